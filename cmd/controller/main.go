@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"nas-controller/internal/api"
 	"nas-controller/internal/database"
@@ -15,6 +17,9 @@ import (
 func main() {
 	port := flag.String("port", "13000", "Port to run the controller on")
 	dataDir := flag.String("data", "/data", "Data directory for repos, db, logs")
+	skipSetup := flag.Bool("skip-setup", false, "Generate a password on first run instead of waiting for the setup wizard")
+	watchdogFile := flag.String("watchdog-file", "", "File the heartbeat is written to for an external watchdog (default <data>/heartbeat.json)")
+	disable := flag.String("disable", "", "Comma-separated feature areas to turn off, on top of the disabledFeatures setting")
 	flag.Parse()
 
 	// Ensure data directory exists
@@ -39,27 +44,64 @@ func main() {
 	// Initialize Docker client
 	dockerClient, err := docker.NewClient()
 	if err != nil {
-		log.Fatalf("Failed to connect to Docker: %v", err)
+		log.Fatalf("Failed to connect to Docker: %v (is /var/run/docker.sock mapped into the container?)", err)
 	}
 	defer dockerClient.Close()
 
+	settingsService, err := services.NewSettingsService(*dataDir)
+	if err != nil {
+		log.Fatalf("Failed to load settings: %v", err)
+	}
+
+	// Docker requests and container stops follow the timeout settings
+	services.ApplyDockerTimeouts(settingsService, dockerClient)
+
 	// Initialize services
 	authService := services.NewAuthService(*dataDir)
-	portAllocator := services.NewPortAllocator(db, dockerClient)
-	gitService := services.NewGitService(*dataDir)
-	buildService := services.NewBuildService(dockerClient, *dataDir)
-	appManager := services.NewAppManager(db, dockerClient, gitService, buildService, portAllocator, *dataDir)
+	portAllocator := services.NewPortAllocator(db, dockerClient, settingsService)
+	gitService := services.NewGitService(*dataDir, settingsService)
+	buildService := services.NewBuildService(db, dockerClient, settingsService, *dataDir)
+	iconService := services.NewIconService(*dataDir)
+	prepullService := services.NewPrepullService(db, dockerClient)
+	healthMonitor := services.NewHealthMonitor(db, dockerClient)
+	exitMonitor := services.NewExitMonitor(db, dockerClient, settingsService)
+	uploadService := services.NewUploadService(*dataDir)
+	appManager := services.NewAppManager(db, dockerClient, gitService, buildService, portAllocator, iconService, prepullService, healthMonitor, uploadService, settingsService, *dataDir)
+	exitMonitor.SetFlows(appManager)
+	diagnostics := services.NewDiagnosticsService(dockerClient, *dataDir)
+	if *watchdogFile == "" {
+		*watchdogFile = filepath.Join(*dataDir, "heartbeat.json")
+	}
+	heartbeat := services.NewHeartbeat(db, buildService, exitMonitor, *watchdogFile)
 
-	// Check/generate password on first run
-	password, isNew, err := authService.EnsurePassword()
-	if err != nil {
-		log.Fatalf("Failed to initialize authentication: %v", err)
+	// Setup problems (read-only mounts, no git, skewed clock) are easier to
+	// spot here than in whatever fails because of them later
+	if report := diagnostics.Run(context.Background()); !report.OK {
+		report.LogFailures()
 	}
-	if isNew {
+
+	// On first run the password is chosen in the setup wizard, unless
+	// setup is skipped and one is generated
+	if !*skipSetup && authService.NeedsSetup() {
 		log.Printf("========================================")
-		log.Printf("FIRST RUN - Generated password: %s", password)
-		log.Printf("Save this password! It's also stored in %s/password.txt", *dataDir)
+		log.Printf("FIRST RUN - Open the web UI on port %s to choose a password", *port)
 		log.Printf("========================================")
+	} else {
+		password, isNew, err := authService.EnsurePassword()
+		if err != nil {
+			log.Fatalf("Failed to initialize authentication: %v", err)
+		}
+		if isNew {
+			log.Printf("========================================")
+			log.Printf("FIRST RUN - Generated password: %s", password)
+			log.Printf("Save this password! It's also stored in %s/password.txt", *dataDir)
+			log.Printf("========================================")
+		}
+	}
+
+	// Fail builds that were running when the controller last died
+	if err := appManager.RecoverInterruptedBuilds(); err != nil {
+		log.Printf("Warning: Failed to recover interrupted builds: %v", err)
 	}
 
 	// Reconcile app states with Docker on startup
@@ -67,8 +109,45 @@ func main() {
 		log.Printf("Warning: Failed to reconcile app states: %v", err)
 	}
 
+	// Follow container health transitions
+	go healthMonitor.Run(context.Background())
+
+	// Record OOM kills and crashes, and catch restart policies giving up
+	go exitMonitor.Run(context.Background())
+
+	// Repair apps stuck in building/starting/updating with nothing behind it
+	go appManager.RunStateWatchdog(context.Background())
+
+	// Record per-app CPU and memory history
+	go appManager.RunMetricsSampler(context.Background())
+
+	// Remove icons left behind by deleted apps
+	go appManager.RunIconSweep(context.Background())
+
+	// Remove previous images kept past their retention
+	go appManager.RunPreviousImageSweep(context.Background())
+
+	// Check the apps' remotes for new commits
+	go appManager.RunUpdateChecks(context.Background())
+
+	// Check the event watcher, build worker and database, and tell an
+	// external watchdog the controller is alive
+	go heartbeat.Run(context.Background())
+
+	// Feature areas are fixed for the life of the process
+	var disabled []string
+	for _, name := range strings.Split(*disable, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			disabled = append(disabled, name)
+		}
+	}
+	features, err := services.NewFeatureFlags(append(disabled, settingsService.Get().DisabledFeatures...))
+	if err != nil {
+		log.Fatalf("Invalid disabled features: %v", err)
+	}
+
 	// Start API server
-	router := api.NewRouter(db, dockerClient, authService, appManager, buildService, portAllocator, *dataDir)
+	router := api.NewRouter(db, dockerClient, authService, appManager, gitService, buildService, portAllocator, settingsService, diagnostics, exitMonitor, heartbeat, features, *dataDir)
 
 	log.Printf("NAS Controller starting on port %s", *port)
 	log.Printf("Data directory: %s", *dataDir)
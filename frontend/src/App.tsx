@@ -3,12 +3,20 @@ import { useAuth } from './hooks/useAuth';
 import Login from './pages/Login';
 import Dashboard from './pages/Dashboard';
 import Settings from './pages/Settings';
+import AppPage from './pages/AppPage';
 
 type Page = 'dashboard' | 'settings';
 
+// appRoute returns the app ID of a /apps/:id link, or null.
+function appRoute(): string | null {
+  const match = window.location.pathname.match(/^\/apps\/([^/]+)\/?$/);
+  return match ? decodeURIComponent(match[1]) : null;
+}
+
 export default function App() {
   const { isAuthenticated, isLoading, login, logout } = useAuth();
   const [currentPage, setCurrentPage] = useState<Page>('dashboard');
+  const [linkedAppId, setLinkedAppId] = useState(appRoute);
   const [isDark, setIsDark] = useState(() => {
     if (typeof window !== 'undefined') {
       return window.matchMedia('(prefers-color-scheme: dark)').matches;
@@ -39,6 +47,18 @@ export default function App() {
     return <Login onLogin={login} />;
   }
 
+  if (linkedAppId) {
+    return (
+      <AppPage
+        appId={linkedAppId}
+        onBack={() => {
+          window.history.pushState(null, '', '/');
+          setLinkedAppId(null);
+        }}
+      />
+    );
+  }
+
   return (
     <div className="min-h-screen">
       {currentPage === 'dashboard' && (
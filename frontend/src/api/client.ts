@@ -1,5 +1,9 @@
 const API_BASE = '/api/v1';
 
+// Per-session CSRF token, issued by login and auth/check. Required by the
+// server on every cookie-authenticated non-GET request.
+let csrfToken = '';
+
 async function fetchAPI<T>(
   endpoint: string,
   options: RequestInit = {}
@@ -8,6 +12,7 @@ async function fetchAPI<T>(
     ...options,
     headers: {
       'Content-Type': 'application/json',
+      ...(csrfToken ? { 'X-CSRF-Token': csrfToken } : {}),
       ...options.headers,
     },
     credentials: 'include',
@@ -20,6 +25,16 @@ async function fetchAPI<T>(
 
   if (!response.ok) {
     const error = await response.json().catch(() => ({ error: 'Unknown error' }));
+    // Dangerous actions ask for the password again; retry once with it.
+    if (response.status === 428 && error.code === 'confirmation_required') {
+      const password = window.prompt('Enter your password to confirm this action');
+      if (password) {
+        return fetchAPI<T>(endpoint, {
+          ...options,
+          headers: { ...options.headers, 'X-Confirm': password },
+        });
+      }
+    }
     throw new Error(error.error || 'Request failed');
   }
 
@@ -28,16 +43,34 @@ async function fetchAPI<T>(
 
 export const api = {
   // Auth
-  login: (password: string) =>
-    fetchAPI<{ token: string }>('/auth/login', {
+  login: async (password: string) => {
+    const result = await fetchAPI<{ token: string; csrfToken: string }>('/auth/login', {
       method: 'POST',
       body: JSON.stringify({ password }),
-    }),
+    });
+    csrfToken = result.csrfToken;
+    return result;
+  },
 
   logout: () => fetchAPI('/auth/logout', { method: 'POST' }),
 
-  checkAuth: () =>
-    fetchAPI<{ authenticated: boolean }>('/auth/check'),
+  checkAuth: async () => {
+    const result = await fetchAPI<{ authenticated: boolean; csrfToken?: string }>('/auth/check');
+    csrfToken = result.csrfToken ?? '';
+    return result;
+  },
+
+  // First-run wizard; both reject (410) once setup is done
+  getSetupStatus: () => fetchAPI<SetupStatus>('/setup/status'),
+
+  completeSetup: async (setup: { password: string; portRangeStart?: number; portRangeEnd?: number }) => {
+    const result = await fetchAPI<{ token: string; csrfToken: string }>('/setup', {
+      method: 'POST',
+      body: JSON.stringify(setup),
+    });
+    csrfToken = result.csrfToken;
+    return result;
+  },
 
   updatePassword: (currentPassword: string, newPassword: string) =>
     fetchAPI('/auth/password', {
@@ -48,10 +81,15 @@ export const api = {
   // Apps
   getApps: () => fetchAPI<App[]>('/apps'),
 
-  getApp: (id: string) => fetchAPI<{ app: App; uptime?: string }>(`/apps/${id}`),
+  getAppSummaries: () => fetchAPI<AppSummary[]>('/apps?view=summary'),
+
+  getApp: (id: string) =>
+    fetchAPI<{ app: App; uptime?: string; oomKills24h: number; resources?: ContainerResources }>(`/apps/${id}`),
 
-  cloneRepo: (repoUrl: string, branch: string) =>
-    fetchAPI<CloneResult>('/apps/clone', {
+  // A 409 carries the appId whose checkout the clone would replace; pass
+  // reclone to replace it anyway.
+  cloneRepo: (repoUrl: string, branch: string, reclone = false) =>
+    fetchAPI<CloneResult>(`/apps/clone${reclone ? '?reclone=true' : ''}`, {
       method: 'POST',
       body: JSON.stringify({ repoUrl, branch }),
     }),
@@ -68,11 +106,62 @@ export const api = {
       body: JSON.stringify(config),
     }),
 
-  deleteApp: (id: string) =>
-    fetchAPI(`/apps/${id}`, { method: 'DELETE' }),
+  // Without a plan ID this only previews the delete.
+  previewDelete: (id: string) =>
+    fetchAPI<{ preview: true; plan: DeletePlan }>(`/apps/${id}`, { method: 'DELETE' }),
+
+  deleteApp: (id: string, planId: string) =>
+    fetchAPI<{ message: string; plan: DeletePlan }>(`/apps/${id}?plan=${encodeURIComponent(planId)}`, { method: 'DELETE' }),
+
+  // With preview, returns the merged env without saving it.
+  importEnv: (id: string, dotenv: string, options: { preview?: boolean; replace?: boolean } = {}) => {
+    const params = new URLSearchParams();
+    if (options.preview) params.set('preview', 'true');
+    if (options.replace) params.set('mode', 'replace');
+    return fetchAPI<App | EnvImportPreview>(`/apps/${id}/env/import?${params}`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'text/plain' },
+      body: dotenv,
+    });
+  },
+
+  // A plain link downloads it; the session cookie authenticates the GET.
+  envExportUrl: (id: string) => `${API_BASE}/apps/${id}/env/export`,
+
+  buildApp: (id: string, options: BuildOptions = {}) =>
+    fetchAPI<{ message: string; queued: boolean; correlationId: string }>(`/apps/${id}/build`, {
+      method: 'POST',
+      body: JSON.stringify(options),
+    }),
+
+  getBadge: (id: string) => fetchAPI<BadgeSettings>(`/apps/${id}/badge`),
+
+  enableBadge: (id: string) =>
+    fetchAPI<BadgeSettings>(`/apps/${id}/badge`, { method: 'POST' }),
+
+  disableBadge: (id: string) =>
+    fetchAPI<BadgeSettings>(`/apps/${id}/badge`, { method: 'DELETE' }),
 
-  buildApp: (id: string) =>
-    fetchAPI(`/apps/${id}/build`, { method: 'POST' }),
+  getWebhook: (id: string) => fetchAPI<WebhookSettings>(`/apps/${id}/webhook`),
+
+  enableWebhook: (id: string) =>
+    fetchAPI<WebhookSettings>(`/apps/${id}/webhook`, { method: 'POST' }),
+
+  disableWebhook: (id: string) =>
+    fetchAPI<WebhookSettings>(`/apps/${id}/webhook`, { method: 'DELETE' }),
+
+  getBuildSecrets: (id: string) => fetchAPI<BuildSecret[]>(`/apps/${id}/build-secrets`),
+
+  setBuildSecret: (id: string, secretId: string, value: string) =>
+    fetchAPI<{ id: string; message: string }>(`/apps/${id}/build-secrets/${secretId}`, {
+      method: 'PUT',
+      body: JSON.stringify({ value }),
+    }),
+
+  deleteBuildSecret: (id: string, secretId: string) =>
+    fetchAPI<{ message: string }>(`/apps/${id}/build-secrets/${secretId}`, { method: 'DELETE' }),
+
+  planStart: (id: string) => fetchAPI<StartPlan>(`/apps/${id}/plan`),
 
   startApp: (id: string) =>
     fetchAPI(`/apps/${id}/start`, { method: 'POST' }),
@@ -83,37 +172,111 @@ export const api = {
   restartApp: (id: string) =>
     fetchAPI(`/apps/${id}/restart`, { method: 'POST' }),
 
-  pullAndRebuild: (id: string) =>
-    fetchAPI(`/apps/${id}/pull`, { method: 'POST' }),
+  // tag is a commit tag (or just the commit); without one, the newest
+  // build older than what runs now
+  rollbackApp: (id: string, tag?: string) =>
+    fetchAPI<{ message: string; app: App }>(`/apps/${id}/rollback`, {
+      method: 'POST',
+      body: JSON.stringify(tag ? { tag } : {}),
+    }),
+
+  bulkAction: (action: 'start' | 'stop' | 'restart', appIds: string[]) =>
+    fetchAPI<BatchReport>('/apps/bulk', {
+      method: 'POST',
+      body: JSON.stringify({ action, appIds }),
+    }),
+
+  getAppMetrics: (id: string, window = '6h') =>
+    fetchAPI<AppMetrics>(`/apps/${id}/metrics?window=${encodeURIComponent(window)}`),
+
+  repairState: (id: string) =>
+    fetchAPI<{ repair: StateRepair | null; app: App }>(`/apps/${id}/repair-state`, { method: 'POST' }),
+
+  pullAndRebuild: (id: string, options: BuildOptions = {}) =>
+    fetchAPI<{ message: string; queued: boolean; correlationId: string }>(`/apps/${id}/pull`, {
+      method: 'POST',
+      body: JSON.stringify(options),
+    }),
 
   checkAppUpdate: (id: string) =>
-    fetchAPI<{ hasUpdate: boolean; localCommit: string; remoteCommit: string }>(
+    fetchAPI<{ hasUpdate: boolean; localCommit: string; remoteCommit: string; localChanges?: string[] }>(
       `/apps/${id}/check-update`
     ),
 
+  listStashes: (id: string) => fetchAPI<{ stashes: Stash[] }>(`/apps/${id}/stashes`),
+
+  dropStash: (id: string, commit: string) =>
+    fetchAPI(`/apps/${id}/stashes/${commit}`, { method: 'DELETE' }),
+
   getLogs: (id: string, lines = 100) =>
     fetchAPI<{ logs: string }>(`/apps/${id}/logs?lines=${lines}`),
 
   getBuildLogs: (id: string) =>
     fetchAPI<{ logs: string }>(`/apps/${id}/build-logs`),
 
+  // Build history, newest first; pass the last build's id as before for
+  // the next page
+  getBuilds: (id: string, page: { before?: number; limit?: number } = {}) => {
+    const params = new URLSearchParams();
+    if (page.before) params.set('before', String(page.before));
+    if (page.limit) params.set('limit', String(page.limit));
+    const query = params.toString();
+    return fetchAPI<Build[]>(`/apps/${id}/builds${query ? `?${query}` : ''}`);
+  },
+
+  getBuildRecordLogs: (id: string, buildId: number) =>
+    fetchAPI<{ logs: string }>(`/apps/${id}/builds/${buildId}/logs`),
+
+  getImages: (id: string) =>
+    fetchAPI<AppImage[]>(`/apps/${id}/images`),
+
   clearLogs: (id: string) =>
     fetchAPI(`/apps/${id}/logs`, { method: 'DELETE' }),
 
   // System
   getSystemInfo: () => fetchAPI<SystemInfo>('/system/info'),
 
+  getGlobalEnv: () => fetchAPI<GlobalEnv>('/system/global-env'),
+
+  updateGlobalEnv: (env: Record<string, string>, timezone?: string) =>
+    fetchAPI<GlobalEnv>('/system/global-env', {
+      method: 'PUT',
+      body: JSON.stringify({ env, timezone }),
+    }),
+
   getStorage: () => fetchAPI<StorageInfo>('/system/storage'),
 
+  getBuildQueue: () => fetchAPI<BuildQueue>('/builds/queue'),
+
+  dequeueBuild: (appId: string) => fetchAPI(`/builds/queue/${appId}`, { method: 'DELETE' }),
+
   getPorts: () =>
-    fetchAPI<{ usedPorts: number[]; range: { start: number; end: number } }>(
+    fetchAPI<{ usedPorts: number[]; bindings: PortBinding[]; ipv6: boolean; range: { start: number; end: number } }>(
       '/system/ports'
     ),
 
-  pruneImages: () => fetchAPI<{ spaceReclaimed: number }>('/system/prune', { method: 'POST' }),
+  pruneImages: () =>
+    fetchAPI<{ spaceReclaimed: number; previousImagesRemoved: number }>('/system/prune', { method: 'POST' }),
+
+  getBuildCache: () => fetchAPI<BuildCacheUsage>('/system/build-cache'),
+
+  // until is a Go duration such as '24h'; cache used within it is kept
+  pruneBuildCache: (until?: string) =>
+    fetchAPI<{ spaceReclaimed: number; entriesDeleted: number }>(
+      `/system/prune-build-cache${until ? `?until=${encodeURIComponent(until)}` : ''}`,
+      { method: 'POST' }
+    ),
+
+  clearBuildCache: (id: string) =>
+    fetchAPI<{ spaceReclaimed: number; entriesDeleted: number; coarse: boolean; warning?: string }>(
+      `/apps/${id}/build-cache/clear`,
+      { method: 'POST' }
+    ),
 
   clearAllLogs: () => fetchAPI('/system/logs', { method: 'DELETE' }),
 
+  checkUpdates: () => fetchAPI<UpdateSweep>('/system/check-updates', { method: 'POST' }),
+
   checkSelfUpdate: (repoUrl?: string, branch?: string) =>
     fetchAPI<{ hasUpdate: boolean; localCommit: string; remoteCommit: string }>(
       '/system/check-update',
@@ -130,36 +293,140 @@ export const api = {
     }),
 };
 
+export interface Healthcheck {
+  command: string;
+  interval?: string;
+  retries?: number;
+}
+
 export interface App {
   id: string;
   name: string;
   slug: string;
   description: string;
+  sourceType: 'git' | 'upload';
   repoUrl: string;
   branch: string;
   lastCommit: string;
   dockerfilePath: string;
+  // Dockerfile stage built, as --target; empty builds the last stage.
+  buildTarget: string;
+  // os/arch built and run for, such as linux/arm64; empty is the host's.
+  platform: string;
   imageName: string;
   containerName: string;
   internalPort: number;
   externalPort: number;
+  replicas: number;
+  restartPolicy: 'no' | 'always' | 'unless-stopped' | 'on-failure';
+  maxRetries: number;
+  networkMode: 'bridge' | 'host';
+  network?: string;
+  ipAddress?: string;
+  gpu?: 'nvidia' | 'intel';
+  gpuCapabilities?: string;
+  gpuRuntime?: boolean;
+  replicaPorts: number[];
   env: Record<string, string>;
   volumes: string[];
-  status: 'stopped' | 'running' | 'building' | 'build-failed' | 'starting' | 'error';
+  devices: string[];
+  privileged: boolean;
+  capAdd?: string[];
+  capDrop?: string[];
+  healthcheck?: Healthcheck;
+  labels: Record<string, string>;
+  user: string;
+  // null keeps the image's ENTRYPOINT/CMD; [] clears it.
+  entrypoint: string[] | null;
+  command: string[] | null;
+  extraHosts: string[];
+  dns: string[];
+  // '' and 0 use the logMaxSize/logMaxFiles settings.
+  logMaxSize: string;
+  logMaxFiles: number;
+  // Pass the controller's proxy settings to the container
+  useProxy: boolean;
+  // containerName is the name in use; customContainerName replaces the
+  // prefix + slug default when set.
+  customContainerName: string;
+  hostname: string;
+  memoryLimit: string;
+  memorySwap: string;
+  memoryReservation: string;
+  shmSize: string;
+  ulimits: Ulimit[];
+  // Namespaced kernel parameters, e.g. { 'net.core.somaxconn': '1024' }.
+  sysctls: Record<string, string>;
+  bindAddress: string;
+  // 'on' or 'off' overrides the publishIPv6 setting; '' follows it.
+  publishIPv6: '' | 'on' | 'off';
+  // Recreate the container when a build of the running app succeeds.
+  autoRecreate: boolean;
+  // Keep the app off the shared network where other apps reach it by slug.
+  networkIsolated: boolean;
+  // Stash modified files in the checkout on pull instead of discarding them.
+  preserveLocalChanges: boolean;
+  // Leave the app stopped when a pull's rebuild fails, instead of
+  // restarting it on the previous image.
+  failStop: boolean;
+  // Pass env values as written instead of interpolating ${VAR} references.
+  literalEnv: boolean;
+  // Set for apps run from a compose file: the file, the service the app's
+  // port and settings belong to, and the other services' containers.
+  composeFile?: string;
+  composeService?: string;
+  composeContainers?: Record<string, string>;
+  status: 'stopped' | 'running' | 'building' | 'build-failed' | 'starting' | 'error' | 'updating' | 'deploying';
+  subStatus?: string;
+  health: 'none' | 'starting' | 'healthy' | 'unhealthy';
   lastBuild: string | null;
   lastBuildDuration: string;
   lastBuildSuccess: boolean;
+  lastBuildLogTruncated?: boolean;
+  lastError?: string;
+  rebuildRequired?: boolean;
+  // The container runs an older image than the last build.
+  restartRequired?: boolean;
+  // The commit tag the app runs instead of its latest build
+  rolledBackTo?: string;
+  // A pull's rebuild failed and the app was restarted on the image from
+  // before; failedBuildId is that build, if it was recorded.
+  runningOutdated?: boolean;
+  failedBuildId?: number;
+  // The last update check of the app's remote; a pull clears
+  // updateAvailable.
+  updateAvailable: boolean;
+  remoteCommit?: string;
+  lastChecked?: string;
+  // Why the last update check failed; the result before it is kept
+  lastCheckError?: string;
   imageSize: number;
   createdAt: string;
   updatedAt: string;
 }
 
+export interface DeletePlan {
+  id: string;
+  appId: string;
+  appName: string;
+  containerState: string;
+  steps: { action: string; target: string; bytes: number; result?: 'done' | 'failed'; error?: string }[];
+  keptVolumes: { name: string; bytes: number }[];
+  totalBytes: number;
+  expiresAt: string;
+  executed: boolean;
+}
+
 export interface CloneResult {
   slug: string;
   name: string;
   description: string;
   hasDockerfile: boolean;
   dockerfilePath: string;
+  composeFile?: string;
+  composeServices?: string[];
+  // The Dockerfile's named stages, for picking a buildTarget.
+  buildTargets?: string[];
   manifest: {
     name?: string;
     description?: string;
@@ -168,12 +435,16 @@ export interface CloneResult {
     volumes?: string[];
   } | null;
   suggestedPort: number;
+  defaultVolumes: string[];
+  volumeWarnings?: string[];
 }
 
 export interface AppConfig {
   name?: string;
   dockerfilePath?: string;
   buildContext?: string;
+  buildTarget?: string;
+  platform?: string;
   internalPort?: number;
   externalPort?: number;
   env?: Record<string, string>;
@@ -181,6 +452,82 @@ export interface AppConfig {
   volumes?: string[];
 }
 
+export interface GlobalEnv {
+  env: Record<string, string>;
+  // '' follows the controller's own timezone, 'off' passes no TZ.
+  timezone: string;
+  detectedTz: string;
+  effectiveTz: string;
+  staleEnvApps: { id: string; name: string }[];
+}
+
+export interface CooldownSkip {
+  at: string;
+  until: string;
+}
+
+export interface Build {
+  id: number;
+  appId: string;
+  commit: string;
+  success: boolean;
+  correlationId?: string;
+  baseImages: Record<string, string>;
+  buildArgsHash: string;
+  dockerVersion: string;
+  builder: string;
+  startedAt: string;
+  finishedAt?: string;
+  duration?: string;
+  initiator?: 'manual' | 'create' | 'recovery' | 'webhook' | 'schedule' | 'auto-update';
+  triggeredBy?: string;
+  // The commit tag a successful build was also given, e.g. myapp:1a2b3c4d
+  imageTag?: string;
+  inputsHash?: string;
+  // A requested build that didn't run because nothing changed since the
+  // last successful one, e.g. "no changes since build #12"
+  skipped?: boolean;
+  skipReason?: string;
+  // success is false for a skipped build too; result tells them apart
+  result: 'running' | 'success' | 'failed' | 'skipped';
+}
+
+// latest, previous, then commit tags newest first. imageId is empty for a
+// tag Docker no longer has.
+export interface AppImage {
+  tag: string;
+  commit?: string;
+  buildId?: number;
+  taggedAt?: string;
+  imageId: string;
+  size: number;
+  created?: string;
+  current: boolean;
+}
+
+export interface BuildOptions {
+  noCache?: boolean;
+  pullBaseImage?: boolean;
+  // Build even when the commit and build inputs are unchanged
+  force?: boolean;
+}
+
+// What the log and build WebSockets send with ?format=json; without it
+// they send plain text lines.
+export type StreamMessage =
+  | { type: 'log'; seq: number; ts: string; data: string }
+  | { type: 'progress'; seq: number; ts: string; data: { percent: number } }
+  | { type: 'error'; seq: number; ts: string; data: { message: string; category?: string; hint?: string } }
+  | { type: 'done'; seq: number; ts: string; data: { success: boolean; skipped?: boolean } | null };
+
+export interface BuildQueue {
+  building?: string;
+  waiting: { appId: string; position: number; requestedAt: string; cooldownUntil?: string }[];
+  // A Go duration, e.g. "0s" or "5m0s".
+  cooldown: string;
+  cooldownSkips: Record<string, CooldownSkip>;
+}
+
 export interface SystemInfo {
   version: string;
   totalApps: number;
@@ -191,6 +538,124 @@ export interface SystemInfo {
     images: number;
     serverVersion: string;
   };
+  exitEvents: {
+    received: number;
+    coalesced: number;
+    dropped: number;
+    suppressed: number;
+    handled: number;
+    pending: number;
+    queued: number;
+  };
+  // The daemon's native os/arch, such as linux/amd64; empty if unknown.
+  platform: string;
+  heartbeat: HeartbeatStatus;
+  // Enabled feature areas (apps, ws, system, self-update, guests, shares,
+  // badges, webhooks); a disabled area's routes answer 404.
+  features: string[];
+}
+
+export interface SubsystemHealth {
+  ok: boolean;
+  misses: number;
+  error?: string;
+  lastOk?: string;
+  recoveries: number;
+}
+
+// Written to the watchdog file every 30s. Subsystems are events, builds
+// and database.
+export interface HeartbeatStatus {
+  at: string;
+  pid: number;
+  ok: boolean;
+  subsystems: Record<string, SubsystemHealth>;
+}
+
+export interface EnvImportPreview {
+  preview: true;
+  env: Record<string, string>;
+  added: string[];
+  changed: string[];
+  removed: string[];
+}
+
+export interface ContainerResources {
+  memory: number;
+  memorySwap: number;
+  memoryReservation: number;
+  shmSize: number;
+  ulimits?: Ulimit[];
+}
+
+// -1 is unlimited.
+export interface Ulimit {
+  name: string;
+  soft: number;
+  hard: number;
+}
+
+export interface UpdateSweep {
+  checked: number;
+  available: number;
+  failed: number;
+  // Uploaded and local-path apps, which have no remote
+  skipped: number;
+  duration: string;
+  apps: {
+    appId: string;
+    name: string;
+    updateAvailable: boolean;
+    localCommit?: string;
+    remoteCommit?: string;
+    error?: string;
+  }[];
+}
+
+export interface AppSummary {
+  id: string;
+  name: string;
+  icon: string;
+  status: App['status'];
+  ports: number[];
+  uptime?: string;
+  updateAvailable: boolean;
+}
+
+export interface PortBinding {
+  port: number;
+  bindAddress: string;
+  appId: string;
+  appName: string;
+  replica: number;
+  // IP versions the port is published on; '' for host-mode apps.
+  families: 'v4' | 'v6' | 'both' | '';
+}
+
+export interface MetricPoint {
+  t: string;
+  cpu: number;
+  mem: number;
+}
+
+export interface AppMetrics {
+  window: string;
+  step: string;
+  points: MetricPoint[];
+}
+
+export interface Stash {
+  ref: string;
+  commit: string;
+  message: string;
+  createdAt: string;
+}
+
+export interface StateRepair {
+  from: string;
+  fromSubStatus?: string;
+  to: string;
+  reason: string;
 }
 
 export interface StorageInfo {
@@ -198,5 +663,130 @@ export interface StorageInfo {
   repositories: number;
   logs: number;
   images: number;
+  // Space only the apps' previous images (kept as cache sources) use
+  previousImages: number;
+  buildCache: number;
+  // The part of buildCache not in use, which a build cache prune frees
+  buildCacheReclaimable: number;
+  containerLogs: {
+    total: number;
+    // Largest first; bytes is -1 when the host's log files aren't visible.
+    containers: {
+      appId: string;
+      appName: string;
+      container: string;
+      replica: number;
+      bytes: number;
+      maxSize: string;
+      maxFiles: string;
+    }[];
+  };
+  total: number;
+}
+
+export interface BuildCacheUsage {
+  // Largest first; appId and buildId are set when the entry was created
+  // during one of the controller's builds
+  entries: {
+    id: string;
+    type: string;
+    description: string;
+    size: number;
+    inUse: boolean;
+    shared: boolean;
+    createdAt: string;
+    lastUsedAt?: string;
+    usageCount: number;
+    appId?: string;
+    buildId?: number;
+  }[];
   total: number;
+  reclaimable: number;
+  apps: Record<string, number>;
+}
+
+// ContainerSpec is what a container is created from; only the commonly
+// shown fields are typed
+export interface ContainerSpec {
+  name: string;
+  image: string;
+  internalPort: number;
+  externalPort: number;
+  bindAddress?: string;
+  publishIPv6?: boolean;
+  env: Record<string, string>;
+  restartPolicy: string;
+  volumes: string[];
+  networkMode?: string;
+  network?: string;
+  ipAddress?: string;
+  networks?: { name: string; aliases?: string[] }[];
+  labels: Record<string, string>;
+  [key: string]: unknown;
+}
+
+export interface BatchResult {
+  appId: string;
+  ok: boolean;
+  error?: string;
+  timedOut?: boolean;
+  forced?: boolean;
+  durationMs: number;
+}
+
+export interface BatchReport {
+  action: string;
+  results: BatchResult[];
+  succeeded: number;
+  failed: number;
+  forced: number;
+  elapsedMs: number;
+  sequentialMs: number;
+  concurrency: number;
+}
+
+// Values are write-only and never returned.
+export interface BuildSecret {
+  id: string;
+  updatedAt: string;
+}
+
+// url is a path; prefix the controller's origin to embed it.
+export interface BadgeSettings {
+  enabled: boolean;
+  token?: string;
+  url?: string;
+}
+
+export interface WebhookSettings {
+  enabled: boolean;
+  createdAt?: string;
+  path?: string;
+  // Only with an external base URL
+  url?: string;
+  // Only in the response that generated it
+  secret?: string;
+}
+
+export interface StartPlan {
+  spec: ContainerSpec;
+  services?: Record<string, ContainerSpec>;
+  replicas: number;
+  issues: { check: string; severity: 'error' | 'warning'; message: string }[];
+  ready: boolean;
+  running: boolean;
+  restartRequired: boolean;
+  changes: { field: string; from: unknown; to: unknown }[];
+}
+
+export interface SetupStatus {
+  required: boolean;
+  minPasswordLength: number;
+  portRangeStart: number;
+  portRangeEnd: number;
+  diagnostics: {
+    ranAt: string;
+    ok: boolean;
+    checks: { name: string; ok: boolean; detail: string; hint?: string }[];
+  };
 }
@@ -10,8 +10,9 @@ import {
   ExternalLink,
   Box,
   GitBranch,
+  ShieldAlert,
 } from 'lucide-react';
-import { api, App } from '../api/client';
+import { api, App, DeletePlan } from '../api/client';
 
 interface AppCardProps {
   app: App;
@@ -34,6 +35,8 @@ function getStatusColor(status: App['status']): string {
       return 'text-emerald-600 dark:text-emerald-400';
     case 'building':
     case 'starting':
+    case 'updating':
+    case 'deploying':
       return 'text-amber-600 dark:text-amber-400';
     case 'build-failed':
     case 'error':
@@ -49,6 +52,8 @@ function getStatusDot(status: App['status']): string {
       return 'bg-emerald-500';
     case 'building':
     case 'starting':
+    case 'updating':
+    case 'deploying':
       return 'bg-amber-500';
     case 'build-failed':
     case 'error':
@@ -77,10 +82,20 @@ export default function AppCard({ app, onRefresh, onShowLogs, onShowConfig }: Ap
   };
 
   const handleDelete = async () => {
-    if (!confirm(`Delete ${app.name}? This will stop the container and remove all data.`)) {
+    let plan: DeletePlan;
+    try {
+      ({ plan } = await api.previewDelete(app.id));
+    } catch (err) {
+      alert(err instanceof Error ? err.message : 'Action failed');
       return;
     }
-    await handleAction('delete', () => api.deleteApp(app.id));
+    const kept = plan.keptVolumes.length > 0
+      ? ` Named volumes are kept: ${plan.keptVolumes.map((v) => v.name).join(', ')}.`
+      : '';
+    if (!confirm(`Delete ${app.name}? This will stop the container and free ${formatBytes(plan.totalBytes)} (image, source, logs).${kept}`)) {
+      return;
+    }
+    await handleAction('delete', () => api.deleteApp(app.id, plan.id));
   };
 
   const handleUpdate = async () => {
@@ -124,6 +139,23 @@ export default function AppCard({ app, onRefresh, onShowLogs, onShowConfig }: Ap
                 <span className={`w-1.5 h-1.5 rounded-full ${getStatusDot(app.status)}`} />
                 {app.status}
               </span>
+              {isRunning && (app.health === 'unhealthy' || app.health === 'starting') && (
+                <span
+                  className={`text-xs font-medium ${app.health === 'unhealthy' ? 'text-red-600 dark:text-red-400' : 'text-yellow-600 dark:text-yellow-400'}`}
+                  title="Container healthcheck"
+                >
+                  {app.health}
+                </span>
+              )}
+              {(app.privileged || (app.capAdd?.length ?? 0) > 0) && (
+                <span
+                  className="flex items-center gap-1 text-xs font-medium text-red-600 dark:text-red-400"
+                  title={app.privileged ? 'Privileged container' : `Extra capabilities: ${app.capAdd?.join(', ')}`}
+                >
+                  <ShieldAlert className="w-3 h-3" />
+                  {app.privileged ? 'privileged' : 'caps'}
+                </span>
+              )}
             </div>
             <div className="flex items-center gap-3 text-sm text-gray-500 dark:text-gray-400 mt-1">
               <span className="truncate flex items-center gap-1">
@@ -0,0 +1,77 @@
+import { useState, useEffect, useCallback } from 'react';
+import { ArrowLeft } from 'lucide-react';
+import { api, App } from '../api/client';
+import AppCard from '../components/AppCard';
+import LogsModal from '../components/LogsModal';
+import ConfigModal from '../components/ConfigModal';
+
+interface AppPageProps {
+  appId: string;
+  onBack: () => void;
+}
+
+// AppPage shows a single app at /apps/:id, the target of deep links and
+// guest links. Guests can only load the apps their code covers.
+export default function AppPage({ appId, onBack }: AppPageProps) {
+  const [app, setApp] = useState<App | null>(null);
+  const [error, setError] = useState('');
+  const [showLogs, setShowLogs] = useState(false);
+  const [showConfig, setShowConfig] = useState(false);
+
+  const fetchApp = useCallback(async () => {
+    try {
+      const data = await api.getApp(appId);
+      setApp(data.app);
+      setError('');
+    } catch (e) {
+      setError(e instanceof Error ? e.message : 'Failed to load app');
+    }
+  }, [appId]);
+
+  useEffect(() => {
+    fetchApp();
+    const interval = setInterval(fetchApp, 5000);
+    return () => clearInterval(interval);
+  }, [fetchApp]);
+
+  return (
+    <div className="min-h-screen">
+      <header className="bg-white dark:bg-gray-800 border-b border-gray-200 dark:border-gray-700 sticky top-0 z-10">
+        <div className="max-w-6xl mx-auto px-4 py-4 flex items-center gap-3">
+          <button
+            onClick={onBack}
+            className="p-2 hover:bg-gray-100 dark:hover:bg-gray-700 rounded-lg transition-colors text-gray-500 dark:text-gray-400"
+          >
+            <ArrowLeft className="w-5 h-5" />
+          </button>
+          <h1 className="text-xl font-bold">{app ? app.name : 'NAS Controller'}</h1>
+        </div>
+      </header>
+
+      <main className="max-w-xl mx-auto px-4 py-6">
+        {error && <p className="text-sm text-red-600 dark:text-red-400 mb-4">{error}</p>}
+        {app && (
+          <AppCard
+            app={app}
+            onRefresh={fetchApp}
+            onShowLogs={() => setShowLogs(true)}
+            onShowConfig={() => setShowConfig(true)}
+          />
+        )}
+      </main>
+
+      {showLogs && <LogsModal appId={appId} onClose={() => setShowLogs(false)} />}
+
+      {showConfig && (
+        <ConfigModal
+          appId={appId}
+          onClose={() => setShowConfig(false)}
+          onSave={() => {
+            setShowConfig(false);
+            fetchApp();
+          }}
+        />
+      )}
+    </div>
+  );
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/api/handlers"
+	"nas-controller/internal/services"
+)
+
+// ConfirmHeader carries the password on requests for actions that need
+// re-confirmation.
+const ConfirmHeader = "X-Confirm"
+
+// Error codes returned with 428 and 403 so the UI knows to prompt.
+const (
+	codeConfirmationRequired = "confirmation_required"
+	codeConfirmationFailed   = "confirmation_failed"
+)
+
+type ConfirmMiddleware struct {
+	authService     *services.AuthService
+	settingsService *services.SettingsService
+}
+
+func NewConfirmMiddleware(authService *services.AuthService, settingsService *services.SettingsService) *ConfirmMiddleware {
+	return &ConfirmMiddleware{authService: authService, settingsService: settingsService}
+}
+
+// Require makes the route need a fresh password in ConfirmHeader when
+// action is listed in the confirmActions setting. It runs after
+// Authenticate.
+func (m *ConfirmMiddleware) Require(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.settingsService.Get().RequiresConfirmation(action) {
+			c.Next()
+			return
+		}
+
+		password := c.GetHeader(ConfirmHeader)
+		if password == "" {
+			c.AbortWithStatusJSON(http.StatusPreconditionRequired, gin.H{
+				"error":  "this action must be confirmed with your password",
+				"code":   codeConfirmationRequired,
+				"action": action,
+			})
+			return
+		}
+
+		if !m.authService.ConfirmAction(c.Request.Context(), action, c.GetString(handlers.ActorKey), password) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":  "confirmation failed",
+				"code":   codeConfirmationFailed,
+				"action": action,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
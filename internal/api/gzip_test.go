@@ -0,0 +1,62 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGzip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := strings.Repeat(`{"name":"demo","status":"running"},`, 100)
+	router := gin.New()
+	router.Use(Gzip())
+	router.GET("/json", func(c *gin.Context) { c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(body)) })
+	router.GET("/png", func(c *gin.Context) { c.Data(http.StatusOK, "image/png", []byte(body)) })
+	router.GET("/empty", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"json", "/json", "gzip, deflate, br", true},
+		{"client without gzip", "/json", "", false},
+		{"already compressed type", "/png", "gzip", false},
+		{"no content", "/empty", "gzip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if got := w.Header().Get("Content-Encoding") == "gzip"; got != tt.wantGzip {
+				t.Fatalf("gzipped = %v, want %v", got, tt.wantGzip)
+			}
+			if !tt.wantGzip {
+				return
+			}
+			if w.Header().Get("Content-Length") != "" {
+				t.Error("Content-Length of the uncompressed body kept")
+			}
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(gz)
+			if err != nil || string(data) != body {
+				t.Errorf("body = %q (%v)", data, err)
+			}
+		})
+	}
+}
@@ -1,19 +1,21 @@
 package handlers
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"nas-controller/internal/docker"
+	"nas-controller/internal/logs"
 	"nas-controller/internal/models"
 	"nas-controller/internal/services"
 )
@@ -22,6 +24,7 @@ type AppHandler struct {
 	appManager   *services.AppManager
 	buildService *services.BuildService
 	dockerClient *docker.Client
+	streams      *services.StreamLimiter
 	dataDir      string
 }
 
@@ -29,41 +32,92 @@ func NewAppHandler(
 	appManager *services.AppManager,
 	buildService *services.BuildService,
 	dockerClient *docker.Client,
+	streams *services.StreamLimiter,
 	dataDir string,
 ) *AppHandler {
 	return &AppHandler{
 		appManager:   appManager,
 		buildService: buildService,
 		dockerClient: dockerClient,
+		streams:      streams,
 		dataDir:      dataDir,
 	}
 }
 
+// Log stream liveness: clients must answer a ping within logStreamPongWait
+// and accept each write within logStreamWriteWait. Variables so tests can
+// shorten them.
+var (
+	logStreamPingInterval = 30 * time.Second
+	logStreamPongWait     = 60 * time.Second
+	logStreamWriteWait    = 10 * time.Second
+)
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
+// ListApps returns every app. With ?view=summary each app is cut down to an
+// AppSummary, for dashboards that poll the list; env, build args and the
+// rest of the config are left out.
 func (h *AppHandler) ListApps(c *gin.Context) {
+	view := c.DefaultQuery("view", "full")
+	if view != "full" && view != "summary" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "view must be full or summary"})
+		return
+	}
+
 	apps, err := h.appManager.GetAllApps()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Enrich with uptime info
-	ctx := context.Background()
+	// Enrich with uptime info. Past the deadline the remaining apps are
+	// listed without it rather than holding the list up.
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	uptimes := make(map[string]string, len(apps))
 	for _, app := range apps {
 		if app.Status == models.StatusRunning && app.ContainerID != "" {
 			uptime, _ := h.appManager.GetContainerUptime(ctx, app.ID)
+			uptimes[app.ID] = uptime
 			app.LastBuildDuration = uptime // Reuse field for uptime in list view
+			h.appManager.RefreshHealth(ctx, app)
 		}
 	}
 
+	if view == "summary" {
+		summaries := make([]models.AppSummary, 0, len(apps))
+		for _, app := range apps {
+			summaries = append(summaries, summarizeApp(app, uptimes[app.ID]))
+		}
+		c.JSON(http.StatusOK, summaries)
+		return
+	}
+
 	c.JSON(http.StatusOK, apps)
 }
 
+func summarizeApp(app *models.App, uptime string) models.AppSummary {
+	ports := []int{}
+	if app.ExternalPort > 0 {
+		ports = append(ports, app.ExternalPort)
+	}
+	ports = append(ports, app.ReplicaPorts...)
+	return models.AppSummary{
+		ID:              app.ID,
+		Name:            app.Name,
+		Icon:            app.Icon,
+		Status:          app.Status,
+		Ports:           ports,
+		Uptime:          uptime,
+		UpdateAvailable: app.UpdateAvailable,
+	}
+}
+
 func (h *AppHandler) GetApp(c *gin.Context) {
 	id := c.Param("id")
 	app, err := h.appManager.GetApp(id)
@@ -72,18 +126,98 @@ func (h *AppHandler) GetApp(c *gin.Context) {
 		return
 	}
 
+	// Last time the controller reached the repo/registry, and how it went
+	contacts, _ := h.appManager.GetContacts(app.ID)
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	resp := gin.H{"app": app, "contacts": contacts}
+
+	// Chronic OOM kills mean the app needs a bigger memory limit
+	resp["oomKills24h"] = h.appManager.RecentOOMKills(app.ID)
+
 	// Get uptime if running
 	if app.Status == models.StatusRunning && app.ContainerID != "" {
-		uptime, _ := h.appManager.GetContainerUptime(context.Background(), app.ID)
+		uptime, _ := h.appManager.GetContainerUptime(ctx, app.ID)
 		// Return uptime separately
-		c.JSON(http.StatusOK, gin.H{
-			"app":    app,
-			"uptime": uptime,
-		})
+		resp["uptime"] = uptime
+	}
+
+	if app.Replicas > 1 {
+		replicas, _ := h.appManager.GetReplicas(ctx, app.ID)
+		resp["replicas"] = replicas
+	}
+
+	if links := h.appManager.Links(app.ID); links != nil {
+		resp["links"] = links
+	}
+
+	// Memory settings as Docker applied them, in bytes, to check against
+	// the configured ones
+	if resources := h.appManager.ContainerResources(ctx, app); resources != nil {
+		resp["resources"] = resources
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// AppRuntime is what GET /api/v2/apps/:id reports about an app's
+// containers, grouped apart from its configuration.
+type AppRuntime struct {
+	Uptime      *AppUptime             `json:"uptime"`
+	Replicas    []models.ReplicaStatus `json:"replicas,omitempty"`
+	Resources   *docker.ResourceConfig `json:"resources,omitempty"`
+	OOMKills24h int                    `json:"oomKills24h"`
+}
+
+// AppUptime is how long the app's container has been running; v1 only
+// had the display text.
+type AppUptime struct {
+	StartedAt time.Time `json:"startedAt"`
+	Seconds   int64     `json:"seconds"`
+	Display   string    `json:"display"`
+}
+
+// GetAppV2 is GetApp with the runtime fields (uptime, replicas, resources,
+// OOM kills) under "runtime" and uptime as a start time and duration.
+func (h *AppHandler) GetAppV2(c *gin.Context) {
+	id := c.Param("id")
+	app, err := h.appManager.GetApp(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"app": app})
+	contacts, _ := h.appManager.GetContacts(app.ID)
+	runtime := AppRuntime{OOMKills24h: h.appManager.RecentOOMKills(app.ID)}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	if app.Status == models.StatusRunning && app.ContainerID != "" {
+		if startedAt, _ := h.appManager.GetContainerStartedAt(ctx, app.ID); startedAt != nil {
+			uptime := time.Since(*startedAt)
+			runtime.Uptime = &AppUptime{
+				StartedAt: *startedAt,
+				Seconds:   int64(uptime / time.Second),
+				Display:   docker.FormatUptime(uptime),
+			}
+		}
+	}
+
+	if app.Replicas > 1 {
+		runtime.Replicas, _ = h.appManager.GetReplicas(ctx, app.ID)
+	}
+
+	runtime.Resources = h.appManager.ContainerResources(ctx, app)
+
+	resp := gin.H{"app": app, "contacts": contacts, "runtime": runtime}
+	if links := h.appManager.Links(app.ID); links != nil {
+		resp["links"] = links
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 func (h *AppHandler) CloneRepo(c *gin.Context) {
@@ -93,9 +227,9 @@ func (h *AppHandler) CloneRepo(c *gin.Context) {
 		return
 	}
 
-	result, err := h.appManager.CloneAndValidate(req.RepoURL, req.Branch)
+	result, err := h.appManager.CloneAndValidate(c.Request.Context(), req.RepoURL, req.Branch, c.Query("reclone") == "true")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err, http.StatusBadRequest), errorBody(c, err))
 		return
 	}
 
@@ -114,29 +248,28 @@ func (h *AppHandler) CreateApp(c *gin.Context) {
 		return
 	}
 
-	app, err := h.appManager.CreateApp(req.RepoURL, req.Branch, &req.Config)
+	app, err := h.appManager.CreateApp(c.Request.Context(), req.RepoURL, req.Branch, &req.Config)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err, http.StatusBadRequest), errorBody(c, err))
 		return
 	}
 
 	// Auto-trigger build and start in background
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-		defer cancel()
-		if err := h.appManager.BuildApp(ctx, app.ID, nil); err != nil {
-			log.Printf("Auto-build failed for %s: %v", app.Name, err)
-			return
-		}
-		if err := h.appManager.StartApp(ctx, app.ID); err != nil {
-			log.Printf("Auto-start failed for %s: %v", app.Name, err)
-		}
-	}()
+	go h.buildAndStart(detachedContext(c), app)
 
 	c.JSON(http.StatusCreated, app)
 }
 
-func (h *AppHandler) UpdateApp(c *gin.Context) {
+// buildAndStart is the deploy that follows creating an app; ctx is the
+// create request's, detached.
+func (h *AppHandler) buildAndStart(ctx context.Context, app *models.App) {
+	ctx = services.WithInitiator(ctx, services.InitiatorCreate, services.Initiator(ctx).ID)
+	if err := h.appManager.DeployApp(ctx, app.ID, nil); err != nil {
+		log.Printf("[%s] Auto-deploy failed for %s: %v", services.CorrelationID(ctx), app.Name, err)
+	}
+}
+
+func (h *AppHandler) GetAppSpec(c *gin.Context) {
 	id := c.Param("id")
 	app, err := h.appManager.GetApp(id)
 	if err != nil {
@@ -144,7 +277,70 @@ func (h *AppHandler) UpdateApp(c *gin.Context) {
 		return
 	}
 
-	wasRunning := app.Status == models.StatusRunning
+	c.JSON(http.StatusOK, services.SpecFromApp(app))
+}
+
+// ApplyAppSpec converges an app onto the posted spec and reports the
+// field-level diff. The rebuild or restart it may call for is left to the
+// caller.
+func (h *AppHandler) ApplyAppSpec(c *gin.Context) {
+	id := c.Param("id")
+	app, err := h.appManager.GetApp(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+
+	var spec models.AppSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if err := services.CheckPrivilegedConfirmed(app.Privileged, spec.Privileged, c.Query("confirmPrivileged") == "true"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	diff, err := h.appManager.ApplySpec(id, &spec, actorOf(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+func (h *AppHandler) CreateAppFromSpec(c *gin.Context) {
+	var spec models.AppSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if err := services.CheckPrivilegedConfirmed(false, spec.Privileged, c.Query("confirmPrivileged") == "true"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	app, err := h.appManager.CreateAppFromSpec(c.Request.Context(), &spec, actorOf(c))
+	if err != nil {
+		c.JSON(errorStatus(err, http.StatusBadRequest), errorBody(c, err))
+		return
+	}
+
+	go h.buildAndStart(detachedContext(c), app)
+
+	c.JSON(http.StatusCreated, app)
+}
+
+func (h *AppHandler) UpdateApp(c *gin.Context) {
+	id := c.Param("id")
+	app, err := h.appManager.GetApp(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
 
 	var req models.ConfigureAppRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -152,6 +348,16 @@ func (h *AppHandler) UpdateApp(c *gin.Context) {
 		return
 	}
 
+	h.applyConfig(c, app, &req)
+}
+
+// applyConfig is the shared update path for UpdateApp and
+// RestoreConfigSnapshot: merge req into app, save, and restart the container
+// if it was running.
+func (h *AppHandler) applyConfig(c *gin.Context, app *models.App, req *models.ConfigureAppRequest) {
+	wasRunning := app.Status == models.StatusRunning
+	previous := *app
+
 	if req.Name != "" {
 		app.Name = req.Name
 	}
@@ -176,70 +382,553 @@ func (h *AppHandler) UpdateApp(c *gin.Context) {
 	if req.Volumes != nil {
 		app.Volumes = req.Volumes
 	}
+	if req.OfflineBuild != nil {
+		app.OfflineBuild = *req.OfflineBuild
+	}
+	if req.BuildTarget != nil {
+		app.BuildTarget = strings.TrimSpace(*req.BuildTarget)
+	}
+	if req.Platform != nil {
+		app.Platform = strings.ToLower(strings.TrimSpace(*req.Platform))
+	}
+	if req.Replicas > services.MaxReplicas {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("replicas must be between 1 and %d", services.MaxReplicas)})
+		return
+	}
+	if req.Replicas > 0 {
+		app.Replicas = req.Replicas
+	}
+	if req.RestartPolicy != "" {
+		app.RestartPolicy = req.RestartPolicy
+	}
+	if req.MaxRetries != nil {
+		app.MaxRetries = *req.MaxRetries
+	}
+	if err := services.ValidateRestartPolicy(app.RestartPolicy, app.MaxRetries); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.NetworkMode != "" {
+		app.NetworkMode = req.NetworkMode
+	}
+	if req.Network != nil {
+		app.Network = strings.TrimSpace(*req.Network)
+	}
+	if req.IPAddress != nil {
+		app.IPAddress = strings.TrimSpace(*req.IPAddress)
+	}
+	if req.GPU != nil {
+		app.GPU = *req.GPU
+	}
+	if req.GPUCapabilities != nil {
+		app.GPUCapabilities = *req.GPUCapabilities
+	}
+	if req.GPURuntime != nil {
+		app.GPURuntime = *req.GPURuntime
+	}
+	if req.Devices != nil {
+		app.Devices = req.Devices
+	}
+	if req.Privileged != nil {
+		if err := services.CheckPrivilegedConfirmed(app.Privileged, *req.Privileged, req.ConfirmPrivileged); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		app.Privileged = *req.Privileged
+	}
+	if req.CapAdd != nil {
+		app.CapAdd = req.CapAdd
+	}
+	if req.CapDrop != nil {
+		app.CapDrop = req.CapDrop
+	}
+	if req.Healthcheck != nil {
+		app.Healthcheck = req.Healthcheck
+	}
+	if req.Labels != nil {
+		app.Labels = req.Labels
+	}
+	if req.User != nil {
+		app.User = *req.User
+	}
+	if req.Entrypoint.Set {
+		app.Entrypoint = req.Entrypoint.Value
+	}
+	if req.Command.Set {
+		app.Command = req.Command.Value
+	}
+	if req.ExtraHosts != nil {
+		if err := services.ValidateExtraHosts(req.ExtraHosts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		app.ExtraHosts = req.ExtraHosts
+	}
+	if req.DNS != nil {
+		if err := services.ValidateDNS(req.DNS); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		app.DNS = req.DNS
+	}
+	if req.ContainerName != nil && *req.ContainerName != app.CustomContainerName {
+		if err := services.ValidateContainerName(*req.ContainerName); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// The container is recreated under the new name on the next start,
+		// or right away if it's running
+		app.CustomContainerName = *req.ContainerName
+		ctx, cancel := requestContext(c, readTimeout)
+		defer cancel()
+		if err := h.appManager.CheckContainerName(ctx, app); err != nil {
+			c.JSON(errorStatus(err, http.StatusConflict), errorBody(c, err))
+			return
+		}
+	}
+	if req.Hostname != nil {
+		if err := services.ValidateHostname(*req.Hostname); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		app.Hostname = *req.Hostname
+	}
+	if req.UseProxy != nil {
+		app.UseProxy = *req.UseProxy
+	}
+	if req.AutoRecreate != nil {
+		app.AutoRecreate = *req.AutoRecreate
+	}
+	if req.NetworkIsolated != nil {
+		app.NetworkIsolated = *req.NetworkIsolated
+	}
+	if req.PreserveLocalChanges != nil {
+		app.PreserveLocalChanges = *req.PreserveLocalChanges
+	}
+	if req.FailStop != nil {
+		app.FailStop = *req.FailStop
+	}
+	if req.LiteralEnv != nil {
+		app.LiteralEnv = *req.LiteralEnv
+	}
+	if req.LogMaxSize != nil {
+		app.LogMaxSize = *req.LogMaxSize
+	}
+	if req.LogMaxFiles != nil {
+		app.LogMaxFiles = *req.LogMaxFiles
+	}
+	if err := services.ValidateLogOptions(app.LogMaxSize, app.LogMaxFiles); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MemoryLimit != nil {
+		app.MemoryLimit = *req.MemoryLimit
+	}
+	if req.MemorySwap != nil {
+		app.MemorySwap = *req.MemorySwap
+	}
+	if req.MemoryReservation != nil {
+		app.MemoryReservation = *req.MemoryReservation
+	}
+	if req.ShmSize != nil {
+		app.ShmSize = *req.ShmSize
+	}
+	if err := services.ValidateMemoryOptions(app.MemoryLimit, app.MemorySwap, app.MemoryReservation, app.ShmSize); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Ulimits != nil {
+		if err := services.ValidateUlimits(req.Ulimits); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		app.Ulimits = req.Ulimits
+	}
+	if req.Sysctls != nil {
+		app.Sysctls = req.Sysctls
+	}
+	// Checked even when unchanged, since a move to host networking rules
+	// out net.* sysctls
+	if err := services.ValidateSysctls(app.Sysctls, app.NetworkMode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.BindAddress != nil {
+		address := strings.TrimSpace(*req.BindAddress)
+		if err := services.ValidateBindAddress(address); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		app.BindAddress = address
+	}
+	if req.PublishIPv6 != nil {
+		publish := strings.ToLower(strings.TrimSpace(*req.PublishIPv6))
+		if err := services.ValidatePublishIPv6(publish); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		app.PublishIPv6 = publish
+	}
 
-	if err := h.appManager.UpdateApp(app); err != nil {
+	if err := h.appManager.UpdateApp(app, actorOf(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// If the app was running, restart it in the background so the new config
-	// (port mappings, env vars, volumes) takes effect immediately.
+	// (port mappings, env vars, volumes) takes effect immediately. A restart
+	// policy change alone is applied without a restart.
 	if wasRunning {
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-			defer cancel()
-			h.appManager.RestartApp(ctx, id)
-		}()
+		id := app.ID
+		parent := detachedContext(c)
+		go h.appManager.ApplyRunningConfig(parent, &previous, id)
 	}
 
 	c.JSON(http.StatusOK, app)
 }
 
-func (h *AppHandler) DeleteApp(c *gin.Context) {
+func (h *AppHandler) GetConfigHistory(c *gin.Context) {
+	history, err := h.appManager.GetConfigHistory(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// RestoreConfigSnapshot re-applies a snapshot's env and build args through
+// the normal update path. Build arg changes only take effect on the next
+// build.
+func (h *AppHandler) RestoreConfigSnapshot(c *gin.Context) {
 	id := c.Param("id")
+	app, err := h.appManager.GetApp(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
 
-	if err := h.appManager.DeleteApp(context.Background(), id); err != nil {
+	snapshotID, err := strconv.ParseInt(c.Param("snapshotId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid snapshot id"})
+		return
+	}
+
+	snapshot, err := h.appManager.GetConfigSnapshot(id, snapshotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Non-nil maps so an empty snapshot clears the current values.
+	h.applyConfig(c, app, &models.ConfigureAppRequest{
+		Env:       copyMap(snapshot.Env),
+		BuildArgs: copyMap(snapshot.BuildArgs),
+	})
+}
+
+// ListAppEvents returns the app's recorded container exits (OOM kills,
+// crashes) and state repairs, newest first.
+func (h *AppHandler) ListAppEvents(c *gin.Context) {
+	events, err := h.appManager.GetAppEvents(c.Param("id"))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, events)
+}
+
+// GetAppMetrics returns the app's CPU and memory history over ?window (a
+// duration such as 30m or 6h; default 6h, capped at the retention setting),
+// averaged into steps sized for a chart.
+func (h *AppHandler) GetAppMetrics(c *gin.Context) {
+	if _, err := h.appManager.GetApp(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "app deleted"})
+	window := services.DefaultMetricsWindow
+	if raw := c.Query("window"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a positive duration such as 30m or 6h"})
+			return
+		}
+		window = parsed
+	}
+
+	points, window, step, err := h.appManager.AppMetrics(c.Param("id"), window)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"window": window.String(),
+		"step":   step.String(),
+		"points": points,
+	})
 }
 
-func (h *AppHandler) BuildApp(c *gin.Context) {
+// RepairState checks an app stuck in a transient status (building,
+// starting, updating, deploying) against Docker and the build worker right
+// away, instead of waiting for the watchdog. repair is null when the
+// status was not stuck.
+func (h *AppHandler) RepairState(c *gin.Context) {
+	if _, err := h.appManager.GetApp(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	repair, err := h.appManager.RepairState(ctx, c.Param("id"))
+	if err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
+		return
+	}
+	app, _ := h.appManager.GetApp(c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"repair": repair, "app": app})
+}
+
+// PlanStart previews starting the app: the container spec it would be
+// created from, with secrets masked, and what would stop or change the
+// start. Nothing is created.
+func (h *AppHandler) PlanStart(c *gin.Context) {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	plan, err := h.appManager.PlanStart(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+// GetHealth returns the app's HEALTHCHECK status and recent probe results.
+func (h *AppHandler) GetHealth(c *gin.Context) {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	health, err := h.appManager.GetHealth(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	c.JSON(http.StatusOK, health)
+}
+
+// ListBuilds returns the app's build history, newest first. ?limit= pages
+// it; pass the last build's id as ?before= for the next page.
+func (h *AppHandler) ListBuilds(c *gin.Context) {
+	before, _ := strconv.ParseInt(c.Query("before"), 10, 64)
+	limit := parseInt(c.Query("limit"), 0)
+	if before < 0 || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "before and limit cannot be negative"})
+		return
+	}
+	builds, err := h.buildService.GetBuilds(c.Param("id"), before, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, builds)
+}
+
+// ListImages returns the app's image tags: latest, previous and the
+// commit tags its builds kept, with sizes and creation times.
+func (h *AppHandler) ListImages(c *gin.Context) {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+
+	app, err := h.appManager.GetApp(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	images, err := h.appManager.AppImages(ctx, app)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, images)
+}
+
+// GetBuildRecordLog returns one recorded build's log, or its last ?lines=.
+func (h *AppHandler) GetBuildRecordLog(c *gin.Context) {
+	buildID, err := strconv.ParseInt(c.Param("buildId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid build id"})
+		return
+	}
+
+	buildLog, err := h.buildService.GetBuildRecordLog(c.Param("id"), buildID, parseInt(c.Query("lines"), 0))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"logs": buildLog})
+}
+
+// GetBuildInputs returns what a build was made from: commit, resolved base
+// image digests, build args hash and builder.
+func (h *AppHandler) GetBuildInputs(c *gin.Context) {
+	buildID, err := strconv.ParseInt(c.Param("buildId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid build id"})
+		return
+	}
+
+	build, err := h.buildService.GetBuild(c.Param("id"), buildID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, build)
+}
+
+// CompareBuilds lists the inputs that differ between ?from= and ?to=.
+func (h *AppHandler) CompareBuilds(c *gin.Context) {
+	fromID, err1 := strconv.ParseInt(c.Query("from"), 10, 64)
+	toID, err2 := strconv.ParseInt(c.Query("to"), 10, 64)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be build ids"})
+		return
+	}
+
+	comparison, err := h.buildService.CompareBuildRecords(c.Param("id"), fromID, toID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, comparison)
+}
+
+func copyMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// PreviewDelete answers a DELETE that has neither confirm=true nor a plan
+// ID with the delete plan (what would be removed, and sizes) and deletes
+// nothing. Anything else goes on to confirmation and DeleteApp.
+func (h *AppHandler) PreviewDelete(c *gin.Context) {
+	if c.Query("confirm") == "true" || c.Query("plan") != "" {
+		c.Next()
+		return
+	}
+
+	// Sizing volumes asks Docker for disk usage, which can be slow
+	ctx, cancel := requestContext(c, actionTimeout)
+	defer cancel()
+	plan, err := h.appManager.PlanDelete(ctx, c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, gin.H{"preview": true, "plan": plan})
+}
+
+// DeleteApp executes a previewed plan (?plan=<id>), or plans and executes
+// in one go (?confirm=true), and reports each step's result.
+func (h *AppHandler) DeleteApp(c *gin.Context) {
 	id := c.Param("id")
 
-	if h.buildService.IsBuilding() {
-		c.JSON(http.StatusConflict, gin.H{"error": "another build is in progress"})
+	var plan *models.DeletePlan
+	var err error
+	if planID := c.Query("plan"); planID != "" {
+		plan, err = h.appManager.ExecuteDeletePlan(detachedContext(c), id, planID)
+	} else {
+		plan, err = h.appManager.DeleteApp(detachedContext(c), id)
+	}
+	if errors.Is(err, services.ErrDeletePlanNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
+	if err != nil {
+		resp := errorBody(c, err)
+		if plan != nil {
+			resp["plan"] = plan
+		}
+		c.JSON(errorStatus(err, http.StatusInternalServerError), resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "app deleted", "plan": plan})
+}
+
+// buildContext is detachedContext with the build options the request body
+// asks for ({"noCache": true, "pullBaseImage": true}). The body is
+// optional; without one the build uses none. {"force": true}, or
+// ?force=true, builds even when nothing changed since the last successful
+// build.
+func buildContext(c *gin.Context) context.Context {
+	var req struct {
+		docker.BuildOptions
+		Force bool `json:"force"`
+	}
+	c.ShouldBindJSON(&req)
+	ctx := services.WithBuildOptions(detachedContext(c), req.BuildOptions)
+	if req.Force || c.Query("force") == "true" {
+		ctx = services.WithForceBuild(ctx)
+	}
+	return ctx
+}
 
-	// Start build in background
+func (h *AppHandler) BuildApp(c *gin.Context) {
+	id := c.Param("id")
+
+	// Start build in background; it waits its turn if another is running
+	queued := h.buildQueued(id)
+	parent := buildContext(c)
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-		defer cancel()
-		h.appManager.BuildApp(ctx, id, nil)
+		h.appManager.BuildApp(parent, id, nil)
 	}()
 
-	c.JSON(http.StatusAccepted, gin.H{"message": "build started"})
+	message := "build started"
+	if queued {
+		message = "build queued"
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       message,
+		"queued":        queued,
+		"correlationId": services.CorrelationID(parent),
+	})
 }
 
 func (h *AppHandler) StartApp(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.appManager.StartApp(context.Background(), id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	ctx, cancel := actionContext(c, actionTimeout)
+	defer cancel()
+	if err := h.appManager.StartStoppedApp(ctx, id); err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), startError(c, err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "app started"})
 }
 
+// startError builds the error response for a failed start, flagging when
+// the only fix is a rebuild.
+func startError(c *gin.Context, err error) gin.H {
+	resp := errorBody(c, err)
+	if errors.Is(err, services.ErrImageMissing) {
+		resp["rebuildRequired"] = true
+	}
+	return resp
+}
+
 func (h *AppHandler) StopApp(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.appManager.StopApp(context.Background(), id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	ctx, cancel := actionContext(c, actionTimeout)
+	defer cancel()
+	if err := h.appManager.StopApp(ctx, id); err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
 		return
 	}
 
@@ -249,36 +938,126 @@ func (h *AppHandler) StopApp(c *gin.Context) {
 func (h *AppHandler) RestartApp(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.appManager.RestartApp(context.Background(), id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	ctx, cancel := actionContext(c, actionTimeout)
+	defer cancel()
+	if err := h.appManager.RestartApp(ctx, id); err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), startError(c, err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "app restarted"})
 }
 
-func (h *AppHandler) PullAndRebuild(c *gin.Context) {
-	id := c.Param("id")
+// Rollback recreates the app's container from an earlier build's image,
+// the body's optional {"tag": ...} or else the newest older one.
+func (h *AppHandler) Rollback(c *gin.Context) {
+	var req struct {
+		Tag string `json:"tag"`
+	}
+	c.ShouldBindJSON(&req)
 
-	if h.buildService.IsBuilding() {
-		c.JSON(http.StatusConflict, gin.H{"error": "another build is in progress"})
+	ctx, cancel := actionContext(c, actionTimeout)
+	defer cancel()
+	app, err := h.appManager.Rollback(ctx, c.Param("id"), req.Tag)
+	if err != nil {
+		err = requestError(ctx, err)
+		status := errorStatus(err, http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, services.ErrNoRollbackImage):
+			status = http.StatusConflict
+		case errors.Is(err, services.ErrUnknownRollbackTarget), errors.Is(err, services.ErrRollbackCompose):
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, errorBody(c, err))
 		return
 	}
 
-	// Start in background
+	c.JSON(http.StatusOK, gin.H{"message": "rolled back to " + app.RolledBackTo, "app": app})
+}
+
+type bulkActionRequest struct {
+	Action string   `json:"action" binding:"required"`
+	AppIDs []string `json:"appIds" binding:"required"`
+}
+
+// BulkAction starts, stops or restarts several apps at once and reports
+// each one's outcome. The batch itself succeeding is a 200 even when some
+// apps failed.
+func (h *AppHandler) BulkAction(c *gin.Context) {
+	var req bulkActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.AppIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action and appIds are required"})
+		return
+	}
+
+	ctx, cancel := actionContext(c, actionTimeout)
+	defer cancel()
+	report, err := h.appManager.RunBatch(ctx, req.Action, req.AppIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *AppHandler) PullAndRebuild(c *gin.Context) {
+	id := c.Param("id")
+
+	// Start in background; the rebuild waits its turn in the build queue
+	queued := h.buildQueued(id)
+	parent := buildContext(c)
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-		defer cancel()
-		h.appManager.PullAndRebuild(ctx, id, nil)
+		h.appManager.PullAndRebuild(parent, id, nil)
 	}()
 
-	c.JSON(http.StatusAccepted, gin.H{"message": "pull and rebuild started"})
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "pull and rebuild started",
+		"queued":        queued,
+		"correlationId": services.CorrelationID(parent),
+	})
+}
+
+// buildQueued reports whether a build of the app requested now would have
+// to wait: another build is running, or one of this app is already queued
+// (which the new request takes over from).
+func (h *AppHandler) buildQueued(appID string) bool {
+	building, _, ok := h.buildService.CurrentBuild()
+	return (ok && building != appID) || h.buildService.Queued(appID)
+}
+
+// Prepull pulls the app's base images in the background; poll
+// GetPrepull for progress.
+func (h *AppHandler) Prepull(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.appManager.GetApp(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+
+	state, err := h.appManager.PrepullApp(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, state)
+}
+
+func (h *AppHandler) GetPrepull(c *gin.Context) {
+	state := h.appManager.GetPrepull(c.Param("id"))
+	if state == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no prepull has run for this app"})
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
 }
 
 func (h *AppHandler) CheckUpdate(c *gin.Context) {
 	id := c.Param("id")
 
-	result, err := h.appManager.CheckAppUpdate(id)
+	result, err := h.appManager.CheckAppUpdate(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -287,35 +1066,101 @@ func (h *AppHandler) CheckUpdate(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+// ListStashes lists the stashes pulls left in the app's checkout.
+func (h *AppHandler) ListStashes(c *gin.Context) {
+	stashes, err := h.appManager.ListStashes(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stashes": stashes})
+}
+
+// DropStash deletes a stash, named by its commit, from the app's checkout.
+func (h *AppHandler) DropStash(c *gin.Context) {
+	err := h.appManager.DropStash(c.Request.Context(), c.Param("id"), c.Param("commit"))
+	if errors.Is(err, services.ErrStashNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "stash dropped"})
+}
+
 func (h *AppHandler) GetLogs(c *gin.Context) {
 	id := c.Param("id")
 	lines := c.DefaultQuery("lines", "100")
 
+	format, err := parseLogFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	app, err := h.appManager.GetApp(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
 		return
 	}
 
-	if app.ContainerID == "" {
+	// Compose apps pick a service; the default is the primary one
+	containerID, err := h.appManager.ServiceContainerID(app, c.Query("service"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if containerID == "" {
 		c.JSON(http.StatusOK, gin.H{"logs": ""})
 		return
 	}
 
-	logs, err := h.dockerClient.GetContainerLogs(context.Background(), app.ContainerID, lines)
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	output, err := h.dockerClient.GetContainerLogs(ctx, containerID, lines)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
 		return
 	}
-	defer logs.Close()
+	defer output.Close()
 
-	data, _ := io.ReadAll(logs)
 	// Strip Docker log header bytes
-	cleanLogs := stripDockerLogHeaders(data)
+	cleanLogs, err := logs.ReadAll(output, format)
+	if err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"logs": string(cleanLogs)})
 }
 
+// ClearBuildCache prunes the app's unused build cache. The response says
+// when the cache couldn't be told apart and a coarser prune was done.
+func (h *AppHandler) ClearBuildCache(c *gin.Context) {
+	app, err := h.appManager.GetApp(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+
+	ctx, cancel := requestContext(c, pruneTimeout)
+	defer cancel()
+	result, err := h.buildService.ClearBuildCache(ctx, app)
+	if err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
 func (h *AppHandler) ClearLogs(c *gin.Context) {
 	id := c.Param("id")
 
@@ -328,27 +1173,43 @@ func (h *AppHandler) ClearLogs(c *gin.Context) {
 func (h *AppHandler) GetBuildLogs(c *gin.Context) {
 	id := c.Param("id")
 
-	logs, err := h.buildService.GetBuildLog(id)
+	var buildLog string
+	var err error
+	if lines := parseInt(c.Query("lines"), 0); lines > 0 {
+		buildLog, err = h.buildService.GetBuildLogTail(id, lines)
+	} else {
+		buildLog, err = h.buildService.GetBuildLog(id)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"logs": logs})
+	c.JSON(http.StatusOK, gin.H{"logs": buildLog})
 }
 
 func (h *AppHandler) GetAppIcon(c *gin.Context) {
-	id := c.Param("id")
-
-	// Check for cached icon
-	iconPath := filepath.Join(h.dataDir, "icons", id+".png")
-	if _, err := os.Stat(iconPath); err == nil {
-		c.File(iconPath)
+	iconPath, contentType, etag, err := h.appManager.IconETag(c.Param("id"))
+	if err != nil {
+		// The UI falls back to a letter avatar
+		c.JSON(http.StatusNotFound, gin.H{"error": "no icon"})
 		return
 	}
 
-	// Return default icon
-	c.JSON(http.StatusNotFound, gin.H{"error": "no icon"})
+	// Icons change in place under the same URL, so browsers revalidate
+	// every time and get a 304 while the content hash matches.
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	// Served without auth from the controller's own origin: an SVG must
+	// not run script or be sniffed as anything else
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	c.File(iconPath)
 }
 
 func (h *AppHandler) StreamLogs(c *gin.Context) {
@@ -360,30 +1221,113 @@ func (h *AppHandler) StreamLogs(c *gin.Context) {
 		return
 	}
 
-	if app.ContainerID == "" {
+	containerID, err := h.appManager.ServiceContainerID(app, c.Query("service"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if containerID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "container not running"})
 		return
 	}
 
+	format, err := parseLogFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	asJSON, err := parseStreamFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		return
 	}
 	defer conn.Close()
+	stream := newStreamWriter(conn, asJSON)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	release, err := h.streams.Acquire(app.ID)
+	if err != nil {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()),
+			time.Now().Add(logStreamWriteWait))
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
 	defer cancel()
 
-	logs, err := h.dockerClient.StreamContainerLogs(ctx, app.ContainerID)
+	output, err := h.dockerClient.StreamContainerLogs(ctx, containerID)
 	if err != nil {
+		stream.Error(StreamErrorData{Message: err.Error()}, "")
 		return
 	}
-	defer logs.Close()
+	defer output.Close()
 
-	scanner := bufio.NewScanner(logs)
-	for scanner.Scan() {
-		line := stripDockerLogHeaders(scanner.Bytes())
-		if err := conn.WriteMessage(websocket.TextMessage, line); err != nil {
+	pumpLogs(ctx, cancel, conn, stream, output, format)
+}
+
+// pumpLogs copies output to the socket until either end goes away. It keeps
+// the connection alive with pings and closes output as soon as ctx is
+// cancelled, so a hung client never pins the docker stream.
+func pumpLogs(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, stream *streamWriter, output io.ReadCloser, format logs.Format) {
+	// The client never sends anything, but reading is what processes pongs
+	// and notices a closed socket. Any read error ends the stream.
+	conn.SetReadDeadline(time.Now().Add(logStreamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(logStreamPongWait))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Ping so half-open connections (sleeping laptops, dropped Wi-Fi) hit the
+	// read deadline instead of holding the docker stream forever.
+	go func() {
+		ticker := time.NewTicker(logStreamPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(logStreamWriteWait)); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	// A quiet container leaves the reader blocked in Read; closing the
+	// stream is what unblocks it once the client is gone.
+	go func() {
+		<-ctx.Done()
+		output.Close()
+	}()
+
+	frames := logs.NewFrameReader(output)
+	for {
+		line, err := frames.Next()
+		if err != nil {
+			// The container stopped, unless it's the client that went
+			if ctx.Err() == nil {
+				stream.Send(StreamDone, nil, "")
+			}
+			return
+		}
+		message := bytes.TrimSuffix(format.AppendLine(nil, line.Data), []byte("\n"))
+		if err := stream.Log(string(message)); err != nil {
 			return
 		}
 	}
@@ -392,15 +1336,22 @@ func (h *AppHandler) StreamLogs(c *gin.Context) {
 func (h *AppHandler) StreamBuild(c *gin.Context) {
 	id := c.Param("id")
 
+	asJSON, err := parseStreamFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		return
 	}
 	defer conn.Close()
+	stream := newStreamWriter(conn, asJSON)
 
 	app, err := h.appManager.GetApp(id)
 	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte("Error: app not found"))
+		stream.Error(StreamErrorData{Message: "app not found"}, "Error: app not found")
 		return
 	}
 
@@ -408,54 +1359,43 @@ func (h *AppHandler) StreamBuild(c *gin.Context) {
 	defer close(progressChan)
 
 	// Start build
+	parent := detachedContext(c)
+	if c.Query("force") == "true" {
+		parent = services.WithForceBuild(parent)
+	}
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-		defer cancel()
-		h.appManager.BuildApp(ctx, app.ID, progressChan)
+		h.appManager.BuildApp(parent, app.ID, progressChan)
 	}()
 
 	// Stream progress
+	lastPercent := -1
 	for progress := range progressChan {
 		if progress.Error != "" {
-			conn.WriteMessage(websocket.TextMessage, []byte("ERROR: "+progress.Error))
+			stream.Error(StreamErrorData{Message: progress.Error, Category: progress.Category, Hint: progress.Hint},
+				"ERROR: "+progress.Error)
 		}
 		if progress.Message != "" {
-			conn.WriteMessage(websocket.TextMessage, []byte(progress.Message))
+			stream.Log(progress.Message)
+		}
+		// Steps are counted from the build output, so the percentage can
+		// only move after a message
+		if building, percent, ok := h.buildService.CurrentBuild(); ok && building == app.ID && percent != lastPercent {
+			lastPercent = percent
+			stream.Send(StreamProgress, gin.H{"percent": percent}, "")
 		}
 		if progress.Complete {
-			if progress.Success {
-				conn.WriteMessage(websocket.TextMessage, []byte("\n\nBuild completed successfully!"))
-			} else {
-				conn.WriteMessage(websocket.TextMessage, []byte("\n\nBuild failed!"))
+			text := "\n\nBuild failed!"
+			if progress.Skipped {
+				text = "\n\nAlready up to date."
+			} else if progress.Success {
+				text = "\n\nBuild completed successfully!"
 			}
+			stream.Send(StreamDone, gin.H{"success": progress.Success, "skipped": progress.Skipped}, text)
 			return
 		}
 	}
 }
 
-// stripDockerLogHeaders removes the 8-byte header from Docker log lines
-func stripDockerLogHeaders(data []byte) []byte {
-	var result []byte
-	for len(data) > 0 {
-		if len(data) >= 8 {
-			// Skip header
-			size := int(data[4])<<24 | int(data[5])<<16 | int(data[6])<<8 | int(data[7])
-			data = data[8:]
-			if size > 0 && len(data) >= size {
-				result = append(result, data[:size]...)
-				data = data[size:]
-			} else {
-				result = append(result, data...)
-				break
-			}
-		} else {
-			result = append(result, data...)
-			break
-		}
-	}
-	return result
-}
-
 func parseInt(s string, defaultVal int) int {
 	if v, err := strconv.Atoi(s); err == nil {
 		return v
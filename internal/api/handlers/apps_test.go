@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"nas-controller/internal/database"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/logs"
+	"nas-controller/internal/models"
+	"nas-controller/internal/services"
+)
+
+// newTestAppHandler returns an AppHandler whose app manager runs on runtime
+// and an in-memory database.
+func newTestAppHandler(t *testing.T, runtime docker.Runtime) (*AppHandler, *database.DB) {
+	t.Helper()
+	dataDir := t.TempDir()
+	db, err := database.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	settings, err := services.NewSettingsService(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builds := services.NewBuildService(db, runtime, settings, dataDir)
+	m := services.NewAppManager(
+		db,
+		runtime,
+		services.NewGitService(dataDir, settings),
+		builds,
+		services.NewPortAllocator(db, nil, settings),
+		services.NewIconService(dataDir),
+		services.NewPrepullService(db, nil),
+		services.NewHealthMonitor(db, nil),
+		services.NewUploadService(dataDir),
+		settings,
+		dataDir,
+	)
+	gin.SetMode(gin.TestMode)
+	return NewAppHandler(m, builds, nil, services.NewStreamLimiter(settings), dataDir), db
+}
+
+// quietLogs stands in for the docker log stream of a container that prints
+// nothing: Read blocks until the stream is closed.
+type quietLogs struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newQuietLogs() *quietLogs {
+	return &quietLogs{closed: make(chan struct{})}
+}
+
+func (q *quietLogs) Read(p []byte) (int, error) {
+	<-q.closed
+	return 0, io.ErrClosedPipe
+}
+
+func (q *quietLogs) Close() error {
+	q.once.Do(func() { close(q.closed) })
+	return nil
+}
+
+var shortenLiveness sync.Once
+
+// shortenLogStreamLiveness makes the ping and pong timeouts fit in a test.
+// They stay short for the rest of the package's tests: a finished stream's
+// ping goroutine can still be reading them.
+func shortenLogStreamLiveness() {
+	shortenLiveness.Do(func() {
+		logStreamPingInterval, logStreamPongWait, logStreamWriteWait = 20*time.Millisecond, 100*time.Millisecond, 50*time.Millisecond
+	})
+}
+
+// serveLogs serves output to one websocket client with pumpLogs, as
+// envelopes if asJSON, and reports when pumpLogs returns.
+func serveLogs(t *testing.T, output io.ReadCloser, asJSON bool) (string, <-chan struct{}) {
+	t.Helper()
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pumpLogs(ctx, cancel, conn, newStreamWriter(conn, asJSON), output, logs.Format{})
+		close(done)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), done
+}
+
+func TestLogStreamReleasesDockerStream(t *testing.T) {
+	tests := []struct {
+		name string
+		// client does what the client does once connected
+		client func(conn *websocket.Conn)
+	}{
+		{
+			// Never reading means never answering a ping, like a laptop
+			// that went to sleep with the page open
+			name:   "hung client",
+			client: func(conn *websocket.Conn) {},
+		},
+		{
+			name:   "client that goes away",
+			client: func(conn *websocket.Conn) { conn.Close() },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shortenLogStreamLiveness()
+			output := newQuietLogs()
+			url, done := serveLogs(t, output, false)
+
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			tt.client(conn)
+
+			select {
+			case <-output.closed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("docker log stream still open")
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("pumpLogs did not return")
+			}
+		})
+	}
+}
+
+func TestLogStreamKeepsAnsweringClient(t *testing.T) {
+	shortenLogStreamLiveness()
+	output := newQuietLogs()
+	defer output.Close()
+	url, done := serveLogs(t, output, false)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Reading answers pings, so the quiet stream outlives several pong waits
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-output.closed:
+		t.Fatal("docker log stream closed under a live client")
+	case <-time.After(5 * logStreamPongWait):
+	}
+
+	conn.Close()
+	<-done
+}
+
+// listFixture is a server's worth of apps: count apps with envVars env
+// vars each, plus the config a typical app carries.
+func listFixture(count, envVars int) []*models.App {
+	apps := make([]*models.App, count)
+	for i := range apps {
+		env := make(map[string]string, envVars)
+		for j := 0; j < envVars; j++ {
+			env[fmt.Sprintf("APP_SETTING_%02d", j)] = fmt.Sprintf("value-%d-%d-with-some-length", i, j)
+		}
+		apps[i] = &models.App{
+			ID:             fmt.Sprintf("app-%04d", i),
+			Name:           fmt.Sprintf("App %d", i),
+			Slug:           fmt.Sprintf("app-%d", i),
+			Description:    "A self-hosted service built from its repository",
+			Icon:           fmt.Sprintf("/api/apps/app-%04d/icon", i),
+			SourceType:     "github",
+			RepoURL:        fmt.Sprintf("https://github.com/acme/app-%d", i),
+			Branch:         "main",
+			LastCommit:     "9568545a1b2c3d4e5f60718293a4b5c6d7e8f901",
+			DockerfilePath: "Dockerfile",
+			BuildArgs:      map[string]string{"VERSION": "1.2.3", "TARGETARCH": "amd64"},
+			ImageName:      fmt.Sprintf("nas-app-%d:latest", i),
+			ContainerName:  fmt.Sprintf("nas-app-%d", i),
+			ContainerID:    "3f2a9c1e7b44d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6",
+			InternalPort:   8080,
+			ExternalPort:   13000 + i,
+			RestartPolicy:  "unless-stopped",
+			Status:         models.StatusRunning,
+			Env:            env,
+			Volumes:        []string{fmt.Sprintf("/mnt/user/appdata/app-%d:/data", i)},
+		}
+	}
+	return apps
+}
+
+func TestSummarizeApp(t *testing.T) {
+	app := listFixture(1, 30)[0]
+	app.UpdateAvailable = true
+	app.ReplicaPorts = []int{13101, 13102}
+
+	got := summarizeApp(app, "3 hours")
+	want := models.AppSummary{
+		ID:              app.ID,
+		Name:            app.Name,
+		Icon:            app.Icon,
+		Status:          models.StatusRunning,
+		Ports:           []int{13000, 13101, 13102},
+		Uptime:          "3 hours",
+		UpdateAvailable: true,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+
+	// An app with no port yet lists none, not null
+	app.ExternalPort, app.ReplicaPorts = 0, nil
+	data, _ := json.Marshal(summarizeApp(app, ""))
+	if !bytes.Contains(data, []byte(`"ports":[]`)) || bytes.Contains(data, []byte("uptime")) {
+		t.Errorf("summary = %s", data)
+	}
+}
+
+// BenchmarkAppListPayload reports how big GET /apps is in full and as
+// ?view=summary, before and after gzip. Run with -bench AppListPayload.
+func BenchmarkAppListPayload(b *testing.B) {
+	apps := listFixture(40, 30)
+	views := []struct {
+		name string
+		body func() any
+	}{
+		{"full", func() any { return apps }},
+		{"summary", func() any {
+			summaries := make([]models.AppSummary, 0, len(apps))
+			for _, app := range apps {
+				summaries = append(summaries, summarizeApp(app, "3 hours"))
+			}
+			return summaries
+		}},
+	}
+	for _, view := range views {
+		b.Run(view.name, func(b *testing.B) {
+			var raw, compressed int
+			for i := 0; i < b.N; i++ {
+				data, err := json.Marshal(view.body())
+				if err != nil {
+					b.Fatal(err)
+				}
+				var buf bytes.Buffer
+				gz := gzip.NewWriter(&buf)
+				gz.Write(data)
+				gz.Close()
+				raw, compressed = len(data), buf.Len()
+			}
+			b.ReportMetric(float64(raw), "bytes")
+			b.ReportMetric(float64(compressed), "gzip-bytes")
+		})
+	}
+}
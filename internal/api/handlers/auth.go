@@ -21,6 +21,14 @@ func NewAuthHandler(db *database.DB, authService *services.AuthService) *AuthHan
 	}
 }
 
+// ActorKey is the gin context key under which the auth middleware stores who
+// is making the request, e.g. "session:1a2b3c4d" or "token:1a2b3c4d".
+const ActorKey = "actor"
+
+func actorOf(c *gin.Context) string {
+	return c.GetString(ActorKey)
+}
+
 type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
@@ -42,23 +50,32 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	h.startSession(c)
+}
+
+// startSession logs the client in: it creates a session, sets its cookie
+// and responds with the tokens.
+func (h *AuthHandler) startSession(c *gin.Context) {
 	// Clean up expired sessions
 	h.db.CleanupExpiredSessions()
 
 	// Create new session
 	token := h.authService.GenerateSessionToken()
+	csrfToken := h.authService.GenerateSessionToken()
 	expiresAt := time.Now().Add(7 * 24 * time.Hour) // 7 days
 
-	if err := h.db.CreateSession(token, expiresAt); err != nil {
+	if err := h.db.CreateSession(token, csrfToken, expiresAt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
 		return
 	}
 
 	// Set cookie
+	c.SetSameSite(http.SameSiteLaxMode)
 	c.SetCookie("session", token, 7*24*60*60, "/", "", false, true)
 
 	c.JSON(http.StatusOK, gin.H{
 		"token":     token,
+		"csrfToken": csrfToken,
 		"expiresAt": expiresAt,
 	})
 }
@@ -80,8 +97,23 @@ func (h *AuthHandler) Check(c *gin.Context) {
 		return
 	}
 
-	valid := h.db.ValidateSession(token)
-	c.JSON(http.StatusOK, gin.H{"authenticated": valid})
+	if !h.db.ValidateSession(token) {
+		c.JSON(http.StatusOK, gin.H{"authenticated": false})
+		return
+	}
+
+	// Sessions created before CSRF protection have no token yet; issue one
+	// so they keep working without a fresh login.
+	csrfToken, _ := h.db.GetSessionCSRFToken(token)
+	if csrfToken == "" {
+		csrfToken = h.authService.GenerateSessionToken()
+		if err := h.db.SetSessionCSRFToken(token, csrfToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue CSRF token"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"authenticated": true, "csrfToken": csrfToken})
 }
 
 func (h *AuthHandler) UpdatePassword(c *gin.Context) {
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// badgeMaxAge is how long clients and proxies may cache a badge; short,
+// since it shows the app's current status.
+const badgeMaxAge = "max-age=30"
+
+// badgeURL is the path the app's badge is served under with token.
+func badgeURL(appID string, token string) string {
+	return "/api/v1/apps/" + appID + "/badge.svg?token=" + token
+}
+
+// GetBadgeSettings says whether the app's badge is public, and where.
+func (h *AppHandler) GetBadgeSettings(c *gin.Context) {
+	id := c.Param("id")
+	token, err := h.appManager.BadgeToken(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	if token == "" {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "token": token, "url": badgeURL(id, token)})
+}
+
+// EnableBadge makes the app's badge public, or moves it to a new token if
+// it already was; the old URL stops working.
+func (h *AppHandler) EnableBadge(c *gin.Context) {
+	id := c.Param("id")
+	token, err := h.appManager.EnableBadge(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "token": token, "url": badgeURL(id, token)})
+}
+
+func (h *AppHandler) DisableBadge(c *gin.Context) {
+	if err := h.appManager.DisableBadge(c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": false})
+}
+
+// GetBadge serves the app's status badge without auth; the token in the
+// query string is the credential. ?uptime=1 adds the container's uptime.
+func (h *AppHandler) GetBadge(c *gin.Context) {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	svg, err := h.appManager.Badge(ctx, c.Param("id"), c.Query("token"), c.Query("uptime") == "1")
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.String(http.StatusNotFound, "badge not found")
+		return
+	}
+	c.Header("Cache-Control", badgeMaxAge)
+	c.Header("X-Robots-Tag", "noindex")
+	c.Data(http.StatusOK, "image/svg+xml; charset=utf-8", svg)
+}
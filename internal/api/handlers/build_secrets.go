@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/services"
+)
+
+// ListBuildSecrets lists the IDs of the app's build secrets; values are
+// write-only.
+func (h *AppHandler) ListBuildSecrets(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.appManager.GetApp(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	secrets, err := h.buildService.BuildSecrets(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, secrets)
+}
+
+// SetBuildSecret adds or replaces a build secret; the body is {"value":
+// "..."}. The response doesn't echo the value.
+func (h *AppHandler) SetBuildSecret(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.appManager.GetApp(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	var req struct {
+		Value string `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secretID := c.Param("secretId")
+	if err := services.ValidateBuildSecret(secretID, req.Value); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.buildService.SetBuildSecret(id, secretID, req.Value); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": secretID, "message": "secret set"})
+}
+
+func (h *AppHandler) DeleteBuildSecret(c *gin.Context) {
+	err := h.buildService.DeleteBuildSecret(c.Param("id"), c.Param("secretId"))
+	if errors.Is(err, services.ErrBuildSecretNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "secret deleted"})
+}
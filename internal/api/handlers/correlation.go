@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/services"
+)
+
+// CorrelationHeader carries a request's correlation ID both ways. Clients
+// may supply one; otherwise the controller makes one up.
+const CorrelationHeader = "X-Correlation-ID"
+
+// detachedContext is a background context for work that outlives the
+// request, still tagged with the request's correlation ID and, for builds
+// it starts, its actor.
+func detachedContext(c *gin.Context) context.Context {
+	ctx := services.WithCorrelationID(context.Background(), services.CorrelationID(c.Request.Context()))
+	return services.WithInitiator(ctx, services.InitiatorManual, actorOf(c))
+}
+
+// errorBody is the error envelope for failed operations. The correlation ID
+// matches the controller log lines and build record for the operation;
+// Docker failures also carry a stable code (see docker.Error), and a
+// rejected app name the slug to use instead.
+func errorBody(c *gin.Context, err error) gin.H {
+	resp := gin.H{"error": err.Error()}
+	if e, ok := docker.AsError(err); ok {
+		resp["code"] = e.Code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		resp["code"] = docker.CodeTimeout
+	}
+	var nameErr *services.InvalidNameError
+	if errors.As(err, &nameErr) && nameErr.Suggestion != "" {
+		resp["suggestion"] = nameErr.Suggestion
+	}
+	var checkoutErr *services.CheckoutInUseError
+	if errors.As(err, &checkoutErr) {
+		resp["appId"] = checkoutErr.AppID
+	}
+	if id := services.CorrelationID(c.Request.Context()); id != "" {
+		resp["correlationId"] = id
+	}
+	return resp
+}
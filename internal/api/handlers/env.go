@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/models"
+	"nas-controller/internal/services"
+)
+
+// ImportEnv merges a .env file into the app's environment. The file is the
+// raw request body, or the "file" part of a multipart upload. With
+// ?preview=true nothing is saved and the result is returned for
+// confirmation; ?mode=replace drops variables the file doesn't set instead of
+// keeping them.
+func (h *AppHandler) ImportEnv(c *gin.Context) {
+	app, err := h.appManager.GetApp(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+
+	text, err := readDotenv(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	imported, err := services.ParseDotenv(text)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	merged := map[string]string{}
+	if c.Query("mode") != "replace" {
+		for k, v := range app.Env {
+			merged[k] = v
+		}
+	}
+	added, changed := []string{}, []string{}
+	for k, v := range imported {
+		old, ok := app.Env[k]
+		switch {
+		case !ok:
+			added = append(added, k)
+		case old != v:
+			changed = append(changed, k)
+		}
+		merged[k] = v
+	}
+	removed := []string{}
+	for k := range app.Env {
+		if _, ok := merged[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+
+	if c.Query("preview") == "true" {
+		c.JSON(http.StatusOK, gin.H{
+			"preview": true,
+			"env":     merged,
+			"added":   added,
+			"changed": changed,
+			"removed": removed,
+		})
+		return
+	}
+
+	// The same path as PUT /apps/:id, so the change is recorded in the
+	// config history and a running app is restarted with it.
+	h.applyConfig(c, app, &models.ConfigureAppRequest{Env: merged})
+}
+
+// ExportEnv renders the app's environment as a .env file.
+func (h *AppHandler) ExportEnv(c *gin.Context) {
+	app, err := h.appManager.GetApp(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.env"`, app.Slug))
+	c.String(http.StatusOK, services.RenderDotenv(app.Env))
+}
+
+func readDotenv(c *gin.Context) (string, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxDotenvBytes)
+
+	var r io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return "", fmt.Errorf("expected a multipart upload with a \"file\" part")
+		}
+		f, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read .env file (limit %d bytes): %v", services.MaxDotenvBytes, err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/services"
+)
+
+// errorStatus picks the HTTP status for a failed operation: Docker errors
+// get one matching what went wrong, a timeout 504, a rejected app name
+// 422, anything else gets fallback.
+func errorStatus(err error, fallback int) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	var nameErr *services.InvalidNameError
+	if errors.As(err, &nameErr) {
+		return http.StatusUnprocessableEntity
+	}
+	var checkoutErr *services.CheckoutInUseError
+	if errors.As(err, &checkoutErr) {
+		return http.StatusConflict
+	}
+	e, ok := docker.AsError(err)
+	if !ok {
+		return fallback
+	}
+	switch e.Kind {
+	case docker.KindNotFound:
+		return http.StatusNotFound
+	case docker.KindConflict:
+		return http.StatusConflict
+	case docker.KindInvalid:
+		return http.StatusBadRequest
+	case docker.KindUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/services"
+)
+
+func TestErrorStatus(t *testing.T) {
+	dockerErr := func(kind docker.Kind, code string) error {
+		return fmt.Errorf("failed to start container: %w", &docker.Error{Kind: kind, Code: code, Err: errors.New("daemon says no")})
+	}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"container not found", dockerErr(docker.KindNotFound, docker.CodeContainerNotFound), http.StatusNotFound},
+		{"port allocated", dockerErr(docker.KindConflict, docker.CodePortAllocated), http.StatusConflict},
+		{"name conflict", fmt.Errorf("create: %w", docker.NameConflict("nas-app-demo")), http.StatusConflict},
+		{"invalid parameter", dockerErr(docker.KindInvalid, docker.CodeInvalidParameter), http.StatusBadRequest},
+		{"daemon unavailable", dockerErr(docker.KindUnavailable, docker.CodeUnavailable), http.StatusServiceUnavailable},
+		// An unclassified daemon failure is the daemon's fault, not the caller's
+		{"daemon internal", dockerErr(docker.KindInternal, docker.CodeInternal), http.StatusInternalServerError},
+		{"timeout", fmt.Errorf("inspect: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{"invalid name", &services.InvalidNameError{Name: "My App!", Reason: "bad characters"}, http.StatusUnprocessableEntity},
+		{"checkout in use", &services.CheckoutInUseError{AppID: "a1", AppName: "demo", Building: true}, http.StatusConflict},
+		{"anything else", errors.New("disk full"), http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorStatus(tt.err, http.StatusTeapot); got != tt.want {
+				t.Errorf("errorStatus = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorBodyCarriesDockerCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/apps/a1/start", nil)
+	c.Request = c.Request.WithContext(services.WithCorrelationID(c.Request.Context(), "d99f170224c7"))
+
+	err := fmt.Errorf("failed to start container: %w", &docker.Error{
+		Kind: docker.KindConflict,
+		Code: docker.CodePortAllocated,
+		Err:  errors.New("Bind for 0.0.0.0:8080 failed: port is already allocated"),
+	})
+	body := errorBody(c, err)
+	if body["code"] != docker.CodePortAllocated {
+		t.Errorf("code = %v, want %s", body["code"], docker.CodePortAllocated)
+	}
+	if body["error"] != "failed to start container: Bind for 0.0.0.0:8080 failed: port is already allocated" {
+		t.Errorf("error = %v", body["error"])
+	}
+	if body["correlationId"] != "d99f170224c7" {
+		t.Errorf("correlationId = %v", body["correlationId"])
+	}
+
+	if _, ok := errorBody(c, errors.New("disk full"))["code"]; ok {
+		t.Error("code set on an error that isn't Docker's")
+	}
+}
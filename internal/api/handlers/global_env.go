@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/services"
+)
+
+// GetGlobalEnv returns the environment every app's container starts from:
+// the global variables, the timezone setting and the TZ it resolves to,
+// and the running apps that were started with a different one.
+func (h *SystemHandler) GetGlobalEnv(c *gin.Context) {
+	h.respondGlobalEnv(c, h.settingsService.Get())
+}
+
+// UpdateGlobalEnv replaces the global environment and, if given, the
+// timezone setting. The new values reach running apps when they are
+// restarted; the response lists the ones still on the old values.
+func (h *SystemHandler) UpdateGlobalEnv(c *gin.Context) {
+	var req struct {
+		Env      map[string]string `json:"env"`
+		Timezone *string           `json:"timezone"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	settings := h.settingsService.Get()
+	settings.GlobalEnv = req.Env
+	if req.Timezone != nil {
+		settings.Timezone = *req.Timezone
+	}
+	if err := services.ValidateGlobalEnv(settings.GlobalEnv, settings.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.settingsService.Update(settings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.respondGlobalEnv(c, settings)
+}
+
+func (h *SystemHandler) respondGlobalEnv(c *gin.Context, settings services.Settings) {
+	env := settings.GlobalEnv
+	if env == nil {
+		env = map[string]string{}
+	}
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	stale, err := h.appManager.StaleEnvApps(ctx)
+	if err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"env":          env,
+		"timezone":     settings.Timezone,
+		"detectedTz":   services.DetectTimezone(),
+		"effectiveTz":  settings.EffectiveTimezone(),
+		"staleEnvApps": stale,
+	})
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/models"
+	"nas-controller/internal/services"
+)
+
+type GuestHandler struct {
+	guests          *services.GuestService
+	authService     *services.AuthService
+	settingsService *services.SettingsService
+}
+
+func NewGuestHandler(guests *services.GuestService, authService *services.AuthService, settingsService *services.SettingsService) *GuestHandler {
+	return &GuestHandler{
+		guests:          guests,
+		authService:     authService,
+		settingsService: settingsService,
+	}
+}
+
+type createGuestRequest struct {
+	Name        string    `json:"name"`
+	AppIDs      []string  `json:"appIds" binding:"required"`
+	Permissions []string  `json:"permissions" binding:"required"`
+	ExpiresAt   time.Time `json:"expiresAt" binding:"required"`
+}
+
+type redeemGuestRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func (h *GuestHandler) CreateGuest(c *gin.Context) {
+	var req createGuestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	guest, code, err := h.guests.Create(req.Name, req.AppIDs, req.Permissions, req.ExpiresAt, actorOf(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{"guest": guest, "code": code}
+	if base := strings.TrimRight(h.settingsService.Get().ExternalBaseURL, "/"); base != "" {
+		resp["url"] = base + "/guest/" + url.PathEscape(code)
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+func (h *GuestHandler) ListGuests(c *gin.Context) {
+	guests, err := h.guests.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, guests)
+}
+
+func (h *GuestHandler) RevokeGuest(c *gin.Context) {
+	found, err := h.guests.Revoke(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "guest code not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "guest code revoked"})
+}
+
+// RedeemGuest exchanges a guest code for a restricted session, the same way
+// Login does for the password.
+func (h *GuestHandler) RedeemGuest(c *gin.Context) {
+	var req redeemGuestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code required"})
+		return
+	}
+
+	token, csrfToken, guest, err := h.redeem(c, req.Code)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":     token,
+		"csrfToken": csrfToken,
+		"expiresAt": guest.ExpiresAt,
+		"guest":     guest,
+	})
+}
+
+// RedeemGuestLink is the target of a guest URL: it starts the session and
+// sends the browser to the first app it covers.
+func (h *GuestHandler) RedeemGuestLink(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+
+	_, _, guest, err := h.redeem(c, c.Param("code"))
+	if err != nil {
+		c.String(http.StatusNotFound, "guest code not found or expired")
+		return
+	}
+	c.Redirect(http.StatusFound, "/apps/"+url.PathEscape(guest.AppIDs[0]))
+}
+
+func (h *GuestHandler) redeem(c *gin.Context, code string) (string, string, *models.GuestCode, error) {
+	token := h.authService.GenerateSessionToken()
+	csrfToken := h.authService.GenerateSessionToken()
+	guest, err := h.guests.Redeem(code, token, csrfToken)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("session", token, int(time.Until(guest.ExpiresAt).Seconds()), "/", "", false, true)
+	h.guests.Audit(c.Request.Context(), guest, "redeem", strings.Join(guest.AppIDs, ","), true)
+	return token, csrfToken, guest, nil
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"nas-controller/internal/logs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseLogFormat reads the timestamps=off, tz=<IANA zone> and colors=off
+// query options.
+func parseLogFormat(c *gin.Context) (logs.Format, error) {
+	var f logs.Format
+
+	switch c.Query("timestamps") {
+	case "", "on", "raw":
+	case "off":
+		f.StripTimestamps = true
+	default:
+		return f, fmt.Errorf("timestamps must be on or off")
+	}
+
+	if tz := c.Query("tz"); tz != "" && !f.StripTimestamps {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return f, fmt.Errorf("unknown time zone %q", tz)
+		}
+		f.Location = loc
+	}
+
+	switch c.Query("colors") {
+	case "", "on":
+	case "off":
+		f.StripANSI = true
+	default:
+		return f, fmt.Errorf("colors must be on or off")
+	}
+
+	return f, nil
+}
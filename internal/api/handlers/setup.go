@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/services"
+)
+
+// SetupHandler serves the first-run wizard. Its endpoints need no auth,
+// and answer 410 once the controller has a password.
+type SetupHandler struct {
+	auth            *AuthHandler
+	authService     *services.AuthService
+	settingsService *services.SettingsService
+	diagnostics     *services.DiagnosticsService
+}
+
+func NewSetupHandler(auth *AuthHandler, authService *services.AuthService, settingsService *services.SettingsService, diagnostics *services.DiagnosticsService) *SetupHandler {
+	return &SetupHandler{
+		auth:            auth,
+		authService:     authService,
+		settingsService: settingsService,
+		diagnostics:     diagnostics,
+	}
+}
+
+type SetupRequest struct {
+	Password string `json:"password" binding:"required"`
+	// Zero keeps the current range
+	PortRangeStart int `json:"portRangeStart"`
+	PortRangeEnd   int `json:"portRangeEnd"`
+}
+
+func setupGone(c *gin.Context) {
+	c.JSON(http.StatusGone, gin.H{"error": services.ErrSetupComplete.Error()})
+}
+
+// GetStatus returns what the wizard shows: the port range new apps will
+// get and a fresh diagnostics run, which checks the data paths are
+// mounted and writable.
+func (h *SetupHandler) GetStatus(c *gin.Context) {
+	if !h.authService.NeedsSetup() {
+		setupGone(c)
+		return
+	}
+
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	start, end := h.settingsService.Get().PortRange()
+	c.JSON(http.StatusOK, gin.H{
+		"required":          true,
+		"minPasswordLength": services.MinPasswordLength,
+		"portRangeStart":    start,
+		"portRangeEnd":      end,
+		"diagnostics":       h.diagnostics.Run(ctx),
+	})
+}
+
+// Complete sets the password and port range, then logs the client in.
+func (h *SetupHandler) Complete(c *gin.Context) {
+	if !h.authService.NeedsSetup() {
+		setupGone(c)
+		return
+	}
+
+	var req SetupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password required"})
+		return
+	}
+	if len(req.Password) < services.MinPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("password must be at least %d characters", services.MinPasswordLength)})
+		return
+	}
+	if err := services.ValidatePortRange(req.PortRangeStart, req.PortRangeEnd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.authService.CompleteSetup(req.Password, func() error {
+		if req.PortRangeStart == 0 && req.PortRangeEnd == 0 {
+			return nil
+		}
+		settings := h.settingsService.Get()
+		settings.PortRangeStart = req.PortRangeStart
+		settings.PortRangeEnd = req.PortRangeEnd
+		_, err := h.settingsService.Update(settings)
+		return err
+	})
+	if errors.Is(err, services.ErrSetupComplete) {
+		setupGone(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete setup: " + err.Error()})
+		return
+	}
+
+	h.auth.startSession(c)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/logs"
+	"nas-controller/internal/models"
+	"nas-controller/internal/services"
+)
+
+// shareLogLines is how much container log a share snapshots.
+const shareLogLines = "1000"
+
+type ShareHandler struct {
+	shares       *services.ShareService
+	appManager   *services.AppManager
+	buildService *services.BuildService
+	dockerClient *docker.Client
+}
+
+func NewShareHandler(
+	shares *services.ShareService,
+	appManager *services.AppManager,
+	buildService *services.BuildService,
+	dockerClient *docker.Client,
+) *ShareHandler {
+	return &ShareHandler{
+		shares:       shares,
+		appManager:   appManager,
+		buildService: buildService,
+		dockerClient: dockerClient,
+	}
+}
+
+type createShareRequest struct {
+	Type      string `json:"type" binding:"required"`
+	ExpiresIn string `json:"expiresIn"`
+}
+
+func (h *ShareHandler) CreateShare(c *gin.Context) {
+	id := c.Param("id")
+
+	var req createShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var expiresIn time.Duration
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiresIn must be a positive duration such as 1h or 30m"})
+			return
+		}
+		expiresIn = d
+	}
+
+	app, err := h.appManager.GetApp(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+
+	var content string
+	switch req.Type {
+	case models.ShareBuildLog:
+		content, err = h.buildService.GetBuildLog(id)
+	case models.ShareContainerLog:
+		ctx, cancel := requestContext(c, readTimeout)
+		defer cancel()
+		content, err = h.containerLog(ctx, app)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be buildLog or containerLog"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	share, token, err := h.shares.Create(app, req.Type, content, expiresIn, actorOf(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"share": share,
+		"url":   "/share/" + token,
+	})
+}
+
+func (h *ShareHandler) containerLog(ctx context.Context, app *models.App) (string, error) {
+	if app.ContainerID == "" {
+		return "", nil
+	}
+	output, err := h.dockerClient.GetContainerLogs(ctx, app.ContainerID, shareLogLines)
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+
+	// Escape codes would be noise on the share page, and could split a
+	// secret so redaction misses it
+	data, err := logs.ReadAll(output, logs.Format{StripANSI: true})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (h *ShareHandler) ListShares(c *gin.Context) {
+	id := c.Param("id")
+
+	if _, err := h.appManager.GetApp(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+
+	shares, err := h.shares.List(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+func (h *ShareHandler) RevokeShare(c *gin.Context) {
+	id := c.Param("id")
+
+	found, err := h.shares.Revoke(id, c.Param("shareId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "share not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "share revoked"})
+}
+
+// ViewShare serves a share's snapshot as plain text. It needs no auth; the
+// token is the credential. Unknown and expired tokens look the same.
+func (h *ShareHandler) ViewShare(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Header("Referrer-Policy", "no-referrer")
+
+	_, content, err := h.shares.Open(c.Param("token"))
+	if err != nil {
+		c.String(http.StatusNotFound, "share not found or expired")
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(content))
+}
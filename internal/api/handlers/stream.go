@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Stream message types.
+const (
+	// StreamLog is a line of output; data is the line.
+	StreamLog = "log"
+	// StreamProgress is how far along the stream's operation is; data is
+	// {percent}.
+	StreamProgress = "progress"
+	// StreamError is a failure; data is {message, category, hint}.
+	StreamError = "error"
+	// StreamDone is the last message; data is {success} for operations
+	// that can fail (a build adds skipped, for one that had nothing to
+	// do), and null for a log stream whose source ended.
+	StreamDone = "done"
+)
+
+// StreamMessage is the envelope every message of a stream comes in when
+// the client asks for ?format=json. Seq counts from 1 per stream, so a
+// client can tell it missed none.
+type StreamMessage struct {
+	Type string      `json:"type"`
+	Seq  int64       `json:"seq"`
+	TS   time.Time   `json:"ts"`
+	Data interface{} `json:"data"`
+}
+
+// StreamErrorData is the data of a StreamError message.
+type StreamErrorData struct {
+	Message  string `json:"message"`
+	Category string `json:"category,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// streamWriter sends a stream's messages over a WebSocket, as
+// StreamMessage envelopes for clients that asked for them and as the plain
+// text lines the streams always sent otherwise.
+type streamWriter struct {
+	conn *websocket.Conn
+	json bool
+	seq  int64
+}
+
+// parseStreamFormat reads the format=text|json query option. Text, the
+// default, is what the streams sent before the envelope existed.
+func parseStreamFormat(c *gin.Context) (bool, error) {
+	switch c.Query("format") {
+	case "", "text":
+		return false, nil
+	case "json":
+		return true, nil
+	default:
+		return false, fmt.Errorf("format must be text or json")
+	}
+}
+
+func newStreamWriter(conn *websocket.Conn, asJSON bool) *streamWriter {
+	return &streamWriter{conn: conn, json: asJSON}
+}
+
+// Send writes one message: the envelope of kind and data, or text. Plain
+// text clients get nothing for an empty text, such as progress they never
+// had.
+func (w *streamWriter) Send(kind string, data interface{}, text string) error {
+	var message []byte
+	if w.json {
+		w.seq++
+		var err error
+		message, err = json.Marshal(StreamMessage{Type: kind, Seq: w.seq, TS: time.Now().UTC(), Data: data})
+		if err != nil {
+			return err
+		}
+	} else if text == "" {
+		return nil
+	} else {
+		message = []byte(text)
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(logStreamWriteWait))
+	return w.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// Log sends a line of output.
+func (w *streamWriter) Log(line string) error {
+	return w.Send(StreamLog, line, line)
+}
+
+// Error sends a failure, which plain text clients get as text.
+func (w *streamWriter) Error(data StreamErrorData, text string) error {
+	return w.Send(StreamError, data, text)
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"nas-controller/internal/docker/dockertest"
+	"nas-controller/internal/models"
+	"nas-controller/internal/services"
+)
+
+// decodedMessage is a StreamMessage as a client decodes it, with data left
+// for the message type to decode.
+type decodedMessage struct {
+	Type string          `json:"type"`
+	Seq  int64           `json:"seq"`
+	TS   time.Time       `json:"ts"`
+	Data json.RawMessage `json:"data"`
+}
+
+// readStream reads a stream's messages until it closes or, if asked for
+// envelopes, sends done. A stream cut short shows in checkEnvelopes.
+func readStream(t *testing.T, conn *websocket.Conn, asJSON bool) (texts []string, messages []decodedMessage) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return texts, messages
+		}
+		if !asJSON {
+			texts = append(texts, string(data))
+			continue
+		}
+		var m decodedMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatalf("not an envelope: %s", data)
+		}
+		messages = append(messages, m)
+		if m.Type == StreamDone {
+			return texts, messages
+		}
+	}
+}
+
+// checkEnvelopes checks what every stream's envelopes share: seq counting
+// from 1, a UTC timestamp from just now, a known type, and done last.
+func checkEnvelopes(t *testing.T, messages []decodedMessage, since time.Time) {
+	t.Helper()
+	if len(messages) == 0 || messages[len(messages)-1].Type != StreamDone {
+		t.Fatalf("stream didn't end with done: %+v", messages)
+	}
+	for i, m := range messages {
+		if m.Seq != int64(i+1) {
+			t.Errorf("message %d has seq %d", i, m.Seq)
+		}
+		if m.TS.Location() != time.UTC || m.TS.Before(since.Add(-time.Second)) || m.TS.After(time.Now().Add(time.Second)) {
+			t.Errorf("message %d sent at %s", i, m.TS)
+		}
+		switch m.Type {
+		case StreamLog, StreamProgress, StreamError:
+		case StreamDone:
+			if i != len(messages)-1 {
+				t.Errorf("done at %d of %d", i+1, len(messages))
+			}
+		default:
+			t.Errorf("message %d has type %q", i, m.Type)
+		}
+	}
+}
+
+func TestParseStreamFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		query   string
+		asJSON  bool
+		wantErr bool
+	}{
+		{query: "", asJSON: false},
+		{query: "?format=text", asJSON: false},
+		{query: "?format=json", asJSON: true},
+		{query: "?format=JSON", wantErr: true},
+		{query: "?format=sse", wantErr: true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/apps/a1b2c3d4/build/stream"+tt.query, nil)
+		asJSON, err := parseStreamFormat(c)
+		if (err != nil) != tt.wantErr || asJSON != tt.asJSON {
+			t.Errorf("parseStreamFormat(%q) = %v, %v", tt.query, asJSON, err)
+		}
+	}
+}
+
+// buildStreamServer serves StreamBuild for an uploaded app whose source is
+// a Dockerfile, building on fake.
+func buildStreamServer(t *testing.T, fake *dockertest.Fake) (string, *models.App) {
+	t.Helper()
+	h, db := newTestAppHandler(t, fake)
+	app := &models.App{
+		ID:             "a1b2c3d4",
+		Name:           "Demo",
+		Slug:           "demo",
+		SourceType:     models.SourceTypeUpload,
+		DockerfilePath: "Dockerfile",
+		BuildContext:   ".",
+		ImageName:      "nas-app-demo:latest",
+		ContainerName:  "nas-app-demo",
+		InternalPort:   8080,
+		ExternalPort:   13000,
+		Replicas:       1,
+		Status:         models.StatusStopped,
+	}
+	if err := db.CreateApp(app); err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(h.dataDir, "repos", "uploads", app.Slug)
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "Dockerfile"), []byte("FROM alpine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/apps/:id/build/stream", h.StreamBuild)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/apps/", app
+}
+
+func TestBuildStreamEnvelopes(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		url, app := buildStreamServer(t, dockertest.New())
+		since := time.Now()
+		conn, _, err := websocket.DefaultDialer.Dial(url+app.ID+"/build/stream?format=json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, messages := readStream(t, conn, true)
+		checkEnvelopes(t, messages, since)
+
+		var output strings.Builder
+		for _, m := range messages {
+			switch m.Type {
+			case StreamLog:
+				var line string
+				if err := json.Unmarshal(m.Data, &line); err != nil {
+					t.Fatalf("log data %s: %v", m.Data, err)
+				}
+				output.WriteString(line)
+			case StreamProgress:
+				var progress struct {
+					Percent *int `json:"percent"`
+				}
+				if err := json.Unmarshal(m.Data, &progress); err != nil || progress.Percent == nil || *progress.Percent < 0 || *progress.Percent > 100 {
+					t.Errorf("progress data %s: %v", m.Data, err)
+				}
+			case StreamError:
+				t.Errorf("error in a successful build: %s", m.Data)
+			}
+		}
+		if !strings.Contains(output.String(), "building "+app.ImageName) {
+			t.Errorf("build output missing from the log messages:\n%s", output.String())
+		}
+		var done struct {
+			Success *bool `json:"success"`
+			Skipped *bool `json:"skipped"`
+		}
+		if err := json.Unmarshal(messages[len(messages)-1].Data, &done); err != nil || done.Success == nil || !*done.Success || done.Skipped == nil || *done.Skipped {
+			t.Errorf("done data %s: %v", messages[len(messages)-1].Data, err)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		fake := dockertest.New()
+		fake.BuildErr = errors.New("dockerfile parse error line 1: unknown instruction: FORM")
+		url, app := buildStreamServer(t, fake)
+		since := time.Now()
+		conn, _, err := websocket.DefaultDialer.Dial(url+app.ID+"/build/stream?format=json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, messages := readStream(t, conn, true)
+		checkEnvelopes(t, messages, since)
+
+		var failures []StreamErrorData
+		for _, m := range messages {
+			if m.Type != StreamError {
+				continue
+			}
+			var data StreamErrorData
+			if err := json.Unmarshal(m.Data, &data); err != nil {
+				t.Fatalf("error data %s: %v", m.Data, err)
+			}
+			failures = append(failures, data)
+		}
+		if len(failures) != 1 || !strings.Contains(failures[0].Message, "unknown instruction: FORM") || failures[0].Category != services.BuildFailureSyntax || failures[0].Hint == "" {
+			t.Errorf("errors = %+v, want the parse error with its category and hint", failures)
+		}
+		var done struct {
+			Success *bool `json:"success"`
+		}
+		if err := json.Unmarshal(messages[len(messages)-1].Data, &done); err != nil || done.Success == nil || *done.Success {
+			t.Errorf("done data %s: %v", messages[len(messages)-1].Data, err)
+		}
+	})
+
+	t.Run("plain text", func(t *testing.T) {
+		fake := dockertest.New()
+		fake.BuildErr = errors.New("dockerfile parse error line 1: unknown instruction: FORM")
+		url, app := buildStreamServer(t, fake)
+		conn, _, err := websocket.DefaultDialer.Dial(url+app.ID+"/build/stream", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		texts, _ := readStream(t, conn, false)
+
+		if len(texts) == 0 || texts[len(texts)-1] != "\n\nBuild failed!" {
+			t.Fatalf("last message of %q, want Build failed!", texts)
+		}
+		var errorLines int
+		for _, text := range texts {
+			if json.Valid([]byte(text)) && strings.HasPrefix(text, "{") {
+				t.Errorf("envelope sent to a plain text client: %s", text)
+			}
+			if strings.HasPrefix(text, "ERROR: ") {
+				errorLines++
+			}
+		}
+		if errorLines != 1 {
+			t.Errorf("%d ERROR: lines in %q", errorLines, texts)
+		}
+	})
+
+	t.Run("missing app", func(t *testing.T) {
+		url, _ := buildStreamServer(t, dockertest.New())
+		conn, _, err := websocket.DefaultDialer.Dial(url+"missing/build/stream?format=json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m decodedMessage
+		var failure StreamErrorData
+		if err := json.Unmarshal(data, &m); err != nil || m.Type != StreamError || m.Seq != 1 || json.Unmarshal(m.Data, &failure) != nil || failure.Message != "app not found" {
+			t.Errorf("message = %s", data)
+		}
+	})
+}
+
+func TestLogStreamEnvelopes(t *testing.T) {
+	output := io.NopCloser(strings.NewReader("starting\n\x1b[32mready\x1b[0m\nno newline"))
+	url, done := serveLogs(t, output, true)
+	since := time.Now()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, messages := readStream(t, conn, true)
+	<-done
+	checkEnvelopes(t, messages, since)
+
+	var lines []string
+	for _, m := range messages[:len(messages)-1] {
+		var line string
+		if m.Type != StreamLog || json.Unmarshal(m.Data, &line) != nil {
+			t.Fatalf("message %d: %s %s", m.Seq, m.Type, m.Data)
+		}
+		lines = append(lines, line)
+	}
+	// Lines come as plain text would send them, newline trimmed
+	if want := []string{"starting", "\x1b[32mready\x1b[0m", "no newline"}; strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	// A log stream's source ending has no outcome
+	if data := string(messages[len(messages)-1].Data); data != "null" {
+		t.Errorf("done data = %s, want null", data)
+	}
+}
@@ -9,12 +9,16 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"nas-controller/internal/database"
 	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
 	"nas-controller/internal/services"
 )
 
@@ -23,28 +27,60 @@ const Version = "1.0.0"
 const defaultControllerRepo = "https://github.com/0HugoHu/Unraid-Docker-Controller.git"
 
 type SystemHandler struct {
-	dockerClient *docker.Client
-	buildService *services.BuildService
-	db           *database.DB
-	dataDir      string
+	appManager      *services.AppManager
+	dockerClient    *docker.Client
+	buildService    *services.BuildService
+	gitService      *services.GitService
+	settingsService *services.SettingsService
+	portAllocator   *services.PortAllocator
+	diagnostics     *services.DiagnosticsService
+	streams         *services.StreamLimiter
+	exitMonitor     *services.ExitMonitor
+	heartbeat       *services.Heartbeat
+	features        services.FeatureFlags
+	db              *database.DB
+	dataDir         string
+
+	// selfUpdateAvailable is the result of the last CheckSelfUpdate.
+	selfUpdateMu        sync.Mutex
+	selfUpdateAvailable bool
 }
 
 func NewSystemHandler(
+	appManager *services.AppManager,
 	dockerClient *docker.Client,
 	buildService *services.BuildService,
+	gitService *services.GitService,
+	settingsService *services.SettingsService,
+	portAllocator *services.PortAllocator,
+	diagnostics *services.DiagnosticsService,
+	streams *services.StreamLimiter,
+	exitMonitor *services.ExitMonitor,
+	heartbeat *services.Heartbeat,
+	features services.FeatureFlags,
 	db *database.DB,
 	dataDir string,
 ) *SystemHandler {
 	return &SystemHandler{
-		dockerClient: dockerClient,
-		buildService: buildService,
-		db:           db,
-		dataDir:      dataDir,
+		appManager:      appManager,
+		dockerClient:    dockerClient,
+		buildService:    buildService,
+		gitService:      gitService,
+		settingsService: settingsService,
+		portAllocator:   portAllocator,
+		diagnostics:     diagnostics,
+		streams:         streams,
+		exitMonitor:     exitMonitor,
+		heartbeat:       heartbeat,
+		features:        features,
+		db:              db,
+		dataDir:         dataDir,
 	}
 }
 
 func (h *SystemHandler) GetInfo(c *gin.Context) {
-	ctx := context.Background()
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
 
 	dockerInfo, _ := h.dockerClient.GetDockerInfo(ctx)
 
@@ -56,14 +92,45 @@ func (h *SystemHandler) GetInfo(c *gin.Context) {
 		}
 	}
 
+	streamTotal, streamsPerApp := h.streams.Counts()
+
+	// Failures from the last diagnostics run (at startup, or the last
+	// GET /system/diagnostics)
+	diagnostics := gin.H{}
+	if report := h.diagnostics.Last(); report != nil {
+		diagnostics["ranAt"] = report.RanAt
+		diagnostics["failures"] = report.Failures()
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"version":     Version,
 		"totalApps":   len(apps),
 		"runningApps": runningCount,
 		"docker":      dockerInfo,
+		"logStreams": gin.H{
+			"total":  streamTotal,
+			"perApp": streamsPerApp,
+		},
+		"git": h.gitService.Stats(),
+		// Docker exit events and how many were coalesced or dropped
+		"exitEvents": h.exitMonitor.Stats(),
+		// The last heartbeat and the health of what it checks
+		"heartbeat": h.heartbeat.Status(),
+		// The API feature areas this controller registered
+		"features": h.features.List(),
+		// Lets the frontend hide GPU options on hosts without one
+		"gpu": h.dockerClient.DetectGPU(ctx),
+		// Lets the frontend warn that an app's platform runs under emulation
+		"platform":    h.dockerClient.HostPlatform(ctx),
+		"diagnostics": diagnostics,
 	})
 }
 
+// GetDiagnostics runs the setup checks afresh.
+func (h *SystemHandler) GetDiagnostics(c *gin.Context) {
+	c.JSON(http.StatusOK, h.diagnostics.Run(c.Request.Context()))
+}
+
 func (h *SystemHandler) GetStorage(c *gin.Context) {
 	// Get database size
 	dbPath := filepath.Join(h.dataDir, "controller.db")
@@ -93,39 +160,184 @@ func (h *SystemHandler) GetStorage(c *gin.Context) {
 		imagesSize += app.ImageSize
 	}
 
+	// Container logs, largest first. Sizes are -1 when the log files on the
+	// host can't be read from the controller.
+	appNames := make(map[string]string, len(apps))
+	for _, app := range apps {
+		appNames[app.ID] = app.Name
+	}
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	containerLogs, _ := h.dockerClient.ContainerLogUsages(ctx)
+	sort.SliceStable(containerLogs, func(i, j int) bool {
+		return containerLogs[i].Bytes > containerLogs[j].Bytes
+	})
+	containerLogsSize := int64(0)
+	entries := make([]gin.H, 0, len(containerLogs))
+	for _, usage := range containerLogs {
+		if usage.Bytes > 0 {
+			containerLogsSize += usage.Bytes
+		}
+		entries = append(entries, gin.H{
+			"appId":     usage.AppID,
+			"appName":   appNames[usage.AppID],
+			"container": usage.ContainerName,
+			"replica":   usage.Replica,
+			"bytes":     usage.Bytes,
+			"maxSize":   usage.MaxSize,
+			"maxFiles":  usage.MaxFiles,
+		})
+	}
+
+	// BuildKit's build cache, which image sizes don't include, and how much
+	// of it a build cache prune would free
+	buildCacheSize, buildCacheReclaimable := int64(0), int64(0)
+	if cache, err := h.dockerClient.BuildCache(ctx); err == nil {
+		for _, entry := range cache {
+			buildCacheSize += entry.Size
+			if !entry.InUse {
+				buildCacheReclaimable += entry.Size
+			}
+		}
+	}
+
+	// Previous images kept as build cache sources, counting only what the
+	// current images don't share with them
+	previousImagesSize := h.appManager.PreviousImagesSize(ctx)
+
 	c.JSON(http.StatusOK, gin.H{
-		"database":    dbSize,
-		"repositories": reposSize,
-		"logs":        logsSize,
-		"images":      imagesSize,
-		"total":       dbSize + reposSize + logsSize + imagesSize,
+		"database":              dbSize,
+		"repositories":          reposSize,
+		"logs":                  logsSize,
+		"images":                imagesSize,
+		"previousImages":        previousImagesSize,
+		"buildCache":            buildCacheSize,
+		"buildCacheReclaimable": buildCacheReclaimable,
+		"containerLogs": gin.H{
+			"total":      containerLogsSize,
+			"containers": entries,
+		},
+		"total": dbSize + reposSize + logsSize + imagesSize + previousImagesSize + buildCacheSize + containerLogsSize,
 	})
 }
 
+// GetBuildCache lists the build cache entries, with the app and build
+// that created each where that can be told.
+func (h *SystemHandler) GetBuildCache(c *gin.Context) {
+	// Disk usage can be slow on a large cache
+	ctx, cancel := requestContext(c, actionTimeout)
+	defer cancel()
+
+	usage, err := h.buildService.BuildCacheUsage(ctx)
+	if err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
 func (h *SystemHandler) GetPorts(c *gin.Context) {
 	usedPorts, _ := h.db.GetUsedPorts()
+	sticky, _ := h.portAllocator.GetStickyPorts()
+	bindings, _ := h.appManager.PortBindings()
 
+	start, end := h.portAllocator.Range()
 	c.JSON(http.StatusOK, gin.H{
 		"usedPorts": usedPorts,
+		// The same ports with the app holding each, the address it's
+		// published on and whether over IPv4, IPv6 or both
+		"bindings": bindings,
+		"ipv6":     h.portAllocator.IPv6Supported(),
 		"range": gin.H{
-			"start": services.PortRangeStart,
-			"end":   services.PortRangeEnd,
+			"start": start,
+			"end":   end,
 		},
+		// New apps get their slug's sticky port when free, otherwise one
+		// picked from the range by strategy.
+		"strategy": h.portAllocator.Strategy(),
+		"sticky":   sticky,
 	})
 }
 
+func (h *SystemHandler) GetNetworks(c *gin.Context) {
+	ctx, cancel := requestContext(c, readTimeout)
+	defer cancel()
+	networks, err := h.dockerClient.ListNetworks(ctx)
+	if err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, networks)
+}
+
+// GetBuildQueue returns the running build, the builds waiting for their
+// turn and when apps were last held back by the build cooldown.
+func (h *SystemHandler) GetBuildQueue(c *gin.Context) {
+	c.JSON(http.StatusOK, h.buildService.QueueStatus())
+}
+
+// DequeueBuild removes an app's waiting build from the queue. The app goes
+// back to the status it had before the build was requested.
+func (h *SystemHandler) DequeueBuild(c *gin.Context) {
+	if err := h.buildService.Dequeue(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "build removed from the queue"})
+}
+
 func (h *SystemHandler) PruneImages(c *gin.Context) {
-	ctx := context.Background()
+	ctx, cancel := requestContext(c, pruneTimeout)
+	defer cancel()
 
+	// Expired previous images go first; the space they free is counted
+	// here, as Docker's prune no longer sees them
+	previousRemoved, previousFreed := h.appManager.ExpirePreviousImages(ctx)
 	reclaimed, err := h.dockerClient.PruneImages(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":        "images pruned",
+		"message":               "images pruned",
+		"spaceReclaimed":        reclaimed + uint64(previousFreed),
+		"previousImagesRemoved": previousRemoved,
+	})
+}
+
+// PruneBuildCache removes the unused build cache, whichever app or host
+// build made it. ?until=<duration> keeps what was used within that long,
+// e.g. until=24h.
+func (h *SystemHandler) PruneBuildCache(c *gin.Context) {
+	unusedFor := time.Duration(0)
+	if raw := c.Query("until"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until must be a duration such as 24h"})
+			return
+		}
+		unusedFor = d
+	}
+
+	ctx, cancel := requestContext(c, pruneTimeout)
+	defer cancel()
+	reclaimed, deleted, err := h.dockerClient.PruneBuildCache(ctx, nil, unusedFor)
+	if err != nil {
+		err = requestError(ctx, err)
+		c.JSON(errorStatus(err, http.StatusInternalServerError), errorBody(c, err))
+		return
+	}
+	log.Printf("Pruned build cache unused for %s: %d entries, %d bytes", unusedFor, deleted, reclaimed)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "build cache pruned",
 		"spaceReclaimed": reclaimed,
+		"entriesDeleted": deleted,
 	})
 }
 
@@ -138,6 +350,206 @@ func (h *SystemHandler) ClearAllLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "all logs cleared"})
 }
 
+// GetSettings returns the settings, with proxy credentials masked. The
+// read-only "timeouts" holds the operation timeouts in effect, defaults
+// filled in.
+func (h *SystemHandler) GetSettings(c *gin.Context) {
+	settings := h.settingsService.Get()
+	c.JSON(http.StatusOK, struct {
+		services.Settings
+		Timeouts services.Timeouts `json:"timeouts"`
+	}{settings.Masked(), settings.Timeouts()})
+}
+
+func (h *SystemHandler) UpdateSettings(c *gin.Context) {
+	current := h.settingsService.Get()
+	settings := current
+	// Decoding into the current map would merge into it (and change the
+	// stored settings in place), so globalEnv is replaced, or kept if
+	// the request leaves it out.
+	settings.GlobalEnv = nil
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if settings.GlobalEnv == nil {
+		settings.GlobalEnv = current.GlobalEnv
+	}
+	settings.KeepMaskedProxies(current)
+
+	switch settings.PortStrategy {
+	case "", services.PortStrategySequential, services.PortStrategyRandom:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "portStrategy must be sequential or random"})
+		return
+	}
+
+	if settings.MaxLogStreams < 0 || settings.MaxLogStreamsPerApp < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "log stream limits cannot be negative"})
+		return
+	}
+
+	if settings.MaxBuildLogMB < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxBuildLogMB cannot be negative"})
+		return
+	}
+
+	if settings.BuildHistoryLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "buildHistoryLimit cannot be negative"})
+		return
+	}
+
+	if settings.PreviousImageDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "previousImageDays cannot be negative"})
+		return
+	}
+
+	if settings.ImageTagsKeep < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "imageTagsKeep cannot be negative"})
+		return
+	}
+
+	if settings.UpdateCheckHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "updateCheckHours cannot be negative"})
+		return
+	}
+
+	if err := services.ValidateExternalBaseURL(settings.ExternalBaseURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidateContainerPrefix(settings.ContainerPrefix); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidateSharedNetwork(settings.SharedNetwork); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidateConfirmActions(settings.ConfirmActions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidatePortRange(settings.PortRangeStart, settings.PortRangeEnd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidateContainerUser(settings.DefaultUser); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidateBindMountSettings(settings.BindMountPrefixes, settings.BindMountOwner); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidateBindAddress(settings.BindAddress); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ValidateFeatures(settings.DisabledFeatures); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if settings.AppdataDir != "" && !strings.HasPrefix(settings.AppdataDir, "/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "appdataDir must be an absolute path"})
+		return
+	}
+
+	if err := services.ValidateLogOptions(settings.LogMaxSize, settings.LogMaxFiles); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for name, proxy := range map[string]string{"httpProxy": settings.HTTPProxy, "httpsProxy": settings.HTTPSProxy} {
+		if err := services.ValidateProxyURL(name, proxy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if settings.BuildTimeoutMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "buildTimeoutMinutes cannot be negative"})
+		return
+	}
+
+	for name, value := range map[string]int{
+		"gitOperationTimeoutMinutes":  settings.GitOperationTimeoutMinutes,
+		"dockerRequestTimeoutSeconds": settings.DockerRequestTimeoutSeconds,
+		"containerStopTimeoutSeconds": settings.ContainerStopTimeoutSeconds,
+		"selfUpdateTimeoutMinutes":    settings.SelfUpdateTimeoutMinutes,
+	} {
+		if value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": name + " cannot be negative"})
+			return
+		}
+	}
+
+	if settings.PromotionWindowMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "promotionWindowMinutes cannot be negative"})
+		return
+	}
+
+	if settings.MetricsRetentionHours < 0 || settings.MetricsRetentionHours > services.MaxMetricsRetentionHours {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("metricsRetentionHours must be between 0 and %d", services.MaxMetricsRetentionHours)})
+		return
+	}
+
+	if settings.BuildCooldownSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "buildCooldownSeconds cannot be negative"})
+		return
+	}
+
+	if settings.ExitFlapSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exitFlapSeconds cannot be negative"})
+		return
+	}
+
+	if err := services.ValidateGlobalEnv(settings.GlobalEnv, settings.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	changes, err := h.settingsService.Update(settings)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{
+		"settings":        settings.Masked(),
+		"applied":         changes.Applied,
+		"restartRequired": changes.RestartRequired,
+	}
+	for _, name := range changes.Applied {
+		if name == "globalEnv" || name == "timezone" {
+			// Running apps only get the new environment when restarted
+			ctx, cancel := requestContext(c, readTimeout)
+			defer cancel()
+			resp["staleEnvApps"], _ = h.appManager.StaleEnvApps(ctx)
+			break
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// CheckUpdates checks every git app's remote for new commits now, instead
+// of waiting for the background poller, and returns the totals and each
+// app's result. The sweep carries on if the client goes away.
+func (h *SystemHandler) CheckUpdates(c *gin.Context) {
+	ctx, cancel := actionContext(c, actionTimeout)
+	defer cancel()
+	c.JSON(http.StatusOK, h.appManager.SweepUpdates(ctx, true))
+}
+
 func (h *SystemHandler) CheckSelfUpdate(c *gin.Context) {
 	var req struct {
 		RepoURL string `json:"repoUrl"`
@@ -152,7 +564,7 @@ func (h *SystemHandler) CheckSelfUpdate(c *gin.Context) {
 		req.Branch = "main"
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), h.settingsService.Get().Timeouts().GitOperation)
 	defer cancel()
 
 	srcDir := filepath.Join(h.dataDir, "controller-src")
@@ -160,7 +572,7 @@ func (h *SystemHandler) CheckSelfUpdate(c *gin.Context) {
 	if _, err := os.Stat(filepath.Join(srcDir, ".git")); os.IsNotExist(err) {
 		// No local source yet — clone it to check
 		os.RemoveAll(srcDir)
-		cmd := exec.CommandContext(ctx, "git", "clone", "--branch", req.Branch, "--depth", "1", req.RepoURL, srcDir)
+		cmd := h.withProxy(exec.CommandContext(ctx, "git", "clone", "--branch", req.Branch, "--depth", "1", req.RepoURL, srcDir))
 		if output, err := cmd.CombinedOutput(); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("git clone failed: %s", string(output))})
 			return
@@ -194,7 +606,7 @@ func (h *SystemHandler) CheckSelfUpdate(c *gin.Context) {
 	localCommit := strings.TrimSpace(string(localOut))
 
 	// Fetch remote
-	cmd = exec.CommandContext(ctx, "git", "-C", srcDir, "fetch", "origin", req.Branch)
+	cmd = h.withProxy(exec.CommandContext(ctx, "git", "-C", srcDir, "fetch", "origin", req.Branch))
 	if output, err := cmd.CombinedOutput(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("git fetch failed: %s", string(output))})
 		return
@@ -218,6 +630,8 @@ func (h *SystemHandler) CheckSelfUpdate(c *gin.Context) {
 		remoteShort = remoteShort[:8]
 	}
 
+	h.setSelfUpdateAvailable(localCommit != remoteCommit)
+
 	c.JSON(http.StatusOK, gin.H{
 		"hasUpdate":    localCommit != remoteCommit,
 		"localCommit":  localShort,
@@ -225,6 +639,82 @@ func (h *SystemHandler) CheckSelfUpdate(c *gin.Context) {
 	})
 }
 
+// withProxy runs a self-update git command through the proxy settings.
+func (h *SystemHandler) withProxy(cmd *exec.Cmd) *exec.Cmd {
+	cmd.Env = os.Environ()
+	for name, value := range h.settingsService.Get().ProxyEnv() {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	return cmd
+}
+
+func (h *SystemHandler) setSelfUpdateAvailable(available bool) {
+	h.selfUpdateMu.Lock()
+	defer h.selfUpdateMu.Unlock()
+	h.selfUpdateAvailable = available
+}
+
+// GetSummary is the one cheap call for dashboards and widgets. Everything
+// comes from the database and in-memory caches; it never calls Docker or
+// git, so numbers are as fresh as the last reconcile or update check.
+func (h *SystemHandler) GetSummary(c *gin.Context) {
+	apps, err := h.db.GetAllApps()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	byStatus := make(map[models.AppStatus]int)
+	for _, app := range apps {
+		byStatus[app.Status]++
+	}
+
+	build := gin.H{"running": false}
+	if appID, percent, building := h.buildService.CurrentBuild(); building {
+		build = gin.H{"running": true, "appId": appID, "percent": percent}
+	}
+	build["queued"] = len(h.buildService.QueueStatus().Waiting)
+
+	rangeStart, rangeEnd := h.portAllocator.Range()
+	totalPorts := rangeEnd - rangeStart + 1
+	usedPorts, _ := h.db.GetUsedPorts()
+	inRange := 0
+	for _, port := range usedPorts {
+		if port >= rangeStart && port <= rangeEnd {
+			inRange++
+		}
+	}
+
+	disk := gin.H{}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(h.dataDir, &fs); err == nil {
+		disk["free"] = uint64(fs.Bavail) * uint64(fs.Bsize)
+		disk["total"] = uint64(fs.Blocks) * uint64(fs.Bsize)
+	}
+
+	h.selfUpdateMu.Lock()
+	selfUpdate := h.selfUpdateAvailable
+	h.selfUpdateMu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"apps": gin.H{
+			"total":    len(apps),
+			"byStatus": byStatus,
+		},
+		"updatesAvailable": h.appManager.CachedUpdateCount(),
+		"build":            build,
+		"ports": gin.H{
+			"total":     totalPorts,
+			"remaining": totalPorts - inRange,
+		},
+		"disk": disk,
+		"controller": gin.H{
+			"version":         Version,
+			"updateAvailable": selfUpdate,
+		},
+	})
+}
+
 func (h *SystemHandler) SelfUpdate(c *gin.Context) {
 	var req struct {
 		RepoURL string `json:"repoUrl"`
@@ -239,14 +729,14 @@ func (h *SystemHandler) SelfUpdate(c *gin.Context) {
 		req.Branch = "main"
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), h.settingsService.Get().Timeouts().SelfUpdate)
 	defer cancel()
 
 	// Step 1: Clone or pull the controller source
 	srcDir := filepath.Join(h.dataDir, "controller-src")
 	if _, err := os.Stat(filepath.Join(srcDir, ".git")); err == nil {
 		exec.CommandContext(ctx, "git", "-C", srcDir, "remote", "set-url", "origin", req.RepoURL).Run()
-		cmd := exec.CommandContext(ctx, "git", "-C", srcDir, "fetch", "origin", req.Branch)
+		cmd := h.withProxy(exec.CommandContext(ctx, "git", "-C", srcDir, "fetch", "origin", req.Branch))
 		if output, err := cmd.CombinedOutput(); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("git fetch failed: %s", string(output))})
 			return
@@ -258,7 +748,7 @@ func (h *SystemHandler) SelfUpdate(c *gin.Context) {
 		}
 	} else {
 		os.RemoveAll(srcDir)
-		cmd := exec.CommandContext(ctx, "git", "clone", "--branch", req.Branch, "--depth", "1", req.RepoURL, srcDir)
+		cmd := h.withProxy(exec.CommandContext(ctx, "git", "clone", "--branch", req.Branch, "--depth", "1", req.RepoURL, srcDir))
 		if output, err := cmd.CombinedOutput(); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("git clone failed: %s", string(output))})
 			return
@@ -268,7 +758,7 @@ func (h *SystemHandler) SelfUpdate(c *gin.Context) {
 	// Step 2: Build new image
 	imageName := "nas-controller:latest"
 	log.Printf("Self-update: building new image %s from %s", imageName, srcDir)
-	if err := h.dockerClient.BuildImage(ctx, srcDir, "./Dockerfile", imageName, nil, io.Discard); err != nil {
+	if err := h.dockerClient.BuildImage(ctx, srcDir, "./Dockerfile", imageName, h.settingsService.Get().ProxyEnv(), "", docker.BuildOptions{}, io.Discard); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("image build failed: %v", err)})
 		return
 	}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds on the Docker work a request waits for, so a wedged daemon fails
+// the request instead of hanging it and the goroutines behind it. Single
+// Docker calls are also bounded by the client's request timeout; these
+// cover handlers that make many, or read a stream. Variables so tests can
+// shorten them.
+var (
+	// readTimeout bounds lookups: app details, logs, system info.
+	readTimeout = 15 * time.Second
+	// actionTimeout bounds a start, stop or restart, which can pull an
+	// image and wait out a stop timeout.
+	actionTimeout = 5 * time.Minute
+	// pruneTimeout bounds an image prune, which walks every image.
+	pruneTimeout = 10 * time.Minute
+)
+
+// requestContext is the request's context bounded by timeout. It also
+// ends when the client goes away.
+func requestContext(c *gin.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.Request.Context(), timeout)
+}
+
+// actionContext is detachedContext bounded by timeout: the action carries
+// on when the client goes away, but not forever.
+func actionContext(c *gin.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(detachedContext(c), timeout)
+}
+
+// timeoutError is an error from an operation whose context ran out, which
+// errorStatus answers with 504.
+type timeoutError struct {
+	err error
+}
+
+func (e *timeoutError) Error() string { return e.err.Error() }
+
+func (e *timeoutError) Unwrap() []error { return []error{e.err, context.DeadlineExceeded} }
+
+// requestError marks err as a timeout when ctx ran out. Errors that were
+// wrapped on the way up no longer say so themselves.
+func requestError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, context.DeadlineExceeded) {
+		return &timeoutError{err: err}
+	}
+	return err
+}
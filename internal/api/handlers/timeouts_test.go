@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/docker/dockertest"
+	"nas-controller/internal/models"
+)
+
+// errDaemonHung is what the hung daemon's calls fail with once their
+// context ends. It doesn't wrap the context's error, like the errors
+// callers build on the way up.
+var errDaemonHung = errors.New("daemon stopped answering")
+
+// hungDocker is a Docker daemon that accepts calls and never answers them.
+type hungDocker struct {
+	*dockertest.Fake
+}
+
+func (hungDocker) hang(ctx context.Context) error {
+	<-ctx.Done()
+	return errDaemonHung
+}
+
+func (d hungDocker) CreateContainer(ctx context.Context, spec docker.ContainerSpec) (string, error) {
+	return "", d.hang(ctx)
+}
+
+func (d hungDocker) StartContainer(ctx context.Context, containerID string) error {
+	return d.hang(ctx)
+}
+
+func (d hungDocker) StopContainer(ctx context.Context, containerID string) error {
+	return d.hang(ctx)
+}
+
+func (d hungDocker) RemoveContainer(ctx context.Context, containerID string, force bool) error {
+	return d.hang(ctx)
+}
+
+func (d hungDocker) GetAppContainer(ctx context.Context, appID string, replica int) (*types.Container, error) {
+	return nil, d.hang(ctx)
+}
+
+func (d hungDocker) GetContainerByName(ctx context.Context, name string) (*types.Container, error) {
+	return nil, d.hang(ctx)
+}
+
+func (d hungDocker) GetContainersOnPort(ctx context.Context, port int) ([]*types.Container, error) {
+	return nil, d.hang(ctx)
+}
+
+func (d hungDocker) GetContainerStatus(ctx context.Context, containerID string) (string, string, error) {
+	return "", "", d.hang(ctx)
+}
+
+func (d hungDocker) InspectImage(ctx context.Context, ref string) (*docker.ImageDetails, error) {
+	return nil, d.hang(ctx)
+}
+
+var shortenTimeouts sync.Once
+
+// shortenRequestTimeouts makes the handlers give up on Docker within a
+// test. Like the log stream liveness, they stay short for the package.
+func shortenRequestTimeouts() {
+	shortenTimeouts.Do(func() {
+		readTimeout, actionTimeout, pruneTimeout = 100*time.Millisecond, 200*time.Millisecond, 200*time.Millisecond
+	})
+}
+
+func TestHungDockerAnswers504(t *testing.T) {
+	shortenRequestTimeouts()
+	runtime := hungDocker{Fake: dockertest.New()}
+	h, db := newTestAppHandler(t, runtime)
+	router := gin.New()
+	router.POST("/api/v1/apps/:id/stop", h.StopApp)
+	router.POST("/api/v1/apps/:id/restart", h.RestartApp)
+	router.POST("/api/v1/apps/:id/start", h.StartApp)
+
+	app := &models.App{
+		ID:            "a1b2c3d4",
+		Name:          "Demo",
+		Slug:          "demo",
+		ImageName:     "nas-app-demo:latest",
+		ContainerName: "nas-app-demo",
+		ContainerID:   "3f2a9c1e7b44",
+		InternalPort:  8080,
+		ExternalPort:  13000,
+		Replicas:      1,
+		Status:        models.StatusRunning,
+	}
+	if err := db.CreateApp(app); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status models.AppStatus
+	}{
+		{"stop", http.MethodPost, "/api/v1/apps/a1b2c3d4/stop", models.StatusRunning},
+		{"restart", http.MethodPost, "/api/v1/apps/a1b2c3d4/restart", models.StatusRunning},
+		{"start", http.MethodPost, "/api/v1/apps/a1b2c3d4/start", models.StatusStopped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.Status = tt.status
+			if err := db.UpdateApp(app); err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			elapsed := time.Since(start)
+
+			if w.Code != http.StatusGatewayTimeout {
+				t.Fatalf("status = %d, want 504: %s", w.Code, w.Body)
+			}
+			var body struct {
+				Error string `json:"error"`
+				Code  string `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != docker.CodeTimeout {
+				t.Errorf("code = %q, want %s", body.Code, docker.CodeTimeout)
+			}
+			if elapsed > 2*time.Second {
+				t.Errorf("answered after %s", elapsed)
+			}
+		})
+		if tt.name == "stop" {
+			// A stop that timed out doesn't claim the app stopped
+			current, err := db.GetApp(app.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current.Status == models.StatusStopped || current.ContainerID == "" {
+				t.Errorf("app %s with container %q after a stop that never happened", current.Status, current.ContainerID)
+			}
+		}
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-expired.Done()
+	cancelled, cancel2 := context.WithCancel(context.Background())
+	cancel2()
+
+	wrapped := fmt.Errorf("failed to start container: %v", errDaemonHung)
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		err         error
+		wantTimeout bool
+	}{
+		{"no error", expired, nil, false},
+		{"error before the deadline", context.Background(), wrapped, false},
+		{"error after the deadline", expired, wrapped, true},
+		{"deadline already in the chain", expired, fmt.Errorf("stop: %w", context.DeadlineExceeded), true},
+		// The client left; that's not the daemon timing out
+		{"cancelled", cancelled, wrapped, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := requestError(tt.ctx, tt.err)
+			if got := errors.Is(err, context.DeadlineExceeded); got != tt.wantTimeout {
+				t.Errorf("timeout = %v, want %v (%v)", got, tt.wantTimeout, err)
+			}
+			if tt.err != nil && err.Error() != tt.err.Error() {
+				t.Errorf("message = %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
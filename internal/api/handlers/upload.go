@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/models"
+	"nas-controller/internal/services"
+)
+
+// UploadApp creates an app from an uploaded build context. The body is
+// multipart: a "context" part with the tar.gz and an optional "config" part
+// holding the same JSON as CreateApp's config (name is required). Parts may
+// come in either order; the tarball is streamed to disk.
+func (h *AppHandler) UploadApp(c *gin.Context) {
+	staged, config, err := h.readUpload(c)
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	app, err := h.appManager.CreateUploadedApp(c.Request.Context(), staged, config)
+	if err != nil {
+		c.JSON(errorStatus(err, http.StatusBadRequest), errorBody(c, err))
+		return
+	}
+
+	go h.buildAndStart(detachedContext(c), app)
+
+	c.JSON(http.StatusCreated, app)
+}
+
+// ReplaceUpload replaces an uploaded app's build context. The next build
+// uses it; nothing is rebuilt here.
+func (h *AppHandler) ReplaceUpload(c *gin.Context) {
+	staged, _, err := h.readUpload(c)
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	app, err := h.appManager.ReplaceUpload(c.Param("id"), staged)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, app)
+}
+
+func (h *AppHandler) readUpload(c *gin.Context) (string, *models.ConfigureAppRequest, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxUploadBytes)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return "", nil, fmt.Errorf("expected a multipart upload with a \"context\" part")
+	}
+
+	config := &models.ConfigureAppRequest{}
+	staged := ""
+	discard := func() {
+		if staged != "" {
+			h.appManager.DiscardUpload(staged)
+		}
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			discard()
+			return "", nil, fmt.Errorf("failed to read upload: %w", err)
+		}
+
+		switch part.FormName() {
+		case "config":
+			if err := json.NewDecoder(io.LimitReader(part, 1<<20)).Decode(config); err != nil {
+				discard()
+				return "", nil, fmt.Errorf("invalid config: %v", err)
+			}
+		case "context":
+			if staged != "" {
+				discard()
+				return "", nil, fmt.Errorf("only one \"context\" part is allowed")
+			}
+			staged, err = h.appManager.ReceiveUpload(part)
+			if err != nil {
+				return "", nil, err
+			}
+		}
+		part.Close()
+	}
+
+	if staged == "" {
+		return "", nil, fmt.Errorf("missing \"context\" part with the build context tarball")
+	}
+	return staged, config, nil
+}
+
+func uploadErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/docker/dockertest"
+	"nas-controller/internal/models"
+)
+
+var updateShapes = flag.Bool("update", false, "rewrite testdata/v1 from the current responses")
+
+// jsonShape flattens a decoded JSON document to "path: type" lines, one
+// per field. Array elements share the path name[], so every element must
+// fit the same shape.
+func jsonShape(prefix string, v any, shape map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		shape[prefix] = "object"
+		for key, value := range v {
+			jsonShape(strings.TrimPrefix(prefix+"."+key, "."), value, shape)
+		}
+	case []any:
+		shape[prefix] = "array"
+		for _, value := range v {
+			jsonShape(prefix+"[]", value, shape)
+		}
+	case string:
+		shape[prefix] = "string"
+	case float64:
+		shape[prefix] = "number"
+	case bool:
+		shape[prefix] = "bool"
+	case nil:
+		if _, ok := shape[prefix]; !ok {
+			shape[prefix] = "null"
+		}
+	}
+}
+
+func formatShape(shape map[string]string) string {
+	paths := make([]string, 0, len(shape))
+	for path := range shape {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, path := range paths {
+		kind := shape[path]
+		if path == "" {
+			path = "."
+		}
+		fmt.Fprintf(&b, "%s: %s\n", path, kind)
+	}
+	return b.String()
+}
+
+func parseShape(data string) map[string]string {
+	shape := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		path, kind, _ := strings.Cut(line, ": ")
+		if path == "." {
+			path = ""
+		}
+		shape[path] = kind
+	}
+	return shape
+}
+
+// newV1TestServer serves the v1 app routes over a fake Docker, holding one
+// running app.
+func newV1TestServer(t *testing.T) (http.Handler, *models.App) {
+	t.Helper()
+	fake := dockertest.New()
+	h, db := newTestAppHandler(t, fake)
+
+	pulled := time.Date(2026, time.October, 1, 12, 0, 0, 0, time.UTC)
+	app := &models.App{
+		ID:             "a1b2c3d4",
+		Name:           "Demo",
+		Slug:           "demo",
+		Description:    "A demo app",
+		Icon:           "/api/v1/apps/a1b2c3d4/icon",
+		SourceType:     "github",
+		RepoURL:        "https://github.com/acme/demo",
+		Branch:         "main",
+		LastCommit:     "9568545a",
+		LastPulled:     &pulled,
+		DockerfilePath: "Dockerfile",
+		BuildContext:   ".",
+		BuildArgs:      map[string]string{"VERSION": "1"},
+		ImageName:      "nas-app-demo:latest",
+		ContainerName:  "nas-app-demo",
+		InternalPort:   8080,
+		ExternalPort:   13000,
+		RestartPolicy:  "unless-stopped",
+		NetworkMode:    models.NetworkModeBridge,
+		Replicas:       1,
+		ReplicaPorts:   []int{},
+		Env:            map[string]string{"TZ": "UTC"},
+		Volumes:        []string{"/mnt/user/appdata/demo:/data"},
+		Devices:        []string{},
+		Labels:         map[string]string{"team": "home"},
+		Ulimits:        []models.Ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}},
+		Status:         models.StatusRunning,
+		Health:         docker.HealthNone,
+		LastBuild:      &pulled,
+	}
+	app.ContainerID = fake.AddContainer(docker.ContainerSpec{Name: app.ContainerName, Image: app.ImageName})
+	if err := db.CreateApp(app); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/apps", h.ListApps)
+	router.GET("/api/v1/apps/:id", h.GetApp)
+	return router, app
+}
+
+// TestV1ResponseShapes pins the JSON shapes of v1 responses to
+// testdata/v1. Adding a field is compatible; removing or renaming one, or
+// changing its type, breaks v1 clients and belongs in a v2 route instead.
+// Run with -update after an intended addition.
+func TestV1ResponseShapes(t *testing.T) {
+	router, app := newV1TestServer(t)
+
+	tests := []struct {
+		golden string
+		path   string
+		status int
+	}{
+		{"list_apps", "/api/v1/apps", http.StatusOK},
+		{"list_apps_summary", "/api/v1/apps?view=summary", http.StatusOK},
+		{"get_app", "/api/v1/apps/" + app.ID, http.StatusOK},
+		{"get_app_not_found", "/api/v1/apps/missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var body any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			jsonShape("", body, got)
+
+			golden := filepath.Join("testdata", "v1", tt.golden+".shape")
+			if *updateShapes {
+				if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, []byte(formatShape(got)), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			data, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for path, kind := range parseShape(string(data)) {
+				switch current, ok := got[path]; {
+				case !ok:
+					t.Errorf("%s: missing, v1 clients read it", path)
+				// null says nothing about the type a field has when set
+				case kind != "null" && current != "null" && current != kind:
+					t.Errorf("%s: %s, v1 sends %s", path, current, kind)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONShape(t *testing.T) {
+	var body any
+	json.Unmarshal([]byte(`{"app":{"id":"x","ports":[1,2],"env":{},"lastPulled":null},"ok":true,"items":[{"a":1},{"b":"2"}]}`), &body)
+	got := make(map[string]string)
+	jsonShape("", body, got)
+	want := ". object\napp object\napp.env object\napp.id string\napp.lastPulled null\napp.ports array\napp.ports[] number\nitems array\nitems[] object\nitems[].a number\nitems[].b string\nok bool\n"
+	if formatted := strings.ReplaceAll(formatShape(got), ": ", " "); formatted != want {
+		t.Errorf("shape =\n%s\nwant\n%s", formatted, want)
+	}
+	if fmt.Sprint(parseShape(formatShape(got))) != fmt.Sprint(got) {
+		t.Error("shape doesn't survive a round trip")
+	}
+}
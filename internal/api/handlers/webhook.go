@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/services"
+)
+
+// webhookMaxBody bounds a delivery's body; GitHub caps payloads at 25 MB,
+// but a push's is a fraction of that.
+const webhookMaxBody = 10 << 20
+
+type WebhookHandler struct {
+	appManager      *services.AppManager
+	buildService    *services.BuildService
+	settingsService *services.SettingsService
+}
+
+func NewWebhookHandler(appManager *services.AppManager, buildService *services.BuildService, settingsService *services.SettingsService) *WebhookHandler {
+	return &WebhookHandler{
+		appManager:      appManager,
+		buildService:    buildService,
+		settingsService: settingsService,
+	}
+}
+
+// webhookPath is where the app's webhook takes deliveries.
+func webhookPath(appID string) string {
+	return "/api/v1/webhooks/" + url.PathEscape(appID)
+}
+
+// webhookResponse is the app's webhook info with where to point the
+// provider at: the path, and the full URL when the controller knows its
+// external base URL.
+func (h *WebhookHandler) webhookResponse(appID string, info *services.WebhookInfo) gin.H {
+	resp := gin.H{"enabled": info.Enabled}
+	if !info.Enabled {
+		return resp
+	}
+	resp["createdAt"] = info.CreatedAt
+	resp["path"] = webhookPath(appID)
+	if base := strings.TrimRight(h.settingsService.Get().ExternalBaseURL, "/"); base != "" {
+		resp["url"] = base + webhookPath(appID)
+	}
+	return resp
+}
+
+// GetWebhook says whether the app has a webhook, and where; the secret is
+// only shown when it is generated.
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	id := c.Param("id")
+	info, err := h.appManager.Webhook(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "app not found"})
+		return
+	}
+	c.JSON(http.StatusOK, h.webhookResponse(id, info))
+}
+
+// EnableWebhook generates the app's webhook secret, or rotates it if it
+// already had one; deliveries signed with the old one are refused.
+func (h *WebhookHandler) EnableWebhook(c *gin.Context) {
+	id := c.Param("id")
+	secret, err := h.appManager.EnableWebhook(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	info, err := h.appManager.Webhook(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := h.webhookResponse(id, info)
+	resp["secret"] = secret
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *WebhookHandler) DisableWebhook(c *gin.Context) {
+	if err := h.appManager.DisableWebhook(c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": false})
+}
+
+// Deliver takes a push delivery from GitHub or GitLab without a session;
+// the signature or token is the credential. A push to the app's branch
+// starts a pull and rebuild and is answered 202 straight away; the build
+// is recorded with the webhook as its initiator. Other events and pushes
+// to other branches are acknowledged and ignored.
+func (h *WebhookHandler) Deliver(c *gin.Context) {
+	id := c.Param("appId")
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, webhookMaxBody))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "payload too large"})
+		return
+	}
+
+	provider, credential, event := services.WebhookGitHub, c.GetHeader("X-Hub-Signature-256"), c.GetHeader("X-GitHub-Event")
+	if credential == "" {
+		provider, credential, event = services.WebhookGitLab, c.GetHeader("X-Gitlab-Token"), c.GetHeader("X-Gitlab-Event")
+	}
+	app, err := h.appManager.VerifyWebhook(id, provider, credential, body)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrWebhookUnauthorized) {
+			status = http.StatusUnauthorized
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case provider == services.WebhookGitHub && event == "ping":
+		c.JSON(http.StatusOK, gin.H{"message": "pong"})
+		return
+	case provider == services.WebhookGitHub && event != "push",
+		provider == services.WebhookGitLab && event != "Push Hook":
+		c.JSON(http.StatusOK, gin.H{"message": "ignored " + event + " event"})
+		return
+	}
+
+	push, err := services.ParseWebhookPush(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if push.Branch != app.Branch {
+		c.JSON(http.StatusOK, gin.H{"message": "ignored push to a branch other than " + app.Branch})
+		return
+	}
+	if push.Commit == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "ignored deletion of " + app.Branch})
+		return
+	}
+
+	// The rebuild waits its turn in the build queue, and supersedes one of
+	// the app's already waiting
+	building, _, ok := h.buildService.CurrentBuild()
+	queued := (ok && building != app.ID) || h.buildService.Queued(app.ID)
+	ctx := services.WithCorrelationID(context.Background(), services.CorrelationID(c.Request.Context()))
+	ctx = services.WithInitiator(ctx, services.InitiatorWebhook, provider)
+	go func() {
+		h.appManager.PullAndRebuild(ctx, app.ID, nil)
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "pull and rebuild started",
+		"queued":        queued,
+		"commit":        push.Commit,
+		"correlationId": services.CorrelationID(ctx),
+	})
+}
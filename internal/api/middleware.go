@@ -1,25 +1,50 @@
 package api
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"nas-controller/internal/api/handlers"
 	"nas-controller/internal/database"
+	"nas-controller/internal/models"
+	"nas-controller/internal/services"
 )
 
 type AuthMiddleware struct {
-	db *database.DB
+	db     *database.DB
+	guests *services.GuestService
 }
 
-func NewAuthMiddleware(db *database.DB) *AuthMiddleware {
-	return &AuthMiddleware{db: db}
+func NewAuthMiddleware(db *database.DB, guests *services.GuestService) *AuthMiddleware {
+	return &AuthMiddleware{db: db, guests: guests}
 }
 
+// guestRoutes are the only routes a guest session may use, each with the
+// guest permission it needs ("" just needs access to the app).
+var guestRoutes = map[string]string{
+	"GET /api/v1/apps/:id":             "",
+	"GET /api/v1/apps/:id/icon":        "",
+	"POST /api/v1/apps/:id/start":      models.GuestStart,
+	"POST /api/v1/apps/:id/stop":       models.GuestStop,
+	"GET /api/v1/apps/:id/logs":        models.GuestLogs,
+	"GET /api/v1/apps/:id/logs/stream": models.GuestLogs,
+	"GET /api/v2/apps/:id":             "",
+}
+
+// CSRFHeader carries the per-session CSRF token on cookie-authenticated
+// state-changing requests.
+const CSRFHeader = "X-CSRF-Token"
+
 func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check session cookie
 		token, err := c.Cookie("session")
+		fromCookie := err == nil && token != ""
 		if err != nil {
 			// Also check Authorization header
 			authHeader := c.GetHeader("Authorization")
@@ -38,10 +63,78 @@ func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
 			return
 		}
 
+		// Browsers attach the cookie to cross-site requests on their own, so
+		// cookie-authenticated mutations must also prove same-origin by
+		// echoing the session's CSRF token. Bearer tokens can't be forged
+		// that way and are exempt.
+		if fromCookie && !isSafeMethod(c.Request.Method) {
+			expected, err := m.db.GetSessionCSRFToken(token)
+			provided := c.GetHeader(CSRFHeader)
+			if err != nil || expected == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing or invalid CSRF token"})
+				return
+			}
+		}
+
+		c.Set(handlers.ActorKey, actorID(token, fromCookie))
+		if !m.authorizeGuest(c, token) {
+			return
+		}
 		c.Next()
 	}
 }
 
+// authorizeGuest confines guest sessions to guestRoutes on their own apps,
+// audit-logging every attempt. It aborts the request and returns false if
+// the guest isn't allowed; full sessions always pass. A session whose kind
+// can't be looked up is refused rather than taken as full.
+func (m *AuthMiddleware) authorizeGuest(c *gin.Context, token string) bool {
+	guest, isGuest, err := m.guests.ForSession(token)
+	if err != nil {
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, services.ErrSessionGone) {
+			status = http.StatusUnauthorized
+		}
+		c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		return false
+	}
+	if !isGuest {
+		return true
+	}
+	if guest == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "guest access revoked"})
+		return false
+	}
+
+	route := c.Request.Method + " " + c.FullPath()
+	appID := c.Param("id")
+	permission, ok := guestRoutes[route]
+	allowed := ok && m.guests.Allows(guest, permission, appID)
+	m.guests.Audit(c.Request.Context(), guest, route, appID, allowed)
+	if !allowed {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "guest access does not cover this action"})
+		return false
+	}
+
+	c.Set(handlers.ActorKey, services.GuestActor(guest))
+	return true
+}
+
+// actorID names the credential behind a request without exposing it: the
+// kind plus a short hash of the token.
+func actorID(token string, fromCookie bool) string {
+	kind := "token"
+	if fromCookie {
+		kind = "session"
+	}
+	sum := sha256.Sum256([]byte(token))
+	return kind + ":" + hex.EncodeToString(sum[:4])
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
 func (m *AuthMiddleware) AuthenticateWS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// For WebSocket, check query param
@@ -61,6 +154,9 @@ func (m *AuthMiddleware) AuthenticateWS() gin.HandlerFunc {
 			return
 		}
 
+		if !m.authorizeGuest(c, token) {
+			return
+		}
 		c.Next()
 	}
 }
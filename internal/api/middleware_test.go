@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/database"
+	"nas-controller/internal/services"
+)
+
+func TestAuthorizeGuestFailsClosed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.CreateSession("full", "csrf", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	m := NewAuthMiddleware(db, services.NewGuestService(db))
+
+	authorize := func(token string) (bool, int) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/apps/x/start", nil)
+		return m.authorizeGuest(c, token), w.Code
+	}
+
+	if ok, _ := authorize("full"); !ok {
+		t.Error("full session refused")
+	}
+	if ok, code := authorize("gone"); ok || code != http.StatusUnauthorized {
+		t.Errorf("missing session: ok = %v, status = %d, want refused with 401", ok, code)
+	}
+
+	// A lookup that fails says nothing about the session's kind
+	db.Close()
+	if ok, code := authorize("full"); ok || code != http.StatusServiceUnavailable {
+		t.Errorf("failed lookup: ok = %v, status = %d, want refused with 503", ok, code)
+	}
+}
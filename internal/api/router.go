@@ -4,6 +4,7 @@ import (
 	"embed"
 	"io/fs"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"nas-controller/internal/api/handlers"
@@ -15,24 +16,63 @@ import (
 //go:embed all:static
 var staticFiles embed.FS
 
+// routes is what the route registrars work from: the groups to register
+// on, the shared middleware, the enabled feature areas and the services
+// handlers are built from. Each registrar builds only the handlers it
+// needs, so one area can be set up with just its own dependencies.
+type routes struct {
+	router *gin.Engine
+	// api is /api/v1; protected is the same behind authentication
+	api       *gin.RouterGroup
+	protected *gin.RouterGroup
+	features  services.FeatureFlags
+
+	authMiddleware *AuthMiddleware
+	confirm        *ConfirmMiddleware
+
+	db              *database.DB
+	dockerClient    *docker.Client
+	authService     *services.AuthService
+	appManager      *services.AppManager
+	gitService      *services.GitService
+	buildService    *services.BuildService
+	portAllocator   *services.PortAllocator
+	settingsService *services.SettingsService
+	diagnostics     *services.DiagnosticsService
+	exitMonitor     *services.ExitMonitor
+	heartbeat       *services.Heartbeat
+	streams         *services.StreamLimiter
+	guestService    *services.GuestService
+	dataDir         string
+}
+
 func NewRouter(
 	db *database.DB,
 	dockerClient *docker.Client,
 	authService *services.AuthService,
 	appManager *services.AppManager,
+	gitService *services.GitService,
 	buildService *services.BuildService,
 	portAllocator *services.PortAllocator,
+	settingsService *services.SettingsService,
+	diagnostics *services.DiagnosticsService,
+	exitMonitor *services.ExitMonitor,
+	heartbeat *services.Heartbeat,
+	features services.FeatureFlags,
 	dataDir string,
 ) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
+	// Handlers may pass c itself as a context; it then ends with the request
+	router.ContextWithFallback = true
 
 	// CORS middleware
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+CSRFHeader+", "+ConfirmHeader)
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", handlers.CorrelationHeader+", Deprecation, Sunset, Link")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -42,74 +82,63 @@ func NewRouter(
 		c.Next()
 	})
 
-	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(db, authService)
-	appHandler := handlers.NewAppHandler(appManager, buildService, dockerClient, dataDir)
-	systemHandler := handlers.NewSystemHandler(dockerClient, buildService, db, dataDir)
-
-	// Auth middleware
-	authMiddleware := NewAuthMiddleware(db)
-
-	// API routes
-	api := router.Group("/api/v1")
-	{
-		// Auth routes (no auth required)
-		auth := api.Group("/auth")
-		{
-			auth.POST("/login", authHandler.Login)
-			auth.POST("/logout", authHandler.Logout)
-			auth.GET("/check", authHandler.Check)
-		}
-
-		// Protected routes
-		protected := api.Group("")
-		protected.Use(authMiddleware.Authenticate())
-		{
-			// Auth
-			protected.PUT("/auth/password", authHandler.UpdatePassword)
-
-			// Apps
-			protected.GET("/apps", appHandler.ListApps)
-			protected.POST("/apps", appHandler.CreateApp)
-			protected.POST("/apps/clone", appHandler.CloneRepo)
-			protected.GET("/apps/:id", appHandler.GetApp)
-			protected.PUT("/apps/:id", appHandler.UpdateApp)
-			protected.DELETE("/apps/:id", appHandler.DeleteApp)
-			protected.GET("/apps/:id/icon", appHandler.GetAppIcon)
-
-			// App actions
-			protected.POST("/apps/:id/build", appHandler.BuildApp)
-			protected.POST("/apps/:id/start", appHandler.StartApp)
-			protected.POST("/apps/:id/stop", appHandler.StopApp)
-			protected.POST("/apps/:id/restart", appHandler.RestartApp)
-			protected.POST("/apps/:id/pull", appHandler.PullAndRebuild)
-			protected.GET("/apps/:id/check-update", appHandler.CheckUpdate)
-
-			// Logs
-			protected.GET("/apps/:id/logs", appHandler.GetLogs)
-			protected.DELETE("/apps/:id/logs", appHandler.ClearLogs)
-			protected.GET("/apps/:id/build-logs", appHandler.GetBuildLogs)
-
-			// System
-			protected.GET("/system/info", systemHandler.GetInfo)
-			protected.GET("/system/storage", systemHandler.GetStorage)
-			protected.GET("/system/ports", systemHandler.GetPorts)
-			protected.POST("/system/prune", systemHandler.PruneImages)
-			protected.DELETE("/system/logs", systemHandler.ClearAllLogs)
-			protected.POST("/system/check-update", systemHandler.CheckSelfUpdate)
-			protected.POST("/system/self-update", systemHandler.SelfUpdate)
+	// Tag every request with a correlation ID, echoed back in the response
+	// and carried through to builds and log lines.
+	router.Use(func(c *gin.Context) {
+		id := c.GetHeader(handlers.CorrelationHeader)
+		if !services.ValidCorrelationID(id) {
+			id = services.NewCorrelationID()
 		}
+		c.Request = c.Request.WithContext(services.WithCorrelationID(c.Request.Context(), id))
+		c.Writer.Header().Set(handlers.CorrelationHeader, id)
+		c.Next()
+	})
 
-		// WebSocket routes (auth via query param)
-		api.GET("/apps/:id/logs/stream", authMiddleware.AuthenticateWS(), appHandler.StreamLogs)
-		api.GET("/apps/:id/build/stream", authMiddleware.AuthenticateWS(), appHandler.StreamBuild)
+	// Compress JSON, text and the frontend's assets for clients that
+	// accept gzip
+	router.Use(Gzip())
+
+	r := &routes{
+		router:          router,
+		features:        features,
+		db:              db,
+		dockerClient:    dockerClient,
+		authService:     authService,
+		appManager:      appManager,
+		gitService:      gitService,
+		buildService:    buildService,
+		portAllocator:   portAllocator,
+		settingsService: settingsService,
+		diagnostics:     diagnostics,
+		exitMonitor:     exitMonitor,
+		heartbeat:       heartbeat,
+		streams:         services.NewStreamLimiter(settingsService),
+		guestService:    services.NewGuestService(db),
+		dataDir:         dataDir,
 	}
+	r.authMiddleware = NewAuthMiddleware(db, r.guestService)
+	r.confirm = NewConfirmMiddleware(authService, settingsService)
+
+	// Supported API versions and the controller build
+	router.GET("/api/version", getVersion)
 
 	// Health check (no auth)
 	router.GET("/api/v1/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	r.api = router.Group("/api/v1")
+	r.protected = r.api.Group("")
+	r.protected.Use(r.authMiddleware.Authenticate())
+
+	// Each feature area registers its own routes, and none if it is
+	// disabled
+	registerAuth(r)
+	registerApps(r)
+	registerStreams(r)
+	registerSystem(r)
+	registerWebhooks(r)
+
 	// Serve static files (frontend)
 	staticFS, err := fs.Sub(staticFiles, "static")
 	if err == nil {
@@ -136,6 +165,18 @@ func NewRouter(
 				filePath = filePath[1:]
 			}
 
+			// Share links are never SPA routes; a malformed one is just missing
+			if strings.HasPrefix(path, "/share/") {
+				c.String(http.StatusNotFound, "share not found or expired")
+				return
+			}
+
+			// Nor are API paths, such as those of a disabled feature area
+			if strings.HasPrefix(path, "/api/") {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+
 			// Skip empty path
 			if filePath == "" {
 				serveIndex(c)
@@ -173,6 +214,12 @@ func NewRouter(
 				}
 			}
 
+			// Vite puts a content hash in every asset's name, so they never
+			// change under the same URL
+			if strings.HasPrefix(filePath, "assets/") {
+				c.Header("Cache-Control", "public, max-age=31536000, immutable")
+			}
+
 			c.Data(http.StatusOK, contentType, content)
 		})
 	}
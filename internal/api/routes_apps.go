@@ -0,0 +1,93 @@
+package api
+
+import (
+	"nas-controller/internal/api/handlers"
+	"nas-controller/internal/services"
+)
+
+// registerApps registers the app routes, with shares and status badges
+// unless those are disabled.
+func registerApps(r *routes) {
+	if !r.features.Enabled(services.FeatureApps) {
+		return
+	}
+	appHandler := handlers.NewAppHandler(r.appManager, r.buildService, r.dockerClient, r.streams, r.dataDir)
+	protected := r.protected
+
+	protected.GET("/apps", appHandler.ListApps)
+	protected.POST("/apps", appHandler.CreateApp)
+	protected.POST("/apps/clone", appHandler.CloneRepo)
+	protected.POST("/apps/spec", appHandler.CreateAppFromSpec)
+	protected.POST("/apps/upload", appHandler.UploadApp)
+	protected.POST("/apps/bulk", appHandler.BulkAction)
+	protected.GET("/apps/:id", Deprecated(v1AppDeprecation), appHandler.GetApp)
+	protected.PUT("/apps/:id", appHandler.UpdateApp)
+	protected.DELETE("/apps/:id", appHandler.PreviewDelete, r.confirm.Require(services.ConfirmDeleteApp), appHandler.DeleteApp)
+	protected.GET("/apps/:id/icon", appHandler.GetAppIcon)
+	protected.GET("/apps/:id/build-secrets", appHandler.ListBuildSecrets)
+	protected.PUT("/apps/:id/build-secrets/:secretId", appHandler.SetBuildSecret)
+	protected.DELETE("/apps/:id/build-secrets/:secretId", appHandler.DeleteBuildSecret)
+	protected.GET("/apps/:id/config-history", appHandler.GetConfigHistory)
+	protected.POST("/apps/:id/config-history/:snapshotId/restore", appHandler.RestoreConfigSnapshot)
+	protected.GET("/apps/:id/spec", appHandler.GetAppSpec)
+	protected.PUT("/apps/:id/spec", appHandler.ApplyAppSpec)
+	protected.POST("/apps/:id/env/import", appHandler.ImportEnv)
+	protected.GET("/apps/:id/env/export", appHandler.ExportEnv)
+
+	// App actions
+	protected.POST("/apps/:id/build", appHandler.BuildApp)
+	protected.POST("/apps/:id/prepull", appHandler.Prepull)
+	protected.GET("/apps/:id/prepull", appHandler.GetPrepull)
+	protected.GET("/apps/:id/plan", appHandler.PlanStart)
+	protected.POST("/apps/:id/start", appHandler.StartApp)
+	protected.POST("/apps/:id/stop", appHandler.StopApp)
+	protected.POST("/apps/:id/restart", appHandler.RestartApp)
+	protected.POST("/apps/:id/rollback", appHandler.Rollback)
+	protected.POST("/apps/:id/pull", appHandler.PullAndRebuild)
+	protected.POST("/apps/:id/upload", appHandler.ReplaceUpload)
+	protected.GET("/apps/:id/check-update", appHandler.CheckUpdate)
+	protected.GET("/apps/:id/stashes", appHandler.ListStashes)
+	protected.DELETE("/apps/:id/stashes/:commit", appHandler.DropStash)
+	protected.GET("/apps/:id/health", appHandler.GetHealth)
+	protected.GET("/apps/:id/events", appHandler.ListAppEvents)
+	protected.GET("/apps/:id/metrics", appHandler.GetAppMetrics)
+	protected.POST("/apps/:id/repair-state", appHandler.RepairState)
+
+	// Logs
+	protected.GET("/apps/:id/logs", appHandler.GetLogs)
+	protected.DELETE("/apps/:id/logs", appHandler.ClearLogs)
+	protected.GET("/apps/:id/build-logs", appHandler.GetBuildLogs)
+	protected.GET("/apps/:id/builds", appHandler.ListBuilds)
+	protected.GET("/apps/:id/builds/compare", appHandler.CompareBuilds)
+	protected.GET("/apps/:id/builds/:buildId/inputs", appHandler.GetBuildInputs)
+	protected.GET("/apps/:id/builds/:buildId/logs", appHandler.GetBuildRecordLog)
+	protected.GET("/apps/:id/images", appHandler.ListImages)
+	protected.POST("/apps/:id/build-cache/clear", appHandler.ClearBuildCache)
+
+	// v2 only has the routes whose response shapes changed from v1
+	v2 := r.router.Group("/api/v2")
+	v2.Use(r.authMiddleware.Authenticate())
+	v2.GET("/apps/:id", appHandler.GetAppV2)
+
+	// App icons (no auth), for Unraid's net.unraid.docker.icon label
+	r.router.GET("/icons/:id", appHandler.GetAppIcon)
+
+	if r.features.Enabled(services.FeatureShares) {
+		shareHandler := handlers.NewShareHandler(services.NewShareService(r.db), r.appManager, r.buildService, r.dockerClient)
+		protected.POST("/apps/:id/share", shareHandler.CreateShare)
+		protected.GET("/apps/:id/shares", shareHandler.ListShares)
+		protected.DELETE("/apps/:id/shares/:shareId", shareHandler.RevokeShare)
+
+		// Shared log snapshots (no auth, the token is the credential)
+		r.router.GET("/share/:token", shareHandler.ViewShare)
+	}
+
+	if r.features.Enabled(services.FeatureBadges) {
+		protected.GET("/apps/:id/badge", appHandler.GetBadgeSettings)
+		protected.POST("/apps/:id/badge", appHandler.EnableBadge)
+		protected.DELETE("/apps/:id/badge", appHandler.DisableBadge)
+
+		// Status badges (no auth, the token in the query is the credential)
+		r.api.GET("/apps/:id/badge.svg", appHandler.GetBadge)
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"nas-controller/internal/api/handlers"
+	"nas-controller/internal/services"
+)
+
+// registerAuth registers login, the first-run wizard and the password
+// change, which are always there, and guest access unless it is disabled.
+func registerAuth(r *routes) {
+	authHandler := handlers.NewAuthHandler(r.db, r.authService)
+	setupHandler := handlers.NewSetupHandler(authHandler, r.authService, r.settingsService, r.diagnostics)
+
+	// No auth required
+	auth := r.api.Group("/auth")
+	auth.POST("/login", authHandler.Login)
+	auth.POST("/logout", authHandler.Logout)
+	auth.GET("/check", authHandler.Check)
+
+	// First-run wizard (no auth, gone once a password is set)
+	r.api.GET("/setup/status", setupHandler.GetStatus)
+	r.api.POST("/setup", setupHandler.Complete)
+
+	r.protected.PUT("/auth/password", authHandler.UpdatePassword)
+
+	if !r.features.Enabled(services.FeatureGuests) {
+		return
+	}
+	guestHandler := handlers.NewGuestHandler(r.guestService, r.authService, r.settingsService)
+	auth.POST("/guest", guestHandler.RedeemGuest)
+	r.protected.POST("/auth/guests", guestHandler.CreateGuest)
+	r.protected.GET("/auth/guests", guestHandler.ListGuests)
+	r.protected.DELETE("/auth/guests/:id", guestHandler.RevokeGuest)
+
+	// Guest links (no auth, the code is the credential)
+	r.router.GET("/guest/:code", guestHandler.RedeemGuestLink)
+}
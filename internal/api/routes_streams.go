@@ -0,0 +1,17 @@
+package api
+
+import (
+	"nas-controller/internal/api/handlers"
+	"nas-controller/internal/services"
+)
+
+// registerStreams registers the WebSocket log and build streams, which
+// authenticate by query parameter.
+func registerStreams(r *routes) {
+	if !r.features.Enabled(services.FeatureStreams) {
+		return
+	}
+	appHandler := handlers.NewAppHandler(r.appManager, r.buildService, r.dockerClient, r.streams, r.dataDir)
+	r.api.GET("/apps/:id/logs/stream", r.authMiddleware.AuthenticateWS(), appHandler.StreamLogs)
+	r.api.GET("/apps/:id/build/stream", r.authMiddleware.AuthenticateWS(), appHandler.StreamBuild)
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"nas-controller/internal/api/handlers"
+	"nas-controller/internal/services"
+)
+
+// registerSystem registers the controller-wide routes, with self-update
+// unless it is disabled.
+func registerSystem(r *routes) {
+	if !r.features.Enabled(services.FeatureSystem) {
+		return
+	}
+	systemHandler := handlers.NewSystemHandler(r.appManager, r.dockerClient, r.buildService, r.gitService, r.settingsService,
+		r.portAllocator, r.diagnostics, r.streams, r.exitMonitor, r.heartbeat, r.features, r.db, r.dataDir)
+	protected := r.protected
+
+	protected.GET("/summary", systemHandler.GetSummary)
+	protected.GET("/system/info", systemHandler.GetInfo)
+	protected.GET("/system/diagnostics", systemHandler.GetDiagnostics)
+	protected.GET("/system/storage", systemHandler.GetStorage)
+	protected.GET("/system/ports", systemHandler.GetPorts)
+	protected.GET("/system/networks", systemHandler.GetNetworks)
+	protected.GET("/system/build-queue", systemHandler.GetBuildQueue)
+	protected.GET("/builds/queue", systemHandler.GetBuildQueue)
+	protected.DELETE("/builds/queue/:id", systemHandler.DequeueBuild)
+	protected.GET("/system/build-cache", systemHandler.GetBuildCache)
+	protected.POST("/system/prune", systemHandler.PruneImages)
+	protected.POST("/system/prune-build-cache", systemHandler.PruneBuildCache)
+	protected.DELETE("/system/logs", systemHandler.ClearAllLogs)
+	protected.GET("/system/settings", systemHandler.GetSettings)
+	protected.PUT("/system/settings", systemHandler.UpdateSettings)
+	protected.POST("/system/check-updates", systemHandler.CheckUpdates)
+	protected.GET("/system/global-env", systemHandler.GetGlobalEnv)
+	protected.PUT("/system/global-env", systemHandler.UpdateGlobalEnv)
+
+	if r.features.Enabled(services.FeatureSelfUpdate) {
+		protected.POST("/system/check-update", systemHandler.CheckSelfUpdate)
+		protected.POST("/system/self-update", r.confirm.Require(services.ConfirmSelfUpdate), systemHandler.SelfUpdate)
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"nas-controller/internal/api/handlers"
+	"nas-controller/internal/services"
+)
+
+// registerWebhooks registers push-to-deploy: the delivery endpoint, which
+// authenticates by the app's webhook secret, and the app's webhook
+// settings.
+func registerWebhooks(r *routes) {
+	if !r.features.Enabled(services.FeatureWebhooks) {
+		return
+	}
+	webhookHandler := handlers.NewWebhookHandler(r.appManager, r.buildService, r.settingsService)
+
+	r.protected.GET("/apps/:id/webhook", webhookHandler.GetWebhook)
+	r.protected.POST("/apps/:id/webhook", webhookHandler.EnableWebhook)
+	r.protected.DELETE("/apps/:id/webhook", webhookHandler.DisableWebhook)
+
+	// Deliveries (no auth, the signature or token is the credential)
+	r.api.POST("/webhooks/:appId", webhookHandler.Deliver)
+}
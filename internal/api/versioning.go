@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nas-controller/internal/api/handlers"
+)
+
+// APIVersion describes one version of the REST API for GET /api/version.
+type APIVersion struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	// Status is "stable" for a version whose shapes don't change, or
+	// "current" for the newest one.
+	Status string `json:"status"`
+	// Routes lists what the version serves when it only covers the
+	// endpoints whose shapes changed; other requests stay on older ones.
+	Routes []string `json:"routes,omitempty"`
+}
+
+// APIVersions are the API versions this controller serves. v1 keeps its
+// response shapes; an endpoint whose shape changes gets a v2 route instead,
+// and its v1 route is marked deprecated.
+var APIVersions = []APIVersion{
+	{Version: "v1", Path: "/api/v1", Status: "stable"},
+	{Version: "v2", Path: "/api/v2", Status: "current", Routes: []string{"GET /apps/:id"}},
+}
+
+// Deprecation describes a route slated for change.
+type Deprecation struct {
+	// Since is when the route was deprecated, and Sunset when it may be
+	// removed or change shape.
+	Since  time.Time
+	Sunset time.Time
+	// Successor is the route that replaces it, with :params filled in
+	// from the request.
+	Successor string
+}
+
+// v1AppDeprecation covers GET /api/v1/apps/:id, whose runtime fields moved
+// under "runtime" in v2.
+var v1AppDeprecation = Deprecation{
+	Since:     time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC),
+	Sunset:    time.Date(2027, time.October, 15, 0, 0, 0, 0, time.UTC),
+	Successor: "/api/v2/apps/:id",
+}
+
+// Deprecated marks responses of a deprecated route with the Deprecation
+// (RFC 9745) and Sunset (RFC 8594) headers and a Link to its successor, so
+// scripts can notice before the route changes. The response is otherwise
+// untouched.
+func Deprecated(d Deprecation) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		if d.Successor != "" {
+			successor := d.Successor
+			for _, param := range c.Params {
+				successor = strings.Replace(successor, ":"+param.Key, param.Value, 1)
+			}
+			h.Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		}
+		c.Next()
+	}
+}
+
+// getVersion serves GET /api/version: the API versions served and the
+// controller build. It needs no session, so scripts can check before
+// logging in.
+func getVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"versions": APIVersions,
+		"controller": gin.H{
+			"version":   handlers.Version,
+			"goVersion": runtime.Version(),
+		},
+	})
+}
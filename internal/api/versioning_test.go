@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeprecatedHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	body := `{"app":{"id":"a1b2c3d4"}}`
+	router.GET("/api/v1/apps/:id", Deprecated(v1AppDeprecation), func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", []byte(body))
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/apps/a1b2c3d4", nil))
+
+	want := map[string]string{
+		"Deprecation": "@1792022400",
+		"Sunset":      "Fri, 15 Oct 2027 00:00:00 GMT",
+		"Link":        `</api/v2/apps/a1b2c3d4>; rel="successor-version"`,
+	}
+	for header, value := range want {
+		if got := w.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+	// The v1 response itself is untouched
+	if w.Body.String() != body {
+		t.Errorf("body = %s", w.Body)
+	}
+}
@@ -3,6 +3,9 @@ package database
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"math"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -13,8 +16,21 @@ type DB struct {
 	conn *sql.DB
 }
 
+// memoryDBs numbers in-memory databases, so each New(":memory:") gets
+// its own.
+var memoryDBs atomic.Int64
+
+// New opens the database at dbPath. ":memory:" opens an empty in-memory
+// database instead, for tests; it lasts until Close.
 func New(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+	dsn := dbPath + "?_journal_mode=WAL&_busy_timeout=5000"
+	if dbPath == ":memory:" {
+		// A plain :memory: is private to one connection, and the pool opens
+		// several. A named shared-cache database is seen by all of them, and
+		// lasts while the pool keeps one open, which it does until Close.
+		dsn = fmt.Sprintf("file:memdb%d?mode=memory&cache=shared&_busy_timeout=5000", memoryDBs.Add(1))
+	}
+	conn, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -60,18 +76,215 @@ func (db *DB) migrate() error {
 		image_size INTEGER DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		volumes TEXT DEFAULT '[]'
+		volumes TEXT DEFAULT '[]',
+		icon_source TEXT DEFAULT '',
+		offline_build INTEGER DEFAULT 0,
+		last_build_network_mode TEXT DEFAULT '',
+		last_build_failure TEXT DEFAULT '',
+		sub_status TEXT DEFAULT '',
+		replicas INTEGER DEFAULT 1,
+		replica_ports TEXT DEFAULT '[]',
+		last_error TEXT DEFAULT '',
+		rebuild_required INTEGER DEFAULT 0,
+		last_build_log_truncated INTEGER DEFAULT 0,
+		network_mode TEXT DEFAULT 'bridge',
+		network TEXT DEFAULT '',
+		max_retries INTEGER DEFAULT 3,
+		ip_address TEXT DEFAULT '',
+		last_build_correlation_id TEXT DEFAULT '',
+		gpu TEXT DEFAULT '',
+		gpu_capabilities TEXT DEFAULT '',
+		gpu_runtime INTEGER DEFAULT 0,
+		devices TEXT DEFAULT '[]',
+		privileged INTEGER DEFAULT 0,
+		cap_add TEXT DEFAULT '[]',
+		cap_drop TEXT DEFAULT '[]',
+		health TEXT DEFAULT 'none',
+		healthcheck TEXT DEFAULT '',
+		labels TEXT DEFAULT '{}',
+		container_user TEXT DEFAULT '',
+		source_type TEXT DEFAULT 'git',
+		entrypoint TEXT DEFAULT 'null',
+		command TEXT DEFAULT 'null',
+		extra_hosts TEXT DEFAULT '[]',
+		dns TEXT DEFAULT '[]',
+		log_max_size TEXT DEFAULT '',
+		log_max_files INTEGER DEFAULT 0,
+		use_proxy INTEGER DEFAULT 0,
+		custom_container_name TEXT DEFAULT '',
+		hostname TEXT DEFAULT '',
+		memory_limit TEXT DEFAULT '',
+		memory_swap TEXT DEFAULT '',
+		memory_reservation TEXT DEFAULT '',
+		shm_size TEXT DEFAULT '',
+		bind_address TEXT DEFAULT '',
+		ulimits TEXT DEFAULT '[]',
+		auto_recreate INTEGER DEFAULT 0,
+		restart_required INTEGER DEFAULT 0,
+		sysctls TEXT DEFAULT '{}',
+		network_isolated INTEGER DEFAULT 0,
+		compose_file TEXT DEFAULT '',
+		compose_service TEXT DEFAULT '',
+		compose_containers TEXT DEFAULT '{}',
+		preserve_local_changes INTEGER DEFAULT 0,
+		build_target TEXT DEFAULT '',
+		platform TEXT DEFAULT '',
+		publish_ipv6 TEXT DEFAULT '',
+		rolled_back_to TEXT DEFAULT '',
+		fail_stop INTEGER DEFAULT 0,
+		running_outdated INTEGER DEFAULT 0,
+		failed_build_id INTEGER DEFAULT 0,
+		update_available INTEGER DEFAULT 0,
+		remote_commit TEXT DEFAULT '',
+		last_checked DATETIME,
+		last_check_error TEXT DEFAULT '',
+		literal_env INTEGER DEFAULT 0
 	);
 
 	CREATE TABLE IF NOT EXISTS sessions (
 		token TEXT PRIMARY KEY,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		csrf_token TEXT DEFAULT '',
+		guest_id TEXT DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS sticky_ports (
+		slug TEXT PRIMARY KEY,
+		port INTEGER NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS app_badges (
+		app_id TEXT PRIMARY KEY,
+		token TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS app_webhooks (
+		app_id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS build_secrets (
+		app_id TEXT NOT NULL,
+		id TEXT NOT NULL,
+		value BLOB NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (app_id, id)
+	);
+
+	CREATE TABLE IF NOT EXISTS app_contacts (
+		app_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		at DATETIME NOT NULL,
+		success INTEGER NOT NULL,
+		error TEXT DEFAULT '',
+		PRIMARY KEY (app_id, kind)
+	);
+
+	CREATE TABLE IF NOT EXISTS config_snapshots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		app_id TEXT NOT NULL,
+		env TEXT DEFAULT '{}',
+		build_args TEXT DEFAULT '{}',
+		actor TEXT DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS builds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		app_id TEXT NOT NULL,
+		git_commit TEXT DEFAULT '',
+		success INTEGER DEFAULT 0,
+		correlation_id TEXT DEFAULT '',
+		base_images TEXT DEFAULT '{}',
+		build_args_hash TEXT DEFAULT '',
+		docker_version TEXT DEFAULT '',
+		builder TEXT DEFAULT '',
+		started_at DATETIME NOT NULL,
+		finished_at DATETIME,
+		duration TEXT DEFAULT '',
+		triggered_by TEXT DEFAULT '',
+		log_path TEXT DEFAULT '',
+		initiator TEXT DEFAULT '',
+		image_tag TEXT DEFAULT '',
+		inputs_hash TEXT DEFAULT '',
+		skipped INTEGER DEFAULT 0,
+		skip_reason TEXT DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS image_tags (
+		app_id TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		git_commit TEXT NOT NULL,
+		build_id INTEGER NOT NULL,
+		tagged_at DATETIME NOT NULL,
+		PRIMARY KEY (app_id, tag)
+	);
+
+	CREATE TABLE IF NOT EXISTS shares (
+		id TEXT PRIMARY KEY,
+		app_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		content TEXT NOT NULL,
+		actor TEXT DEFAULT '',
+		created_at DATETIME NOT NULL,
 		expires_at DATETIME NOT NULL
 	);
 
+	CREATE TABLE IF NOT EXISTS guest_codes (
+		id TEXT PRIMARY KEY,
+		name TEXT DEFAULT '',
+		app_ids TEXT NOT NULL,
+		permissions TEXT NOT NULL,
+		actor TEXT DEFAULT '',
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS app_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		app_id TEXT NOT NULL,
+		replica INTEGER DEFAULT 1,
+		reason TEXT NOT NULL,
+		exit_code INTEGER DEFAULT 0,
+		signal TEXT DEFAULT '',
+		memory_limit INTEGER DEFAULT 0,
+		gave_up INTEGER DEFAULT 0,
+		detail TEXT DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS app_metrics (
+		app_id TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		resolution INTEGER NOT NULL DEFAULT 60,
+		cpu REAL NOT NULL,
+		mem INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS build_leases (
+		app_id TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		started_at DATETIME NOT NULL,
+		heartbeat_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS controller_heartbeat (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		at DATETIME NOT NULL
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_apps_slug ON apps(slug);
 	CREATE INDEX IF NOT EXISTS idx_apps_status ON apps(status);
 	CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
+	CREATE INDEX IF NOT EXISTS idx_config_snapshots_app ON config_snapshots(app_id, id);
+	CREATE INDEX IF NOT EXISTS idx_builds_app ON builds(app_id, id);
+	CREATE INDEX IF NOT EXISTS idx_app_events_app ON app_events(app_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_app_metrics_app ON app_metrics(app_id, ts);
+	CREATE INDEX IF NOT EXISTS idx_app_metrics_ts ON app_metrics(ts);
 	`
 
 	_, err := db.conn.Exec(schema)
@@ -81,6 +294,81 @@ func (db *DB) migrate() error {
 
 	// Migrations
 	db.conn.Exec("ALTER TABLE apps ADD COLUMN volumes TEXT DEFAULT '[]'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN icon_source TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN offline_build INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN last_build_network_mode TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN last_build_failure TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN sub_status TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN replicas INTEGER DEFAULT 1")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN replica_ports TEXT DEFAULT '[]'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN last_error TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN rebuild_required INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN last_build_log_truncated INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN network_mode TEXT DEFAULT 'bridge'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN network TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN max_retries INTEGER DEFAULT 3")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN ip_address TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN last_build_correlation_id TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN gpu TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN gpu_capabilities TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN gpu_runtime INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN devices TEXT DEFAULT '[]'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN privileged INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN cap_add TEXT DEFAULT '[]'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN cap_drop TEXT DEFAULT '[]'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN health TEXT DEFAULT 'none'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN healthcheck TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN labels TEXT DEFAULT '{}'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN container_user TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN source_type TEXT DEFAULT 'git'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN entrypoint TEXT DEFAULT 'null'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN command TEXT DEFAULT 'null'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN extra_hosts TEXT DEFAULT '[]'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN dns TEXT DEFAULT '[]'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN log_max_size TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN log_max_files INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN use_proxy INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN custom_container_name TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN hostname TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN memory_limit TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN memory_swap TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN memory_reservation TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN shm_size TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN bind_address TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN ulimits TEXT DEFAULT '[]'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN auto_recreate INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN restart_required INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN sysctls TEXT DEFAULT '{}'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN network_isolated INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN compose_file TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN compose_service TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN compose_containers TEXT DEFAULT '{}'")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN preserve_local_changes INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN build_target TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN platform TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN publish_ipv6 TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN rolled_back_to TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN fail_stop INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN running_outdated INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN failed_build_id INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN update_available INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN remote_commit TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN last_checked DATETIME")
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN last_check_error TEXT DEFAULT ''")
+	// Apps from before env interpolation pass their env through as written;
+	// CreateApp always sets literal_env, so the default only fills them in
+	db.conn.Exec("ALTER TABLE apps ADD COLUMN literal_env INTEGER DEFAULT 1")
+	db.conn.Exec("ALTER TABLE app_events ADD COLUMN detail TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE sessions ADD COLUMN csrf_token TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE sessions ADD COLUMN guest_id TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE builds ADD COLUMN duration TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE builds ADD COLUMN triggered_by TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE builds ADD COLUMN log_path TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE builds ADD COLUMN initiator TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE builds ADD COLUMN image_tag TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE builds ADD COLUMN inputs_hash TEXT DEFAULT ''")
+	db.conn.Exec("ALTER TABLE builds ADD COLUMN skipped INTEGER DEFAULT 0")
+	db.conn.Exec("ALTER TABLE builds ADD COLUMN skip_reason TEXT DEFAULT ''")
 
 	return nil
 }
@@ -89,20 +377,57 @@ func (db *DB) CreateApp(app *models.App) error {
 	buildArgsJSON, _ := json.Marshal(app.BuildArgs)
 	envJSON, _ := json.Marshal(app.Env)
 	volumesJSON, _ := json.Marshal(app.Volumes)
+	devicesJSON, _ := json.Marshal(app.Devices)
+	labelsJSON, _ := json.Marshal(app.Labels)
+	entrypointJSON, _ := json.Marshal(app.Entrypoint)
+	commandJSON, _ := json.Marshal(app.Command)
+	extraHostsJSON, _ := json.Marshal(app.ExtraHosts)
+	dnsJSON, _ := json.Marshal(app.DNS)
+	capAddJSON, _ := json.Marshal(app.CapAdd)
+	capDropJSON, _ := json.Marshal(app.CapDrop)
+	healthcheckJSON, _ := json.Marshal(app.Healthcheck)
+	replicaPortsJSON, _ := json.Marshal(app.ReplicaPorts)
+	ulimitsJSON, _ := json.Marshal(app.Ulimits)
+	sysctlsJSON, _ := json.Marshal(app.Sysctls)
+	composeContainersJSON, _ := json.Marshal(app.ComposeContainers)
 
 	_, err := db.conn.Exec(`
 		INSERT INTO apps (
 			id, name, slug, description, icon, repo_url, branch, last_commit, last_pulled,
 			dockerfile_path, build_context, build_args, image_name, container_name, container_id,
 			internal_port, external_port, restart_policy, env, status, last_build,
-			last_build_duration, last_build_success, image_size, created_at, updated_at, volumes
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			last_build_duration, last_build_success, image_size, created_at, updated_at, volumes,
+			icon_source, offline_build, last_build_network_mode, last_build_failure, sub_status,
+			replicas, replica_ports, last_error, rebuild_required, last_build_log_truncated,
+			network_mode, network, max_retries, ip_address, last_build_correlation_id, gpu,
+			gpu_capabilities, gpu_runtime, devices, privileged, cap_add, cap_drop, health,
+			healthcheck, labels, container_user, source_type, entrypoint, command, extra_hosts, dns,
+			log_max_size, log_max_files, use_proxy, custom_container_name, hostname, memory_limit,
+			memory_swap, memory_reservation, shm_size, bind_address, ulimits, auto_recreate,
+			restart_required, sysctls, network_isolated, compose_file, compose_service,
+			compose_containers, preserve_local_changes, build_target, platform,
+			publish_ipv6, rolled_back_to, fail_stop, running_outdated,
+			failed_build_id, literal_env
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		app.ID, app.Name, app.Slug, app.Description, app.Icon, app.RepoURL, app.Branch,
 		app.LastCommit, app.LastPulled, app.DockerfilePath, app.BuildContext, string(buildArgsJSON),
 		app.ImageName, app.ContainerName, app.ContainerID, app.InternalPort, app.ExternalPort,
 		app.RestartPolicy, string(envJSON), app.Status, app.LastBuild, app.LastBuildDuration,
 		app.LastBuildSuccess, app.ImageSize, app.CreatedAt, app.UpdatedAt, string(volumesJSON),
+		app.IconSource, app.OfflineBuild, app.LastBuildNetworkMode, app.LastBuildFailure,
+		app.SubStatus, app.Replicas, string(replicaPortsJSON), app.LastError, app.RebuildRequired,
+		app.LastBuildLogTruncated, app.NetworkMode, app.Network, app.MaxRetries, app.IPAddress,
+		app.LastBuildCorrelationID, app.GPU, app.GPUCapabilities, app.GPURuntime,
+		string(devicesJSON), app.Privileged, string(capAddJSON), string(capDropJSON), app.Health,
+		string(healthcheckJSON), string(labelsJSON), app.User, app.SourceType,
+		string(entrypointJSON), string(commandJSON), string(extraHostsJSON), string(dnsJSON),
+		app.LogMaxSize, app.LogMaxFiles, app.UseProxy, app.CustomContainerName, app.Hostname,
+		app.MemoryLimit, app.MemorySwap, app.MemoryReservation, app.ShmSize, app.BindAddress,
+		string(ulimitsJSON), app.AutoRecreate, app.RestartRequired, string(sysctlsJSON),
+		app.NetworkIsolated, app.ComposeFile, app.ComposeService, string(composeContainersJSON),
+		app.PreserveLocalChanges, app.BuildTarget, app.Platform, app.PublishIPv6, app.RolledBackTo,
+		app.FailStop, app.RunningOutdated, app.FailedBuildID, app.LiteralEnv,
 	)
 	return err
 }
@@ -111,6 +436,19 @@ func (db *DB) UpdateApp(app *models.App) error {
 	buildArgsJSON, _ := json.Marshal(app.BuildArgs)
 	envJSON, _ := json.Marshal(app.Env)
 	volumesJSON, _ := json.Marshal(app.Volumes)
+	devicesJSON, _ := json.Marshal(app.Devices)
+	labelsJSON, _ := json.Marshal(app.Labels)
+	entrypointJSON, _ := json.Marshal(app.Entrypoint)
+	commandJSON, _ := json.Marshal(app.Command)
+	extraHostsJSON, _ := json.Marshal(app.ExtraHosts)
+	dnsJSON, _ := json.Marshal(app.DNS)
+	capAddJSON, _ := json.Marshal(app.CapAdd)
+	capDropJSON, _ := json.Marshal(app.CapDrop)
+	healthcheckJSON, _ := json.Marshal(app.Healthcheck)
+	replicaPortsJSON, _ := json.Marshal(app.ReplicaPorts)
+	ulimitsJSON, _ := json.Marshal(app.Ulimits)
+	sysctlsJSON, _ := json.Marshal(app.Sysctls)
+	composeContainersJSON, _ := json.Marshal(app.ComposeContainers)
 
 	_, err := db.conn.Exec(`
 		UPDATE apps SET
@@ -119,7 +457,20 @@ func (db *DB) UpdateApp(app *models.App) error {
 			image_name = ?, container_name = ?, container_id = ?, internal_port = ?,
 			external_port = ?, restart_policy = ?, env = ?, status = ?, last_build = ?,
 			last_build_duration = ?, last_build_success = ?, image_size = ?, updated_at = ?,
-			volumes = ?
+			volumes = ?, icon_source = ?, offline_build = ?, last_build_network_mode = ?,
+			last_build_failure = ?, sub_status = ?, replicas = ?, replica_ports = ?, last_error = ?,
+			rebuild_required = ?, last_build_log_truncated = ?, network_mode = ?, network = ?,
+			max_retries = ?, ip_address = ?, last_build_correlation_id = ?, gpu = ?,
+			gpu_capabilities = ?, gpu_runtime = ?, devices = ?, privileged = ?, cap_add = ?,
+			cap_drop = ?, health = ?, healthcheck = ?, labels = ?, container_user = ?,
+			source_type = ?, entrypoint = ?, command = ?, extra_hosts = ?, dns = ?, log_max_size = ?,
+			log_max_files = ?, use_proxy = ?, custom_container_name = ?, hostname = ?,
+			memory_limit = ?, memory_swap = ?, memory_reservation = ?, shm_size = ?,
+			bind_address = ?, ulimits = ?, auto_recreate = ?, restart_required = ?, sysctls = ?,
+			network_isolated = ?, compose_file = ?, compose_service = ?, compose_containers = ?,
+			preserve_local_changes = ?, build_target = ?, platform = ?, publish_ipv6 = ?,
+			rolled_back_to = ?, fail_stop = ?, running_outdated = ?, failed_build_id = ?,
+			literal_env = ?
 		WHERE id = ?
 	`,
 		app.Name, app.Description, app.Icon, app.RepoURL, app.Branch, app.LastCommit,
@@ -127,7 +478,20 @@ func (db *DB) UpdateApp(app *models.App) error {
 		app.ImageName, app.ContainerName, app.ContainerID, app.InternalPort,
 		app.ExternalPort, app.RestartPolicy, string(envJSON), app.Status, app.LastBuild,
 		app.LastBuildDuration, app.LastBuildSuccess, app.ImageSize, time.Now(),
-		string(volumesJSON), app.ID,
+		string(volumesJSON), app.IconSource, app.OfflineBuild, app.LastBuildNetworkMode,
+		app.LastBuildFailure, app.SubStatus, app.Replicas, string(replicaPortsJSON), app.LastError,
+		app.RebuildRequired, app.LastBuildLogTruncated, app.NetworkMode, app.Network,
+		app.MaxRetries, app.IPAddress, app.LastBuildCorrelationID, app.GPU, app.GPUCapabilities,
+		app.GPURuntime, string(devicesJSON), app.Privileged, string(capAddJSON),
+		string(capDropJSON), app.Health, string(healthcheckJSON), string(labelsJSON), app.User,
+		app.SourceType, string(entrypointJSON), string(commandJSON), string(extraHostsJSON),
+		string(dnsJSON), app.LogMaxSize, app.LogMaxFiles, app.UseProxy, app.CustomContainerName,
+		app.Hostname, app.MemoryLimit, app.MemorySwap, app.MemoryReservation, app.ShmSize,
+		app.BindAddress, string(ulimitsJSON), app.AutoRecreate, app.RestartRequired,
+		string(sysctlsJSON), app.NetworkIsolated, app.ComposeFile, app.ComposeService,
+		string(composeContainersJSON), app.PreserveLocalChanges, app.BuildTarget, app.Platform,
+		app.PublishIPv6, app.RolledBackTo, app.FailStop, app.RunningOutdated,
+		app.FailedBuildID, app.LiteralEnv, app.ID,
 	)
 	return err
 }
@@ -160,13 +524,574 @@ func (db *DB) GetAllApps() ([]*models.App, error) {
 	return apps, nil
 }
 
+// RecordUpdateCheck stores the result of an update check of the app's
+// remote. It is kept out of UpdateApp so a flow holding an older copy of
+// the app doesn't undo a check that finished meanwhile.
+func (db *DB) RecordUpdateCheck(appID string, available bool, remoteCommit string, checkedAt time.Time) error {
+	_, err := db.conn.Exec(`UPDATE apps SET update_available = ?, remote_commit = ?, last_checked = ?, last_check_error = '' WHERE id = ?`,
+		available, remoteCommit, checkedAt, appID)
+	return err
+}
+
+// RecordUpdateCheckFailure stores that an update check of the app's remote
+// failed, keeping the last successful result. The check still counts as
+// done, so a broken remote isn't retried before the interval is up.
+func (db *DB) RecordUpdateCheckFailure(appID string, message string, checkedAt time.Time) error {
+	_, err := db.conn.Exec(`UPDATE apps SET last_checked = ?, last_check_error = ? WHERE id = ?`, checkedAt, message, appID)
+	return err
+}
+
+// ClearUpdateAvailable marks the app as up to date with the remote it was
+// last checked against, after a pull.
+func (db *DB) ClearUpdateAvailable(appID string) error {
+	_, err := db.conn.Exec(`UPDATE apps SET update_available = 0 WHERE id = ?`, appID)
+	return err
+}
+
+// CountUpdatesAvailable returns how many apps had an update available at
+// their last check.
+func (db *DB) CountUpdatesAvailable() (int, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM apps WHERE update_available = 1`).Scan(&count)
+	return count, err
+}
+
 func (db *DB) DeleteApp(id string) error {
+	db.conn.Exec(`DELETE FROM app_contacts WHERE app_id = ?`, id)
+	db.conn.Exec(`DELETE FROM config_snapshots WHERE app_id = ?`, id)
+	db.conn.Exec(`DELETE FROM shares WHERE app_id = ?`, id)
+	db.conn.Exec(`DELETE FROM builds WHERE app_id = ?`, id)
+	db.conn.Exec(`DELETE FROM app_events WHERE app_id = ?`, id)
+	db.conn.Exec(`DELETE FROM app_metrics WHERE app_id = ?`, id)
+	db.conn.Exec(`DELETE FROM app_badges WHERE app_id = ?`, id)
+	db.conn.Exec(`DELETE FROM app_webhooks WHERE app_id = ?`, id)
+	db.conn.Exec(`DELETE FROM build_secrets WHERE app_id = ?`, id)
+	db.conn.Exec(`DELETE FROM image_tags WHERE app_id = ?`, id)
 	_, err := db.conn.Exec(`DELETE FROM apps WHERE id = ?`, id)
 	return err
 }
 
+// RecordContact stores the outcome of the latest remote contact of kind,
+// replacing the previous one.
+func (db *DB) RecordContact(appID string, kind string, success bool, errMsg string) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO app_contacts (app_id, kind, at, success, error) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(app_id, kind) DO UPDATE SET at = excluded.at, success = excluded.success, error = excluded.error
+	`, appID, kind, time.Now(), success, errMsg)
+	return err
+}
+
+func (db *DB) GetContacts(appID string) (map[string]*models.RemoteContact, error) {
+	rows, err := db.conn.Query(`SELECT kind, at, success, error FROM app_contacts WHERE app_id = ?`, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := make(map[string]*models.RemoteContact)
+	for rows.Next() {
+		contact := &models.RemoteContact{}
+		if err := rows.Scan(&contact.Kind, &contact.At, &contact.Success, &contact.Error); err != nil {
+			return nil, err
+		}
+		contacts[contact.Kind] = contact
+	}
+	return contacts, nil
+}
+
+// CreateConfigSnapshot stores snapshot and drops all but the newest keep
+// snapshots for the app.
+func (db *DB) CreateConfigSnapshot(snapshot *models.ConfigSnapshot, keep int) error {
+	envJSON, _ := json.Marshal(snapshot.Env)
+	buildArgsJSON, _ := json.Marshal(snapshot.BuildArgs)
+
+	result, err := db.conn.Exec(`
+		INSERT INTO config_snapshots (app_id, env, build_args, actor, created_at) VALUES (?, ?, ?, ?, ?)
+	`, snapshot.AppID, string(envJSON), string(buildArgsJSON), snapshot.Actor, snapshot.CreatedAt)
+	if err != nil {
+		return err
+	}
+	snapshot.ID, _ = result.LastInsertId()
+
+	_, err = db.conn.Exec(`
+		DELETE FROM config_snapshots WHERE app_id = ? AND id NOT IN (
+			SELECT id FROM config_snapshots WHERE app_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`, snapshot.AppID, snapshot.AppID, keep)
+	return err
+}
+
+// GetConfigSnapshots returns the app's snapshots, newest first.
+func (db *DB) GetConfigSnapshots(appID string) ([]*models.ConfigSnapshot, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, app_id, env, build_args, actor, created_at FROM config_snapshots
+		WHERE app_id = ? ORDER BY id DESC
+	`, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []*models.ConfigSnapshot
+	for rows.Next() {
+		snapshot, err := scanConfigSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+func (db *DB) GetConfigSnapshot(appID string, id int64) (*models.ConfigSnapshot, error) {
+	row := db.conn.QueryRow(`
+		SELECT id, app_id, env, build_args, actor, created_at FROM config_snapshots
+		WHERE app_id = ? AND id = ?
+	`, appID, id)
+	return scanConfigSnapshot(row)
+}
+
+func scanConfigSnapshot(row interface{ Scan(...interface{}) error }) (*models.ConfigSnapshot, error) {
+	snapshot := &models.ConfigSnapshot{}
+	var envJSON, buildArgsJSON string
+	if err := row.Scan(&snapshot.ID, &snapshot.AppID, &envJSON, &buildArgsJSON, &snapshot.Actor, &snapshot.CreatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(envJSON), &snapshot.Env)
+	json.Unmarshal([]byte(buildArgsJSON), &snapshot.BuildArgs)
+	return snapshot, nil
+}
+
+// CreateBuild records the start of a build.
+func (db *DB) CreateBuild(build *models.Build) error {
+	result, err := db.conn.Exec(`
+		INSERT INTO builds (app_id, git_commit, correlation_id, initiator, triggered_by, started_at) VALUES (?, ?, ?, ?, ?, ?)
+	`, build.AppID, build.Commit, build.CorrelationID, build.Initiator, build.TriggeredBy, build.StartedAt)
+	if err != nil {
+		return err
+	}
+	build.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// SetBuildLogPath records where a build's log is kept.
+func (db *DB) SetBuildLogPath(id int64, path string) error {
+	_, err := db.conn.Exec(`UPDATE builds SET log_path = ? WHERE id = ?`, path, id)
+	return err
+}
+
+// PruneBuilds drops all but the newest keep builds for the app, returning
+// the log paths of the builds it dropped. Skipped builds are kept to their
+// own keep newest, so a run of them doesn't push out the builds that ran,
+// and the newest successful build is always kept: skipping is decided
+// against it.
+func (db *DB) PruneBuilds(appID string, keep int) ([]string, error) {
+	const pruned = `app_id = ? AND id NOT IN (
+		SELECT id FROM builds WHERE app_id = ? AND skipped = 0 ORDER BY id DESC LIMIT ?
+	) AND id NOT IN (
+		SELECT id FROM builds WHERE app_id = ? AND skipped = 1 ORDER BY id DESC LIMIT ?
+	) AND id NOT IN (
+		SELECT id FROM builds WHERE app_id = ? AND success = 1 ORDER BY id DESC LIMIT 1
+	)`
+	args := []interface{}{appID, appID, keep, appID, keep, appID}
+	rows, err := db.conn.Query(`SELECT log_path FROM builds WHERE log_path != '' AND `+pruned, args...)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	rows.Close()
+
+	_, err = db.conn.Exec(`DELETE FROM builds WHERE `+pruned, args...)
+	return paths, err
+}
+
+// FinishBuild stores a build's outcome and inputs.
+func (db *DB) FinishBuild(build *models.Build) error {
+	baseImagesJSON, _ := json.Marshal(build.BaseImages)
+	_, err := db.conn.Exec(`
+		UPDATE builds SET success = ?, base_images = ?, build_args_hash = ?, docker_version = ?, builder = ?, finished_at = ?, duration = ?,
+			image_tag = ?, inputs_hash = ?, skipped = ?, skip_reason = ?
+		WHERE id = ?
+	`, build.Success, string(baseImagesJSON), build.BuildArgsHash, build.DockerVersion, build.Builder, build.FinishedAt,
+		build.Duration, build.ImageTag, build.InputsHash, build.Skipped, build.SkipReason, build.ID)
+	return err
+}
+
+const buildColumns = `id, app_id, git_commit, success, correlation_id, base_images, build_args_hash, docker_version, builder, started_at, finished_at, duration, triggered_by, log_path, initiator, image_tag, inputs_hash, skipped, skip_reason`
+
+// GetBuilds returns up to limit of the app's recorded builds, newest
+// first, starting below build before. Zero leaves either unbounded.
+func (db *DB) GetBuilds(appID string, before int64, limit int) ([]*models.Build, error) {
+	if before <= 0 {
+		before = math.MaxInt64
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := db.conn.Query(`SELECT `+buildColumns+` FROM builds WHERE app_id = ? AND id < ? ORDER BY id DESC LIMIT ?`,
+		appID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	builds := []*models.Build{}
+	for rows.Next() {
+		build, err := scanBuild(rows)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, build)
+	}
+	return builds, nil
+}
+
+// GetAllBuilds returns every app's recorded builds, oldest first.
+func (db *DB) GetAllBuilds() ([]*models.Build, error) {
+	rows, err := db.conn.Query(`SELECT ` + buildColumns + ` FROM builds ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	builds := []*models.Build{}
+	for rows.Next() {
+		build, err := scanBuild(rows)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, build)
+	}
+	return builds, nil
+}
+
+func (db *DB) GetBuild(appID string, id int64) (*models.Build, error) {
+	row := db.conn.QueryRow(`SELECT `+buildColumns+` FROM builds WHERE app_id = ? AND id = ?`, appID, id)
+	return scanBuild(row)
+}
+
+// GetPreviousSuccessfulBuild returns the app's last successful build before
+// id.
+func (db *DB) GetPreviousSuccessfulBuild(appID string, id int64) (*models.Build, error) {
+	row := db.conn.QueryRow(`
+		SELECT `+buildColumns+` FROM builds
+		WHERE app_id = ? AND id < ? AND success = 1 ORDER BY id DESC LIMIT 1
+	`, appID, id)
+	return scanBuild(row)
+}
+
+func scanBuild(row interface{ Scan(...interface{}) error }) (*models.Build, error) {
+	build := &models.Build{}
+	var baseImagesJSON string
+	var finishedAt sql.NullTime
+	if err := row.Scan(&build.ID, &build.AppID, &build.Commit, &build.Success, &build.CorrelationID, &baseImagesJSON,
+		&build.BuildArgsHash, &build.DockerVersion, &build.Builder, &build.StartedAt, &finishedAt, &build.Duration,
+		&build.TriggeredBy, &build.LogPath, &build.Initiator, &build.ImageTag, &build.InputsHash, &build.Skipped,
+		&build.SkipReason); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(baseImagesJSON), &build.BaseImages)
+	if build.BaseImages == nil {
+		build.BaseImages = map[string]string{}
+	}
+	if finishedAt.Valid {
+		build.FinishedAt = &finishedAt.Time
+	}
+	switch {
+	case build.Skipped:
+		build.Result = models.BuildResultSkipped
+	case build.Success:
+		build.Result = models.BuildResultSuccess
+	case build.FinishedAt == nil:
+		build.Result = models.BuildResultRunning
+	default:
+		build.Result = models.BuildResultFailed
+	}
+	return build, nil
+}
+
+// CreateAppEvent stores event and drops all but the app's newest keep.
+func (db *DB) CreateAppEvent(event *models.AppEvent, keep int) error {
+	result, err := db.conn.Exec(`
+		INSERT INTO app_events (app_id, replica, reason, exit_code, signal, memory_limit, gave_up, detail, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, event.AppID, event.Replica, event.Reason, event.ExitCode, event.Signal, event.MemoryLimit, event.GaveUp,
+		event.Detail, event.CreatedAt)
+	if err != nil {
+		return err
+	}
+	event.ID, _ = result.LastInsertId()
+
+	_, err = db.conn.Exec(`
+		DELETE FROM app_events WHERE app_id = ? AND id NOT IN (
+			SELECT id FROM app_events WHERE app_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`, event.AppID, event.AppID, keep)
+	return err
+}
+
+// GetAppEvents returns the app's events, newest first.
+func (db *DB) GetAppEvents(appID string) ([]*models.AppEvent, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, app_id, replica, reason, exit_code, signal, memory_limit, gave_up, detail, created_at
+		FROM app_events WHERE app_id = ? ORDER BY id DESC
+	`, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*models.AppEvent{}
+	for rows.Next() {
+		event := &models.AppEvent{}
+		if err := rows.Scan(&event.ID, &event.AppID, &event.Replica, &event.Reason, &event.ExitCode, &event.Signal,
+			&event.MemoryLimit, &event.GaveUp, &event.Detail, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// InsertAppMetric records one sample of the app's CPU (percent) and memory
+// (bytes) at ts, at the sampler's one-minute resolution.
+func (db *DB) InsertAppMetric(appID string, ts time.Time, cpu float64, mem int64) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO app_metrics (app_id, ts, resolution, cpu, mem) VALUES (?, ?, 60, ?, ?)
+	`, appID, ts.Unix(), cpu, mem)
+	return err
+}
+
+// CompactAppMetrics averages the samples older than before that are finer
+// than resolution (in seconds) into one row per app per resolution-long
+// bucket, and prunes everything older than pruneBefore.
+func (db *DB) CompactAppMetrics(before time.Time, resolution int, pruneBefore time.Time) error {
+	// Only whole buckets, so one is never split across two compactions
+	cutoff := before.Unix() / int64(resolution) * int64(resolution)
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM app_metrics WHERE ts < ?`, pruneBefore.Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO app_metrics (app_id, ts, resolution, cpu, mem)
+		SELECT app_id, ts / ?1 * ?1, ?1, AVG(cpu), CAST(AVG(mem) AS INTEGER)
+		FROM app_metrics WHERE resolution < ?1 AND ts < ?2
+		GROUP BY app_id, ts / ?1
+	`, resolution, cutoff); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM app_metrics WHERE resolution < ? AND ts < ?`, resolution, cutoff); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAppMetrics returns the app's samples since the given time averaged
+// into step-long buckets, oldest first, weighting each by the time it
+// covers. Buckets with no samples, such as while the app was stopped, are
+// left out.
+func (db *DB) GetAppMetrics(appID string, since time.Time, step time.Duration) ([]models.MetricPoint, error) {
+	seconds := int64(step / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	rows, err := db.conn.Query(`
+		SELECT ts / ?1 * ?1 AS bucket,
+			SUM(cpu * resolution) / SUM(resolution),
+			CAST(SUM(mem * resolution) / SUM(resolution) AS INTEGER)
+		FROM app_metrics WHERE app_id = ?2 AND ts >= ?3
+		GROUP BY bucket ORDER BY bucket
+	`, seconds, appID, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []models.MetricPoint{}
+	for rows.Next() {
+		var bucket int64
+		var point models.MetricPoint
+		if err := rows.Scan(&bucket, &point.CPU, &point.Memory); err != nil {
+			return nil, err
+		}
+		point.Time = time.Unix(bucket, 0).UTC()
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
+
+// CountAppEvents counts the app's events with reason since the given time.
+func (db *DB) CountAppEvents(appID string, reason string, since time.Time) (int, error) {
+	var count int
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) FROM app_events WHERE app_id = ? AND reason = ? AND created_at >= ?
+	`, appID, reason, since).Scan(&count)
+	return count, err
+}
+
+// AcquireBuildLease takes the build lease for appID, unless another holder
+// has heartbeated it since staleBefore.
+func (db *DB) AcquireBuildLease(appID string, holder string, staleBefore time.Time) (bool, error) {
+	now := time.Now()
+	result, err := db.conn.Exec(`
+		INSERT INTO build_leases (app_id, holder, started_at, heartbeat_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(app_id) DO UPDATE SET holder = excluded.holder, started_at = excluded.started_at,
+			heartbeat_at = excluded.heartbeat_at
+		WHERE build_leases.heartbeat_at < ?
+	`, appID, holder, now, now, staleBefore)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// WriteHeartbeat records the controller's heartbeat, proving the database
+// still takes writes.
+func (db *DB) WriteHeartbeat(at time.Time) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO controller_heartbeat (id, at) VALUES (1, ?)
+		ON CONFLICT(id) DO UPDATE SET at = excluded.at
+	`, at)
+	return err
+}
+
+// HeartbeatBuildLease extends a lease. It reports false if holder no longer
+// has it.
+func (db *DB) HeartbeatBuildLease(appID string, holder string) (bool, error) {
+	result, err := db.conn.Exec(`
+		UPDATE build_leases SET heartbeat_at = ? WHERE app_id = ? AND holder = ?
+	`, time.Now(), appID, holder)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func (db *DB) ReleaseBuildLease(appID string, holder string) error {
+	_, err := db.conn.Exec(`DELETE FROM build_leases WHERE app_id = ? AND holder = ?`, appID, holder)
+	return err
+}
+
+// ExpireBuildLeases deletes leases not heartbeated since staleBefore, and
+// any still recorded for holder, and returns their app IDs.
+func (db *DB) ExpireBuildLeases(staleBefore time.Time, holder string) ([]string, error) {
+	rows, err := db.conn.Query(`
+		SELECT app_id FROM build_leases WHERE heartbeat_at < ? OR holder = ?
+	`, staleBefore, holder)
+	if err != nil {
+		return nil, err
+	}
+	var appIDs []string
+	for rows.Next() {
+		var appID string
+		if err := rows.Scan(&appID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		appIDs = append(appIDs, appID)
+	}
+	rows.Close()
+
+	for _, appID := range appIDs {
+		if _, err := db.conn.Exec(`DELETE FROM build_leases WHERE app_id = ?`, appID); err != nil {
+			return nil, err
+		}
+	}
+	return appIDs, nil
+}
+
+func (db *DB) HasBuildLease(appID string) bool {
+	var count int
+	db.conn.QueryRow(`SELECT COUNT(*) FROM build_leases WHERE app_id = ?`, appID).Scan(&count)
+	return count > 0
+}
+
+func (db *DB) CreateShare(share *models.Share, content string) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO shares (id, app_id, type, content, actor, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, share.ID, share.AppID, share.Type, content, share.Actor, share.CreatedAt, share.ExpiresAt)
+	return err
+}
+
+// GetShareContent returns the snapshot of an unexpired share.
+func (db *DB) GetShareContent(id string) (*models.Share, string, error) {
+	share := &models.Share{}
+	var content string
+	err := db.conn.QueryRow(`
+		SELECT id, app_id, type, content, actor, created_at, expires_at FROM shares
+		WHERE id = ? AND expires_at > ?
+	`, id, time.Now()).Scan(&share.ID, &share.AppID, &share.Type, &content, &share.Actor, &share.CreatedAt, &share.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return share, content, nil
+}
+
+// GetShares lists an app's unexpired shares, newest first.
+func (db *DB) GetShares(appID string) ([]*models.Share, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, app_id, type, actor, created_at, expires_at FROM shares
+		WHERE app_id = ? AND expires_at > ? ORDER BY created_at DESC
+	`, appID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []*models.Share{}
+	for rows.Next() {
+		share := &models.Share{}
+		if err := rows.Scan(&share.ID, &share.AppID, &share.Type, &share.Actor, &share.CreatedAt, &share.ExpiresAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// DeleteShare revokes a share. It reports false if the app had no such share.
+func (db *DB) DeleteShare(appID string, id string) (bool, error) {
+	result, err := db.conn.Exec(`DELETE FROM shares WHERE app_id = ? AND id = ?`, appID, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func (db *DB) CleanupExpiredShares() error {
+	_, err := db.conn.Exec(`DELETE FROM shares WHERE expires_at <= ?`, time.Now())
+	return err
+}
+
 func (db *DB) GetUsedPorts() ([]int, error) {
-	rows, err := db.conn.Query(`SELECT external_port FROM apps WHERE external_port IS NOT NULL`)
+	return db.queryUsedPorts(`SELECT external_port, replica_ports FROM apps WHERE external_port IS NOT NULL`)
+}
+
+func (db *DB) GetUsedPortsExcluding(excludeAppID string) ([]int, error) {
+	return db.queryUsedPorts(`SELECT external_port, replica_ports FROM apps WHERE external_port IS NOT NULL AND id != ?`, excludeAppID)
+}
+
+// queryUsedPorts collects each app's external port plus the ports of its
+// extra replicas.
+func (db *DB) queryUsedPorts(query string, args ...interface{}) ([]int, error) {
+	rows, err := db.conn.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -175,36 +1100,209 @@ func (db *DB) GetUsedPorts() ([]int, error) {
 	var ports []int
 	for rows.Next() {
 		var port int
-		if err := rows.Scan(&port); err != nil {
+		var replicaPortsJSON sql.NullString
+		if err := rows.Scan(&port, &replicaPortsJSON); err != nil {
 			return nil, err
 		}
 		ports = append(ports, port)
+
+		var replicaPorts []int
+		json.Unmarshal([]byte(replicaPortsJSON.String), &replicaPorts)
+		ports = append(ports, replicaPorts...)
 	}
 	return ports, nil
 }
 
-func (db *DB) GetUsedPortsExcluding(excludeAppID string) ([]int, error) {
-	rows, err := db.conn.Query(`SELECT external_port FROM apps WHERE external_port IS NOT NULL AND id != ?`, excludeAppID)
+// GetStickyPort returns the port last assigned to slug, or 0 if none.
+func (db *DB) GetStickyPort(slug string) (int, error) {
+	var port int
+	err := db.conn.QueryRow(`SELECT port FROM sticky_ports WHERE slug = ?`, slug).Scan(&port)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return port, err
+}
+
+func (db *DB) SetStickyPort(slug string, port int) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO sticky_ports (slug, port, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(slug) DO UPDATE SET port = excluded.port, updated_at = excluded.updated_at
+	`, slug, port, time.Now())
+	return err
+}
+
+// GetBadgeToken returns the token the app's public badge is served under,
+// or "" if the badge is not enabled.
+func (db *DB) GetBadgeToken(appID string) (string, error) {
+	var token string
+	err := db.conn.QueryRow(`SELECT token FROM app_badges WHERE app_id = ?`, appID).Scan(&token)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return token, err
+}
+
+func (db *DB) SetBadgeToken(appID string, token string) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO app_badges (app_id, token, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(app_id) DO UPDATE SET token = excluded.token, created_at = excluded.created_at
+	`, appID, token, time.Now())
+	return err
+}
+
+func (db *DB) DeleteBadgeToken(appID string) error {
+	_, err := db.conn.Exec(`DELETE FROM app_badges WHERE app_id = ?`, appID)
+	return err
+}
+
+// GetWebhook returns the app's webhook secret and when it was generated,
+// or "" if the app has no webhook.
+func (db *DB) GetWebhook(appID string) (string, time.Time, error) {
+	var secret string
+	var createdAt time.Time
+	err := db.conn.QueryRow(`SELECT secret, created_at FROM app_webhooks WHERE app_id = ?`, appID).Scan(&secret, &createdAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil
+	}
+	return secret, createdAt, err
+}
+
+func (db *DB) SetWebhookSecret(appID string, secret string) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO app_webhooks (app_id, secret, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(app_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at
+	`, appID, secret, time.Now())
+	return err
+}
+
+func (db *DB) DeleteWebhook(appID string) error {
+	_, err := db.conn.Exec(`DELETE FROM app_webhooks WHERE app_id = ?`, appID)
+	return err
+}
+
+// RecordImageTag records that the app's build buildID tagged its image
+// with tag for commit, replacing an earlier build of the same commit.
+func (db *DB) RecordImageTag(appID string, tag string, commit string, buildID int64, at time.Time) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO image_tags (app_id, tag, git_commit, build_id, tagged_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(app_id, tag) DO UPDATE SET git_commit = excluded.git_commit, build_id = excluded.build_id,
+			tagged_at = excluded.tagged_at
+	`, appID, tag, commit, buildID, at)
+	return err
+}
+
+// GetImageTags returns the app's recorded commit tags, newest first.
+func (db *DB) GetImageTags(appID string) ([]models.AppImage, error) {
+	rows, err := db.conn.Query(`
+		SELECT tag, git_commit, build_id, tagged_at FROM image_tags WHERE app_id = ? ORDER BY tagged_at DESC
+	`, appID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var ports []int
+	images := []models.AppImage{}
 	for rows.Next() {
+		var image models.AppImage
+		var taggedAt time.Time
+		if err := rows.Scan(&image.Tag, &image.Commit, &image.BuildID, &taggedAt); err != nil {
+			return nil, err
+		}
+		image.TaggedAt = &taggedAt
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+func (db *DB) DeleteImageTag(appID string, tag string) error {
+	_, err := db.conn.Exec(`DELETE FROM image_tags WHERE app_id = ? AND tag = ?`, appID, tag)
+	return err
+}
+
+// ListBuildSecrets returns the app's build secrets without their values,
+// by ID.
+func (db *DB) ListBuildSecrets(appID string) ([]models.BuildSecret, error) {
+	rows, err := db.conn.Query(`SELECT id, updated_at FROM build_secrets WHERE app_id = ? ORDER BY id`, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	secrets := []models.BuildSecret{}
+	for rows.Next() {
+		var secret models.BuildSecret
+		if err := rows.Scan(&secret.ID, &secret.UpdatedAt); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, rows.Err()
+}
+
+// GetBuildSecretValues returns the app's build secrets' stored (encrypted)
+// values by ID.
+func (db *DB) GetBuildSecretValues(appID string) (map[string][]byte, error) {
+	rows, err := db.conn.Query(`SELECT id, value FROM build_secrets WHERE app_id = ?`, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string][]byte{}
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		values[id] = value
+	}
+	return values, rows.Err()
+}
+
+func (db *DB) SetBuildSecret(appID string, id string, value []byte) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO build_secrets (app_id, id, value, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(app_id, id) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`, appID, id, value, time.Now())
+	return err
+}
+
+// DeleteBuildSecret removes one of the app's build secrets. It reports
+// false if the app had no such secret.
+func (db *DB) DeleteBuildSecret(appID string, id string) (bool, error) {
+	result, err := db.conn.Exec(`DELETE FROM build_secrets WHERE app_id = ? AND id = ?`, appID, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func (db *DB) GetStickyPorts() (map[string]int, error) {
+	rows, err := db.conn.Query(`SELECT slug, port FROM sticky_ports ORDER BY slug`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sticky := make(map[string]int)
+	for rows.Next() {
+		var slug string
 		var port int
-		if err := rows.Scan(&port); err != nil {
+		if err := rows.Scan(&slug, &port); err != nil {
 			return nil, err
 		}
-		ports = append(ports, port)
+		sticky[slug] = port
 	}
-	return ports, nil
+	return sticky, nil
 }
 
 func (db *DB) scanApp(row *sql.Row) (*models.App, error) {
 	app := &models.App{}
-	var buildArgsJSON, envJSON, volumesJSON string
-	var lastPulled, lastBuild sql.NullTime
+	var buildArgsJSON, envJSON, volumesJSON, replicaPortsJSON, devicesJSON, capAddJSON, capDropJSON, healthcheckJSON, labelsJSON,
+		entrypointJSON, commandJSON, extraHostsJSON, dnsJSON, ulimitsJSON, sysctlsJSON, composeContainersJSON string
+	var lastPulled, lastBuild, lastChecked sql.NullTime
 	var lastBuildSuccess int
 	var containerID sql.NullString
 
@@ -214,6 +1312,19 @@ func (db *DB) scanApp(row *sql.Row) (*models.App, error) {
 		&app.ImageName, &app.ContainerName, &containerID, &app.InternalPort, &app.ExternalPort,
 		&app.RestartPolicy, &envJSON, &app.Status, &lastBuild, &app.LastBuildDuration,
 		&lastBuildSuccess, &app.ImageSize, &app.CreatedAt, &app.UpdatedAt, &volumesJSON,
+		&app.IconSource, &app.OfflineBuild, &app.LastBuildNetworkMode, &app.LastBuildFailure,
+		&app.SubStatus, &app.Replicas, &replicaPortsJSON, &app.LastError, &app.RebuildRequired,
+		&app.LastBuildLogTruncated, &app.NetworkMode, &app.Network, &app.MaxRetries, &app.IPAddress,
+		&app.LastBuildCorrelationID, &app.GPU, &app.GPUCapabilities, &app.GPURuntime, &devicesJSON,
+		&app.Privileged, &capAddJSON, &capDropJSON, &app.Health, &healthcheckJSON, &labelsJSON,
+		&app.User, &app.SourceType, &entrypointJSON, &commandJSON, &extraHostsJSON, &dnsJSON,
+		&app.LogMaxSize, &app.LogMaxFiles, &app.UseProxy, &app.CustomContainerName, &app.Hostname,
+		&app.MemoryLimit, &app.MemorySwap, &app.MemoryReservation, &app.ShmSize, &app.BindAddress,
+		&ulimitsJSON, &app.AutoRecreate, &app.RestartRequired, &sysctlsJSON, &app.NetworkIsolated,
+		&app.ComposeFile, &app.ComposeService, &composeContainersJSON, &app.PreserveLocalChanges,
+		&app.BuildTarget, &app.Platform, &app.PublishIPv6, &app.RolledBackTo, &app.FailStop,
+		&app.RunningOutdated, &app.FailedBuildID, &app.UpdateAvailable, &app.RemoteCommit,
+		&lastChecked, &app.LastCheckError, &app.LiteralEnv,
 	)
 	if err != nil {
 		return nil, err
@@ -222,6 +1333,9 @@ func (db *DB) scanApp(row *sql.Row) (*models.App, error) {
 	if lastPulled.Valid {
 		app.LastPulled = &lastPulled.Time
 	}
+	if lastChecked.Valid {
+		app.LastChecked = &lastChecked.Time
+	}
 	if lastBuild.Valid {
 		app.LastBuild = &lastBuild.Time
 	}
@@ -232,6 +1346,21 @@ func (db *DB) scanApp(row *sql.Row) (*models.App, error) {
 	json.Unmarshal([]byte(buildArgsJSON), &app.BuildArgs)
 	json.Unmarshal([]byte(envJSON), &app.Env)
 	json.Unmarshal([]byte(volumesJSON), &app.Volumes)
+	json.Unmarshal([]byte(devicesJSON), &app.Devices)
+	json.Unmarshal([]byte(labelsJSON), &app.Labels)
+	// "null" leaves these nil (image default); "[]" is an override with
+	// nothing in it.
+	json.Unmarshal([]byte(entrypointJSON), &app.Entrypoint)
+	json.Unmarshal([]byte(commandJSON), &app.Command)
+	json.Unmarshal([]byte(extraHostsJSON), &app.ExtraHosts)
+	json.Unmarshal([]byte(dnsJSON), &app.DNS)
+	json.Unmarshal([]byte(capAddJSON), &app.CapAdd)
+	json.Unmarshal([]byte(capDropJSON), &app.CapDrop)
+	json.Unmarshal([]byte(healthcheckJSON), &app.Healthcheck)
+	json.Unmarshal([]byte(replicaPortsJSON), &app.ReplicaPorts)
+	json.Unmarshal([]byte(ulimitsJSON), &app.Ulimits)
+	json.Unmarshal([]byte(sysctlsJSON), &app.Sysctls)
+	json.Unmarshal([]byte(composeContainersJSON), &app.ComposeContainers)
 
 	if app.BuildArgs == nil {
 		app.BuildArgs = make(map[string]string)
@@ -242,14 +1371,48 @@ func (db *DB) scanApp(row *sql.Row) (*models.App, error) {
 	if app.Volumes == nil {
 		app.Volumes = []string{}
 	}
+	if app.Devices == nil {
+		app.Devices = []string{}
+	}
+	if app.ExtraHosts == nil {
+		app.ExtraHosts = []string{}
+	}
+	if app.DNS == nil {
+		app.DNS = []string{}
+	}
+	if app.Ulimits == nil {
+		app.Ulimits = []models.Ulimit{}
+	}
+	if app.Sysctls == nil {
+		app.Sysctls = map[string]string{}
+	}
+	if app.ComposeContainers == nil {
+		app.ComposeContainers = map[string]string{}
+	}
+	if app.Labels == nil {
+		app.Labels = map[string]string{}
+	}
+	if app.SourceType == "" {
+		app.SourceType = models.SourceTypeGit
+	}
+	if app.Replicas < 1 {
+		app.Replicas = 1
+	}
+	if app.NetworkMode == "" {
+		app.NetworkMode = models.NetworkModeBridge
+	}
+	if app.Health == "" {
+		app.Health = models.HealthNone
+	}
 
 	return app, nil
 }
 
 func (db *DB) scanAppRows(rows *sql.Rows) (*models.App, error) {
 	app := &models.App{}
-	var buildArgsJSON, envJSON, volumesJSON string
-	var lastPulled, lastBuild sql.NullTime
+	var buildArgsJSON, envJSON, volumesJSON, replicaPortsJSON, devicesJSON, capAddJSON, capDropJSON, healthcheckJSON, labelsJSON,
+		entrypointJSON, commandJSON, extraHostsJSON, dnsJSON, ulimitsJSON, sysctlsJSON, composeContainersJSON string
+	var lastPulled, lastBuild, lastChecked sql.NullTime
 	var lastBuildSuccess int
 	var containerID sql.NullString
 
@@ -259,6 +1422,19 @@ func (db *DB) scanAppRows(rows *sql.Rows) (*models.App, error) {
 		&app.ImageName, &app.ContainerName, &containerID, &app.InternalPort, &app.ExternalPort,
 		&app.RestartPolicy, &envJSON, &app.Status, &lastBuild, &app.LastBuildDuration,
 		&lastBuildSuccess, &app.ImageSize, &app.CreatedAt, &app.UpdatedAt, &volumesJSON,
+		&app.IconSource, &app.OfflineBuild, &app.LastBuildNetworkMode, &app.LastBuildFailure,
+		&app.SubStatus, &app.Replicas, &replicaPortsJSON, &app.LastError, &app.RebuildRequired,
+		&app.LastBuildLogTruncated, &app.NetworkMode, &app.Network, &app.MaxRetries, &app.IPAddress,
+		&app.LastBuildCorrelationID, &app.GPU, &app.GPUCapabilities, &app.GPURuntime, &devicesJSON,
+		&app.Privileged, &capAddJSON, &capDropJSON, &app.Health, &healthcheckJSON, &labelsJSON,
+		&app.User, &app.SourceType, &entrypointJSON, &commandJSON, &extraHostsJSON, &dnsJSON,
+		&app.LogMaxSize, &app.LogMaxFiles, &app.UseProxy, &app.CustomContainerName, &app.Hostname,
+		&app.MemoryLimit, &app.MemorySwap, &app.MemoryReservation, &app.ShmSize, &app.BindAddress,
+		&ulimitsJSON, &app.AutoRecreate, &app.RestartRequired, &sysctlsJSON, &app.NetworkIsolated,
+		&app.ComposeFile, &app.ComposeService, &composeContainersJSON, &app.PreserveLocalChanges,
+		&app.BuildTarget, &app.Platform, &app.PublishIPv6, &app.RolledBackTo, &app.FailStop,
+		&app.RunningOutdated, &app.FailedBuildID, &app.UpdateAvailable, &app.RemoteCommit,
+		&lastChecked, &app.LastCheckError, &app.LiteralEnv,
 	)
 	if err != nil {
 		return nil, err
@@ -267,6 +1443,9 @@ func (db *DB) scanAppRows(rows *sql.Rows) (*models.App, error) {
 	if lastPulled.Valid {
 		app.LastPulled = &lastPulled.Time
 	}
+	if lastChecked.Valid {
+		app.LastChecked = &lastChecked.Time
+	}
 	if lastBuild.Valid {
 		app.LastBuild = &lastBuild.Time
 	}
@@ -277,6 +1456,21 @@ func (db *DB) scanAppRows(rows *sql.Rows) (*models.App, error) {
 	json.Unmarshal([]byte(buildArgsJSON), &app.BuildArgs)
 	json.Unmarshal([]byte(envJSON), &app.Env)
 	json.Unmarshal([]byte(volumesJSON), &app.Volumes)
+	json.Unmarshal([]byte(devicesJSON), &app.Devices)
+	json.Unmarshal([]byte(labelsJSON), &app.Labels)
+	// "null" leaves these nil (image default); "[]" is an override with
+	// nothing in it.
+	json.Unmarshal([]byte(entrypointJSON), &app.Entrypoint)
+	json.Unmarshal([]byte(commandJSON), &app.Command)
+	json.Unmarshal([]byte(extraHostsJSON), &app.ExtraHosts)
+	json.Unmarshal([]byte(dnsJSON), &app.DNS)
+	json.Unmarshal([]byte(capAddJSON), &app.CapAdd)
+	json.Unmarshal([]byte(capDropJSON), &app.CapDrop)
+	json.Unmarshal([]byte(healthcheckJSON), &app.Healthcheck)
+	json.Unmarshal([]byte(replicaPortsJSON), &app.ReplicaPorts)
+	json.Unmarshal([]byte(ulimitsJSON), &app.Ulimits)
+	json.Unmarshal([]byte(sysctlsJSON), &app.Sysctls)
+	json.Unmarshal([]byte(composeContainersJSON), &app.ComposeContainers)
 
 	if app.BuildArgs == nil {
 		app.BuildArgs = make(map[string]string)
@@ -287,13 +1481,58 @@ func (db *DB) scanAppRows(rows *sql.Rows) (*models.App, error) {
 	if app.Volumes == nil {
 		app.Volumes = []string{}
 	}
+	if app.Devices == nil {
+		app.Devices = []string{}
+	}
+	if app.ExtraHosts == nil {
+		app.ExtraHosts = []string{}
+	}
+	if app.DNS == nil {
+		app.DNS = []string{}
+	}
+	if app.Ulimits == nil {
+		app.Ulimits = []models.Ulimit{}
+	}
+	if app.Sysctls == nil {
+		app.Sysctls = map[string]string{}
+	}
+	if app.ComposeContainers == nil {
+		app.ComposeContainers = map[string]string{}
+	}
+	if app.Labels == nil {
+		app.Labels = map[string]string{}
+	}
+	if app.SourceType == "" {
+		app.SourceType = models.SourceTypeGit
+	}
+	if app.Replicas < 1 {
+		app.Replicas = 1
+	}
+	if app.NetworkMode == "" {
+		app.NetworkMode = models.NetworkModeBridge
+	}
+	if app.Health == "" {
+		app.Health = models.HealthNone
+	}
 
 	return app, nil
 }
 
 // Session management
-func (db *DB) CreateSession(token string, expiresAt time.Time) error {
-	_, err := db.conn.Exec(`INSERT INTO sessions (token, expires_at) VALUES (?, ?)`, token, expiresAt)
+func (db *DB) CreateSession(token string, csrfToken string, expiresAt time.Time) error {
+	_, err := db.conn.Exec(`INSERT INTO sessions (token, csrf_token, expires_at) VALUES (?, ?, ?)`, token, csrfToken, expiresAt)
+	return err
+}
+
+// GetSessionCSRFToken returns the CSRF token bound to a live session.
+func (db *DB) GetSessionCSRFToken(token string) (string, error) {
+	var csrfToken string
+	err := db.conn.QueryRow(`SELECT csrf_token FROM sessions WHERE token = ? AND expires_at > ?`, token, time.Now()).Scan(&csrfToken)
+	return csrfToken, err
+}
+
+func (db *DB) SetSessionCSRFToken(token string, csrfToken string) error {
+	_, err := db.conn.Exec(`UPDATE sessions SET csrf_token = ? WHERE token = ?`, csrfToken, token)
 	return err
 }
 
@@ -312,3 +1551,87 @@ func (db *DB) CleanupExpiredSessions() error {
 	_, err := db.conn.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now())
 	return err
 }
+
+// CreateGuestSession creates a session restricted by the given guest code.
+func (db *DB) CreateGuestSession(token string, csrfToken string, guestID string, expiresAt time.Time) error {
+	_, err := db.conn.Exec(`INSERT INTO sessions (token, csrf_token, guest_id, expires_at) VALUES (?, ?, ?, ?)`, token, csrfToken, guestID, expiresAt)
+	return err
+}
+
+// GetSessionGuestID returns the guest code behind a live session, or "" for
+// a full session.
+func (db *DB) GetSessionGuestID(token string) (string, error) {
+	var guestID string
+	err := db.conn.QueryRow(`SELECT guest_id FROM sessions WHERE token = ? AND expires_at > ?`, token, time.Now()).Scan(&guestID)
+	return guestID, err
+}
+
+func (db *DB) CreateGuestCode(guest *models.GuestCode) error {
+	appIDsJSON, _ := json.Marshal(guest.AppIDs)
+	permissionsJSON, _ := json.Marshal(guest.Permissions)
+	_, err := db.conn.Exec(`
+		INSERT INTO guest_codes (id, name, app_ids, permissions, actor, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, guest.ID, guest.Name, string(appIDsJSON), string(permissionsJSON), guest.Actor, guest.CreatedAt, guest.ExpiresAt)
+	return err
+}
+
+const guestCodeColumns = `
+	g.id, g.name, g.app_ids, g.permissions, g.actor, g.created_at, g.expires_at,
+	(SELECT COUNT(*) FROM sessions s WHERE s.guest_id = g.id AND s.expires_at > ?)`
+
+func scanGuestCode(row interface{ Scan(...interface{}) error }) (*models.GuestCode, error) {
+	guest := &models.GuestCode{}
+	var appIDsJSON, permissionsJSON string
+	if err := row.Scan(&guest.ID, &guest.Name, &appIDsJSON, &permissionsJSON, &guest.Actor, &guest.CreatedAt, &guest.ExpiresAt, &guest.Sessions); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(appIDsJSON), &guest.AppIDs)
+	json.Unmarshal([]byte(permissionsJSON), &guest.Permissions)
+	return guest, nil
+}
+
+// GetGuestCode returns an unexpired guest code.
+func (db *DB) GetGuestCode(id string) (*models.GuestCode, error) {
+	now := time.Now()
+	row := db.conn.QueryRow(`SELECT `+guestCodeColumns+` FROM guest_codes g WHERE g.id = ? AND g.expires_at > ?`, now, id, now)
+	return scanGuestCode(row)
+}
+
+// GetGuestCodes lists unexpired guest codes, newest first.
+func (db *DB) GetGuestCodes() ([]*models.GuestCode, error) {
+	now := time.Now()
+	rows, err := db.conn.Query(`SELECT `+guestCodeColumns+` FROM guest_codes g WHERE g.expires_at > ? ORDER BY g.created_at DESC`, now, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	guests := []*models.GuestCode{}
+	for rows.Next() {
+		guest, err := scanGuestCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		guests = append(guests, guest)
+	}
+	return guests, nil
+}
+
+// DeleteGuestCode revokes a guest code and ends every session created from
+// it. It reports false if there was no such code.
+func (db *DB) DeleteGuestCode(id string) (bool, error) {
+	result, err := db.conn.Exec(`DELETE FROM guest_codes WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	if _, err := db.conn.Exec(`DELETE FROM sessions WHERE guest_id = ?`, id); err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func (db *DB) CleanupExpiredGuestCodes() error {
+	_, err := db.conn.Exec(`DELETE FROM guest_codes WHERE expires_at < ?`, time.Now())
+	return err
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"nas-controller/internal/models"
+)
+
+func TestLiteralEnvMigrationKeepsExistingAppsLiteral(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "controller.db")
+	db, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateApp(&models.App{ID: "old", Name: "old", Slug: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	// Back to the schema from before the column existed
+	if _, err := db.conn.Exec("ALTER TABLE apps DROP COLUMN literal_env"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.CreateApp(&models.App{ID: "new", Name: "new", Slug: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]bool{"old": true, "new": false} {
+		app, err := db.GetApp(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if app.LiteralEnv != want {
+			t.Errorf("app %s: literalEnv = %v, want %v", id, app.LiteralEnv, want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// BuildCacheEntry is one record of the daemon's BuildKit build cache.
+// Builds made with the classic builder cache intermediate images instead,
+// which don't show up here.
+type BuildCacheEntry struct {
+	ID          string     `json:"id"`
+	Type        string     `json:"type"`
+	Description string     `json:"description"`
+	Size        int64      `json:"size"`
+	InUse       bool       `json:"inUse"`
+	Shared      bool       `json:"shared"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	UsageCount  int        `json:"usageCount"`
+}
+
+// BuildCache lists the build cache records. Like VolumeSizes it asks for
+// the daemon's disk usage, so it is not cheap.
+func (c *Client) BuildCache(ctx context.Context) ([]BuildCacheEntry, error) {
+	usage, err := c.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.BuildCacheObject}})
+	if err != nil {
+		return nil, translate(err)
+	}
+	entries := make([]BuildCacheEntry, 0, len(usage.BuildCache))
+	for _, record := range usage.BuildCache {
+		entries = append(entries, BuildCacheEntry{
+			ID:          record.ID,
+			Type:        record.Type,
+			Description: record.Description,
+			Size:        record.Size,
+			InUse:       record.InUse,
+			Shared:      record.Shared,
+			CreatedAt:   record.CreatedAt,
+			LastUsedAt:  record.LastUsedAt,
+			UsageCount:  record.UsageCount,
+		})
+	}
+	return entries, nil
+}
+
+// PruneBuildCache removes the unused build cache records with the given
+// IDs. With no IDs it removes every unused record not used within
+// unusedFor instead, whatever built it. It returns the space reclaimed and
+// how many records went.
+func (c *Client) PruneBuildCache(ctx context.Context, ids []string, unusedFor time.Duration) (uint64, int, error) {
+	args := filters.NewArgs()
+	for _, id := range ids {
+		args.Add("id", id)
+	}
+	if len(ids) == 0 {
+		args.Add("until", unusedFor.String())
+	}
+	report, err := c.cli.BuildCachePrune(ctx, types.BuildCachePruneOptions{Filters: args})
+	if err != nil {
+		return 0, 0, translate(err)
+	}
+	return report.SpaceReclaimed, len(report.CachesDeleted), nil
+}
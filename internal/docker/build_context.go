@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+// LargeBuildContext is the context size above which a build warns that
+// sending it to the daemon will be slow.
+const LargeBuildContext = 500 << 20
+
+// ContextExcludes are the patterns left out of the build context in
+// contextPath: .git, then those of its .dockerignore, so the file can
+// still bring .git back with !.git. The Dockerfile and .dockerignore are
+// always sent, as docker build does, since the daemon needs them.
+func ContextExcludes(contextPath string, dockerfilePath string) ([]string, error) {
+	excludes := []string{".git"}
+	f, err := os.Open(filepath.Join(contextPath, ".dockerignore"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return excludes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns, err := ignorefile.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	excludes = append(excludes, patterns...)
+	return append(excludes, "!"+filepath.ToSlash(filepath.Clean(dockerfilePath)), "!.dockerignore"), nil
+}
+
+// ContextSize adds up the files in contextPath that excludes leave in the
+// build context.
+func ContextSize(contextPath string, excludes []string) (int64, error) {
+	pm, err := patternmatcher.New(excludes)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	err = filepath.WalkDir(contextPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contextPath, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		excluded, err := pm.MatchesOrParentMatches(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// A later !pattern can bring back something below an
+			// excluded directory, so it's only skipped without any.
+			if excluded && !pm.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if excluded || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
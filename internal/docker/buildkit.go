@@ -0,0 +1,73 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+)
+
+// BuilderBuildKit names the builder BuildImage uses for builds with
+// secrets, which the classic builder can't mount.
+const BuilderBuildKit = "buildkit"
+
+// buildKitTrace is the aux message BuildKit builds report progress in,
+// instead of the classic builder's stream lines.
+const buildKitTrace = "moby.buildkit.trace"
+
+// startSecretSession opens a BuildKit session serving secrets to the
+// build's RUN --mount=type=secret steps and returns its ID for
+// ImageBuildOptions.SessionID. The session lasts until close is called.
+func (c *Client) startSecretSession(ctx context.Context, secrets map[string][]byte) (string, func(), error) {
+	sess, err := session.NewSession(ctx, "")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start build session: %v", err)
+	}
+	sess.Allow(secretsprovider.FromMap(secrets))
+	go sess.Run(ctx, func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+		return c.cli.DialHijack(ctx, "/session", proto, meta)
+	})
+	return sess.ID(), func() { sess.Close() }, nil
+}
+
+// buildKitProgress turns BuildKit's progress reports into log lines like
+// docker build's plain output: each step once as it starts, then its
+// output and any error.
+type buildKitProgress struct {
+	steps map[string]int
+}
+
+func (p *buildKitProgress) write(aux json.RawMessage, logWriter io.Writer) {
+	var data []byte
+	if err := json.Unmarshal(aux, &data); err != nil {
+		return
+	}
+	var status controlapi.StatusResponse
+	if err := status.Unmarshal(data); err != nil {
+		return
+	}
+	if p.steps == nil {
+		p.steps = map[string]int{}
+	}
+	for _, v := range status.Vertexes {
+		id := string(v.Digest)
+		if _, ok := p.steps[id]; !ok && v.Started != nil {
+			p.steps[id] = len(p.steps) + 1
+			fmt.Fprintf(logWriter, "#%d %s\n", p.steps[id], v.Name)
+			if v.Cached {
+				fmt.Fprintf(logWriter, "#%d CACHED\n", p.steps[id])
+			}
+		}
+		if v.Error != "" {
+			fmt.Fprintf(logWriter, "#%d ERROR: %s\n", p.steps[id], v.Error)
+		}
+	}
+	for _, l := range status.Logs {
+		logWriter.Write(l.Msg)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"net"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -19,28 +21,52 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type Client struct {
-	cli *client.Client
+	cli      *client.Client
+	timeouts *timeouts
 }
 
 type BuildMessage struct {
-	Stream      string `json:"stream"`
-	Error       string `json:"error"`
+	// BuildKit builds report progress as aux messages with this ID
+	ID          string          `json:"id"`
+	Aux         json.RawMessage `json:"aux"`
+	Stream      string          `json:"stream"`
+	Error       string          `json:"error"`
 	ErrorDetail struct {
 		Message string `json:"message"`
 	} `json:"errorDetail"`
 }
 
+// NetworkInfo describes a Docker network an app can be attached to.
+type NetworkInfo struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Driver   string `json:"driver"`
+	Scope    string `json:"scope"`
+	Internal bool   `json:"internal"`
+}
+
+// PullMessage is one line of the JSON stream returned by an image pull.
+type PullMessage struct {
+	Status   string `json:"status"`
+	ID       string `json:"id"`
+	Progress string `json:"progress"`
+	Error    string `json:"error"`
+}
+
 func NewClient() (*Client, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, err
 	}
 
+	c := &Client{cli: cli, timeouts: &timeouts{request: DefaultRequestTimeout, stop: DefaultStopTimeout}}
+
 	// Test connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := c.requestContext(context.Background())
 	defer cancel()
 
 	_, err = cli.Ping(ctx)
@@ -48,16 +74,50 @@ func NewClient() (*Client, error) {
 		return nil, fmt.Errorf("failed to connect to Docker: %v", err)
 	}
 
-	return &Client{cli: cli}, nil
+	return c, nil
 }
 
 func (c *Client) Close() error {
 	return c.cli.Close()
 }
 
-func (c *Client) BuildImage(ctx context.Context, contextPath string, dockerfilePath string, imageName string, buildArgs map[string]string, logWriter io.Writer) error {
-	// Create tar archive of the build context
-	tar, err := archive.TarWithOptions(contextPath, &archive.TarOptions{})
+// Builder names the builder BuildImage uses. ImageBuildOptions.Version is
+// left unset, so that is the classic builder rather than BuildKit, except
+// for builds with secrets (see BuilderBuildKit).
+const Builder = "classic"
+
+// BuildOptions are the docker build flags a build can be asked for.
+type BuildOptions struct {
+	// NoCache is --no-cache: every step runs again.
+	NoCache bool `json:"noCache,omitempty"`
+	// PullBaseImage is --pull: FROM images are pulled even if present.
+	PullBaseImage bool `json:"pullBaseImage,omitempty"`
+	// CacheFrom are images whose layers the build may reuse, as
+	// --cache-from. It is set by the controller, not by requests.
+	CacheFrom []string `json:"-"`
+	// Target is --target: the stage to build instead of the last one. It
+	// comes from the app, not from requests, as does Platform, --platform.
+	Target   string `json:"-"`
+	Platform string `json:"-"`
+	// Secrets are served to RUN --mount=type=secret,id=<key> steps, as
+	// --secret. They need BuildKit, so a build with any uses it rather
+	// than the classic builder. Set by the controller, never logged.
+	Secrets map[string][]byte `json:"-"`
+}
+
+// BuildImage builds contextPath into imageName. networkMode is passed through
+// to the build containers; "none" cuts RUN steps off from the network.
+func (c *Client) BuildImage(ctx context.Context, contextPath string, dockerfilePath string, imageName string, buildArgs map[string]string, networkMode string, options BuildOptions, logWriter io.Writer) error {
+	// Create tar archive of the build context, less what .dockerignore
+	// leaves out
+	excludes, err := ContextExcludes(contextPath, dockerfilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read .dockerignore: %v", err)
+	}
+	if size, err := ContextSize(contextPath, excludes); err == nil && size > LargeBuildContext && logWriter != nil {
+		fmt.Fprintf(logWriter, "Warning: the build context is %d MB; sending it to Docker may take a while. Add what the build doesn't need to .dockerignore.\n", size>>20)
+	}
+	tar, err := archive.TarWithOptions(contextPath, &archive.TarOptions{ExcludePatterns: excludes})
 	if err != nil {
 		return fmt.Errorf("failed to create build context: %v", err)
 	}
@@ -76,6 +136,21 @@ func (c *Client) BuildImage(ctx context.Context, contextPath string, dockerfileP
 		BuildArgs:  args,
 		Remove:     true,
 		ForceRemove: true,
+		NetworkMode: networkMode,
+		NoCache:     options.NoCache,
+		PullParent:  options.PullBaseImage,
+		CacheFrom:   options.CacheFrom,
+		Target:      options.Target,
+		Platform:    options.Platform,
+	}
+	if len(options.Secrets) > 0 {
+		sessionID, closeSession, err := c.startSecretSession(ctx, options.Secrets)
+		if err != nil {
+			return err
+		}
+		defer closeSession()
+		opts.Version = types.BuilderBuildKit
+		opts.SessionID = sessionID
 	}
 
 	resp, err := c.cli.ImageBuild(ctx, tar, opts)
@@ -85,7 +160,9 @@ func (c *Client) BuildImage(ctx context.Context, contextPath string, dockerfileP
 	defer resp.Body.Close()
 
 	// Stream build output
+	var progress buildKitProgress
 	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1024*1024)
 	for scanner.Scan() {
 		var msg BuildMessage
 		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
@@ -94,7 +171,12 @@ func (c *Client) BuildImage(ctx context.Context, contextPath string, dockerfileP
 		if msg.Error != "" {
 			return fmt.Errorf("build error: %s", msg.Error)
 		}
-		if msg.Stream != "" && logWriter != nil {
+		if logWriter == nil {
+			continue
+		}
+		if msg.ID == buildKitTrace {
+			progress.write(msg.Aux, logWriter)
+		} else if msg.Stream != "" {
 			logWriter.Write([]byte(msg.Stream))
 		}
 	}
@@ -102,112 +184,353 @@ func (c *Client) BuildImage(ctx context.Context, contextPath string, dockerfileP
 	return nil
 }
 
-func (c *Client) CreateContainer(ctx context.Context, name string, imageName string, internalPort int, externalPort int, env map[string]string, restartPolicy string, volumes []string) (string, error) {
+// PullImage pulls ref, passing each status line to onStatus. Per-layer
+// download progress is skipped; the "Pulling fs layer" / "Pull complete"
+// lines are enough to follow along.
+func (c *Client) PullImage(ctx context.Context, ref string, onStatus func(string)) error {
+	resp, err := c.cli.ImagePull(ctx, ref, image.PullOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %v", ref, err)
+	}
+	defer resp.Close()
+
+	scanner := bufio.NewScanner(resp)
+	for scanner.Scan() {
+		var msg PullMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", ref, msg.Error)
+		}
+		if msg.Progress != "" || onStatus == nil {
+			continue
+		}
+		if msg.ID != "" {
+			onStatus(msg.ID + ": " + msg.Status)
+		} else {
+			onStatus(msg.Status)
+		}
+	}
+	return scanner.Err()
+}
+
+// CreateContainer creates a container from spec. See ContainerSpec for
+// what each field does.
+func (c *Client) CreateContainer(ctx context.Context, spec ContainerSpec) (string, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
 	// Convert env map to slice
-	envSlice := make([]string, 0, len(env))
-	for k, v := range env {
+	envSlice := make([]string, 0, len(spec.Env))
+	for k, v := range spec.Env {
 		envSlice = append(envSlice, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	// Port bindings
-	portStr := fmt.Sprintf("%d/tcp", internalPort)
+	// Port bindings, on every interface unless BindAddress names one
+	bindAddress := spec.BindAddress
+	if bindAddress == "" {
+		bindAddress = "0.0.0.0"
+	}
+	portStr := fmt.Sprintf("%d/tcp", spec.InternalPort)
 	exposedPorts := nat.PortSet{
 		nat.Port(portStr): struct{}{},
 	}
 	portBindings := nat.PortMap{
 		nat.Port(portStr): []nat.PortBinding{
 			{
-				HostIP:   "0.0.0.0",
-				HostPort: strconv.Itoa(externalPort),
+				HostIP:   bindAddress,
+				HostPort: strconv.Itoa(spec.ExternalPort),
 			},
 		},
 	}
-
-	// Restart policy
-	var restartPolicyConfig container.RestartPolicy
-	switch restartPolicy {
-	case "always":
-		restartPolicyConfig = container.RestartPolicy{Name: "always"}
-	case "unless-stopped":
-		restartPolicyConfig = container.RestartPolicy{Name: "unless-stopped"}
-	case "on-failure":
-		restartPolicyConfig = container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}
-	default:
-		restartPolicyConfig = container.RestartPolicy{Name: "no"}
+	if spec.PublishIPv6 && spec.BindAddress == "" {
+		portBindings[nat.Port(portStr)] = append(portBindings[nat.Port(portStr)], nat.PortBinding{
+			HostIP:   "::",
+			HostPort: strconv.Itoa(spec.ExternalPort),
+		})
 	}
 
 	config := &container.Config{
-		Image:        imageName,
+		Image:        spec.Image,
 		Env:          envSlice,
 		ExposedPorts: exposedPorts,
+		Labels:       spec.Labels,
+		User:         spec.User,
 	}
 
 	hostConfig := &container.HostConfig{
 		PortBindings:  portBindings,
-		RestartPolicy: restartPolicyConfig,
-		Binds:         volumes,
+		RestartPolicy: restartPolicyConfig(spec.RestartPolicy, spec.MaxRetries),
+		Binds:         spec.Volumes,
+		ExtraHosts:    spec.ExtraHosts,
+		DNS:           spec.DNS,
+		Sysctls:       spec.Sysctls,
 	}
 
-	resp, err := c.cli.ContainerCreate(ctx, config, hostConfig, &network.NetworkingConfig{}, nil, name)
-	if err != nil {
+	// Docker refuses a hostname with host networking; the container has
+	// the host's.
+	if spec.NetworkMode != "host" {
+		config.Hostname = spec.Hostname
+	}
+
+	if spec.NetworkMode == "host" || spec.InternalPort == 0 {
+		config.ExposedPorts = nil
+		hostConfig.PortBindings = nil
+		hostConfig.NetworkMode = container.NetworkMode("host")
+	}
+
+	applyGPU(config, hostConfig, spec.GPU)
+	applySecurity(hostConfig, spec.Security)
+	applyHealthcheck(config, spec.Healthcheck)
+	applyLogConfig(hostConfig, spec.LogConfig)
+	applyResources(hostConfig, spec.Resources)
+	if err := c.applyCommand(ctx, config, spec.Entrypoint, spec.Cmd); err != nil {
 		return "", err
 	}
 
+	for _, d := range spec.Devices {
+		device, err := ParseDevice(d)
+		if err != nil {
+			return "", err
+		}
+		hostConfig.Devices = append(hostConfig.Devices, device)
+	}
+
+	networkingConfig := &network.NetworkingConfig{}
+	if spec.Network != "" && spec.NetworkMode != "host" {
+		hostConfig.NetworkMode = container.NetworkMode(spec.Network)
+		endpoint := &network.EndpointSettings{}
+		if spec.IPAddress != "" {
+			endpoint.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: spec.IPAddress}
+		}
+		networkingConfig.EndpointsConfig = map[string]*network.EndpointSettings{
+			spec.Network: endpoint,
+		}
+	}
+
+	// Other networks are joined after creation, except the container's own,
+	// whose endpoint takes the aliases
+	var join []SharedNetwork
+	for _, shared := range spec.Networks {
+		if shared.Name == "" || spec.NetworkMode == "host" {
+			continue
+		}
+		if endpoint, ok := networkingConfig.EndpointsConfig[shared.Name]; ok {
+			endpoint.Aliases = shared.Aliases
+			continue
+		}
+		join = append(join, shared)
+	}
+
+	// Without it Docker warns about an image built for another platform
+	var platform *ocispec.Platform
+	if spec.Platform != "" {
+		parsed, err := ParsePlatform(spec.Platform)
+		if err != nil {
+			return "", err
+		}
+		platform = parsed
+	}
+
+	resp, err := c.cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, spec.Name)
+	if err != nil {
+		return "", translate(err)
+	}
+
+	for _, shared := range join {
+		if err := c.joinSharedNetwork(ctx, resp.ID, shared); err != nil {
+			c.cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+			return "", err
+		}
+	}
+
 	return resp.ID, nil
 }
 
+// restartPolicyConfig builds Docker's restart policy. maxRetries only
+// applies to on-failure.
+func restartPolicyConfig(policy string, maxRetries int) container.RestartPolicy {
+	switch policy {
+	case "always":
+		return container.RestartPolicy{Name: "always"}
+	case "unless-stopped":
+		return container.RestartPolicy{Name: "unless-stopped"}
+	case "on-failure":
+		return container.RestartPolicy{Name: "on-failure", MaximumRetryCount: maxRetries}
+	default:
+		return container.RestartPolicy{Name: "no"}
+	}
+}
+
+// UpdateRestartPolicy changes a container's restart policy in place, without
+// restarting it.
+func (c *Client) UpdateRestartPolicy(ctx context.Context, containerID string, policy string, maxRetries int) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	_, err := c.cli.ContainerUpdate(ctx, containerID, container.UpdateConfig{
+		RestartPolicy: restartPolicyConfig(policy, maxRetries),
+	})
+	return translate(err)
+}
+
+// ListNetworks returns the Docker networks on the host, sorted by name.
+func (c *Client) ListNetworks(ctx context.Context) ([]NetworkInfo, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	networks, err := c.cli.NetworkList(ctx, network.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]NetworkInfo, 0, len(networks))
+	for _, n := range networks {
+		result = append(result, NetworkInfo{
+			ID:       n.ID,
+			Name:     n.Name,
+			Driver:   n.Driver,
+			Scope:    n.Scope,
+			Internal: n.Internal,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// NetworkExists reports whether a network with the given name or ID exists.
+func (c *Client) NetworkExists(ctx context.Context, name string) (bool, error) {
+	networks, err := c.ListNetworks(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, n := range networks {
+		if n.Name == name || n.ID == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckStaticIP verifies that ip lies in one of networkName's subnets and
+// isn't held by a container other than owner.
+func (c *Client) CheckStaticIP(ctx context.Context, networkName string, ip string, owner string) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	inspect, err := c.cli.NetworkInspect(ctx, networkName, network.InspectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to inspect network %s: %v", networkName, err)
+	}
+
+	addr := net.ParseIP(ip)
+	var subnets []string
+	inSubnet := false
+	for _, cfg := range inspect.IPAM.Config {
+		_, subnet, err := net.ParseCIDR(cfg.Subnet)
+		if err != nil || subnet.IP.To4() == nil {
+			continue
+		}
+		subnets = append(subnets, cfg.Subnet)
+		if subnet.Contains(addr) {
+			inSubnet = true
+		}
+	}
+	if len(subnets) == 0 {
+		return fmt.Errorf("network %s has no IPv4 subnet, so a static IP can't be assigned", networkName)
+	}
+	if !inSubnet {
+		return fmt.Errorf("IP %s is outside network %s (subnets: %s)", ip, networkName, strings.Join(subnets, ", "))
+	}
+
+	for _, endpoint := range inspect.Containers {
+		used, _, _ := strings.Cut(endpoint.IPv4Address, "/")
+		if used == ip && endpoint.Name != owner {
+			return fmt.Errorf("IP %s on network %s is already used by container %s", ip, networkName, endpoint.Name)
+		}
+	}
+	return nil
+}
+
+// IsNoSuchImage reports whether err is Docker refusing to create a container
+// because its image doesn't exist (e.g. it was pruned).
+func IsNoSuchImage(err error) bool {
+	e, ok := AsError(err)
+	return ok && e.Code == CodeImageNotFound
+}
+
 func (c *Client) StartContainer(ctx context.Context, containerID string) error {
-	return c.cli.ContainerStart(ctx, containerID, container.StartOptions{})
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	return translate(c.cli.ContainerStart(ctx, containerID, container.StartOptions{}))
 }
 
 func (c *Client) StopContainer(ctx context.Context, containerID string) error {
-	timeout := 30
-	return c.cli.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &timeout})
+	request, stop := c.getTimeouts()
+	// The request lasts as long as the container takes to exit
+	ctx, cancel := context.WithTimeout(ctx, stop+request)
+	defer cancel()
+	timeout := int(stop / time.Second)
+	return translate(c.cli.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &timeout}))
 }
 
 func (c *Client) RemoveContainer(ctx context.Context, containerID string, force bool) error {
-	return c.cli.ContainerRemove(ctx, containerID, container.RemoveOptions{
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	return translate(c.cli.ContainerRemove(ctx, containerID, container.RemoveOptions{
 		Force:         force,
 		RemoveVolumes: false,
-	})
+	}))
 }
 
-func (c *Client) GetContainerStatus(ctx context.Context, containerID string) (string, error) {
+// GetContainerStatus returns "running" or "stopped", and the container's
+// HEALTHCHECK state (HealthNone if it has none or isn't running).
+func (c *Client) GetContainerStatus(ctx context.Context, containerID string) (string, string, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	info, err := c.cli.ContainerInspect(ctx, containerID)
 	if err != nil {
-		return "", err
+		return "", "", translate(err)
 	}
 
-	if info.State.Running {
-		return "running", nil
+	if !info.State.Running {
+		return "stopped", HealthNone, nil
 	}
-	return "stopped", nil
+	health := HealthNone
+	if info.State.Health != nil && info.State.Health.Status != "" {
+		health = info.State.Health.Status
+	}
+	return "running", health, nil
 }
 
 func (c *Client) GetContainerLogs(ctx context.Context, containerID string, tail string) (io.ReadCloser, error) {
-	return c.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
+	logs, err := c.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Tail:       tail,
 		Timestamps: true,
 	})
+	return logs, translate(err)
 }
 
 func (c *Client) StreamContainerLogs(ctx context.Context, containerID string) (io.ReadCloser, error) {
-	return c.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
+	logs, err := c.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Follow:     true,
 		Timestamps: true,
 	})
+	return logs, translate(err)
 }
 
 func (c *Client) RemoveImage(ctx context.Context, imageName string) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	_, err := c.cli.ImageRemove(ctx, imageName, image.RemoveOptions{Force: true, PruneChildren: true})
-	return err
+	return translate(err)
 }
 
 func (c *Client) GetImageSize(ctx context.Context, imageName string) (int64, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	inspect, _, err := c.cli.ImageInspectWithRaw(ctx, imageName)
 	if err != nil {
 		return 0, err
@@ -215,15 +538,76 @@ func (c *Client) GetImageSize(ctx context.Context, imageName string) (int64, err
 	return inspect.Size, nil
 }
 
+// ImageDigest returns the repo digest ref resolves to locally, e.g.
+// sha256:ab12..., falling back to the image ID for images that were never
+// pulled from a registry.
+func (c *Client) ImageDigest(ctx context.Context, ref string) (string, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	inspect, _, err := c.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		if _, digest, ok := strings.Cut(repoDigest, "@"); ok {
+			return digest, nil
+		}
+	}
+	return inspect.ID, nil
+}
+
+// ServerVersion returns the daemon's version, e.g. "27.3.1 (API 1.47)".
+func (c *Client) ServerVersion(ctx context.Context) (string, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	v, err := c.cli.ServerVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s (API %s)", v.Version, v.APIVersion), nil
+}
+
+// VolumeSizes returns the size of each named volume Docker knows, or -1
+// where it hasn't measured it. It asks for the daemon's disk usage, which
+// walks every volume, so it is not cheap.
+func (c *Client) VolumeSizes(ctx context.Context) (map[string]int64, error) {
+	usage, err := c.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		return nil, translate(err)
+	}
+	sizes := make(map[string]int64, len(usage.Volumes))
+	for _, v := range usage.Volumes {
+		size := int64(-1)
+		if v.UsageData != nil {
+			size = v.UsageData.Size
+		}
+		sizes[v.Name] = size
+	}
+	return sizes, nil
+}
+
+// DaemonTime returns the Docker host's clock as the daemon reports it.
+func (c *Client) DaemonTime(ctx context.Context) (time.Time, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	info, err := c.cli.Info(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, info.SystemTime)
+}
+
 func (c *Client) PruneImages(ctx context.Context) (uint64, error) {
 	report, err := c.cli.ImagesPrune(ctx, filters.Args{})
 	if err != nil {
-		return 0, err
+		return 0, translate(err)
 	}
 	return report.SpaceReclaimed, nil
 }
 
 func (c *Client) GetContainerByName(ctx context.Context, name string) (*types.Container, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	containers, err := c.cli.ContainerList(ctx, container.ListOptions{All: true})
 	if err != nil {
 		return nil, err
@@ -242,6 +626,8 @@ func (c *Client) GetContainerByName(ctx context.Context, name string) (*types.Co
 
 // GetContainersOnPort returns all running containers bound to the given host port.
 func (c *Client) GetContainersOnPort(ctx context.Context, port int) ([]*types.Container, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	all, err := c.cli.ContainerList(ctx, container.ListOptions{All: true})
 	if err != nil {
 		return nil, err
@@ -262,6 +648,8 @@ func (c *Client) GetContainersOnPort(ctx context.Context, port int) ([]*types.Co
 }
 
 func (c *Client) GetDockerInfo(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	info, err := c.cli.Info(ctx)
 	if err != nil {
 		return nil, err
@@ -287,36 +675,53 @@ func (c *Client) IsPortInUse(port int) bool {
 }
 
 func (c *Client) GetContainerUptime(ctx context.Context, containerID string) (string, error) {
+	startTime, err := c.ContainerStartedAt(ctx, containerID)
+	if err != nil || startTime == nil {
+		return "", err
+	}
+	return FormatUptime(time.Since(*startTime)), nil
+}
+
+// ContainerStartedAt returns when the container was last started, or nil if
+// it isn't running.
+func (c *Client) ContainerStartedAt(ctx context.Context, containerID string) (*time.Time, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	info, err := c.cli.ContainerInspect(ctx, containerID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !info.State.Running {
-		return "", nil
+		return nil, nil
 	}
 
 	startTime, err := time.Parse(time.RFC3339Nano, info.State.StartedAt)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return &startTime, nil
+}
 
-	duration := time.Since(startTime)
-
+// FormatUptime renders an uptime as the UI shows it: "2d 3h", "3h 5m" or
+// "5m".
+func FormatUptime(duration time.Duration) string {
 	days := int(duration.Hours() / 24)
 	hours := int(duration.Hours()) % 24
 	minutes := int(duration.Minutes()) % 60
 
 	if days > 0 {
-		return fmt.Sprintf("%dd %dh", days, hours), nil
+		return fmt.Sprintf("%dd %dh", days, hours)
 	}
 	if hours > 0 {
-		return fmt.Sprintf("%dh %dm", hours, minutes), nil
+		return fmt.Sprintf("%dh %dm", hours, minutes)
 	}
-	return fmt.Sprintf("%dm", minutes), nil
+	return fmt.Sprintf("%dm", minutes)
 }
 
 func (c *Client) InspectSelf(ctx context.Context) (types.ContainerJSON, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	hostname, _ := os.Hostname() // Container ID in Docker
 	return c.cli.ContainerInspect(ctx, hostname)
 }
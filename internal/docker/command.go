@@ -0,0 +1,36 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+)
+
+// applyCommand overrides the image's ENTRYPOINT and CMD. nil keeps the
+// image's; an empty slice clears it.
+//
+// Docker fills in the image's CMD whenever a create request has neither an
+// entrypoint nor a command, so clearing only the command would bring the
+// image's back. Restating the image's entrypoint avoids that.
+func (c *Client) applyCommand(ctx context.Context, config *container.Config, entrypoint, cmd []string) error {
+	if entrypoint != nil {
+		config.Entrypoint = strslice.StrSlice(entrypoint)
+	}
+	if cmd == nil {
+		return nil
+	}
+	config.Cmd = strslice.StrSlice(cmd)
+
+	if len(cmd) == 0 && entrypoint == nil {
+		inspect, _, err := c.cli.ImageInspectWithRaw(ctx, config.Image)
+		if err != nil {
+			return fmt.Errorf("failed to inspect image: %v", err)
+		}
+		if inspect.Config != nil && len(inspect.Config.Entrypoint) > 0 {
+			config.Entrypoint = inspect.Config.Entrypoint
+		}
+	}
+	return nil
+}
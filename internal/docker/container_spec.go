@@ -0,0 +1,51 @@
+package docker
+
+// ContainerSpec is everything CreateContainer needs to create a container.
+// It is built once per app so starting the app and previewing its start
+// agree on what the container looks like.
+type ContainerSpec struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// Platform is the os/arch to run Image for; empty is the host's.
+	Platform string `json:"platform,omitempty"`
+	// InternalPort is published on ExternalPort, or nothing is published
+	// if it is 0.
+	InternalPort int    `json:"internalPort"`
+	ExternalPort int    `json:"externalPort"`
+	BindAddress  string `json:"bindAddress,omitempty"`
+	// PublishIPv6 publishes ExternalPort on [::] as well when BindAddress
+	// is empty.
+	PublishIPv6   bool              `json:"publishIPv6,omitempty"`
+	Env           map[string]string `json:"env"`
+	RestartPolicy string            `json:"restartPolicy"`
+	MaxRetries    int               `json:"maxRetries,omitempty"`
+	Volumes       []string          `json:"volumes"`
+	// NetworkMode "host" publishes nothing; the container uses the host's
+	// network directly.
+	NetworkMode string `json:"networkMode,omitempty"`
+	// Network attaches the container to that existing network instead of
+	// the default bridge, at IPAddress if one is given.
+	Network   string `json:"network,omitempty"`
+	IPAddress string `json:"ipAddress,omitempty"`
+	// Networks are joined as well, each under its aliases; one that is
+	// Network gets its aliases on that endpoint instead.
+	Networks    []SharedNetwork    `json:"networks,omitempty"`
+	GPU         GPUConfig          `json:"gpu"`
+	Devices     []string           `json:"devices,omitempty"`
+	Security    SecurityConfig     `json:"security"`
+	Healthcheck *HealthcheckConfig `json:"healthcheck,omitempty"`
+	Labels      map[string]string  `json:"labels"`
+	User        string             `json:"user,omitempty"`
+	// Entrypoint and Cmd override the image's when non-nil; see
+	// applyCommand.
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	Cmd        []string `json:"cmd,omitempty"`
+	// ExtraHosts and DNS are passed through as --add-host and --dns, and
+	// Sysctls as --sysctl.
+	ExtraHosts []string          `json:"extraHosts,omitempty"`
+	DNS        []string          `json:"dns,omitempty"`
+	Sysctls    map[string]string `json:"sysctls,omitempty"`
+	LogConfig  LogConfig         `json:"logConfig"`
+	Hostname   string            `json:"hostname,omitempty"`
+	Resources  ResourceConfig    `json:"resources"`
+}
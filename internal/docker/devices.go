@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// missingDevicePattern matches the daemon's error when a mapped host device
+// doesn't exist, e.g. `error gathering device information while adding
+// custom device "/dev/ttyUSB0": no such file or directory`.
+var missingDevicePattern = regexp.MustCompile(`adding custom device "([^"]+)": no such file or directory`)
+
+// ParseDevice parses hostPath[:containerPath[:permissions]], the same form
+// as `docker run --device`. containerPath defaults to hostPath and
+// permissions to "rwm".
+func ParseDevice(spec string) (container.DeviceMapping, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return container.DeviceMapping{}, fmt.Errorf("invalid device %q: expected hostPath[:containerPath[:permissions]]", spec)
+	}
+
+	device := container.DeviceMapping{
+		PathOnHost:        parts[0],
+		PathInContainer:   parts[0],
+		CgroupPermissions: "rwm",
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		device.PathInContainer = parts[1]
+	}
+	if len(parts) > 2 {
+		device.CgroupPermissions = parts[2]
+	}
+
+	if !strings.HasPrefix(path.Clean(device.PathOnHost), "/dev/") {
+		return container.DeviceMapping{}, fmt.Errorf("invalid device %q: host path must be under /dev", spec)
+	}
+	if !path.IsAbs(device.PathInContainer) {
+		return container.DeviceMapping{}, fmt.Errorf("invalid device %q: container path must be absolute", spec)
+	}
+	if device.CgroupPermissions == "" || strings.Trim(device.CgroupPermissions, "rwm") != "" {
+		return container.DeviceMapping{}, fmt.Errorf("invalid device %q: permissions must be a combination of r, w and m", spec)
+	}
+	return device, nil
+}
+
+// MissingDevice returns the host device Docker couldn't find when creating
+// or starting a container, or "" if err isn't about a missing device.
+func MissingDevice(err error) string {
+	if err == nil {
+		return ""
+	}
+	if m := missingDevicePattern.FindStringSubmatch(err.Error()); m != nil {
+		return m[1]
+	}
+	return ""
+}
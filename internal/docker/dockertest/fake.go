@@ -0,0 +1,460 @@
+// Package dockertest provides an in-memory docker.Runtime for tests of the
+// services that run apps, so they can go through create, build, start, stop
+// and delete without a daemon.
+package dockertest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"nas-controller/internal/docker"
+)
+
+// Container is a container the fake daemon holds.
+type Container struct {
+	ID        string
+	Spec      docker.ContainerSpec
+	Running   bool
+	StartedAt time.Time
+}
+
+// Fake is a docker.Runtime that keeps containers, images and networks in
+// memory. Errors come back as the *docker.Error the real client translates
+// daemon failures to, so callers' error handling is exercised too. The zero
+// value is not usable; call New.
+type Fake struct {
+	mu         sync.Mutex
+	containers map[string]*Container
+	images     map[string]*docker.ImageDetails
+	networks   map[string]bool
+	nextID     int
+
+	// BuildErr, when set, fails every build with it.
+	BuildErr error
+	// Builds are the image names built so far, in order.
+	Builds []string
+}
+
+var _ docker.Runtime = (*Fake)(nil)
+
+func New() *Fake {
+	return &Fake{
+		containers: make(map[string]*Container),
+		images:     make(map[string]*docker.ImageDetails),
+		networks:   make(map[string]bool),
+	}
+}
+
+func notFound(code, format string, args ...any) error {
+	return &docker.Error{Kind: docker.KindNotFound, Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+func conflict(code, format string, args ...any) error {
+	return &docker.Error{Kind: docker.KindConflict, Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// AddImage makes ref exist, as if it had been pulled.
+func (f *Fake) AddImage(ref string, size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addImage(ref, size)
+}
+
+func (f *Fake) addImage(ref string, size int64) {
+	f.nextID++
+	f.images[ref] = &docker.ImageDetails{ID: fmt.Sprintf("sha256:%064d", f.nextID), Size: size, Created: time.Now()}
+}
+
+// HasImage reports whether ref exists.
+func (f *Fake) HasImage(ref string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.images[ref] != nil
+}
+
+// AddContainer creates and starts a container outside the code under test,
+// such as one left behind by a crash or run by another tool.
+func (f *Fake) AddContainer(spec docker.ContainerSpec) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.create(spec)
+	c.Running, c.StartedAt = true, time.Now()
+	return c.ID
+}
+
+// Container returns the container with the given name, or nil.
+func (f *Fake) Container(name string) *Container {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c := f.byName(name); c != nil {
+		copy := *c
+		return &copy
+	}
+	return nil
+}
+
+// Containers returns the names of every container, sorted.
+func (f *Fake) Containers() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.containers))
+	for _, c := range f.containers {
+		names = append(names, c.Spec.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RestartDaemon simulates the daemon restarting: every container stops,
+// and those whose restart policy brings them back are started again.
+// Docker keeps container IDs across a restart.
+func (f *Fake) RestartDaemon() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for _, c := range f.containers {
+		wasRunning := c.Running
+		c.Running = false
+		switch c.Spec.RestartPolicy {
+		case "always":
+			c.Running, c.StartedAt = true, now
+		case "unless-stopped":
+			c.Running = wasRunning
+			c.StartedAt = now
+		}
+	}
+}
+
+func (f *Fake) byName(name string) *Container {
+	for _, c := range f.containers {
+		if c.Spec.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *Fake) create(spec docker.ContainerSpec) *Container {
+	f.nextID++
+	c := &Container{ID: fmt.Sprintf("%064x", f.nextID), Spec: spec}
+	f.containers[c.ID] = c
+	return c
+}
+
+// summary is c as the container list returns it.
+func summary(c *Container) *types.Container {
+	state := "exited"
+	if c.Running {
+		state = "running"
+	}
+	summary := &types.Container{
+		ID:     c.ID,
+		Names:  []string{"/" + c.Spec.Name},
+		Image:  c.Spec.Image,
+		State:  state,
+		Labels: c.Spec.Labels,
+	}
+	if c.Spec.ExternalPort > 0 && c.Spec.NetworkMode != "host" {
+		summary.Ports = []types.Port{{
+			IP:          "0.0.0.0",
+			PrivatePort: uint16(c.Spec.InternalPort),
+			PublicPort:  uint16(c.Spec.ExternalPort),
+			Type:        "tcp",
+		}}
+	}
+	return summary
+}
+
+func (f *Fake) CreateContainer(ctx context.Context, spec docker.ContainerSpec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.images[spec.Image] == nil {
+		return "", notFound(docker.CodeImageNotFound, "No such image: %s", spec.Image)
+	}
+	if existing := f.byName(spec.Name); existing != nil {
+		return "", conflict(docker.CodeContainerNameConflict, "Conflict. The container name %q is already in use by container %q", "/"+spec.Name, existing.ID)
+	}
+	return f.create(spec).ID, nil
+}
+
+func (f *Fake) StartContainer(ctx context.Context, containerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.containers[containerID]
+	if c == nil {
+		return notFound(docker.CodeContainerNotFound, "No such container: %s", containerID)
+	}
+	if c.Running {
+		return nil
+	}
+	if port := c.Spec.ExternalPort; port > 0 && c.Spec.NetworkMode != "host" {
+		for _, other := range f.containers {
+			if other.Running && other.Spec.ExternalPort == port && other.Spec.NetworkMode != "host" {
+				return conflict(docker.CodePortAllocated, "Bind for 0.0.0.0:%d failed: port is already allocated", port)
+			}
+		}
+	}
+	c.Running, c.StartedAt = true, time.Now()
+	return nil
+}
+
+func (f *Fake) StopContainer(ctx context.Context, containerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.containers[containerID]
+	if c == nil {
+		return notFound(docker.CodeContainerNotFound, "No such container: %s", containerID)
+	}
+	c.Running = false
+	return nil
+}
+
+func (f *Fake) RemoveContainer(ctx context.Context, containerID string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.containers[containerID]
+	if c == nil {
+		return notFound(docker.CodeContainerNotFound, "No such container: %s", containerID)
+	}
+	if c.Running && !force {
+		return conflict(docker.CodeConflict, "cannot remove container %q: container is running", "/"+c.Spec.Name)
+	}
+	delete(f.containers, containerID)
+	return nil
+}
+
+func (f *Fake) UpdateRestartPolicy(ctx context.Context, containerID string, policy string, maxRetries int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.containers[containerID]
+	if c == nil {
+		return notFound(docker.CodeContainerNotFound, "No such container: %s", containerID)
+	}
+	c.Spec.RestartPolicy, c.Spec.MaxRetries = policy, maxRetries
+	return nil
+}
+
+func (f *Fake) GetAppContainer(ctx context.Context, appID string, replica int) (*types.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.containers {
+		if c.Spec.Labels[docker.AppIDLabel] == appID && c.Spec.Labels[docker.ReplicaLabel] == strconv.Itoa(replica) {
+			return summary(c), nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *Fake) GetContainerByName(ctx context.Context, name string) (*types.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c := f.byName(name); c != nil {
+		return summary(c), nil
+	}
+	return nil, nil
+}
+
+func (f *Fake) GetContainersOnPort(ctx context.Context, port int) ([]*types.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []*types.Container
+	for _, c := range f.containers {
+		if c.Spec.ExternalPort == port && c.Spec.NetworkMode != "host" {
+			result = append(result, summary(c))
+		}
+	}
+	return result, nil
+}
+
+func (f *Fake) GetContainerStatus(ctx context.Context, containerID string) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.containers[containerID]
+	if c == nil {
+		return "", "", notFound(docker.CodeContainerNotFound, "No such container: %s", containerID)
+	}
+	if !c.Running {
+		return "stopped", docker.HealthNone, nil
+	}
+	return "running", docker.HealthNone, nil
+}
+
+func (f *Fake) GetContainerUptime(ctx context.Context, containerID string) (string, error) {
+	startedAt, err := f.ContainerStartedAt(ctx, containerID)
+	if err != nil || startedAt == nil {
+		return "", err
+	}
+	return docker.FormatUptime(time.Since(*startedAt)), nil
+}
+
+func (f *Fake) ContainerStartedAt(ctx context.Context, containerID string) (*time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.containers[containerID]
+	if c == nil {
+		return nil, notFound(docker.CodeContainerNotFound, "No such container: %s", containerID)
+	}
+	if !c.Running {
+		return nil, nil
+	}
+	startedAt := c.StartedAt
+	return &startedAt, nil
+}
+
+func (f *Fake) ContainerResources(ctx context.Context, containerID string) (*docker.ResourceConfig, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.containers[containerID]
+	if c == nil {
+		return nil, notFound(docker.CodeContainerNotFound, "No such container: %s", containerID)
+	}
+	resources := c.Spec.Resources
+	return &resources, nil
+}
+
+func (f *Fake) ContainerUsage(ctx context.Context, containerID string) (*docker.ContainerUsage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.containers[containerID] == nil {
+		return nil, notFound(docker.CodeContainerNotFound, "No such container: %s", containerID)
+	}
+	return &docker.ContainerUsage{}, nil
+}
+
+func (f *Fake) VolumeSizes(ctx context.Context) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+func (f *Fake) NetworkExists(ctx context.Context, name string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.networks[name], nil
+}
+
+func (f *Fake) EnsureNetwork(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.networks[name] = true
+	return nil
+}
+
+func (f *Fake) RemoveNetworkIfUnused(ctx context.Context, name string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.containers {
+		if c.Spec.Network == name {
+			return false, nil
+		}
+		for _, shared := range c.Spec.Networks {
+			if shared.Name == name {
+				return false, nil
+			}
+		}
+	}
+	delete(f.networks, name)
+	return true, nil
+}
+
+func (f *Fake) CheckStaticIP(ctx context.Context, networkName string, ip string, owner string) error {
+	return nil
+}
+
+// BuildImage writes a line to logWriter and creates imageName, unless
+// BuildErr is set.
+func (f *Fake) BuildImage(ctx context.Context, contextPath string, dockerfilePath string, imageName string, buildArgs map[string]string, networkMode string, options docker.BuildOptions, logWriter io.Writer) error {
+	fmt.Fprintf(logWriter, "Step 1/1 : building %s from %s\n", imageName, dockerfilePath)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.BuildErr != nil {
+		return f.BuildErr
+	}
+	f.Builds = append(f.Builds, imageName)
+	f.addImage(imageName, 1<<20)
+	return nil
+}
+
+func (f *Fake) PullImage(ctx context.Context, ref string, onStatus func(string)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.images[ref] == nil {
+		f.addImage(ref, 1<<20)
+	}
+	return nil
+}
+
+func (f *Fake) RemoveImage(ctx context.Context, imageName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.images[imageName] == nil {
+		return notFound(docker.CodeImageNotFound, "No such image: %s", imageName)
+	}
+	delete(f.images, imageName)
+	return nil
+}
+
+func (f *Fake) GetImageSize(ctx context.Context, imageName string) (int64, error) {
+	details, err := f.InspectImage(ctx, imageName)
+	if err != nil {
+		return 0, err
+	}
+	return details.Size, nil
+}
+
+func (f *Fake) ImageDigest(ctx context.Context, ref string) (string, error) {
+	details, err := f.InspectImage(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return details.ID, nil
+}
+
+func (f *Fake) ServerVersion(ctx context.Context) (string, error) {
+	return "27.3.1 (API 1.47)", nil
+}
+
+func (f *Fake) BuildCache(ctx context.Context) ([]docker.BuildCacheEntry, error) {
+	return nil, nil
+}
+
+func (f *Fake) PruneBuildCache(ctx context.Context, ids []string, unusedFor time.Duration) (uint64, int, error) {
+	return 0, 0, nil
+}
+
+func (f *Fake) TagImage(ctx context.Context, source string, target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	details := f.images[source]
+	if details == nil {
+		return notFound(docker.CodeImageNotFound, "No such image: %s", source)
+	}
+	f.images[target] = details
+	return nil
+}
+
+func (f *Fake) InspectImage(ctx context.Context, ref string) (*docker.ImageDetails, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	details := f.images[ref]
+	if details == nil {
+		return nil, notFound(docker.CodeImageNotFound, "No such image: %s", ref)
+	}
+	copy := *details
+	return &copy, nil
+}
+
+func (f *Fake) ImageUniqueSizes(ctx context.Context) (map[string]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sizes := make(map[string]int64, len(f.images))
+	for ref, details := range f.images {
+		sizes[ref] = details.Size
+	}
+	return sizes, nil
+}
@@ -0,0 +1,131 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// Kind is the broad class of a Docker failure, which the API maps to an
+// HTTP status.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindConflict
+	KindInvalid
+	KindUnavailable
+)
+
+// Stable codes for Docker failures, returned to API clients next to the
+// daemon's message so they don't have to parse it.
+const (
+	CodeContainerNotFound       = "CONTAINER_NOT_FOUND"
+	CodeImageNotFound           = "IMAGE_NOT_FOUND"
+	CodeContainerAlreadyRunning = "CONTAINER_ALREADY_RUNNING"
+	CodeContainerNameConflict   = "CONTAINER_NAME_CONFLICT"
+	CodePortAllocated           = "PORT_ALREADY_ALLOCATED"
+	CodeNotFound                = "DOCKER_NOT_FOUND"
+	CodeConflict                = "DOCKER_CONFLICT"
+	CodeInvalidParameter        = "DOCKER_INVALID_PARAMETER"
+	CodeUnavailable             = "DOCKER_UNAVAILABLE"
+	CodeInternal                = "DOCKER_ERROR"
+
+	// CodeTimeout is a request that ran out of time waiting on the
+	// daemon. It isn't an Error's code: timeouts stay context errors.
+	CodeTimeout = "DOCKER_TIMEOUT"
+)
+
+// Error is a failed Docker API call, classified. Its message is the
+// daemon's, so wrapping it reads the same as before translation.
+type Error struct {
+	Kind Kind
+	Code string
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// AlreadyRunning is the error for starting a container that is running.
+// Docker itself treats that as a no-op, so callers that care check first.
+func AlreadyRunning(name string) error {
+	return &Error{
+		Kind: KindConflict,
+		Code: CodeContainerAlreadyRunning,
+		Err:  fmt.Errorf("container %s is already running", name),
+	}
+}
+
+// NameConflict is the error for a container name held by a container the
+// controller doesn't manage, which it must not remove to take the name.
+func NameConflict(name string) error {
+	return &Error{
+		Kind: KindConflict,
+		Code: CodeContainerNameConflict,
+		Err:  fmt.Errorf("container name %s is already in use by a container the controller doesn't manage", name),
+	}
+}
+
+// translate classifies an error from the Docker SDK. Context errors pass
+// through untouched so callers can still tell a cancelled request.
+//
+// The daemon reports a few failures only in the message: a port another
+// container holds comes back as a 500 "Bind for 0.0.0.0:8080 failed: port
+// is already allocated", and 404s don't say what was missing except as
+// "No such container: x" or "No such image: x".
+func translate(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var translated *Error
+	if errors.As(err, &translated) {
+		return err
+	}
+
+	msg := err.Error()
+	e := &Error{Kind: KindInternal, Code: CodeInternal, Err: err}
+	switch {
+	case strings.Contains(msg, "port is already allocated") || strings.Contains(msg, "address already in use"):
+		e.Kind, e.Code = KindConflict, CodePortAllocated
+	case errdefs.IsNotFound(err):
+		e.Kind, e.Code = KindNotFound, CodeNotFound
+		if strings.Contains(msg, "No such container") {
+			e.Code = CodeContainerNotFound
+		} else if strings.Contains(msg, "No such image") {
+			e.Code = CodeImageNotFound
+		}
+	case errdefs.IsConflict(err):
+		e.Kind, e.Code = KindConflict, CodeConflict
+		if strings.Contains(msg, "container name") && strings.Contains(msg, "is already in use") {
+			e.Code = CodeContainerNameConflict
+		}
+	case errdefs.IsInvalidParameter(err):
+		e.Kind, e.Code = KindInvalid, CodeInvalidParameter
+	case errdefs.IsUnavailable(err) || client.IsErrConnectionFailed(err):
+		e.Kind, e.Code = KindUnavailable, CodeUnavailable
+	}
+	return e
+}
+
+// AsError returns the translated Docker error in err's chain, if any.
+func AsError(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// IsNotFound reports whether err is Docker saying the container or image
+// doesn't exist.
+func IsNotFound(err error) bool {
+	e, ok := AsError(err)
+	return ok && e.Kind == KindNotFound
+}
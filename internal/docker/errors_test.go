@@ -0,0 +1,181 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/client"
+)
+
+// newTestClient returns a Client talking to daemon instead of Docker.
+func newTestClient(t *testing.T, daemon http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(daemon)
+	t.Cleanup(server.Close)
+	return newClientAt(t, "tcp://"+strings.TrimPrefix(server.URL, "http://"))
+}
+
+func newClientAt(t *testing.T, host string) *Client {
+	t.Helper()
+	cli, err := client.NewClientWithOpts(client.WithHost(host), client.WithVersion("1.47"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return &Client{cli: cli, timeouts: &timeouts{request: DefaultRequestTimeout, stop: DefaultStopTimeout}}
+}
+
+// daemonError answers every request the way the daemon reports a failure.
+func daemonError(status int, message string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "{\"message\":%q}\n", message)
+	})
+}
+
+func TestTranslateDaemonErrors(t *testing.T) {
+	// Status and message as Docker 27 sends them
+	tests := []struct {
+		name     string
+		status   int
+		message  string
+		wantKind Kind
+		wantCode string
+	}{
+		{
+			name:     "container gone",
+			status:   http.StatusNotFound,
+			message:  "No such container: 3f2a9c1e7b44",
+			wantKind: KindNotFound,
+			wantCode: CodeContainerNotFound,
+		},
+		{
+			name:     "image pruned",
+			status:   http.StatusNotFound,
+			message:  "No such image: nas-app-demo:latest",
+			wantKind: KindNotFound,
+			wantCode: CodeImageNotFound,
+		},
+		{
+			name:     "network gone",
+			status:   http.StatusNotFound,
+			message:  "network nas-shared not found",
+			wantKind: KindNotFound,
+			wantCode: CodeNotFound,
+		},
+		{
+			name:     "container name taken",
+			status:   http.StatusConflict,
+			message:  `Conflict. The container name "/nas-app-demo" is already in use by container "3f2a9c1e7b44". You have to remove (or rename) that container to be able to reuse that name.`,
+			wantKind: KindConflict,
+			wantCode: CodeContainerNameConflict,
+		},
+		{
+			name:     "image in use",
+			status:   http.StatusConflict,
+			message:  "conflict: unable to remove repository reference \"nas-app-demo:latest\" (must force) - container 3f2a9c1e7b44 is using its referenced image 5d1e2f",
+			wantKind: KindConflict,
+			wantCode: CodeConflict,
+		},
+		{
+			// Reported as a 500; only the message says what happened
+			name:     "port held by another container",
+			status:   http.StatusInternalServerError,
+			message:  "driver failed programming external connectivity on endpoint nas-app-demo (9c1e): Bind for 0.0.0.0:8080 failed: port is already allocated",
+			wantKind: KindConflict,
+			wantCode: CodePortAllocated,
+		},
+		{
+			name:     "port held by a host process",
+			status:   http.StatusInternalServerError,
+			message:  "driver failed programming external connectivity on endpoint nas-app-demo (9c1e): Error starting userland proxy: listen tcp4 0.0.0.0:8080: bind: address already in use",
+			wantKind: KindConflict,
+			wantCode: CodePortAllocated,
+		},
+		{
+			name:     "bad reference",
+			status:   http.StatusBadRequest,
+			message:  "invalid reference format: repository name (library/Demo) must be lowercase",
+			wantKind: KindInvalid,
+			wantCode: CodeInvalidParameter,
+		},
+		{
+			name:     "daemon shutting down",
+			status:   http.StatusServiceUnavailable,
+			message:  "daemon is shutting down",
+			wantKind: KindUnavailable,
+			wantCode: CodeUnavailable,
+		},
+		{
+			name:     "anything else",
+			status:   http.StatusInternalServerError,
+			message:  "error evaluating symlinks from mount source \"/mnt/user/appdata/demo\": lstat /mnt/user/appdata/demo: no such file or directory",
+			wantKind: KindInternal,
+			wantCode: CodeInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, daemonError(tt.status, tt.message))
+			err := c.StartContainer(context.Background(), "3f2a9c1e7b44")
+			e, ok := AsError(err)
+			if !ok {
+				t.Fatalf("err = %v (%T), want a *docker.Error", err, err)
+			}
+			if e.Kind != tt.wantKind || e.Code != tt.wantCode {
+				t.Errorf("kind %d code %s, want %d %s", e.Kind, e.Code, tt.wantKind, tt.wantCode)
+			}
+			// The daemon's message reads the same after translation
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("message = %q, want it to carry %q", err.Error(), tt.message)
+			}
+			if IsNotFound(err) != (tt.wantKind == KindNotFound) {
+				t.Errorf("IsNotFound = %v", IsNotFound(err))
+			}
+			if IsNoSuchImage(err) != (tt.wantCode == CodeImageNotFound) {
+				t.Errorf("IsNoSuchImage = %v", IsNoSuchImage(err))
+			}
+		})
+	}
+}
+
+func TestTranslateDaemonDown(t *testing.T) {
+	// A port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	c := newClientAt(t, "tcp://"+addr)
+	e, ok := AsError(c.StopContainer(context.Background(), "3f2a9c1e7b44"))
+	if !ok || e.Kind != KindUnavailable || e.Code != CodeUnavailable {
+		t.Errorf("err = %+v, want %s", e, CodeUnavailable)
+	}
+}
+
+func TestTranslatePassesThrough(t *testing.T) {
+	if translate(nil) != nil {
+		t.Error("translate(nil) != nil")
+	}
+	// Context errors stay context errors, so a timeout is still a timeout
+	for _, err := range []error{context.Canceled, fmt.Errorf("inspect: %w", context.DeadlineExceeded)} {
+		if got := translate(err); got != err {
+			t.Errorf("translate(%v) = %v (%T)", err, got, got)
+		}
+	}
+	// Already translated errors keep their code
+	conflict := NameConflict("nas-app-demo")
+	if got := translate(fmt.Errorf("create: %w", conflict)); !errors.Is(got, conflict) {
+		t.Errorf("translated twice: %v", got)
+	}
+}
@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ContainerExit is a controller-managed container stopping, as seen on
+// Docker's event stream.
+type ContainerExit struct {
+	ContainerID   string
+	ContainerName string
+	AppID         string
+	Replica       int
+	ExitCode      int
+	// OOM is set when the kernel OOM killer hit the container just before it
+	// died. By the time the exit is handled Docker may have restarted it and
+	// cleared State.OOMKilled, so the event is the reliable signal.
+	OOM bool
+	// At is when Docker saw the container die.
+	At time.Time
+}
+
+// ExitState is what became of a container after it exited.
+type ExitState struct {
+	Running    bool
+	Restarting bool
+	// MemoryLimit is the container's memory limit in bytes; 0 is unlimited.
+	MemoryLimit  int64
+	RestartCount int
+	// StartedAt is when the container last started; after an exit Docker
+	// restarted, that is after the exit.
+	StartedAt time.Time
+}
+
+// WatchExits calls onExit whenever a container carrying AppIDLabel dies,
+// until ctx is done or the event stream fails.
+func (c *Client) WatchExits(ctx context.Context, onExit func(ContainerExit)) error {
+	msgs, errs := c.cli.Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("event", string(events.ActionOOM)),
+			filters.Arg("event", string(events.ActionDie)),
+			filters.Arg("label", AppIDLabel),
+		),
+	})
+
+	// Docker sends oom before the die it causes
+	oomKilled := make(map[string]bool)
+	for {
+		select {
+		case msg := <-msgs:
+			if msg.Action == events.ActionOOM {
+				oomKilled[msg.Actor.ID] = true
+				continue
+			}
+			if msg.Action != events.ActionDie {
+				continue
+			}
+			exitCode, _ := strconv.Atoi(msg.Actor.Attributes["exitCode"])
+			replica, _ := strconv.Atoi(msg.Actor.Attributes[ReplicaLabel])
+			at := time.Now()
+			if msg.TimeNano != 0 {
+				at = time.Unix(0, msg.TimeNano)
+			}
+			onExit(ContainerExit{
+				ContainerID:   msg.Actor.ID,
+				ContainerName: msg.Actor.Attributes["name"],
+				AppID:         msg.Actor.Attributes[AppIDLabel],
+				Replica:       replica,
+				ExitCode:      exitCode,
+				OOM:           oomKilled[msg.Actor.ID],
+				At:            at,
+			})
+			delete(oomKilled, msg.Actor.ID)
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ContainerExitState inspects a container that exited.
+func (c *Client) ContainerExitState(ctx context.Context, containerID string) (*ExitState, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	info, err := c.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, translate(err)
+	}
+	state := &ExitState{RestartCount: info.RestartCount}
+	if info.State != nil {
+		state.Running = info.State.Running
+		state.Restarting = info.State.Restarting
+		state.StartedAt, _ = time.Parse(time.RFC3339Nano, info.State.StartedAt)
+	}
+	if info.HostConfig != nil {
+		state.MemoryLimit = info.HostConfig.Memory
+	}
+	return state, nil
+}
@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// GPU vendors an app can request.
+const (
+	GPUNvidia = "nvidia"
+	GPUIntel  = "intel"
+)
+
+// GPUConfig describes the GPU passthrough for a container. The zero value
+// means no GPU.
+type GPUConfig struct {
+	Vendor string `json:"vendor,omitempty"`
+	// Capabilities are NVIDIA driver capabilities (compute, video, ...).
+	// Empty means the driver default.
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Runtime runs the container under the nvidia runtime, as the Unraid
+	// NVIDIA driver plugin expects.
+	Runtime bool `json:"runtime,omitempty"`
+}
+
+// GPUSupport is what the host offers for GPU passthrough.
+type GPUSupport struct {
+	Nvidia        bool `json:"nvidia"`
+	NvidiaRuntime bool `json:"nvidiaRuntime"`
+	Intel         bool `json:"intel"`
+}
+
+// applyGPU adds gpu's device requests or mappings to a container config.
+func applyGPU(config *container.Config, hostConfig *container.HostConfig, gpu GPUConfig) {
+	switch gpu.Vendor {
+	case GPUNvidia:
+		hostConfig.DeviceRequests = []container.DeviceRequest{{
+			Driver:       "nvidia",
+			Count:        -1,
+			Capabilities: [][]string{{"gpu"}},
+		}}
+		if gpu.Runtime {
+			hostConfig.Runtime = "nvidia"
+			config.Env = append(config.Env, "NVIDIA_VISIBLE_DEVICES=all")
+		}
+		if len(gpu.Capabilities) > 0 {
+			config.Env = append(config.Env, "NVIDIA_DRIVER_CAPABILITIES="+strings.Join(gpu.Capabilities, ","))
+		}
+	case GPUIntel:
+		hostConfig.Devices = append(hostConfig.Devices, container.DeviceMapping{
+			PathOnHost:        "/dev/dri",
+			PathInContainer:   "/dev/dri",
+			CgroupPermissions: "rwm",
+		})
+	}
+}
+
+// DetectGPU reports GPU support from the Docker daemon's runtimes and the
+// device nodes visible to the controller.
+func (c *Client) DetectGPU(ctx context.Context) GPUSupport {
+	var support GPUSupport
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	if info, err := c.cli.Info(ctx); err == nil {
+		_, support.NvidiaRuntime = info.Runtimes["nvidia"]
+	}
+	if _, err := os.Stat("/dev/nvidia0"); err == nil || support.NvidiaRuntime {
+		support.Nvidia = true
+	}
+	if _, err := os.Stat("/dev/dri"); err == nil {
+		support.Intel = true
+	}
+	return support
+}
@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Health statuses as Docker reports them. HealthNone means the image has
+// no HEALTHCHECK.
+const (
+	HealthNone      = "none"
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// HealthState is a container's health and Docker's log of its last few
+// probes, oldest first.
+type HealthState struct {
+	Status        string
+	FailingStreak int
+	Probes        []HealthProbe
+}
+
+type HealthProbe struct {
+	Start    time.Time
+	End      time.Time
+	ExitCode int
+	Output   string
+}
+
+// HealthcheckConfig overrides the image's HEALTHCHECK. Command runs with
+// the container's shell; zero Interval and Retries keep Docker's defaults.
+type HealthcheckConfig struct {
+	Command  string        `json:"command"`
+	Interval time.Duration `json:"interval,omitempty"`
+	Retries  int           `json:"retries,omitempty"`
+}
+
+func applyHealthcheck(config *container.Config, healthcheck *HealthcheckConfig) {
+	if healthcheck == nil {
+		return
+	}
+	config.Healthcheck = &container.HealthConfig{
+		Test:     []string{"CMD-SHELL", healthcheck.Command},
+		Interval: healthcheck.Interval,
+		Retries:  healthcheck.Retries,
+	}
+}
+
+// HealthEvent is a container's HEALTHCHECK status changing. Docker only
+// reports changes (starting -> healthy, healthy -> unhealthy, ...), not
+// every probe.
+type HealthEvent struct {
+	ContainerID   string
+	ContainerName string
+	Status        string
+}
+
+// WatchHealth calls onEvent for every health status change until ctx is
+// done or the event stream fails (e.g. the daemon restarted), returning the
+// stream's error.
+func (c *Client) WatchHealth(ctx context.Context, onEvent func(HealthEvent)) error {
+	msgs, errs := c.cli.Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("event", string(events.ActionHealthStatus)),
+		),
+	})
+	for {
+		select {
+		case msg := <-msgs:
+			// Action is "health_status: healthy" etc.
+			status, ok := strings.CutPrefix(string(msg.Action), string(events.ActionHealthStatus)+": ")
+			if !ok {
+				continue
+			}
+			onEvent(HealthEvent{
+				ContainerID:   msg.Actor.ID,
+				ContainerName: msg.Actor.Attributes["name"],
+				Status:        status,
+			})
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ContainerHealth returns the container's health state. It is nil if the
+// image has no HEALTHCHECK.
+func (c *Client) ContainerHealth(ctx context.Context, containerID string) (*HealthState, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	info, err := c.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	if info.State == nil || info.State.Health == nil {
+		return nil, nil
+	}
+
+	state := &HealthState{
+		Status:        info.State.Health.Status,
+		FailingStreak: info.State.Health.FailingStreak,
+	}
+	for _, result := range info.State.Health.Log {
+		if result == nil {
+			continue
+		}
+		state.Probes = append(state.Probes, HealthProbe{
+			Start:    result.Start,
+			End:      result.End,
+			ExitCode: result.ExitCode,
+			Output:   result.Output,
+		})
+	}
+	return state, nil
+}
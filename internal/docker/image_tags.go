@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ImageDetails is what InspectImage reports of an image.
+type ImageDetails struct {
+	ID      string
+	Size    int64
+	Created time.Time
+}
+
+// InspectImage returns the ID, size and creation time of the image ref
+// names.
+func (c *Client) InspectImage(ctx context.Context, ref string) (*ImageDetails, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	inspect, _, err := c.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return nil, translate(err)
+	}
+	details := &ImageDetails{ID: inspect.ID, Size: inspect.Size}
+	details.Created, _ = time.Parse(time.RFC3339Nano, inspect.Created)
+	return details, nil
+}
+
+// TagImage adds target as another name for the image source names.
+func (c *Client) TagImage(ctx context.Context, source string, target string) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	return translate(c.cli.ImageTag(ctx, source, target))
+}
+
+// ImageUniqueSizes returns, by repo tag, how much of each image no other
+// image shares: what removing it would free. Like VolumeSizes it asks for
+// the daemon's disk usage, so it is not cheap.
+func (c *Client) ImageUniqueSizes(ctx context.Context) (map[string]int64, error) {
+	usage, err := c.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.ImageObject}})
+	if err != nil {
+		return nil, translate(err)
+	}
+	sizes := make(map[string]int64)
+	for _, img := range usage.Images {
+		unique := img.Size
+		if img.SharedSize > 0 {
+			unique -= img.SharedSize
+		}
+		for _, tag := range img.RepoTags {
+			sizes[tag] = unique
+		}
+	}
+	return sizes, nil
+}
@@ -0,0 +1,51 @@
+package docker
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Labels the controller puts on every container it creates, so they can be
+// found without relying on the container name.
+const (
+	AppIDLabel   = "nas-controller.app-id"
+	ReplicaLabel = "nas-controller.replica"
+	// SharedEnvLabel fingerprints the controller-wide environment (TZ and
+	// global env) the container was created with, to tell when it's stale.
+	SharedEnvLabel = "nas-controller.shared-env"
+	// ServiceLabel names the compose service of an app's other containers,
+	// which carry no ReplicaLabel.
+	ServiceLabel = "nas-controller.service"
+)
+
+// Labels Unraid's Docker tab reads to link and decorate a container.
+const (
+	UnraidWebUILabel = "net.unraid.docker.webui"
+	UnraidIconLabel  = "net.unraid.docker.icon"
+)
+
+// GetAppContainer returns the container labelled as replica (1 is the
+// primary) of appID, or nil if there is none. Containers created before
+// labels were added are not found; callers fall back to the name.
+func (c *Client) GetAppContainer(ctx context.Context, appID string, replica int) (*types.Container, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	containers, err := c.cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", AppIDLabel+"="+appID),
+			filters.Arg("label", ReplicaLabel+"="+strconv.Itoa(replica)),
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, nil
+	}
+	return &containers[0], nil
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// LogConfig caps a container's log files. MaxSize is a size such as "10m";
+// empty leaves the daemon's default (unbounded for json-file). MaxFiles is
+// how many rotated files to keep and only applies with a MaxSize.
+type LogConfig struct {
+	MaxSize  string `json:"maxSize,omitempty"`
+	MaxFiles int    `json:"maxFiles,omitempty"`
+}
+
+// applyLogConfig sets the log options without naming a driver, so the
+// daemon's default driver (json-file, or local) is kept. Both take max-size
+// and max-file.
+func applyLogConfig(hostConfig *container.HostConfig, logConfig LogConfig) {
+	if logConfig.MaxSize == "" {
+		return
+	}
+	hostConfig.LogConfig.Config = map[string]string{"max-size": logConfig.MaxSize}
+	if logConfig.MaxFiles > 0 {
+		hostConfig.LogConfig.Config["max-file"] = strconv.Itoa(logConfig.MaxFiles)
+	}
+}
+
+// ContainerLogUsage is the size of a managed container's log files.
+type ContainerLogUsage struct {
+	ContainerID   string `json:"containerId"`
+	ContainerName string `json:"containerName"`
+	AppID         string `json:"appId"`
+	Replica       int    `json:"replica"`
+	// Bytes covers the current file and its rotations; -1 when the files
+	// can't be read from here.
+	Bytes    int64  `json:"bytes"`
+	MaxSize  string `json:"maxSize,omitempty"`
+	MaxFiles string `json:"maxFiles,omitempty"`
+}
+
+// ContainerLogUsages reports the log size of every managed container. Docker
+// only gives the log path, which is on the host, so sizes are known only when
+// the controller can see it (/var/lib/docker/containers mapped in).
+func (c *Client) ContainerLogUsages(ctx context.Context) ([]*ContainerLogUsage, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	containers, err := c.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", AppIDLabel)),
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	usages := []*ContainerLogUsage{}
+	for _, cont := range containers {
+		info, err := c.cli.ContainerInspect(ctx, cont.ID)
+		if err != nil {
+			continue
+		}
+		replica, _ := strconv.Atoi(cont.Labels[ReplicaLabel])
+		usage := &ContainerLogUsage{
+			ContainerID:   cont.ID,
+			ContainerName: strings.TrimPrefix(info.Name, "/"),
+			AppID:         cont.Labels[AppIDLabel],
+			Replica:       replica,
+			Bytes:         logFilesSize(info.LogPath),
+		}
+		if info.HostConfig != nil {
+			usage.MaxSize = info.HostConfig.LogConfig.Config["max-size"]
+			usage.MaxFiles = info.HostConfig.LogConfig.Config["max-file"]
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// logFilesSize adds up a log file and its rotations (path.1, path.2, ...,
+// possibly gzipped).
+func logFilesSize(path string) int64 {
+	if path == "" {
+		return -1
+	}
+	if _, err := os.Stat(path); err != nil {
+		return -1
+	}
+	matches, _ := filepath.Glob(path + "*")
+	var size int64
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
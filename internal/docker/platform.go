@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// platformPart is one segment of an os/arch[/variant] platform.
+var platformPart = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// architectures maps the daemon's uname-style architecture to the name
+// platforms use for it.
+var architectures = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armv7l":  "arm/v7",
+	"armv6l":  "arm/v6",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// ParsePlatform parses a platform such as linux/amd64 or linux/arm/v7.
+func ParsePlatform(platform string) (*ocispec.Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("platform %q must be os/arch or os/arch/variant, such as linux/arm64", platform)
+	}
+	for _, part := range parts {
+		if !platformPart.MatchString(part) {
+			return nil, fmt.Errorf("platform %q must be os/arch or os/arch/variant, such as linux/arm64", platform)
+		}
+	}
+	p := &ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// HostPlatform is the platform the daemon runs containers for natively,
+// such as linux/amd64, or "" if it can't be asked.
+func (c *Client) HostPlatform(ctx context.Context) string {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	info, err := c.cli.Info(ctx)
+	if err != nil || info.OSType == "" || info.Architecture == "" {
+		return ""
+	}
+	arch := info.Architecture
+	if mapped, ok := architectures[arch]; ok {
+		arch = mapped
+	}
+	return info.OSType + "/" + arch
+}
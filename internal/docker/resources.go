@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ResourceConfig holds a container's memory settings, in bytes. Zero leaves
+// Docker's default: no limit, no reservation and a 64MB /dev/shm.
+// MemorySwap is memory plus swap, so it is at least Memory; -1 allows
+// unlimited swap. Ulimits override the daemon's default ulimits; none
+// keeps them.
+type ResourceConfig struct {
+	Memory            int64    `json:"memory"`
+	MemorySwap        int64    `json:"memorySwap"`
+	MemoryReservation int64    `json:"memoryReservation"`
+	ShmSize           int64    `json:"shmSize"`
+	Ulimits           []Ulimit `json:"ulimits,omitempty"`
+}
+
+// Ulimit is one --ulimit name=soft:hard; -1 is unlimited.
+type Ulimit struct {
+	Name string `json:"name"`
+	Soft int64  `json:"soft"`
+	Hard int64  `json:"hard"`
+}
+
+func applyResources(hostConfig *container.HostConfig, resources ResourceConfig) {
+	hostConfig.Memory = resources.Memory
+	hostConfig.MemorySwap = resources.MemorySwap
+	hostConfig.MemoryReservation = resources.MemoryReservation
+	hostConfig.ShmSize = resources.ShmSize
+	for _, u := range resources.Ulimits {
+		hostConfig.Ulimits = append(hostConfig.Ulimits, &container.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+	}
+}
+
+// ContainerResources reads back the memory settings a container was created
+// with. Docker fills in what it defaulted, such as the shm size and, with a
+// memory limit but no swap limit, twice the limit for MemorySwap.
+func (c *Client) ContainerResources(ctx context.Context, containerID string) (*ResourceConfig, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	info, err := c.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, translate(err)
+	}
+	if info.HostConfig == nil {
+		return &ResourceConfig{}, nil
+	}
+	resources := &ResourceConfig{
+		Memory:            info.HostConfig.Memory,
+		MemorySwap:        info.HostConfig.MemorySwap,
+		MemoryReservation: info.HostConfig.MemoryReservation,
+		ShmSize:           info.HostConfig.ShmSize,
+	}
+	for _, u := range info.HostConfig.Ulimits {
+		if u != nil {
+			resources.Ulimits = append(resources.Ulimits, Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+		}
+	}
+	return resources, nil
+}
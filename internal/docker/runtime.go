@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ContainerRuntime is what the app manager asks of Docker to run apps:
+// containers and the networks they join. Client implements it against the
+// daemon; tests can swap in a fake.
+type ContainerRuntime interface {
+	CreateContainer(ctx context.Context, spec ContainerSpec) (string, error)
+	StartContainer(ctx context.Context, containerID string) error
+	StopContainer(ctx context.Context, containerID string) error
+	RemoveContainer(ctx context.Context, containerID string, force bool) error
+	UpdateRestartPolicy(ctx context.Context, containerID string, policy string, maxRetries int) error
+
+	GetAppContainer(ctx context.Context, appID string, replica int) (*types.Container, error)
+	GetContainerByName(ctx context.Context, name string) (*types.Container, error)
+	GetContainersOnPort(ctx context.Context, port int) ([]*types.Container, error)
+	GetContainerStatus(ctx context.Context, containerID string) (string, string, error)
+	GetContainerUptime(ctx context.Context, containerID string) (string, error)
+	ContainerStartedAt(ctx context.Context, containerID string) (*time.Time, error)
+	ContainerResources(ctx context.Context, containerID string) (*ResourceConfig, error)
+	ContainerUsage(ctx context.Context, containerID string) (*ContainerUsage, error)
+	VolumeSizes(ctx context.Context) (map[string]int64, error)
+
+	NetworkExists(ctx context.Context, name string) (bool, error)
+	EnsureNetwork(ctx context.Context, name string) error
+	RemoveNetworkIfUnused(ctx context.Context, name string) (bool, error)
+	CheckStaticIP(ctx context.Context, networkName string, ip string, owner string) error
+}
+
+// ImageBuilder is what building and pulling images asks of Docker.
+type ImageBuilder interface {
+	BuildImage(ctx context.Context, contextPath string, dockerfilePath string, imageName string, buildArgs map[string]string, networkMode string, options BuildOptions, logWriter io.Writer) error
+	PullImage(ctx context.Context, ref string, onStatus func(string)) error
+	RemoveImage(ctx context.Context, imageName string) error
+	GetImageSize(ctx context.Context, imageName string) (int64, error)
+	ImageDigest(ctx context.Context, ref string) (string, error)
+	ServerVersion(ctx context.Context) (string, error)
+	BuildCache(ctx context.Context) ([]BuildCacheEntry, error)
+	PruneBuildCache(ctx context.Context, ids []string, unusedFor time.Duration) (uint64, int, error)
+	TagImage(ctx context.Context, source string, target string) error
+	InspectImage(ctx context.Context, ref string) (*ImageDetails, error)
+	ImageUniqueSizes(ctx context.Context) (map[string]int64, error)
+}
+
+// Runtime is both: everything the app manager needs from Docker.
+type Runtime interface {
+	ContainerRuntime
+	ImageBuilder
+}
+
+var _ Runtime = (*Client)(nil)
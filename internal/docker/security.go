@@ -0,0 +1,16 @@
+package docker
+
+import "github.com/docker/docker/api/types/container"
+
+// SecurityConfig is the access a container gets beyond Docker's defaults.
+type SecurityConfig struct {
+	Privileged bool     `json:"privileged,omitempty"`
+	CapAdd     []string `json:"capAdd,omitempty"`
+	CapDrop    []string `json:"capDrop,omitempty"`
+}
+
+func applySecurity(hostConfig *container.HostConfig, security SecurityConfig) {
+	hostConfig.Privileged = security.Privileged
+	hostConfig.CapAdd = security.CapAdd
+	hostConfig.CapDrop = security.CapDrop
+}
@@ -0,0 +1,78 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/network"
+)
+
+// ManagedNetworkLabel marks networks the controller created, so it only
+// ever removes its own.
+const ManagedNetworkLabel = "nas-controller.managed"
+
+// SharedNetwork is a network a container joins besides its own, under
+// Aliases. The zero value joins nothing.
+type SharedNetwork struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// EnsureNetwork creates a bridge network called name, labelled as the
+// controller's, unless a network by that name already exists.
+func (c *Client) EnsureNetwork(ctx context.Context, name string) error {
+	exists, err := c.NetworkExists(ctx, name)
+	if err != nil {
+		return translate(err)
+	}
+	if exists {
+		return nil
+	}
+
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	_, err = c.cli.NetworkCreate(ctx, name, network.CreateOptions{
+		Driver: "bridge",
+		Labels: map[string]string{ManagedNetworkLabel: "true"},
+	})
+	if err != nil {
+		// Another start may have created it in the meantime
+		if exists, _ := c.NetworkExists(ctx, name); exists {
+			return nil
+		}
+		return fmt.Errorf("failed to create network %s: %w", name, translate(err))
+	}
+	return nil
+}
+
+// RemoveNetworkIfUnused removes the network called name if the controller
+// created it and no container is attached, and reports whether it did.
+func (c *Client) RemoveNetworkIfUnused(ctx context.Context, name string) (bool, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	inspect, err := c.cli.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err != nil {
+		if e, ok := AsError(translate(err)); ok && e.Kind == KindNotFound {
+			return false, nil
+		}
+		return false, translate(err)
+	}
+	if inspect.Labels[ManagedNetworkLabel] != "true" || len(inspect.Containers) > 0 {
+		return false, nil
+	}
+	if err := c.cli.NetworkRemove(ctx, inspect.ID); err != nil {
+		return false, translate(err)
+	}
+	return true, nil
+}
+
+// joinSharedNetwork attaches a just-created container to shared.
+func (c *Client) joinSharedNetwork(ctx context.Context, containerID string, shared SharedNetwork) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	err := c.cli.NetworkConnect(ctx, shared.Name, containerID, &network.EndpointSettings{Aliases: shared.Aliases})
+	if err != nil {
+		return fmt.Errorf("failed to join network %s: %w", shared.Name, translate(err))
+	}
+	return nil
+}
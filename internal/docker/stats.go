@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ContainerUsage is a point-in-time reading of a container's cumulative CPU
+// counters and current memory use. CPU percentages come from the difference
+// between two readings.
+type ContainerUsage struct {
+	// CPUTotal is the container's CPU time so far, in nanoseconds.
+	CPUTotal uint64
+	// SystemCPU is the host's CPU time so far, in nanoseconds. Zero on
+	// Windows daemons.
+	SystemCPU  uint64
+	OnlineCPUs uint32
+	// Memory is the usage less the page cache the kernel can reclaim, as
+	// `docker stats` reports it.
+	Memory uint64
+}
+
+// ContainerUsage reads the container's current usage without streaming.
+// Docker leaves the previous-sample fields of a one-shot read empty, so
+// callers compute CPU deltas against their own last reading.
+func (c *Client) ContainerUsage(ctx context.Context, containerID string) (*ContainerUsage, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	resp, err := c.cli.ContainerStatsOneShot(ctx, containerID)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer resp.Body.Close()
+
+	var stats container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("failed to decode stats: %v", err)
+	}
+
+	memory := stats.MemoryStats.Usage
+	// cgroup v2 reports inactive_file, v1 total_inactive_file
+	cache, ok := stats.MemoryStats.Stats["inactive_file"]
+	if !ok {
+		cache = stats.MemoryStats.Stats["total_inactive_file"]
+	}
+	if cache < memory {
+		memory -= cache
+	}
+
+	onlineCPUs := stats.CPUStats.OnlineCPUs
+	if onlineCPUs == 0 {
+		onlineCPUs = uint32(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return &ContainerUsage{
+		CPUTotal:   stats.CPUStats.CPUUsage.TotalUsage,
+		SystemCPU:  stats.CPUStats.SystemUsage,
+		OnlineCPUs: onlineCPUs,
+		Memory:     memory,
+	}, nil
+}
@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRequestTimeout bounds one Docker API request until
+	// SetTimeouts says otherwise.
+	DefaultRequestTimeout = 30 * time.Second
+	// DefaultStopTimeout is how long a stopped container gets to exit
+	// before it is killed.
+	DefaultStopTimeout = 30 * time.Second
+)
+
+// timeouts holds the client's request and stop timeouts, which can change
+// while requests are in flight.
+type timeouts struct {
+	mu      sync.RWMutex
+	request time.Duration
+	stop    time.Duration
+}
+
+// SetTimeouts sets how long one API request may take and how long a
+// container gets to exit on stop. Zero keeps the current value. Streams
+// (builds, pulls, logs, events) and the slow whole-daemon calls (prune,
+// disk usage) are bounded by their caller's context instead.
+func (c *Client) SetTimeouts(request, stop time.Duration) {
+	c.timeouts.mu.Lock()
+	defer c.timeouts.mu.Unlock()
+	if request > 0 {
+		c.timeouts.request = request
+	}
+	if stop > 0 {
+		c.timeouts.stop = stop
+	}
+}
+
+func (c *Client) getTimeouts() (request, stop time.Duration) {
+	c.timeouts.mu.RLock()
+	defer c.timeouts.mu.RUnlock()
+	return c.timeouts.request, c.timeouts.stop
+}
+
+// requestContext bounds ctx by the request timeout, for a call that should
+// come back quickly.
+func (c *Client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	request, _ := c.getTimeouts()
+	return context.WithTimeout(ctx, request)
+}
@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowDaemon answers nothing until the request is given up on, like a
+// daemon wedged on a hung storage driver. It records each request's query.
+type slowDaemon struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (d *slowDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	d.queries = append(d.queries, r.URL.RawQuery)
+	d.mu.Unlock()
+	<-r.Context().Done()
+}
+
+func (d *slowDaemon) Queries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	c := newTestClient(t, &slowDaemon{})
+	c.SetTimeouts(50*time.Millisecond, 0)
+
+	calls := map[string]func(ctx context.Context) error{
+		"start": func(ctx context.Context) error { return c.StartContainer(ctx, "demo") },
+		"status": func(ctx context.Context) error {
+			_, _, err := c.GetContainerStatus(ctx, "demo")
+			return err
+		},
+		"remove image": func(ctx context.Context) error { return c.RemoveImage(ctx, "demo:latest") },
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			err := call(context.Background())
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("err = %v, want a deadline exceeded", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("gave up after %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestStopTimeoutExtendsRequest(t *testing.T) {
+	daemon := &slowDaemon{}
+	c := newTestClient(t, daemon)
+	c.SetTimeouts(50*time.Millisecond, time.Second)
+
+	// The daemon is told how long the container gets, and the request
+	// waits that long on top of the request timeout
+	start := time.Now()
+	err := c.StopContainer(context.Background(), "demo")
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want a deadline exceeded", err)
+	}
+	if elapsed < time.Second || elapsed > 3*time.Second {
+		t.Errorf("stop gave up after %s, want about 1.05s", elapsed)
+	}
+	if queries := daemon.Queries(); len(queries) != 1 || queries[0] != "t=1" {
+		t.Errorf("queries = %v, want t=1", queries)
+	}
+}
+
+func TestSetTimeoutsKeepsUnsetValues(t *testing.T) {
+	c := newClientAt(t, "tcp://127.0.0.1:2375")
+	c.SetTimeouts(time.Minute, 0)
+	c.SetTimeouts(0, 5*time.Second)
+	if request, stop := c.getTimeouts(); request != time.Minute || stop != 5*time.Second {
+		t.Errorf("timeouts = %s, %s", request, stop)
+	}
+}
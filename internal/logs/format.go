@@ -0,0 +1,89 @@
+package logs
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"time"
+)
+
+// Format controls how lines are presented: the timestamp Docker prepends
+// to each line, and terminal escape codes. The zero value leaves lines
+// untouched.
+type Format struct {
+	StripTimestamps bool
+	// Location rewrites timestamps into this zone. Ignored when they are
+	// stripped.
+	Location *time.Location
+	// StripANSI removes color and cursor escape codes.
+	StripANSI bool
+}
+
+// Raw reports whether f leaves lines untouched.
+func (f Format) Raw() bool {
+	return !f.StripTimestamps && f.Location == nil && !f.StripANSI
+}
+
+// AppendLine appends line to dst formatted per f. Lines that don't start
+// with an RFC3339 timestamp keep whatever they start with.
+func (f Format) AppendLine(dst []byte, line []byte) []byte {
+	if f.Raw() {
+		return append(dst, line...)
+	}
+	if f.StripANSI {
+		line = StripANSI(line)
+	}
+	if !f.StripTimestamps && f.Location == nil {
+		return append(dst, line...)
+	}
+
+	sp := bytes.IndexByte(line, ' ')
+	if sp <= 0 {
+		return append(dst, line...)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(line[:sp]))
+	if err != nil {
+		return append(dst, line...)
+	}
+
+	if !f.StripTimestamps {
+		dst = ts.In(f.Location).AppendFormat(dst, time.RFC3339Nano)
+		dst = append(dst, ' ')
+	}
+	return append(dst, line[sp+1:]...)
+}
+
+// ansiPattern matches CSI sequences (colors, cursor movement) and OSC
+// sequences (window titles, hyperlinks).
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)`)
+
+// StripANSI returns data without terminal escape codes.
+func StripANSI(data []byte) []byte {
+	if bytes.IndexByte(data, 0x1b) < 0 {
+		return data
+	}
+	return ansiPattern.ReplaceAll(data, nil)
+}
+
+// ReadAll reads Docker log output from r to the end and returns its lines
+// formatted per f, stdout and stderr together in the order written.
+func ReadAll(r io.Reader, f Format) ([]byte, error) {
+	var result []byte
+	frames := NewFrameReader(r)
+	for {
+		line, err := frames.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		result = f.AppendLine(result, line.Data)
+	}
+}
+
+// Demux is ReadAll over output that is already in memory.
+func Demux(data []byte, f Format) []byte {
+	result, _ := ReadAll(bytes.NewReader(data), f)
+	return result
+}
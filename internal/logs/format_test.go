@@ -0,0 +1,27 @@
+package logs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatAppendLine(t *testing.T) {
+	utc := "2024-05-01T10:00:00.5Z hello\n"
+	tests := []struct {
+		name   string
+		format Format
+		line   string
+		want   string
+	}{
+		{"raw", Format{}, utc, utc},
+		{"strip timestamp", Format{StripTimestamps: true}, utc, "hello\n"},
+		{"zone", Format{Location: time.FixedZone("CEST", 2*60*60)}, utc, "2024-05-01T12:00:00.5+02:00 hello\n"},
+		{"no timestamp to strip", Format{StripTimestamps: true}, "hello\n", "hello\n"},
+		{"strip ansi", Format{StripANSI: true}, "\x1b[1;31merror\x1b[0m \x1b]0;title\x07done\n", "error done\n"},
+	}
+	for _, tt := range tests {
+		if got := string(tt.format.AppendLine(nil, []byte(tt.line))); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Stream is the output a log line was written to, as Docker's
+// multiplexing header names it.
+type Stream byte
+
+const (
+	Stdin  Stream = 0
+	Stdout Stream = 1
+	Stderr Stream = 2
+	// System is Docker's own error output, interleaved with the
+	// container's.
+	System Stream = 3
+	// Raw is output that had no headers: a TTY container, or whatever
+	// followed a header that didn't parse.
+	Raw Stream = 255
+)
+
+func (s Stream) String() string {
+	switch s {
+	case Stdin:
+		return "stdin"
+	case Stdout:
+		return "stdout"
+	case Stderr:
+		return "stderr"
+	case System:
+		return "system"
+	}
+	return "raw"
+}
+
+// MaxLineLength bounds a line. Longer ones are returned in pieces of this
+// size, so a container that never writes a newline can't grow a buffer
+// without limit.
+const MaxLineLength = 256 * 1024
+
+const headerSize = 8
+
+// Line is one line of log output. Data ends with the newline, unless the
+// output ended without one or the line was split at MaxLineLength.
+type Line struct {
+	Stream Stream
+	Data   []byte
+}
+
+// FrameReader turns Docker's multiplexed log output into lines tagged
+// with their stream. Each frame is an 8-byte header (stream, three zero
+// bytes, big-endian payload size) and its payload. A line can span frames,
+// Docker splits long lines at 16 KB, and stdout and stderr frames can
+// interleave, so partial lines are kept per stream. Output that doesn't
+// start with a valid header is a TTY's and is read as plain lines; a bad
+// header later on turns the rest of the output into Raw lines as well.
+type FrameReader struct {
+	r       *bufio.Reader
+	raw     bool
+	started bool
+	// remaining is what is left of the current frame's payload.
+	remaining int
+	stream    Stream
+	partial   map[Stream][]byte
+	buf       []byte
+	// ready holds lines completed by the last frame, to return in order.
+	ready []Line
+	err   error
+}
+
+// NewFrameReader reads frames from r.
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{
+		r:       bufio.NewReaderSize(r, 32*1024),
+		partial: make(map[Stream][]byte),
+		buf:     make([]byte, 32*1024),
+	}
+}
+
+// Next returns the next line. At the end of the output, lines that never
+// got their newline are returned, then io.EOF. Other read errors are
+// returned once the lines before them have been.
+func (f *FrameReader) Next() (Line, error) {
+	for {
+		if len(f.ready) > 0 {
+			line := f.ready[0]
+			f.ready = f.ready[1:]
+			return line, nil
+		}
+		if f.err != nil {
+			if line, ok := f.flush(); ok {
+				return line, nil
+			}
+			return Line{}, f.err
+		}
+		f.fill()
+	}
+}
+
+// fill reads the next chunk of output into ready, or sets err.
+func (f *FrameReader) fill() {
+	if !f.started {
+		f.started = true
+		header, err := f.r.Peek(headerSize)
+		if len(header) == 0 && err != nil {
+			f.err = err
+			return
+		}
+		f.raw = !validHeader(header)
+	}
+
+	if f.raw {
+		f.readRaw()
+		return
+	}
+
+	if f.remaining == 0 {
+		header, err := f.r.Peek(headerSize)
+		if len(header) < headerSize {
+			if len(header) > 0 {
+				// Output cut off inside a header: keep what's there
+				f.raw = true
+				return
+			}
+			f.err = err
+			return
+		}
+		if !validHeader(header) {
+			f.raw = true
+			return
+		}
+		f.stream = Stream(header[0])
+		f.remaining = int(header[4])<<24 | int(header[5])<<16 | int(header[6])<<8 | int(header[7])
+		f.r.Discard(headerSize)
+		return
+	}
+
+	// Read the payload in pieces, so a giant frame isn't allocated whole
+	n, err := f.r.Read(f.buf[:min(f.remaining, len(f.buf))])
+	f.add(f.stream, f.buf[:n])
+	f.remaining -= n
+	if err != nil {
+		// A truncated payload ends the output like any other error
+		f.err = err
+	}
+}
+
+// readRaw reads whatever output is available as plain lines. It doesn't
+// wait for a full buffer, so a followed TTY log shows up as it's written.
+func (f *FrameReader) readRaw() {
+	n, err := f.r.Read(f.buf)
+	f.add(Raw, f.buf[:n])
+	if err != nil {
+		f.err = err
+	}
+}
+
+// add appends data to the stream's partial line and moves every line it
+// completes to ready.
+func (f *FrameReader) add(stream Stream, data []byte) {
+	pending := f.partial[stream]
+	for len(data) > 0 {
+		end := len(data)
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			end = i + 1
+		}
+		if room := MaxLineLength - len(pending); end > room {
+			end = room
+		}
+		pending = append(pending, data[:end]...)
+		data = data[end:]
+		if len(pending) == MaxLineLength || pending[len(pending)-1] == '\n' {
+			f.ready = append(f.ready, Line{Stream: stream, Data: pending})
+			pending = nil
+		}
+	}
+	f.partial[stream] = pending
+}
+
+// flush returns a line left without its newline.
+func (f *FrameReader) flush() (Line, bool) {
+	for _, stream := range []Stream{Stdin, Stdout, Stderr, System, Raw} {
+		if data := f.partial[stream]; len(data) > 0 {
+			delete(f.partial, stream)
+			return Line{Stream: stream, Data: data}, true
+		}
+	}
+	return Line{}, false
+}
+
+// validHeader reports whether header looks like a frame header: a known
+// stream and three zero bytes.
+func validHeader(header []byte) bool {
+	return len(header) >= headerSize && header[0] <= byte(System) &&
+		header[1] == 0 && header[2] == 0 && header[3] == 0
+}
@@ -0,0 +1,169 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// frame returns payload as one multiplexed frame on stream.
+func frame(stream Stream, payload string) []byte {
+	header := make([]byte, headerSize)
+	header[0] = byte(stream)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func frames(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+type wantLine struct {
+	stream Stream
+	data   string
+}
+
+// readLines reads r through a FrameReader to the end.
+func readLines(t *testing.T, r io.Reader) ([]wantLine, error) {
+	t.Helper()
+	f := NewFrameReader(r)
+	var lines []wantLine
+	for {
+		line, err := f.Next()
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, wantLine{line.Stream, string(line.Data)})
+		if len(lines) > 1000 {
+			t.Fatal("no end to the lines")
+		}
+	}
+}
+
+func TestFrameReader(t *testing.T) {
+	giant := strings.Repeat("x", 2*MaxLineLength+10)
+
+	tests := []struct {
+		name  string
+		input []byte
+		// oneByte feeds the input a byte per read, so every header and
+		// payload is split across reads
+		oneByte bool
+		want    []wantLine
+	}{
+		{
+			name:  "empty",
+			input: nil,
+		},
+		{
+			name:  "one frame",
+			input: frame(Stdout, "hello\n"),
+			want:  []wantLine{{Stdout, "hello\n"}},
+		},
+		{
+			name:  "several lines in a frame",
+			input: frame(Stderr, "a\nb\nc\n"),
+			want:  []wantLine{{Stderr, "a\n"}, {Stderr, "b\n"}, {Stderr, "c\n"}},
+		},
+		{
+			name:  "line split across frames",
+			input: frames(frame(Stdout, "hel"), frame(Stdout, "lo\n")),
+			want:  []wantLine{{Stdout, "hello\n"}},
+		},
+		{
+			name:    "headers and payloads split across reads",
+			input:   frames(frame(Stdout, "one\n"), frame(Stderr, "two\n"), frame(Stdout, "three\n")),
+			oneByte: true,
+			want:    []wantLine{{Stdout, "one\n"}, {Stderr, "two\n"}, {Stdout, "three\n"}},
+		},
+		{
+			name:  "interleaved stdout and stderr",
+			input: frames(frame(Stdout, "out "), frame(Stderr, "err\n"), frame(Stdout, "continued\n")),
+			want:  []wantLine{{Stderr, "err\n"}, {Stdout, "out continued\n"}},
+		},
+		{
+			name:  "zero-length frames",
+			input: frames(frame(Stdout, ""), frame(Stdout, "a\n"), frame(Stderr, ""), frame(Stdout, "b\n")),
+			want:  []wantLine{{Stdout, "a\n"}, {Stdout, "b\n"}},
+		},
+		{
+			name:  "no trailing newline",
+			input: frames(frame(Stdout, "done\n"), frame(Stdout, "partial")),
+			want:  []wantLine{{Stdout, "done\n"}, {Stdout, "partial"}},
+		},
+		{
+			name:  "frame larger than the read buffer and the line limit",
+			input: frame(Stdout, giant+"\n"),
+			want: []wantLine{
+				{Stdout, giant[:MaxLineLength]},
+				{Stdout, giant[MaxLineLength : 2*MaxLineLength]},
+				{Stdout, giant[2*MaxLineLength:] + "\n"},
+			},
+		},
+		{
+			name:  "frame declaring more than the output holds",
+			input: frame(Stdout, "cut short\n")[:headerSize+4],
+			want:  []wantLine{{Stdout, "cut "}},
+		},
+		{
+			name:  "tty output without headers",
+			input: []byte("plain\nlines\n"),
+			want:  []wantLine{{Raw, "plain\n"}, {Raw, "lines\n"}},
+		},
+		{
+			name:    "tty output read a byte at a time",
+			input:   []byte("\x1b[32mgreen\x1b[0m\n"),
+			oneByte: true,
+			want:    []wantLine{{Raw, "\x1b[32mgreen\x1b[0m\n"}},
+		},
+		{
+			name:  "malformed header after valid frames",
+			input: frames(frame(Stdout, "ok\n"), []byte{9, 1, 2, 3, 'b', 'a', 'd', '\n', 'x', '\n'}),
+			want:  []wantLine{{Stdout, "ok\n"}, {Raw, "\t\x01\x02\x03bad\n"}, {Raw, "x\n"}},
+		},
+		{
+			name:  "output cut off inside a header",
+			input: frames(frame(Stdout, "ok\n"), []byte{1, 0, 0}),
+			want:  []wantLine{{Stdout, "ok\n"}, {Raw, "\x01\x00\x00"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r io.Reader = bytes.NewReader(tt.input)
+			if tt.oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			got, err := readLines(t, r)
+			if err != io.EOF {
+				t.Errorf("err = %v, want io.EOF", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lines %v, want %d %v", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("line %d = %s %q, want %s %q", i, got[i].stream, got[i].data, tt.want[i].stream, tt.want[i].data)
+				}
+			}
+		})
+	}
+}
+
+func TestFrameReaderReturnsLinesBeforeError(t *testing.T) {
+	failure := errors.New("connection reset")
+	r := io.MultiReader(bytes.NewReader(frames(frame(Stdout, "a\n"), frame(Stderr, "b"))), iotest.ErrReader(failure))
+
+	got, err := readLines(t, r)
+	if !errors.Is(err, failure) {
+		t.Errorf("err = %v, want %v", err, failure)
+	}
+	want := []wantLine{{Stdout, "a\n"}, {Stderr, "b"}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("lines = %v, want %v", got, want)
+	}
+}
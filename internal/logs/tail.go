@@ -0,0 +1,91 @@
+package logs
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// tailChunk is how far TailLines reads back at a time.
+const tailChunk = 64 * 1024
+
+// TailBytes returns up to the last n bytes of the file at path. When that
+// starts inside a line, the partial line is dropped. A missing file reads
+// as empty.
+func TailBytes(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() <= n {
+		return io.ReadAll(f)
+	}
+	// Read one byte more to tell whether the cut falls on a line start
+	if _, err := f.Seek(-(n + 1), io.SeekEnd); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[i+1:], nil
+	}
+	return data[1:], nil
+}
+
+// TailLines returns the last n lines of the file at path, reading
+// backwards from the end so a long log isn't read whole. A missing file
+// reads as empty.
+func TailLines(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	var data []byte
+	for offset := info.Size(); offset > 0; {
+		size := int64(tailChunk)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(chunk, data...)
+
+		// A trailing newline ends the last line rather than starting another
+		body := bytes.TrimSuffix(data, []byte("\n"))
+		if bytes.Count(body, []byte("\n")) >= n {
+			start := len(body)
+			for i := 0; i < n; i++ {
+				start = bytes.LastIndexByte(body[:start], '\n')
+			}
+			return data[start+1:], nil
+		}
+	}
+	return data, nil
+}
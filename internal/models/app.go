@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -13,6 +14,26 @@ const (
 	StatusBuildFailed  AppStatus = "build-failed"
 	StatusStarting     AppStatus = "starting"
 	StatusError        AppStatus = "error"
+
+	// Composite states span a multi-step flow. While one is set, SubStatus
+	// holds the step in progress (stopped, building, starting, ...).
+	StatusUpdating  AppStatus = "updating"
+	StatusDeploying AppStatus = "deploying"
+)
+
+// Where an app's source comes from. Git covers cloned repos and local
+// paths; upload is a build context uploaded as a tarball.
+const (
+	SourceTypeGit    = "git"
+	SourceTypeUpload = "upload"
+)
+
+// Icon provenance, so a manifest icon can replace a guessed one later on
+// without clobbering an icon the user picked themselves.
+const (
+	IconSourceManifest = "manifest"
+	IconSourceForge    = "forge"
+	IconSourceUpload   = "upload"
 )
 
 type App struct {
@@ -21,6 +42,8 @@ type App struct {
 	Slug        string            `json:"slug"`
 	Description string            `json:"description"`
 	Icon        string            `json:"icon"`
+	IconSource  string            `json:"iconSource"`
+	SourceType  string            `json:"sourceType"`
 	RepoURL     string            `json:"repoUrl"`
 	Branch      string            `json:"branch"`
 	LastCommit  string            `json:"lastCommit"`
@@ -29,6 +52,21 @@ type App struct {
 	DockerfilePath string         `json:"dockerfilePath"`
 	BuildContext   string         `json:"buildContext"`
 	BuildArgs      map[string]string `json:"buildArgs"`
+	OfflineBuild   bool           `json:"offlineBuild"`
+	// BuildTarget is the Dockerfile stage to build, as --target; empty
+	// builds the last stage.
+	BuildTarget    string         `json:"buildTarget"`
+	// Platform is the os/arch the image is built and run for, such as
+	// linux/arm64; empty is the host's.
+	Platform       string         `json:"platform"`
+
+	// ComposeFile is the repo's compose file, relative to its root, for an
+	// app run as a set of services; empty for a single container.
+	// ComposeService is the service published on the app's port, and
+	// ComposeContainers maps each running service to its container.
+	ComposeFile       string            `json:"composeFile,omitempty"`
+	ComposeService    string            `json:"composeService,omitempty"`
+	ComposeContainers map[string]string `json:"composeContainers,omitempty"`
 
 	ImageName     string         `json:"imageName"`
 	ContainerName string         `json:"containerName"`
@@ -36,20 +74,493 @@ type App struct {
 	InternalPort  int            `json:"internalPort"`
 	ExternalPort  int            `json:"externalPort"`
 	RestartPolicy string         `json:"restartPolicy"`
+	// MaxRetries is the retry count for the on-failure restart policy.
+	MaxRetries    int            `json:"maxRetries"`
+	// NetworkMode is NetworkModeBridge (a published port) or NetworkModeHost
+	// (the container shares the host's network; ExternalPort then mirrors
+	// InternalPort).
+	NetworkMode   string         `json:"networkMode"`
+	// Network is an existing Docker network to attach the container to
+	// instead of the default bridge. Ignored in host mode.
+	Network       string         `json:"network,omitempty"`
+	// IPAddress pins the container's IPv4 address on Network (e.g. on a
+	// macvlan br0). Empty lets Docker assign one.
+	IPAddress     string         `json:"ipAddress,omitempty"`
+
+	// GPU is "", "nvidia" or "intel". GPUCapabilities is a comma-separated
+	// list of NVIDIA driver capabilities; GPURuntime runs the container
+	// under the nvidia runtime.
+	GPU             string       `json:"gpu,omitempty"`
+	GPUCapabilities string       `json:"gpuCapabilities,omitempty"`
+	GPURuntime      bool         `json:"gpuRuntime,omitempty"`
+
+	// Replicas is how many identical containers to run. Replica 1 is
+	// ContainerName on ExternalPort; replica i > 1 is ContainerName-i on
+	// ReplicaPorts[i-2].
+	Replicas     int            `json:"replicas"`
+	ReplicaPorts []int          `json:"replicaPorts"`
 
 	Env     map[string]string `json:"env"`
 	Volumes []string          `json:"volumes"`
+	// Devices are host devices mapped into the container, each
+	// hostPath[:containerPath[:permissions]] (e.g. /dev/ttyUSB0).
+	Devices []string          `json:"devices"`
+
+	// Privileged and CapAdd/CapDrop (capability names without CAP_) grant
+	// the container access beyond Docker's defaults.
+	Privileged bool     `json:"privileged"`
+	CapAdd     []string `json:"capAdd,omitempty"`
+	CapDrop    []string `json:"capDrop,omitempty"`
+
+	// Healthcheck overrides the image's HEALTHCHECK; nil keeps the image's.
+	Healthcheck *Healthcheck `json:"healthcheck,omitempty"`
+
+	// User is who the container runs as ("uid:gid", "uid" or a name from
+	// the image); empty keeps the image's USER.
+	User string `json:"user"`
+
+	// Labels are extra container labels. The controller adds the Unraid
+	// web UI/icon labels and its own nas-controller.* labels on top.
+	Labels map[string]string `json:"labels"`
+
+	// Entrypoint and Command override the image's ENTRYPOINT and CMD. nil
+	// keeps the image's; an empty slice clears it.
+	Entrypoint []string `json:"entrypoint"`
+	Command    []string `json:"command"`
+
+	// ExtraHosts are /etc/hosts entries ("host:ip"); DNS replaces the
+	// daemon's resolvers.
+	ExtraHosts []string `json:"extraHosts"`
+	DNS        []string `json:"dns"`
+
+	// LogMaxSize and LogMaxFiles cap the container's log files (Docker's
+	// max-size and max-file). Empty and 0 use the controller-wide default.
+	LogMaxSize  string `json:"logMaxSize"`
+	LogMaxFiles int    `json:"logMaxFiles"`
+
+	// UseProxy passes the controller's proxy settings to the container as
+	// HTTP_PROXY/HTTPS_PROXY/NO_PROXY. Builds always get them.
+	UseProxy bool `json:"useProxy"`
+
+	// CustomContainerName replaces the <prefix><slug> container name;
+	// ContainerName is the name the container actually has. Hostname sets
+	// the container's hostname (Docker uses the short container ID when
+	// empty).
+	CustomContainerName string `json:"customContainerName"`
+	Hostname            string `json:"hostname"`
+
+	// MemoryLimit, MemorySwap (memory plus swap, or -1 for unlimited),
+	// MemoryReservation and ShmSize (/dev/shm) are sizes such as "1g".
+	// Empty leaves Docker's default, which for ShmSize is 64MB.
+	MemoryLimit       string `json:"memoryLimit"`
+	MemorySwap        string `json:"memorySwap"`
+	MemoryReservation string `json:"memoryReservation"`
+	ShmSize           string `json:"shmSize"`
+
+	// Ulimits override the daemon's default ulimits (docker run --ulimit).
+	Ulimits []Ulimit `json:"ulimits"`
+
+	// Sysctls are namespaced kernel parameters set in the container
+	// (docker run --sysctl), such as net.core.somaxconn.
+	Sysctls map[string]string `json:"sysctls"`
+
+	// BindAddress is the host IP the app's ports are published on. Empty
+	// uses the bindAddress setting, and with that unset every interface.
+	BindAddress string `json:"bindAddress"`
+
+	// PublishIPv6 also publishes the app's ports on [::] when they are on
+	// every interface. IPv6On or IPv6Off, or empty to follow the
+	// publishIPv6 setting.
+	PublishIPv6 string `json:"publishIPv6"`
+
+	// AutoRecreate has a build of a running app recreate its container on
+	// the new image; otherwise RestartRequired is set until it's restarted.
+	AutoRecreate bool `json:"autoRecreate"`
+
+	// NetworkIsolated keeps the app's containers off the shared app network,
+	// where the other apps reach them by slug.
+	NetworkIsolated bool `json:"networkIsolated"`
+
+	// PreserveLocalChanges has a pull stash modifications made in the
+	// checkout before resetting it to the remote branch, instead of only
+	// warning that they are discarded.
+	PreserveLocalChanges bool `json:"preserveLocalChanges"`
+
+	// FailStop leaves a running app stopped when a pull's rebuild fails,
+	// instead of restarting it on its previous image.
+	FailStop bool `json:"failStop"`
+
+	// LiteralEnv passes env values to the container as they are, for apps
+	// that need literal ${...}, instead of interpolating references to
+	// other variables.
+	LiteralEnv bool `json:"literalEnv"`
 
 	Status            AppStatus  `json:"status"`
+	SubStatus         AppStatus  `json:"subStatus,omitempty"`
+	// Health is the container's HEALTHCHECK state: healthy, unhealthy,
+	// starting, or none when it has no healthcheck or isn't running.
+	Health            string     `json:"health"`
 	LastBuild         *time.Time `json:"lastBuild"`
 	LastBuildDuration string     `json:"lastBuildDuration"`
 	LastBuildSuccess  bool       `json:"lastBuildSuccess"`
+	LastBuildNetworkMode string  `json:"lastBuildNetworkMode"`
+	LastBuildFailure  string     `json:"lastBuildFailure,omitempty"`
+	// LastBuildLogTruncated means the build log hit the size cap and only
+	// its head and tail were kept.
+	LastBuildLogTruncated bool   `json:"lastBuildLogTruncated,omitempty"`
+	// LastBuildCorrelationID ties the last build to the request that
+	// started it, in logs and error responses.
+	LastBuildCorrelationID string `json:"lastBuildCorrelationId,omitempty"`
+	// LastError is why the last start failed; RebuildRequired is set when
+	// that was because the image is gone and automatic recovery failed.
+	LastError         string     `json:"lastError,omitempty"`
+	RebuildRequired   bool       `json:"rebuildRequired,omitempty"`
+	// RestartRequired means the app's container runs an older image than
+	// the last build produced.
+	RestartRequired   bool       `json:"restartRequired,omitempty"`
+	// RolledBackTo is the commit tag (slug:1a2b3c4d) the app runs instead
+	// of its latest build, after a rollback. The next successful build
+	// clears it.
+	RolledBackTo      string     `json:"rolledBackTo,omitempty"`
+	// RunningOutdated means the app's rebuild on a pull failed and it was
+	// restarted on the image from before; FailedBuildID is that build, if
+	// it was recorded. The next successful build clears both.
+	RunningOutdated   bool       `json:"runningOutdated,omitempty"`
+	FailedBuildID     int64      `json:"failedBuildId,omitempty"`
+	// UpdateAvailable, RemoteCommit and LastChecked are the result of the
+	// last update check of the app's remote, by the background poller or
+	// on request. A pull clears UpdateAvailable. LastCheckError is why the
+	// last check failed, which leaves the other two as they were.
+	UpdateAvailable   bool       `json:"updateAvailable"`
+	RemoteCommit      string     `json:"remoteCommit,omitempty"`
+	LastChecked       *time.Time `json:"lastChecked,omitempty"`
+	LastCheckError    string     `json:"lastCheckError,omitempty"`
 	ImageSize         int64      `json:"imageSize"`
 
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// Container health states, as Docker reports them.
+const (
+	HealthNone      = "none"
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// Healthcheck is a container healthcheck defined in the app config, for
+// images that lack one. Command runs in the container's shell; Interval is
+// a duration such as "30s".
+type Healthcheck struct {
+	Command  string `json:"command"`
+	Interval string `json:"interval,omitempty"`
+	Retries  int    `json:"retries,omitempty"`
+}
+
+// Ulimit is a container ulimit, as in --ulimit name=soft:hard. -1 is
+// unlimited.
+type Ulimit struct {
+	Name string `json:"name"`
+	Soft int64  `json:"soft"`
+	Hard int64  `json:"hard"`
+}
+
+// Kinds of remote contact tracked per app.
+const (
+	ContactFetch    = "fetch"
+	ContactPull     = "pull"
+	ContactRegistry = "registry"
+	ContactWebhook  = "webhook"
+)
+
+// RemoteContact is the latest attempt of one kind to talk to an app's repo or
+// registry. For ContactPull only successful pulls are recorded.
+type RemoteContact struct {
+	Kind    string    `json:"kind"`
+	At      time.Time `json:"at"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// ConfigSnapshot is an app's env and build args as of one UpdateApp that
+// changed them. Actor identifies the session or token that made the change.
+type ConfigSnapshot struct {
+	ID        int64             `json:"id"`
+	AppID     string            `json:"appId"`
+	Env       map[string]string `json:"env"`
+	BuildArgs map[string]string `json:"buildArgs"`
+	Actor     string            `json:"actor"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// ConfigChange is one key that differs between consecutive snapshots.
+// Scope is "env" or "buildArgs"; Change is "added", "removed" or "changed".
+type ConfigChange struct {
+	Scope  string `json:"scope"`
+	Key    string `json:"key"`
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// ConfigHistoryEntry is a snapshot (secret values masked) together with the
+// changes from the snapshot before it.
+type ConfigHistoryEntry struct {
+	ConfigSnapshot
+	Changes []ConfigChange `json:"changes"`
+}
+
+// AppSummary is the slim view of an app for dashboards that poll the app
+// list (GET /apps?view=summary). Ports are the host ports the app's
+// containers are published on.
+type AppSummary struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	Icon            string    `json:"icon"`
+	Status          AppStatus `json:"status"`
+	Ports           []int     `json:"ports"`
+	Uptime          string    `json:"uptime,omitempty"`
+	UpdateAvailable bool      `json:"updateAvailable"`
+}
+
+// PortBinding is a host port held by an app's container and the address it
+// is published on.
+type PortBinding struct {
+	Port        int    `json:"port"`
+	BindAddress string `json:"bindAddress"`
+	AppID       string `json:"appId"`
+	AppName     string `json:"appName"`
+	Replica     int    `json:"replica"`
+	// Families is "v4", "v6" or "both", the IP versions the port is
+	// published on; empty for host-mode apps.
+	Families string `json:"families"`
+}
+
+// MetricPoint is an app's average CPU and memory use over one step of a
+// metrics series starting at Time. CPU is a percentage of one core, so a
+// busy app on a 4-core host can reach 400; Memory is in bytes. Replicas
+// are summed.
+type MetricPoint struct {
+	Time   time.Time `json:"t"`
+	CPU    float64   `json:"cpu"`
+	Memory int64     `json:"mem"`
+}
+
+// Reasons a container exited, as recorded in an AppEvent.
+const (
+	ExitReasonOOM    = "oom"    // killed by the kernel OOM killer
+	ExitReasonSignal = "signal" // killed by a signal (exit code 128+n)
+	ExitReasonError  = "error"  // exited with a nonzero code
+	ExitReasonClean  = "exit"   // exited with code 0
+)
+
+// EventReasonStateRepaired marks an AppEvent recording the state watchdog
+// moving the app out of a status it was stuck in. Detail says from what
+// and why.
+const EventReasonStateRepaired = "state-repaired"
+
+// EventReasonManifestVolumes marks an AppEvent recording that a pull
+// changed the volumes the repo's manifest declares. They aren't applied;
+// Detail lists what changed.
+const EventReasonManifestVolumes = "manifest-volumes-changed"
+
+// EventReasonImageRebuilt marks an AppEvent recording a successful build of
+// a running app: Detail says whether its container was recreated on the
+// new image or needs a restart.
+const EventReasonImageRebuilt = "image-rebuilt"
+
+// EventReasonLocalChanges marks an AppEvent recording a pull that found
+// the checkout modified. Detail lists the files and whether they were
+// stashed or discarded.
+const EventReasonLocalChanges = "local-changes"
+
+// EventReasonRolledBack marks an AppEvent recording a rollback. Detail
+// names the image the app went back to and the one it left.
+const EventReasonRolledBack = "rolled-back"
+
+// EventReasonOutdatedBuild marks an AppEvent recording that a pull's
+// rebuild failed and the app was restarted on its previous image. Detail
+// names the failed build and its error.
+const EventReasonOutdatedBuild = "running-outdated-build"
+
+// AppEvent is one of the app's containers exiting without the controller
+// stopping it. GaveUp is set when Docker's restart policy didn't bring it
+// back.
+type AppEvent struct {
+	ID          int64     `json:"id"`
+	AppID       string    `json:"appId"`
+	Replica     int       `json:"replica"`
+	Reason      string    `json:"reason"`
+	ExitCode    int       `json:"exitCode"`
+	Signal      string    `json:"signal,omitempty"`
+	MemoryLimit int64     `json:"memoryLimit"`
+	GaveUp      bool      `json:"gaveUp"`
+	Detail      string    `json:"detail,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Build is one build of an app with the inputs that went into it, so two
+// builds of the same commit that behave differently can be told apart.
+// BaseImages maps each FROM reference to the digest it resolved to ("" if
+// it couldn't be inspected).
+type Build struct {
+	ID            int64             `json:"id"`
+	AppID         string            `json:"appId"`
+	Commit        string            `json:"commit"`
+	Success       bool              `json:"success"`
+	CorrelationID string            `json:"correlationId,omitempty"`
+	BaseImages    map[string]string `json:"baseImages"`
+	BuildArgsHash string            `json:"buildArgsHash"`
+	DockerVersion string            `json:"dockerVersion"`
+	Builder       string            `json:"builder"`
+	StartedAt     time.Time         `json:"startedAt"`
+	FinishedAt    *time.Time        `json:"finishedAt,omitempty"`
+	Duration      string            `json:"duration,omitempty"`
+	// Initiator is the kind of entry point that started the build (see
+	// services.BuildInitiator), and TriggeredBy who or what within it: the
+	// actor for manual, create and recovery builds, e.g.
+	// "session:1a2b3c4d". Both are empty for builds that predate them.
+	Initiator   string `json:"initiator,omitempty"`
+	TriggeredBy string `json:"triggeredBy,omitempty"`
+	// ImageTag is the commit tag a successful build was also given, e.g.
+	// myapp:1a2b3c4d; empty without a commit.
+	ImageTag string `json:"imageTag,omitempty"`
+	// LogPath is this build's own copy of its log, served by
+	// /apps/:id/builds/:buildId/logs.
+	LogPath string `json:"-"`
+	// InputsHash fingerprints what went into the build besides the commit:
+	// Dockerfile and compose file paths, build args, builder options and
+	// build secrets.
+	InputsHash string `json:"inputsHash,omitempty"`
+	// Skipped is set for a build that was requested but not run, because
+	// the commit and inputs were those of the last successful build;
+	// SkipReason names it.
+	Skipped    bool   `json:"skipped,omitempty"`
+	SkipReason string `json:"skipReason,omitempty"`
+	// Result is how the build ended, one of the BuildResult values, so a
+	// skipped build isn't taken for a failed one. It is derived, not
+	// stored.
+	Result string `json:"result"`
+}
+
+// Build results.
+const (
+	BuildResultRunning = "running"
+	BuildResultSuccess = "success"
+	BuildResultFailed  = "failed"
+	BuildResultSkipped = "skipped"
+)
+
+// BuildInputChange is one input that differs between two builds. Field is
+// commit, buildArgs, dockerVersion, builder or baseImage (with Image set).
+type BuildInputChange struct {
+	Field string `json:"field"`
+	Image string `json:"image,omitempty"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+type BuildComparison struct {
+	From    *Build             `json:"from"`
+	To      *Build             `json:"to"`
+	Changes []BuildInputChange `json:"changes"`
+}
+
+// HealthProbe is one run of a container's Docker HEALTHCHECK.
+type HealthProbe struct {
+	At        time.Time `json:"at"`
+	Success   bool      `json:"success"`
+	LatencyMs int64     `json:"latencyMs"`
+	ExitCode  int       `json:"exitCode"`
+	Output    string    `json:"output,omitempty"`
+}
+
+// AppHealth is an app's current health and its recent probes, oldest
+// first. Status is Docker's (starting, healthy, unhealthy), or none when the
+// image defines no HEALTHCHECK. Since is when Status last changed.
+type AppHealth struct {
+	Status        string        `json:"status"`
+	FailingStreak int           `json:"failingStreak"`
+	Since         *time.Time    `json:"since,omitempty"`
+	Probes        []HealthProbe `json:"probes"`
+}
+
+// Container network modes.
+const (
+	NetworkModeBridge = "bridge"
+	NetworkModeHost   = "host"
+)
+
+// App.PublishIPv6 values.
+const (
+	IPv6On  = "on"
+	IPv6Off = "off"
+)
+
+// Share types.
+const (
+	ShareBuildLog     = "buildLog"
+	ShareContainerLog = "containerLog"
+)
+
+// BuildSecret is one of an app's build secrets as the API reports it: the
+// ID a Dockerfile mounts it by, never the value.
+type BuildSecret struct {
+	ID        string    `json:"id"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// AppImage is one of an app's image tags: latest, previous, or one of the
+// commit tags its builds leave behind. Commit, BuildID and TaggedAt come
+// from the controller's record of commit tags; the rest is Docker's, and
+// ImageID is empty for a tag Docker no longer has.
+type AppImage struct {
+	Tag      string     `json:"tag"`
+	Commit   string     `json:"commit,omitempty"`
+	BuildID  int64      `json:"buildId,omitempty"`
+	TaggedAt *time.Time `json:"taggedAt,omitempty"`
+	ImageID  string     `json:"imageId"`
+	Size     int64      `json:"size"`
+	Created  *time.Time `json:"created,omitempty"`
+	// Current is set on the tags of the image the app runs from.
+	Current bool `json:"current"`
+}
+
+// Share is a read-only, expiring link to a frozen log snapshot. ID is a hash
+// of the token; the token itself is only returned when the share is
+// created.
+type Share struct {
+	ID        string    `json:"id"`
+	AppID     string    `json:"appId"`
+	Type      string    `json:"type"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Guest permissions.
+const (
+	GuestStart = "start"
+	GuestStop  = "stop"
+	GuestLogs  = "logs"
+)
+
+// GuestCode lets whoever redeems it act on AppIDs with Permissions until
+// ExpiresAt. ID is a hash of the code; the code itself is only returned
+// when it is created.
+type GuestCode struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	AppIDs      []string  `json:"appIds"`
+	Permissions []string  `json:"permissions"`
+	Actor       string    `json:"actor"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	// Sessions is how many live sessions were created from the code.
+	Sessions int `json:"sessions"`
+}
+
 type AppManifest struct {
 	Name        string            `json:"name"`
 	Description string            `json:"description"`
@@ -64,6 +575,16 @@ type CreateAppRequest struct {
 	Branch  string `json:"branch" binding:"required"`
 }
 
+// ReplicaStatus is the live state of one replica container.
+type ReplicaStatus struct {
+	Index         int    `json:"index"`
+	ContainerName string `json:"containerName"`
+	ContainerID   string `json:"containerId,omitempty"`
+	Port          int    `json:"port"`
+	Status        string `json:"status"`
+	Uptime        string `json:"uptime,omitempty"`
+}
+
 type ConfigureAppRequest struct {
 	Name           string            `json:"name"`
 	DockerfilePath string            `json:"dockerfilePath"`
@@ -73,6 +594,203 @@ type ConfigureAppRequest struct {
 	Env            map[string]string `json:"env"`
 	BuildArgs      map[string]string `json:"buildArgs"`
 	Volumes        []string          `json:"volumes,omitempty"`
+	OfflineBuild   *bool             `json:"offlineBuild,omitempty"`
+	// BuildTarget is a pointer so an empty string can go back to building
+	// the last stage.
+	BuildTarget   *string `json:"buildTarget,omitempty"`
+	Platform      *string `json:"platform,omitempty"`
+	Replicas      int     `json:"replicas,omitempty"`
+	RestartPolicy string  `json:"restartPolicy,omitempty"`
+	MaxRetries    *int    `json:"maxRetries,omitempty"`
+	NetworkMode   string  `json:"networkMode,omitempty"`
+	// Network is a pointer so an empty string can move the app back to the
+	// default bridge.
+	Network         *string  `json:"network,omitempty"`
+	IPAddress       *string  `json:"ipAddress,omitempty"`
+	GPU             *string  `json:"gpu,omitempty"`
+	GPUCapabilities *string  `json:"gpuCapabilities,omitempty"`
+	GPURuntime      *bool    `json:"gpuRuntime,omitempty"`
+	Devices         []string `json:"devices,omitempty"`
+	Privileged      *bool    `json:"privileged,omitempty"`
+	// ConfirmPrivileged must be set to turn privileged mode on.
+	ConfirmPrivileged bool     `json:"confirmPrivileged,omitempty"`
+	CapAdd            []string `json:"capAdd,omitempty"`
+	CapDrop           []string `json:"capDrop,omitempty"`
+	// Healthcheck with an empty command removes the app's healthcheck.
+	Healthcheck *Healthcheck      `json:"healthcheck,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// User defaults to the defaultUser setting when creating an app; an
+	// empty string goes back to the image's USER.
+	User        *string      `json:"user,omitempty"`
+	Entrypoint  ArgsOverride `json:"entrypoint"`
+	Command     ArgsOverride `json:"command"`
+	ExtraHosts  []string     `json:"extraHosts,omitempty"`
+	DNS         []string     `json:"dns,omitempty"`
+	LogMaxSize  *string      `json:"logMaxSize,omitempty"`
+	LogMaxFiles *int         `json:"logMaxFiles,omitempty"`
+	UseProxy    *bool        `json:"useProxy,omitempty"`
+	// ContainerName sets a custom container name; empty goes back to
+	// <prefix><slug>.
+	ContainerName     *string `json:"containerName,omitempty"`
+	Hostname          *string `json:"hostname,omitempty"`
+	MemoryLimit       *string `json:"memoryLimit,omitempty"`
+	MemorySwap        *string `json:"memorySwap,omitempty"`
+	MemoryReservation *string `json:"memoryReservation,omitempty"`
+	ShmSize           *string `json:"shmSize,omitempty"`
+	// Ulimits replaces the app's ulimits; an empty list removes them.
+	Ulimits      []Ulimit `json:"ulimits,omitempty"`
+	BindAddress  *string  `json:"bindAddress,omitempty"`
+	PublishIPv6  *string  `json:"publishIPv6,omitempty"`
+	AutoRecreate *bool    `json:"autoRecreate,omitempty"`
+	// Sysctls replaces the app's sysctls; an empty map removes them.
+	Sysctls              map[string]string `json:"sysctls,omitempty"`
+	NetworkIsolated      *bool             `json:"networkIsolated,omitempty"`
+	PreserveLocalChanges *bool             `json:"preserveLocalChanges,omitempty"`
+	FailStop             *bool             `json:"failStop,omitempty"`
+	LiteralEnv           *bool             `json:"literalEnv,omitempty"`
+	// Compose runs the repo's compose file instead of its Dockerfile. Unset
+	// means only when there is no Dockerfile. Only read at creation.
+	Compose *bool `json:"compose,omitempty"`
+}
+
+// ArgsOverride is an entrypoint or command in a request. JSON can say three
+// things about it: nothing (the field is absent, leave it alone), null (go
+// back to the image's) or an array, possibly empty (use exactly that).
+type ArgsOverride struct {
+	Set   bool
+	Value []string
+}
+
+func (o *ArgsOverride) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+	var value []string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	o.Value = value
+	return nil
+}
+
+func (o ArgsOverride) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.Value)
+}
+
+// AppSpec is the declarative, runtime-free definition of an app used by the
+// spec endpoints. Fields are canonicalized (defaults filled in, volumes
+// sorted, empty collections omitted) so equal specs serialize identically.
+type AppSpec struct {
+	Name            string            `json:"name"`
+	Description     string            `json:"description,omitempty"`
+	RepoURL         string            `json:"repoUrl"`
+	Branch          string            `json:"branch"`
+	DockerfilePath  string            `json:"dockerfilePath"`
+	BuildContext    string            `json:"buildContext"`
+	BuildArgs       map[string]string `json:"buildArgs,omitempty"`
+	OfflineBuild    bool              `json:"offlineBuild,omitempty"`
+	BuildTarget     string            `json:"buildTarget,omitempty"`
+	Platform        string            `json:"platform,omitempty"`
+	InternalPort    int               `json:"internalPort"`
+	ExternalPort    int               `json:"externalPort,omitempty"`
+	RestartPolicy   string            `json:"restartPolicy"`
+	MaxRetries      int               `json:"maxRetries,omitempty"`
+	Replicas        int               `json:"replicas"`
+	NetworkMode     string            `json:"networkMode"`
+	Network         string            `json:"network,omitempty"`
+	IPAddress       string            `json:"ipAddress,omitempty"`
+	GPU             string            `json:"gpu,omitempty"`
+	GPUCapabilities string            `json:"gpuCapabilities,omitempty"`
+	GPURuntime      bool              `json:"gpuRuntime,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	Volumes         []string          `json:"volumes,omitempty"`
+	Devices         []string          `json:"devices,omitempty"`
+	Privileged      bool              `json:"privileged,omitempty"`
+	CapAdd          []string          `json:"capAdd,omitempty"`
+	CapDrop         []string          `json:"capDrop,omitempty"`
+	Healthcheck     *Healthcheck      `json:"healthcheck,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	User            string            `json:"user,omitempty"`
+	// Entrypoint and Command are pointers so an empty override is kept
+	// apart from none.
+	Entrypoint           *[]string         `json:"entrypoint,omitempty"`
+	Command              *[]string         `json:"command,omitempty"`
+	ExtraHosts           []string          `json:"extraHosts,omitempty"`
+	DNS                  []string          `json:"dns,omitempty"`
+	LogMaxSize           string            `json:"logMaxSize,omitempty"`
+	LogMaxFiles          int               `json:"logMaxFiles,omitempty"`
+	UseProxy             bool              `json:"useProxy,omitempty"`
+	ContainerName        string            `json:"containerName,omitempty"`
+	Hostname             string            `json:"hostname,omitempty"`
+	MemoryLimit          string            `json:"memoryLimit,omitempty"`
+	MemorySwap           string            `json:"memorySwap,omitempty"`
+	MemoryReservation    string            `json:"memoryReservation,omitempty"`
+	ShmSize              string            `json:"shmSize,omitempty"`
+	Ulimits              []Ulimit          `json:"ulimits,omitempty"`
+	BindAddress          string            `json:"bindAddress,omitempty"`
+	PublishIPv6          string            `json:"publishIPv6,omitempty"`
+	AutoRecreate         bool              `json:"autoRecreate,omitempty"`
+	Sysctls              map[string]string `json:"sysctls,omitempty"`
+	NetworkIsolated      bool              `json:"networkIsolated,omitempty"`
+	PreserveLocalChanges bool              `json:"preserveLocalChanges,omitempty"`
+	FailStop             bool              `json:"failStop,omitempty"`
+	LiteralEnv           bool              `json:"literalEnv,omitempty"`
+}
+
+// Delete steps, in the order they run.
+const (
+	DeleteStepContainer = "container"
+	DeleteStepReplicas  = "replicas"
+	DeleteStepImage     = "image"
+	DeleteStepSource    = "source"
+	DeleteStepBuildLog  = "buildLog"
+	DeleteStepIcon      = "icon"
+	DeleteStepRecord    = "record"
+)
+
+// DeletePlan is what deleting an app will remove and how much space that
+// frees. Named volumes are listed but kept. Executing the plan fills in each
+// step's result.
+type DeletePlan struct {
+	ID             string         `json:"id"`
+	AppID          string         `json:"appId"`
+	AppName        string         `json:"appName"`
+	ContainerState string         `json:"containerState"`
+	Steps          []*DeleteStep  `json:"steps"`
+	KeptVolumes    []*VolumeUsage `json:"keptVolumes"`
+	TotalBytes     int64          `json:"totalBytes"`
+	ExpiresAt      time.Time      `json:"expiresAt"`
+	Executed       bool           `json:"executed"`
+}
+
+type DeleteStep struct {
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Bytes  int64  `json:"bytes"`
+	Result string `json:"result,omitempty"` // done or failed, once executed
+	Error  string `json:"error,omitempty"`
+}
+
+// VolumeUsage is a named volume an app mounts. Bytes is -1 if Docker hasn't
+// measured it.
+type VolumeUsage struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// SpecChange is one field that differs between an app and an applied spec.
+type SpecChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+type SpecDiff struct {
+	Changes         []SpecChange `json:"changes"`
+	RebuildRequired bool         `json:"rebuildRequired"`
+	RestartRequired bool         `json:"restartRequired"`
 }
 
 type CloneResult struct {
@@ -83,4 +801,16 @@ type CloneResult struct {
 	DockerfilePath string      `json:"dockerfilePath"`
 	Manifest       *AppManifest `json:"manifest"`
 	SuggestedPort  int         `json:"suggestedPort"`
+	// ComposeFile is the compose file found in the repo, and
+	// ComposeServices the services it defines.
+	ComposeFile     string     `json:"composeFile,omitempty"`
+	ComposeServices []string   `json:"composeServices,omitempty"`
+	// BuildTargets are the Dockerfile's named stages, in order, any of
+	// which can be the app's buildTarget.
+	BuildTargets   []string    `json:"buildTargets,omitempty"`
+	// DefaultVolumes are the manifest's volumes as the app would get them
+	// (see services.ManifestVolumes), for the user to adjust before
+	// creating it; VolumeWarnings lists manifest entries left out.
+	DefaultVolumes []string    `json:"defaultVolumes"`
+	VolumeWarnings []string    `json:"volumeWarnings,omitempty"`
 }
@@ -0,0 +1,472 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nas-controller/internal/database"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/docker/dockertest"
+	"nas-controller/internal/models"
+)
+
+// testEnv is an AppManager wired to an in-memory database and a fake
+// Docker, with its apps cloned from local git repos standing in for
+// https://github.com/acme/<name>.
+type testEnv struct {
+	m       *AppManager
+	docker  *dockertest.Fake
+	db      *database.DB
+	remotes string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	fake := dockertest.New()
+	return newTestEnvWith(t, fake, fake)
+}
+
+// newTestEnvWith is newTestEnv talking to runtime, which wraps fake.
+func newTestEnvWith(t *testing.T, fake *dockertest.Fake, runtime docker.Runtime) *testEnv {
+	t.Helper()
+	dataDir := t.TempDir()
+	remotes := t.TempDir()
+
+	gitConfig := filepath.Join(t.TempDir(), "gitconfig")
+	config := fmt.Sprintf(`[user]
+	name = test
+	email = test@example.com
+[init]
+	defaultBranch = main
+[url "file://%s/"]
+	insteadOf = https://github.com/acme/
+`, remotes)
+	if err := os.WriteFile(gitConfig, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", gitConfig)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	db, err := database.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	settings, err := NewSettingsService(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	icons := NewIconService(dataDir)
+	icons.httpClient = &http.Client{Transport: offlineTransport{}}
+
+	m := NewAppManager(
+		db,
+		runtime,
+		NewGitService(dataDir, settings),
+		NewBuildService(db, runtime, settings, dataDir),
+		NewPortAllocator(db, nil, settings),
+		icons,
+		NewPrepullService(db, nil),
+		NewHealthMonitor(db, nil),
+		NewUploadService(dataDir),
+		settings,
+		dataDir,
+	)
+	return &testEnv{m: m, docker: fake, db: db, remotes: remotes}
+}
+
+// offlineTransport fails every request, so no test reaches the network
+// for a forge avatar.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("offline")
+}
+
+func (e *testEnv) git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes files to the remote repo name, creating it if needed, and
+// returns the new commit.
+func (e *testEnv) commit(t *testing.T, name string, files map[string]string) string {
+	t.Helper()
+	dir := filepath.Join(e.remotes, name+".git")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		e.git(t, dir, "init", "-q")
+	}
+	for path, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.git(t, dir, "add", "-A")
+	e.git(t, dir, "commit", "-q", "-m", "change")
+	return e.git(t, dir, "rev-parse", "HEAD")
+}
+
+// createApp creates an app from a new remote repo name.
+func (e *testEnv) createApp(t *testing.T, name string, config *models.ConfigureAppRequest) *models.App {
+	t.Helper()
+	e.commit(t, name, map[string]string{"Dockerfile": "FROM alpine\nCMD [\"sleep\", \"infinity\"]\n"})
+	if config == nil {
+		config = &models.ConfigureAppRequest{}
+	}
+	app, err := e.m.CreateApp(context.Background(), "https://github.com/acme/"+name+".git", "main", config)
+	if err != nil {
+		t.Fatalf("CreateApp: %v", err)
+	}
+	return app
+}
+
+func (e *testEnv) app(t *testing.T, id string) *models.App {
+	t.Helper()
+	app, err := e.db.GetApp(id)
+	if err != nil {
+		t.Fatalf("GetApp: %v", err)
+	}
+	return app
+}
+
+func (e *testEnv) wantStatus(t *testing.T, id string, want models.AppStatus) *models.App {
+	t.Helper()
+	app := e.app(t, id)
+	if app.Status != want || app.SubStatus != "" {
+		t.Fatalf("status = %s (%s), want %s; last error %q", app.Status, app.SubStatus, want, app.LastError)
+	}
+	return app
+}
+
+func TestAppLifecycle(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+
+	app := e.createApp(t, "demo", nil)
+	if app.Slug != "demo" || app.Status != models.StatusStopped {
+		t.Fatalf("created %s as %s", app.Slug, app.Status)
+	}
+
+	if err := e.m.BuildApp(ctx, app.ID, nil); err != nil {
+		t.Fatalf("BuildApp: %v", err)
+	}
+	e.wantStatus(t, app.ID, models.StatusStopped)
+	if !e.docker.HasImage(app.ImageName) {
+		t.Fatalf("image %s not built", app.ImageName)
+	}
+
+	if err := e.m.StartApp(ctx, app.ID); err != nil {
+		t.Fatalf("StartApp: %v", err)
+	}
+	app = e.wantStatus(t, app.ID, models.StatusRunning)
+	c := e.docker.Container(app.ContainerName)
+	if c == nil || !c.Running || c.ID != app.ContainerID {
+		t.Fatalf("container %s not running as the app's: %+v", app.ContainerName, c)
+	}
+	if c.Spec.Labels[docker.AppIDLabel] != app.ID || c.Spec.ExternalPort != app.ExternalPort {
+		t.Errorf("container labels %v, port %d", c.Spec.Labels, c.Spec.ExternalPort)
+	}
+
+	if err := e.m.StopApp(ctx, app.ID); err != nil {
+		t.Fatalf("StopApp: %v", err)
+	}
+	e.wantStatus(t, app.ID, models.StatusStopped)
+	if names := e.docker.Containers(); len(names) != 0 {
+		t.Errorf("containers left after stop: %v", names)
+	}
+
+	plan, err := e.m.DeleteApp(ctx, app.ID)
+	if err != nil {
+		t.Fatalf("DeleteApp: %v", err)
+	}
+	for _, step := range plan.Steps {
+		if step.Result != "done" {
+			t.Errorf("delete step %s: %s %s", step.Action, step.Result, step.Error)
+		}
+	}
+	if _, err := e.db.GetApp(app.ID); err == nil {
+		t.Error("app record still there")
+	}
+	if e.docker.HasImage(app.ImageName) {
+		t.Error("image still there")
+	}
+	if _, err := os.Stat(e.m.repoPath(app)); !os.IsNotExist(err) {
+		t.Errorf("checkout still there: %v", err)
+	}
+}
+
+func TestPullAndRebuildRunningApp(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+
+	app := e.createApp(t, "demo", nil)
+	if err := e.m.DeployApp(ctx, app.ID, nil); err != nil {
+		t.Fatalf("DeployApp: %v", err)
+	}
+	before := e.wantStatus(t, app.ID, models.StatusRunning)
+
+	commit := e.commit(t, "demo", map[string]string{"index.html": "v2"})
+	if err := e.m.PullAndRebuild(ctx, app.ID, nil); err != nil {
+		t.Fatalf("PullAndRebuild: %v", err)
+	}
+	after := e.wantStatus(t, app.ID, models.StatusRunning)
+	if after.LastCommit != commit[:8] {
+		t.Errorf("last commit = %s, want %s", after.LastCommit, commit[:8])
+	}
+	if len(e.docker.Builds) != 2 {
+		t.Errorf("builds = %v, want two", e.docker.Builds)
+	}
+	if after.ContainerID == before.ContainerID || e.docker.Container(after.ContainerName).ID != after.ContainerID {
+		t.Error("the container was not replaced")
+	}
+	if e.m.inFlow(app.ID) {
+		t.Error("flow still registered")
+	}
+
+	// Nothing new: the build is skipped and the app keeps running
+	if err := e.m.PullAndRebuild(ctx, app.ID, nil); !errors.Is(err, ErrBuildUnchanged) {
+		t.Fatalf("PullAndRebuild without changes = %v, want ErrBuildUnchanged", err)
+	}
+	e.wantStatus(t, app.ID, models.StatusRunning)
+
+	// A failed build restarts the app on the image it had
+	e.commit(t, "demo", map[string]string{"index.html": "v3"})
+	e.docker.BuildErr = errors.New("The command '/bin/sh -c make' returned a non-zero code: 2")
+	if err := e.m.PullAndRebuild(ctx, app.ID, nil); err == nil {
+		t.Fatal("PullAndRebuild succeeded with a failing build")
+	}
+	failed := e.wantStatus(t, app.ID, models.StatusRunning)
+	if !failed.RunningOutdated || failed.LastBuildSuccess {
+		t.Errorf("runningOutdated = %v, lastBuildSuccess = %v", failed.RunningOutdated, failed.LastBuildSuccess)
+	}
+}
+
+func TestReconcileAfterDaemonRestart(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+
+	kept := e.createApp(t, "kept", &models.ConfigureAppRequest{RestartPolicy: "always"})
+	lost := e.createApp(t, "lost", &models.ConfigureAppRequest{RestartPolicy: "no"})
+	gone := e.createApp(t, "gone", nil)
+	for _, app := range []*models.App{kept, lost, gone} {
+		if err := e.m.DeployApp(ctx, app.ID, nil); err != nil {
+			t.Fatalf("DeployApp %s: %v", app.Slug, err)
+		}
+	}
+
+	e.docker.RestartDaemon()
+	// A container removed behind the controller's back
+	goneID := e.app(t, gone.ID).ContainerID
+	if err := e.docker.RemoveContainer(ctx, goneID, true); err != nil {
+		t.Fatal(err)
+	}
+	// And a flow the previous process didn't finish
+	stuck := e.app(t, kept.ID)
+	stuck.Status, stuck.SubStatus = models.StatusUpdating, models.StatusBuilding
+	e.db.UpdateApp(stuck)
+
+	if err := e.m.ReconcileStates(); err != nil {
+		t.Fatalf("ReconcileStates: %v", err)
+	}
+	e.wantStatus(t, kept.ID, models.StatusRunning)
+	e.wantStatus(t, lost.ID, models.StatusStopped)
+	if app := e.wantStatus(t, gone.ID, models.StatusStopped); app.ContainerID != "" {
+		t.Errorf("container ID %s kept for a removed container", app.ContainerID)
+	}
+
+	// The stopped ones start again on their own ports
+	if err := e.m.StartApp(ctx, lost.ID); err != nil {
+		t.Fatalf("StartApp: %v", err)
+	}
+	if app := e.wantStatus(t, lost.ID, models.StatusRunning); app.ExternalPort != lost.ExternalPort {
+		t.Errorf("port moved from %d to %d", lost.ExternalPort, app.ExternalPort)
+	}
+}
+
+func TestStartWithPortConflict(t *testing.T) {
+	t.Run("held outside docker", func(t *testing.T) {
+		e := newTestEnv(t)
+		ctx := context.Background()
+		app := e.createApp(t, "demo", nil)
+		if err := e.m.BuildApp(ctx, app.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		listener, err := net.Listen("tcp4", fmt.Sprintf("127.0.0.1:%d", app.ExternalPort))
+		if err != nil {
+			t.Skipf("can't hold port %d: %v", app.ExternalPort, err)
+		}
+		defer listener.Close()
+
+		if err := e.m.StartApp(ctx, app.ID); err != nil {
+			t.Fatalf("StartApp: %v", err)
+		}
+		moved := e.wantStatus(t, app.ID, models.StatusRunning)
+		if moved.ExternalPort == app.ExternalPort {
+			t.Fatalf("app stayed on held port %d", app.ExternalPort)
+		}
+		if c := e.docker.Container(moved.ContainerName); c.Spec.ExternalPort != moved.ExternalPort {
+			t.Errorf("container published %d, app has %d", c.Spec.ExternalPort, moved.ExternalPort)
+		}
+		if sticky, err := e.db.GetStickyPort(app.Slug); err != nil || sticky != moved.ExternalPort {
+			t.Errorf("sticky port = %d (%v), want %d", sticky, err, moved.ExternalPort)
+		}
+	})
+
+	t.Run("stale container on the port", func(t *testing.T) {
+		e := newTestEnv(t)
+		ctx := context.Background()
+		app := e.createApp(t, "demo", nil)
+		if err := e.m.BuildApp(ctx, app.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		// Left behind by a crash under a name the app no longer uses
+		e.docker.AddContainer(docker.ContainerSpec{
+			Name:         "demo-old",
+			Image:        app.ImageName,
+			InternalPort: 80,
+			ExternalPort: app.ExternalPort,
+		})
+
+		if err := e.m.StartApp(ctx, app.ID); err != nil {
+			t.Fatalf("StartApp: %v", err)
+		}
+		started := e.wantStatus(t, app.ID, models.StatusRunning)
+		if started.ExternalPort != app.ExternalPort {
+			t.Errorf("port moved from %d to %d", app.ExternalPort, started.ExternalPort)
+		}
+		if names := e.docker.Containers(); len(names) != 1 || names[0] != started.ContainerName {
+			t.Errorf("containers = %v, want only %s", names, started.ContainerName)
+		}
+	})
+}
+
+// gatedBuilder holds the first build until release is closed, and records
+// whether the Dockerfile was still there when it went on.
+type gatedBuilder struct {
+	*dockertest.Fake
+	started    chan struct{}
+	release    chan struct{}
+	contextErr error
+}
+
+func (b *gatedBuilder) BuildImage(ctx context.Context, contextPath, dockerfilePath, imageName string, buildArgs map[string]string, networkMode string, options docker.BuildOptions, logWriter io.Writer) error {
+	select {
+	case b.started <- struct{}{}:
+	default:
+	}
+	<-b.release
+	if _, err := os.Stat(filepath.Join(contextPath, dockerfilePath)); err != nil {
+		b.contextErr = err
+	}
+	return b.Fake.BuildImage(ctx, contextPath, dockerfilePath, imageName, buildArgs, networkMode, options, logWriter)
+}
+
+// gateClones holds clones from the test remotes part-way, after the
+// checkout was removed and before the new one is written, until the
+// returned release is called. It waits for a clone to get there first.
+func gateClones(t *testing.T) (wait func(), release func()) {
+	t.Helper()
+	dir := t.TempDir()
+	hook := filepath.Join(dir, "pack-objects")
+	script := fmt.Sprintf("#!/bin/sh\ntouch %[1]s/started\nwhile [ ! -e %[1]s/release ]; do sleep 0.01; done\nexec \"$@\"\n", dir)
+	if err := os.WriteFile(hook, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config, err := os.OpenFile(os.Getenv("GIT_CONFIG_GLOBAL"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(config, "[uploadpack]\n\tpackObjectsHook = %s\n", hook)
+	config.Close()
+
+	wait = func() {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if _, err := os.Stat(filepath.Join(dir, "started")); err == nil {
+				return
+			}
+		}
+		t.Fatal("clone never reached the gate")
+	}
+	release = func() {
+		os.WriteFile(filepath.Join(dir, "release"), nil, 0644)
+	}
+	t.Cleanup(release)
+	return wait, release
+}
+
+func TestCloneOverExistingCheckout(t *testing.T) {
+	builder := &gatedBuilder{Fake: dockertest.New(), started: make(chan struct{}, 1), release: make(chan struct{})}
+	e := newTestEnvWith(t, builder.Fake, builder)
+	ctx := context.Background()
+	app := e.createApp(t, "demo", nil)
+	repoURL := "https://github.com/acme/demo.git"
+	checkout := e.m.repoPath(app)
+
+	// Refused unless asked for, leaving the checkout alone
+	var inUse *CheckoutInUseError
+	if _, err := e.m.CloneAndValidate(ctx, repoURL, "main", false); !errors.As(err, &inUse) || inUse.AppID != app.ID || inUse.Building {
+		t.Fatalf("clone over the checkout: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(checkout, "Dockerfile")); err != nil {
+		t.Fatalf("checkout touched: %v", err)
+	}
+
+	// A re-clone doesn't pull the checkout from under a build
+	built := make(chan error, 1)
+	go func() { built <- e.m.BuildApp(ctx, app.ID, nil) }()
+	<-builder.started
+	if _, err := e.m.CloneAndValidate(ctx, repoURL, "main", true); !errors.As(err, &inUse) || !inUse.Building {
+		t.Errorf("re-clone during a build: %v", err)
+	}
+	close(builder.release)
+	if err := <-built; err != nil {
+		t.Fatalf("BuildApp: %v", err)
+	}
+	if builder.contextErr != nil {
+		t.Errorf("build lost its checkout: %v", builder.contextErr)
+	}
+
+	// Nor does a build start from a checkout being re-cloned
+	head := e.commit(t, "demo", map[string]string{"README.md": "v2\n"})
+	wait, release := gateClones(t)
+	cloned := make(chan error, 1)
+	go func() {
+		_, err := e.m.CloneAndValidate(ctx, repoURL, "main", true)
+		cloned <- err
+	}()
+	wait()
+	builds := len(builder.Builds)
+	if err := e.m.BuildApp(WithForceBuild(ctx), app.ID, nil); err == nil || !strings.Contains(err.Error(), "in progress") {
+		t.Errorf("build during a re-clone: %v", err)
+	}
+	release()
+	if err := <-cloned; err != nil {
+		t.Fatalf("re-clone: %v", err)
+	}
+	if len(builder.Builds) != builds {
+		t.Error("built from a half-written checkout")
+	}
+	if got := e.git(t, checkout, "rev-parse", "HEAD"); got != head {
+		t.Errorf("checkout at %s, want %s", got, head)
+	}
+}
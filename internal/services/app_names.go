@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// MaxSlugLength bounds an app's slug. The slug names its repo directory,
+// image and container, so it is kept well inside Docker's name limits even
+// with the container name prefix.
+const MaxSlugLength = 48
+
+// slugPattern is what a slug may look like: lowercase letters and digits,
+// with single dashes between them.
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+var slugSeparatorPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// reservedSlugs can't be app slugs: they are the controller's own names, or
+// directories a repo would land on top of.
+var reservedSlugs = map[string]bool{
+	"nas-controller": true, // the controller's image and container
+	"static":         true, // the embedded web UI
+	"uploads":        true, // repos/uploads holds uploaded build contexts
+}
+
+// InvalidNameError rejects an app slug. Suggestion is a normalized slug
+// that would be accepted, when one can be derived.
+type InvalidNameError struct {
+	Name       string
+	Reason     string
+	Suggestion string
+}
+
+func (e *InvalidNameError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("app name %q %s; try %q", e.Name, e.Reason, e.Suggestion)
+	}
+	return fmt.Sprintf("app name %q %s", e.Name, e.Reason)
+}
+
+// NormalizeSlug lowercases name and turns every run of other characters
+// into a single dash. It doesn't shorten the result; ValidateSlug does
+// that check.
+func NormalizeSlug(name string) string {
+	return strings.Trim(slugSeparatorPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// ValidateSlug checks that slug is safe to use as a directory and Docker
+// name: not empty, at most MaxSlugLength, slugPattern's characters only and
+// not reserved.
+func ValidateSlug(slug string) error {
+	var reason string
+	switch {
+	case slug == "":
+		reason = "is empty"
+	case len(slug) > MaxSlugLength:
+		reason = fmt.Sprintf("is longer than %d characters", MaxSlugLength)
+	case !slugPattern.MatchString(slug):
+		reason = "may only contain lowercase letters, digits and single dashes"
+	case reservedSlugs[slug]:
+		reason = "is reserved"
+	}
+	if reason == "" {
+		return nil
+	}
+	return &InvalidNameError{Name: slug, Reason: reason, Suggestion: suggestSlug(slug, nil)}
+}
+
+// suggestSlug derives an acceptable slug from name, adding a numeric
+// suffix while taken reports it in use. taken may be nil.
+func suggestSlug(name string, taken func(string) bool) string {
+	base := NormalizeSlug(name)
+	if len(base) > MaxSlugLength {
+		base = strings.TrimRight(base[:MaxSlugLength], "-")
+	}
+	if base == "" {
+		base = "app"
+	}
+	if reservedSlugs[base] {
+		base += "-app"
+	}
+
+	candidate := base
+	for n := 2; taken != nil && taken(candidate); n++ {
+		suffix := fmt.Sprintf("-%d", n)
+		if len(base)+len(suffix) > MaxSlugLength {
+			base = strings.TrimRight(base[:MaxSlugLength-len(suffix)], "-")
+		}
+		candidate = base + suffix
+	}
+	return candidate
+}
+
+// checkSlug validates slug for a new app and refuses one another app
+// already has. The suggestion in either case is free.
+func (m *AppManager) checkSlug(slug string) error {
+	if err := ValidateSlug(slug); err != nil {
+		err.(*InvalidNameError).Suggestion = suggestSlug(slug, m.slugTaken)
+		return err
+	}
+	if existing, err := m.db.GetAppBySlug(slug); err == nil {
+		return &InvalidNameError{
+			Name:       slug,
+			Reason:     fmt.Sprintf("is already used by app %s", existing.ID),
+			Suggestion: suggestSlug(slug, m.slugTaken),
+		}
+	}
+	return nil
+}
+
+// checkNewSlug is checkSlug before anything is written for the app. It
+// also refuses a slug whose repo or upload directory is still on disk,
+// such as one left by a delete that skipped the source step, which a clone
+// or upload would otherwise replace.
+func (m *AppManager) checkNewSlug(slug string) error {
+	if err := m.checkSlug(slug); err != nil {
+		return err
+	}
+	if dir := m.leftoverDir(slug); dir != "" {
+		return &InvalidNameError{
+			Name:       slug,
+			Reason:     fmt.Sprintf("still has files on disk in %s", dir),
+			Suggestion: suggestSlug(slug, m.slugTaken),
+		}
+	}
+	return nil
+}
+
+// slugTaken reports whether an app or a leftover directory has slug.
+func (m *AppManager) slugTaken(slug string) bool {
+	if _, err := m.db.GetAppBySlug(slug); err == nil {
+		return true
+	}
+	return m.leftoverDir(slug) != ""
+}
+
+// leftoverDir returns slug's repo or upload directory if one exists.
+func (m *AppManager) leftoverDir(slug string) string {
+	for _, dir := range []string{m.gitService.GetRepoPath(slug), m.uploads.Path(slug)} {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
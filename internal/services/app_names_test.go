@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateSlug(t *testing.T) {
+	tests := []struct {
+		slug       string
+		valid      bool
+		suggestion string
+	}{
+		{slug: "demo", valid: true},
+		{slug: "my-app-2", valid: true},
+		{slug: "", suggestion: "app"},
+		{slug: "My_App", suggestion: "my-app"},
+		{slug: "a--b", suggestion: "a-b"},
+		{slug: "-demo", suggestion: "demo"},
+		{slug: "..", suggestion: "app"},
+		{slug: "../etc", suggestion: "etc"},
+		{slug: "uploads", suggestion: "uploads-app"},
+		{slug: strings.Repeat("a", MaxSlugLength), valid: true},
+		{slug: strings.Repeat("a", MaxSlugLength+1), suggestion: strings.Repeat("a", MaxSlugLength)},
+	}
+	for _, tt := range tests {
+		err := ValidateSlug(tt.slug)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateSlug(%q) = %v, want valid %v", tt.slug, err, tt.valid)
+			continue
+		}
+		if err != nil && err.(*InvalidNameError).Suggestion != tt.suggestion {
+			t.Errorf("ValidateSlug(%q) suggests %q, want %q", tt.slug, err.(*InvalidNameError).Suggestion, tt.suggestion)
+		}
+	}
+}
+
+func TestSuggestSlugSkipsTaken(t *testing.T) {
+	long := strings.Repeat("a", MaxSlugLength)
+	taken := map[string]bool{"demo": true, "demo-2": true, long: true}
+	isTaken := func(s string) bool { return taken[s] }
+
+	if got := suggestSlug("demo", isTaken); got != "demo-3" {
+		t.Errorf("suggestSlug(demo) = %q, want demo-3", got)
+	}
+	// The suffix fits inside the length limit
+	if got := suggestSlug(long, isTaken); got != long[:MaxSlugLength-2]+"-2" {
+		t.Errorf("suggestSlug(long) = %q", got)
+	}
+}
+
+func TestCheckNewSlugRefusesLeftovers(t *testing.T) {
+	_, db := newTestSettings(t)
+	reposDir := filepath.Join(t.TempDir(), "repos")
+	m := &AppManager{
+		db:         db,
+		gitService: &GitService{reposDir: reposDir},
+		uploads:    &UploadService{uploadsDir: filepath.Join(reposDir, "uploads")},
+	}
+	for _, dir := range []string{m.gitService.GetRepoPath("demo"), m.uploads.Path("demo-2")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var nameErr *InvalidNameError
+	if err := m.checkNewSlug("demo"); !errors.As(err, &nameErr) {
+		t.Fatalf("checkNewSlug(demo) = %v, want an InvalidNameError", err)
+	}
+	// Both leftovers are passed over for the suggestion
+	if nameErr.Suggestion != "demo-3" {
+		t.Errorf("suggestion = %q, want demo-3", nameErr.Suggestion)
+	}
+	// Once the source is on disk for the app being created, only other
+	// apps count
+	if err := m.checkSlug("demo"); err != nil {
+		t.Errorf("checkSlug(demo) = %v", err)
+	}
+	if err := m.checkNewSlug("other"); err != nil {
+		t.Errorf("checkNewSlug(other) = %v", err)
+	}
+}
+
+// FuzzSlugStaysInDirectory checks that whatever an app is named after (a
+// repo URL, a local directory, an upload), the slug it gets either is
+// rejected or names a directory directly inside repos/ and repos/uploads/.
+func FuzzSlugStaysInDirectory(f *testing.F) {
+	for _, seed := range []string{
+		"https://github.com/acme/demo",
+		"git@github.com:acme/demo.git",
+		"https://github.com/acme/..",
+		"https://github.com/acme/%2e%2e",
+		"https://github.com/acme/../../etc",
+		"https://github.com/acme/uploads",
+		"/mnt/user/3_secret/../../etc",
+		"/mnt/user/3_secret/app\x00name",
+		"C:\\Windows\\System32",
+		strings.Repeat("x", 300),
+	} {
+		f.Add(seed)
+	}
+
+	reposDir := filepath.Join(f.TempDir(), "repos")
+	git := &GitService{reposDir: reposDir}
+	uploads := &UploadService{uploadsDir: filepath.Join(reposDir, "uploads")}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		for _, slug := range []string{git.extractSlug(name), NormalizeSlug(filepath.Base(name)), name} {
+			if err := ValidateSlug(slug); err != nil {
+				// The suggestion has to pass where the name didn't
+				suggestion := err.(*InvalidNameError).Suggestion
+				if ValidateSlug(suggestion) != nil {
+					t.Fatalf("suggestion %q for %q is invalid too", suggestion, slug)
+				}
+				slug = suggestion
+			}
+			for _, path := range []string{git.GetRepoPath(slug), uploads.Path(slug)} {
+				dir := filepath.Dir(path)
+				if dir != reposDir && dir != uploads.uploadsDir {
+					t.Fatalf("slug %q puts its directory at %s", slug, path)
+				}
+				if path == uploads.uploadsDir {
+					t.Fatalf("slug %q is the uploads directory", slug)
+				}
+			}
+		}
+	})
+}
+
+// FuzzIsLocalPath checks that a path accepted as a local source can't
+// leave the permitted directory.
+func FuzzIsLocalPath(f *testing.F) {
+	for _, seed := range []string{
+		allowedLocalPathPrefix + "demo",
+		allowedLocalPathPrefix + "../../etc",
+		allowedLocalPathPrefix + "demo/../../other",
+		allowedLocalPathPrefix + "./..",
+		allowedLocalPathPrefix + "..",
+		allowedLocalPathPrefix,
+		"/mnt/user/3_secret",
+		"/mnt/user/3_secretive/demo",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, path string) {
+		if !IsLocalPath(path) {
+			return
+		}
+		clean := filepath.Clean(path)
+		if clean+"/" != allowedLocalPathPrefix && !strings.HasPrefix(clean, allowedLocalPathPrefix) {
+			t.Fatalf("%q accepted, but is %s", path, clean)
+		}
+	})
+}
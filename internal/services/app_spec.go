@@ -0,0 +1,525 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"nas-controller/internal/models"
+)
+
+// specField describes one spec field: how to read it from a spec, how to
+// write it to an app, and whether changing it needs a rebuild (otherwise a
+// restart of a running container is enough).
+type specField struct {
+	name    string
+	get     func(*models.AppSpec) interface{}
+	set     func(*models.App, *models.AppSpec)
+	rebuild bool
+}
+
+var specFields = []specField{
+	{"name", func(s *models.AppSpec) interface{} { return s.Name },
+		func(a *models.App, s *models.AppSpec) { a.Name = s.Name }, false},
+	{"description", func(s *models.AppSpec) interface{} { return s.Description },
+		func(a *models.App, s *models.AppSpec) { a.Description = s.Description }, false},
+	{"branch", func(s *models.AppSpec) interface{} { return s.Branch },
+		func(a *models.App, s *models.AppSpec) { a.Branch = s.Branch }, true},
+	{"dockerfilePath", func(s *models.AppSpec) interface{} { return s.DockerfilePath },
+		func(a *models.App, s *models.AppSpec) { a.DockerfilePath = s.DockerfilePath }, true},
+	{"buildContext", func(s *models.AppSpec) interface{} { return s.BuildContext },
+		func(a *models.App, s *models.AppSpec) { a.BuildContext = s.BuildContext }, true},
+	{"buildArgs", func(s *models.AppSpec) interface{} { return s.BuildArgs },
+		func(a *models.App, s *models.AppSpec) { a.BuildArgs = copyStringMap(s.BuildArgs) }, true},
+	{"offlineBuild", func(s *models.AppSpec) interface{} { return s.OfflineBuild },
+		func(a *models.App, s *models.AppSpec) { a.OfflineBuild = s.OfflineBuild }, true},
+	{"buildTarget", func(s *models.AppSpec) interface{} { return s.BuildTarget },
+		func(a *models.App, s *models.AppSpec) { a.BuildTarget = s.BuildTarget }, true},
+	{"platform", func(s *models.AppSpec) interface{} { return s.Platform },
+		func(a *models.App, s *models.AppSpec) { a.Platform = s.Platform }, true},
+	{"internalPort", func(s *models.AppSpec) interface{} { return s.InternalPort },
+		func(a *models.App, s *models.AppSpec) { a.InternalPort = s.InternalPort }, false},
+	{"externalPort", func(s *models.AppSpec) interface{} { return s.ExternalPort },
+		func(a *models.App, s *models.AppSpec) { a.ExternalPort = s.ExternalPort }, false},
+	{"restartPolicy", func(s *models.AppSpec) interface{} { return s.RestartPolicy },
+		func(a *models.App, s *models.AppSpec) { a.RestartPolicy = s.RestartPolicy }, false},
+	{"maxRetries", func(s *models.AppSpec) interface{} { return s.MaxRetries },
+		func(a *models.App, s *models.AppSpec) {
+			if s.RestartPolicy == "on-failure" {
+				a.MaxRetries = s.MaxRetries
+			}
+		}, false},
+	{"replicas", func(s *models.AppSpec) interface{} { return s.Replicas },
+		func(a *models.App, s *models.AppSpec) { a.Replicas = s.Replicas }, false},
+	{"networkMode", func(s *models.AppSpec) interface{} { return s.NetworkMode },
+		func(a *models.App, s *models.AppSpec) { a.NetworkMode = s.NetworkMode }, false},
+	{"network", func(s *models.AppSpec) interface{} { return s.Network },
+		func(a *models.App, s *models.AppSpec) { a.Network = s.Network }, false},
+	{"ipAddress", func(s *models.AppSpec) interface{} { return s.IPAddress },
+		func(a *models.App, s *models.AppSpec) { a.IPAddress = s.IPAddress }, false},
+	{"gpu", func(s *models.AppSpec) interface{} { return s.GPU },
+		func(a *models.App, s *models.AppSpec) { a.GPU = s.GPU }, false},
+	{"gpuCapabilities", func(s *models.AppSpec) interface{} { return s.GPUCapabilities },
+		func(a *models.App, s *models.AppSpec) { a.GPUCapabilities = s.GPUCapabilities }, false},
+	{"gpuRuntime", func(s *models.AppSpec) interface{} { return s.GPURuntime },
+		func(a *models.App, s *models.AppSpec) { a.GPURuntime = s.GPURuntime }, false},
+	{"env", func(s *models.AppSpec) interface{} { return s.Env },
+		func(a *models.App, s *models.AppSpec) { a.Env = copyStringMap(s.Env) }, false},
+	{"volumes", func(s *models.AppSpec) interface{} { return s.Volumes },
+		func(a *models.App, s *models.AppSpec) { a.Volumes = append([]string{}, s.Volumes...) }, false},
+	{"devices", func(s *models.AppSpec) interface{} { return s.Devices },
+		func(a *models.App, s *models.AppSpec) { a.Devices = append([]string{}, s.Devices...) }, false},
+	{"privileged", func(s *models.AppSpec) interface{} { return s.Privileged },
+		func(a *models.App, s *models.AppSpec) { a.Privileged = s.Privileged }, false},
+	{"capAdd", func(s *models.AppSpec) interface{} { return s.CapAdd },
+		func(a *models.App, s *models.AppSpec) { a.CapAdd = append([]string(nil), s.CapAdd...) }, false},
+	{"capDrop", func(s *models.AppSpec) interface{} { return s.CapDrop },
+		func(a *models.App, s *models.AppSpec) { a.CapDrop = append([]string(nil), s.CapDrop...) }, false},
+	{"healthcheck", func(s *models.AppSpec) interface{} { return s.Healthcheck },
+		func(a *models.App, s *models.AppSpec) { a.Healthcheck = copyHealthcheck(s.Healthcheck) }, false},
+	{"labels", func(s *models.AppSpec) interface{} { return s.Labels },
+		func(a *models.App, s *models.AppSpec) { a.Labels = copyStringMap(s.Labels) }, false},
+	{"user", func(s *models.AppSpec) interface{} { return s.User },
+		func(a *models.App, s *models.AppSpec) { a.User = s.User }, false},
+	{"entrypoint", func(s *models.AppSpec) interface{} { return s.Entrypoint },
+		func(a *models.App, s *models.AppSpec) { a.Entrypoint = argsFromSpec(s.Entrypoint) }, false},
+	{"command", func(s *models.AppSpec) interface{} { return s.Command },
+		func(a *models.App, s *models.AppSpec) { a.Command = argsFromSpec(s.Command) }, false},
+	{"extraHosts", func(s *models.AppSpec) interface{} { return s.ExtraHosts },
+		func(a *models.App, s *models.AppSpec) { a.ExtraHosts = append([]string{}, s.ExtraHosts...) }, false},
+	{"dns", func(s *models.AppSpec) interface{} { return s.DNS },
+		func(a *models.App, s *models.AppSpec) { a.DNS = append([]string{}, s.DNS...) }, false},
+	{"logMaxSize", func(s *models.AppSpec) interface{} { return s.LogMaxSize },
+		func(a *models.App, s *models.AppSpec) { a.LogMaxSize = s.LogMaxSize }, false},
+	{"logMaxFiles", func(s *models.AppSpec) interface{} { return s.LogMaxFiles },
+		func(a *models.App, s *models.AppSpec) { a.LogMaxFiles = s.LogMaxFiles }, false},
+	{"useProxy", func(s *models.AppSpec) interface{} { return s.UseProxy },
+		func(a *models.App, s *models.AppSpec) { a.UseProxy = s.UseProxy }, false},
+	{"containerName", func(s *models.AppSpec) interface{} { return s.ContainerName },
+		func(a *models.App, s *models.AppSpec) { a.CustomContainerName = s.ContainerName }, false},
+	{"hostname", func(s *models.AppSpec) interface{} { return s.Hostname },
+		func(a *models.App, s *models.AppSpec) { a.Hostname = s.Hostname }, false},
+	{"memoryLimit", func(s *models.AppSpec) interface{} { return s.MemoryLimit },
+		func(a *models.App, s *models.AppSpec) { a.MemoryLimit = s.MemoryLimit }, false},
+	{"memorySwap", func(s *models.AppSpec) interface{} { return s.MemorySwap },
+		func(a *models.App, s *models.AppSpec) { a.MemorySwap = s.MemorySwap }, false},
+	{"memoryReservation", func(s *models.AppSpec) interface{} { return s.MemoryReservation },
+		func(a *models.App, s *models.AppSpec) { a.MemoryReservation = s.MemoryReservation }, false},
+	{"shmSize", func(s *models.AppSpec) interface{} { return s.ShmSize },
+		func(a *models.App, s *models.AppSpec) { a.ShmSize = s.ShmSize }, false},
+	{"ulimits", func(s *models.AppSpec) interface{} { return s.Ulimits },
+		func(a *models.App, s *models.AppSpec) { a.Ulimits = append([]models.Ulimit{}, s.Ulimits...) }, false},
+	{"bindAddress", func(s *models.AppSpec) interface{} { return s.BindAddress },
+		func(a *models.App, s *models.AppSpec) { a.BindAddress = s.BindAddress }, false},
+	{"publishIPv6", func(s *models.AppSpec) interface{} { return s.PublishIPv6 },
+		func(a *models.App, s *models.AppSpec) { a.PublishIPv6 = s.PublishIPv6 }, false},
+	{"sysctls", func(s *models.AppSpec) interface{} { return s.Sysctls },
+		func(a *models.App, s *models.AppSpec) { a.Sysctls = copyStringMap(s.Sysctls) }, false},
+	{"networkIsolated", func(s *models.AppSpec) interface{} { return s.NetworkIsolated },
+		func(a *models.App, s *models.AppSpec) { a.NetworkIsolated = s.NetworkIsolated }, false},
+	{"autoRecreate", func(s *models.AppSpec) interface{} { return s.AutoRecreate },
+		func(a *models.App, s *models.AppSpec) { a.AutoRecreate = s.AutoRecreate }, false},
+	{"preserveLocalChanges", func(s *models.AppSpec) interface{} { return s.PreserveLocalChanges },
+		func(a *models.App, s *models.AppSpec) { a.PreserveLocalChanges = s.PreserveLocalChanges }, false},
+	{"failStop", func(s *models.AppSpec) interface{} { return s.FailStop },
+		func(a *models.App, s *models.AppSpec) { a.FailStop = s.FailStop }, false},
+	{"literalEnv", func(s *models.AppSpec) interface{} { return s.LiteralEnv },
+		func(a *models.App, s *models.AppSpec) { a.LiteralEnv = s.LiteralEnv }, false},
+}
+
+// SpecFromApp returns the canonical spec for app.
+func SpecFromApp(app *models.App) *models.AppSpec {
+	spec := &models.AppSpec{
+		Name:                 app.Name,
+		Description:          app.Description,
+		RepoURL:              app.RepoURL,
+		Branch:               app.Branch,
+		DockerfilePath:       app.DockerfilePath,
+		BuildContext:         app.BuildContext,
+		BuildArgs:            copyStringMap(app.BuildArgs),
+		OfflineBuild:         app.OfflineBuild,
+		BuildTarget:          app.BuildTarget,
+		Platform:             app.Platform,
+		InternalPort:         app.InternalPort,
+		ExternalPort:         app.ExternalPort,
+		RestartPolicy:        app.RestartPolicy,
+		MaxRetries:           app.MaxRetries,
+		Replicas:             app.Replicas,
+		NetworkMode:          app.NetworkMode,
+		Network:              app.Network,
+		IPAddress:            app.IPAddress,
+		GPU:                  app.GPU,
+		GPUCapabilities:      app.GPUCapabilities,
+		GPURuntime:           app.GPURuntime,
+		Env:                  copyStringMap(app.Env),
+		Volumes:              append([]string{}, app.Volumes...),
+		Devices:              append([]string{}, app.Devices...),
+		Privileged:           app.Privileged,
+		CapAdd:               append([]string{}, app.CapAdd...),
+		CapDrop:              append([]string{}, app.CapDrop...),
+		Healthcheck:          copyHealthcheck(app.Healthcheck),
+		Labels:               copyStringMap(app.Labels),
+		User:                 app.User,
+		Entrypoint:           argsToSpec(app.Entrypoint),
+		Command:              argsToSpec(app.Command),
+		ExtraHosts:           append([]string{}, app.ExtraHosts...),
+		DNS:                  append([]string{}, app.DNS...),
+		LogMaxSize:           app.LogMaxSize,
+		LogMaxFiles:          app.LogMaxFiles,
+		UseProxy:             app.UseProxy,
+		ContainerName:        app.CustomContainerName,
+		Hostname:             app.Hostname,
+		MemoryLimit:          app.MemoryLimit,
+		MemorySwap:           app.MemorySwap,
+		MemoryReservation:    app.MemoryReservation,
+		ShmSize:              app.ShmSize,
+		Ulimits:              append([]models.Ulimit{}, app.Ulimits...),
+		BindAddress:          app.BindAddress,
+		PublishIPv6:          app.PublishIPv6,
+		AutoRecreate:         app.AutoRecreate,
+		NetworkIsolated:      app.NetworkIsolated,
+		PreserveLocalChanges: app.PreserveLocalChanges,
+		FailStop:             app.FailStop,
+		LiteralEnv:           app.LiteralEnv,
+		Sysctls:              copyStringMap(app.Sysctls),
+	}
+	CanonicalizeSpec(spec)
+	return spec
+}
+
+// CanonicalizeSpec fills defaults and normalizes ordering so two specs that
+// describe the same app compare (and serialize) equal.
+func CanonicalizeSpec(spec *models.AppSpec) {
+	spec.Name = strings.TrimSpace(spec.Name)
+	spec.Description = strings.TrimSpace(spec.Description)
+	spec.RepoURL = strings.TrimSpace(spec.RepoURL)
+	spec.Branch = strings.TrimSpace(spec.Branch)
+	spec.Network = strings.TrimSpace(spec.Network)
+	spec.IPAddress = strings.TrimSpace(spec.IPAddress)
+	if spec.DockerfilePath == "" {
+		spec.DockerfilePath = "./Dockerfile"
+	}
+	if spec.BuildContext == "" {
+		spec.BuildContext = "."
+	}
+	if spec.InternalPort == 0 {
+		spec.InternalPort = 80
+	}
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = DefaultRestartPolicy
+	}
+	// The retry count only means something for on-failure.
+	if spec.RestartPolicy != "on-failure" {
+		spec.MaxRetries = 0
+	} else if spec.MaxRetries == 0 {
+		spec.MaxRetries = DefaultMaxRetries
+	}
+	if spec.Replicas == 0 {
+		spec.Replicas = 1
+	}
+	if spec.NetworkMode == "" {
+		spec.NetworkMode = models.NetworkModeBridge
+	}
+	if len(spec.BuildArgs) == 0 {
+		spec.BuildArgs = nil
+	}
+	if len(spec.Env) == 0 {
+		spec.Env = nil
+	}
+	if len(spec.Labels) == 0 {
+		spec.Labels = nil
+	}
+	if len(spec.Sysctls) == 0 {
+		spec.Sysctls = nil
+	}
+	// Resolver order matters, so unlike volumes these stay as given.
+	if len(spec.ExtraHosts) == 0 {
+		spec.ExtraHosts = nil
+	}
+	if len(spec.DNS) == 0 {
+		spec.DNS = nil
+	}
+	if len(spec.Ulimits) == 0 {
+		spec.Ulimits = nil
+	} else {
+		sort.Slice(spec.Ulimits, func(i, j int) bool { return spec.Ulimits[i].Name < spec.Ulimits[j].Name })
+	}
+	if len(spec.Volumes) == 0 {
+		spec.Volumes = nil
+	} else {
+		sort.Strings(spec.Volumes)
+	}
+	if len(spec.Devices) == 0 {
+		spec.Devices = nil
+	} else {
+		sort.Strings(spec.Devices)
+	}
+	spec.CapAdd = canonicalCapabilities(spec.CapAdd)
+	spec.CapDrop = canonicalCapabilities(spec.CapDrop)
+	if hc, err := normalizeHealthcheck(spec.Healthcheck); err == nil {
+		spec.Healthcheck = hc
+	}
+}
+
+// DiffSpec compares the app's current spec with desired. A zero
+// ExternalPort in desired means "keep whatever port is assigned".
+func DiffSpec(app *models.App, desired *models.AppSpec) *models.SpecDiff {
+	current := SpecFromApp(app)
+	if desired.ExternalPort == 0 {
+		desired.ExternalPort = current.ExternalPort
+	}
+
+	diff := &models.SpecDiff{Changes: []models.SpecChange{}}
+	for _, f := range specFields {
+		from, to := f.get(current), f.get(desired)
+		if reflect.DeepEqual(from, to) {
+			continue
+		}
+		diff.Changes = append(diff.Changes, models.SpecChange{Field: f.name, From: from, To: to})
+		if f.rebuild {
+			diff.RebuildRequired = true
+		} else if !controllerFields[f.name] {
+			diff.RestartRequired = true
+		}
+	}
+
+	// A container only needs a restart if there is one to restart, and a
+	// rebuild restarts it anyway.
+	if diff.RebuildRequired || app.Status != models.StatusRunning {
+		diff.RestartRequired = false
+	}
+	return diff
+}
+
+// ApplySpec converges the app onto spec. Applying the same spec twice is a
+// no-op that reports an empty diff. Neither the rebuild nor the restart is
+// triggered here; the diff says which one the caller should do.
+func (m *AppManager) ApplySpec(appID string, spec *models.AppSpec, actor string) (*models.SpecDiff, error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+
+	CanonicalizeSpec(spec)
+	if err := validateSpec(spec); err != nil {
+		return nil, err
+	}
+	if spec.RepoURL != app.RepoURL {
+		return nil, fmt.Errorf("repoUrl cannot be changed; delete and re-create the app instead")
+	}
+
+	diff := DiffSpec(app, spec)
+	if len(diff.Changes) == 0 {
+		return diff, nil
+	}
+
+	publishAddress := spec.BindAddress
+	if publishAddress == "" {
+		publishAddress = m.settings.Get().BindAddress
+	}
+	if spec.ExternalPort != app.ExternalPort && !m.portAllocator.IsPortAvailableForApp(spec.ExternalPort, app.ID, publishAddress) {
+		return nil, fmt.Errorf("port %d is not available", spec.ExternalPort)
+	}
+
+	for _, f := range specFields {
+		f.set(app, spec)
+	}
+	if err := m.UpdateApp(app, actor); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// CreateAppFromSpec registers a new app from spec. Like CreateApp, it
+// clones the repo; unlike CreateApp it refuses to touch an existing app with
+// the same slug.
+func (m *AppManager) CreateAppFromSpec(ctx context.Context, spec *models.AppSpec, actor string) (*models.App, error) {
+	CanonicalizeSpec(spec)
+	if err := validateSpec(spec); err != nil {
+		return nil, err
+	}
+
+	if slug := m.gitService.extractSlug(spec.RepoURL); slug != "" {
+		if err := m.checkNewSlug(slug); err != nil {
+			return nil, err
+		}
+	}
+
+	offlineBuild := spec.OfflineBuild
+	app, err := m.CreateApp(ctx, spec.RepoURL, spec.Branch, &models.ConfigureAppRequest{
+		Name:                 spec.Name,
+		DockerfilePath:       spec.DockerfilePath,
+		BuildContext:         spec.BuildContext,
+		InternalPort:         spec.InternalPort,
+		ExternalPort:         spec.ExternalPort,
+		Env:                  spec.Env,
+		BuildArgs:            spec.BuildArgs,
+		Volumes:              spec.Volumes,
+		Devices:              spec.Devices,
+		Labels:               spec.Labels,
+		User:                 &spec.User,
+		Entrypoint:           models.ArgsOverride{Set: spec.Entrypoint != nil, Value: argsFromSpec(spec.Entrypoint)},
+		Command:              models.ArgsOverride{Set: spec.Command != nil, Value: argsFromSpec(spec.Command)},
+		ExtraHosts:           spec.ExtraHosts,
+		DNS:                  spec.DNS,
+		LogMaxSize:           &spec.LogMaxSize,
+		LogMaxFiles:          &spec.LogMaxFiles,
+		UseProxy:             &spec.UseProxy,
+		ContainerName:        &spec.ContainerName,
+		Hostname:             &spec.Hostname,
+		MemoryLimit:          &spec.MemoryLimit,
+		MemorySwap:           &spec.MemorySwap,
+		MemoryReservation:    &spec.MemoryReservation,
+		ShmSize:              &spec.ShmSize,
+		Ulimits:              spec.Ulimits,
+		BindAddress:          &spec.BindAddress,
+		PublishIPv6:          &spec.PublishIPv6,
+		AutoRecreate:         &spec.AutoRecreate,
+		NetworkIsolated:      &spec.NetworkIsolated,
+		PreserveLocalChanges: &spec.PreserveLocalChanges,
+		FailStop:             &spec.FailStop,
+		LiteralEnv:           &spec.LiteralEnv,
+		Sysctls:              spec.Sysctls,
+		OfflineBuild:         &offlineBuild,
+		BuildTarget:          &spec.BuildTarget,
+		Platform:             &spec.Platform,
+		NetworkMode:          spec.NetworkMode,
+		Network:              &spec.Network,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Fields CreateApp doesn't take, and anything the manifest filled in
+	// that the spec doesn't want.
+	if _, err := m.ApplySpec(app.ID, spec, actor); err != nil {
+		return nil, err
+	}
+	return m.db.GetApp(app.ID)
+}
+
+func validateSpec(spec *models.AppSpec) error {
+	if spec.RepoURL == "" || spec.Branch == "" {
+		return fmt.Errorf("repoUrl and branch are required")
+	}
+	if spec.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if spec.InternalPort < 1 || spec.InternalPort > 65535 {
+		return fmt.Errorf("internalPort must be between 1 and 65535")
+	}
+	if spec.ExternalPort < 0 || spec.ExternalPort > 65535 {
+		return fmt.Errorf("externalPort must be between 1 and 65535")
+	}
+	if spec.Replicas < 1 || spec.Replicas > MaxReplicas {
+		return fmt.Errorf("replicas must be between 1 and %d", MaxReplicas)
+	}
+	if err := validateNetworking(spec.NetworkMode, spec.Network, spec.IPAddress, spec.Replicas); err != nil {
+		return err
+	}
+	if err := validateGPU(spec.GPU, spec.GPUCapabilities, spec.GPURuntime); err != nil {
+		return err
+	}
+	if err := validateDevices(spec.Devices); err != nil {
+		return err
+	}
+	if err := validateVolumes(spec.Volumes); err != nil {
+		return err
+	}
+	if _, err := normalizeCapabilities("capAdd", spec.CapAdd); err != nil {
+		return err
+	}
+	if _, err := normalizeCapabilities("capDrop", spec.CapDrop); err != nil {
+		return err
+	}
+	if _, err := normalizeHealthcheck(spec.Healthcheck); err != nil {
+		return err
+	}
+	if err := validateLabels(spec.Labels); err != nil {
+		return err
+	}
+	if err := ValidateContainerUser(spec.User); err != nil {
+		return err
+	}
+	if err := ValidateExtraHosts(spec.ExtraHosts); err != nil {
+		return err
+	}
+	if err := ValidateDNS(spec.DNS); err != nil {
+		return err
+	}
+	if err := ValidateLogOptions(spec.LogMaxSize, spec.LogMaxFiles); err != nil {
+		return err
+	}
+	if err := ValidateContainerName(spec.ContainerName); err != nil {
+		return err
+	}
+	if err := ValidateHostname(spec.Hostname); err != nil {
+		return err
+	}
+	if err := ValidateMemoryOptions(spec.MemoryLimit, spec.MemorySwap, spec.MemoryReservation, spec.ShmSize); err != nil {
+		return err
+	}
+	if err := ValidateUlimits(spec.Ulimits); err != nil {
+		return err
+	}
+	if err := ValidateSysctls(spec.Sysctls, spec.NetworkMode); err != nil {
+		return err
+	}
+	if err := ValidateBindAddress(spec.BindAddress); err != nil {
+		return err
+	}
+	if err := ValidatePublishIPv6(spec.PublishIPv6); err != nil {
+		return err
+	}
+	return ValidateRestartPolicy(spec.RestartPolicy, spec.MaxRetries)
+}
+
+// argsToSpec and argsFromSpec convert an entrypoint or command between the
+// app's nil-or-slice form and the spec's pointer, keeping an empty override
+// distinct from none.
+func argsToSpec(args []string) *[]string {
+	if args == nil {
+		return nil
+	}
+	copied := append([]string{}, args...)
+	return &copied
+}
+
+func argsFromSpec(args *[]string) []string {
+	if args == nil {
+		return nil
+	}
+	return append([]string{}, *args...)
+}
+
+// canonicalCapabilities sorts capability names in their normalized form,
+// leaving unknown ones for validateSpec to reject.
+func canonicalCapabilities(caps []string) []string {
+	if len(caps) == 0 {
+		return nil
+	}
+	out := make([]string, len(caps))
+	for i, c := range caps {
+		out[i] = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(c)), "CAP_")
+	}
+	sort.Strings(out)
+	return out
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func copyHealthcheck(hc *models.Healthcheck) *models.Healthcheck {
+	if hc == nil {
+		return nil
+	}
+	out := *hc
+	return &out
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// isCompositeStatus reports whether status covers a multi-step flow rather
+// than a single container state.
+func isCompositeStatus(status models.AppStatus) bool {
+	return status == models.StatusUpdating || status == models.StatusDeploying
+}
+
+// All app status changes go through the three functions below, so the rules
+// for composite flows live in one place:
+//
+//	beginFlow:  running            -> updating (sub: running)
+//	applyStep:  updating (running) -> updating (sub: stopped)
+//	            updating (stopped) -> updating (sub: building)
+//	            ...
+//	endFlow:    updating (running) -> running
+//
+// Outside a flow, applyStep simply sets the status. Watchers of Status thus
+// see a single updating -> running transition instead of the churn of the
+// individual steps.
+
+// applyStep returns the (status, subStatus) after a step reaches step.
+func applyStep(status, subStatus, step models.AppStatus) (models.AppStatus, models.AppStatus) {
+	if isCompositeStatus(status) {
+		return status, step
+	}
+	return step, ""
+}
+
+// beginFlow enters composite. started is false if the app is already inside
+// a flow, in which case the outer flow stays in charge and the caller must
+// not end it.
+func beginFlow(status, subStatus, composite models.AppStatus) (models.AppStatus, models.AppStatus, bool) {
+	if isCompositeStatus(status) {
+		return status, subStatus, false
+	}
+	return composite, status, true
+}
+
+// endFlow leaves a composite flow, settling on the state the last step
+// reached.
+func endFlow(status, subStatus models.AppStatus) (models.AppStatus, models.AppStatus) {
+	if !isCompositeStatus(status) {
+		return status, subStatus
+	}
+	if subStatus == "" {
+		return models.StatusStopped, ""
+	}
+	return subStatus, ""
+}
+
+func (m *AppManager) setStatus(app *models.App, step models.AppStatus) {
+	app.Status, app.SubStatus = applyStep(app.Status, app.SubStatus, step)
+}
+
+// startFlow puts the app into composite for the duration of a multi-step
+// operation. The returned func ends the flow; it is a no-op when the app was
+// already inside one.
+func (m *AppManager) startFlow(appID string, composite models.AppStatus) func() {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return func() {}
+	}
+
+	var started bool
+	app.Status, app.SubStatus, started = beginFlow(app.Status, app.SubStatus, composite)
+	if !started {
+		return func() {}
+	}
+	// Registered before the status is written, so the watchdog never sees
+	// the flow's status without the flow.
+	m.trackFlow(appID, 1)
+	m.db.UpdateApp(app)
+
+	return func() {
+		defer m.trackFlow(appID, -1)
+		app, err := m.db.GetApp(appID)
+		if err != nil {
+			return
+		}
+		app.Status, app.SubStatus = endFlow(app.Status, app.SubStatus)
+		m.db.UpdateApp(app)
+	}
+}
+
+// flowSpan is an app's current or most recent composite flow. Nested flows
+// share the outermost one's span.
+type flowSpan struct {
+	depth int
+	start time.Time
+	// end is zero while the flow runs
+	end time.Time
+}
+
+// track records a flow starting (delta 1) or ending (delta -1) at now.
+func (s *flowSpan) track(delta int, now time.Time) {
+	if delta > 0 && s.depth == 0 {
+		s.start, s.end = now, time.Time{}
+	}
+	s.depth += delta
+	if s.depth <= 0 {
+		s.depth, s.end = 0, now
+	}
+}
+
+// covers reports whether at falls inside the span.
+func (s *flowSpan) covers(at time.Time) bool {
+	if s.start.IsZero() || at.Before(s.start) {
+		return false
+	}
+	return s.depth > 0 || !at.After(s.end)
+}
+
+// trackFlow records a flow starting (delta 1) or ending (delta -1). The
+// span of an app's last flow is kept after it ends, so events that are only
+// judged later can still be matched to it.
+func (m *AppManager) trackFlow(appID string, delta int) {
+	m.flowsMu.Lock()
+	defer m.flowsMu.Unlock()
+	span, ok := m.flows[appID]
+	if !ok {
+		span = &flowSpan{}
+		m.flows[appID] = span
+	}
+	span.track(delta, time.Now())
+}
+
+// inFlow reports whether a composite flow for the app is running in this
+// process.
+func (m *AppManager) inFlow(appID string) bool {
+	m.flowsMu.Lock()
+	defer m.flowsMu.Unlock()
+	span, ok := m.flows[appID]
+	return ok && span.depth > 0
+}
+
+// InFlowAt reports whether at fell inside a composite flow of the app, for
+// the exit monitor to leave out the stops and starts of the flow's steps.
+func (m *AppManager) InFlowAt(appID string, at time.Time) bool {
+	m.flowsMu.Lock()
+	defer m.flowsMu.Unlock()
+	span, ok := m.flows[appID]
+	return ok && span.covers(at)
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+func TestFlowTransitions(t *testing.T) {
+	status, sub, started := beginFlow(models.StatusRunning, "", models.StatusUpdating)
+	if !started || status != models.StatusUpdating || sub != models.StatusRunning {
+		t.Fatalf("beginFlow = %s (%s), %v", status, sub, started)
+	}
+
+	for _, step := range []models.AppStatus{models.StatusStopped, models.StatusBuilding, models.StatusStarting, models.StatusRunning} {
+		status, sub = applyStep(status, sub, step)
+		if status != models.StatusUpdating || sub != step {
+			t.Fatalf("applyStep(%s) = %s (%s), want updating (%s)", step, status, sub, step)
+		}
+	}
+
+	if _, _, nested := beginFlow(status, sub, models.StatusDeploying); nested {
+		t.Error("beginFlow started a flow inside a flow")
+	}
+
+	status, sub = endFlow(status, sub)
+	if status != models.StatusRunning || sub != "" {
+		t.Errorf("endFlow = %s (%s), want running", status, sub)
+	}
+}
+
+func TestApplyStepOutsideFlow(t *testing.T) {
+	status, sub := applyStep(models.StatusRunning, "", models.StatusStopped)
+	if status != models.StatusStopped || sub != "" {
+		t.Errorf("applyStep = %s (%s), want stopped", status, sub)
+	}
+}
+
+func TestEndFlow(t *testing.T) {
+	tests := []struct {
+		status, sub models.AppStatus
+		want        models.AppStatus
+	}{
+		{models.StatusDeploying, models.StatusBuildFailed, models.StatusBuildFailed},
+		{models.StatusUpdating, models.StatusError, models.StatusError},
+		{models.StatusDeploying, "", models.StatusStopped},
+		{models.StatusRunning, "", models.StatusRunning},
+	}
+	for _, tt := range tests {
+		if got, sub := endFlow(tt.status, tt.sub); got != tt.want || sub != "" {
+			t.Errorf("endFlow(%s, %s) = %s (%s), want %s", tt.status, tt.sub, got, sub, tt.want)
+		}
+	}
+}
+
+func TestFlowSpanCovers(t *testing.T) {
+	t0 := time.Now()
+	var span flowSpan
+	if span.covers(t0) {
+		t.Fatal("empty span covers")
+	}
+
+	span.track(1, t0)
+	span.track(1, t0.Add(time.Second))
+	span.track(-1, t0.Add(2*time.Second))
+	if span.depth != 1 || !span.covers(t0.Add(time.Hour)) {
+		t.Fatal("running outer flow should cover anything after its start")
+	}
+	span.track(-1, t0.Add(3*time.Second))
+
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{t0.Add(-time.Second), false},
+		{t0, true},
+		{t0.Add(3 * time.Second), true},
+		{t0.Add(4 * time.Second), false},
+	}
+	for _, tt := range tests {
+		if got := span.covers(tt.at); got != tt.want {
+			t.Errorf("covers(t0%+v) = %v, want %v", tt.at.Sub(t0), got, tt.want)
+		}
+	}
+
+	// A new flow replaces the old span
+	span.track(1, t0.Add(10*time.Second))
+	if span.covers(t0.Add(time.Second)) {
+		t.Error("new flow still covers the previous one")
+	}
+}
+
+type fakeFlows map[string]bool
+
+func (f fakeFlows) InFlowAt(appID string, at time.Time) bool { return f[appID] }
+
+func TestExitMonitorSuppressesExitsInsideFlow(t *testing.T) {
+	e := NewExitMonitor(nil, nil, nil)
+	e.SetFlows(fakeFlows{"app1": true})
+
+	// Returns before touching Docker or the database, which are nil here
+	e.handleExit(t.Context(), &pendingExit{exit: docker.ContainerExit{AppID: "app1", ExitCode: 137, At: time.Now()}, exits: 1})
+	if got := e.Stats().Suppressed; got != 1 {
+		t.Errorf("suppressed = %d, want 1", got)
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// ReceiveUpload unpacks an uploaded build context into staging. Pass the
+// result to CreateUploadedApp or ReplaceUpload, which take ownership of it.
+func (m *AppManager) ReceiveUpload(r io.Reader) (string, error) {
+	return m.uploads.Receive(r)
+}
+
+// DiscardUpload drops a staged upload that won't be used.
+func (m *AppManager) DiscardUpload(staged string) {
+	m.uploads.Discard(staged)
+}
+
+// CreateUploadedApp creates an app whose source is the staged upload. The
+// app's slug comes from config.Name.
+func (m *AppManager) CreateUploadedApp(ctx context.Context, staged string, config *models.ConfigureAppRequest) (*models.App, error) {
+	committed := false
+	defer func() {
+		if !committed {
+			m.uploads.Discard(staged)
+		}
+	}()
+
+	if strings.TrimSpace(config.Name) == "" {
+		return nil, fmt.Errorf("name is required for an uploaded app")
+	}
+	slug := NormalizeSlug(config.Name)
+	if err := m.checkNewSlug(slug); err != nil {
+		return nil, err
+	}
+
+	cloneResult, err := m.inspectUpload(staged, config.DockerfilePath)
+	if err != nil {
+		return nil, err
+	}
+	cloneResult.Slug = slug
+
+	if err := m.uploads.Commit(staged, slug); err != nil {
+		return nil, err
+	}
+	committed = true
+
+	app, err := m.createApp(ctx, "upload:"+slug, models.SourceTypeUpload, models.SourceTypeUpload, cloneResult, config)
+	if err != nil {
+		m.uploads.Remove(slug)
+		return nil, err
+	}
+	return app, nil
+}
+
+// ReplaceUpload swaps an uploaded app's source for the staged upload and
+// marks the app for rebuild. The swap holds the app's build lease, so it is
+// refused while a build runs or waits in the queue, and a build can't start
+// reading the source halfway through it.
+func (m *AppManager) ReplaceUpload(appID string, staged string) (*models.App, error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		m.uploads.Discard(staged)
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+	if app.SourceType != models.SourceTypeUpload {
+		m.uploads.Discard(staged)
+		return nil, fmt.Errorf("app %s is built from a repository, not an upload", app.Slug)
+	}
+	if m.buildService.Queued(app.ID) {
+		m.uploads.Discard(staged)
+		return nil, fmt.Errorf("app %s has a build queued; upload again once it finishes", app.Slug)
+	}
+	release, err := m.buildService.acquireLease(app.ID, func() {})
+	if err != nil {
+		m.uploads.Discard(staged)
+		return nil, fmt.Errorf("app %s is being built; upload again once the build finishes", app.Slug)
+	}
+	defer release()
+	if _, err := m.inspectUpload(staged, app.DockerfilePath); err != nil {
+		m.uploads.Discard(staged)
+		return nil, err
+	}
+	manifestBefore, _ := m.manifestVolumes(app.Slug, m.gitService.ReadManifest(m.repoPath(app)))
+	if err := m.uploads.Commit(staged, app.Slug); err != nil {
+		m.uploads.Discard(staged)
+		return nil, err
+	}
+
+	now := time.Now()
+	app.LastPulled = &now
+	app.RebuildRequired = true
+	manifest := m.gitService.ReadManifest(m.repoPath(app))
+	m.iconService.ResolveIcon(app, m.repoPath(app), manifest)
+	m.db.UpdateApp(app)
+
+	manifestAfter, _ := m.manifestVolumes(app.Slug, manifest)
+	m.reportManifestVolumeChanges(app, manifestBefore, manifestAfter)
+	return app, nil
+}
+
+// inspectUpload checks that a staged upload has a Dockerfile (at
+// dockerfilePath if given) and reads its manifest.
+func (m *AppManager) inspectUpload(dir, dockerfilePath string) (*models.CloneResult, error) {
+	if dockerfilePath != "" {
+		path := filepath.Join(dir, filepath.FromSlash(dockerfilePath))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return nil, fmt.Errorf("dockerfilePath escapes the build context")
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("no Dockerfile at %s in the upload", dockerfilePath)
+		}
+	}
+
+	result, err := m.gitService.validateLocalPath(dir)
+	if err != nil {
+		if dockerfilePath == "" {
+			return nil, fmt.Errorf("no Dockerfile found in the upload")
+		}
+		// validateLocalPath only knows the usual places
+		result = &models.CloneResult{HasDockerfile: true, DockerfilePath: dockerfilePath, Manifest: m.gitService.ReadManifest(dir)}
+		readBuildTargets(result, dir)
+	}
+	return result, nil
+}
@@ -7,11 +7,20 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 type AuthService struct {
 	dataDir      string
 	passwordFile string
+
+	// The password is cached and only re-read when password.txt's mtime or
+	// size changes, e.g. after it's edited by hand.
+	mu       sync.Mutex
+	password string
+	modTime  time.Time
+	size     int64
 }
 
 func NewAuthService(dataDir string) *AuthService {
@@ -21,28 +30,34 @@ func NewAuthService(dataDir string) *AuthService {
 	}
 }
 
+// EnsurePassword returns the password, generating one if there is none.
+// It's the fallback for installs that skip the setup wizard.
 func (s *AuthService) EnsurePassword() (string, bool, error) {
-	// Check if password file exists
-	if _, err := os.Stat(s.passwordFile); os.IsNotExist(err) {
-		// Generate new password
-		password := generateRandomPassword(16)
-		if err := os.WriteFile(s.passwordFile, []byte(password), 0600); err != nil {
-			return "", false, err
-		}
-		return password, true, nil
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Read existing password
-	data, err := os.ReadFile(s.passwordFile)
-	if err != nil {
+	password, err := s.loadPassword()
+	if err == nil {
+		return password, false, nil
+	}
+	if !os.IsNotExist(err) {
 		return "", false, err
 	}
 
-	return strings.TrimSpace(string(data)), false, nil
+	// Generate new password
+	password = generateRandomPassword(16)
+	if err := s.storePassword(password); err != nil {
+		return "", false, err
+	}
+	return password, true, nil
 }
 
+// ValidatePassword checks password against the stored one. Before setup
+// there is none, and nothing validates.
 func (s *AuthService) ValidatePassword(password string) bool {
-	storedPassword, _, err := s.EnsurePassword()
+	s.mu.Lock()
+	storedPassword, err := s.loadPassword()
+	s.mu.Unlock()
 	if err != nil {
 		return false
 	}
@@ -55,7 +70,51 @@ func (s *AuthService) UpdatePassword(currentPassword, newPassword string) error
 		return os.ErrPermission
 	}
 
-	return os.WriteFile(s.passwordFile, []byte(newPassword), 0600)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storePassword(newPassword)
+}
+
+// loadPassword returns the cached password, re-reading password.txt if it
+// has changed on disk. Callers hold mu.
+func (s *AuthService) loadPassword() (string, error) {
+	info, err := os.Stat(s.passwordFile)
+	if err != nil {
+		return "", err
+	}
+	if s.password != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.password, nil
+	}
+
+	data, err := readFileRecovering(s.passwordFile, validPassword)
+	if err != nil {
+		return "", err
+	}
+	s.password = strings.TrimSpace(string(data))
+	s.cacheStat()
+	return s.password, nil
+}
+
+// storePassword writes password.txt atomically and caches the new
+// password. Callers hold mu.
+func (s *AuthService) storePassword(password string) error {
+	if err := writeFileAtomic(s.passwordFile, []byte(password), 0600, validPassword); err != nil {
+		return err
+	}
+	s.password = password
+	s.cacheStat()
+	return nil
+}
+
+func (s *AuthService) cacheStat() {
+	if info, err := os.Stat(s.passwordFile); err == nil {
+		s.modTime = info.ModTime()
+		s.size = info.Size()
+	}
+}
+
+func validPassword(data []byte) bool {
+	return strings.TrimSpace(string(data)) != ""
 }
 
 func (s *AuthService) GenerateSessionToken() string {
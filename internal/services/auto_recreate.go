@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// afterRebuild follows a successful build of a running app, whose container
+// still runs the previous image. With AutoRecreate the container is
+// swapped for one on the new image through StartApp, as a deploy; otherwise
+// the app is flagged RestartRequired. Either way an app event records it.
+func (m *AppManager) afterRebuild(ctx context.Context, app *models.App) {
+	if !app.AutoRecreate {
+		app.RestartRequired = true
+		m.db.UpdateApp(app)
+		m.recordRebuilt(app, "the container still runs the previous image; restart the app to use the new one")
+		return
+	}
+
+	logf(ctx, "App %s: recreating the container on the new image", app.Slug)
+	endFlow := m.startFlow(app.ID, models.StatusDeploying)
+	err := m.StartApp(ctx, app.ID)
+	endFlow()
+	if err != nil {
+		logf(ctx, "App %s: recreating after the build failed: %v", app.Slug, err)
+		m.recordRebuilt(app, fmt.Sprintf("recreating the container on the new image failed: %v", err))
+		return
+	}
+	m.recordRebuilt(app, "the container was recreated on the new image")
+}
+
+func (m *AppManager) recordRebuilt(app *models.App, detail string) {
+	m.db.CreateAppEvent(&models.AppEvent{
+		AppID:     app.ID,
+		Replica:   1,
+		Reason:    models.EventReasonImageRebuilt,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}, appEventLimit)
+}
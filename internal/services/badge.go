@@ -0,0 +1,174 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"text/template"
+
+	"nas-controller/internal/models"
+)
+
+// ErrBadgeNotFound is returned for a badge that is not enabled or whose
+// token doesn't match; the two are not told apart.
+var ErrBadgeNotFound = errors.New("badge not found")
+
+// Badge colours, as shields.io uses them.
+const (
+	badgeGreen  = "#4c1"
+	badgeGrey   = "#9f9f9f"
+	badgeRed    = "#e05d44"
+	badgeYellow = "#dfb317"
+	badgeLabel  = "#555"
+)
+
+// maxCachedBadges bounds the rendered badge cache; uptimes make the set of
+// messages open-ended, so it is emptied when full.
+const maxCachedBadges = 512
+
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Message}}">
+<title>{{.Label}}: {{.Message}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="{{.LabelWidth}}" height="20" fill="` + badgeLabel + `"/><rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/><rect width="{{.Width}}" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="15" fill="#010101" fill-opacity=".3">{{.Label}}</text><text x="{{.LabelX}}" y="14">{{.Label}}</text>
+<text x="{{.MessageX}}" y="15" fill="#010101" fill-opacity=".3">{{.Message}}</text><text x="{{.MessageX}}" y="14">{{.Message}}</text>
+</g>
+</svg>
+`))
+
+var (
+	badgeCacheMu sync.Mutex
+	badgeCache   = map[string][]byte{}
+)
+
+// BadgeToken returns the token the app's badge is served under, or "" if
+// it is not enabled.
+func (m *AppManager) BadgeToken(appID string) (string, error) {
+	if _, err := m.db.GetApp(appID); err != nil {
+		return "", fmt.Errorf("app not found: %v", err)
+	}
+	return m.db.GetBadgeToken(appID)
+}
+
+// EnableBadge makes the app's badge public under a new token, replacing
+// the previous one if there was one, and returns it.
+func (m *AppManager) EnableBadge(appID string) (string, error) {
+	if _, err := m.db.GetApp(appID); err != nil {
+		return "", fmt.Errorf("app not found: %v", err)
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := hex.EncodeToString(raw)
+	if err := m.db.SetBadgeToken(appID, token); err != nil {
+		return "", fmt.Errorf("failed to save badge token: %v", err)
+	}
+	return token, nil
+}
+
+// DisableBadge stops serving the app's badge.
+func (m *AppManager) DisableBadge(appID string) error {
+	return m.db.DeleteBadgeToken(appID)
+}
+
+// Badge renders the app's status badge as SVG if token is its badge token,
+// with the container's uptime when withUptime is set and it is running.
+func (m *AppManager) Badge(ctx context.Context, appID string, token string, withUptime bool) ([]byte, error) {
+	expected, err := m.db.GetBadgeToken(appID)
+	if err != nil || expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return nil, ErrBadgeNotFound
+	}
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, ErrBadgeNotFound
+	}
+
+	message, color := badgeStatus(app.Status)
+	if withUptime && app.Status == models.StatusRunning && app.ContainerID != "" {
+		if uptime, err := m.dockerClient.GetContainerUptime(ctx, app.ContainerID); err == nil && uptime != "" {
+			message += " " + uptime
+		}
+	}
+	return renderBadge(app.Name, message, color), nil
+}
+
+// badgeStatus is the message and colour the badge shows for status. The
+// composite flows rebuild the app, so they show as building.
+func badgeStatus(status models.AppStatus) (string, string) {
+	switch status {
+	case models.StatusRunning:
+		return "running", badgeGreen
+	case models.StatusStopped:
+		return "stopped", badgeGrey
+	case models.StatusError, models.StatusBuildFailed:
+		return string(status), badgeRed
+	case models.StatusBuilding, models.StatusStarting, models.StatusUpdating, models.StatusDeploying:
+		return string(status), badgeYellow
+	}
+	return string(status), badgeGrey
+}
+
+// renderBadge renders a badge once per label, message and colour and
+// serves later requests from the cache. The text is escaped here, since
+// app names are user input and text/template leaves it as is.
+func renderBadge(label, message, color string) []byte {
+	key := label + "\x00" + message + "\x00" + color
+	badgeCacheMu.Lock()
+	defer badgeCacheMu.Unlock()
+	if svg, ok := badgeCache[key]; ok {
+		return svg
+	}
+
+	labelWidth := badgeTextWidth(label) + 10
+	messageWidth := badgeTextWidth(message) + 10
+	var buf bytes.Buffer
+	badgeTemplate.Execute(&buf, struct {
+		Label, Message, Color           string
+		Width, LabelWidth, MessageWidth int
+		LabelX, MessageX                float64
+	}{
+		Label:        template.HTMLEscapeString(label),
+		Message:      template.HTMLEscapeString(message),
+		Color:        color,
+		Width:        labelWidth + messageWidth,
+		LabelWidth:   labelWidth,
+		MessageWidth: messageWidth,
+		LabelX:       float64(labelWidth) / 2,
+		MessageX:     float64(labelWidth) + float64(messageWidth)/2,
+	})
+
+	if len(badgeCache) >= maxCachedBadges {
+		badgeCache = map[string][]byte{}
+	}
+	badgeCache[key] = buf.Bytes()
+	return buf.Bytes()
+}
+
+// badgeTextWidth estimates the width of s in 11px Verdana, close enough to
+// size the badge without measuring the font.
+func badgeTextWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		switch {
+		case r == ' ' || r == 'i' || r == 'l' || r == 'j' || r == 'I' || r == '.' || r == ',' || r == ':' || r == '|' || r == '\'':
+			width += 4
+		case r == 'f' || r == 't' || r == 'r' || r == '-' || r == '(' || r == ')':
+			width += 5
+		case r == 'm' || r == 'w' || r == 'M' || r == 'W':
+			width += 11
+		case r >= 'A' && r <= 'Z':
+			width += 8
+		default:
+			width += 7
+		}
+	}
+	return width
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// batchConcurrency is how many apps a batch works on at once. Docker
+	// has no call that stops or starts several containers, so a batch is
+	// one call per app, overlapped.
+	batchConcurrency = 4
+	// batchTimeout caps a whole batch. Apps still going when it passes are
+	// forced where the action allows it, and reported as timed out.
+	batchTimeout = 90 * time.Second
+	// batchForceTimeout is how long forcing one straggler may take.
+	batchForceTimeout = 15 * time.Second
+)
+
+// Batch actions.
+const (
+	BatchStart   = "start"
+	BatchStop    = "stop"
+	BatchRestart = "restart"
+)
+
+// BatchResult is what a batch did to one app.
+type BatchResult struct {
+	AppID string `json:"appId"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// TimedOut is set when the app was still going at the batch's cap, and
+	// Forced when its containers were then killed rather than waited for.
+	TimedOut   bool  `json:"timedOut,omitempty"`
+	Forced     bool  `json:"forced,omitempty"`
+	DurationMs int64 `json:"durationMs"`
+}
+
+// BatchReport is the outcome of a batch, one result per app in the order
+// asked. ElapsedMs is how long the batch took; SequentialMs adds up the
+// apps' own durations, about what doing them one after another would
+// have taken.
+type BatchReport struct {
+	Action       string        `json:"action"`
+	Results      []BatchResult `json:"results"`
+	Succeeded    int           `json:"succeeded"`
+	Failed       int           `json:"failed"`
+	Forced       int           `json:"forced"`
+	ElapsedMs    int64         `json:"elapsedMs"`
+	SequentialMs int64         `json:"sequentialMs"`
+	Concurrency  int           `json:"concurrency"`
+}
+
+// batchAction is what a batch does per app. force, if set, is run on an
+// app still going at the cap, with a fresh context.
+type batchAction struct {
+	run   func(ctx context.Context, appID string) error
+	force func(ctx context.Context, appID string) error
+}
+
+func (m *AppManager) batchAction(action string) (batchAction, error) {
+	switch action {
+	case BatchStart:
+		return batchAction{run: m.StartApp}, nil
+	case BatchStop:
+		return batchAction{run: m.StopApp, force: m.killApp}, nil
+	case BatchRestart:
+		return batchAction{run: m.RestartApp}, nil
+	}
+	return batchAction{}, fmt.Errorf("action must be %s, %s or %s", BatchStart, BatchStop, BatchRestart)
+}
+
+// RunBatch does action to each app, batchConcurrency at a time, within
+// batchTimeout overall. Each app gets its own result whether it failed,
+// timed out or was forced; one app's failure doesn't stop the others.
+func (m *AppManager) RunBatch(ctx context.Context, action string, appIDs []string) (*BatchReport, error) {
+	do, err := m.batchAction(action)
+	if err != nil {
+		return nil, err
+	}
+	// An app listed twice would race itself
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(appIDs))
+	for _, id := range appIDs {
+		if _, err := m.db.GetApp(id); err != nil {
+			return nil, fmt.Errorf("app %s not found", id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	appIDs = unique
+
+	report := &BatchReport{Action: action, Results: make([]BatchResult, len(appIDs)), Concurrency: batchConcurrency}
+	batchCtx, cancel := context.WithTimeout(ctx, batchTimeout)
+	defer cancel()
+
+	start := time.Now()
+	slots := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, id := range appIDs {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			result := BatchResult{AppID: id}
+			select {
+			case slots <- struct{}{}:
+			case <-batchCtx.Done():
+				result.TimedOut = true
+				result.Error = "not started before the batch timed out"
+				report.Results[i] = result
+				return
+			}
+			defer func() { <-slots }()
+
+			began := time.Now()
+			err := do.run(batchCtx, id)
+			result.DurationMs = time.Since(began).Milliseconds()
+			// The actions carry on past some Docker errors, so a straggler
+			// shows as one that ended after the cap.
+			if batchCtx.Err() != nil && ctx.Err() == nil {
+				result.TimedOut = true
+				err = fmt.Errorf("timed out after %s", batchTimeout)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.OK = true
+			}
+			report.Results[i] = result
+		}(i, id)
+	}
+	wg.Wait()
+
+	for i := range report.Results {
+		result := &report.Results[i]
+		if result.TimedOut && do.force != nil {
+			forceCtx, cancelForce := context.WithTimeout(context.WithoutCancel(ctx), batchForceTimeout)
+			if err := do.force(forceCtx, result.AppID); err != nil {
+				result.Error = fmt.Sprintf("%s; forcing failed: %v", result.Error, err)
+			} else {
+				result.Forced = true
+				report.Forced++
+			}
+			cancelForce()
+		}
+		if result.OK {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+		report.SequentialMs += result.DurationMs
+	}
+	report.ElapsedMs = time.Since(start).Milliseconds()
+
+	log.Printf("Batch %s of %d apps: %d ok, %d failed, %d forced in %dms (%dms one by one)",
+		action, len(appIDs), report.Succeeded, report.Failed, report.Forced, report.ElapsedMs, report.SequentialMs)
+	return report, nil
+}
+
+// killApp is StopApp without waiting for the containers to exit: they are
+// removed by force, which kills them, and the app is then marked stopped.
+func (m *AppManager) killApp(ctx context.Context, appID string) error {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return fmt.Errorf("app not found: %v", err)
+	}
+	ids := []string{}
+	if app.ContainerID != "" {
+		ids = append(ids, app.ContainerID)
+	}
+	for i := 1; i <= max(app.Replicas, 1); i++ {
+		if c, _ := m.dockerClient.GetAppContainer(ctx, app.ID, i); c != nil {
+			ids = append(ids, c.ID)
+		}
+	}
+	for _, id := range app.ComposeContainers {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		m.dockerClient.RemoveContainer(ctx, id, true)
+	}
+	// Nothing is left for it to wait on
+	return m.StopApp(ctx, appID)
+}
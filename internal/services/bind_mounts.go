@@ -0,0 +1,276 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"nas-controller/internal/models"
+)
+
+// DefaultBindMountOwner owns directories created for bind mounts when
+// Settings.BindMountOwner is unset: Unraid's nobody:users.
+const DefaultBindMountOwner = "99:100"
+
+// DefaultBindMountPrefixes are the host paths apps may bind mount when
+// Settings.BindMountPrefixes is unset: the array, cache pools and
+// unassigned devices, but not the host's system directories.
+var DefaultBindMountPrefixes = []string{"/mnt/"}
+
+// bindMountOwnerPattern is a numeric uid:gid; names can't be resolved
+// against the host's users from inside the controller.
+var bindMountOwnerPattern = regexp.MustCompile(`^[0-9]+:[0-9]+$`)
+
+// createOption is the controller's own volume option; it is stripped
+// before the volume is handed to Docker.
+const createOption = "create"
+
+// volumeMount is one entry of an app's volumes, source:target[:options]
+// as in docker run -v, plus the controller's create[=uid:gid] option.
+type volumeMount struct {
+	source  string
+	target  string
+	options []string
+	// create is set for bind mounts whose host directory may be created;
+	// owner is the uid:gid it is given, empty for the default.
+	create bool
+	owner  string
+}
+
+// bind reports whether the mount is a host path rather than a named volume.
+func (v *volumeMount) bind() bool {
+	return strings.HasPrefix(v.source, "/")
+}
+
+// docker returns the mount as Docker takes it, without create.
+func (v *volumeMount) docker() string {
+	if len(v.options) == 0 {
+		return v.source + ":" + v.target
+	}
+	return v.source + ":" + v.target + ":" + strings.Join(v.options, ",")
+}
+
+func parseVolume(volume string) (*volumeMount, error) {
+	// The options may hold create's uid:gid, so split off only two fields
+	parts := strings.SplitN(volume, ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("volume %q: expected source:target[:options]", volume)
+	}
+	v := &volumeMount{source: parts[0], target: parts[1]}
+	if !strings.HasPrefix(v.target, "/") {
+		return nil, fmt.Errorf("volume %q: the container path must be absolute", volume)
+	}
+	if len(parts) == 3 {
+		for _, opt := range strings.Split(parts[2], ",") {
+			name, owner, hasOwner := strings.Cut(opt, "=")
+			if name != createOption {
+				v.options = append(v.options, opt)
+				continue
+			}
+			if hasOwner && !bindMountOwnerPattern.MatchString(owner) {
+				return nil, fmt.Errorf("volume %q: create takes a numeric uid:gid, such as create=99:100", volume)
+			}
+			v.create, v.owner = true, owner
+		}
+	}
+	if v.create && !v.bind() {
+		return nil, fmt.Errorf("volume %q: create only applies to host paths", volume)
+	}
+	return v, nil
+}
+
+// validateVolumes checks the form of an app's volumes. Whether host paths
+// are allowed and exist is checked when the container is created, since
+// both can change in between.
+func validateVolumes(volumes []string) error {
+	for _, volume := range volumes {
+		if _, err := parseVolume(volume); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateBindMountSettings checks the bind mount prefixes and owner
+// settings.
+func ValidateBindMountSettings(prefixes []string, owner string) error {
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("bind mount prefix %q must be an absolute path", prefix)
+		}
+	}
+	if owner != "" && !bindMountOwnerPattern.MatchString(owner) {
+		return fmt.Errorf("bindMountOwner must be a numeric uid:gid, such as 99:100")
+	}
+	return nil
+}
+
+// dockerVolumes returns the app's volumes as Docker takes them. Entries
+// that don't parse are passed through for Docker to reject.
+func dockerVolumes(volumes []string) []string {
+	out := make([]string, 0, len(volumes))
+	for _, volume := range volumes {
+		if v, err := parseVolume(volume); err == nil {
+			volume = v.docker()
+		}
+		out = append(out, volume)
+	}
+	return out
+}
+
+// prepareBindMounts checks the app's bind mounts before its container is
+// created, so Docker doesn't create a missing host directory as root (or
+// refuse to start) and a path outside the allowed prefixes is never
+// mounted. Missing directories of mounts with the create option are made
+// and given to their owner. It returns the volumes as Docker takes them;
+// errors name the offending mount.
+//
+// Host paths can only be checked where the controller sees them, so
+// existence is checked for paths under a directory mapped into the
+// controller at the same path (e.g. -v /mnt/user:/mnt/user), and left to
+// Docker elsewhere.
+func (m *AppManager) prepareBindMounts(app *models.App) ([]string, error) {
+	return m.prepareVolumes(app.Volumes)
+}
+
+// prepareVolumes is prepareBindMounts for a list of volumes.
+func (m *AppManager) prepareVolumes(appVolumes []string) ([]string, error) {
+	volumes := make([]string, 0, len(appVolumes))
+	for _, volume := range appVolumes {
+		v, missing, err := m.checkVolume(volume)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, v.docker())
+		if !missing {
+			continue
+		}
+
+		owner := v.owner
+		if owner == "" {
+			owner = m.settings.Get().BindMountOwner
+		}
+		if owner == "" {
+			owner = DefaultBindMountOwner
+		}
+		source := bindSource(v)
+		if err := createOwnedDir(source, owner); err != nil {
+			return nil, fmt.Errorf("volume %q: failed to create %s: %v", volume, source, err)
+		}
+	}
+	return volumes, nil
+}
+
+// checkVolume does prepareVolumes' checks for one volume without creating
+// anything. missing is set for a bind mount whose host directory would be
+// created.
+func (m *AppManager) checkVolume(volume string) (v *volumeMount, missing bool, err error) {
+	v, err = parseVolume(volume)
+	if err != nil || !v.bind() {
+		return v, false, err
+	}
+
+	prefixes := m.settings.Get().BindMountPrefixes
+	if prefixes == nil {
+		prefixes = DefaultBindMountPrefixes
+	}
+	source := bindSource(v)
+	if !underPrefixes(source, prefixes) {
+		return v, false, fmt.Errorf("volume %q: host path %s is outside the allowed bind mount prefixes (%s)",
+			volume, source, strings.Join(prefixes, ", "))
+	}
+
+	if _, err := os.Stat(source); err == nil {
+		return v, false, nil
+	} else if !os.IsNotExist(err) {
+		return v, false, fmt.Errorf("volume %q: cannot check host path %s: %v", volume, source, err)
+	}
+	if !hostPathVisible(source) {
+		if v.create {
+			return v, false, fmt.Errorf("volume %q: cannot create %s: map its parent into the controller at the same path, or create it on the host", volume, source)
+		}
+		return v, false, nil
+	}
+	if !v.create {
+		return v, false, fmt.Errorf("volume %q: host path %s does not exist; create it, or add the create option to have it created", volume, source)
+	}
+	return v, true, nil
+}
+
+// bindSource is the bind mount's host path, with symlinks resolved.
+func bindSource(v *volumeMount) string {
+	source := filepath.Clean(v.source)
+	if resolved, err := filepath.EvalSymlinks(source); err == nil {
+		source = resolved
+	}
+	return source
+}
+
+// underPrefixes reports whether path is one of the prefixes or inside one.
+func underPrefixes(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		prefix = filepath.Clean(prefix)
+		if prefix == "/" || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// createOwnedDir creates dir and any missing parents, giving each created
+// directory to owner ("uid:gid").
+func createOwnedDir(dir string, owner string) error {
+	uidText, gidText, _ := strings.Cut(owner, ":")
+	uid, _ := strconv.Atoi(uidText)
+	gid, _ := strconv.Atoi(gidText)
+
+	var missing []string
+	for path := dir; path != "/"; path = filepath.Dir(path) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		missing = append(missing, path)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, path := range missing {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostPathVisible reports whether path would be the host's own path inside
+// the controller: always outside a container, and inside one only below a
+// mount point other than the container's root filesystem.
+func hostPathVisible(path string) bool {
+	if _, err := os.Stat("/.dockerenv"); err != nil {
+		return true
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	unescape := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// id parent major:minor root mountpoint ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := unescape.Replace(fields[4])
+		if mountPoint != "/" && (path == mountPoint || strings.HasPrefix(path, mountPoint+"/")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// BuildCacheEntry is a build cache record and, when it can be told, the
+// build that created it.
+type BuildCacheEntry struct {
+	docker.BuildCacheEntry
+	AppID   string `json:"appId,omitempty"`
+	BuildID int64  `json:"buildId,omitempty"`
+}
+
+// BuildCacheUsage summarizes the daemon's build cache, largest entries
+// first.
+type BuildCacheUsage struct {
+	Entries []BuildCacheEntry `json:"entries"`
+	Total   int64             `json:"total"`
+	// Reclaimable is the size of the entries not in use.
+	Reclaimable int64 `json:"reclaimable"`
+	// Apps is the size attributed to each app, by app ID.
+	Apps map[string]int64 `json:"apps"`
+}
+
+// BuildCacheClear is what clearing an app's build cache did.
+type BuildCacheClear struct {
+	SpaceReclaimed uint64 `json:"spaceReclaimed"`
+	EntriesDeleted int    `json:"entriesDeleted"`
+	// Coarse is set when no entries could be attributed to the app and
+	// all unused cache older than its last build was pruned instead.
+	Coarse  bool   `json:"coarse"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// BuildCacheUsage lists the build cache. Docker doesn't say which build
+// made an entry, but the controller runs one build at a time, so an entry
+// created while a recorded build was running is attributed to it.
+func (s *BuildService) BuildCacheUsage(ctx context.Context) (*BuildCacheUsage, error) {
+	records, err := s.dockerClient.BuildCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	builds, err := s.db.GetAllBuilds()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &BuildCacheUsage{Entries: make([]BuildCacheEntry, 0, len(records)), Apps: map[string]int64{}}
+	for _, record := range records {
+		entry := BuildCacheEntry{BuildCacheEntry: record}
+		if build := buildAt(builds, record.CreatedAt); build != nil {
+			entry.AppID, entry.BuildID = build.AppID, build.ID
+			usage.Apps[build.AppID] += record.Size
+		}
+		usage.Entries = append(usage.Entries, entry)
+		usage.Total += record.Size
+		if !record.InUse {
+			usage.Reclaimable += record.Size
+		}
+	}
+	sort.SliceStable(usage.Entries, func(i, j int) bool {
+		return usage.Entries[i].Size > usage.Entries[j].Size
+	})
+	return usage, nil
+}
+
+// buildAt returns the build that was running at t, if any.
+func buildAt(builds []*models.Build, t time.Time) *models.Build {
+	for _, build := range builds {
+		finished := time.Now()
+		if build.FinishedAt != nil {
+			finished = *build.FinishedAt
+		}
+		if !t.Before(build.StartedAt) && !t.After(finished) {
+			return build
+		}
+	}
+	return nil
+}
+
+// ClearBuildCache prunes the unused build cache entries attributed to the
+// app. When none are, it falls back to pruning every unused entry not used
+// since the app's last build, which can take other apps' cache with it,
+// and says so.
+func (s *BuildService) ClearBuildCache(ctx context.Context, app *models.App) (*BuildCacheClear, error) {
+	usage, err := s.BuildCacheUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range usage.Entries {
+		if entry.AppID == app.ID && !entry.InUse {
+			ids = append(ids, entry.ID)
+		}
+	}
+
+	result := &BuildCacheClear{}
+	if len(ids) == 0 {
+		if app.LastBuild == nil {
+			return result, nil
+		}
+		result.Coarse = true
+		result.Warning = fmt.Sprintf("no build cache could be attributed to %s; pruned all unused build cache not used since its last build, which may include other apps' cache", app.Name)
+	}
+	unusedFor := time.Duration(0)
+	if app.LastBuild != nil {
+		unusedFor = time.Since(*app.LastBuild).Round(time.Second)
+	}
+	result.SpaceReclaimed, result.EntriesDeleted, err = s.dockerClient.PruneBuildCache(ctx, ids, unusedFor)
+	if err != nil {
+		return nil, err
+	}
+	logf(ctx, "Cleared build cache of %s: %d entries, %d bytes (coarse: %v)", app.Slug, result.EntriesDeleted, result.SpaceReclaimed, result.Coarse)
+	return result, nil
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Build failure categories recorded on the app after a failed build.
+const (
+	BuildFailurePullDenied = "pull-denied"
+	BuildFailureDiskFull   = "disk-full"
+	BuildFailureSyntax     = "dockerfile-syntax"
+	BuildFailureNetwork    = "network"
+	BuildFailureTestStep   = "test-failure"
+	BuildFailureUnknown    = "unknown"
+
+	// BuildFailureInterrupted marks builds cut off by a controller restart.
+	BuildFailureInterrupted = "interrupted"
+)
+
+// BuildFailureRule maps output fragments (matched case-insensitively) to a
+// category and the hint shown to the user.
+type BuildFailureRule struct {
+	Category string
+	Hint     string
+	Patterns []string
+	// Step, if set, must match the command of the step that failed for the
+	// rule to apply, so fragments that are only telling in that step's
+	// output don't match other steps'.
+	Step *regexp.Regexp
+}
+
+const buildFailureTestHint = "a test step in the Dockerfile failed — see the test output above the error"
+
+// testStep matches the command of a step that runs tests.
+var testStep = regexp.MustCompile(`(?i)\b(test|tests|pytest|jest|vitest|mocha|rspec)\b`)
+
+// Where the failing step's command is named: the classic builder's error,
+// BuildKit's, and failing those the last step the log started.
+var (
+	classicStepError  = regexp.MustCompile(`(?i)the command '([^']*)' returned a non-zero code`)
+	buildKitStepError = regexp.MustCompile(`(?i)process "((?:[^"\\]|\\.)*)" did not complete successfully`)
+	stepStart         = regexp.MustCompile(`(?m)^(?:Step \d+/\d+ : |#\d+ \[[^\]]*\] )(.*)$`)
+)
+
+// failingStep returns the command of the step the build failed in, or ""
+// if it can't be told.
+func failingStep(errMsg string, logTail string) string {
+	for _, pattern := range []*regexp.Regexp{classicStepError, buildKitStepError} {
+		if match := pattern.FindStringSubmatch(errMsg); match != nil {
+			return match[1]
+		}
+	}
+	if matches := stepStart.FindAllStringSubmatch(logTail, -1); len(matches) > 0 {
+		return matches[len(matches)-1][1]
+	}
+	return ""
+}
+
+var (
+	buildFailureRulesMu sync.RWMutex
+
+	// Ordered most to least specific; the first matching rule wins.
+	buildFailureRules = []BuildFailureRule{
+		{
+			Category: BuildFailurePullDenied,
+			Hint:     "base image pull was denied — check the FROM image name, or run docker login for that registry on the host",
+			Patterns: []string{
+				"pull access denied",
+				"requested access to the resource is denied",
+				"unauthorized: authentication required",
+				"toomanyrequests",
+			},
+		},
+		{
+			Category: BuildFailureDiskFull,
+			Hint:     "the Docker host ran out of disk space — prune unused images under Settings → Storage and retry",
+			Patterns: []string{
+				"no space left on device",
+				"disk quota exceeded",
+			},
+		},
+		{
+			Category: BuildFailureSyntax,
+			Hint:     "the Dockerfile could not be parsed — check the instruction named in the error",
+			Patterns: []string{
+				"dockerfile parse error",
+				"unknown instruction",
+				"failed to parse dockerfile",
+			},
+		},
+		// Test failures come before network ones: a failing test often logs
+		// a refused connection of its own. Test runners' own markers are
+		// telling anywhere; the generic wording only in a test step.
+		{
+			Category: BuildFailureTestStep,
+			Hint:     buildFailureTestHint,
+			Patterns: []string{
+				"npm err! test failed",
+				"--- fail:",
+				"test suite failed to run",
+			},
+		},
+		{
+			Category: BuildFailureTestStep,
+			Hint:     buildFailureTestHint,
+			Step:     testStep,
+			Patterns: []string{
+				"tests failed",
+				"test failed",
+				"failures:",
+				" failing",
+			},
+		},
+		{
+			Category: BuildFailureNetwork,
+			Hint:     "the build could not reach the network — check DNS and outbound access from the Docker host",
+			Patterns: []string{
+				"temporary failure in name resolution",
+				"could not resolve",
+				"no such host",
+				"getaddrinfo",
+				"eai_again",
+				"enotfound",
+				"network is unreachable",
+				"connection refused",
+				"connection timed out",
+				"i/o timeout",
+				"tls handshake timeout",
+				"dial tcp",
+				"failed to fetch",
+			},
+		},
+	}
+)
+
+// RegisterBuildFailureRule adds a rule ahead of the built-in ones, so
+// callers can both add categories and override the default matching.
+func RegisterBuildFailureRule(rule BuildFailureRule) {
+	buildFailureRulesMu.Lock()
+	defer buildFailureRulesMu.Unlock()
+	buildFailureRules = append([]BuildFailureRule{rule}, buildFailureRules...)
+}
+
+// ClassifyBuildFailure picks a category and hint from the build error and the
+// tail of the build log. Unmatched failures are BuildFailureUnknown with no
+// hint.
+func ClassifyBuildFailure(errMsg string, logTail string) (string, string) {
+	output := strings.ToLower(errMsg + "\n" + logTail)
+	step := failingStep(errMsg, logTail)
+
+	buildFailureRulesMu.RLock()
+	defer buildFailureRulesMu.RUnlock()
+
+	for _, rule := range buildFailureRules {
+		if rule.Step != nil && !rule.Step.MatchString(step) {
+			continue
+		}
+		for _, pattern := range rule.Patterns {
+			if strings.Contains(output, pattern) {
+				return rule.Category, rule.Hint
+			}
+		}
+	}
+	return BuildFailureUnknown, ""
+}
+
+// BuildError is returned by BuildService.BuildApp when the image build
+// itself fails, carrying the classification alongside the original error.
+type BuildError struct {
+	Err      error
+	Category string
+	Hint     string
+}
+
+func (e *BuildError) Error() string {
+	if e.Hint == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + "\nhint: " + e.Hint
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
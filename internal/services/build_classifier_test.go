@@ -0,0 +1,117 @@
+package services
+
+import "testing"
+
+func TestClassifyBuildFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		errMsg  string
+		logTail string
+		want    string
+	}{
+		{
+			name:   "pull denied",
+			errMsg: "pull access denied for private/app, repository does not exist",
+			want:   BuildFailurePullDenied,
+		},
+		{
+			name:    "disk full",
+			errMsg:  "The command '/bin/sh -c npm ci' returned a non-zero code: 1",
+			logTail: "npm ERR! nospc ENOSPC: no space left on device, write",
+			want:    BuildFailureDiskFull,
+		},
+		{
+			name:   "dockerfile syntax",
+			errMsg: "dockerfile parse error line 4: unknown instruction: RUNN",
+			want:   BuildFailureSyntax,
+		},
+		{
+			name:    "network in an install step",
+			errMsg:  "The command '/bin/sh -c apt-get update' returned a non-zero code: 100",
+			logTail: "Step 3/6 : RUN apt-get update\nErr:1 http://deb.debian.org bookworm InRelease\n  Temporary failure in name resolution",
+			want:    BuildFailureNetwork,
+		},
+		{
+			name:    "go test failure anywhere",
+			errMsg:  "The command '/bin/sh -c make ci' returned a non-zero code: 2",
+			logTail: "--- FAIL: TestParse (0.00s)\n    parse_test.go:12: got 1, want 2\nFAIL",
+			want:    BuildFailureTestStep,
+		},
+		{
+			name:    "test step that logs a refused connection",
+			errMsg:  "The command '/bin/sh -c npm test' returned a non-zero code: 1",
+			logTail: "Step 5/7 : RUN npm test\nError: connect ECONNREFUSED 127.0.0.1:5432\n1 test failed",
+			want:    BuildFailureTestStep,
+		},
+		{
+			name:    "buildkit test step",
+			errMsg:  `process "/bin/sh -c pytest -q" did not complete successfully: exit code: 1`,
+			logTail: "#9 [5/6] RUN pytest -q\n=== FAILURES: ===\n2 failed, 10 passed",
+			want:    BuildFailureTestStep,
+		},
+		{
+			name:    "step named in the log only",
+			errMsg:  "exit status 1",
+			logTail: "Step 6/8 : RUN go test ./...\nFAILURES: 3",
+			want:    BuildFailureTestStep,
+		},
+		{
+			name:    "compiler output mentioning failures outside a test step",
+			errMsg:  "The command '/bin/sh -c go build ./...' returned a non-zero code: 1",
+			logTail: "Step 4/6 : RUN go build ./...\n./main.go:3: retry failures: undefined: x\nlint: test failed to compile",
+			want:    BuildFailureUnknown,
+		},
+		{
+			name:    "linter failure in a build step",
+			errMsg:  "The command '/bin/sh -c npm run lint' returned a non-zero code: 1",
+			logTail: "Step 4/6 : RUN npm run lint\n  12:5  error  'x' is unused\n✖ 1 problem; 0 tests failed",
+			want:    BuildFailureUnknown,
+		},
+		{
+			name:   "nothing recognizable",
+			errMsg: "exit status 1",
+			want:   BuildFailureUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, hint := ClassifyBuildFailure(tt.errMsg, tt.logTail)
+			if got != tt.want {
+				t.Errorf("category = %q, want %q", got, tt.want)
+			}
+			if (hint == "") != (tt.want == BuildFailureUnknown) {
+				t.Errorf("hint = %q for category %q", hint, got)
+			}
+		})
+	}
+}
+
+func TestRegisterBuildFailureRuleTakesPrecedence(t *testing.T) {
+	saved := buildFailureRules
+	defer func() { buildFailureRules = saved }()
+
+	RegisterBuildFailureRule(BuildFailureRule{Category: "custom", Hint: "custom hint", Patterns: []string{"no such host"}})
+	if got, hint := ClassifyBuildFailure("dial tcp: lookup registry: no such host", ""); got != "custom" || hint != "custom hint" {
+		t.Errorf("got %q (%q), want the registered rule", got, hint)
+	}
+}
+
+func TestFailingStep(t *testing.T) {
+	tests := []struct {
+		errMsg  string
+		logTail string
+		want    string
+	}{
+		{"The command '/bin/sh -c npm test' returned a non-zero code: 1", "", "/bin/sh -c npm test"},
+		{`process "/bin/sh -c go test ./..." did not complete successfully: exit code: 1`, "", "/bin/sh -c go test ./..."},
+		{"exit status 1", "Step 1/3 : FROM alpine\nStep 2/3 : RUN make\n", "RUN make"},
+		{"exit status 1", "#5 [2/3] RUN make check\n#5 0.2 error", "RUN make check"},
+		{"exit status 1", "no steps here", ""},
+	}
+	for _, tt := range tests {
+		if got := failingStep(tt.errMsg, tt.logTail); got != tt.want {
+			t.Errorf("failingStep(%q, %q) = %q, want %q", tt.errMsg, tt.logTail, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"nas-controller/internal/logs"
+	"nas-controller/internal/models"
+)
+
+// DefaultBuildHistoryLimit is how many builds are kept per app when
+// Settings.BuildHistoryLimit is unset.
+const DefaultBuildHistoryLimit = 20
+
+// ErrBuildLogMissing is returned for a build whose log has been cleared,
+// or that predates per-build logs.
+var ErrBuildLogMissing = errors.New("build log no longer available")
+
+// Build initiators: the kinds of entry point a build or deploy can come
+// from.
+const (
+	// InitiatorManual is a user's request; the ID is its actor.
+	InitiatorManual = "manual"
+	// InitiatorCreate is the deploy that follows creating an app; the ID
+	// is the actor who created it.
+	InitiatorCreate = "create"
+	// InitiatorRecovery is the rebuild of an image found missing on
+	// start; the ID is whoever started the app.
+	InitiatorRecovery = "recovery"
+	// InitiatorWebhook, InitiatorSchedule and InitiatorAutoUpdate are for
+	// builds started by a hook, a scheduled job or an update check, with
+	// the hook's or job's name as the ID.
+	InitiatorWebhook    = "webhook"
+	InitiatorSchedule   = "schedule"
+	InitiatorAutoUpdate = "auto-update"
+)
+
+// BuildInitiator is where a build or deploy came from: Kind is one of the
+// initiators above and ID who or what within it.
+type BuildInitiator struct {
+	Kind string
+	ID   string
+}
+
+// String is the initiator as logs show it, e.g. "schedule nightly-rebuild".
+func (i BuildInitiator) String() string {
+	switch {
+	case i.Kind == "":
+		return "controller"
+	case i.ID == "":
+		return i.Kind
+	}
+	return i.Kind + " " + i.ID
+}
+
+type buildInitiatorKey struct{}
+
+// WithInitiator tags ctx with where the work came from, so builds done on
+// its behalf record it.
+func WithInitiator(ctx context.Context, kind string, id string) context.Context {
+	return context.WithValue(ctx, buildInitiatorKey{}, BuildInitiator{Kind: kind, ID: id})
+}
+
+// Initiator returns what ctx was tagged with, or the zero initiator for
+// work the controller started itself.
+func Initiator(ctx context.Context) BuildInitiator {
+	initiator, _ := ctx.Value(buildInitiatorKey{}).(BuildInitiator)
+	return initiator
+}
+
+func (s *BuildService) historyLimit() int {
+	if limit := s.settings.Get().BuildHistoryLimit; limit > 0 {
+		return limit
+	}
+	return DefaultBuildHistoryLimit
+}
+
+// historyDir holds the logs of the app's recorded builds, one per build.
+// build-<appID>.log is still the latest build's, for the existing
+// endpoints.
+func (s *BuildService) historyDir(appID string) string {
+	return filepath.Join(s.logsDir, "builds", appID)
+}
+
+// openBuildRecordLog creates the log file for a recorded build and saves
+// its path on the record. It returns nil if either fails; the build log
+// then only goes to build-<appID>.log.
+func (s *BuildService) openBuildRecordLog(ctx context.Context, build *models.Build) *os.File {
+	if build == nil {
+		return nil
+	}
+	path := filepath.Join(s.historyDir(build.AppID), fmt.Sprintf("%d.log", build.ID))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logf(ctx, "Failed to create build history log: %v", err)
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		logf(ctx, "Failed to create build history log: %v", err)
+		return nil
+	}
+	if err := s.db.SetBuildLogPath(build.ID, path); err != nil {
+		logf(ctx, "Failed to record build history log: %v", err)
+		file.Close()
+		os.Remove(path)
+		return nil
+	}
+	build.LogPath = path
+	return file
+}
+
+// pruneBuildHistory drops the app's builds beyond the history limit, with
+// their logs.
+func (s *BuildService) pruneBuildHistory(ctx context.Context, appID string) {
+	paths, err := s.db.PruneBuilds(appID, s.historyLimit())
+	if err != nil {
+		logf(ctx, "Failed to prune build history of %s: %v", appID, err)
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logf(ctx, "Failed to remove build log %s: %v", path, err)
+		}
+	}
+}
+
+// GetBuildRecordLog returns the log of one of the app's recorded builds,
+// or its last lines if lines is positive.
+func (s *BuildService) GetBuildRecordLog(appID string, buildID int64, lines int) (string, error) {
+	build, err := s.GetBuild(appID, buildID)
+	if err != nil {
+		return "", err
+	}
+	if build.LogPath == "" {
+		return "", ErrBuildLogMissing
+	}
+	if _, err := os.Stat(build.LogPath); os.IsNotExist(err) {
+		return "", ErrBuildLogMissing
+	}
+
+	var data []byte
+	if lines > 0 {
+		data, err = logs.TailLines(build.LogPath, lines)
+	} else {
+		data, err = os.ReadFile(build.LogPath)
+	}
+	return string(data), err
+}
+
+// RemoveBuildHistory deletes the logs of all the app's recorded builds.
+func (s *BuildService) RemoveBuildHistory(appID string) error {
+	return os.RemoveAll(s.historyDir(appID))
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// startBuildRecord records that a build of app has started, and prunes
+// the app's history down to its limit. It returns nil if the record
+// couldn't be saved; the build goes ahead regardless.
+func (s *BuildService) startBuildRecord(ctx context.Context, app *models.App, correlationID string, startedAt time.Time) *models.Build {
+	initiator := Initiator(ctx)
+	build := &models.Build{
+		AppID:         app.ID,
+		Commit:        app.LastCommit,
+		CorrelationID: correlationID,
+		Initiator:     initiator.Kind,
+		TriggeredBy:   initiator.ID,
+		StartedAt:     startedAt,
+	}
+	if err := s.db.CreateBuild(build); err != nil {
+		logf(ctx, "Failed to record build of %s: %v", app.Slug, err)
+		return nil
+	}
+	s.pruneBuildHistory(ctx, app.ID)
+	return build
+}
+
+// finishBuildRecord stores the build's outcome and the inputs it actually
+// used. For a successful build, base image or builder changes since the
+// last successful build are noted in the build log: those are the changes
+// nobody made on purpose.
+func (s *BuildService) finishBuildRecord(ctx context.Context, build *models.Build, app *models.App, contextPath string, success bool, logWriter io.Writer) {
+	if build == nil {
+		return
+	}
+	now := time.Now()
+	build.Success = success
+	build.FinishedAt = &now
+	build.Duration = now.Sub(build.StartedAt).Round(time.Second).String()
+	build.BuildArgsHash = hashBuildArgs(app.BuildArgs)
+	if build.Builder == "" {
+		build.Builder = docker.Builder
+	}
+	build.DockerVersion, _ = s.dockerClient.ServerVersion(ctx)
+	build.BaseImages = map[string]string{}
+	if dockerfile, err := os.ReadFile(filepath.Join(contextPath, app.DockerfilePath)); err == nil {
+		for _, image := range ParseBaseImages(dockerfile, app.BuildArgs) {
+			// A base that wasn't pulled (failed build) stays "".
+			build.BaseImages[image], _ = s.dockerClient.ImageDigest(ctx, image)
+		}
+	}
+
+	if err := s.db.FinishBuild(build); err != nil {
+		logf(ctx, "Failed to record build of %s: %v", app.Slug, err)
+		return
+	}
+
+	if !success || logWriter == nil {
+		return
+	}
+	previous, err := s.db.GetPreviousSuccessfulBuild(app.ID, build.ID)
+	if err != nil {
+		return
+	}
+	for _, change := range CompareBuilds(previous, build) {
+		switch change.Field {
+		case "baseImage":
+			fmt.Fprintf(logWriter, "\nNote: base image %s changed since build #%d (%s -> %s)", change.Image, previous.ID, orNone(change.From), orNone(change.To))
+		case "dockerVersion", "builder":
+			fmt.Fprintf(logWriter, "\nNote: %s changed since build #%d (%s -> %s)", change.Field, previous.ID, orNone(change.From), orNone(change.To))
+		}
+	}
+}
+
+// CompareBuilds lists the inputs that differ between from and to.
+func CompareBuilds(from, to *models.Build) []models.BuildInputChange {
+	changes := []models.BuildInputChange{}
+	add := func(field, image, a, b string) {
+		if a != b {
+			changes = append(changes, models.BuildInputChange{Field: field, Image: image, From: a, To: b})
+		}
+	}
+
+	add("commit", "", from.Commit, to.Commit)
+	add("buildArgs", "", from.BuildArgsHash, to.BuildArgsHash)
+	add("dockerVersion", "", from.DockerVersion, to.DockerVersion)
+	add("builder", "", from.Builder, to.Builder)
+
+	images := make(map[string]bool)
+	for image := range from.BaseImages {
+		images[image] = true
+	}
+	for image := range to.BaseImages {
+		images[image] = true
+	}
+	sorted := make([]string, 0, len(images))
+	for image := range images {
+		sorted = append(sorted, image)
+	}
+	sort.Strings(sorted)
+	for _, image := range sorted {
+		add("baseImage", image, from.BaseImages[image], to.BaseImages[image])
+	}
+	return changes
+}
+
+// GetBuilds returns a page of the app's build history, newest first: up to
+// limit builds older than build before. Zero leaves either unbounded.
+func (s *BuildService) GetBuilds(appID string, before int64, limit int) ([]*models.Build, error) {
+	return s.db.GetBuilds(appID, before, limit)
+}
+
+func (s *BuildService) GetBuild(appID string, id int64) (*models.Build, error) {
+	build, err := s.db.GetBuild(appID, id)
+	if err != nil {
+		return nil, fmt.Errorf("build not found")
+	}
+	return build, nil
+}
+
+// CompareBuildRecords compares two of the app's builds.
+func (s *BuildService) CompareBuildRecords(appID string, fromID, toID int64) (*models.BuildComparison, error) {
+	from, err := s.GetBuild(appID, fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.GetBuild(appID, toID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.BuildComparison{From: from, To: to, Changes: CompareBuilds(from, to)}, nil
+}
+
+// hashBuildArgs fingerprints build args without storing their values,
+// which may be secrets.
+func hashBuildArgs(args map[string]string) string {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, args[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
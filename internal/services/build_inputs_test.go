@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"nas-controller/internal/docker/dockertest"
+	"nas-controller/internal/models"
+)
+
+func TestCompareBuilds(t *testing.T) {
+	base := func() *models.Build {
+		return &models.Build{
+			Commit:        "abc12345",
+			BuildArgsHash: hashBuildArgs(map[string]string{"VERSION": "1"}),
+			DockerVersion: "27.3.1",
+			Builder:       "buildkit",
+			BaseImages:    map[string]string{"golang:1.24": "sha256:aaa", "alpine:3.20": "sha256:bbb"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(b *models.Build)
+		want   []models.BuildInputChange
+	}{
+		{
+			name:   "identical",
+			change: func(b *models.Build) {},
+			want:   []models.BuildInputChange{},
+		},
+		{
+			name:   "new commit",
+			change: func(b *models.Build) { b.Commit = "def67890" },
+			want:   []models.BuildInputChange{{Field: "commit", From: "abc12345", To: "def67890"}},
+		},
+		{
+			name:   "build args",
+			change: func(b *models.Build) { b.BuildArgsHash = hashBuildArgs(map[string]string{"VERSION": "2"}) },
+			want: []models.BuildInputChange{{
+				Field: "buildArgs",
+				From:  hashBuildArgs(map[string]string{"VERSION": "1"}),
+				To:    hashBuildArgs(map[string]string{"VERSION": "2"}),
+			}},
+		},
+		{
+			name: "docker upgrade and builder switch",
+			change: func(b *models.Build) {
+				b.DockerVersion = "27.4.0"
+				b.Builder = "classic"
+			},
+			want: []models.BuildInputChange{
+				{Field: "dockerVersion", From: "27.3.1", To: "27.4.0"},
+				{Field: "builder", From: "buildkit", To: "classic"},
+			},
+		},
+		{
+			name:   "base image moved",
+			change: func(b *models.Build) { b.BaseImages["alpine:3.20"] = "sha256:ccc" },
+			want:   []models.BuildInputChange{{Field: "baseImage", Image: "alpine:3.20", From: "sha256:bbb", To: "sha256:ccc"}},
+		},
+		{
+			// Images come out sorted, whatever the map order
+			name: "base image swapped for another",
+			change: func(b *models.Build) {
+				delete(b.BaseImages, "golang:1.24")
+				b.BaseImages["golang:1.25"] = "sha256:ddd"
+			},
+			want: []models.BuildInputChange{
+				{Field: "baseImage", Image: "golang:1.24", From: "sha256:aaa", To: ""},
+				{Field: "baseImage", Image: "golang:1.25", From: "", To: "sha256:ddd"},
+			},
+		},
+		{
+			// A failed build records the base it couldn't pull as ""
+			name:   "base image not pulled",
+			change: func(b *models.Build) { b.BaseImages["alpine:3.20"] = "" },
+			want:   []models.BuildInputChange{{Field: "baseImage", Image: "alpine:3.20", From: "sha256:bbb", To: ""}},
+		},
+		{
+			name:   "build from before inputs were recorded",
+			change: func(b *models.Build) { *b = models.Build{Commit: b.Commit} },
+			want: []models.BuildInputChange{
+				{Field: "buildArgs", From: hashBuildArgs(map[string]string{"VERSION": "1"}), To: ""},
+				{Field: "dockerVersion", From: "27.3.1", To: ""},
+				{Field: "builder", From: "buildkit", To: ""},
+				{Field: "baseImage", Image: "alpine:3.20", From: "sha256:bbb", To: ""},
+				{Field: "baseImage", Image: "golang:1.24", From: "sha256:aaa", To: ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := base(), base()
+			tt.change(to)
+			if got := CompareBuilds(from, to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CompareBuilds = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashBuildArgs(t *testing.T) {
+	args := map[string]string{"A": "1", "B": "2", "TOKEN": "hunter2"}
+	hash := hashBuildArgs(args)
+	if hash != hashBuildArgs(map[string]string{"TOKEN": "hunter2", "B": "2", "A": "1"}) {
+		t.Error("hash depends on map order")
+	}
+	if strings.Contains(hash, "hunter2") {
+		t.Error("hash carries a value")
+	}
+	for _, other := range []map[string]string{
+		{"A": "1", "B": "2", "TOKEN": "hunter3"},
+		{"A": "1", "B": "2"},
+		{"A": "1", "B": "2", "TOKEN": ""},
+	} {
+		if hashBuildArgs(other) == hash {
+			t.Errorf("%v hashes the same as %v", other, args)
+		}
+	}
+	if hashBuildArgs(nil) != hashBuildArgs(map[string]string{}) {
+		t.Error("no args and empty args differ")
+	}
+}
+
+func TestBuildRecordsInputsAndNotesDrift(t *testing.T) {
+	settings, db := newTestSettings(t)
+	dataDir := t.TempDir()
+	fake := dockertest.New()
+	builds := NewBuildService(db, fake, settings, dataDir)
+
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "Dockerfile"), []byte("FROM alpine:3.20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app := &models.App{ID: "app1", Name: "demo", Slug: "demo", ImageName: "demo:latest", DockerfilePath: "Dockerfile", LastCommit: "abc12345"}
+	if err := db.CreateApp(app); err != nil {
+		t.Fatal(err)
+	}
+	build := func() string {
+		t.Helper()
+		if err := builds.BuildApp(WithForceBuild(context.Background()), app, source, nil); err != nil {
+			t.Fatalf("BuildApp: %v", err)
+		}
+		log, err := os.ReadFile(filepath.Join(dataDir, "logs", fmt.Sprintf("build-%s.log", app.ID)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(log)
+	}
+
+	fake.AddImage("alpine:3.20", 1<<20)
+	if log := build(); strings.Contains(log, "Note:") {
+		t.Errorf("first build noted drift:\n%s", log)
+	}
+	if log := build(); strings.Contains(log, "Note:") {
+		t.Errorf("rebuild from the same inputs noted drift:\n%s", log)
+	}
+
+	// The tag moved upstream between builds
+	fake.AddImage("alpine:3.20", 1<<20)
+	log := build()
+	if !strings.Contains(log, "Note: base image alpine:3.20 changed since build #2") {
+		t.Errorf("base image change not noted:\n%s", log)
+	}
+
+	history, err := builds.GetBuilds(app.ID, 0, 0)
+	if err != nil || len(history) != 3 {
+		t.Fatalf("history = %d builds (%v), want 3", len(history), err)
+	}
+	latest := history[0]
+	if latest.BaseImages["alpine:3.20"] == "" || latest.DockerVersion == "" || latest.BuildArgsHash != hashBuildArgs(nil) {
+		t.Errorf("inputs not recorded: %+v", latest)
+	}
+	comparison, err := builds.CompareBuildRecords(app.ID, history[2].ID, latest.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comparison.Changes) != 1 || comparison.Changes[0].Field != "baseImage" {
+		t.Errorf("changes = %+v, want the base image only", comparison.Changes)
+	}
+	if _, err := builds.CompareBuildRecords("other", history[2].ID, latest.ID); err == nil {
+		t.Error("compared builds of another app")
+	}
+}
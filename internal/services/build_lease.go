@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+const (
+	buildLeaseHeartbeat = 10 * time.Second
+
+	// buildLeaseTTL is how long a lease survives without a heartbeat before
+	// another controller (or this one, after a restart) may take it over.
+	buildLeaseTTL = 45 * time.Second
+)
+
+// leaseHolderID identifies this controller process in build leases. It is
+// stable across restarts of the same container (same hostname, PID 1), so
+// leases left behind by a crash are recognised as ours straight away.
+func leaseHolderID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// acquireLease takes the DB build lease for app and heartbeats it until the
+// returned release func is called. If the lease is lost (another controller
+// took it over after missed heartbeats) cancel is called to stop the build.
+func (s *BuildService) acquireLease(appID string, cancel context.CancelFunc) (func(), error) {
+	ok, err := s.db.AcquireBuildLease(appID, s.holder, time.Now().Add(-buildLeaseTTL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to take build lease: %v", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("another build of this app is in progress")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(buildLeaseHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				held, err := s.db.HeartbeatBuildLease(appID, s.holder)
+				if err != nil {
+					log.Printf("Build lease heartbeat for %s failed: %v", appID, err)
+					continue
+				}
+				if !held {
+					log.Printf("Lost build lease for %s, cancelling build", appID)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		s.db.ReleaseBuildLease(appID, s.holder)
+	}, nil
+}
+
+// RecoverInterruptedBuilds expires build leases left behind by a dead
+// controller and marks those builds as failed. Run it at startup, before
+// ReconcileStates.
+func (m *AppManager) RecoverInterruptedBuilds() error {
+	appIDs, err := m.db.ExpireBuildLeases(time.Now().Add(-buildLeaseTTL), m.buildService.holder)
+	if err != nil {
+		return err
+	}
+
+	interrupted := make(map[string]bool, len(appIDs))
+	for _, appID := range appIDs {
+		interrupted[appID] = true
+	}
+
+	apps, err := m.db.GetAllApps()
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		// Apps stuck in "building" with no live lease were interrupted too
+		// (e.g. by a crash before leases existed). A live lease means
+		// another controller is building it right now.
+		if !interrupted[app.ID] && (app.Status != models.StatusBuilding || m.db.HasBuildLease(app.ID)) {
+			continue
+		}
+		log.Printf("Build of %s was interrupted by a controller restart", app.Slug)
+		app.Status = models.StatusBuildFailed
+		app.SubStatus = ""
+		app.LastBuildSuccess = false
+		app.LastBuildFailure = BuildFailureInterrupted
+		m.db.UpdateApp(app)
+	}
+	return nil
+}
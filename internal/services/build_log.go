@@ -0,0 +1,93 @@
+package services
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// DefaultMaxBuildLogMB caps each build-*.log file.
+	DefaultMaxBuildLogMB = 50
+
+	// buildLogTailSize is how much of the end of a capped build is kept, so
+	// the failure reason survives truncation.
+	buildLogTailSize = 4 * 1024 * 1024
+)
+
+// cappedLogWriter writes the head of a build log up to its limit, then only
+// keeps a rolling tail in memory until flush writes it out behind a
+// truncation marker. The cap never fails a write, so the build carries on.
+type cappedLogWriter struct {
+	w        io.Writer
+	limit    int64
+	tailSize int
+
+	written   int64
+	omitted   int64
+	truncated bool
+	flushed   bool
+	tail      []byte
+}
+
+// newCappedLogWriter caps the log at roughly limit bytes, head and tail
+// together.
+func newCappedLogWriter(w io.Writer, limit int64) *cappedLogWriter {
+	tailSize := buildLogTailSize
+	if int64(tailSize) > limit/2 {
+		tailSize = int(limit / 2)
+	}
+	return &cappedLogWriter{w: w, limit: limit - int64(tailSize), tailSize: tailSize}
+}
+
+func (c *cappedLogWriter) Write(p []byte) (int, error) {
+	total := len(p)
+
+	if !c.truncated {
+		room := c.limit - c.written
+		if int64(len(p)) <= room {
+			n, err := c.w.Write(p)
+			c.written += int64(n)
+			return n, err
+		}
+		if room > 0 {
+			c.w.Write(p[:room])
+			c.written += room
+			p = p[room:]
+		}
+		c.truncated = true
+	}
+
+	if c.flushed {
+		_, err := c.w.Write(p)
+		return total, err
+	}
+
+	c.tail = append(c.tail, p...)
+	// Let the buffer grow to twice the tail before compacting, so a
+	// chatty build doesn't copy megabytes on every write.
+	if len(c.tail) > 2*c.tailSize {
+		c.trimTail()
+	}
+	return total, nil
+}
+
+func (c *cappedLogWriter) trimTail() {
+	if over := len(c.tail) - c.tailSize; over > 0 {
+		c.omitted += int64(over)
+		c.tail = append(c.tail[:0], c.tail[over:]...)
+	}
+}
+
+// flush writes the retained tail behind a truncation marker. Anything
+// written afterwards (the build's closing status lines) goes straight to
+// the file.
+func (c *cappedLogWriter) flush() {
+	if !c.truncated || c.flushed {
+		return
+	}
+	c.flushed = true
+	c.trimTail()
+	fmt.Fprintf(c.w, "\n\n[... build log truncated: %d bytes omitted, last %d bytes follow ...]\n\n", c.omitted, len(c.tail))
+	c.w.Write(c.tail)
+	c.tail = nil
+}
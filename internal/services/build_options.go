@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"nas-controller/internal/docker"
+)
+
+type buildOptionsKey struct{}
+
+// WithBuildOptions asks builds done on ctx's behalf for options, like
+// WithInitiator, so they reach the build through rebuilds and deploys.
+func WithBuildOptions(ctx context.Context, options docker.BuildOptions) context.Context {
+	return context.WithValue(ctx, buildOptionsKey{}, options)
+}
+
+// BuildOptions returns the options ctx asks for, or none.
+func BuildOptions(ctx context.Context) docker.BuildOptions {
+	options, _ := ctx.Value(buildOptionsKey{}).(docker.BuildOptions)
+	return options
+}
+
+type forceBuildKey struct{}
+
+// WithForceBuild asks builds done on ctx's behalf to run even when nothing
+// changed since the app's last successful build.
+func WithForceBuild(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceBuildKey{}, true)
+}
+
+// ForceBuild reports whether ctx asks for builds to run regardless.
+func ForceBuild(ctx context.Context) bool {
+	force, _ := ctx.Value(forceBuildKey{}).(bool)
+	return force
+}
+
+// describeBuildOptions is options as the build log shows them.
+func describeBuildOptions(options docker.BuildOptions) string {
+	var flags []string
+	if options.Target != "" {
+		flags = append(flags, "--target "+options.Target)
+	}
+	if options.Platform != "" {
+		flags = append(flags, "--platform "+options.Platform)
+	}
+	if options.NoCache {
+		flags = append(flags, "--no-cache")
+	}
+	if options.PullBaseImage {
+		flags = append(flags, "--pull")
+	}
+	// IDs only; the values never reach the log
+	ids := make([]string, 0, len(options.Secrets))
+	for id := range options.Secrets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		flags = append(flags, "--secret id="+id)
+	}
+	if len(flags) == 0 {
+		return "none"
+	}
+	return strings.Join(flags, " ")
+}
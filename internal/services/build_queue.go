@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBuildSuperseded is returned for a queued build whose place was taken
+// by a newer request for the same app. The newer one builds whatever was
+// checked out last, so nothing is lost.
+var ErrBuildSuperseded = errors.New("superseded by a newer build request for the same app")
+
+// ErrBuildDequeued is returned for a queued build that was removed from the
+// queue before its turn came.
+var ErrBuildDequeued = errors.New("removed from the build queue")
+
+// ErrBuildNotQueued is returned when removing a build of an app that has
+// none waiting.
+var ErrBuildNotQueued = errors.New("no build of this app is waiting in the queue")
+
+// buildTicket is one app's place in the build queue.
+type buildTicket struct {
+	appID       string
+	requestedAt time.Time
+	// turn receives nil when the build may start, or ErrBuildSuperseded.
+	turn chan error
+}
+
+// CooldownSkip records the build queue passing an app over because its
+// last build started less than the cooldown ago.
+type CooldownSkip struct {
+	At    time.Time `json:"at"`
+	Until time.Time `json:"until"`
+}
+
+// QueuedBuild is an app waiting in the build queue.
+type QueuedBuild struct {
+	AppID         string     `json:"appId"`
+	Position      int        `json:"position"`
+	RequestedAt   time.Time  `json:"requestedAt"`
+	CooldownUntil *time.Time `json:"cooldownUntil,omitempty"`
+}
+
+// BuildQueueStatus is what the build worker is doing and what is waiting.
+// CooldownSkips holds, by app ID, the last time each app was passed over
+// for cooling down.
+type BuildQueueStatus struct {
+	Building      string                  `json:"building,omitempty"`
+	Waiting       []QueuedBuild           `json:"waiting"`
+	Cooldown      string                  `json:"cooldown"`
+	CooldownSkips map[string]CooldownSkip `json:"cooldownSkips"`
+}
+
+// waitTurn queues a build of appID and blocks until it may start. Builds
+// run one at a time. The queue holds one entry per app, so waiting apps take
+// turns in the order they first asked (round-robin by app rather than one
+// app's burst of requests ahead of everyone else). A request for an app
+// already waiting takes over its place and the older one gets
+// ErrBuildSuperseded. An app whose last build started within the cooldown
+// setting is passed over until it has cooled down.
+func (s *BuildService) waitTurn(ctx context.Context, appID string) error {
+	ticket := &buildTicket{appID: appID, requestedAt: time.Now(), turn: make(chan error, 1)}
+
+	s.queueMu.Lock()
+	replaced := false
+	for i, queued := range s.queue {
+		if queued.appID == appID {
+			queued.turn <- ErrBuildSuperseded
+			s.queue[i] = ticket
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		s.queue = append(s.queue, ticket)
+	}
+	s.dispatchLocked()
+	s.queueMu.Unlock()
+
+	select {
+	case err := <-ticket.turn:
+		return err
+	case <-ctx.Done():
+		s.queueMu.Lock()
+		defer s.queueMu.Unlock()
+		for i, queued := range s.queue {
+			if queued == ticket {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// Dispatched or superseded in the meantime; give the turn back
+		if err := <-ticket.turn; err == nil {
+			s.active = false
+			s.dispatchLocked()
+		}
+		return ctx.Err()
+	}
+}
+
+// finishTurn ends the running build's turn and starts the next one.
+func (s *BuildService) finishTurn() {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	s.active = false
+	s.dispatchLocked()
+}
+
+// checkWorker reports the build worker holding a turn with no build
+// running, which leaves the queue waiting forever. It blocks if either
+// lock is stuck, which the heartbeat notices.
+func (s *BuildService) checkWorker() error {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	if _, _, building := s.CurrentBuild(); s.active && !building {
+		return fmt.Errorf("build turn held with no build running (%d waiting)", len(s.queue))
+	}
+	return nil
+}
+
+// releaseStalledTurn ends a turn checkWorker found held with no build
+// running and starts the next build.
+func (s *BuildService) releaseStalledTurn() {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	if _, _, building := s.CurrentBuild(); s.active && !building {
+		s.active = false
+		s.dispatchLocked()
+	}
+}
+
+// dispatchLocked hands the worker to the first waiting app that isn't
+// cooling down. If every waiting app is, it tries again when the first
+// cooldown ends. Callers must hold queueMu.
+func (s *BuildService) dispatchLocked() {
+	if s.active || len(s.queue) == 0 {
+		return
+	}
+	now := time.Now()
+	cooldown := s.cooldown()
+
+	var next time.Time
+	for i, ticket := range s.queue {
+		until := s.lastStart[ticket.appID].Add(cooldown)
+		if cooldown <= 0 || !now.Before(until) {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.active = true
+			s.lastStart[ticket.appID] = now
+			ticket.turn <- nil
+			return
+		}
+		if skip := s.cooldownSkips[ticket.appID]; skip == nil || skip.Until != until {
+			s.cooldownSkips[ticket.appID] = &CooldownSkip{At: now, Until: until}
+		}
+		if next.IsZero() || until.Before(next) {
+			next = until
+		}
+	}
+
+	if s.queueTimer != nil {
+		s.queueTimer.Stop()
+	}
+	s.queueTimer = time.AfterFunc(next.Sub(now), func() {
+		s.queueMu.Lock()
+		defer s.queueMu.Unlock()
+		s.dispatchLocked()
+	})
+}
+
+func (s *BuildService) cooldown() time.Duration {
+	return time.Duration(s.settings.Get().BuildCooldownSeconds) * time.Second
+}
+
+// Queued reports whether appID is waiting in the build queue.
+func (s *BuildService) Queued(appID string) bool {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	for _, ticket := range s.queue {
+		if ticket.appID == appID {
+			return true
+		}
+	}
+	return false
+}
+
+// Dequeue removes appID's waiting build from the queue; the request that
+// queued it ends with ErrBuildDequeued. A build that already started isn't
+// affected.
+func (s *BuildService) Dequeue(appID string) error {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	for i, ticket := range s.queue {
+		if ticket.appID == appID {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			ticket.turn <- ErrBuildDequeued
+			return nil
+		}
+	}
+	return ErrBuildNotQueued
+}
+
+// LastCooldownSkip returns when the queue last passed appID over for
+// cooling down, and until when, or nil if it never has.
+func (s *BuildService) LastCooldownSkip(appID string) *CooldownSkip {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	if skip := s.cooldownSkips[appID]; skip != nil {
+		copied := *skip
+		return &copied
+	}
+	return nil
+}
+
+// QueueStatus returns the running build and the waiting ones, in the
+// order they will be considered.
+func (s *BuildService) QueueStatus() *BuildQueueStatus {
+	building, _, _ := s.CurrentBuild()
+	cooldown := s.cooldown()
+
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	status := &BuildQueueStatus{
+		Building:      building,
+		Waiting:       []QueuedBuild{},
+		Cooldown:      cooldown.String(),
+		CooldownSkips: make(map[string]CooldownSkip, len(s.cooldownSkips)),
+	}
+	now := time.Now()
+	for i, ticket := range s.queue {
+		queued := QueuedBuild{AppID: ticket.appID, Position: i + 1, RequestedAt: ticket.requestedAt}
+		if until := s.lastStart[ticket.appID].Add(cooldown); cooldown > 0 && now.Before(until) {
+			queued.CooldownUntil = &until
+		}
+		status.Waiting = append(status.Waiting, queued)
+	}
+	for appID, skip := range s.cooldownSkips {
+		status.CooldownSkips[appID] = *skip
+	}
+	return status
+}
+
+// handOverRestart leaves restarting appID to whichever pull-and-rebuild
+// took over from one that had stopped it.
+func (m *AppManager) handOverRestart(appID string) {
+	m.restartsMu.Lock()
+	defer m.restartsMu.Unlock()
+	m.restarts[appID] = true
+}
+
+// takeRestart reports, once, whether a superseded pull-and-rebuild handed
+// over restarting appID.
+func (m *AppManager) takeRestart(appID string) bool {
+	m.restartsMu.Lock()
+	defer m.restartsMu.Unlock()
+	restart := m.restarts[appID]
+	delete(m.restarts, appID)
+	return restart
+}
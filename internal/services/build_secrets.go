@@ -0,0 +1,174 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"nas-controller/internal/models"
+)
+
+// ErrBuildSecretNotFound is returned for deleting a build secret the app
+// doesn't have.
+var ErrBuildSecretNotFound = errors.New("build secret not found")
+
+// maxBuildSecretSize is BuildKit's own limit on a secret.
+const maxBuildSecretSize = 500 * 1024
+
+// buildSecretID is what a secret can be called; it is the id= of the
+// Dockerfile's RUN --mount=type=secret.
+var buildSecretID = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ValidateBuildSecret checks a build secret's ID and value.
+func ValidateBuildSecret(id string, value string) error {
+	if !buildSecretID.MatchString(id) {
+		return fmt.Errorf("secret id %q must be 1-64 letters, digits, '_', '.' or '-'", id)
+	}
+	if value == "" {
+		return fmt.Errorf("secret %s has no value", id)
+	}
+	if len(value) > maxBuildSecretSize {
+		return fmt.Errorf("secret %s is larger than %d KB", id, maxBuildSecretSize/1024)
+	}
+	return nil
+}
+
+// BuildSecrets lists the app's build secrets, without their values.
+func (s *BuildService) BuildSecrets(appID string) ([]models.BuildSecret, error) {
+	return s.db.ListBuildSecrets(appID)
+}
+
+// SetBuildSecret adds or replaces one of the app's build secrets, stored
+// encrypted. The value can't be read back through the API.
+func (s *BuildService) SetBuildSecret(appID string, id string, value string) error {
+	if err := ValidateBuildSecret(id, value); err != nil {
+		return err
+	}
+	sealed, err := s.sealSecret([]byte(value))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+	return s.db.SetBuildSecret(appID, id, sealed)
+}
+
+func (s *BuildService) DeleteBuildSecret(appID string, id string) error {
+	deleted, err := s.db.DeleteBuildSecret(appID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrBuildSecretNotFound
+	}
+	return nil
+}
+
+// buildSecrets returns the app's build secrets, decrypted, for a build to
+// mount.
+func (s *BuildService) buildSecrets(appID string) (map[string][]byte, error) {
+	sealed, err := s.db.GetBuildSecretValues(appID)
+	if err != nil || len(sealed) == 0 {
+		return nil, err
+	}
+	secrets := make(map[string][]byte, len(sealed))
+	for id, value := range sealed {
+		plain, err := s.openSecret(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt build secret %s: %v", id, err)
+		}
+		secrets[id] = plain
+	}
+	return secrets, nil
+}
+
+// sealSecret encrypts value with AES-GCM under the controller's secret
+// key, nonce first.
+func (s *BuildService) sealSecret(value []byte) ([]byte, error) {
+	gcm, err := s.secretCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, value, nil), nil
+}
+
+func (s *BuildService) openSecret(sealed []byte) ([]byte, error) {
+	gcm, err := s.secretCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("value is truncated")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// secretCipher is the cipher for the key in build-secrets.key in the data
+// directory, created on first use. The key lives outside the database, so
+// a copy of controller.db alone doesn't give the secrets away; losing the
+// key file loses them.
+func (s *BuildService) secretCipher() (cipher.AEAD, error) {
+	s.secretMu.Lock()
+	defer s.secretMu.Unlock()
+	if s.secretKey == nil {
+		path := filepath.Join(s.dataDir, "build-secrets.key")
+		key, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+			err = writeFileSynced(path, key, 0600)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load build secret key: %v", err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%s is not a 32-byte key", path)
+		}
+		s.secretKey = key
+	}
+	block, err := aes.NewCipher(s.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretRedactor masks build secret values in build output, in case a RUN
+// step prints one.
+type secretRedactor struct {
+	w       io.Writer
+	secrets []string
+}
+
+func newSecretRedactor(w io.Writer, secrets map[string][]byte) io.Writer {
+	if len(secrets) == 0 {
+		return w
+	}
+	r := &secretRedactor{w: w}
+	for _, value := range secrets {
+		r.secrets = append(r.secrets, string(value))
+	}
+	return r
+}
+
+func (r *secretRedactor) Write(p []byte) (int, error) {
+	text := string(p)
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, maskedValue)
+	}
+	if _, err := io.WriteString(r.w, text); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -2,43 +2,106 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"sync"
 	"time"
 
+	"nas-controller/internal/database"
 	"nas-controller/internal/docker"
+	"nas-controller/internal/logs"
 	"nas-controller/internal/models"
 )
 
 type BuildService struct {
-	dockerClient *docker.Client
+	db           *database.DB
+	dockerClient docker.ImageBuilder
+	settings     *SettingsService
+	holder       string
 	dataDir      string
 	logsDir      string
 	building     bool
 	buildMu      sync.Mutex
 	buildCancel  context.CancelFunc
+
+	// The running build, for the summary endpoint. Guarded by buildMu.
+	buildAppID string
+	buildStep  int
+	buildSteps int
+
+	// timeout follows Timeouts.Build. Guarded by buildMu.
+	timeout time.Duration
+
+	// The build queue (see build_queue.go). Guarded by queueMu.
+	queueMu       sync.Mutex
+	queue         []*buildTicket
+	active        bool
+	queueTimer    *time.Timer
+	lastStart     map[string]time.Time
+	cooldownSkips map[string]*CooldownSkip
+
+	// secretKey encrypts build secrets at rest, loaded on first use (see
+	// build_secrets.go). Guarded by secretMu.
+	secretMu  sync.Mutex
+	secretKey []byte
 }
 
+// Build network modes recorded on the app for auditing.
+const (
+	BuildNetworkDefault = "default"
+	BuildNetworkNone    = "none"
+)
+
+// offlineBuildHint replaces the network hint for failed offline builds,
+// where the lack of network access is deliberate.
+const offlineBuildHint = "offline build is enabled for this app, so the build had no network access (vendor dependencies or turn offline build off)"
+
 type BuildProgress struct {
 	AppID    string `json:"appId"`
 	Message  string `json:"message"`
 	Error    string `json:"error"`
+	Category string `json:"category,omitempty"`
+	Hint     string `json:"hint,omitempty"`
 	Complete bool   `json:"complete"`
 	Success  bool   `json:"success"`
+	// Skipped is set on the completion of a build that didn't run because
+	// nothing changed since the last successful one.
+	Skipped bool `json:"skipped,omitempty"`
 }
 
-func NewBuildService(dockerClient *docker.Client, dataDir string) *BuildService {
+func NewBuildService(db *database.DB, dockerClient docker.ImageBuilder, settings *SettingsService, dataDir string) *BuildService {
 	logsDir := filepath.Join(dataDir, "logs")
 	os.MkdirAll(logsDir, 0755)
 
-	return &BuildService{
+	s := &BuildService{
+		db:           db,
 		dockerClient: dockerClient,
+		settings:     settings,
+		holder:       leaseHolderID(),
 		dataDir:      dataDir,
 		logsDir:      logsDir,
+
+		lastStart:     make(map[string]time.Time),
+		cooldownSkips: make(map[string]*CooldownSkip),
 	}
+	settings.Subscribe(s.applySettings)
+	return s
+}
+
+func (s *BuildService) applySettings(settings Settings) {
+	s.buildMu.Lock()
+	defer s.buildMu.Unlock()
+	s.timeout = settings.Timeouts().Build
+
+	// A shorter cooldown may let a waiting build start now
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	s.dispatchLocked()
 }
 
 func (s *BuildService) IsBuilding() bool {
@@ -47,16 +110,71 @@ func (s *BuildService) IsBuilding() bool {
 	return s.building
 }
 
-func (s *BuildService) BuildApp(ctx context.Context, app *models.App, repoPath string, progressChan chan<- BuildProgress) error {
+// CurrentBuild returns the app being built and how far along it is, judged
+// by the "Step N/M" lines of the build output. percent is 0 until the first
+// step is seen.
+func (s *BuildService) CurrentBuild() (appID string, percent int, building bool) {
 	s.buildMu.Lock()
-	if s.building {
-		s.buildMu.Unlock()
-		return fmt.Errorf("another build is in progress")
+	defer s.buildMu.Unlock()
+	if !s.building {
+		return "", 0, false
+	}
+	if s.buildSteps > 0 {
+		percent = (s.buildStep - 1) * 100 / s.buildSteps
+	}
+	return s.buildAppID, percent, true
+}
+
+func (s *BuildService) setBuildStep(step, steps int) {
+	s.buildMu.Lock()
+	defer s.buildMu.Unlock()
+	s.buildStep, s.buildSteps = step, steps
+}
+
+// BuildApp builds the app's image, once its turn in the build queue comes.
+// It returns ErrBuildSuperseded without building if a newer request for the
+// same app takes its place while it waits, and ErrBuildDequeued if it is
+// removed from the queue. A build that would repeat the last successful
+// one isn't queued at all: it is recorded as skipped and ErrBuildUnchanged
+// returned, unless ctx forces it.
+func (s *BuildService) BuildApp(ctx context.Context, app *models.App, repoPath string, progressChan chan<- BuildProgress) error {
+	inputsHash := s.buildInputsHash(app)
+	if last := s.unchangedSince(ctx, app, inputsHash); last != nil {
+		s.recordSkippedBuild(ctx, app, last, inputsHash)
+		logf(ctx, "Skipped build of %s (triggered by %s): no changes since build #%d", app.Slug, Initiator(ctx), last.ID)
+		if progressChan != nil {
+			progressChan <- BuildProgress{
+				AppID:    app.ID,
+				Message:  fmt.Sprintf("Already up to date: commit %s and build inputs unchanged since build #%d\n", app.LastCommit, last.ID),
+				Complete: true,
+				Success:  true,
+				Skipped:  true,
+			}
+		}
+		return ErrBuildUnchanged
+	}
+
+	if buildingID, _, building := s.CurrentBuild(); (building || s.Queued(app.ID)) && progressChan != nil {
+		if building && buildingID != app.ID {
+			progressChan <- BuildProgress{AppID: app.ID, Message: "Waiting for another build to finish\n"}
+		} else {
+			progressChan <- BuildProgress{AppID: app.ID, Message: "Queued\n"}
+		}
+	}
+	if err := s.waitTurn(ctx, app.ID); err != nil {
+		return err
 	}
+	defer s.finishTurn()
+
+	s.buildMu.Lock()
 	s.building = true
+	s.buildAppID, s.buildStep, s.buildSteps = app.ID, 0, 0
 
-	// Create cancelable context
-	buildCtx, cancel := context.WithCancel(ctx)
+	// Create cancelable context, with the timeout in effect when the build
+	// starts
+	timeout := s.timeout
+	buildCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 	s.buildCancel = cancel
 	s.buildMu.Unlock()
 
@@ -64,10 +182,20 @@ func (s *BuildService) BuildApp(ctx context.Context, app *models.App, repoPath s
 		s.buildMu.Lock()
 		s.building = false
 		s.buildCancel = nil
+		s.buildAppID = ""
 		s.buildMu.Unlock()
 	}()
 
-	// Create log file
+	// The DB lease outlives this process, so a crash mid-build is noticed
+	// at the next startup.
+	releaseLease, err := s.acquireLease(app.ID, cancel)
+	if err != nil {
+		return err
+	}
+	defer releaseLease()
+
+	// Create log file, before the build record so a failure here can't
+	// leave a record that never finishes
 	logPath := filepath.Join(s.logsDir, fmt.Sprintf("build-%s.log", app.ID))
 	logFile, err := os.Create(logPath)
 	if err != nil {
@@ -75,14 +203,63 @@ func (s *BuildService) BuildApp(ctx context.Context, app *models.App, repoPath s
 	}
 	defer logFile.Close()
 
+	startTime := time.Now()
+	app.LastBuild = &startTime
+
+	correlationID := CorrelationID(ctx)
+	if correlationID == "" {
+		correlationID = NewCorrelationID()
+		buildCtx = WithCorrelationID(buildCtx, correlationID)
+	}
+	app.LastBuildCorrelationID = correlationID
+	build := s.startBuildRecord(buildCtx, app, correlationID, startTime)
+	if build != nil {
+		build.InputsHash = inputsHash
+	}
+
+	// The build's own copy in its history, which the next build doesn't
+	// overwrite
+	var logOut io.Writer = logFile
+	if recordLog := s.openBuildRecordLog(buildCtx, build); recordLog != nil {
+		defer recordLog.Close()
+		logOut = io.MultiWriter(logFile, recordLog)
+	}
+
+	// The cap only applies to the file; viewers still get every line.
+	logCap := newCappedLogWriter(logOut, s.maxLogBytes())
+	defer func() {
+		logCap.flush()
+		app.LastBuildLogTruncated = logCap.truncated
+	}()
+
 	// Create multi-writer for both log file and progress channel
 	writer := &buildLogWriter{
 		appID:        app.ID,
-		logFile:      logFile,
+		logFile:      logCap,
 		progressChan: progressChan,
+		onStep:       s.setBuildStep,
 	}
 
-	startTime := time.Now()
+	// Straight into the log file too, so a pasted build log carries them.
+	fmt.Fprintf(writer, "Correlation ID: %s\n", correlationID)
+	fmt.Fprintf(writer, "Triggered by: %s\n", Initiator(ctx))
+	options := BuildOptions(ctx)
+	if app.ComposeFile == "" {
+		options.Target = app.BuildTarget
+		options.Platform = app.Platform
+	}
+	// A build with secrets needs them all; one that can't be decrypted
+	// fails the build below rather than being left out.
+	var secretsErr error
+	options.Secrets, secretsErr = s.buildSecrets(app.ID)
+	if len(options.Secrets) > 0 && build != nil {
+		build.Builder = docker.BuilderBuildKit
+	}
+	// Output goes through the redactor from here on, in case a step prints
+	// a secret.
+	output := newSecretRedactor(writer, options.Secrets)
+	fmt.Fprintf(writer, "Build options: %s\n", describeBuildOptions(options))
+	logf(buildCtx, "Building %s (triggered by %s)", app.Slug, Initiator(ctx))
 
 	sendProgress := func(msg string) {
 		if progressChan != nil {
@@ -95,35 +272,84 @@ func (s *BuildService) BuildApp(ctx context.Context, app *models.App, repoPath s
 
 	sendProgress(fmt.Sprintf("Starting build for %s\n", app.Name))
 	sendProgress(fmt.Sprintf("Context: %s\n", repoPath))
-	sendProgress(fmt.Sprintf("Dockerfile: %s\n", app.DockerfilePath))
-	sendProgress(fmt.Sprintf("Image: %s\n\n", app.ImageName))
+	if app.ComposeFile != "" {
+		sendProgress(fmt.Sprintf("Compose file: %s\n", app.ComposeFile))
+	} else {
+		sendProgress(fmt.Sprintf("Dockerfile: %s\n", app.DockerfilePath))
+		if app.BuildTarget != "" {
+			sendProgress(fmt.Sprintf("Target: %s\n", app.BuildTarget))
+		}
+	}
+	sendProgress(fmt.Sprintf("Image: %s\n", app.ImageName))
+	sendProgress(fmt.Sprintf("Network: %s\n", BuildNetworkMode(app)))
+	// Docker predefines the proxy build args, so they reach RUN steps
+	// without an ARG line and stay out of the image history.
+	settings := s.settings.Get()
+	proxy := settings.HTTPSProxy
+	if proxy == "" {
+		proxy = settings.HTTPProxy
+	}
+	if proxy != "" {
+		sendProgress(fmt.Sprintf("Proxy: %s\n", MaskProxyURL(proxy)))
+	}
+	sendProgress("\n")
 
 	// Build the image
-	err = s.dockerClient.BuildImage(
-		buildCtx,
-		repoPath,
-		app.DockerfilePath,
-		app.ImageName,
-		app.BuildArgs,
-		writer,
-	)
+	if secretsErr != nil {
+		err = secretsErr
+	} else if app.ComposeFile != "" {
+		err = s.buildCompose(buildCtx, app, repoPath, settings.ProxyEnv(), options, output)
+	} else if err = ValidateBuildTarget(repoPath, app.DockerfilePath, app.BuildTarget); err == nil {
+		options.CacheFrom = s.keepPrevious(buildCtx, app.ImageName, writer)
+		err = s.dockerClient.BuildImage(
+			buildCtx,
+			repoPath,
+			app.DockerfilePath,
+			app.ImageName,
+			withInheritedEnv(app.BuildArgs, settings.ProxyEnv()),
+			BuildNetworkMode(app),
+			options,
+			output,
+		)
+	}
 
 	duration := time.Since(startTime)
 
 	if err != nil {
-		errMsg := fmt.Sprintf("\n\nBuild failed: %v\n", err)
+		if errors.Is(buildCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("build timed out after %s", timeout)
+		}
+		logCap.flush()
+		tail, _ := logs.TailBytes(logPath, 16*1024)
+		category, hint := ClassifyBuildFailure(err.Error(), string(tail))
+		if category == BuildFailureNetwork && app.OfflineBuild {
+			hint = offlineBuildHint
+		}
+		buildErr := &BuildError{Err: err, Category: category, Hint: hint}
+
+		errMsg := fmt.Sprintf("\n\nBuild failed: %v\n", buildErr)
 		writer.Write([]byte(errMsg))
+		logf(buildCtx, "Build of %s (triggered by %s) failed: %v", app.Slug, Initiator(ctx), buildErr)
+		s.finishBuildRecord(ctx, build, app, repoPath, false, nil)
 
 		if progressChan != nil {
 			progressChan <- BuildProgress{
 				AppID:    app.ID,
-				Error:    err.Error(),
+				Error:    buildErr.Error(),
+				Category: category,
+				Hint:     hint,
 				Complete: true,
 				Success:  false,
 			}
 		}
-		return err
+		return buildErr
+	}
+
+	tag := s.tagCommit(buildCtx, app, build, writer)
+	if build != nil {
+		build.ImageTag = tag
 	}
+	s.finishBuildRecord(ctx, build, app, repoPath, true, writer)
 
 	successMsg := fmt.Sprintf("\n\nBuild completed successfully in %s\n", duration.Round(time.Second))
 	writer.Write([]byte(successMsg))
@@ -140,6 +366,22 @@ func (s *BuildService) BuildApp(ctx context.Context, app *models.App, repoPath s
 	return nil
 }
 
+func (s *BuildService) maxLogBytes() int64 {
+	mb := s.settings.Get().MaxBuildLogMB
+	if mb <= 0 {
+		mb = DefaultMaxBuildLogMB
+	}
+	return int64(mb) * 1024 * 1024
+}
+
+// BuildNetworkMode returns the network mode builds of app run with.
+func BuildNetworkMode(app *models.App) string {
+	if app.OfflineBuild {
+		return BuildNetworkNone
+	}
+	return BuildNetworkDefault
+}
+
 func (s *BuildService) CancelBuild() {
 	s.buildMu.Lock()
 	defer s.buildMu.Unlock()
@@ -161,11 +403,27 @@ func (s *BuildService) GetBuildLog(appID string) (string, error) {
 	return string(data), nil
 }
 
+// GetBuildLogTail returns the last lines of the app's build log.
+func (s *BuildService) GetBuildLogTail(appID string, lines int) (string, error) {
+	data, err := logs.TailLines(filepath.Join(s.logsDir, fmt.Sprintf("build-%s.log", appID)), lines)
+	return string(data), err
+}
+
 func (s *BuildService) ClearBuildLog(appID string) error {
 	logPath := filepath.Join(s.logsDir, fmt.Sprintf("build-%s.log", appID))
 	return os.Remove(logPath)
 }
 
+// BuildLogSize returns the size of the app's build logs, the latest and
+// its history's, 0 if it has none.
+func (s *BuildService) BuildLogSize(appID string) int64 {
+	size := dirSize(s.historyDir(appID))
+	if info, err := os.Stat(filepath.Join(s.logsDir, fmt.Sprintf("build-%s.log", appID))); err == nil {
+		size += info.Size()
+	}
+	return size
+}
+
 func (s *BuildService) GetLogsSize() (int64, error) {
 	var size int64
 	err := filepath.Walk(s.logsDir, func(path string, info os.FileInfo, err error) error {
@@ -186,8 +444,9 @@ func (s *BuildService) ClearAllLogs() error {
 		return err
 	}
 
+	// Build history logs go too; their records stay, without a log
 	for _, entry := range entries {
-		os.Remove(filepath.Join(s.logsDir, entry.Name()))
+		os.RemoveAll(filepath.Join(s.logsDir, entry.Name()))
 	}
 	return nil
 }
@@ -196,10 +455,20 @@ type buildLogWriter struct {
 	appID        string
 	logFile      io.Writer
 	progressChan chan<- BuildProgress
+	onStep       func(step, steps int)
 }
 
+var buildStepPattern = regexp.MustCompile(`(?m)^Step (\d+)/(\d+) :`)
+
 func (w *buildLogWriter) Write(p []byte) (n int, err error) {
 	n, err = w.logFile.Write(p)
+	if w.onStep != nil {
+		if m := buildStepPattern.FindSubmatch(p); m != nil {
+			step, _ := strconv.Atoi(string(m[1]))
+			steps, _ := strconv.Atoi(string(m[2]))
+			w.onStep(step, steps)
+		}
+	}
 	if w.progressChan != nil && len(p) > 0 {
 		w.progressChan <- BuildProgress{
 			AppID:   w.appID,
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// ErrBuildUnchanged is returned instead of building when the app's commit
+// and build inputs are those of its last successful build, whose image it
+// still has.
+var ErrBuildUnchanged = errors.New("already up to date: no changes since the last successful build")
+
+// buildInputsHash fingerprints what goes into a build of app besides the
+// commit: where its Dockerfile, context and compose file are, its build
+// args, the builder options that come from the app, and its build
+// secrets. Like hashBuildArgs it keeps no values; secrets are hashed
+// sealed, as stored.
+func (s *BuildService) buildInputsHash(app *models.App) string {
+	sealed, _ := s.db.GetBuildSecretValues(app.ID)
+	ids := make([]string, 0, len(sealed))
+	for id := range sealed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "dockerfile=%s\ncontext=%s\ncompose=%s\ntarget=%s\nplatform=%s\nnetwork=%s\nargs=%s\n",
+		app.DockerfilePath, app.BuildContext, app.ComposeFile, app.BuildTarget, app.Platform,
+		BuildNetworkMode(app), hashBuildArgs(app.BuildArgs))
+	for _, id := range ids {
+		fmt.Fprintf(h, "secret %s=%x\n", id, sha256.Sum256(sealed[id]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// unchangedSince returns the app's last successful build if building now
+// would repeat it: same commit, same inputs, and its image still there to
+// run. It returns nil when the build should go ahead, always so when ctx
+// forces it or asks for --no-cache or --pull, for uploads and local
+// paths, whose source changes without a commit, and for an app rolled
+// back to an older image.
+func (s *BuildService) unchangedSince(ctx context.Context, app *models.App, inputsHash string) *models.Build {
+	options := BuildOptions(ctx)
+	if ForceBuild(ctx) || options.NoCache || options.PullBaseImage {
+		return nil
+	}
+	if app.LastCommit == "" || app.SourceType == models.SourceTypeUpload || IsLocalPath(app.RepoURL) || app.RolledBackTo != "" {
+		return nil
+	}
+	last, err := s.db.GetPreviousSuccessfulBuild(app.ID, math.MaxInt64)
+	if err != nil || last.Commit != app.LastCommit || last.InputsHash != inputsHash {
+		return nil
+	}
+	if _, err := s.dockerClient.InspectImage(ctx, app.ImageName); err != nil {
+		return nil
+	}
+	return last
+}
+
+// recordSkippedBuild records a build that wasn't run because it would
+// have repeated last, so the history shows every request, and prunes the
+// app's history down to its limit.
+func (s *BuildService) recordSkippedBuild(ctx context.Context, app *models.App, last *models.Build, inputsHash string) {
+	now := time.Now()
+	correlationID := CorrelationID(ctx)
+	if correlationID == "" {
+		correlationID = NewCorrelationID()
+	}
+	initiator := Initiator(ctx)
+	build := &models.Build{
+		AppID:         app.ID,
+		Commit:        app.LastCommit,
+		CorrelationID: correlationID,
+		Initiator:     initiator.Kind,
+		TriggeredBy:   initiator.ID,
+		StartedAt:     now,
+		FinishedAt:    &now,
+		Duration:      "0s",
+		BaseImages:    map[string]string{},
+		BuildArgsHash: hashBuildArgs(app.BuildArgs),
+		InputsHash:    inputsHash,
+		Skipped:       true,
+		SkipReason:    fmt.Sprintf("no changes since build #%d", last.ID),
+	}
+	if err := s.db.CreateBuild(build); err != nil {
+		logf(ctx, "Failed to record skipped build of %s: %v", app.Slug, err)
+		return
+	}
+	if err := s.db.FinishBuild(build); err != nil {
+		logf(ctx, "Failed to record skipped build of %s: %v", app.Slug, err)
+	}
+	s.pruneBuildHistory(ctx, app.ID)
+}
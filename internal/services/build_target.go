@@ -0,0 +1,76 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nas-controller/internal/models"
+)
+
+// DockerfileStages lists the named stages of the Dockerfile at path, the
+// names of its FROM ... AS <name> lines, in order. Unnamed stages can only
+// be targeted by index, which the controller doesn't offer.
+func DockerfileStages(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var stages []string
+	var line string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		// Instructions continue onto the next line after a trailing
+		// backslash.
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		line += text
+		fields := strings.Fields(line)
+		line = ""
+		if len(fields) >= 4 && strings.EqualFold(fields[0], "FROM") && strings.EqualFold(fields[len(fields)-2], "AS") {
+			stages = append(stages, fields[len(fields)-1])
+		}
+	}
+	return stages, scanner.Err()
+}
+
+// readBuildTargets fills in the stages of result's Dockerfile, if it has
+// one, for the UI to offer as build targets.
+func readBuildTargets(result *models.CloneResult, dir string) {
+	if !result.HasDockerfile {
+		return
+	}
+	result.BuildTargets, _ = DockerfileStages(filepath.Join(dir, result.DockerfilePath))
+}
+
+// ValidateBuildTarget checks that target is a stage of the Dockerfile at
+// dockerfilePath in dir, since Docker's own error for a missing one names
+// neither. An unreadable Dockerfile is left for the build to report.
+func ValidateBuildTarget(dir string, dockerfilePath string, target string) error {
+	if target == "" {
+		return nil
+	}
+	stages, err := DockerfileStages(filepath.Join(dir, dockerfilePath))
+	if err != nil {
+		return nil
+	}
+	for _, stage := range stages {
+		if strings.EqualFold(stage, target) {
+			return nil
+		}
+	}
+	if len(stages) == 0 {
+		return fmt.Errorf("buildTarget %q: %s has no named stages", target, dockerfilePath)
+	}
+	return fmt.Errorf("buildTarget %q is not a stage of %s; its stages are %s", target, dockerfilePath, strings.Join(stages, ", "))
+}
@@ -0,0 +1,655 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// composeFileNames are the compose files looked for at the root of a repo,
+// in Compose's order of preference.
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// composeServiceNamePattern is what a service name may be. Its containers
+// are named <container>-<service>, so a name that is only digits, which
+// would look like a replica, is refused separately.
+var composeServiceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// FindComposeFile returns the compose file at the root of dir, relative to
+// it, or "" if there is none.
+func FindComposeFile(dir string) string {
+	for _, name := range composeFileNames {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			return name
+		}
+	}
+	return ""
+}
+
+// readComposeServices reports composeFile, if any, in result with its
+// services, suggesting the primary service's port. A compose file that
+// can't be run only fails a repo without a Dockerfile to fall back on.
+func readComposeServices(result *models.CloneResult, dir string, composeFile string) error {
+	if composeFile == "" {
+		return nil
+	}
+	project, err := LoadCompose(filepath.Join(dir, composeFile), nil)
+	if err != nil {
+		if result.HasDockerfile {
+			return nil
+		}
+		return fmt.Errorf("%s: %v", composeFile, err)
+	}
+	result.ComposeFile = composeFile
+	result.ComposeServices = project.ServiceNames()
+	if ports := project.Services[project.PrimaryService()].Ports; len(ports) > 0 {
+		result.SuggestedPort, _ = composeContainerPort(ports[0])
+	}
+	return nil
+}
+
+// ComposeProject is the part of a compose file the controller runs:
+// services with their image or build, ports, environment, volumes and
+// depends_on. Everything else in the file is ignored.
+type ComposeProject struct {
+	Services map[string]*ComposeService `yaml:"services"`
+}
+
+type ComposeService struct {
+	Image       string           `yaml:"image"`
+	Build       *ComposeBuild    `yaml:"build"`
+	Ports       composePorts     `yaml:"ports"`
+	Environment composeEnv       `yaml:"environment"`
+	Volumes     []string         `yaml:"volumes"`
+	DependsOn   composeDependsOn `yaml:"depends_on"`
+}
+
+// ComposeBuild is a service's build section, relative to the compose
+// file's directory.
+type ComposeBuild struct {
+	Context    string     `yaml:"context"`
+	Dockerfile string     `yaml:"dockerfile"`
+	Args       composeEnv `yaml:"args"`
+}
+
+// UnmarshalYAML accepts the short form, where build is just the context.
+func (b *ComposeBuild) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		b.Context = node.Value
+		return nil
+	}
+	type plain ComposeBuild
+	return node.Decode((*plain)(b))
+}
+
+// composeEnv is an environment or build args section, which compose allows
+// as a map or as a list of KEY=VALUE.
+type composeEnv map[string]string
+
+func (e *composeEnv) UnmarshalYAML(node *yaml.Node) error {
+	env := composeEnv{}
+	switch node.Kind {
+	case yaml.MappingNode:
+		var values map[string]*string
+		if err := node.Decode(&values); err != nil {
+			return err
+		}
+		for k, v := range values {
+			if v != nil {
+				env[k] = *v
+			} else {
+				env[k] = ""
+			}
+		}
+	case yaml.SequenceNode:
+		var entries []string
+		if err := node.Decode(&entries); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			k, v, _ := strings.Cut(entry, "=")
+			env[k] = v
+		}
+	default:
+		return fmt.Errorf("line %d: expected a map or a list of KEY=VALUE", node.Line)
+	}
+	*e = env
+	return nil
+}
+
+// composePorts is a ports section. Entries in the long syntax are turned
+// into the short one.
+type composePorts []string
+
+func (p *composePorts) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: ports must be a list", node.Line)
+	}
+	ports := composePorts{}
+	for _, item := range node.Content {
+		if item.Kind == yaml.ScalarNode {
+			ports = append(ports, item.Value)
+			continue
+		}
+		var long struct {
+			Target    int    `yaml:"target"`
+			Published string `yaml:"published"`
+		}
+		if err := item.Decode(&long); err != nil {
+			return err
+		}
+		entry := strconv.Itoa(long.Target)
+		if long.Published != "" {
+			entry = long.Published + ":" + entry
+		}
+		ports = append(ports, entry)
+	}
+	*p = ports
+	return nil
+}
+
+// composeDependsOn is depends_on, a list of services or a map keyed by
+// them. Conditions are not waited for; dependencies are only started first.
+type composeDependsOn []string
+
+func (d *composeDependsOn) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		*d = names
+	case yaml.MappingNode:
+		names := make([]string, 0, len(node.Content)/2)
+		for i := 0; i < len(node.Content); i += 2 {
+			names = append(names, node.Content[i].Value)
+		}
+		*d = names
+	default:
+		return fmt.Errorf("line %d: depends_on must be a list or a map", node.Line)
+	}
+	return nil
+}
+
+// LoadCompose reads and checks the compose file at path. ${NAME},
+// ${NAME:-default} and $NAME are filled in from env first.
+func LoadCompose(path string, env map[string]string) (*ComposeProject, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %v", err)
+	}
+	return ParseCompose(data, env)
+}
+
+// ParseCompose is LoadCompose for the file's contents.
+func ParseCompose(data []byte, env map[string]string) (*ComposeProject, error) {
+	expanded := os.Expand(string(data), func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if key, fallback, ok := strings.Cut(name, ":-"); ok {
+			if v := env[key]; v != "" {
+				return v
+			}
+			return fallback
+		}
+		if key, fallback, ok := strings.Cut(name, "-"); ok {
+			if v, set := env[key]; set {
+				return v
+			}
+			return fallback
+		}
+		return env[name]
+	})
+
+	var project ComposeProject
+	if err := yaml.Unmarshal([]byte(expanded), &project); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %v", err)
+	}
+	if len(project.Services) == 0 {
+		return nil, fmt.Errorf("compose file defines no services")
+	}
+	for name, svc := range project.Services {
+		if svc == nil {
+			return nil, fmt.Errorf("service %s: empty definition", name)
+		}
+		if !composeServiceNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("service %q: names may only contain lowercase letters, digits, '_' and '-'", name)
+		}
+		if _, err := strconv.Atoi(name); err == nil {
+			return nil, fmt.Errorf("service %q: names can't be only digits", name)
+		}
+		if svc.Image == "" && svc.Build == nil {
+			return nil, fmt.Errorf("service %s: needs an image or a build section", name)
+		}
+		for _, dep := range svc.DependsOn {
+			if project.Services[dep] == nil {
+				return nil, fmt.Errorf("service %s depends on unknown service %s", name, dep)
+			}
+		}
+		for _, port := range svc.Ports {
+			if _, err := composeContainerPort(port); err != nil {
+				return nil, fmt.Errorf("service %s: %v", name, err)
+			}
+		}
+	}
+	if _, err := project.Order(); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// ServiceNames returns the services sorted by name.
+func (p *ComposeProject) ServiceNames() []string {
+	names := make([]string, 0, len(p.Services))
+	for name := range p.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Order returns the services in the order they are started: every service
+// after the ones it depends on, otherwise by name.
+func (p *ComposeProject) Order() ([]string, error) {
+	var order []string
+	state := map[string]int{} // 1 visiting, 2 done
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("services depend on each other in a cycle: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		deps := append([]string{}, p.Services[name].DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range p.ServiceNames() {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// PrimaryService picks the service published on the app's port: the first
+// by name that has ports, preferring one the repo builds, or failing that
+// the first one it builds.
+func (p *ComposeProject) PrimaryService() string {
+	var withPorts, built string
+	for _, name := range p.ServiceNames() {
+		svc := p.Services[name]
+		if len(svc.Ports) > 0 && svc.Build != nil {
+			return name
+		}
+		if len(svc.Ports) > 0 && withPorts == "" {
+			withPorts = name
+		}
+		if svc.Build != nil && built == "" {
+			built = name
+		}
+	}
+	if withPorts != "" {
+		return withPorts
+	}
+	if built != "" {
+		return built
+	}
+	return p.ServiceNames()[0]
+}
+
+// validateComposeNetworking checks the network settings of a compose app,
+// whose services share a network of their own and run once each.
+func validateComposeNetworking(mode, network string, replicas int) error {
+	if mode != models.NetworkModeBridge || network != "" {
+		return fmt.Errorf("compose apps run on a network of their own; host mode and custom networks aren't supported")
+	}
+	if replicas > 1 {
+		return fmt.Errorf("compose apps support a single replica only")
+	}
+	return nil
+}
+
+// composeContainerPort returns the container side of a ports entry such as
+// "8080", "80:8080" or "127.0.0.1:80:8080/tcp". Ranges aren't supported.
+func composeContainerPort(entry string) (int, error) {
+	spec, _, _ := strings.Cut(entry, "/")
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		spec = spec[i+1:]
+	}
+	port, err := strconv.Atoi(spec)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("unsupported port %q", entry)
+	}
+	return port, nil
+}
+
+// composeImage is the image a service runs: the one built for it, which for
+// the primary service is the app's own image, or the one it names.
+func composeImage(app *models.App, name string, svc *ComposeService) string {
+	if svc.Build == nil {
+		return svc.Image
+	}
+	if name == app.ComposeService {
+		return app.ImageName
+	}
+	return fmt.Sprintf("%s-%s:latest", app.Slug, name)
+}
+
+// composeContainerName names a service's container. The primary service
+// has the app's container name.
+func composeContainerName(app *models.App, name string) string {
+	if name == app.ComposeService {
+		return app.ContainerName
+	}
+	return app.ContainerName + "-" + name
+}
+
+// composeNetwork is the network an app's services share, where each is
+// reachable by its service name, as Compose's <project>_default is.
+func composeNetwork(app *models.App) string {
+	return app.Slug + "_default"
+}
+
+// composeVolumes turns a service's volumes into the controller's form:
+// named volumes are prefixed with the app's slug, as Compose prefixes them
+// with the project, and paths relative to the compose file go under the
+// app's appdata directory, created if missing.
+func composeVolumes(app *models.App, appdataDir string, volumes []string) ([]string, error) {
+	out := make([]string, 0, len(volumes))
+	for _, entry := range volumes {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("volume %q: anonymous volumes aren't supported; give it a name", entry)
+		}
+		source := parts[0]
+		switch {
+		case strings.HasPrefix(source, "."):
+			rel := filepath.Clean(source)
+			if rel == ".." || strings.HasPrefix(rel, "../") {
+				return nil, fmt.Errorf("volume %q: relative paths must stay inside the app's directory", entry)
+			}
+			parts[0] = filepath.Join(appdataDir, app.Slug, rel)
+			if len(parts) == 3 {
+				parts[2] += "," + createOption
+			} else {
+				parts = append(parts, createOption)
+			}
+		case strings.HasPrefix(source, "~"):
+			return nil, fmt.Errorf("volume %q: use an absolute host path", entry)
+		case !strings.HasPrefix(source, "/"):
+			parts[0] = app.Slug + "_" + source
+		}
+		out = append(out, strings.Join(parts, ":"))
+	}
+	return out, nil
+}
+
+// loadCompose reads the app's compose file, filled in from the app's env.
+func (m *AppManager) loadCompose(app *models.App) (*ComposeProject, error) {
+	return LoadCompose(filepath.Join(m.repoPath(app), app.ComposeFile), app.Env)
+}
+
+// composeServiceSpec is the container spec of one of the app's compose
+// services. serviceVolumes are the service's own, volumes the app's; both
+// already prepared. shared is the app's place on the shared network, which
+// only the primary service joins.
+func (m *AppManager) composeServiceSpec(app *models.App, name string, svc *ComposeService, serviceVolumes []string, volumes []string, shared docker.SharedNetwork) docker.ContainerSpec {
+	networks := []docker.SharedNetwork{{Name: composeNetwork(app), Aliases: []string{name}}}
+	if name != app.ComposeService {
+		return docker.ContainerSpec{
+			Name:          composeContainerName(app, name),
+			Image:         composeImage(app, name, svc),
+			Env:           svc.Environment,
+			RestartPolicy: app.RestartPolicy,
+			MaxRetries:    app.MaxRetries,
+			Volumes:       serviceVolumes,
+			NetworkMode:   models.NetworkModeBridge,
+			Network:       composeNetwork(app),
+			Networks:      networks,
+			Labels:        map[string]string{docker.AppIDLabel: app.ID, docker.ServiceLabel: name},
+			LogConfig:     m.logConfig(app),
+		}
+	}
+
+	spec := m.containerSpec(app, append(serviceVolumes, volumes...), shared)
+	spec.Name = composeContainerName(app, name)
+	spec.Image = composeImage(app, name, svc)
+	spec.Env = copyStringMap(svc.Environment)
+	for k, v := range m.containerEnv(app) {
+		spec.Env[k] = v
+	}
+	spec.NetworkMode = models.NetworkModeBridge
+	spec.Network = composeNetwork(app)
+	spec.IPAddress = ""
+	spec.Networks = append(networks, shared)
+	return spec
+}
+
+// startCompose creates and starts the app's services in dependency order
+// on their own network. The primary service is published on the app's port
+// and gets the app's env, volumes and container settings on top of the
+// compose file's; the others run as the file describes them. volumes are
+// the app's own, already prepared.
+func (m *AppManager) startCompose(ctx context.Context, app *models.App, volumes []string) error {
+	fail := func(err error) error {
+		m.setStatus(app, models.StatusError)
+		app.LastError = err.Error()
+		m.db.UpdateApp(app)
+		return err
+	}
+
+	project, err := m.loadCompose(app)
+	if err != nil {
+		return fail(err)
+	}
+	if project.Services[app.ComposeService] == nil {
+		return fail(fmt.Errorf("compose file no longer defines service %s", app.ComposeService))
+	}
+	order, _ := project.Order()
+
+	network := composeNetwork(app)
+	if err := m.dockerClient.EnsureNetwork(ctx, network); err != nil {
+		return fail(err)
+	}
+	appdataDir := m.settings.Get().AppdataDir
+	if appdataDir == "" {
+		appdataDir = DefaultAppdataDir
+	}
+
+	shared := m.sharedNetwork(ctx, app)
+
+	m.removeComposeContainers(ctx, app)
+	app.ComposeContainers = map[string]string{}
+	app.Health = models.HealthNone
+	m.setStatus(app, models.StatusStarting)
+	m.db.UpdateApp(app)
+
+	for _, name := range order {
+		svc := project.Services[name]
+		serviceVolumes, err := composeVolumes(app, appdataDir, svc.Volumes)
+		if err == nil {
+			serviceVolumes, err = m.prepareVolumes(serviceVolumes)
+		}
+		if err != nil {
+			return fail(fmt.Errorf("service %s: %v", name, err))
+		}
+
+		containerName := composeContainerName(app, name)
+		if existing, _ := m.dockerClient.GetContainerByName(ctx, containerName); existing != nil && foreignContainer(app, existing) {
+			return fail(fmt.Errorf("service %s: %w", name, docker.NameConflict(containerName)))
+		}
+		m.removeContainerByName(ctx, app, containerName)
+
+		containerID, err := m.dockerClient.CreateContainer(ctx, m.composeServiceSpec(app, name, svc, serviceVolumes, volumes, shared))
+		if err != nil {
+			if docker.IsNoSuchImage(err) && name == app.ComposeService {
+				return fmt.Errorf("%w: %s", ErrImageMissing, app.ImageName)
+			}
+			return fail(fmt.Errorf("service %s: failed to create container: %w", name, err))
+		}
+		app.ComposeContainers[name] = containerID
+		if name == app.ComposeService {
+			app.ContainerID = containerID
+		}
+		m.db.UpdateApp(app)
+
+		if err := m.dockerClient.StartContainer(ctx, containerID); err != nil {
+			return fail(fmt.Errorf("service %s: failed to start container: %w", name, err))
+		}
+	}
+
+	m.setStatus(app, models.StatusRunning)
+	app.LastError = ""
+	app.RebuildRequired = false
+	app.RestartRequired = false
+	m.db.UpdateApp(app)
+	return nil
+}
+
+// removeComposeContainers stops and removes the app's services other than
+// the primary one, which goes with the app's container. Stopping follows
+// the reverse of the start order, as far as it is known.
+func (m *AppManager) removeComposeContainers(ctx context.Context, app *models.App) {
+	if app.ComposeFile == "" {
+		return
+	}
+	names := make([]string, 0, len(app.ComposeContainers))
+	if project, err := m.loadCompose(app); err == nil {
+		names, _ = project.Order()
+	} else {
+		for name := range app.ComposeContainers {
+			names = append(names, name)
+		}
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+		if name == app.ComposeService {
+			continue
+		}
+		if id := app.ComposeContainers[name]; id != "" {
+			m.dockerClient.StopContainer(ctx, id)
+			m.dockerClient.RemoveContainer(ctx, id, true)
+		}
+		if existing, _ := m.dockerClient.GetContainerByName(ctx, composeContainerName(app, name)); existing != nil && !foreignContainer(app, existing) {
+			m.dockerClient.StopContainer(ctx, existing.ID)
+			m.dockerClient.RemoveContainer(ctx, existing.ID, true)
+		}
+	}
+	app.ComposeContainers = map[string]string{}
+}
+
+// removeComposeResources removes what only an app's services use: the
+// images built for them and their network. Named volumes stay, like the
+// app's other data.
+func (m *AppManager) removeComposeResources(ctx context.Context, app *models.App) error {
+	if project, err := m.loadCompose(app); err == nil {
+		for name, svc := range project.Services {
+			if svc.Build == nil || name == app.ComposeService {
+				continue
+			}
+			if err := m.dockerClient.RemoveImage(ctx, composeImage(app, name, svc)); err != nil && !docker.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	if _, err := m.dockerClient.RemoveNetworkIfUnused(ctx, composeNetwork(app)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ServiceContainerID returns the container of one of a compose app's
+// services; "" means the app's own container.
+func (m *AppManager) ServiceContainerID(app *models.App, service string) (string, error) {
+	if service == "" || service == app.ComposeService {
+		return app.ContainerID, nil
+	}
+	if app.ComposeFile == "" {
+		return "", fmt.Errorf("app %s doesn't run services", app.Name)
+	}
+	id, ok := app.ComposeContainers[service]
+	if !ok {
+		return "", fmt.Errorf("service %s is not running", service)
+	}
+	return id, nil
+}
+
+// buildCompose builds the images of the app's services that have a build
+// section, and pulls the others' so starting needs nothing from the
+// network. A pull that fails is only a warning if the image is already
+// there. inherited (the proxy settings) go to every build beneath the
+// file's args; the app's own build args only go to the primary service.
+func (s *BuildService) buildCompose(ctx context.Context, app *models.App, repoPath string, inherited map[string]string, options docker.BuildOptions, writer io.Writer) error {
+	composePath := filepath.Join(repoPath, app.ComposeFile)
+	project, err := LoadCompose(composePath, app.Env)
+	if err != nil {
+		return err
+	}
+	order, _ := project.Order()
+	dir := filepath.Dir(composePath)
+
+	for _, name := range order {
+		svc := project.Services[name]
+		image := composeImage(app, name, svc)
+		if svc.Build == nil {
+			fmt.Fprintf(writer, "\n==> Pulling %s for service %s\n", image, name)
+			err := s.dockerClient.PullImage(ctx, image, func(status string) {
+				fmt.Fprintln(writer, status)
+			})
+			if err != nil {
+				if _, sizeErr := s.dockerClient.GetImageSize(ctx, image); sizeErr != nil {
+					return fmt.Errorf("service %s: %v", name, err)
+				}
+				fmt.Fprintf(writer, "[warn] %v; using the local image\n", err)
+			}
+			continue
+		}
+
+		contextPath := filepath.Join(dir, svc.Build.Context)
+		if rel, err := filepath.Rel(repoPath, contextPath); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return fmt.Errorf("service %s: build context %s is outside the repository", name, svc.Build.Context)
+		}
+		dockerfile := svc.Build.Dockerfile
+		if dockerfile == "" {
+			dockerfile = "Dockerfile"
+		}
+		args := copyStringMap(svc.Build.Args)
+		if name == app.ComposeService {
+			for k, v := range app.BuildArgs {
+				args[k] = v
+			}
+		}
+
+		fmt.Fprintf(writer, "\n==> Building service %s as %s\n", name, image)
+		serviceOptions := options
+		serviceOptions.CacheFrom = s.keepPrevious(ctx, image, writer)
+		err := s.dockerClient.BuildImage(ctx, contextPath, dockerfile, image,
+			withInheritedEnv(args, inherited), BuildNetworkMode(app), serviceOptions, writer)
+		if err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+	return nil
+}
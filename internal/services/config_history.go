@@ -0,0 +1,166 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// configHistoryLimit is how many env/buildArgs snapshots are kept per app.
+const configHistoryLimit = 20
+
+const maskedValue = "********"
+
+// Keys containing any of these (case-insensitively) have their values masked
+// in config history responses.
+var secretKeyMarkers = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "PRIVATE"}
+
+// IsSecretKey reports whether an env var or build arg looks like it holds a
+// secret.
+func IsSecretKey(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, marker := range secretKeyMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordConfigChange snapshots app's env and build args if they differ from
+// previous. The first recorded change also snapshots the config it replaced,
+// so the history always shows what the app looked like before.
+func (m *AppManager) recordConfigChange(previous, app *models.App, actor string) {
+	if sameStringMap(previous.Env, app.Env) && sameStringMap(previous.BuildArgs, app.BuildArgs) {
+		return
+	}
+
+	existing, err := m.db.GetConfigSnapshots(app.ID)
+	if err != nil {
+		return
+	}
+	if len(existing) == 0 {
+		m.db.CreateConfigSnapshot(&models.ConfigSnapshot{
+			AppID:     app.ID,
+			Env:       copyStringMap(previous.Env),
+			BuildArgs: copyStringMap(previous.BuildArgs),
+			CreatedAt: previous.UpdatedAt,
+		}, configHistoryLimit)
+	}
+
+	m.db.CreateConfigSnapshot(&models.ConfigSnapshot{
+		AppID:     app.ID,
+		Env:       copyStringMap(app.Env),
+		BuildArgs: copyStringMap(app.BuildArgs),
+		Actor:     actor,
+		CreatedAt: time.Now(),
+	}, configHistoryLimit)
+}
+
+// GetConfigHistory returns the app's config snapshots, newest first, with
+// secret values masked and each entry diffed against the one before it.
+func (m *AppManager) GetConfigHistory(appID string) ([]*models.ConfigHistoryEntry, error) {
+	if _, err := m.db.GetApp(appID); err != nil {
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+
+	snapshots, err := m.db.GetConfigSnapshots(appID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]*models.ConfigHistoryEntry, 0, len(snapshots))
+	for i, snapshot := range snapshots {
+		changes := []models.ConfigChange{}
+		if i+1 < len(snapshots) {
+			older := snapshots[i+1]
+			changes = append(changes, diffConfig("env", older.Env, snapshot.Env)...)
+			changes = append(changes, diffConfig("buildArgs", older.BuildArgs, snapshot.BuildArgs)...)
+		}
+
+		masked := *snapshot
+		masked.Env = maskConfig(snapshot.Env)
+		masked.BuildArgs = maskConfig(snapshot.BuildArgs)
+		history = append(history, &models.ConfigHistoryEntry{ConfigSnapshot: masked, Changes: changes})
+	}
+	return history, nil
+}
+
+func (m *AppManager) GetConfigSnapshot(appID string, id int64) (*models.ConfigSnapshot, error) {
+	snapshot, err := m.db.GetConfigSnapshot(appID, id)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot not found: %v", err)
+	}
+	return snapshot, nil
+}
+
+// diffConfig lists the keys that differ between from and to, sorted by key.
+// Secret values are masked, but a changed secret is still reported.
+func diffConfig(scope string, from, to map[string]string) []models.ConfigChange {
+	keys := make(map[string]bool, len(from)+len(to))
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []models.ConfigChange
+	for _, key := range sorted {
+		oldValue, hadOld := from[key]
+		newValue, hasNew := to[key]
+		change := models.ConfigChange{Scope: scope, Key: key}
+		switch {
+		case !hadOld:
+			change.Change = "added"
+			change.To = maskValue(key, newValue)
+		case !hasNew:
+			change.Change = "removed"
+			change.From = maskValue(key, oldValue)
+		case oldValue != newValue:
+			change.Change = "changed"
+			change.From = maskValue(key, oldValue)
+			change.To = maskValue(key, newValue)
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func maskConfig(values map[string]string) map[string]string {
+	masked := make(map[string]string, len(values))
+	for k, v := range values {
+		masked[k] = maskValue(k, v)
+	}
+	return masked
+}
+
+func maskValue(key, value string) string {
+	if value != "" && IsSecretKey(key) {
+		return maskedValue
+	}
+	return value
+}
+
+// sameStringMap treats nil and empty maps as equal.
+func sameStringMap(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
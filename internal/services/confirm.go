@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+// Actions that can be made to require re-entering the password, even with
+// a valid session.
+const (
+	ConfirmDeleteApp  = "delete-app"
+	ConfirmSelfUpdate = "self-update"
+)
+
+// ConfirmableActions lists every action Settings.ConfirmActions may name.
+var ConfirmableActions = []string{ConfirmDeleteApp, ConfirmSelfUpdate}
+
+// DefaultConfirmActions applies while Settings.ConfirmActions is unset.
+var DefaultConfirmActions = []string{ConfirmDeleteApp, ConfirmSelfUpdate}
+
+// RequiresConfirmation reports whether action must be confirmed under
+// these settings.
+func (s Settings) RequiresConfirmation(action string) bool {
+	actions := s.ConfirmActions
+	if actions == nil {
+		actions = DefaultConfirmActions
+	}
+	return containsString(actions, action)
+}
+
+// ValidateConfirmActions rejects unknown action names.
+func ValidateConfirmActions(actions []string) error {
+	for _, action := range actions {
+		if !containsString(ConfirmableActions, action) {
+			return fmt.Errorf("unknown confirm action %q (must be one of %v)", action, ConfirmableActions)
+		}
+	}
+	return nil
+}
+
+// ConfirmAction checks the password given to confirm action and logs the
+// outcome against actor.
+func (s *AuthService) ConfirmAction(ctx context.Context, action, actor, password string) bool {
+	ok := s.ValidatePassword(password)
+	outcome := "confirmed"
+	if !ok {
+		outcome = "confirmation failed"
+	}
+	logf(ctx, "%s by %s: %s", action, actor, outcome)
+	return ok
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// maxLogFiles bounds max-file; Docker has no limit but more than this is
+// almost certainly a typo.
+const maxLogFiles = 100
+
+// logSizePattern is a max-size as Docker takes it: a number with an optional
+// k, m or g unit.
+var logSizePattern = regexp.MustCompile(`^[1-9][0-9]*[kmg]?$`)
+
+// ValidateLogOptions checks a container log max-size (such as "10m") and
+// max-file count. Empty and 0 mean unset.
+func ValidateLogOptions(maxSize string, maxFiles int) error {
+	if maxSize != "" && !logSizePattern.MatchString(maxSize) {
+		return fmt.Errorf("invalid log max size %q: expected a number with an optional k, m or g unit, such as 10m", maxSize)
+	}
+	if maxFiles < 0 || maxFiles > maxLogFiles {
+		return fmt.Errorf("log max files must be between 1 and %d, or 0 for the default", maxLogFiles)
+	}
+	return nil
+}
+
+// logConfig is the app's log limits, each falling back to the controller-wide
+// default when the app doesn't set it. It's read when the container is
+// created, so a changed default reaches every app on its next recreate.
+func (m *AppManager) logConfig(app *models.App) docker.LogConfig {
+	settings := m.settings.Get()
+	config := docker.LogConfig{MaxSize: app.LogMaxSize, MaxFiles: app.LogMaxFiles}
+	if config.MaxSize == "" {
+		config.MaxSize = settings.LogMaxSize
+	}
+	if config.MaxFiles == 0 {
+		config.MaxFiles = settings.LogMaxFiles
+	}
+	return config
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// DefaultContainerPrefix marks containers owned by the controller. Apps get
+// <prefix><slug> as their container name.
+const DefaultContainerPrefix = "nc-"
+
+// containerNamePattern is Docker's rule for container names.
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateContainerPrefix checks that prefix can start a Docker container
+// name. Empty means DefaultContainerPrefix.
+func ValidateContainerPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if len(prefix) > 32 {
+		return fmt.Errorf("container prefix must be at most 32 characters")
+	}
+	if !containerNamePattern.MatchString(prefix) {
+		return fmt.Errorf("container prefix must start with a letter or digit and contain only letters, digits, '_', '.' and '-'")
+	}
+	return nil
+}
+
+// hostnamePattern is an RFC 1123 hostname: dot-separated labels of letters,
+// digits and inner hyphens.
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// ValidateContainerName checks a custom container name against Docker's
+// rules. Empty means <prefix><slug>.
+func ValidateContainerName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if len(name) > 128 {
+		return fmt.Errorf("container name must be at most 128 characters")
+	}
+	if !containerNamePattern.MatchString(name) {
+		return fmt.Errorf("container name must start with a letter or digit and contain only letters, digits, '_', '.' and '-'")
+	}
+	return nil
+}
+
+// ValidateHostname checks a container hostname. Empty leaves Docker's
+// default.
+func ValidateHostname(hostname string) error {
+	if hostname == "" {
+		return nil
+	}
+	if len(hostname) > 253 || !hostnamePattern.MatchString(hostname) {
+		return fmt.Errorf("invalid hostname %q: use letters, digits and hyphens, with dots between labels", hostname)
+	}
+	return nil
+}
+
+// ContainerName returns the canonical container name for slug.
+func (s *SettingsService) ContainerName(slug string) string {
+	prefix := s.Get().ContainerPrefix
+	if prefix == "" {
+		prefix = DefaultContainerPrefix
+	}
+	return prefix + slug
+}
+
+// canonicalContainerName is the name the app's primary container should
+// have: its custom name, or <prefix><slug>.
+func (m *AppManager) canonicalContainerName(app *models.App) string {
+	if app.CustomContainerName != "" {
+		return app.CustomContainerName
+	}
+	return m.settings.ContainerName(app.Slug)
+}
+
+// CheckContainerName makes sure the app's canonical container name is free
+// for it: no other app uses it and no container the controller doesn't
+// manage has it. It returns a docker.NameConflict error otherwise.
+func (m *AppManager) CheckContainerName(ctx context.Context, app *models.App) error {
+	name := m.canonicalContainerName(app)
+	apps, err := m.db.GetAllApps()
+	if err != nil {
+		return err
+	}
+	for _, other := range apps {
+		if other.ID != app.ID && (other.ContainerName == name || m.canonicalContainerName(other) == name) {
+			return fmt.Errorf("container name %s is already used by app %s: %w", name, other.Name, docker.NameConflict(name))
+		}
+	}
+	if existing, _ := m.dockerClient.GetContainerByName(ctx, name); existing != nil && foreignContainer(app, existing) {
+		return docker.NameConflict(name)
+	}
+	return nil
+}
+
+// foreignContainer reports whether c, found under one of app's names, is
+// not app's to remove. Containers from before labels were added have none;
+// those can only carry the name the app had then, or a replica's of it,
+// never a custom one nor a name a later prefix gave it. An unlabeled
+// container under such a name is someone else's, e.g. a user's own
+// binhex-<slug> once the prefix is binhex-.
+func foreignContainer(app *models.App, c *types.Container) bool {
+	if owner := c.Labels[docker.AppIDLabel]; owner != "" {
+		return owner != app.ID
+	}
+	if app.CustomContainerName != "" {
+		return true
+	}
+	if app.ContainerName == "" {
+		return false
+	}
+	for _, name := range c.Names {
+		name = strings.TrimPrefix(name, "/")
+		if name == app.ContainerName {
+			return false
+		}
+		if suffix, ok := strings.CutPrefix(name, app.ContainerName+"-"); ok {
+			if i, err := strconv.Atoi(suffix); err == nil && i >= 2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// containerNames lists the names the app's primary container may currently
+// have: the stored one and, until it has been migrated, the canonical one.
+func (m *AppManager) containerNames(app *models.App) []string {
+	canonical := m.canonicalContainerName(app)
+	if app.ContainerName == "" {
+		return []string{canonical}
+	}
+	if app.ContainerName == canonical {
+		return []string{app.ContainerName}
+	}
+	return []string{app.ContainerName, canonical}
+}
+
+// removeContainersByName stops and removes the app's primary container
+// under any of its names.
+func (m *AppManager) removeContainersByName(ctx context.Context, app *models.App) {
+	for _, name := range m.containerNames(app) {
+		m.removeContainerByName(ctx, app, name)
+	}
+}
+
+// migrateContainerName moves an app whose container predates the naming
+// scheme (or the current prefix, or was renamed) onto the canonical name.
+// It's only called when the containers are about to be recreated anyway.
+func (m *AppManager) migrateContainerName(ctx context.Context, app *models.App) {
+	canonical := m.canonicalContainerName(app)
+	if app.ContainerName == canonical {
+		return
+	}
+
+	if app.ContainerName != "" {
+		m.removeContainerByName(ctx, app, app.ContainerName)
+		m.removeReplicas(ctx, app, 2)
+		log.Printf("App %s: renaming container %s to %s", app.Slug, app.ContainerName, canonical)
+	}
+	app.ContainerName = canonical
+	m.db.UpdateApp(app)
+}
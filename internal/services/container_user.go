@@ -0,0 +1,22 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// containerUserPattern accepts what docker run --user does: a user and an
+// optional group, each a numeric ID or a name.
+var containerUserPattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*(:[a-zA-Z0-9_][a-zA-Z0-9_.-]*)?$`)
+
+// ValidateContainerUser checks a container user such as "99:100". Empty
+// means the image's USER.
+func ValidateContainerUser(user string) error {
+	if user == "" {
+		return nil
+	}
+	if len(user) > 64 || !containerUserPattern.MatchString(user) {
+		return fmt.Errorf("user must be uid[:gid] or name[:group], such as 99:100")
+	}
+	return nil
+}
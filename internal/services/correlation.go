@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type correlationKey struct{}
+
+// correlationIDPattern accepts IDs supplied by clients (X-Correlation-ID):
+// short and free of anything that would mangle a log line.
+var correlationIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// NewCorrelationID returns a short random ID, easy to paste into a forum
+// post or grep for.
+func NewCorrelationID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidCorrelationID reports whether id can be used as a correlation ID.
+func ValidCorrelationID(id string) bool {
+	return correlationIDPattern.MatchString(id)
+}
+
+// WithCorrelationID tags ctx with id, so everything done on its behalf
+// (build records, log lines, error responses) can be traced back to it.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns ctx's correlation ID, or "" if it has none.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// logf logs with ctx's correlation ID prepended.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	if id := CorrelationID(ctx); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+// ValidateExternalBaseURL checks the externally reachable controller URL
+// used for deep links. Empty disables links.
+func ValidateExternalBaseURL(base string) error {
+	if base == "" {
+		return nil
+	}
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("externalBaseUrl must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// AppURL links to the app's page, or "" without an external base URL.
+func (s *SettingsService) AppURL(appID string) string {
+	base := strings.TrimRight(s.Get().ExternalBaseURL, "/")
+	if base == "" {
+		return ""
+	}
+	return base + "/apps/" + url.PathEscape(appID)
+}
+
+// BuildURL links to the app's build page, or "" without an external base
+// URL.
+func (s *SettingsService) BuildURL(appID string) string {
+	if app := s.AppURL(appID); app != "" {
+		return app + "/build"
+	}
+	return ""
+}
+
+// Links returns deep links for the app, or nil without an external base URL.
+func (m *AppManager) Links(appID string) map[string]string {
+	app := m.settings.AppURL(appID)
+	if app == "" {
+		return nil
+	}
+	return map[string]string{"app": app, "build": m.settings.BuildURL(appID)}
+}
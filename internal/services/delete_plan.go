@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// deletePlanTTL is how long a previewed delete can be executed by ID.
+const deletePlanTTL = 10 * time.Minute
+
+// ErrDeletePlanNotFound is returned for a plan ID that is unknown, expired
+// or for another app.
+var ErrDeletePlanNotFound = errors.New("delete plan not found or expired; preview the delete again")
+
+// PlanDelete works out what deleting the app removes, with sizes, without
+// touching anything. The plan is kept so it can be executed by ID.
+func (m *AppManager) PlanDelete(ctx context.Context, appID string) (*models.DeletePlan, error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+
+	plan := &models.DeletePlan{
+		ID:             uuid.New().String(),
+		AppID:          app.ID,
+		AppName:        app.Name,
+		ContainerState: "none",
+		KeptVolumes:    []*models.VolumeUsage{},
+		ExpiresAt:      time.Now().Add(deletePlanTTL),
+	}
+	if _, state := m.findContainer(ctx, app, 1); state != "" {
+		plan.ContainerState = state
+	}
+
+	addStep := func(action, target string, bytes int64) {
+		plan.Steps = append(plan.Steps, &models.DeleteStep{Action: action, Target: target, Bytes: bytes})
+		plan.TotalBytes += bytes
+	}
+
+	addStep(models.DeleteStepContainer, app.ContainerName, 0)
+	if app.Replicas > 1 {
+		addStep(models.DeleteStepReplicas, fmt.Sprintf("%d replicas", app.Replicas-1), 0)
+	}
+	imageSize, _ := m.dockerClient.GetImageSize(ctx, app.ImageName)
+	addStep(models.DeleteStepImage, app.ImageName, imageSize)
+	// A local-path app's source directory is not ours to delete
+	if app.SourceType == models.SourceTypeUpload || !IsLocalPath(app.RepoURL) {
+		source := m.repoPath(app)
+		addStep(models.DeleteStepSource, source, dirSize(source))
+	}
+	addStep(models.DeleteStepBuildLog, "build logs", m.buildService.BuildLogSize(app.ID))
+	iconSize := int64(0)
+	if info, err := os.Stat(m.iconService.IconPath(app.ID)); err == nil {
+		iconSize = info.Size()
+	}
+	addStep(models.DeleteStepIcon, "icon", iconSize)
+	addStep(models.DeleteStepRecord, "app record, build history and events", 0)
+
+	if names := namedVolumes(app.Volumes); len(names) > 0 {
+		sizes, _ := m.dockerClient.VolumeSizes(ctx)
+		for _, name := range names {
+			size, ok := sizes[name]
+			if !ok {
+				size = -1
+			}
+			plan.KeptVolumes = append(plan.KeptVolumes, &models.VolumeUsage{Name: name, Bytes: size})
+		}
+	}
+
+	m.deletePlansMu.Lock()
+	for id, p := range m.deletePlans {
+		if time.Now().After(p.ExpiresAt) {
+			delete(m.deletePlans, id)
+		}
+	}
+	m.deletePlans[plan.ID] = plan
+	m.deletePlansMu.Unlock()
+	return plan, nil
+}
+
+// DeleteApp plans the delete and executes it straight away.
+func (m *AppManager) DeleteApp(ctx context.Context, appID string) (*models.DeletePlan, error) {
+	plan, err := m.PlanDelete(ctx, appID)
+	if err != nil {
+		return nil, err
+	}
+	return m.ExecuteDeletePlan(ctx, appID, plan.ID)
+}
+
+// ExecuteDeletePlan runs the steps of a previewed plan, and only those, so
+// what happens is what the preview showed. A step that fails is recorded
+// and the rest still run; only failing to remove the app record fails the
+// delete.
+func (m *AppManager) ExecuteDeletePlan(ctx context.Context, appID, planID string) (*models.DeletePlan, error) {
+	m.deletePlansMu.Lock()
+	plan := m.deletePlans[planID]
+	if plan == nil || plan.AppID != appID || plan.Executed || time.Now().After(plan.ExpiresAt) {
+		m.deletePlansMu.Unlock()
+		return nil, ErrDeletePlanNotFound
+	}
+	plan.Executed = true
+	delete(m.deletePlans, planID)
+	m.deletePlansMu.Unlock()
+
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+
+	var recordErr error
+	for _, step := range plan.Steps {
+		err := m.runDeleteStep(ctx, app, step.Action)
+		if err != nil {
+			step.Result = "failed"
+			step.Error = err.Error()
+			logf(ctx, "App %s: delete step %s failed: %v", app.Name, step.Action, err)
+			if step.Action == models.DeleteStepRecord {
+				recordErr = err
+			}
+			continue
+		}
+		step.Result = "done"
+	}
+	if !app.NetworkIsolated {
+		m.releaseSharedNetwork(ctx)
+	}
+	return plan, recordErr
+}
+
+func (m *AppManager) runDeleteStep(ctx context.Context, app *models.App, action string) error {
+	switch action {
+	case models.DeleteStepContainer:
+		if app.ContainerID != "" {
+			m.dockerClient.StopContainer(ctx, app.ContainerID)
+			if err := m.dockerClient.RemoveContainer(ctx, app.ContainerID, true); err != nil && !docker.IsNotFound(err) {
+				return err
+			}
+		}
+		// Also by name, in case the ID is stale
+		m.removeContainersByName(ctx, app)
+		m.removeComposeContainers(ctx, app)
+	case models.DeleteStepReplicas:
+		m.removeReplicas(ctx, app, 2)
+	case models.DeleteStepImage:
+		// An image that's already gone (pruned, never built) is what we
+		// wanted anyway
+		if err := m.dockerClient.RemoveImage(ctx, app.ImageName); err != nil && !docker.IsNotFound(err) {
+			return err
+		}
+		for _, image := range append(m.previousImages(app), m.commitImages(app)...) {
+			if err := m.dockerClient.RemoveImage(ctx, image); err != nil && !docker.IsNotFound(err) {
+				return err
+			}
+		}
+		if app.ComposeFile != "" {
+			return m.removeComposeResources(ctx, app)
+		}
+	case models.DeleteStepSource:
+		if app.SourceType == models.SourceTypeUpload {
+			return m.uploads.Remove(app.Slug)
+		}
+		return m.gitService.RemoveRepo(app.Slug)
+	case models.DeleteStepBuildLog:
+		if err := m.buildService.ClearBuildLog(app.ID); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return m.buildService.RemoveBuildHistory(app.ID)
+	case models.DeleteStepIcon:
+		if err := m.iconService.RemoveIcon(app.ID); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	case models.DeleteStepRecord:
+		m.prepull.Forget(app.ID)
+		m.health.Forget(app.ID)
+		return m.db.DeleteApp(app.ID)
+	default:
+		return fmt.Errorf("unknown delete step %q", action)
+	}
+	return nil
+}
+
+// namedVolumes returns the Docker named volumes among an app's volume
+// mappings; bind mounts have a path as their source.
+func namedVolumes(volumes []string) []string {
+	var names []string
+	for _, v := range volumes {
+		source, _, ok := strings.Cut(v, ":")
+		if !ok || source == "" || strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") {
+			continue
+		}
+		names = append(names, source)
+	}
+	return names
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"fmt"
+
+	"nas-controller/internal/docker"
+)
+
+// validateDevices checks each device mapping's syntax and that no two map to
+// the same container path. Whether the host device exists is only known at
+// start time: the controller usually runs in a container without the
+// host's /dev.
+func validateDevices(devices []string) error {
+	seen := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		device, err := docker.ParseDevice(d)
+		if err != nil {
+			return err
+		}
+		if seen[device.PathInContainer] {
+			return fmt.Errorf("device %s is mapped more than once", device.PathInContainer)
+		}
+		seen[device.PathInContainer] = true
+	}
+	return nil
+}
+
+// deviceError rewrites Docker's error for a missing host device into one
+// naming the device, or returns nil if err is about something else.
+func deviceError(err error) error {
+	if device := docker.MissingDevice(err); device != "" {
+		return fmt.Errorf("host device %s not found; check that it is plugged in and the driver is loaded, or remove it from the app's devices", device)
+	}
+	return nil
+}
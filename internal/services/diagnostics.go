@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"nas-controller/internal/docker"
+)
+
+// Thresholds for the diagnostics checks.
+const (
+	minFreeBytes = 2 << 30
+	maxClockSkew = 30 * time.Second
+)
+
+// DiagnosticCheck is the result of one setup check. Hint says how to fix a
+// failure, in terms of the Unraid container template where that's where
+// the problem usually is.
+type DiagnosticCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+type DiagnosticsReport struct {
+	RanAt  time.Time         `json:"ranAt"`
+	OK     bool              `json:"ok"`
+	Checks []DiagnosticCheck `json:"checks"`
+}
+
+// Failures returns the checks that failed.
+func (r *DiagnosticsReport) Failures() []DiagnosticCheck {
+	failures := []DiagnosticCheck{}
+	for _, check := range r.Checks {
+		if !check.OK {
+			failures = append(failures, check)
+		}
+	}
+	return failures
+}
+
+// LogFailures writes each failed check to the controller log.
+func (r *DiagnosticsReport) LogFailures() {
+	for _, check := range r.Failures() {
+		log.Printf("Diagnostics: %s failed: %s. %s", check.Name, check.Detail, check.Hint)
+	}
+}
+
+// DiagnosticsService checks the things that usually break a new install:
+// the Docker socket, the data directory's mounts and permissions, git, disk
+// space and the clock.
+type DiagnosticsService struct {
+	dockerClient *docker.Client
+	dataDir      string
+
+	mu   sync.Mutex
+	last *DiagnosticsReport
+}
+
+func NewDiagnosticsService(dockerClient *docker.Client, dataDir string) *DiagnosticsService {
+	return &DiagnosticsService{
+		dockerClient: dockerClient,
+		dataDir:      dataDir,
+	}
+}
+
+// Run runs every check and keeps the report for Last.
+func (s *DiagnosticsService) Run(ctx context.Context) *DiagnosticsReport {
+	report := &DiagnosticsReport{RanAt: time.Now(), OK: true}
+	report.Checks = append(report.Checks, s.checkDocker(ctx))
+	report.Checks = append(report.Checks, s.checkWritable("dataDir", s.dataDir))
+	for _, dir := range []string{"repos", "logs", "icons"} {
+		report.Checks = append(report.Checks, s.checkWritable(dir, filepath.Join(s.dataDir, dir)))
+	}
+	report.Checks = append(report.Checks, checkGit(ctx))
+	report.Checks = append(report.Checks, s.checkFreeSpace())
+	report.Checks = append(report.Checks, s.checkClock(ctx))
+
+	for _, check := range report.Checks {
+		if !check.OK {
+			report.OK = false
+		}
+	}
+
+	s.mu.Lock()
+	s.last = report
+	s.mu.Unlock()
+	return report
+}
+
+// Last returns the most recent report, or nil if Run hasn't been called.
+func (s *DiagnosticsService) Last() *DiagnosticsReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+func (s *DiagnosticsService) checkDocker(ctx context.Context) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "docker"}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	version, err := s.dockerClient.ServerVersion(ctx)
+	if err != nil {
+		check.Detail = fmt.Sprintf("Docker daemon not reachable: %v", err)
+		check.Hint = "Map /var/run/docker.sock into the controller container (Path: /var/run/docker.sock, read/write)"
+		return check
+	}
+	check.OK = true
+	check.Detail = "Docker " + version
+	return check
+}
+
+// checkWritable makes sure dir exists and a file can be created in it. A
+// read-only mount only shows up on write, not in the mode bits.
+func (s *DiagnosticsService) checkWritable(name, dir string) DiagnosticCheck {
+	check := DiagnosticCheck{Name: name}
+
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		check.Detail = fmt.Sprintf("%s is missing", dir)
+		check.Hint = fmt.Sprintf("Check the appdata path mapped to %s exists on the host", s.dataDir)
+		return check
+	}
+
+	f, err := os.CreateTemp(dir, ".diagnostics-*")
+	if err != nil {
+		check.Detail = fmt.Sprintf("%s is not writable: %v", dir, err)
+		check.Hint = fmt.Sprintf("Map %s read/write, and make it writable by the user the controller runs as", s.dataDir)
+		return check
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	check.OK = true
+	check.Detail = dir + " is writable"
+	return check
+}
+
+func checkGit(ctx context.Context) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "git"}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "git", "--version").Output()
+	if err != nil {
+		check.Detail = fmt.Sprintf("git not found: %v", err)
+		check.Hint = "The controller image ships git; a custom image needs it on PATH to add apps from repositories"
+		return check
+	}
+	check.OK = true
+	check.Detail = strings.TrimSpace(string(out))
+	return check
+}
+
+func (s *DiagnosticsService) checkFreeSpace() DiagnosticCheck {
+	check := DiagnosticCheck{Name: "freeSpace"}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(s.dataDir, &fs); err != nil {
+		check.Detail = fmt.Sprintf("could not read free space: %v", err)
+		return check
+	}
+	free := uint64(fs.Bavail) * uint64(fs.Bsize)
+	check.Detail = fmt.Sprintf("%d MB free on %s", free>>20, s.dataDir)
+	if free < minFreeBytes {
+		check.Hint = "Free up space on the share holding appdata; builds and clones need room"
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// checkClock compares the controller's clock with the Docker host's. They
+// share a kernel clock in the usual setup, so skew means the controller runs
+// somewhere else (or in a VM) with a clock that drifted, which throws off
+// build times, sessions and update checks.
+func (s *DiagnosticsService) checkClock(ctx context.Context) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "clock"}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	before := time.Now()
+	daemonTime, err := s.dockerClient.DaemonTime(ctx)
+	if err != nil {
+		check.Detail = fmt.Sprintf("could not read the Docker host's clock: %v", err)
+		check.Hint = "See the docker check"
+		return check
+	}
+	// Compare against the midpoint of the request to discount its latency.
+	local := before.Add(time.Since(before) / 2)
+	skew := local.Sub(daemonTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	check.Detail = fmt.Sprintf("clock differs from the Docker host by %s", skew.Round(time.Millisecond))
+	if skew > maxClockSkew {
+		check.Hint = "Enable NTP on the host running the controller"
+		return check
+	}
+	check.OK = true
+	return check
+}
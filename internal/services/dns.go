@@ -0,0 +1,39 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// hostGateway is Docker's stand-in for the host's IP in an extra host.
+const hostGateway = "host-gateway"
+
+var extraHostNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_.-]*[a-zA-Z0-9])?$`)
+
+// ValidateExtraHosts checks each /etc/hosts entry is host:ip, the format of
+// docker run --add-host. The IP may be IPv6 or host-gateway.
+func ValidateExtraHosts(hosts []string) error {
+	for _, entry := range hosts {
+		host, ip, ok := strings.Cut(entry, ":")
+		if !ok || !extraHostNamePattern.MatchString(host) {
+			return fmt.Errorf("invalid extra host %q: expected host:ip, such as api.internal:192.168.1.50", entry)
+		}
+		if ip != hostGateway && net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid extra host %q: %q is not an IP address", entry, ip)
+		}
+	}
+	return nil
+}
+
+// ValidateDNS checks each DNS server is an IP address. Docker takes no
+// ports or hostnames here.
+func ValidateDNS(servers []string) error {
+	for _, server := range servers {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid DNS server %q: expected an IP address, such as 192.168.1.2", server)
+		}
+	}
+	return nil
+}
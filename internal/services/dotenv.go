@@ -0,0 +1,141 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxDotenvBytes caps an imported .env file.
+const MaxDotenvBytes = 1 << 20
+
+// envKeyPattern is a POSIX shell variable name, which is what .env files
+// and most images expect.
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseDotenv parses .env text: KEY=VALUE lines, with blank lines and #
+// comments ignored, an optional "export " prefix, and values that may be
+// unquoted (a " #" starts a comment), 'single-quoted' (literal) or
+// "double-quoted" (with \n, \t, \" and \\ escapes). Quoted values must close
+// on the same line. A later duplicate key wins. Every malformed line is
+// reported by number and nothing is returned.
+func ParseDotenv(text string) (map[string]string, error) {
+	env := map[string]string{}
+	var problems []string
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if i == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, err := parseDotenvLine(line)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", i+1, err))
+			continue
+		}
+		env[key] = value
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid .env: %s", strings.Join(problems, "; "))
+	}
+	return env, nil
+}
+
+func parseDotenvLine(line string) (string, string, error) {
+	line = strings.TrimPrefix(line, "export ")
+	key, rest, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", fmt.Errorf("expected KEY=VALUE")
+	}
+	key = strings.TrimSpace(key)
+	if !envKeyPattern.MatchString(key) {
+		return "", "", fmt.Errorf("invalid key %q", key)
+	}
+	rest = strings.TrimSpace(rest)
+
+	switch {
+	case strings.HasPrefix(rest, `'`):
+		end := strings.Index(rest[1:], `'`)
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated single quote")
+		}
+		if err := checkTrailing(rest[end+2:]); err != nil {
+			return "", "", err
+		}
+		return key, rest[1 : end+1], nil
+	case strings.HasPrefix(rest, `"`):
+		var b strings.Builder
+		for i := 1; i < len(rest); i++ {
+			switch ch := rest[i]; ch {
+			case '"':
+				if err := checkTrailing(rest[i+1:]); err != nil {
+					return "", "", err
+				}
+				return key, b.String(), nil
+			case '\\':
+				if i+1 == len(rest) {
+					break
+				}
+				i++
+				switch rest[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					// \" \\ and anything else: the character itself
+					b.WriteByte(rest[i])
+				}
+			default:
+				b.WriteByte(ch)
+			}
+		}
+		return "", "", fmt.Errorf("unterminated double quote")
+	default:
+		if i := strings.Index(rest, " #"); i >= 0 {
+			rest = rest[:i]
+		}
+		return key, strings.TrimSpace(rest), nil
+	}
+}
+
+// checkTrailing allows only whitespace or a comment after a quoted value.
+func checkTrailing(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected text after closing quote: %q", rest)
+	}
+	return nil
+}
+
+// RenderDotenv writes env as .env text, sorted by key, that ParseDotenv reads
+// back unchanged. Values that need it are double-quoted.
+func RenderDotenv(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(quoteDotenvValue(env[k]))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func quoteDotenvValue(value string) string {
+	if value == "" || !strings.ContainsAny(value, " \t\r\n#'\"\\") {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(value) + `"`
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envReference matches ${NAME} and ${NAME:-default} in an env value, and
+// $$, the escape for a literal $. The default runs to the first }, so it
+// can't itself contain a reference.
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// EnvInterpolationError lists why an app's env couldn't be interpolated:
+// the variables referenced but set nowhere, by the variables referencing
+// them, and a chain of variables that reference each other.
+type EnvInterpolationError struct {
+	Unresolved map[string][]string
+	Cycle      []string
+}
+
+func (e *EnvInterpolationError) Error() string {
+	var parts []string
+	if len(e.Cycle) > 0 {
+		parts = append(parts, "env variables reference each other: "+strings.Join(e.Cycle, " -> "))
+	}
+	if len(e.Unresolved) > 0 {
+		names := make([]string, 0, len(e.Unresolved))
+		for name := range e.Unresolved {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = fmt.Sprintf("%s (in %s)", name, strings.Join(e.Unresolved[name], ", "))
+		}
+		parts = append(parts, "unresolved env variables: "+strings.Join(names, ", "))
+	}
+	return strings.Join(parts, "; ") + "; use ${NAME:-default}, set them, or turn on literalEnv"
+}
+
+// maskEnv masks env's secrets, including where interpolation copied a
+// secret's value into another variable (a password in DATABASE_URL).
+func maskEnv(env map[string]string) map[string]string {
+	masked := maskConfig(env)
+	for key, secret := range env {
+		if secret == "" || !IsSecretKey(key) {
+			continue
+		}
+		for other, value := range masked {
+			if !IsSecretKey(other) {
+				masked[other] = strings.ReplaceAll(value, secret, maskedValue)
+			}
+		}
+	}
+	return masked
+}
+
+// interpolateEnv replaces ${NAME} and ${NAME:-default} in env's values
+// with the values of other variables in env, which may themselves
+// reference others. ${NAME:-default} takes the default when NAME is unset
+// or empty; $$ is a literal $. Anything else with a $ is left as it is.
+// On error the values are returned as they were.
+func interpolateEnv(env map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(env))
+	visiting := map[string]bool{}
+	unresolved := map[string][]string{}
+	var stack []string
+	var cycle []string
+
+	var resolve func(name string) string
+	resolve = func(name string) string {
+		if value, ok := resolved[name]; ok {
+			return value
+		}
+		if visiting[name] {
+			if cycle == nil {
+				start := 0
+				for i, n := range stack {
+					if n == name {
+						start = i
+					}
+				}
+				cycle = append(append([]string{}, stack[start:]...), name)
+			}
+			return ""
+		}
+		visiting[name] = true
+		stack = append(stack, name)
+		value := envReference.ReplaceAllStringFunc(env[name], func(ref string) string {
+			if ref == "$$" {
+				return "$"
+			}
+			match := envReference.FindStringSubmatch(ref)
+			target, fallback := match[1], match[2]
+			if _, ok := env[target]; ok {
+				if value := resolve(target); value != "" || fallback == "" {
+					return value
+				}
+			} else if fallback == "" {
+				if refs := unresolved[target]; len(refs) == 0 || refs[len(refs)-1] != name {
+					unresolved[target] = append(refs, name)
+				}
+				return ref
+			}
+			return fallback[len(":-"):]
+		})
+		stack = stack[:len(stack)-1]
+		visiting[name] = false
+		resolved[name] = value
+		return value
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	// Sorted, so the same env always reports the same cycle
+	sort.Strings(names)
+	for _, name := range names {
+		resolve(name)
+	}
+
+	if cycle != nil || len(unresolved) > 0 {
+		for _, refs := range unresolved {
+			sort.Strings(refs)
+		}
+		return env, &EnvInterpolationError{Unresolved: unresolved, Cycle: cycle}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"nas-controller/internal/database"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+const (
+	// appEventLimit is how many exit events are kept per app.
+	appEventLimit = 100
+	// exitSettleDelay is how long to let Docker's restart policy (and a
+	// controller stop, which removes the container) act before judging an
+	// exit. Exits of the same container within it are judged once.
+	exitSettleDelay = 3 * time.Second
+	// exitRetryDelay is how long to wait before resubscribing after the
+	// Docker event stream drops.
+	exitRetryDelay = 10 * time.Second
+	// exitQueueSize is how many settled exits may wait to be judged; more
+	// are dropped and counted.
+	exitQueueSize = 64
+	// DefaultExitFlapSeconds is Settings.ExitFlapSeconds when unset.
+	DefaultExitFlapSeconds = 10
+)
+
+// ExitMonitor follows Docker's die and oom events for managed containers,
+// records each unplanned exit as an app event and marks the app errored when
+// its restart policy gives up. A burst of events, such as a stack of
+// containers restarting at once, is absorbed by judging each container's
+// exits once it has been quiet for exitSettleDelay, one at a time from a
+// bounded queue.
+type ExitMonitor struct {
+	db           *database.DB
+	dockerClient *docker.Client
+	settings     *SettingsService
+	// flows tells which exits happened inside a composite flow, if set
+	flows FlowReporter
+
+	mu      sync.Mutex
+	pending map[string]*pendingExit
+	queue   chan *pendingExit
+	// dropping is set from a drop until an exit is queued again, so a
+	// storm is logged once
+	dropping bool
+	// cancelWatch ends the current event subscription, for Resubscribe
+	cancelWatch context.CancelFunc
+	// connected is set while subscribed to the event stream
+	connected atomic.Bool
+
+	received   atomic.Int64
+	coalesced  atomic.Int64
+	dropped    atomic.Int64
+	suppressed atomic.Int64
+	handled    atomic.Int64
+}
+
+// FlowReporter tells whether an app was inside a composite flow (an update
+// or deploy) at a given time. AppManager implements it.
+type FlowReporter interface {
+	InFlowAt(appID string, at time.Time) bool
+}
+
+// pendingExit is a container's latest exit, waiting out exitSettleDelay.
+type pendingExit struct {
+	exit docker.ContainerExit
+	// exits counts the exits coalesced into this one
+	exits int
+	timer *time.Timer
+}
+
+// ExitEventStats are the exit monitor's counters since startup, for the
+// system info endpoint.
+type ExitEventStats struct {
+	// Received is every die event for a managed container.
+	Received int64 `json:"received"`
+	// Coalesced are exits folded into a later one of the same container.
+	Coalesced int64 `json:"coalesced"`
+	// Dropped are exits never judged because the queue was full.
+	Dropped int64 `json:"dropped"`
+	// Suppressed are exits whose alert was left out as a flap, or that
+	// were left out entirely as a step of an update or deploy.
+	Suppressed int64 `json:"suppressed"`
+	Handled    int64 `json:"handled"`
+	Pending    int   `json:"pending"`
+	Queued     int   `json:"queued"`
+}
+
+func NewExitMonitor(db *database.DB, dockerClient *docker.Client, settings *SettingsService) *ExitMonitor {
+	return &ExitMonitor{
+		db:           db,
+		dockerClient: dockerClient,
+		settings:     settings,
+		pending:      make(map[string]*pendingExit),
+		queue:        make(chan *pendingExit, exitQueueSize),
+	}
+}
+
+// SetFlows makes the exit monitor leave out exits that happen inside an
+// app's composite flow: the flow stops and starts containers on purpose and
+// reports its own outcome.
+func (e *ExitMonitor) SetFlows(flows FlowReporter) {
+	e.flows = flows
+}
+
+// Run follows exit events until ctx is done, resubscribing whenever the
+// event stream drops.
+func (e *ExitMonitor) Run(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case p := <-e.queue:
+				e.handleExit(ctx, p)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		watchCtx, cancel := context.WithCancel(ctx)
+		e.mu.Lock()
+		e.cancelWatch = cancel
+		e.mu.Unlock()
+		e.connected.Store(true)
+		err := e.dockerClient.WatchExits(watchCtx, e.debounce)
+		e.connected.Store(false)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Exit event stream ended: %v; resubscribing in %s", err, exitRetryDelay)
+		select {
+		case <-time.After(exitRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Connected reports whether the exit monitor is subscribed to Docker's
+// event stream.
+func (e *ExitMonitor) Connected() bool {
+	return e.connected.Load()
+}
+
+// Resubscribe drops the current event subscription; Run subscribes again
+// after exitRetryDelay.
+func (e *ExitMonitor) Resubscribe() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cancelWatch != nil {
+		e.cancelWatch()
+	}
+}
+
+// Stats returns the exit monitor's counters.
+func (e *ExitMonitor) Stats() ExitEventStats {
+	e.mu.Lock()
+	pending := len(e.pending)
+	e.mu.Unlock()
+	return ExitEventStats{
+		Received:   e.received.Load(),
+		Coalesced:  e.coalesced.Load(),
+		Dropped:    e.dropped.Load(),
+		Suppressed: e.suppressed.Load(),
+		Handled:    e.handled.Load(),
+		Pending:    pending,
+		Queued:     len(e.queue),
+	}
+}
+
+// debounce holds an exit until its container has been quiet for
+// exitSettleDelay. A later exit of the same container replaces it, keeping
+// the OOM kill if the earlier one was.
+func (e *ExitMonitor) debounce(exit docker.ContainerExit) {
+	e.received.Add(1)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// A timer that already fired has its exit on the way to the queue; this
+	// one starts afresh
+	if p, ok := e.pending[exit.ContainerID]; ok && p.timer.Stop() {
+		e.coalesced.Add(1)
+		exit.OOM = exit.OOM || p.exit.OOM
+		p.exit = exit
+		p.exits++
+		p.timer.Reset(exitSettleDelay)
+		return
+	}
+	p := &pendingExit{exit: exit, exits: 1}
+	p.timer = time.AfterFunc(exitSettleDelay, func() { e.enqueue(p) })
+	e.pending[exit.ContainerID] = p
+}
+
+func (e *ExitMonitor) enqueue(p *pendingExit) {
+	e.mu.Lock()
+	if e.pending[p.exit.ContainerID] == p {
+		delete(e.pending, p.exit.ContainerID)
+	}
+	e.mu.Unlock()
+
+	select {
+	case e.queue <- p:
+		e.mu.Lock()
+		e.dropping = false
+		e.mu.Unlock()
+	default:
+		e.dropped.Add(1)
+		e.mu.Lock()
+		if !e.dropping {
+			log.Printf("[warn] Exit event queue is full; dropping exits until it drains")
+		}
+		e.dropping = true
+		e.mu.Unlock()
+	}
+}
+
+// flapThreshold is how soon a container must be back up for its exit not
+// to be alerted on.
+func (e *ExitMonitor) flapThreshold() time.Duration {
+	seconds := e.settings.Get().ExitFlapSeconds
+	if seconds == 0 {
+		seconds = DefaultExitFlapSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (e *ExitMonitor) handleExit(ctx context.Context, p *pendingExit) {
+	e.handled.Add(1)
+	exit := p.exit
+
+	if e.flows != nil && e.flows.InFlowAt(exit.AppID, exit.At) {
+		e.suppressed.Add(1)
+		return
+	}
+
+	// The controller removes every container it stops or replaces, so one
+	// that's gone was stopped on purpose.
+	state, err := e.dockerClient.ContainerExitState(ctx, exit.ContainerID)
+	if err != nil {
+		return
+	}
+	app, err := e.db.GetApp(exit.AppID)
+	if err != nil || app.Status != models.StatusRunning {
+		return
+	}
+
+	event := &models.AppEvent{
+		AppID:       app.ID,
+		Replica:     exit.Replica,
+		ExitCode:    exit.ExitCode,
+		MemoryLimit: state.MemoryLimit,
+		GaveUp:      !state.Running && !state.Restarting,
+		CreatedAt:   time.Now(),
+	}
+	switch {
+	case exit.OOM:
+		event.Reason = models.ExitReasonOOM
+	case exit.ExitCode > 128:
+		event.Reason = models.ExitReasonSignal
+		event.Signal = syscall.Signal(exit.ExitCode - 128).String()
+	case exit.ExitCode != 0:
+		event.Reason = models.ExitReasonError
+	default:
+		event.Reason = models.ExitReasonClean
+	}
+	e.db.CreateAppEvent(event, appEventLimit)
+
+	// A container Docker had back up within the flap threshold only gets
+	// the app event
+	flap := state.Running && state.StartedAt.Sub(exit.At) < e.flapThreshold()
+	if flap && event.Reason != models.ExitReasonClean {
+		e.suppressed.Add(1)
+	} else if event.Reason != models.ExitReasonClean {
+		// There is no notification channel yet; the controller log is
+		// where alerts show up, tagged with the reason.
+		msg := describeExit(event, app)
+		if p.exits > 1 {
+			msg += fmt.Sprintf(" (%d exits in quick succession)", p.exits)
+		}
+		log.Printf("[alert:%s] App %s: %s", event.Reason, app.Slug, msg)
+	}
+
+	// A replica or compose service (replica 0) going down leaves the app
+	// up; only the primary decides its status.
+	if !event.GaveUp || exit.Replica != 1 {
+		return
+	}
+	if event.Reason == models.ExitReasonClean {
+		app.Status, app.SubStatus = applyStep(app.Status, app.SubStatus, models.StatusStopped)
+	} else {
+		app.Status, app.SubStatus = applyStep(app.Status, app.SubStatus, models.StatusError)
+		app.LastError = describeExit(event, app)
+	}
+	app.Health = models.HealthNone
+	e.db.UpdateApp(app)
+}
+
+func describeExit(event *models.AppEvent, app *models.App) string {
+	var msg string
+	switch event.Reason {
+	case models.ExitReasonOOM:
+		limit := "no memory limit"
+		if event.MemoryLimit > 0 {
+			limit = fmt.Sprintf("memory limit %d MB", event.MemoryLimit>>20)
+		}
+		msg = fmt.Sprintf("container was OOM-killed (exit code %d, %s)", event.ExitCode, limit)
+	case models.ExitReasonSignal:
+		msg = fmt.Sprintf("container was killed by signal: %s (exit code %d)", event.Signal, event.ExitCode)
+	default:
+		msg = fmt.Sprintf("container exited with code %d", event.ExitCode)
+	}
+	if event.Replica > 1 {
+		msg = fmt.Sprintf("replica %d: %s", event.Replica, msg)
+	}
+	if event.GaveUp {
+		msg += fmt.Sprintf("; restart policy %q did not restart it", app.RestartPolicy)
+	}
+	return msg
+}
+
+// GetAppEvents returns the app's recorded container exits, newest first.
+func (m *AppManager) GetAppEvents(appID string) ([]*models.AppEvent, error) {
+	return m.db.GetAppEvents(appID)
+}
+
+// RecentOOMKills counts the app's OOM kills in the last 24 hours, so an
+// app that keeps running out of memory stands out.
+func (m *AppManager) RecentOOMKills(appID string) int {
+	count, _ := m.db.CountAppEvents(appID, models.ExitReasonOOM, time.Now().Add(-24*time.Hour))
+	return count
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Feature areas of the API that can be turned off, for instances exposed
+// to the internet. A disabled area's routes aren't registered at all, so
+// they answer 404. Login, setup, health and version are always there.
+const (
+	// FeatureApps is the app routes: CRUD, actions, logs and builds.
+	FeatureApps = "apps"
+	// FeatureStreams is the WebSocket log and build streams.
+	FeatureStreams = "ws"
+	// FeatureSystem is /system/*, the summary and the build queue.
+	FeatureSystem = "system"
+	// FeatureSelfUpdate is the controller's self-update check and update.
+	FeatureSelfUpdate = "self-update"
+	// FeatureGuests is guest codes and links.
+	FeatureGuests = "guests"
+	// FeatureShares is shared log snapshots.
+	FeatureShares = "shares"
+	// FeatureBadges is public status badges.
+	FeatureBadges = "badges"
+	// FeatureWebhooks is push-to-deploy webhooks.
+	FeatureWebhooks = "webhooks"
+)
+
+// Features lists every feature area.
+var Features = []string{FeatureApps, FeatureStreams, FeatureSystem, FeatureSelfUpdate, FeatureGuests, FeatureShares, FeatureBadges, FeatureWebhooks}
+
+// FeatureFlags is which feature areas are enabled. It is fixed at startup,
+// when the routes are registered.
+type FeatureFlags map[string]bool
+
+// NewFeatureFlags enables every feature area except those disabled.
+func NewFeatureFlags(disabled []string) (FeatureFlags, error) {
+	if err := ValidateFeatures(disabled); err != nil {
+		return nil, err
+	}
+	flags := FeatureFlags{}
+	for _, name := range Features {
+		flags[name] = true
+	}
+	for _, name := range disabled {
+		flags[name] = false
+	}
+	return flags, nil
+}
+
+// ValidateFeatures checks that names are all feature areas.
+func ValidateFeatures(names []string) error {
+	for _, name := range names {
+		known := false
+		for _, feature := range Features {
+			known = known || name == feature
+		}
+		if !known {
+			return fmt.Errorf("unknown feature %q (one of %s)", name, strings.Join(Features, ", "))
+		}
+	}
+	return nil
+}
+
+func (f FeatureFlags) Enabled(name string) bool {
+	return f[name]
+}
+
+// List returns the enabled feature areas, in the order of Features.
+func (f FeatureFlags) List() []string {
+	enabled := []string{}
+	for _, name := range Features {
+		if f[name] {
+			enabled = append(enabled, name)
+		}
+	}
+	return enabled
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// GitPriority orders queued git operations; higher runs first.
+type GitPriority int
+
+const (
+	// GitPriorityBackground is for scheduled work such as update checks.
+	GitPriorityBackground GitPriority = iota
+	// GitPriorityInteractive is for operations a user is waiting on.
+	GitPriorityInteractive
+)
+
+// maxGitProcesses caps concurrent git subprocesses across the controller.
+const maxGitProcesses = 3
+
+// gitPool is a priority semaphore: at most size operations hold a slot, and
+// freed slots go to the highest-priority waiter, FIFO within a priority.
+type gitPool struct {
+	size    int
+	mu      sync.Mutex
+	running int
+	waiters []*gitWaiter
+	ops     map[string]*gitOpTotals
+}
+
+type gitWaiter struct {
+	priority GitPriority
+	ready    chan struct{}
+}
+
+type gitOpTotals struct {
+	count    int64
+	failures int64
+	queued   time.Duration
+	ran      time.Duration
+	maxRun   time.Duration
+}
+
+// GitStats is a snapshot of the git pool for the system info endpoint.
+type GitStats struct {
+	MaxProcesses int                   `json:"maxProcesses"`
+	Running      int                   `json:"running"`
+	Queued       int                   `json:"queued"`
+	Operations   map[string]GitOpStats `json:"operations"`
+}
+
+type GitOpStats struct {
+	Count      int64 `json:"count"`
+	Failures   int64 `json:"failures"`
+	AvgQueueMs int64 `json:"avgQueueMs"`
+	AvgRunMs   int64 `json:"avgRunMs"`
+	MaxRunMs   int64 `json:"maxRunMs"`
+}
+
+func newGitPool(size int) *gitPool {
+	return &gitPool{
+		size: size,
+		ops:  make(map[string]*gitOpTotals),
+	}
+}
+
+// acquire blocks until a slot is free or ctx is done. On success the caller
+// must call release.
+func (p *gitPool) acquire(ctx context.Context, priority GitPriority) error {
+	p.mu.Lock()
+	if p.running < p.size && len(p.waiters) == 0 {
+		p.running++
+		p.mu.Unlock()
+		return nil
+	}
+
+	w := &gitWaiter{priority: priority, ready: make(chan struct{})}
+	// Insert after every waiter of equal or higher priority.
+	i := len(p.waiters)
+	for i > 0 && p.waiters[i-1].priority < priority {
+		i--
+	}
+	p.waiters = append(p.waiters, nil)
+	copy(p.waiters[i+1:], p.waiters[i:])
+	p.waiters[i] = w
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, other := range p.waiters {
+			if other == w {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// Handed a slot while giving up; pass it on.
+		p.releaseLocked()
+		return ctx.Err()
+	}
+}
+
+func (p *gitPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.releaseLocked()
+}
+
+// releaseLocked hands the slot straight to the next waiter, so running only
+// drops when nobody is queued.
+func (p *gitPool) releaseLocked() {
+	if len(p.waiters) > 0 {
+		next := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		close(next.ready)
+		return
+	}
+	p.running--
+}
+
+func (p *gitPool) record(op string, queued, ran time.Duration, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	totals := p.ops[op]
+	if totals == nil {
+		totals = &gitOpTotals{}
+		p.ops[op] = totals
+	}
+	totals.count++
+	if failed {
+		totals.failures++
+	}
+	totals.queued += queued
+	totals.ran += ran
+	if ran > totals.maxRun {
+		totals.maxRun = ran
+	}
+}
+
+func (p *gitPool) stats() GitStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := GitStats{
+		MaxProcesses: p.size,
+		Running:      p.running,
+		Queued:       len(p.waiters),
+		Operations:   make(map[string]GitOpStats, len(p.ops)),
+	}
+	for op, totals := range p.ops {
+		stats.Operations[op] = GitOpStats{
+			Count:      totals.count,
+			Failures:   totals.failures,
+			AvgQueueMs: (totals.queued / time.Duration(totals.count)).Milliseconds(),
+			AvgRunMs:   (totals.ran / time.Duration(totals.count)).Milliseconds(),
+			MaxRunMs:   totals.maxRun.Milliseconds(),
+		}
+	}
+	return stats
+}
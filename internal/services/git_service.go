@@ -1,30 +1,150 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/fs"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"nas-controller/internal/models"
 )
 
+// gitLocalTimeout bounds git commands that don't touch the network
+// (rev-parse, reset). Clones and fetches are bounded by
+// Timeouts.GitOperation, so a dead remote or a prompt nobody will answer
+// can't hold a pool slot forever.
+const gitLocalTimeout = 30 * time.Second
+
+// gitWaitDelay is how long a killed git command's output is waited on.
+const gitWaitDelay = time.Second
+
 type GitService struct {
 	dataDir  string
 	reposDir string
+	pool     *gitPool
+	settings *SettingsService
+
+	// repoLocks serializes operations per repo, which is also what makes
+	// it safe to treat any lock file found at the start of one as stale.
+	repoLocksMu sync.Mutex
+	repoLocks   map[string]*sync.Mutex
 }
 
-func NewGitService(dataDir string) *GitService {
+func NewGitService(dataDir string, settings *SettingsService) *GitService {
 	reposDir := filepath.Join(dataDir, "repos")
 	os.MkdirAll(reposDir, 0755)
 
 	return &GitService{
-		dataDir:  dataDir,
-		reposDir: reposDir,
+		dataDir:   dataDir,
+		reposDir:  reposDir,
+		pool:      newGitPool(maxGitProcesses),
+		settings:  settings,
+		repoLocks: make(map[string]*sync.Mutex),
+	}
+}
+
+// lockRepo takes the per-repo lock and clears lock files a previous git
+// process left behind when it was killed (timeout, cancelled request,
+// controller restart). Call the returned func to unlock.
+func (s *GitService) lockRepo(repoPath string) func() {
+	s.repoLocksMu.Lock()
+	mu, ok := s.repoLocks[repoPath]
+	if !ok {
+		mu = &sync.Mutex{}
+		s.repoLocks[repoPath] = mu
+	}
+	s.repoLocksMu.Unlock()
+
+	mu.Lock()
+	removeStaleGitLocks(repoPath)
+	return mu.Unlock
+}
+
+func removeStaleGitLocks(repoPath string) {
+	gitDir := filepath.Join(repoPath, ".git")
+	for _, name := range []string{"index.lock", "HEAD.lock", "shallow.lock", "config.lock", "packed-refs.lock"} {
+		lockPath := filepath.Join(gitDir, name)
+		if err := os.Remove(lockPath); err == nil {
+			log.Printf("Removed stale git lock %s", lockPath)
+		}
+	}
+	filepath.WalkDir(filepath.Join(gitDir, "refs"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".lock") {
+			if os.Remove(path) == nil {
+				log.Printf("Removed stale git lock %s", path)
+			}
+		}
+		return nil
+	})
+}
+
+// Stats reports git pool occupancy and per-operation latency.
+func (s *GitService) Stats() GitStats {
+	return s.pool.stats()
+}
+
+// run executes git through the pool and returns its stdout. op names the
+// operation in the stats. Failures include git's stderr in the error.
+func (s *GitService) run(ctx context.Context, op string, priority GitPriority, timeout time.Duration, args ...string) ([]byte, error) {
+	queuedAt := time.Now()
+	if err := s.pool.acquire(ctx, priority); err != nil {
+		return nil, fmt.Errorf("cancelled while queued: %v", err)
+	}
+	defer s.pool.release()
+	startedAt := time.Now()
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(runCtx, "git", args...)
+	cmd.Env = s.gitEnv()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// On timeout, kill git's remote helpers (git-remote-https, ssh) along
+	// with it: left alone they hold the connection and the output pipes
+	// until the remote answers.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = gitWaitDelay
+	err := cmd.Run()
+
+	s.pool.record(op, startedAt.Sub(queuedAt), time.Since(startedAt), err != nil)
+
+	if err != nil {
+		if runCtx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s, output: %s", timeout, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("%v, output: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// gitEnv makes git fail instead of prompting for credentials: there is no
+// terminal to answer, and a prompt would hang until the timeout. The proxy
+// settings are read on every call, so a change applies to the next command;
+// later entries override the controller's own environment.
+func (s *GitService) gitEnv() []string {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if os.Getenv("GIT_SSH_COMMAND") == "" {
+		env = append(env, "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	}
+	for name, value := range s.settings.Get().ProxyEnv() {
+		env = append(env, name+"="+value)
 	}
+	return env
 }
 
 // allowedLocalPathPrefix is the only host path the tool is permitted to use
@@ -33,11 +153,13 @@ func NewGitService(dataDir string) *GitService {
 const allowedLocalPathPrefix = "/mnt/user/3_secret/"
 
 // IsLocalPath reports whether repoURL is a permitted local filesystem path.
+// The path is cleaned first, so .. can't climb out of the prefix.
 func IsLocalPath(repoURL string) bool {
-	return strings.HasPrefix(repoURL, allowedLocalPathPrefix)
+	return strings.HasPrefix(repoURL, allowedLocalPathPrefix) &&
+		strings.HasPrefix(filepath.Clean(repoURL)+"/", allowedLocalPathPrefix)
 }
 
-func (s *GitService) CloneRepo(repoURL string, branch string) (*models.CloneResult, error) {
+func (s *GitService) CloneRepo(ctx context.Context, repoURL string, branch string) (*models.CloneResult, error) {
 	if IsLocalPath(repoURL) {
 		return s.validateLocalPath(repoURL)
 	}
@@ -47,17 +169,24 @@ func (s *GitService) CloneRepo(repoURL string, branch string) (*models.CloneResu
 	if slug == "" {
 		return nil, fmt.Errorf("invalid repository URL")
 	}
+	if err := ValidateSlug(slug); err != nil {
+		return nil, err
+	}
 
 	repoPath := filepath.Join(s.reposDir, slug)
+	defer s.lockRepo(repoPath)()
 
 	// Remove existing repo if exists
 	os.RemoveAll(repoPath)
 
 	// Clone the repository
-	cmd := exec.Command("git", "clone", "--branch", branch, "--depth", "1", repoURL, repoPath)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("git clone failed: %s, output: %s", err, string(output))
+	if _, err := s.run(ctx, "clone", GitPriorityInteractive, s.settings.Get().Timeouts().GitOperation,
+		"clone", "--branch", branch, "--depth", "1", repoURL, repoPath); err != nil {
+		if ctx.Err() != nil {
+			// Don't leave a half-written clone for the next caller to trip on
+			os.RemoveAll(repoPath)
+		}
+		return nil, fmt.Errorf("git clone failed: %v", err)
 	}
 
 	// Check for Dockerfile
@@ -79,18 +208,14 @@ func (s *GitService) CloneRepo(repoURL string, branch string) (*models.CloneResu
 		}
 	}
 
-	if !hasDockerfile {
+	composeFile := FindComposeFile(repoPath)
+	if !hasDockerfile && composeFile == "" {
 		os.RemoveAll(repoPath)
 		return nil, fmt.Errorf("no Dockerfile found in repository. Please add a Dockerfile to your repo")
 	}
 
 	// Read manifest if exists
-	var manifest *models.AppManifest
-	manifestPath := filepath.Join(repoPath, "nas-controller.json")
-	if data, err := os.ReadFile(manifestPath); err == nil {
-		manifest = &models.AppManifest{}
-		json.Unmarshal(data, manifest)
-	}
+	manifest := s.ReadManifest(repoPath)
 
 	// Determine name
 	name := slug
@@ -111,6 +236,11 @@ func (s *GitService) CloneRepo(repoURL string, branch string) (*models.CloneResu
 		Manifest:       manifest,
 		SuggestedPort:  80,
 	}
+	if err := readComposeServices(result, repoPath, composeFile); err != nil {
+		os.RemoveAll(repoPath)
+		return nil, err
+	}
+	readBuildTargets(result, repoPath)
 
 	if manifest != nil && manifest.DefaultPort > 0 {
 		result.SuggestedPort = manifest.DefaultPort
@@ -126,7 +256,10 @@ func (s *GitService) validateLocalPath(localPath string) (*models.CloneResult, e
 		return nil, fmt.Errorf("local path does not exist: %s", localPath)
 	}
 
-	slug := strings.ToLower(filepath.Base(localPath))
+	slug := NormalizeSlug(filepath.Base(localPath))
+	if err := ValidateSlug(slug); err != nil {
+		return nil, err
+	}
 
 	dockerfilePath := "./Dockerfile"
 	hasDockerfile := false
@@ -142,15 +275,12 @@ func (s *GitService) validateLocalPath(localPath string) (*models.CloneResult, e
 			break
 		}
 	}
-	if !hasDockerfile {
+	composeFile := FindComposeFile(localPath)
+	if !hasDockerfile && composeFile == "" {
 		return nil, fmt.Errorf("no Dockerfile found in %s", localPath)
 	}
 
-	var manifest *models.AppManifest
-	if data, err := os.ReadFile(filepath.Join(localPath, "nas-controller.json")); err == nil {
-		manifest = &models.AppManifest{}
-		json.Unmarshal(data, manifest)
-	}
+	manifest := s.ReadManifest(localPath)
 
 	name, description := slug, ""
 	if manifest != nil && manifest.Name != "" {
@@ -164,54 +294,88 @@ func (s *GitService) validateLocalPath(localPath string) (*models.CloneResult, e
 		Slug:           slug,
 		Name:           name,
 		Description:    description,
-		HasDockerfile:  true,
+		HasDockerfile:  hasDockerfile,
 		DockerfilePath: dockerfilePath,
 		Manifest:       manifest,
 		SuggestedPort:  80,
 	}
+	if err := readComposeServices(result, localPath, composeFile); err != nil {
+		return nil, err
+	}
+	readBuildTargets(result, localPath)
 	if manifest != nil && manifest.DefaultPort > 0 {
 		result.SuggestedPort = manifest.DefaultPort
 	}
 	return result, nil
 }
 
-func (s *GitService) PullRepo(slug string, branch string) (string, error) {
+// ReadManifest returns the nas-controller.json found at the root of repoPath,
+// or nil if the repo doesn't ship one.
+func (s *GitService) ReadManifest(repoPath string) *models.AppManifest {
+	data, err := os.ReadFile(filepath.Join(repoPath, "nas-controller.json"))
+	if err != nil {
+		return nil
+	}
+	manifest := &models.AppManifest{}
+	json.Unmarshal(data, manifest)
+	return manifest
+}
+
+// PullRepo fetches branch and resets the checkout to it. Modified files
+// in the checkout are reported in the result and, with preserve, stashed
+// first; otherwise the reset discards them.
+func (s *GitService) PullRepo(ctx context.Context, slug string, branch string, preserve bool) (*PullResult, error) {
 	repoPath := filepath.Join(s.reposDir, slug)
 
 	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("repository not found")
+		return nil, fmt.Errorf("repository not found")
 	}
+	defer s.lockRepo(repoPath)()
 
 	// Fetch and reset to origin
-	cmd := exec.Command("git", "-C", repoPath, "fetch", "origin", branch)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("git fetch failed: %s, output: %s", err, string(output))
+	if _, err := s.run(ctx, "fetch", GitPriorityInteractive, s.settings.Get().Timeouts().GitOperation,
+		"-C", repoPath, "fetch", "origin", branch); err != nil {
+		return nil, fmt.Errorf("git fetch failed: %v", err)
 	}
 
-	cmd = exec.Command("git", "-C", repoPath, "reset", "--hard", fmt.Sprintf("origin/%s", branch))
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("git reset failed: %s, output: %s", err, string(output))
+	result := &PullResult{}
+	changes, err := s.localChanges(ctx, repoPath, GitPriorityInteractive)
+	if err != nil {
+		return nil, err
+	}
+	result.LocalChanges = changes
+	if len(changes) > 0 && preserve {
+		if result.Stash, err = s.stashLocalChanges(ctx, repoPath, branch); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.run(ctx, "reset", GitPriorityInteractive, gitLocalTimeout,
+		"-C", repoPath, "reset", "--hard", fmt.Sprintf("origin/%s", branch)); err != nil {
+		return nil, fmt.Errorf("git reset failed: %v", err)
 	}
 
 	// Get latest commit hash
-	cmd = exec.Command("git", "-C", repoPath, "rev-parse", "HEAD")
-	output, err := cmd.Output()
+	output, err := s.run(ctx, "rev-parse", GitPriorityInteractive, gitLocalTimeout,
+		"-C", repoPath, "rev-parse", "HEAD")
 	if err != nil {
-		return "", fmt.Errorf("failed to get commit hash: %s", err)
+		return nil, fmt.Errorf("failed to get commit hash: %v", err)
 	}
+	result.Commit = strings.TrimSpace(string(output))
 
-	return strings.TrimSpace(string(output)), nil
+	return result, nil
 }
 
 func (s *GitService) GetRepoPath(slug string) string {
 	return filepath.Join(s.reposDir, slug)
 }
 
-func (s *GitService) GetLastCommit(slug string) (string, error) {
+func (s *GitService) GetLastCommit(ctx context.Context, slug string) (string, error) {
 	repoPath := filepath.Join(s.reposDir, slug)
+	defer s.lockRepo(repoPath)()
 
-	cmd := exec.Command("git", "-C", repoPath, "rev-parse", "HEAD")
-	output, err := cmd.Output()
+	output, err := s.run(ctx, "rev-parse", GitPriorityInteractive, gitLocalTimeout,
+		"-C", repoPath, "rev-parse", "HEAD")
 	if err != nil {
 		return "", err
 	}
@@ -221,6 +385,7 @@ func (s *GitService) GetLastCommit(slug string) (string, error) {
 
 func (s *GitService) RemoveRepo(slug string) error {
 	repoPath := filepath.Join(s.reposDir, slug)
+	defer s.lockRepo(repoPath)()
 	return os.RemoveAll(repoPath)
 }
 
@@ -229,7 +394,7 @@ func (s *GitService) extractSlug(repoURL string) string {
 	re := regexp.MustCompile(`github\.com[/:]([^/]+)/([^/.]+)`)
 	matches := re.FindStringSubmatch(repoURL)
 	if len(matches) >= 3 {
-		return strings.ToLower(matches[2])
+		return NormalizeSlug(matches[2])
 	}
 	return ""
 }
@@ -238,32 +403,41 @@ type UpdateCheckResult struct {
 	HasUpdate    bool   `json:"hasUpdate"`
 	LocalCommit  string `json:"localCommit"`
 	RemoteCommit string `json:"remoteCommit"`
+	// LocalChanges are the tracked files modified in the checkout, which
+	// the next pull stashes or discards.
+	LocalChanges []string `json:"localChanges,omitempty"`
 }
 
-func (s *GitService) CheckForUpdates(slug string, branch string) (*UpdateCheckResult, error) {
+func (s *GitService) CheckForUpdates(ctx context.Context, slug string, branch string) (*UpdateCheckResult, error) {
 	repoPath := filepath.Join(s.reposDir, slug)
 
 	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
 		return nil, fmt.Errorf("repository not found")
 	}
+	defer s.lockRepo(repoPath)()
 
 	// Get local HEAD
-	cmd := exec.Command("git", "-C", repoPath, "rev-parse", "HEAD")
-	localOutput, err := cmd.Output()
+	localOutput, err := s.run(ctx, "rev-parse", GitPriorityBackground, gitLocalTimeout,
+		"-C", repoPath, "rev-parse", "HEAD")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get local commit: %v", err)
 	}
 	localCommit := strings.TrimSpace(string(localOutput))
 
+	changes, err := s.localChanges(ctx, repoPath, GitPriorityBackground)
+	if err != nil {
+		return nil, err
+	}
+
 	// Fetch remote
-	cmd = exec.Command("git", "-C", repoPath, "fetch", "origin", branch)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("git fetch failed: %s", string(output))
+	if _, err := s.run(ctx, "fetch", GitPriorityBackground, s.settings.Get().Timeouts().GitOperation,
+		"-C", repoPath, "fetch", "origin", branch); err != nil {
+		return nil, fmt.Errorf("git fetch failed: %v", err)
 	}
 
 	// Get remote HEAD
-	cmd = exec.Command("git", "-C", repoPath, "rev-parse", fmt.Sprintf("origin/%s", branch))
-	remoteOutput, err := cmd.Output()
+	remoteOutput, err := s.run(ctx, "rev-parse", GitPriorityBackground, gitLocalTimeout,
+		"-C", repoPath, "rev-parse", fmt.Sprintf("origin/%s", branch))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get remote commit: %v", err)
 	}
@@ -273,6 +447,7 @@ func (s *GitService) CheckForUpdates(slug string, branch string) (*UpdateCheckRe
 		HasUpdate:    localCommit != remoteCommit,
 		LocalCommit:  localCommit[:8],
 		RemoteCommit: remoteCommit[:8],
+		LocalChanges: changes,
 	}, nil
 }
 
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// TimezoneOff in Settings.Timezone stops TZ being passed to containers.
+const TimezoneOff = "off"
+
+// timezonePattern is an IANA zone name such as America/New_York or UTC.
+var timezonePattern = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+
+// DetectTimezone returns the controller's own timezone: its TZ variable
+// (which Unraid sets on every container), else the zone /etc/localtime
+// links to, else /etc/timezone. It is "" if none of them says.
+var DetectTimezone = sync.OnceValue(func() string {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" && timezonePattern.MatchString(tz) {
+		return tz
+	}
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		if _, zone, ok := strings.Cut(target, "zoneinfo/"); ok && timezonePattern.MatchString(zone) {
+			return zone
+		}
+	}
+	if data, err := os.ReadFile("/etc/timezone"); err == nil {
+		if zone := strings.TrimSpace(string(data)); timezonePattern.MatchString(zone) {
+			return zone
+		}
+	}
+	return ""
+})
+
+// ValidateGlobalEnv checks the global environment and timezone settings.
+func ValidateGlobalEnv(env map[string]string, timezone string) error {
+	for key := range env {
+		if !envKeyPattern.MatchString(key) {
+			return fmt.Errorf("globalEnv: invalid key %q", key)
+		}
+	}
+	if timezone != "" && timezone != TimezoneOff && !timezonePattern.MatchString(timezone) {
+		return fmt.Errorf("timezone must be a zone name such as America/New_York, or %q", TimezoneOff)
+	}
+	return nil
+}
+
+// EffectiveTimezone is the TZ containers get: the timezone setting, or
+// the detected one when it's empty. It is "" when off or unknown.
+func (s Settings) EffectiveTimezone() string {
+	switch s.Timezone {
+	case TimezoneOff:
+		return ""
+	case "":
+		return DetectTimezone()
+	default:
+		return s.Timezone
+	}
+}
+
+// SharedEnv is what every app's container environment starts from: TZ,
+// then the global environment over it. Apps' own variables override both.
+func (s Settings) SharedEnv() map[string]string {
+	env := make(map[string]string, len(s.GlobalEnv)+1)
+	if tz := s.EffectiveTimezone(); tz != "" {
+		env["TZ"] = tz
+	}
+	for k, v := range s.GlobalEnv {
+		env[k] = v
+	}
+	return env
+}
+
+// sharedEnvHash fingerprints a shared environment for the label that
+// records which one a container was created with. It is "" for none.
+func sharedEnvHash(env map[string]string) string {
+	if len(env) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\x00", k, env[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// StaleEnvApp is a running app whose container has an outdated shared
+// environment.
+type StaleEnvApp struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// StaleEnvApps returns the running apps whose containers were created with
+// a different shared environment (TZ and global env) than the current one,
+// and so need a restart to pick it up.
+func (m *AppManager) StaleEnvApps(ctx context.Context) ([]StaleEnvApp, error) {
+	apps, err := m.db.GetAllApps()
+	if err != nil {
+		return nil, err
+	}
+	current := sharedEnvHash(m.settings.Get().SharedEnv())
+
+	stale := []StaleEnvApp{}
+	for _, app := range apps {
+		if app.Status != models.StatusRunning {
+			continue
+		}
+		c, _ := m.dockerClient.GetAppContainer(ctx, app.ID, 1)
+		if c == nil {
+			// Containers from before labels predate the shared env too
+			if current != "" {
+				stale = append(stale, StaleEnvApp{ID: app.ID, Name: app.Name})
+			}
+			continue
+		}
+		if c.Labels[docker.SharedEnvLabel] != current {
+			stale = append(stale, StaleEnvApp{ID: app.ID, Name: app.Name})
+		}
+	}
+	return stale, nil
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+var nvidiaCapabilities = map[string]bool{
+	"all": true, "compute": true, "compat32": true, "graphics": true,
+	"utility": true, "video": true, "display": true,
+}
+
+// validateGPU checks an app's GPU settings.
+func validateGPU(gpu, capabilities string, runtime bool) error {
+	switch gpu {
+	case "", docker.GPUNvidia, docker.GPUIntel:
+	default:
+		return fmt.Errorf("gpu must be nvidia or intel")
+	}
+	if gpu != docker.GPUNvidia && (capabilities != "" || runtime) {
+		return fmt.Errorf("gpuCapabilities and gpuRuntime only apply to nvidia")
+	}
+	for _, c := range splitCapabilities(capabilities) {
+		if !nvidiaCapabilities[c] {
+			return fmt.Errorf("unknown gpu capability %q", c)
+		}
+	}
+	return nil
+}
+
+func splitCapabilities(capabilities string) []string {
+	var out []string
+	for _, c := range strings.Split(capabilities, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func gpuConfig(app *models.App) docker.GPUConfig {
+	return docker.GPUConfig{
+		Vendor:       app.GPU,
+		Capabilities: splitCapabilities(app.GPUCapabilities),
+		Runtime:      app.GPURuntime,
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nas-controller/internal/database"
+	"nas-controller/internal/models"
+)
+
+// MaxGuestExpiry bounds how long a guest code (and its sessions) can live.
+const MaxGuestExpiry = 30 * 24 * time.Hour
+
+// guestCodeAlphabet leaves out characters that are easy to misread when a
+// code is read out or typed from a phone (0/O, 1/I).
+const guestCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// guestCodeLength is 50 bits of randomness, shown as XXXXX-XXXXX.
+const guestCodeLength = 10
+
+// GuestService issues time-limited guest codes. Sessions created from a code
+// may only use its permissions on its apps; the auth middleware enforces
+// that through Allows.
+type GuestService struct {
+	db *database.DB
+}
+
+func NewGuestService(db *database.DB) *GuestService {
+	return &GuestService{db: db}
+}
+
+// Create issues a guest code and returns it with the code to hand out. The
+// code is not recoverable afterwards.
+func (s *GuestService) Create(name string, appIDs []string, permissions []string, expiresAt time.Time, actor string) (*models.GuestCode, string, error) {
+	if len(appIDs) == 0 {
+		return nil, "", fmt.Errorf("appIds cannot be empty")
+	}
+	for _, id := range appIDs {
+		if _, err := s.db.GetApp(id); err != nil {
+			return nil, "", fmt.Errorf("app %q not found", id)
+		}
+	}
+	if len(permissions) == 0 {
+		return nil, "", fmt.Errorf("permissions cannot be empty")
+	}
+	for _, p := range permissions {
+		if p != models.GuestStart && p != models.GuestStop && p != models.GuestLogs {
+			return nil, "", fmt.Errorf("unknown permission %q (must be %s, %s or %s)", p, models.GuestStart, models.GuestStop, models.GuestLogs)
+		}
+	}
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return nil, "", fmt.Errorf("expiresAt must be in the future")
+	}
+	if expiresAt.Sub(now) > MaxGuestExpiry {
+		return nil, "", fmt.Errorf("expiresAt cannot be more than %s away", MaxGuestExpiry)
+	}
+
+	raw := make([]byte, guestCodeLength)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate code: %v", err)
+	}
+	code := make([]byte, guestCodeLength)
+	for i, b := range raw {
+		code[i] = guestCodeAlphabet[int(b)%len(guestCodeAlphabet)]
+	}
+
+	guest := &models.GuestCode{
+		ID:          guestID(string(code)),
+		Name:        name,
+		AppIDs:      appIDs,
+		Permissions: permissions,
+		Actor:       actor,
+		CreatedAt:   now,
+		ExpiresAt:   expiresAt,
+	}
+	if err := s.db.CreateGuestCode(guest); err != nil {
+		return nil, "", fmt.Errorf("failed to save guest code: %v", err)
+	}
+
+	s.db.CleanupExpiredGuestCodes()
+	return guest, string(code[:5]) + "-" + string(code[5:]), nil
+}
+
+// Redeem starts a guest session for code. The session expires with the
+// code.
+func (s *GuestService) Redeem(code, token, csrfToken string) (*models.GuestCode, error) {
+	guest, err := s.db.GetGuestCode(guestID(code))
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired guest code")
+	}
+	if err := s.db.CreateGuestSession(token, csrfToken, guest.ID, guest.ExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+	return guest, nil
+}
+
+// ErrSessionGone is returned by ForSession for a session that no longer
+// exists, such as one that expired since it was validated.
+var ErrSessionGone = errors.New("invalid or expired session")
+
+// ForSession returns the guest code behind a session. isGuest is false for
+// full sessions; guest is nil if the session's code has been revoked. A
+// session is only taken as full when the lookup says so: any error means
+// the caller must refuse the request.
+func (s *GuestService) ForSession(token string) (guest *models.GuestCode, isGuest bool, err error) {
+	id, err := s.db.GetSessionGuestID(token)
+	if err == sql.ErrNoRows {
+		return nil, false, ErrSessionGone
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up session: %v", err)
+	}
+	if id == "" {
+		return nil, false, nil
+	}
+	guest, err = s.db.GetGuestCode(id)
+	if err == sql.ErrNoRows {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to look up guest code: %v", err)
+	}
+	return guest, true, nil
+}
+
+// Allows reports whether guest may use permission on appID. An empty
+// permission only requires access to the app.
+func (s *GuestService) Allows(guest *models.GuestCode, permission, appID string) bool {
+	if !containsString(guest.AppIDs, appID) {
+		return false
+	}
+	return permission == "" || containsString(guest.Permissions, permission)
+}
+
+// Audit logs an action taken (or refused) under a guest code.
+func (s *GuestService) Audit(ctx context.Context, guest *models.GuestCode, action, appID string, allowed bool) {
+	outcome := "allowed"
+	if !allowed {
+		outcome = "denied"
+	}
+	logf(ctx, "guest %s (%s): %s on app %s %s", GuestActor(guest), guest.Name, action, appID, outcome)
+}
+
+func (s *GuestService) List() ([]*models.GuestCode, error) {
+	return s.db.GetGuestCodes()
+}
+
+func (s *GuestService) Revoke(id string) (bool, error) {
+	return s.db.DeleteGuestCode(id)
+}
+
+// GuestActor identifies a guest code in actor fields and logs.
+func GuestActor(guest *models.GuestCode) string {
+	return "guest:" + guest.ID[:8]
+}
+
+// guestID hashes a code, ignoring case and the separator so a code read
+// out loud still works.
+func guestID(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"nas-controller/internal/database"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+const (
+	// healthHistoryLimit is how many probe results are kept per app.
+	healthHistoryLimit = 50
+	// healthOutputLimit truncates each probe's output.
+	healthOutputLimit = 512
+	// healthRetryDelay is how long to wait before resubscribing after the
+	// Docker event stream drops.
+	healthRetryDelay = 10 * time.Second
+)
+
+// HealthMonitor keeps the recent HEALTHCHECK history of each app's
+// container in memory. It follows Docker's health_status events, which only
+// fire on transitions, and merges in Docker's own probe log (the last five
+// probes) whenever a transition happens or the history is read, so nothing
+// is polled.
+type HealthMonitor struct {
+	db           *database.DB
+	dockerClient *docker.Client
+
+	mu     sync.Mutex
+	health map[string]*models.AppHealth
+}
+
+func NewHealthMonitor(db *database.DB, dockerClient *docker.Client) *HealthMonitor {
+	return &HealthMonitor{
+		db:           db,
+		dockerClient: dockerClient,
+		health:       make(map[string]*models.AppHealth),
+	}
+}
+
+// Run follows health events until ctx is done, resubscribing whenever the
+// event stream drops.
+func (h *HealthMonitor) Run(ctx context.Context) {
+	for {
+		err := h.dockerClient.WatchHealth(ctx, func(event docker.HealthEvent) {
+			h.handleEvent(ctx, event)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Health event stream ended: %v; resubscribing in %s", err, healthRetryDelay)
+		select {
+		case <-time.After(healthRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *HealthMonitor) handleEvent(ctx context.Context, event docker.HealthEvent) {
+	app := h.appForContainer(event.ContainerID, event.ContainerName)
+	if app == nil {
+		return
+	}
+	state, _ := h.dockerClient.ContainerHealth(ctx, event.ContainerID)
+
+	h.mu.Lock()
+	previous := h.entry(app.ID).Status
+	h.merge(app.ID, event.Status, state)
+	h.mu.Unlock()
+
+	if event.ContainerID == app.ContainerID && app.Health != event.Status {
+		app.Health = event.Status
+		h.db.UpdateApp(app)
+	}
+
+	// There is no notification channel yet; the controller log is where
+	// transitions show up.
+	switch {
+	case event.Status == docker.HealthUnhealthy && previous != docker.HealthUnhealthy:
+		log.Printf("App %s is unhealthy (%s)", app.Slug, lastProbeOutput(state))
+	case event.Status == docker.HealthHealthy && previous == docker.HealthUnhealthy:
+		log.Printf("App %s is healthy again", app.Slug)
+	}
+}
+
+// Get returns the app's current health and probe history.
+func (h *HealthMonitor) Get(ctx context.Context, app *models.App) *models.AppHealth {
+	status := docker.HealthNone
+	var state *docker.HealthState
+	if app.ContainerID != "" {
+		state, _ = h.dockerClient.ContainerHealth(ctx, app.ContainerID)
+		if state != nil {
+			status = state.Status
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.merge(app.ID, status, state)
+	entry := h.entry(app.ID)
+	out := *entry
+	out.Probes = append([]models.HealthProbe{}, entry.Probes...)
+	return &out
+}
+
+// Forget drops the app's history, e.g. when it is deleted.
+func (h *HealthMonitor) Forget(appID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.health, appID)
+}
+
+// entry returns the app's history, creating it. Callers hold mu.
+func (h *HealthMonitor) entry(appID string) *models.AppHealth {
+	entry := h.health[appID]
+	if entry == nil {
+		entry = &models.AppHealth{Status: docker.HealthNone, Probes: []models.HealthProbe{}}
+		h.health[appID] = entry
+	}
+	return entry
+}
+
+// merge records status and appends the probes from Docker's log that are
+// newer than the last one kept. Callers hold mu.
+func (h *HealthMonitor) merge(appID, status string, state *docker.HealthState) {
+	entry := h.entry(appID)
+	if entry.Status != status {
+		now := time.Now()
+		entry.Status = status
+		entry.Since = &now
+	}
+	if state == nil {
+		entry.FailingStreak = 0
+		return
+	}
+	entry.FailingStreak = state.FailingStreak
+
+	var last time.Time
+	if n := len(entry.Probes); n > 0 {
+		last = entry.Probes[n-1].At
+	}
+	for _, result := range state.Probes {
+		if !result.Start.After(last) {
+			continue
+		}
+		output := result.Output
+		if len(output) > healthOutputLimit {
+			output = output[:healthOutputLimit] + "..."
+		}
+		entry.Probes = append(entry.Probes, models.HealthProbe{
+			At:        result.Start,
+			Success:   result.ExitCode == 0,
+			LatencyMs: result.End.Sub(result.Start).Milliseconds(),
+			ExitCode:  result.ExitCode,
+			Output:    output,
+		})
+	}
+	if len(entry.Probes) > healthHistoryLimit {
+		entry.Probes = entry.Probes[len(entry.Probes)-healthHistoryLimit:]
+	}
+}
+
+// appForContainer finds the app whose main container this is.
+func (h *HealthMonitor) appForContainer(containerID, containerName string) *models.App {
+	apps, err := h.db.GetAllApps()
+	if err != nil {
+		return nil
+	}
+	for _, app := range apps {
+		if (containerID != "" && app.ContainerID == containerID) || (containerName != "" && app.ContainerName == containerName) {
+			return app
+		}
+	}
+	return nil
+}
+
+func lastProbeOutput(state *docker.HealthState) string {
+	if state == nil || len(state.Probes) == 0 {
+		return "no probe output"
+	}
+	output := state.Probes[len(state.Probes)-1].Output
+	if len(output) > 200 {
+		output = output[:200] + "..."
+	}
+	return output
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// minHealthcheckInterval keeps a typo like "1ms" from hammering the app.
+const minHealthcheckInterval = time.Second
+
+// normalizeHealthcheck trims hc, returning nil for an empty command (no
+// override), and validates the rest.
+func normalizeHealthcheck(hc *models.Healthcheck) (*models.Healthcheck, error) {
+	if hc == nil || strings.TrimSpace(hc.Command) == "" {
+		return nil, nil
+	}
+	out := &models.Healthcheck{
+		Command:  strings.TrimSpace(hc.Command),
+		Interval: strings.TrimSpace(hc.Interval),
+		Retries:  hc.Retries,
+	}
+	if out.Interval != "" {
+		d, err := time.ParseDuration(out.Interval)
+		if err != nil || d < minHealthcheckInterval {
+			return nil, fmt.Errorf("healthcheck interval must be a duration of at least %s, such as 30s", minHealthcheckInterval)
+		}
+	}
+	if out.Retries < 0 {
+		return nil, fmt.Errorf("healthcheck retries cannot be negative")
+	}
+	return out, nil
+}
+
+func healthcheckConfig(app *models.App) *docker.HealthcheckConfig {
+	if app.Healthcheck == nil {
+		return nil
+	}
+	interval, _ := time.ParseDuration(app.Healthcheck.Interval)
+	return &docker.HealthcheckConfig{
+		Command:  app.Healthcheck.Command,
+		Interval: interval,
+		Retries:  app.Healthcheck.Retries,
+	}
+}
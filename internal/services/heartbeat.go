@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nas-controller/internal/database"
+)
+
+const (
+	// heartbeatInterval is how often the heartbeat checks the controller's
+	// subsystems and rewrites the watchdog file.
+	heartbeatInterval = 30 * time.Second
+	// heartbeatCheckTimeout is how long a subsystem check may take before
+	// the beat counts it as missed.
+	heartbeatCheckTimeout = 10 * time.Second
+	// heartbeatMaxMisses is how many beats in a row a subsystem may miss
+	// before the heartbeat raises an alert and tries to recover it.
+	heartbeatMaxMisses = 3
+)
+
+// Subsystems the heartbeat checks.
+const (
+	SubsystemEvents   = "events"
+	SubsystemBuilds   = "builds"
+	SubsystemDatabase = "database"
+)
+
+// SubsystemHealth is one subsystem's state as of the last beat.
+type SubsystemHealth struct {
+	OK bool `json:"ok"`
+	// Misses counts the beats in a row the subsystem has failed.
+	Misses     int        `json:"misses"`
+	Error      string     `json:"error,omitempty"`
+	LastOK     *time.Time `json:"lastOk,omitempty"`
+	Recoveries int        `json:"recoveries"`
+}
+
+// HeartbeatStatus is what the heartbeat writes to the watchdog file each
+// beat. A watchdog script treats an old At, or OK false, as the controller
+// being wedged.
+type HeartbeatStatus struct {
+	At         time.Time                   `json:"at"`
+	PID        int                         `json:"pid"`
+	OK         bool                        `json:"ok"`
+	Subsystems map[string]*SubsystemHealth `json:"subsystems"`
+}
+
+// heartbeatCheck is one subsystem the heartbeat checks. recover, if set,
+// tries to get it going again once it has missed heartbeatMaxMisses beats.
+type heartbeatCheck struct {
+	name    string
+	check   func(ctx context.Context) error
+	recover func()
+	// running is set while a check is still out, so a hung one isn't
+	// started again every beat
+	running atomic.Bool
+}
+
+// Heartbeat checks that the Docker event watcher, the build worker and the
+// database are alive every heartbeatInterval and writes the result to the
+// watchdog file, for an Unraid user script to restart the container when
+// the beats stop. A subsystem failing heartbeatMaxMisses beats in a row is
+// logged as an alert and, where possible, restarted.
+type Heartbeat struct {
+	path   string
+	checks []*heartbeatCheck
+
+	mu     sync.Mutex
+	status HeartbeatStatus
+}
+
+func NewHeartbeat(db *database.DB, buildService *BuildService, exitMonitor *ExitMonitor, path string) *Heartbeat {
+	h := &Heartbeat{
+		path: path,
+		checks: []*heartbeatCheck{
+			{
+				name: SubsystemEvents,
+				check: func(ctx context.Context) error {
+					if !exitMonitor.Connected() {
+						return fmt.Errorf("not subscribed to Docker events")
+					}
+					return nil
+				},
+				recover: exitMonitor.Resubscribe,
+			},
+			{
+				name: SubsystemBuilds,
+				check: func(ctx context.Context) error {
+					return buildService.checkWorker()
+				},
+				recover: buildService.releaseStalledTurn,
+			},
+			{
+				name: SubsystemDatabase,
+				check: func(ctx context.Context) error {
+					return db.WriteHeartbeat(time.Now())
+				},
+			},
+		},
+		status: HeartbeatStatus{PID: os.Getpid(), Subsystems: map[string]*SubsystemHealth{}},
+	}
+	for _, c := range h.checks {
+		h.status.Subsystems[c.name] = &SubsystemHealth{OK: true}
+	}
+	return h
+}
+
+// Run beats every heartbeatInterval until ctx is done.
+func (h *Heartbeat) Run(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		h.beat(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Status returns the last beat's result.
+func (h *Heartbeat) Status() HeartbeatStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := h.status
+	status.Subsystems = make(map[string]*SubsystemHealth, len(h.status.Subsystems))
+	for name, health := range h.status.Subsystems {
+		copied := *health
+		status.Subsystems[name] = &copied
+	}
+	return status
+}
+
+func (h *Heartbeat) beat(ctx context.Context) {
+	results := make([]error, len(h.checks))
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = h.runCheck(ctx, c)
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	var recovering []*heartbeatCheck
+	h.mu.Lock()
+	h.status.At = now
+	h.status.OK = true
+	for i, c := range h.checks {
+		health := h.status.Subsystems[c.name]
+		if results[i] == nil {
+			if health.Misses >= heartbeatMaxMisses {
+				log.Printf("Heartbeat: %s recovered after %d missed beats", c.name, health.Misses)
+			}
+			health.OK, health.Misses, health.Error = true, 0, ""
+			health.LastOK = &now
+			continue
+		}
+		health.OK = false
+		health.Misses++
+		health.Error = results[i].Error()
+		h.status.OK = false
+		if health.Misses == heartbeatMaxMisses {
+			// There is no notification channel yet; the controller log is
+			// where alerts show up.
+			log.Printf("========================================")
+			log.Printf("[alert:watchdog] %s missed %d heartbeats: %s", c.name, health.Misses, health.Error)
+			log.Printf("========================================")
+			if c.recover != nil {
+				health.Recoveries++
+				recovering = append(recovering, c)
+			}
+		}
+	}
+	status, _ := json.MarshalIndent(h.status, "", "  ")
+	h.mu.Unlock()
+
+	for _, c := range recovering {
+		log.Printf("Heartbeat: restarting %s", c.name)
+		c.recover()
+	}
+
+	if err := writeFileSynced(h.path, status, 0644); err != nil {
+		log.Printf("Heartbeat: failed to write %s: %v", h.path, err)
+	}
+}
+
+// runCheck runs c, giving up on it after heartbeatCheckTimeout. A check
+// still out from an earlier beat counts as failed without starting
+// another.
+func (h *Heartbeat) runCheck(ctx context.Context, c *heartbeatCheck) error {
+	if !c.running.CompareAndSwap(false, true) {
+		return fmt.Errorf("check from an earlier beat still hasn't returned")
+	}
+	ctx, cancel := context.WithTimeout(ctx, heartbeatCheckTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		defer c.running.Store(false)
+		done <- c.check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check timed out after %s", heartbeatCheckTimeout)
+	}
+}
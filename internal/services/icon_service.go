@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// forgeIconTimeout bounds the avatar lookup so CreateApp never waits on a
+// slow or unreachable forge.
+const forgeIconTimeout = 3 * time.Second
+
+// maxIconSize caps how much we are willing to cache for a single icon, after
+// re-encoding.
+const maxIconSize = 1 << 20
+
+type IconService struct {
+	store      *IconStore
+	httpClient *http.Client
+}
+
+func NewIconService(dataDir string) *IconService {
+	return &IconService{
+		store:      NewIconStore(filepath.Join(dataDir, "icons")),
+		httpClient: &http.Client{Timeout: forgeIconTimeout},
+	}
+}
+
+func (s *IconService) IconPath(appID string) string {
+	return s.store.Path(appID)
+}
+
+// ResolveIcon caches an icon for app and records where it came from. A
+// manifest icon always wins over a forge avatar; an uploaded icon is never
+// replaced. Failures are silent — the UI falls back to a letter avatar.
+func (s *IconService) ResolveIcon(app *models.App, repoPath string, manifest *models.AppManifest) {
+	if app.IconSource == models.IconSourceUpload {
+		return
+	}
+
+	if manifest != nil && manifest.Icon != "" {
+		if err := s.copyManifestIcon(app.ID, repoPath, manifest.Icon); err == nil {
+			s.setIcon(app, models.IconSourceManifest)
+			return
+		}
+	}
+
+	if app.IconSource != "" {
+		return
+	}
+
+	if err := s.fetchForgeAvatar(app.ID, app.RepoURL); err == nil {
+		s.setIcon(app, models.IconSourceForge)
+	}
+}
+
+func (s *IconService) RemoveIcon(appID string) error {
+	return s.store.Remove(appID)
+}
+
+func (s *IconService) setIcon(app *models.App, source string) {
+	app.Icon = fmt.Sprintf("/api/v1/apps/%s/icon", app.ID)
+	app.IconSource = source
+}
+
+// copyManifestIcon caches the manifest's icon. The path is checked after
+// resolving symlinks, so a repo can't ship a link to a file of the host.
+func (s *IconService) copyManifestIcon(appID string, repoPath string, iconPath string) error {
+	root, err := filepath.EvalSymlinks(repoPath)
+	if err != nil {
+		return err
+	}
+	src, err := filepath.EvalSymlinks(filepath.Join(repoPath, iconPath))
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(src, root+string(os.PathSeparator)) {
+		return fmt.Errorf("icon path escapes repository: %s", iconPath)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.store.Put(appID, f)
+}
+
+var githubOwnerRe = regexp.MustCompile(`github\.com[/:]([^/]+)/`)
+
+func (s *IconService) fetchForgeAvatar(appID string, repoURL string) error {
+	matches := githubOwnerRe.FindStringSubmatch(repoURL)
+	if len(matches) < 2 {
+		return fmt.Errorf("no avatar source for %s", repoURL)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), forgeIconTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://github.com/%s.png", matches[1]), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("avatar fetch returned %s", resp.Status)
+	}
+
+	return s.store.Put(appID, resp.Body)
+}
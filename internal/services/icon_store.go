@@ -0,0 +1,391 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxIconInputSize caps what is read from an upload, manifest or forge
+	// before re-encoding.
+	maxIconInputSize = 20 << 20
+	// maxIconPixels refuses images that would take too much memory to
+	// decode, whatever their file size.
+	maxIconPixels = 40_000_000
+	// maxIconDimension is the largest width or height an icon is stored at.
+	// Dashboards show icons at well under this.
+	maxIconDimension = 256
+	// maxIconStoreSize caps the icons directory as a whole.
+	maxIconStoreSize = 64 << 20
+	// iconSweepInterval is how often icons of deleted apps are swept.
+	iconSweepInterval = 6 * time.Hour
+	// iconSweepGrace spares files written this recently from a sweep: a
+	// new app's icon is stored before its row, and a temp file may still
+	// be being written.
+	iconSweepGrace = time.Hour
+)
+
+// iconTypes maps the extension an icon is stored under to the content type
+// it is served with.
+var iconTypes = map[string]string{
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".ico":  "image/x-icon",
+	".webp": "image/webp",
+}
+
+// IconStore keeps one icon per app as <app id>.<ext>, each identified by a
+// hash of its contents. Images Go can decode are re-encoded as PNG of at
+// most maxIconDimension square; the extension records the format of the
+// others. Writes go through a temp file and a rename, so concurrent readers
+// only ever see a whole icon.
+type IconStore struct {
+	dir string
+
+	mu sync.Mutex
+	// hashes caches each icon's content hash, keyed by app ID, for ETags.
+	hashes map[string]iconHash
+}
+
+type iconHash struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+func NewIconStore(dir string) *IconStore {
+	os.MkdirAll(dir, 0755)
+	return &IconStore{dir: dir, hashes: make(map[string]iconHash)}
+}
+
+// Path returns the file holding the app's icon, or "" if it has none.
+func (s *IconStore) Path(appID string) string {
+	if !validIconID(appID) {
+		return ""
+	}
+	for ext := range iconTypes {
+		path := filepath.Join(s.dir, appID+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Put stores r as the app's icon. Images Go can decode (PNG, JPEG, GIF) are
+// scaled down to fit maxIconDimension and stored as PNG; SVG, ICO and WebP
+// are stored as given if they are already within maxIconSize. Anything else
+// is refused, since icons are served without auth.
+func (s *IconStore) Put(appID string, r io.Reader) error {
+	if !validIconID(appID) {
+		return fmt.Errorf("invalid app ID %q", appID)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxIconInputSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxIconInputSize {
+		return fmt.Errorf("icon exceeds %d bytes", maxIconInputSize)
+	}
+
+	ext := ".png"
+	if encoded, err := reencodeIcon(data); err == nil {
+		data = encoded
+	} else if err != image.ErrFormat {
+		return err
+	} else if ext = sniffIcon(data); ext == "" {
+		return fmt.Errorf("icon is not a PNG, JPEG, GIF, SVG, ICO or WebP image")
+	}
+	if len(data) > maxIconSize {
+		return fmt.Errorf("icon exceeds %d bytes", maxIconSize)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var existing int64
+	previous := s.Path(appID)
+	if info, err := os.Stat(previous); err == nil {
+		existing = info.Size()
+	}
+	if s.totalSize()-existing+int64(len(data)) > maxIconStoreSize {
+		return fmt.Errorf("icon store is full (%d bytes)", maxIconStoreSize)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, appID+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, appID+ext)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// An icon in another format is replaced, not kept alongside
+	if previous != "" && previous != path {
+		os.Remove(previous)
+	}
+	delete(s.hashes, appID)
+	return nil
+}
+
+// ETag returns the path of the app's icon, its content type and a strong
+// ETag for its contents. The hash is cached until the file changes.
+func (s *IconStore) ETag(appID string) (path, contentType, etag string, err error) {
+	path = s.Path(appID)
+	if path == "" {
+		return "", "", "", os.ErrNotExist
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cached, ok := s.hashes[appID]
+	if !ok || !cached.modTime.Equal(info.ModTime()) || cached.size != info.Size() {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", "", "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", "", "", err
+		}
+		cached = iconHash{modTime: info.ModTime(), size: info.Size(), sum: hex.EncodeToString(h.Sum(nil))[:32]}
+		s.hashes[appID] = cached
+	}
+	return path, iconTypes[filepath.Ext(path)], `"` + cached.sum + `"`, nil
+}
+
+func (s *IconStore) Remove(appID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.Path(appID)
+	if path == "" {
+		return os.ErrNotExist
+	}
+	delete(s.hashes, appID)
+	return os.Remove(path)
+}
+
+// Sweep removes icons of apps not in keep, and temp files left behind by a
+// write that died. Files modified after before are left alone, so an icon
+// stored for an app whose row isn't in keep yet survives. It returns how
+// many files it removed.
+func (s *IconStore) Sweep(keep map[string]bool, before time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+		if info, err := entry.Info(); err != nil || info.ModTime().After(before) {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			if os.Remove(filepath.Join(s.dir, name)) == nil {
+				removed++
+			}
+			continue
+		}
+		ext := filepath.Ext(name)
+		appID := strings.TrimSuffix(name, ext)
+		if iconTypes[ext] == "" || keep[appID] {
+			continue
+		}
+		if os.Remove(filepath.Join(s.dir, name)) == nil {
+			delete(s.hashes, appID)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (s *IconStore) totalSize() int64 {
+	entries, _ := os.ReadDir(s.dir)
+	var total int64
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// validIconID keeps an app ID from naming a file outside the store.
+func validIconID(appID string) bool {
+	return appID != "" && !strings.ContainsAny(appID, `/\`) && !strings.HasPrefix(appID, ".")
+}
+
+// sniffIcon returns the extension to store an icon Go can't decode under:
+// .svg, .ico or .webp, or "" if data is none of those.
+func sniffIcon(data []byte) string {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return ".webp"
+	case len(data) >= 6 && bytes.Equal(data[:4], []byte{0, 0, 1, 0}) && (data[4] != 0 || data[5] != 0):
+		return ".ico"
+	case isSVG(data):
+		return ".svg"
+	}
+	return ""
+}
+
+// isSVG reports whether data is an SVG document: an <svg> root element,
+// after any XML declaration, doctype and comments.
+func isSVG(data []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "svg"
+		}
+	}
+}
+
+// reencodeIcon decodes data, scales it to fit maxIconDimension and encodes
+// it as PNG. It returns image.ErrFormat for formats Go can't decode.
+func reencodeIcon(data []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if err == image.ErrFormat {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid icon: %v", err)
+	}
+	if config.Width*config.Height > maxIconPixels {
+		return nil, fmt.Errorf("icon is too large to decode (%dx%d)", config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid icon: %v", err)
+	}
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, scaleIcon(img, maxIconDimension)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleIcon shrinks img to fit within limit x limit, keeping its aspect ratio,
+// by averaging the source pixels under each destination pixel. Smaller
+// images are returned as they are.
+func scaleIcon(img image.Image, limit int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= limit && h <= limit {
+		return img
+	}
+	dw, dh := limit, limit
+	if w > h {
+		dh = h * limit / w
+	} else {
+		dw = w * limit / h
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(img.At(sx, sy)).(color.NRGBA64)
+					// Weight by alpha so transparent pixels don't darken edges
+					r += uint64(c.R) * uint64(c.A)
+					g += uint64(c.G) * uint64(c.A)
+					bl += uint64(c.B) * uint64(c.A)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			if n == 0 || a == 0 {
+				continue
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / a >> 8),
+				G: uint8(g / a >> 8),
+				B: uint8(bl / a >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// RunIconSweep removes icons of deleted apps at startup and then every
+// iconSweepInterval, until ctx is done. Deletes remove their own icon; this
+// catches ones left by a failed delete or an older controller.
+func (m *AppManager) RunIconSweep(ctx context.Context) {
+	for {
+		// Taken before the apps are read: an icon written after that may
+		// belong to an app created since
+		before := time.Now().Add(-iconSweepGrace)
+		apps, err := m.db.GetAllApps()
+		if err == nil {
+			keep := make(map[string]bool, len(apps))
+			for _, app := range apps {
+				keep[app.ID] = true
+			}
+			if removed := m.iconService.store.Sweep(keep, before); removed > 0 {
+				log.Printf("Icon sweep: removed %d stale icon files", removed)
+			}
+		}
+		select {
+		case <-time.After(iconSweepInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// IconETag returns the app's icon file, its content type and its ETag.
+func (m *AppManager) IconETag(appID string) (string, string, string, error) {
+	return m.iconService.store.ETag(appID)
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIconStoreKeepsFormat(t *testing.T) {
+	store := NewIconStore(t.TempDir())
+
+	svg := `<?xml version="1.0"?><!-- logo --><svg xmlns="http://www.w3.org/2000/svg" width="8" height="8"/>`
+	if err := store.Put("app1", strings.NewReader(svg)); err != nil {
+		t.Fatal(err)
+	}
+	path, contentType, _, err := store.ETag("app1")
+	if err != nil || filepath.Ext(path) != ".svg" || contentType != "image/svg+xml" {
+		t.Fatalf("ETag = %s, %s, %v; want an SVG", path, contentType, err)
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+	if err := store.Put("app1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	path, contentType, _, err = store.ETag("app1")
+	if err != nil || filepath.Ext(path) != ".png" || contentType != "image/png" {
+		t.Fatalf("ETag = %s, %s, %v; want a PNG", path, contentType, err)
+	}
+	if _, err := os.Stat(filepath.Join(store.dir, "app1.svg")); !os.IsNotExist(err) {
+		t.Error("the replaced SVG is still stored")
+	}
+}
+
+func TestIconStoreRefusesNonImages(t *testing.T) {
+	store := NewIconStore(t.TempDir())
+	for _, data := range []string{
+		"root:x:0:0:root:/root:/bin/bash\n",
+		"<html><script>alert(1)</script></html>",
+	} {
+		if err := store.Put("app1", strings.NewReader(data)); err == nil {
+			t.Errorf("stored %q", data)
+		}
+	}
+	if store.Path("app1") != "" {
+		t.Error("a refused icon was stored")
+	}
+}
+
+func TestIconSweepSparesRecentIcons(t *testing.T) {
+	store := NewIconStore(t.TempDir())
+	for _, id := range []string{"kept", "orphan"} {
+		if err := store.Put(id, strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg"/>`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keep := map[string]bool{"kept": true}
+
+	// Written after the cutoff, as an icon stored while the app is created
+	if removed := store.Sweep(keep, time.Now().Add(-time.Hour)); removed != 0 {
+		t.Errorf("removed %d recent icons", removed)
+	}
+	if removed := store.Sweep(keep, time.Now().Add(time.Second)); removed != 1 || store.Path("orphan") != "" || store.Path("kept") == "" {
+		t.Errorf("removed %d, want only the orphan", removed)
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// DefaultImageTagsKeep is Settings.ImageTagsKeep when unset.
+const DefaultImageTagsKeep = 5
+
+// commitImage names the tag a build of image at commit is kept under,
+// slug:1a2b3c4d for slug:latest.
+func commitImage(image string, commit string) string {
+	return imageRepo(image) + ":" + commit
+}
+
+// tagCommit tags a successful build's image with its commit too, so an
+// older build is still there to go back to after a bad deploy, and drops
+// the app's commit tags beyond the retention. It returns the tag, or ""
+// for a build without a commit (uploaded contexts) or when tagging fails.
+func (s *BuildService) tagCommit(ctx context.Context, app *models.App, build *models.Build, writer io.Writer) string {
+	if app.LastCommit == "" {
+		return ""
+	}
+	tag := commitImage(app.ImageName, app.LastCommit)
+	if err := s.dockerClient.TagImage(ctx, app.ImageName, tag); err != nil {
+		// A compose app whose own service isn't built has no image to tag
+		if !docker.IsNotFound(err) {
+			logf(ctx, "[warn] Failed to tag %s as %s: %v", app.ImageName, tag, err)
+		}
+		return ""
+	}
+	fmt.Fprintf(writer, "Tagged %s\n", tag)
+
+	var buildID int64
+	if build != nil {
+		buildID = build.ID
+	}
+	if err := s.db.RecordImageTag(app.ID, tag, app.LastCommit, buildID, time.Now()); err != nil {
+		logf(ctx, "Failed to record image tag %s: %v", tag, err)
+		return tag
+	}
+	s.pruneImageTags(ctx, app.ID)
+	return tag
+}
+
+// pruneImageTags removes all but the app's newest imageTagsKeep commit
+// tags. Removing a tag only deletes the image if nothing else names it.
+func (s *BuildService) pruneImageTags(ctx context.Context, appID string) {
+	tags, err := s.db.GetImageTags(appID)
+	if err != nil || len(tags) <= s.imageTagsKeep() {
+		return
+	}
+	for _, image := range tags[s.imageTagsKeep():] {
+		if err := s.dockerClient.RemoveImage(ctx, image.Tag); err != nil && !docker.IsNotFound(err) {
+			logf(ctx, "[warn] Failed to remove image tag %s: %v", image.Tag, err)
+			continue
+		}
+		s.db.DeleteImageTag(appID, image.Tag)
+	}
+}
+
+func (s *BuildService) imageTagsKeep() int {
+	if keep := s.settings.Get().ImageTagsKeep; keep > 0 {
+		return keep
+	}
+	return DefaultImageTagsKeep
+}
+
+// AppImages lists the app's image tags, latest and previous first, then
+// its commit tags newest first, with Docker's ID, size and creation time
+// for each. Current marks those of the image the app's container runs, or
+// of latest without a container.
+func (m *AppManager) AppImages(ctx context.Context, app *models.App) ([]models.AppImage, error) {
+	tags, err := m.db.GetImageTags(app.ID)
+	if err != nil {
+		return nil, err
+	}
+	images := append([]models.AppImage{{Tag: app.ImageName}, {Tag: previousImage(app.ImageName)}}, tags...)
+	for i := range images {
+		details, err := m.dockerClient.InspectImage(ctx, images[i].Tag)
+		if err != nil {
+			continue
+		}
+		images[i].ImageID = details.ID
+		images[i].Size = details.Size
+		if !details.Created.IsZero() {
+			images[i].Created = &details.Created
+		}
+	}
+
+	current := images[0].ImageID
+	if c, _ := m.dockerClient.GetAppContainer(ctx, app.ID, 1); c != nil {
+		current = c.ImageID
+	}
+	for i := range images {
+		images[i].Current = current != "" && images[i].ImageID == current
+	}
+	return images, nil
+}
+
+// commitImages are the app's recorded commit tags, for deleting the app.
+func (m *AppManager) commitImages(app *models.App) []string {
+	tags, _ := m.db.GetImageTags(app.ID)
+	images := make([]string, 0, len(tags))
+	for _, image := range tags {
+		images = append(images, image.Tag)
+	}
+	return images
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// controllerLabelPrefix is reserved for the labels the controller sets
+// itself; apps can't set or override them.
+const controllerLabelPrefix = "nas-controller."
+
+func validateLabels(labels map[string]string) error {
+	for key := range labels {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("label keys cannot be empty")
+		}
+		if strings.HasPrefix(key, controllerLabelPrefix) {
+			return fmt.Errorf("label %q: the %s prefix is reserved for the controller", key, controllerLabelPrefix)
+		}
+	}
+	return nil
+}
+
+// containerLabels returns the labels for replica i of app (1 is the
+// primary): the app's own labels, Unraid's web UI and icon labels unless the
+// app sets them, and the controller's identifying labels.
+func (m *AppManager) containerLabels(app *models.App, replica int) map[string]string {
+	labels := copyStringMap(app.Labels)
+
+	// Unraid substitutes the host IP and the host port mapped to the given
+	// container port, so this works for replicas and host networking alike.
+	if _, ok := labels[docker.UnraidWebUILabel]; !ok {
+		labels[docker.UnraidWebUILabel] = fmt.Sprintf("http://[IP]:[PORT:%d]/", app.InternalPort)
+	}
+	// Unraid fetches the icon itself, so it needs an absolute URL that
+	// doesn't require a session.
+	if _, ok := labels[docker.UnraidIconLabel]; !ok && app.Icon != "" {
+		if base := strings.TrimRight(m.settings.Get().ExternalBaseURL, "/"); base != "" {
+			labels[docker.UnraidIconLabel] = base + "/icons/" + app.ID
+		}
+	}
+
+	labels[docker.AppIDLabel] = app.ID
+	labels[docker.ReplicaLabel] = strconv.Itoa(replica)
+	if hash := sharedEnvHash(m.settings.Get().SharedEnv()); hash != "" {
+		labels[docker.SharedEnvLabel] = hash
+	}
+	return labels
+}
+
+// findContainer returns the ID and state of replica i of app (1 is the
+// primary), or an empty ID if it has none. It looks the container up by
+// label and, for containers created before labels, by name.
+func (m *AppManager) findContainer(ctx context.Context, app *models.App, replica int) (id, state string) {
+	if c, _ := m.dockerClient.GetAppContainer(ctx, app.ID, replica); c != nil {
+		return c.ID, c.State
+	}
+
+	names := []string{replicaName(app, replica)}
+	if replica == 1 {
+		names = m.containerNames(app)
+	}
+	for _, name := range names {
+		if c, _ := m.dockerClient.GetContainerByName(ctx, name); c != nil {
+			return c.ID, c.State
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// ErrStashNotFound is returned for a stash the checkout doesn't have.
+var ErrStashNotFound = errors.New("stash not found")
+
+// stashIdentity is who stashes are committed as. The controller's git has
+// no user configured, and stash refuses to run without one.
+var stashIdentity = []string{"-c", "user.name=nas-controller", "-c", "user.email=nas-controller@localhost"}
+
+// PullResult is what PullRepo did to the checkout.
+type PullResult struct {
+	Commit string `json:"commit"`
+	// LocalChanges are the tracked files that were modified in the
+	// checkout before it was reset. Untracked files are left in place.
+	LocalChanges []string `json:"localChanges,omitempty"`
+	// Stash holds LocalChanges when the app preserves them; otherwise they
+	// were discarded.
+	Stash *Stash `json:"stash,omitempty"`
+}
+
+// Stash is an entry on the checkout's stash list. Commit identifies it;
+// Ref is its current position, which shifts as stashes are added.
+type Stash struct {
+	Ref       string    `json:"ref"`
+	Commit    string    `json:"commit"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// localChanges lists the tracked files modified in the checkout at
+// repoPath, which is what a hard reset would throw away.
+func (s *GitService) localChanges(ctx context.Context, repoPath string, priority GitPriority) ([]string, error) {
+	output, err := s.run(ctx, "status", priority, gitLocalTimeout,
+		"-C", repoPath, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return nil, fmt.Errorf("git status failed: %v", err)
+	}
+	var files []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if len(line) > 3 {
+			files = append(files, line[3:])
+		}
+	}
+	return files, nil
+}
+
+// stashLocalChanges stashes the checkout's modifications and returns the
+// new stash.
+func (s *GitService) stashLocalChanges(ctx context.Context, repoPath, branch string) (*Stash, error) {
+	message := fmt.Sprintf("nas-controller: before pulling origin/%s at %s", branch, time.Now().Format(time.RFC3339))
+	args := append([]string{"-C", repoPath}, stashIdentity...)
+	args = append(args, "stash", "push", "-m", message)
+	if _, err := s.run(ctx, "stash", GitPriorityInteractive, gitLocalTimeout, args...); err != nil {
+		return nil, fmt.Errorf("git stash failed: %v", err)
+	}
+	stashes, err := s.stashes(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(stashes) == 0 {
+		return nil, fmt.Errorf("git stash left no stash")
+	}
+	return &stashes[0], nil
+}
+
+// stashes lists the checkout's stashes, newest first.
+func (s *GitService) stashes(ctx context.Context, repoPath string) ([]Stash, error) {
+	output, err := s.run(ctx, "stash", GitPriorityInteractive, gitLocalTimeout,
+		"-C", repoPath, "stash", "list", "--format=%gd%x1f%H%x1f%ct%x1f%gs")
+	if err != nil {
+		return nil, fmt.Errorf("git stash list failed: %v", err)
+	}
+	stashes := []Stash{}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		seconds, _ := strconv.ParseInt(fields[2], 10, 64)
+		stashes = append(stashes, Stash{
+			Ref:       fields[0],
+			Commit:    fields[1],
+			Message:   fields[3],
+			CreatedAt: time.Unix(seconds, 0),
+		})
+	}
+	return stashes, nil
+}
+
+// ListStashes lists the stashes in the app's checkout, newest first.
+func (s *GitService) ListStashes(ctx context.Context, slug string) ([]Stash, error) {
+	repoPath := filepath.Join(s.reposDir, slug)
+	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("repository not found")
+	}
+	defer s.lockRepo(repoPath)()
+	return s.stashes(ctx, repoPath)
+}
+
+// DropStash deletes the stash whose commit is commit, or starts with it.
+func (s *GitService) DropStash(ctx context.Context, slug, commit string) error {
+	repoPath := filepath.Join(s.reposDir, slug)
+	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+		return fmt.Errorf("repository not found")
+	}
+	defer s.lockRepo(repoPath)()
+
+	stashes, err := s.stashes(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	for _, stash := range stashes {
+		if len(commit) >= 7 && strings.HasPrefix(stash.Commit, commit) {
+			if _, err := s.run(ctx, "stash", GitPriorityInteractive, gitLocalTimeout,
+				"-C", repoPath, "stash", "drop", stash.Ref); err != nil {
+				return fmt.Errorf("git stash drop failed: %v", err)
+			}
+			return nil
+		}
+	}
+	return ErrStashNotFound
+}
+
+// recordLocalChanges records an app event for a pull that found the
+// checkout modified.
+func (m *AppManager) recordLocalChanges(app *models.App, pull *PullResult) {
+	if len(pull.LocalChanges) == 0 {
+		return
+	}
+	detail := fmt.Sprintf("discarded local changes to %s", strings.Join(pull.LocalChanges, ", "))
+	if pull.Stash != nil {
+		detail = fmt.Sprintf("stashed local changes to %s as %s", strings.Join(pull.LocalChanges, ", "), pull.Stash.Commit[:8])
+	}
+	m.db.CreateAppEvent(&models.AppEvent{
+		AppID:     app.ID,
+		Replica:   1,
+		Reason:    models.EventReasonLocalChanges,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}, appEventLimit)
+}
+
+// ListStashes lists the stashes in a git app's checkout.
+func (m *AppManager) ListStashes(ctx context.Context, appID string) ([]Stash, error) {
+	app, err := m.gitApp(appID)
+	if err != nil {
+		return nil, err
+	}
+	return m.gitService.ListStashes(ctx, app.Slug)
+}
+
+// DropStash deletes a stash from a git app's checkout.
+func (m *AppManager) DropStash(ctx context.Context, appID, commit string) error {
+	app, err := m.gitApp(appID)
+	if err != nil {
+		return err
+	}
+	return m.gitService.DropStash(ctx, app.Slug, commit)
+}
+
+// gitApp returns the app if its source is a clone the controller manages.
+func (m *AppManager) gitApp(appID string) (*models.App, error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+	if app.SourceType != models.SourceTypeGit || IsLocalPath(app.RepoURL) {
+		return nil, fmt.Errorf("app %s has no managed checkout", app.Name)
+	}
+	return app, nil
+}
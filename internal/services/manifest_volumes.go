@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// DefaultAppdataDir is where apps' bind mounts from their manifest go when
+// Settings.AppdataDir is unset: Unraid's appdata share.
+const DefaultAppdataDir = "/mnt/user/appdata"
+
+// volumeNamePattern is what Docker accepts as a named volume.
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ManifestVolumes turns the volumes a repo's manifest asks for into mounts
+// for the app with slug:
+//
+//	/config             -> <appdataDir>/<slug>/config:/config:create
+//	data:/var/lib/data  -> <slug>_data:/var/lib/data
+//	/host/path:/data    -> kept as it is
+//
+// Options such as :ro are kept. The create option has the controller make
+// the appdata directory before the first start. Entries that can't be
+// understood are left out and described in the returned warnings.
+func ManifestVolumes(slug string, appdataDir string, manifest []string) ([]string, []string) {
+	volumes, warnings := []string{}, []string{}
+	for _, entry := range manifest {
+		volume, err := manifestVolume(slug, appdataDir, strings.TrimSpace(entry))
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, warnings
+}
+
+func manifestVolume(slug string, appdataDir string, entry string) (string, error) {
+	parts := strings.SplitN(entry, ":", 3)
+
+	// A bare container path, with or without options
+	if strings.HasPrefix(parts[0], "/") && (len(parts) == 1 || !strings.HasPrefix(parts[1], "/")) {
+		target := filepath.Clean(parts[0])
+		if target == "/" {
+			return "", fmt.Errorf("manifest volume %q: can't mount over /", entry)
+		}
+		source := filepath.Join(appdataDir, slug, strings.TrimPrefix(target, "/"))
+		options := []string{createOption}
+		if len(parts) > 1 {
+			options = append(strings.Split(strings.Join(parts[1:], ":"), ","), createOption)
+		}
+		volume := source + ":" + target + ":" + strings.Join(options, ",")
+		if _, err := parseVolume(volume); err != nil {
+			return "", fmt.Errorf("manifest volume %q: %v", entry, err)
+		}
+		return volume, nil
+	}
+
+	v, err := parseVolume(entry)
+	if err != nil {
+		return "", fmt.Errorf("manifest %v", err)
+	}
+	if v.bind() {
+		return entry, nil
+	}
+	if !volumeNamePattern.MatchString(v.source) {
+		return "", fmt.Errorf("manifest volume %q: %q is not a valid volume name", entry, v.source)
+	}
+	v.source = slug + "_" + v.source
+	return v.docker(), nil
+}
+
+// manifestVolumes translates the manifest's volumes for the app with slug,
+// under the appdataDir setting.
+func (m *AppManager) manifestVolumes(slug string, manifest *models.AppManifest) ([]string, []string) {
+	if manifest == nil || len(manifest.Volumes) == 0 {
+		return []string{}, nil
+	}
+	appdataDir := m.settings.Get().AppdataDir
+	if appdataDir == "" {
+		appdataDir = DefaultAppdataDir
+	}
+	return ManifestVolumes(slug, appdataDir, manifest.Volumes)
+}
+
+// reportManifestVolumeChanges records an app event, and logs a warning, if
+// the volumes the manifest declares went from before to after.
+func (m *AppManager) reportManifestVolumeChanges(app *models.App, before, after []string) {
+	changes := manifestVolumeChanges(before, after)
+	if changes == "" {
+		return
+	}
+	m.db.CreateAppEvent(&models.AppEvent{
+		AppID:     app.ID,
+		Replica:   1,
+		Reason:    models.EventReasonManifestVolumes,
+		Detail:    changes,
+		CreatedAt: time.Now(),
+	}, appEventLimit)
+	log.Printf("[warn] App %s: %s", app.Slug, changes)
+}
+
+// manifestVolumeChanges describes how the volumes the manifest asks for
+// changed, or returns "" if they didn't.
+func manifestVolumeChanges(before, after []string) string {
+	was := make(map[string]bool, len(before))
+	for _, v := range before {
+		was[v] = true
+	}
+	is := make(map[string]bool, len(after))
+	for _, v := range after {
+		is[v] = true
+	}
+
+	var added, removed []string
+	for _, v := range after {
+		if !was[v] {
+			added = append(added, v)
+		}
+	}
+	for _, v := range before {
+		if !is[v] {
+			removed = append(removed, v)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return ""
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	var parts []string
+	if len(added) > 0 {
+		parts = append(parts, "added "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, "removed "+strings.Join(removed, ", "))
+	}
+	return "manifest volumes changed (" + strings.Join(parts, "; ") + "); the app's volumes were left as they are"
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// minMemoryLimit is the smallest memory limit Docker accepts.
+const minMemoryLimit = 6 << 20
+
+// byteSizePattern is a size as Docker's --memory and --shm-size take it: a
+// number with an optional b, k, m or g unit.
+var byteSizePattern = regexp.MustCompile(`^([0-9]+)([bkmg]?)$`)
+
+// parseByteSize parses a size such as "512m" or "1g" into bytes. Empty is 0.
+func parseByteSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	m := byteSizePattern.FindStringSubmatch(strings.ToLower(size))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q: expected a number with an optional k, m or g unit, such as 1g", size)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	shift := map[string]uint{"": 0, "b": 0, "k": 10, "m": 20, "g": 30}[m[2]]
+	if n > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid size %q: too large", size)
+	}
+	return n << shift, nil
+}
+
+// ValidateMemoryOptions checks an app's memory limit, swap limit,
+// reservation and /dev/shm size. Each is a size such as "512m"; empty
+// leaves Docker's default. memorySwap may also be "-1" for unlimited swap.
+func ValidateMemoryOptions(memory, memorySwap, memoryReservation, shmSize string) error {
+	_, err := memoryResources(memory, memorySwap, memoryReservation, shmSize)
+	return err
+}
+
+func memoryResources(memory, memorySwap, memoryReservation, shmSize string) (docker.ResourceConfig, error) {
+	var r docker.ResourceConfig
+	var err error
+	if r.Memory, err = parseByteSize(memory); err != nil {
+		return r, fmt.Errorf("memoryLimit: %v", err)
+	}
+	if memorySwap == "-1" {
+		r.MemorySwap = -1
+	} else if r.MemorySwap, err = parseByteSize(memorySwap); err != nil {
+		return r, fmt.Errorf("memorySwap: %v", err)
+	}
+	if r.MemoryReservation, err = parseByteSize(memoryReservation); err != nil {
+		return r, fmt.Errorf("memoryReservation: %v", err)
+	}
+	if r.ShmSize, err = parseByteSize(shmSize); err != nil {
+		return r, fmt.Errorf("shmSize: %v", err)
+	}
+
+	if r.Memory != 0 && r.Memory < minMemoryLimit {
+		return r, fmt.Errorf("memoryLimit must be at least 6m")
+	}
+	if r.MemorySwap != 0 && r.Memory == 0 {
+		return r, fmt.Errorf("memorySwap needs a memoryLimit")
+	}
+	if r.MemorySwap > 0 && r.MemorySwap < r.Memory {
+		return r, fmt.Errorf("memorySwap is memory plus swap, so it must be at least memoryLimit")
+	}
+	if r.Memory != 0 && r.MemoryReservation > r.Memory {
+		return r, fmt.Errorf("memoryReservation cannot exceed memoryLimit")
+	}
+	return r, nil
+}
+
+// resourceConfig is the app's memory settings and ulimits for container
+// creation. They were validated when saved.
+func resourceConfig(app *models.App) docker.ResourceConfig {
+	r, _ := memoryResources(app.MemoryLimit, app.MemorySwap, app.MemoryReservation, app.ShmSize)
+	r.Ulimits = dockerUlimits(app.Ulimits)
+	return r
+}
+
+// ContainerResources reads the memory settings and ulimits the app's
+// container actually has, so a change can be checked once the container is recreated. It is
+// nil when the app has no container.
+func (m *AppManager) ContainerResources(ctx context.Context, app *models.App) *docker.ResourceConfig {
+	containerID, _ := m.findContainer(ctx, app, 1)
+	if containerID == "" {
+		return nil
+	}
+	resources, err := m.dockerClient.ContainerResources(ctx, containerID)
+	if err != nil {
+		return nil
+	}
+	return resources
+}
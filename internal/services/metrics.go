@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+const (
+	// DefaultMetricsRetentionHours is how long samples are kept when
+	// Settings.MetricsRetentionHours is unset.
+	DefaultMetricsRetentionHours = 24
+	// MaxMetricsRetentionHours caps the retention setting at 30 days.
+	MaxMetricsRetentionHours = 720
+	// DefaultMetricsWindow is the series GET /apps/:id/metrics returns
+	// without ?window.
+	DefaultMetricsWindow = 6 * time.Hour
+
+	// metricsSampleInterval is how often running apps are sampled.
+	metricsSampleInterval = time.Minute
+	// metricsRawAge is how long one-minute samples are kept before they are
+	// averaged into metricsCompactResolution buckets. With the defaults an
+	// app keeps at most 360 + 216 rows.
+	metricsRawAge            = 6 * time.Hour
+	metricsCompactResolution = 5 * time.Minute
+	// metricsMaxPoints is roughly how many points a series has, whatever
+	// its window; enough for a chart, small enough to poll.
+	metricsMaxPoints = 120
+)
+
+// usageReading is the last CPU counters read from an app's container, which
+// the next sample's CPU percentage is computed against.
+type usageReading struct {
+	containerID string
+	cpuTotal    uint64
+	systemCPU   uint64
+}
+
+// RunMetricsSampler samples the CPU and memory of every running app each
+// metricsSampleInterval until ctx is done, and keeps the samples table
+// within the retention setting.
+func (m *AppManager) RunMetricsSampler(ctx context.Context) {
+	ticker := time.NewTicker(metricsSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.sampleMetrics(ctx)
+			now := time.Now()
+			if err := m.db.CompactAppMetrics(now.Add(-metricsRawAge), int(metricsCompactResolution/time.Second),
+				now.Add(-m.metricsRetention())); err != nil {
+				log.Printf("Failed to compact app metrics: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *AppManager) sampleMetrics(ctx context.Context) {
+	apps, err := m.db.GetAllApps()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	sampled := map[string]bool{}
+	for _, app := range apps {
+		// Stopped apps leave a gap rather than a run of zeros
+		if app.Status != models.StatusRunning {
+			continue
+		}
+
+		var cpu float64
+		var mem int64
+		haveCPU := false
+		replicas := app.Replicas
+		if replicas < 1 {
+			replicas = 1
+		}
+		for replica := 1; replica <= replicas; replica++ {
+			// Looked up afresh each time, so a recreated container is
+			// followed and its counters aren't diffed against the old one's
+			containerID, state := m.findContainer(ctx, app, replica)
+			if containerID == "" || state != "running" {
+				continue
+			}
+			usage, err := m.dockerClient.ContainerUsage(ctx, containerID)
+			if err != nil {
+				continue
+			}
+
+			key := fmt.Sprintf("%s/%d", app.ID, replica)
+			sampled[key] = true
+			m.usageMu.Lock()
+			prev, ok := m.usage[key]
+			m.usage[key] = usageReading{containerID: containerID, cpuTotal: usage.CPUTotal, systemCPU: usage.SystemCPU}
+			m.usageMu.Unlock()
+
+			mem += int64(usage.Memory)
+			if ok && prev.containerID == containerID && usage.SystemCPU > prev.systemCPU && usage.CPUTotal >= prev.cpuTotal {
+				cpuDelta := float64(usage.CPUTotal - prev.cpuTotal)
+				systemDelta := float64(usage.SystemCPU - prev.systemCPU)
+				cpu += cpuDelta / systemDelta * float64(usage.OnlineCPUs) * 100
+				haveCPU = true
+			}
+		}
+
+		// The first reading after a start only primes the CPU counters
+		if !haveCPU {
+			continue
+		}
+		if err := m.db.InsertAppMetric(app.ID, now, cpu, mem); err != nil {
+			log.Printf("Failed to record metrics for %s: %v", app.Slug, err)
+		}
+	}
+
+	m.usageMu.Lock()
+	for key := range m.usage {
+		if !sampled[key] {
+			delete(m.usage, key)
+		}
+	}
+	m.usageMu.Unlock()
+}
+
+// AppMetrics returns the app's CPU and memory series over the last window,
+// downsampled to about metricsMaxPoints points, with the window (capped at
+// the retention) and step actually used.
+func (m *AppManager) AppMetrics(appID string, window time.Duration) ([]models.MetricPoint, time.Duration, time.Duration, error) {
+	if retention := m.metricsRetention(); window > retention {
+		window = retention
+	}
+	step := (window/metricsMaxPoints + time.Minute - 1).Truncate(time.Minute)
+	if step < metricsSampleInterval {
+		step = metricsSampleInterval
+	}
+	// Older samples are only kept in compacted buckets
+	if window > metricsRawAge && step < metricsCompactResolution {
+		step = metricsCompactResolution
+	}
+
+	points, err := m.db.GetAppMetrics(appID, time.Now().Add(-window), step)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return points, window, step, nil
+}
+
+func (m *AppManager) metricsRetention() time.Duration {
+	hours := m.settings.Get().MetricsRetentionHours
+	if hours <= 0 {
+		hours = DefaultMetricsRetentionHours
+	}
+	return time.Duration(hours) * time.Hour
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"nas-controller/internal/models"
+)
+
+// validateNetworking checks that an app's network settings fit together.
+func validateNetworking(mode, network, ipAddress string, replicas int) error {
+	if mode != models.NetworkModeBridge && mode != models.NetworkModeHost {
+		return fmt.Errorf("networkMode must be bridge or host")
+	}
+	if mode == models.NetworkModeHost && replicas > 1 {
+		return fmt.Errorf("host network mode supports a single replica only")
+	}
+	if mode == models.NetworkModeHost && network != "" {
+		return fmt.Errorf("host network mode can't be combined with a custom network")
+	}
+
+	if ipAddress != "" {
+		if network == "" {
+			return fmt.Errorf("ipAddress requires a custom network")
+		}
+		if ip := net.ParseIP(ipAddress); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("ipAddress must be an IPv4 address")
+		}
+		if replicas > 1 {
+			return fmt.Errorf("a static ipAddress supports a single replica only")
+		}
+	}
+	return nil
+}
+
+// checkNetworkMode validates app's network settings, and that the mode isn't
+// being switched under an existing container, which would keep running in
+// the old mode until recreated.
+func checkNetworkMode(previous, app *models.App) error {
+	if app.NetworkMode == "" {
+		app.NetworkMode = models.NetworkModeBridge
+	}
+	if err := validateNetworking(app.NetworkMode, app.Network, app.IPAddress, app.Replicas); err != nil {
+		return err
+	}
+	if app.ComposeFile != "" {
+		if err := validateComposeNetworking(app.NetworkMode, app.Network, app.Replicas); err != nil {
+			return err
+		}
+	}
+
+	if previous != nil && previous.NetworkMode != app.NetworkMode && previous.ContainerID != "" {
+		return fmt.Errorf("stop the app before changing its network mode")
+	}
+	return nil
+}
+
+// bindAddress is the host IP app's ports are published on, empty for every
+// interface.
+func (m *AppManager) bindAddress(app *models.App) string {
+	if app.BindAddress != "" {
+		return app.BindAddress
+	}
+	return m.settings.Get().BindAddress
+}
+
+// publishIPv6 is whether app's ports are published on [::] as well as
+// 0.0.0.0: its own choice, else the publishIPv6 setting, and never on
+// hosts without IPv6.
+func (m *AppManager) publishIPv6(app *models.App) bool {
+	if !m.portAllocator.IPv6Supported() {
+		return false
+	}
+	switch app.PublishIPv6 {
+	case models.IPv6On:
+		return true
+	case models.IPv6Off:
+		return false
+	}
+	return m.settings.Get().PublishIPv6
+}
+
+// ipFamilies is which IP versions app's ports are reachable over when
+// published on address: "v4", "v6" or "both".
+func (m *AppManager) ipFamilies(app *models.App, address string) string {
+	ip := net.ParseIP(address)
+	switch {
+	case ip != nil && ip.To4() == nil:
+		return "v6"
+	case (ip == nil || ip.IsUnspecified()) && m.publishIPv6(app):
+		return "both"
+	}
+	return "v4"
+}
+
+// PortBindings lists the host ports the apps hold, with the address each is
+// published on, by port. Host-mode apps listen wherever they choose and are
+// shown as "host".
+func (m *AppManager) PortBindings() ([]models.PortBinding, error) {
+	apps, err := m.db.GetAllApps()
+	if err != nil {
+		return nil, err
+	}
+	bindings := []models.PortBinding{}
+	for _, app := range apps {
+		address := m.bindAddress(app)
+		families := m.ipFamilies(app, address)
+		switch {
+		case app.NetworkMode == models.NetworkModeHost:
+			address, families = "host", ""
+		case address == "":
+			address = "0.0.0.0"
+		}
+		for replica := 1; replica == 1 || replica <= app.Replicas; replica++ {
+			port := replicaPort(app, replica)
+			if port == 0 {
+				continue
+			}
+			bindings = append(bindings, models.PortBinding{
+				Port:        port,
+				BindAddress: address,
+				AppID:       app.ID,
+				AppName:     app.Name,
+				Replica:     replica,
+				Families:    families,
+			})
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].Port < bindings[j].Port })
+	return bindings, nil
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// runOutdated restarts an app whose rebuild on a pull failed on the image
+// it ran before, which a failed build leaves in place, and marks it as
+// running an outdated build. Apps set to fail-stop stay stopped.
+func (m *AppManager) runOutdated(ctx context.Context, appID string, buildErr error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil || app.FailStop {
+		return
+	}
+	if err := m.StartApp(ctx, appID); err != nil {
+		logf(ctx, "[warn] App %s: failed to restart on the previous image after the build failed: %v", app.Slug, err)
+		return
+	}
+
+	// The failed build is the newest recorded, unless it failed before it
+	// was recorded
+	var failedID int64
+	if builds, err := m.db.GetBuilds(appID, 0, 1); err == nil && len(builds) == 1 && builds[0].Result == models.BuildResultFailed {
+		failedID = builds[0].ID
+	}
+	if app, err = m.db.GetApp(appID); err != nil {
+		return
+	}
+	app.RunningOutdated, app.FailedBuildID = true, failedID
+	m.db.UpdateApp(app)
+
+	detail := fmt.Sprintf("build failed: %v", buildErr)
+	if failedID != 0 {
+		detail = fmt.Sprintf("build #%d failed: %v", failedID, buildErr)
+	}
+	// There is no notification channel yet; the controller log is where
+	// alerts show up.
+	log.Printf("[alert:%s] App %s: %s; restarted on the previous image", models.EventReasonOutdatedBuild, app.Slug, detail)
+	m.db.CreateAppEvent(&models.AppEvent{
+		AppID:     appID,
+		Replica:   1,
+		Reason:    models.EventReasonOutdatedBuild,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}, appEventLimit)
+}
@@ -0,0 +1,12 @@
+package services
+
+import "nas-controller/internal/docker"
+
+// ValidatePlatform checks an app's platform; empty means the host's.
+func ValidatePlatform(platform string) error {
+	if platform == "" {
+		return nil
+	}
+	_, err := docker.ParsePlatform(platform)
+	return err
+}
@@ -1,33 +1,161 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"math/rand/v2"
 	"net"
+	"strconv"
 	"sync"
+	"syscall"
 
 	"nas-controller/internal/database"
 	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
 )
 
+// The default range for new apps' ports; Settings.PortRangeStart/End
+// override it.
 const (
-	PortRangeStart = 13001
-	PortRangeEnd   = 13999
+	DefaultPortRangeStart = 13001
+	DefaultPortRangeEnd   = 13999
+)
+
+// Port allocation strategies, selected through Settings.PortStrategy.
+const (
+	PortStrategySequential = "sequential"
+	PortStrategyRandom     = "random"
 )
 
 type PortAllocator struct {
 	db           *database.DB
 	dockerClient *docker.Client
+	settings     *SettingsService
 	mu           sync.Mutex
+
+	// reserved holds ports handed out by AllocatePort that are not in the
+	// database yet, so concurrent creates can't be given the same port.
+	reserved map[int]bool
+
+	// randIntN is swappable so the random strategy is deterministic in tests.
+	randIntN func(n int) int
+
+	// rangeStart and rangeEnd follow the settings. Guarded by mu.
+	rangeStart int
+	rangeEnd   int
+
+	// ipv6 is whether the host can listen on IPv6, found once at startup.
+	ipv6 bool
 }
 
-func NewPortAllocator(db *database.DB, dockerClient *docker.Client) *PortAllocator {
-	return &PortAllocator{
+func NewPortAllocator(db *database.DB, dockerClient *docker.Client, settings *SettingsService) *PortAllocator {
+	p := &PortAllocator{
 		db:           db,
 		dockerClient: dockerClient,
+		settings:     settings,
+		reserved:     make(map[int]bool),
+		randIntN:     rand.IntN,
+		ipv6:         detectIPv6(),
+	}
+	if !p.ipv6 {
+		log.Printf("IPv6 is not available; ports are checked and published on IPv4 only")
+	}
+	settings.Subscribe(p.applySettings)
+	return p
+}
+
+// detectIPv6 reports whether an IPv6 loopback listener can be opened,
+// which it can't on hosts, or in namespaces, with IPv6 disabled.
+func detectIPv6() bool {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// IPv6Supported reports whether ports can be checked and published on
+// IPv6.
+func (p *PortAllocator) IPv6Supported() bool {
+	return p.ipv6
+}
+
+func (p *PortAllocator) applySettings(settings Settings) {
+	start, end := settings.PortRange()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rangeStart, p.rangeEnd = start, end
+}
+
+// Range returns the range new ports are picked from.
+func (p *PortAllocator) Range() (start, end int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rangeStart, p.rangeEnd
+}
+
+// PortRange returns the configured port range, with defaults filled in.
+func (s Settings) PortRange() (start, end int) {
+	start, end = s.PortRangeStart, s.PortRangeEnd
+	if start == 0 {
+		start = DefaultPortRangeStart
+	}
+	if end == 0 {
+		end = DefaultPortRangeEnd
+	}
+	return start, end
+}
+
+// ValidatePortRange checks a port range from the settings; zero means the
+// default for that end.
+func ValidatePortRange(start, end int) error {
+	if start < 0 || start > 65535 || end < 0 || end > 65535 {
+		return fmt.Errorf("port range must be within 1-65535")
+	}
+	s, e := Settings{PortRangeStart: start, PortRangeEnd: end}.PortRange()
+	if s > e {
+		return fmt.Errorf("port range start %d is after its end %d", s, e)
 	}
+	if s < 1024 {
+		return fmt.Errorf("port range must not include privileged ports below 1024")
+	}
+	return nil
 }
 
-func (p *PortAllocator) AllocatePort() (int, error) {
+// ValidateBindAddress checks a host IP to publish ports on; empty means
+// every interface.
+func ValidateBindAddress(address string) error {
+	if address != "" && net.ParseIP(address) == nil {
+		return fmt.Errorf("bindAddress %q must be an IP address, such as 192.168.1.10", address)
+	}
+	return nil
+}
+
+// ValidatePublishIPv6 checks an app's publishIPv6; empty follows the
+// setting.
+func ValidatePublishIPv6(publish string) error {
+	switch publish {
+	case "", models.IPv6On, models.IPv6Off:
+		return nil
+	}
+	return fmt.Errorf("publishIPv6 must be %s, %s or empty to follow the setting", models.IPv6On, models.IPv6Off)
+}
+
+// Strategy returns the allocation strategy in effect.
+func (p *PortAllocator) Strategy() string {
+	if p.settings.Get().PortStrategy == PortStrategyRandom {
+		return PortStrategyRandom
+	}
+	return PortStrategySequential
+}
+
+// AllocatePort picks a port for a new app publishing on bindAddress. The
+// port slug had last time is preferred when it's still free, so delete +
+// re-add keeps the same port. The port stays reserved until Release is
+// called, which callers should do once it has been saved (or abandoned).
+func (p *PortAllocator) AllocatePort(slug string, bindAddress string) (int, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -35,25 +163,43 @@ func (p *PortAllocator) AllocatePort() (int, error) {
 	if err != nil {
 		return 0, fmt.Errorf("failed to get used ports: %v", err)
 	}
+	usedSet := p.usedSet(usedPorts)
 
-	usedSet := make(map[int]bool)
-	for _, port := range usedPorts {
-		usedSet[port] = true
+	if sticky, err := p.db.GetStickyPort(slug); err == nil && p.isFree(sticky, usedSet, bindAddress) {
+		p.reserved[sticky] = true
+		return sticky, nil
 	}
 
-	for port := PortRangeStart; port <= PortRangeEnd; port++ {
-		if usedSet[port] {
-			continue
-		}
-		if !p.isPortInUse(port) {
-			return port, nil
-		}
+	port, err := p.pickPort(usedSet, bindAddress)
+	if err != nil {
+		return 0, fmt.Errorf("no available ports in range %d-%d", p.rangeStart, p.rangeEnd)
+	}
+	p.reserved[port] = true
+	return port, nil
+}
+
+// Release drops the in-memory reservation made by AllocatePort.
+func (p *PortAllocator) Release(port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.reserved, port)
+}
+
+// Remember records port as slug's sticky port.
+func (p *PortAllocator) Remember(slug string, port int) error {
+	if slug == "" || port == 0 {
+		return nil
 	}
+	return p.db.SetStickyPort(slug, port)
+}
 
-	return 0, fmt.Errorf("no available ports in range %d-%d", PortRangeStart, PortRangeEnd)
+func (p *PortAllocator) GetStickyPorts() (map[string]int, error) {
+	return p.db.GetStickyPorts()
 }
 
-func (p *PortAllocator) IsPortAvailable(port int) bool {
+// IsPortAvailable checks if a port is free to publish on bindAddress. Ports
+// held by other apps count as taken whatever address they are on.
+func (p *PortAllocator) IsPortAvailable(port int, bindAddress string) bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -62,19 +208,13 @@ func (p *PortAllocator) IsPortAvailable(port int) bool {
 		return false
 	}
 
-	for _, used := range usedPorts {
-		if used == port {
-			return false
-		}
-	}
-
-	return !p.isPortInUse(port)
+	return !p.usedSet(usedPorts)[port] && !p.isPortInUse(bindAddress, port)
 }
 
 // IsPortAvailableForApp checks if a port is available, excluding the given app's own
 // port reservation. Use this when starting an app so its own DB entry doesn't block
 // it from reclaiming its assigned port.
-func (p *PortAllocator) IsPortAvailableForApp(port int, appID string) bool {
+func (p *PortAllocator) IsPortAvailableForApp(port int, appID string, bindAddress string) bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -83,16 +223,10 @@ func (p *PortAllocator) IsPortAvailableForApp(port int, appID string) bool {
 		return false
 	}
 
-	for _, used := range usedPorts {
-		if used == port {
-			return false
-		}
-	}
-
-	return !p.isPortInUse(port)
+	return !p.usedSet(usedPorts)[port] && !p.isPortInUse(bindAddress, port)
 }
 
-func (p *PortAllocator) FindNextAvailable(preferredPort int) (int, error) {
+func (p *PortAllocator) FindNextAvailable(preferredPort int, bindAddress string) (int, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -100,35 +234,19 @@ func (p *PortAllocator) FindNextAvailable(preferredPort int) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-
-	usedSet := make(map[int]bool)
-	for _, port := range usedPorts {
-		usedSet[port] = true
-	}
+	usedSet := p.usedSet(usedPorts)
 
 	// Try preferred port first
-	if preferredPort >= PortRangeStart && preferredPort <= PortRangeEnd {
-		if !usedSet[preferredPort] && !p.isPortInUse(preferredPort) {
-			return preferredPort, nil
-		}
-	}
-
-	// Find next available
-	for port := PortRangeStart; port <= PortRangeEnd; port++ {
-		if usedSet[port] {
-			continue
-		}
-		if !p.isPortInUse(port) {
-			return port, nil
-		}
+	if p.isFree(preferredPort, usedSet, bindAddress) {
+		return preferredPort, nil
 	}
 
-	return 0, fmt.Errorf("no available ports")
+	return p.pickPort(usedSet, bindAddress)
 }
 
 // FindNextAvailableForApp finds an available port, excluding the given app's own
 // port reservation from the "used" set.
-func (p *PortAllocator) FindNextAvailableForApp(preferredPort int, appID string) (int, error) {
+func (p *PortAllocator) FindNextAvailableForApp(preferredPort int, appID string, bindAddress string) (int, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -136,23 +254,47 @@ func (p *PortAllocator) FindNextAvailableForApp(preferredPort int, appID string)
 	if err != nil {
 		return 0, err
 	}
+	usedSet := p.usedSet(usedPorts)
+
+	if p.isFree(preferredPort, usedSet, bindAddress) {
+		return preferredPort, nil
+	}
 
-	usedSet := make(map[int]bool)
+	return p.pickPort(usedSet, bindAddress)
+}
+
+// usedSet merges DB assignments with outstanding reservations. Callers must
+// hold p.mu.
+func (p *PortAllocator) usedSet(usedPorts []int) map[int]bool {
+	usedSet := make(map[int]bool, len(usedPorts)+len(p.reserved))
 	for _, port := range usedPorts {
 		usedSet[port] = true
 	}
+	for port := range p.reserved {
+		usedSet[port] = true
+	}
+	return usedSet
+}
 
-	if preferredPort >= PortRangeStart && preferredPort <= PortRangeEnd {
-		if !usedSet[preferredPort] && !p.isPortInUse(preferredPort) {
-			return preferredPort, nil
-		}
+func (p *PortAllocator) isFree(port int, usedSet map[int]bool, bindAddress string) bool {
+	if port < p.rangeStart || port > p.rangeEnd {
+		return false
 	}
+	return !usedSet[port] && !p.isPortInUse(bindAddress, port)
+}
 
-	for port := PortRangeStart; port <= PortRangeEnd; port++ {
-		if usedSet[port] {
-			continue
-		}
-		if !p.isPortInUse(port) {
+// pickPort walks the range according to the configured strategy: from the
+// start for sequential, from a random offset (wrapping) for random.
+func (p *PortAllocator) pickPort(usedSet map[int]bool, bindAddress string) (int, error) {
+	size := p.rangeEnd - p.rangeStart + 1
+	offset := 0
+	if p.Strategy() == PortStrategyRandom {
+		offset = p.randIntN(size)
+	}
+
+	for i := 0; i < size; i++ {
+		port := p.rangeStart + (offset+i)%size
+		if p.isFree(port, usedSet, bindAddress) {
 			return port, nil
 		}
 	}
@@ -160,10 +302,33 @@ func (p *PortAllocator) FindNextAvailableForApp(preferredPort int, appID string)
 	return 0, fmt.Errorf("no available ports")
 }
 
-func (p *PortAllocator) isPortInUse(port int) bool {
-	address := fmt.Sprintf("127.0.0.1:%d", port)
-	listener, err := net.Listen("tcp", address)
+// isPortInUse probes port on bindAddress, or on both loopbacks for every
+// interface, since a port taken on either stack can't be published on
+// both. An address the controller doesn't have (it usually runs in its own
+// network namespace) falls back to loopback too. IPv6 is skipped on hosts
+// without it.
+func (p *PortAllocator) isPortInUse(bindAddress string, port int) bool {
+	ip := net.ParseIP(bindAddress)
+	if ip == nil || ip.IsUnspecified() {
+		return p.isInUseOn(net.IPv4(127, 0, 0, 1), port) || (p.ipv6 && p.isInUseOn(net.IPv6loopback, port))
+	}
+	if ip.To4() == nil && !p.ipv6 {
+		return p.isPortInUse("", port)
+	}
+	return p.isInUseOn(ip, port)
+}
+
+// isInUseOn probes port on ip, over tcp4 or tcp6 as ip is.
+func (p *PortAllocator) isInUseOn(ip net.IP, port int) bool {
+	network := "tcp4"
+	if ip.To4() == nil {
+		network = "tcp6"
+	}
+	listener, err := net.Listen(network, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
 	if err != nil {
+		if errors.Is(err, syscall.EADDRNOTAVAIL) && !ip.IsLoopback() {
+			return p.isPortInUse("", port)
+		}
 		return true
 	}
 	listener.Close()
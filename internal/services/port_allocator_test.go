@@ -0,0 +1,167 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+// newTestAllocator returns an allocator over a range of size ports that
+// are all free on this host.
+func newTestAllocator(t *testing.T, size int, strategy string) (*PortAllocator, int) {
+	t.Helper()
+	settings, db := newTestSettings(t)
+	ports := NewPortAllocator(db, nil, settings)
+
+	for start := 42000; start < 60000; start += 1000 {
+		if !portsFree(ports, start, size) {
+			continue
+		}
+		current := settings.Get()
+		current.PortRangeStart, current.PortRangeEnd = start, start+size-1
+		current.PortStrategy = strategy
+		if _, err := settings.Update(current); err != nil {
+			t.Fatal(err)
+		}
+		return ports, start
+	}
+	t.Skip("no free port range on this host")
+	return nil, 0
+}
+
+func portsFree(p *PortAllocator, start, size int) bool {
+	for port := start; port < start+size; port++ {
+		if p.isPortInUse("", port) {
+			return false
+		}
+	}
+	return true
+}
+
+func allocate(t *testing.T, ports *PortAllocator, slug string) int {
+	t.Helper()
+	port, err := ports.AllocatePort(slug, "")
+	if err != nil {
+		t.Fatalf("AllocatePort(%s): %v", slug, err)
+	}
+	return port
+}
+
+func TestAllocateSequential(t *testing.T) {
+	ports, start := newTestAllocator(t, 5, PortStrategySequential)
+
+	first := allocate(t, ports, "a")
+	second := allocate(t, ports, "b")
+	if first != start || second != start+1 {
+		t.Fatalf("got %d, %d, want %d, %d", first, second, start, start+1)
+	}
+
+	// An abandoned reservation frees the port again
+	ports.Release(first)
+	if got := allocate(t, ports, "c"); got != start {
+		t.Errorf("after release got %d, want %d", got, start)
+	}
+}
+
+func TestAllocateSkipsPortsInUse(t *testing.T) {
+	ports, start := newTestAllocator(t, 5, PortStrategySequential)
+	listener, err := net.Listen("tcp4", fmt.Sprintf("127.0.0.1:%d", start))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+
+	if got := allocate(t, ports, "a"); got != start+1 {
+		t.Errorf("got %d, want %d past the held port", got, start+1)
+	}
+}
+
+func TestAllocateStickyPort(t *testing.T) {
+	ports, start := newTestAllocator(t, 5, PortStrategySequential)
+
+	if err := ports.Remember("demo", start+3); err != nil {
+		t.Fatal(err)
+	}
+	if got := allocate(t, ports, "demo"); got != start+3 {
+		t.Errorf("got %d, want the sticky %d", got, start+3)
+	}
+	ports.Release(start + 3)
+
+	// Taken by another app's reservation: the sticky port is passed over
+	other := allocate(t, ports, "other")
+	if err := ports.Remember("late", other); err != nil {
+		t.Fatal(err)
+	}
+	if got := allocate(t, ports, "late"); got == other {
+		t.Errorf("sticky port %d handed out twice", other)
+	}
+
+	// Outside the range: passed over too
+	if err := ports.Remember("outside", start+100); err != nil {
+		t.Fatal(err)
+	}
+	if got := allocate(t, ports, "outside"); got == start+100 {
+		t.Errorf("got %d outside the range", got)
+	}
+}
+
+func TestAllocateRandom(t *testing.T) {
+	ports, start := newTestAllocator(t, 5, PortStrategyRandom)
+	ports.randIntN = func(n int) int {
+		if n != 5 {
+			t.Errorf("randIntN(%d), want the range size 5", n)
+		}
+		return 3
+	}
+
+	// From the random offset, wrapping round to the start of the range
+	want := []int{start + 3, start + 4, start, start + 1}
+	for i, w := range want {
+		if got := allocate(t, ports, fmt.Sprintf("app%d", i)); got != w {
+			t.Errorf("allocation %d = %d, want %d", i, got, w)
+		}
+	}
+}
+
+func TestAllocateConcurrently(t *testing.T) {
+	const size = 40
+	for _, strategy := range []string{PortStrategySequential, PortStrategyRandom} {
+		t.Run(strategy, func(t *testing.T) {
+			ports, start := newTestAllocator(t, size, strategy)
+
+			var wg sync.WaitGroup
+			results := make(chan int, size+1)
+			errs := make(chan error, size+1)
+			for i := 0; i < size+1; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					port, err := ports.AllocatePort(fmt.Sprintf("app%d", i), "")
+					if err != nil {
+						errs <- err
+						return
+					}
+					results <- port
+				}()
+			}
+			wg.Wait()
+			close(results)
+			close(errs)
+
+			seen := make(map[int]bool)
+			for port := range results {
+				if seen[port] {
+					t.Errorf("port %d handed out twice", port)
+				}
+				if port < start || port >= start+size {
+					t.Errorf("port %d outside the range", port)
+				}
+				seen[port] = true
+			}
+			if len(seen) != size || len(errs) != 1 {
+				t.Errorf("%d ports and %d errors, want %d and 1 once the range is used up", len(seen), len(errs), size)
+			}
+		})
+	}
+}
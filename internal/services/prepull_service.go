@@ -0,0 +1,261 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"nas-controller/internal/database"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+const prepullTimeout = 30 * time.Minute
+
+// Prepull image states.
+const (
+	PrepullPending = "pending"
+	PrepullPulling = "pulling"
+	PrepullDone    = "done"
+	PrepullFailed  = "failed"
+)
+
+// PrepullImage tracks one base image. Message is the latest status line
+// from the daemon.
+type PrepullImage struct {
+	Image   string `json:"image"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PrepullState is the progress (or outcome) of the latest prepull of an app.
+type PrepullState struct {
+	Running    bool           `json:"running"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt *time.Time     `json:"finishedAt,omitempty"`
+	Images     []PrepullImage `json:"images"`
+}
+
+// PrepullService pulls an app's base images ahead of a build so the build
+// starts with warm layers. It is independent of BuildService and never
+// takes the build lock, so a prepull can run while another app builds.
+type PrepullService struct {
+	db           *database.DB
+	dockerClient *docker.Client
+	mu           sync.Mutex
+	states       map[string]*PrepullState
+}
+
+func NewPrepullService(db *database.DB, dockerClient *docker.Client) *PrepullService {
+	return &PrepullService{
+		db:           db,
+		dockerClient: dockerClient,
+		states:       make(map[string]*PrepullState),
+	}
+}
+
+// Start pulls the base images named in the app's Dockerfile in the
+// background. repoPath is where the app's source lives.
+func (s *PrepullService) Start(app *models.App, repoPath string) (*PrepullState, error) {
+	dockerfile, err := os.ReadFile(filepath.Join(repoPath, app.BuildContext, app.DockerfilePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Dockerfile: %v", err)
+	}
+	images := ParseBaseImages(dockerfile, app.BuildArgs)
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no pullable base images in Dockerfile")
+	}
+
+	s.mu.Lock()
+	if state := s.states[app.ID]; state != nil && state.Running {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("a prepull is already running for this app")
+	}
+	state := &PrepullState{Running: true, StartedAt: time.Now()}
+	for _, image := range images {
+		state.Images = append(state.Images, PrepullImage{Image: image, Status: PrepullPending})
+	}
+	s.states[app.ID] = state
+	snapshot := s.copyState(state)
+	s.mu.Unlock()
+
+	go s.run(app.ID, state)
+	return snapshot, nil
+}
+
+// Get returns the latest prepull state for the app, or nil if none ran
+// since the controller started.
+func (s *PrepullService) Get(appID string) *PrepullState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.states[appID]
+	if state == nil {
+		return nil
+	}
+	return s.copyState(state)
+}
+
+func (s *PrepullService) Forget(appID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, appID)
+}
+
+func (s *PrepullService) run(appID string, state *PrepullState) {
+	ctx, cancel := context.WithTimeout(context.Background(), prepullTimeout)
+	defer cancel()
+
+	var failures []string
+	for i := range state.Images {
+		s.update(func() { state.Images[i].Status = PrepullPulling })
+
+		image := state.Images[i].Image
+		err := s.dockerClient.PullImage(ctx, image, func(msg string) {
+			s.update(func() { state.Images[i].Message = msg })
+		})
+
+		s.update(func() {
+			if err != nil {
+				state.Images[i].Status = PrepullFailed
+				state.Images[i].Error = err.Error()
+			} else {
+				state.Images[i].Status = PrepullDone
+			}
+		})
+		if err != nil {
+			log.Printf("Prepull of %s for app %s failed: %v", image, appID, err)
+			failures = append(failures, err.Error())
+		}
+	}
+
+	s.update(func() {
+		now := time.Now()
+		state.Running = false
+		state.FinishedAt = &now
+	})
+
+	errMsg := strings.Join(failures, "; ")
+	s.db.RecordContact(appID, models.ContactRegistry, len(failures) == 0, errMsg)
+}
+
+func (s *PrepullService) update(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn()
+}
+
+// copyState returns a copy safe to hand out. Callers must hold s.mu.
+func (s *PrepullService) copyState(state *PrepullState) *PrepullState {
+	out := *state
+	out.Images = append([]PrepullImage(nil), state.Images...)
+	return &out
+}
+
+// ParseBaseImages lists the images referenced by FROM instructions in
+// dockerfile, in order and without duplicates. Stage names, scratch, and
+// references that still contain unresolved variables are skipped. ARGs
+// declared before the first FROM are expanded, with buildArgs overriding
+// their defaults.
+func ParseBaseImages(dockerfile []byte, buildArgs map[string]string) []string {
+	args := make(map[string]string)
+	stages := make(map[string]bool)
+	seen := make(map[string]bool)
+	var images []string
+	sawFrom := false
+
+	for _, line := range dockerfileInstructions(dockerfile) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if sawFrom {
+				continue
+			}
+			name, value, _ := strings.Cut(fields[1], "=")
+			value = strings.Trim(value, `"'`)
+			if override, ok := buildArgs[name]; ok {
+				value = override
+			}
+			args[name] = value
+
+		case "FROM":
+			sawFrom = true
+			rest := fields[1:]
+			for len(rest) > 0 && strings.HasPrefix(rest[0], "--") {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				continue
+			}
+
+			image := expandDockerfileArgs(rest[0], args)
+			isStage := stages[strings.ToLower(image)]
+			if len(rest) >= 3 && strings.EqualFold(rest[1], "AS") {
+				stages[strings.ToLower(rest[2])] = true
+			}
+
+			if image == "" || isStage || strings.Contains(image, "$") || strings.EqualFold(image, "scratch") {
+				continue
+			}
+			if !seen[image] {
+				seen[image] = true
+				images = append(images, image)
+			}
+		}
+	}
+	return images
+}
+
+// dockerfileInstructions joins continuation lines and drops comments.
+func dockerfileInstructions(dockerfile []byte) []string {
+	var instructions []string
+	var current strings.Builder
+
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			current.WriteString(" ")
+			continue
+		}
+		current.WriteString(line)
+		if instruction := strings.TrimSpace(current.String()); instruction != "" {
+			instructions = append(instructions, instruction)
+		}
+		current.Reset()
+	}
+	return instructions
+}
+
+// expandDockerfileArgs substitutes $NAME, ${NAME} and ${NAME:-default}.
+// Unknown names are left as-is so the caller can tell they didn't resolve.
+func expandDockerfileArgs(s string, args map[string]string) string {
+	return os.Expand(s, func(name string) string {
+		key, fallback, hasFallback := strings.Cut(name, ":-")
+		if value, ok := args[key]; ok && value != "" {
+			return value
+		}
+		if hasFallback {
+			return fallback
+		}
+		if value, ok := args[key]; ok {
+			return value
+		}
+		return "${" + name + "}"
+	})
+}
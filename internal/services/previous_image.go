@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+const (
+	// DefaultPreviousImageDays is Settings.PreviousImageDays when unset.
+	DefaultPreviousImageDays = 7
+	// previousImageSweepInterval is how often expired previous images are
+	// removed.
+	previousImageSweepInterval = 6 * time.Hour
+)
+
+// previousImage names the image a build of image keeps its predecessor
+// under, slug:previous for slug:latest.
+func previousImage(image string) string {
+	return imageRepo(image) + ":previous"
+}
+
+// imageRepo is image without its tag.
+func imageRepo(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+// keepPrevious tags the current image as previousImage(image) before it is
+// rebuilt, so the build can reuse its layers after a prune has removed the
+// intermediate ones. It returns the images to pass as CacheFrom, none if
+// there is no current image yet.
+func (s *BuildService) keepPrevious(ctx context.Context, image string, writer io.Writer) []string {
+	if _, err := s.dockerClient.GetImageSize(ctx, image); err != nil {
+		return nil
+	}
+	previous := previousImage(image)
+	if err := s.dockerClient.TagImage(ctx, image, previous); err != nil {
+		logf(ctx, "[warn] Failed to tag %s as %s: %v", image, previous, err)
+		return nil
+	}
+	fmt.Fprintf(writer, "Cache source: %s\n", previous)
+	return []string{previous}
+}
+
+// previousImages are the app's previous images: its own and, for compose
+// apps, those of the other services it builds.
+func (m *AppManager) previousImages(app *models.App) []string {
+	images := []string{previousImage(app.ImageName)}
+	if app.ComposeFile == "" {
+		return images
+	}
+	project, err := m.loadCompose(app)
+	if err != nil {
+		return images
+	}
+	for name, svc := range project.Services {
+		if svc.Build != nil && name != app.ComposeService {
+			images = append(images, previousImage(composeImage(app, name, svc)))
+		}
+	}
+	return images
+}
+
+func (m *AppManager) previousImageRetention() time.Duration {
+	days := m.settings.Get().PreviousImageDays
+	if days == 0 {
+		days = DefaultPreviousImageDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// PreviousImagesSize is how much disk the apps' previous images take that
+// their current images don't share.
+func (m *AppManager) PreviousImagesSize(ctx context.Context) int64 {
+	sizes, err := m.dockerClient.ImageUniqueSizes(ctx)
+	if err != nil {
+		return 0
+	}
+	apps, _ := m.db.GetAllApps()
+	var total int64
+	for _, app := range apps {
+		for _, image := range m.previousImages(app) {
+			total += sizes[image]
+		}
+	}
+	return total
+}
+
+// ExpirePreviousImages removes the previous images of apps whose last
+// build is older than the retention, returning how many went and the space
+// that freed.
+func (m *AppManager) ExpirePreviousImages(ctx context.Context) (int, int64) {
+	apps, err := m.db.GetAllApps()
+	if err != nil {
+		return 0, 0
+	}
+	sizes, _ := m.dockerClient.ImageUniqueSizes(ctx)
+	cutoff := time.Now().Add(-m.previousImageRetention())
+
+	removed, freed := 0, int64(0)
+	for _, app := range apps {
+		if app.LastBuild == nil || app.LastBuild.After(cutoff) {
+			continue
+		}
+		for _, image := range m.previousImages(app) {
+			size, ok := sizes[image]
+			if !ok {
+				continue
+			}
+			if err := m.dockerClient.RemoveImage(ctx, image); err != nil && !docker.IsNotFound(err) {
+				log.Printf("[warn] Failed to remove previous image %s: %v", image, err)
+				continue
+			}
+			removed++
+			freed += size
+		}
+	}
+	return removed, freed
+}
+
+// RunPreviousImageSweep removes expired previous images periodically until
+// ctx is done.
+func (m *AppManager) RunPreviousImageSweep(ctx context.Context) {
+	for {
+		if removed, freed := m.ExpirePreviousImages(ctx); removed > 0 {
+			log.Printf("Previous image sweep: removed %d images, %d bytes", removed, freed)
+		}
+		select {
+		case <-time.After(previousImageSweepInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// MaxReplicas bounds App.Replicas; every replica takes a port from the
+// managed range.
+const MaxReplicas = 10
+
+// replicaName is the container name of replica i (1-based). Replica 1 keeps
+// the plain container name so single-instance apps are unaffected.
+func replicaName(app *models.App, i int) string {
+	if i == 1 {
+		return app.ContainerName
+	}
+	return fmt.Sprintf("%s-%d", app.ContainerName, i)
+}
+
+// replicaPort returns the port of replica i, or 0 if none is assigned yet.
+func replicaPort(app *models.App, i int) int {
+	if i == 1 {
+		return app.ExternalPort
+	}
+	if i-2 < len(app.ReplicaPorts) {
+		return app.ReplicaPorts[i-2]
+	}
+	return 0
+}
+
+// startReplicas brings up replicas 2..N once the primary container is
+// running, then removes any left over from a larger replica count. Ports
+// are kept in ReplicaPorts so each replica comes back on the same port.
+func (m *AppManager) startReplicas(ctx context.Context, app *models.App) error {
+	var shared docker.SharedNetwork
+	if app.Replicas > 1 {
+		shared = m.sharedNetwork(ctx, app)
+	}
+	for i := 2; i <= app.Replicas; i++ {
+		name := replicaName(app, i)
+		if existing, _ := m.dockerClient.GetContainerByName(ctx, name); existing != nil && foreignContainer(app, existing) {
+			return fmt.Errorf("replica %d: %w", i, docker.NameConflict(name))
+		}
+		m.removeContainerByName(ctx, app, name)
+
+		port := replicaPort(app, i)
+		if port == 0 || !m.portAllocator.IsPortAvailableForApp(port, app.ID, m.bindAddress(app)) {
+			newPort, err := m.portAllocator.FindNextAvailable(app.ExternalPort+i-1, m.bindAddress(app))
+			if err != nil {
+				return fmt.Errorf("no available port for replica %d: %v", i, err)
+			}
+			port = newPort
+			for len(app.ReplicaPorts) < i-1 {
+				app.ReplicaPorts = append(app.ReplicaPorts, 0)
+			}
+			app.ReplicaPorts[i-2] = port
+			// Save right away so the next replica's allocation sees it.
+			m.db.UpdateApp(app)
+		}
+
+		spec := m.containerSpec(app, dockerVolumes(app.Volumes), shared)
+		spec.Name, spec.ExternalPort = name, port
+		spec.Labels = m.containerLabels(app, i)
+		containerID, err := m.dockerClient.CreateContainer(ctx, spec)
+		if err != nil {
+			return fmt.Errorf("failed to create replica %d: %v", i, err)
+		}
+		if err := m.dockerClient.StartContainer(ctx, containerID); err != nil {
+			return fmt.Errorf("failed to start replica %d: %v", i, err)
+		}
+	}
+
+	// Before the ports are trimmed, which would forget how many ran
+	m.removeReplicas(ctx, app, app.Replicas+1)
+	if len(app.ReplicaPorts) > app.Replicas-1 {
+		app.ReplicaPorts = app.ReplicaPorts[:app.Replicas-1]
+		m.db.UpdateApp(app)
+	}
+	return nil
+}
+
+// highestReplica is the most replicas the app has run since it last
+// started: every replica started gets a port in ReplicaPorts, and the
+// ports of replicas beyond the count are only dropped once those are gone.
+func highestReplica(app *models.App) int {
+	if n := len(app.ReplicaPorts) + 1; n > app.Replicas {
+		return n
+	}
+	return app.Replicas
+}
+
+// removeReplicas stops and removes the app's replica containers from index
+// from up to the highest it has run. A container under a replica's name
+// that belongs to something else, such as an app whose slug ends in -2,
+// is left alone.
+func (m *AppManager) removeReplicas(ctx context.Context, app *models.App, from int) {
+	if from < 2 {
+		from = 2
+	}
+	for i := from; i <= highestReplica(app); i++ {
+		m.removeContainerByName(ctx, app, replicaName(app, i))
+	}
+}
+
+// removeContainerByName stops and removes the container called name,
+// unless it isn't app's (see foreignContainer), which is left alone.
+func (m *AppManager) removeContainerByName(ctx context.Context, app *models.App, name string) {
+	existing, _ := m.dockerClient.GetContainerByName(ctx, name)
+	if existing == nil {
+		return
+	}
+	if foreignContainer(app, existing) {
+		log.Printf("App %s: not removing container %s, which isn't the app's", app.Slug, name)
+		return
+	}
+	m.dockerClient.StopContainer(ctx, existing.ID)
+	m.dockerClient.RemoveContainer(ctx, existing.ID, true)
+}
+
+// anyReplicaRunning reports whether one of replicas 2..N is running.
+func (m *AppManager) anyReplicaRunning(ctx context.Context, app *models.App) bool {
+	for i := 2; i <= app.Replicas; i++ {
+		if _, state := m.findContainer(ctx, app, i); state == "running" {
+			return true
+		}
+	}
+	return false
+}
+
+// GetReplicas reports the live state of each of the app's replicas.
+func (m *AppManager) GetReplicas(ctx context.Context, appID string) ([]models.ReplicaStatus, error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make([]models.ReplicaStatus, 0, app.Replicas)
+	for i := 1; i <= app.Replicas; i++ {
+		replica := models.ReplicaStatus{
+			Index:         i,
+			ContainerName: replicaName(app, i),
+			Port:          replicaPort(app, i),
+			Status:        string(models.StatusStopped),
+		}
+
+		c, err := m.dockerClient.GetContainerByName(ctx, replica.ContainerName)
+		if err != nil {
+			log.Printf("Failed to look up replica %s: %v", replica.ContainerName, err)
+		}
+		if c != nil {
+			replica.ContainerID = c.ID
+			if c.State == "running" {
+				replica.Status = string(models.StatusRunning)
+				replica.Uptime, _ = m.dockerClient.GetContainerUptime(ctx, c.ID)
+			}
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas, nil
+}
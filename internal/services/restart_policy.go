@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"nas-controller/internal/models"
+)
+
+const (
+	DefaultRestartPolicy = "unless-stopped"
+
+	// DefaultMaxRetries is the on-failure retry count when none is given.
+	DefaultMaxRetries = 3
+	maxMaxRetries     = 100
+)
+
+// ValidateRestartPolicy checks a restart policy and its on-failure retry
+// count.
+func ValidateRestartPolicy(policy string, maxRetries int) error {
+	switch policy {
+	case "no", "always", "unless-stopped", "on-failure":
+	default:
+		return fmt.Errorf("restartPolicy must be one of no, always, unless-stopped, on-failure")
+	}
+	if maxRetries < 0 || maxRetries > maxMaxRetries {
+		return fmt.Errorf("maxRetries must be between 0 and %d", maxMaxRetries)
+	}
+	return nil
+}
+
+// inPlaceFields are the spec fields Docker can change on a running
+// container.
+var inPlaceFields = map[string]bool{"restartPolicy": true, "maxRetries": true}
+
+// controllerFields are the spec fields only the controller reads; changing
+// them leaves the containers alone.
+var controllerFields = map[string]bool{"autoRecreate": true, "preserveLocalChanges": true, "failStop": true}
+
+// ApplyRunningConfig makes a saved config change take effect on the app's
+// running containers. A change to nothing but the restart policy is applied
+// in place with ContainerUpdate; anything else recreates the containers.
+func (m *AppManager) ApplyRunningConfig(ctx context.Context, previous *models.App, appID string) error {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return fmt.Errorf("app not found: %v", err)
+	}
+
+	diff := DiffSpec(previous, SpecFromApp(app))
+	inPlace := len(diff.Changes) > 0 && !diff.RebuildRequired
+	containerChanges := 0
+	for _, change := range diff.Changes {
+		if controllerFields[change.Field] {
+			continue
+		}
+		containerChanges++
+		if !inPlaceFields[change.Field] {
+			inPlace = false
+		}
+	}
+	if len(diff.Changes) > 0 && containerChanges == 0 {
+		return nil
+	}
+	if !inPlace {
+		return m.RestartApp(ctx, appID)
+	}
+
+	for i := 1; i <= app.Replicas; i++ {
+		id, _ := m.findContainer(ctx, app, i)
+		if id == "" {
+			continue
+		}
+		if err := m.dockerClient.UpdateRestartPolicy(ctx, id, app.RestartPolicy, app.MaxRetries); err != nil {
+			// Fall back to recreating, which always picks up the policy
+			logf(ctx, "App %s: updating restart policy in place failed (%v); restarting", app.Name, err)
+			return m.RestartApp(ctx, appID)
+		}
+	}
+	logf(ctx, "App %s: restart policy now %s", app.Name, app.RestartPolicy)
+	return nil
+}
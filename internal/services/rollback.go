@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// ErrNoRollbackImage is returned for a rollback with no earlier build's
+// image to go back to. The app is left as it was.
+var ErrNoRollbackImage = errors.New("no earlier build's image to roll back to")
+
+// ErrUnknownRollbackTarget is returned for a rollback to a tag that isn't
+// one of the app's commit tags.
+var ErrUnknownRollbackTarget = errors.New("not one of the app's commit tags")
+
+// ErrRollbackCompose is returned for a rollback of a compose app, whose
+// services' images aren't tagged per commit.
+var ErrRollbackCompose = errors.New("compose apps can't be rolled back")
+
+// runImage is the image the app's container runs: its latest build, or
+// the commit tag it was rolled back to.
+func runImage(app *models.App) string {
+	if app.RolledBackTo != "" {
+		return app.RolledBackTo
+	}
+	return app.ImageName
+}
+
+// Rollback recreates the app's container from an earlier build's image:
+// target, one of its commit tags (slug:1a2b3c4d, or just the commit), or
+// by default the newest one older than what the app runs now. The checkout
+// isn't touched, so the next pull and rebuild carries on from the branch
+// as usual; its success ends the rollback. The target is checked before
+// the container is stopped, and if it doesn't start the app goes back to
+// the image it ran.
+func (m *AppManager) Rollback(ctx context.Context, appID string, target string) (*models.App, error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+	if app.ComposeFile != "" {
+		return nil, ErrRollbackCompose
+	}
+	tag, err := m.rollbackTarget(ctx, app, target)
+	if err != nil {
+		return nil, err
+	}
+
+	defer m.startFlow(appID, models.StatusDeploying)()
+
+	from := runImage(app)
+	logf(ctx, "App %s: rolling back from %s to %s", app.Slug, from, tag)
+	m.setRolledBackTo(appID, tag)
+	m.StopApp(ctx, appID)
+	if err := m.startApp(ctx, appID); err != nil {
+		logf(ctx, "App %s: %s didn't start, going back to %s: %v", app.Slug, tag, from, err)
+		m.setRolledBackTo(appID, app.RolledBackTo)
+		if restoreErr := m.StartApp(ctx, appID); restoreErr != nil {
+			logf(ctx, "App %s: restarting %s failed: %v", app.Slug, from, restoreErr)
+		}
+		return nil, fmt.Errorf("rollback to %s failed: %w", tag, err)
+	}
+
+	m.db.CreateAppEvent(&models.AppEvent{
+		AppID:     appID,
+		Replica:   1,
+		Reason:    models.EventReasonRolledBack,
+		Detail:    fmt.Sprintf("rolled back to %s from %s", tag, from),
+		CreatedAt: time.Now(),
+	}, appEventLimit)
+	return m.db.GetApp(appID)
+}
+
+// rollbackTarget resolves target to one of the app's commit tags whose
+// image Docker still has, or picks the newest one older than the image the
+// app runs, skipping tags of that same image.
+func (m *AppManager) rollbackTarget(ctx context.Context, app *models.App, target string) (string, error) {
+	tags, err := m.db.GetImageTags(app.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if target != "" {
+		if !strings.Contains(target, ":") {
+			target = commitImage(app.ImageName, target)
+		}
+		for _, image := range tags {
+			if image.Tag != target {
+				continue
+			}
+			if _, err := m.dockerClient.InspectImage(ctx, target); err != nil {
+				return "", fmt.Errorf("%w: %s is no longer in Docker", ErrNoRollbackImage, target)
+			}
+			return target, nil
+		}
+		return "", fmt.Errorf("%s: %w", target, ErrUnknownRollbackTarget)
+	}
+
+	// Tags are newest first; an app already rolled back goes further back
+	// from the tag it runs
+	start := 0
+	for i, image := range tags {
+		if image.Tag == app.RolledBackTo {
+			start = i + 1
+		}
+	}
+	current, _ := m.dockerClient.InspectImage(ctx, runImage(app))
+	for _, image := range tags[start:] {
+		details, err := m.dockerClient.InspectImage(ctx, image.Tag)
+		if err != nil || (current != nil && details.ID == current.ID) {
+			continue
+		}
+		return image.Tag, nil
+	}
+	return "", ErrNoRollbackImage
+}
+
+// setRolledBackTo records the image the app runs instead of its latest
+// build; "" goes back to the latest build.
+func (m *AppManager) setRolledBackTo(appID string, tag string) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return
+	}
+	app.RolledBackTo = tag
+	m.db.UpdateApp(app)
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Files like password.txt and settings.json are rewritten in place by the
+// API. A crash halfway through os.WriteFile leaves them truncated, which
+// for the password locks everyone out, so they go through these helpers
+// instead.
+
+// writeFileAtomic replaces path with data such that a crash leaves either
+// the old contents or the new, never a mix. The old contents are kept in
+// path.bak for readFileRecovering.
+func writeFileAtomic(path string, data []byte, perm os.FileMode, valid func([]byte) bool) error {
+	if old, err := os.ReadFile(path); err == nil && valid(old) {
+		if err := writeFileSynced(path+".bak", old, perm); err != nil {
+			return fmt.Errorf("failed to back up %s: %v", filepath.Base(path), err)
+		}
+	}
+	return writeFileSynced(path, data, perm)
+}
+
+// writeFileSynced writes data to a temp file next to path, fsyncs it and
+// renames it over path, then fsyncs the directory so the rename survives a
+// power cut.
+func writeFileSynced(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// readFileRecovering reads path. If it exists but is empty or fails valid
+// (a write interrupted before these helpers existed, or a bad hand edit),
+// the contents of path.bak are restored over it and returned instead.
+// A missing path is returned as os.IsNotExist, backup or not: deleting the
+// file is how it gets reset.
+func readFileRecovering(path string, valid func([]byte) bool) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if valid(data) {
+		return data, nil
+	}
+
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil || !valid(backup) {
+		return nil, fmt.Errorf("%s is empty or corrupt and there is no usable backup", path)
+	}
+
+	log.Printf("========================================")
+	log.Printf("WARNING: %s is empty or corrupt; restoring the previous version from %s.bak", path, filepath.Base(path))
+	log.Printf("========================================")
+	if err := writeFileSynced(path, backup, 0600); err != nil {
+		log.Printf("Warning: failed to restore %s: %v", path, err)
+	}
+	return backup, nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func validJSON(data []byte) bool {
+	return json.Valid(data)
+}
+
+func TestReadFileRecovering(t *testing.T) {
+	good := []byte(`{"version":1}`)
+	tests := []struct {
+		name    string
+		content []byte
+		// backup is written to path.bak unless nil
+		backup   []byte
+		want     []byte
+		wantErr  bool
+		restored bool
+	}{
+		{name: "valid file", content: good, backup: []byte(`{"version":0}`), want: good},
+		{name: "empty file with backup", content: []byte{}, backup: good, want: good, restored: true},
+		{name: "corrupt file with backup", content: []byte(`{"vers`), backup: good, want: good, restored: true},
+		{name: "corrupt file without backup", content: []byte(`{"vers`), wantErr: true},
+		{name: "empty file without backup", content: []byte{}, wantErr: true},
+		{name: "corrupt file and corrupt backup", content: []byte(`{`), backup: []byte{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "settings.json")
+			if err := os.WriteFile(path, tt.content, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if tt.backup != nil {
+				if err := os.WriteFile(path+".bak", tt.backup, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := readFileRecovering(path, validJSON)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("data = %q, want %q", got, tt.want)
+			}
+			onDisk, _ := os.ReadFile(path)
+			if tt.restored && !bytes.Equal(onDisk, tt.want) {
+				t.Errorf("file not restored from the backup: %q", onDisk)
+			}
+			if !tt.restored && !bytes.Equal(onDisk, tt.content) {
+				t.Errorf("file changed to %q", onDisk)
+			}
+		})
+	}
+}
+
+func TestReadFileRecoveringMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password.txt")
+	// Deleting the file resets it, even with a backup next to it
+	if err := os.WriteFile(path+".bak", []byte(`"secret"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFileRecovering(path, validJSON); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want not exist", err)
+	}
+}
+
+func TestWriteFileAtomicKeepsBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+
+	if err := writeFileAtomic(path, []byte(`{"v":1}`), 0600, validJSON); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("first write left a backup: %v", err)
+	}
+
+	if err := writeFileAtomic(path, []byte(`{"v":2}`), 0600, validJSON); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != `{"v":2}` {
+		t.Errorf("file = %q", data)
+	}
+	if data, _ := os.ReadFile(path + ".bak"); string(data) != `{"v":1}` {
+		t.Errorf("backup = %q, want the previous contents", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+
+	// A corrupt file is not backed up over the good backup
+	if err := os.WriteFile(path, []byte(`{"v`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte(`{"v":3}`), 0600, validJSON); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path + ".bak"); string(data) != `{"v":1}` {
+		t.Errorf("backup = %q, want the last valid contents", data)
+	}
+
+	// No temp files are left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("files = %v, want the file and its backup", names)
+	}
+}
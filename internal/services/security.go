@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// ErrPrivilegedNotConfirmed is returned when privileged mode is turned on
+// without confirmPrivileged.
+var ErrPrivilegedNotConfirmed = fmt.Errorf("privileged mode gives the container full access to the host; resend with confirmPrivileged: true to enable it")
+
+// linuxCapabilities are the capability names Docker accepts for capAdd and
+// capDrop, without the CAP_ prefix.
+var linuxCapabilities = map[string]bool{
+	"ALL": true, "AUDIT_CONTROL": true, "AUDIT_READ": true, "AUDIT_WRITE": true,
+	"BLOCK_SUSPEND": true, "BPF": true, "CHECKPOINT_RESTORE": true, "CHOWN": true,
+	"DAC_OVERRIDE": true, "DAC_READ_SEARCH": true, "FOWNER": true, "FSETID": true,
+	"IPC_LOCK": true, "IPC_OWNER": true, "KILL": true, "LEASE": true,
+	"LINUX_IMMUTABLE": true, "MAC_ADMIN": true, "MAC_OVERRIDE": true, "MKNOD": true,
+	"NET_ADMIN": true, "NET_BIND_SERVICE": true, "NET_BROADCAST": true, "NET_RAW": true,
+	"PERFMON": true, "SETFCAP": true, "SETGID": true, "SETPCAP": true,
+	"SETUID": true, "SYSLOG": true, "SYS_ADMIN": true, "SYS_BOOT": true,
+	"SYS_CHROOT": true, "SYS_MODULE": true, "SYS_NICE": true, "SYS_PACCT": true,
+	"SYS_PTRACE": true, "SYS_RAWIO": true, "SYS_RESOURCE": true, "SYS_TIME": true,
+	"SYS_TTY_CONFIG": true, "WAKE_ALARM": true,
+}
+
+// CheckPrivilegedConfirmed refuses to turn privileged mode on unless the
+// caller confirmed it. Keeping an already privileged app privileged needs no
+// confirmation.
+func CheckPrivilegedConfirmed(wasPrivileged, privileged, confirmed bool) error {
+	if privileged && !wasPrivileged && !confirmed {
+		return ErrPrivilegedNotConfirmed
+	}
+	return nil
+}
+
+// normalizeCapabilities upper-cases capability names and strips the CAP_
+// prefix, rejecting unknown ones.
+func normalizeCapabilities(field string, caps []string) ([]string, error) {
+	out := make([]string, 0, len(caps))
+	for _, c := range caps {
+		name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(c)), "CAP_")
+		if !linuxCapabilities[name] {
+			return nil, fmt.Errorf("%s: unknown capability %q", field, c)
+		}
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+// normalizeSecurity validates and normalizes app's capability lists in
+// place.
+func normalizeSecurity(app *models.App) error {
+	capAdd, err := normalizeCapabilities("capAdd", app.CapAdd)
+	if err != nil {
+		return err
+	}
+	capDrop, err := normalizeCapabilities("capDrop", app.CapDrop)
+	if err != nil {
+		return err
+	}
+	app.CapAdd, app.CapDrop = capAdd, capDrop
+	return nil
+}
+
+func securityConfig(app *models.App) docker.SecurityConfig {
+	return docker.SecurityConfig{
+		Privileged: app.Privileged,
+		CapAdd:     app.CapAdd,
+		CapDrop:    app.CapDrop,
+	}
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Settings are controller-wide defaults, persisted to settings.json in the
+// data directory.
+type Settings struct {
+	// OfflineBuilds is the default for new apps: build with no network access.
+	OfflineBuilds bool `json:"offlineBuilds"`
+
+	// PortStrategy picks how new ports are chosen from the managed range:
+	// PortStrategySequential (default) or PortStrategyRandom.
+	PortStrategy string `json:"portStrategy"`
+
+	// MaxLogStreams and MaxLogStreamsPerApp cap concurrent container log
+	// WebSockets. Zero means DefaultMaxLogStreams / DefaultMaxLogStreamsPerApp.
+	MaxLogStreams       int `json:"maxLogStreams"`
+	MaxLogStreamsPerApp int `json:"maxLogStreamsPerApp"`
+
+	// MaxBuildLogMB caps each build log file; past it only the tail of the
+	// output is kept. Zero means DefaultMaxBuildLogMB.
+	MaxBuildLogMB int `json:"maxBuildLogMB"`
+
+	// BuildHistoryLimit is how many builds, records and logs, are kept per
+	// app. Zero means DefaultBuildHistoryLimit.
+	BuildHistoryLimit int `json:"buildHistoryLimit"`
+	// PreviousImageDays is how long an app's previous image (slug:previous),
+	// kept as a cache source for its next build, outlives the build that
+	// replaced it. Zero means DefaultPreviousImageDays.
+	PreviousImageDays int `json:"previousImageDays"`
+	// ImageTagsKeep is how many commit tags (slug:<commit>) are kept per
+	// app, newest first. Zero means DefaultImageTagsKeep.
+	ImageTagsKeep int `json:"imageTagsKeep"`
+	// UpdateCheckHours is how often each git app's remote is checked for
+	// new commits in the background. Zero means DefaultUpdateCheckHours.
+	UpdateCheckHours int `json:"updateCheckHours"`
+
+	// ExternalBaseURL is how the controller is reached from outside (e.g.
+	// https://nas.example.com:13000). It's used to build deep links to app
+	// and build pages; empty means no links.
+	ExternalBaseURL string `json:"externalBaseUrl"`
+
+	// ContainerPrefix is prepended to an app's slug to name its container.
+	// Empty means DefaultContainerPrefix. Existing apps move to a new
+	// prefix the next time their container is recreated.
+	ContainerPrefix string `json:"containerPrefix"`
+
+	// ConfirmActions lists the actions (ConfirmableActions) that need the
+	// password re-entered even with a valid session. Unset means
+	// DefaultConfirmActions; an empty list turns confirmation off.
+	ConfirmActions []string `json:"confirmActions"`
+
+	// PortRangeStart and PortRangeEnd bound the ports handed to new apps.
+	// Zero means DefaultPortRangeStart / DefaultPortRangeEnd. Apps already
+	// outside a new range keep their ports.
+	PortRangeStart int `json:"portRangeStart"`
+	PortRangeEnd   int `json:"portRangeEnd"`
+
+	// BuildTimeoutMinutes cancels a build that runs longer. Zero means
+	// DefaultBuildTimeout. A change applies to builds started afterwards.
+	BuildTimeoutMinutes int `json:"buildTimeoutMinutes"`
+
+	// GitOperationTimeoutMinutes, DockerRequestTimeoutSeconds,
+	// ContainerStopTimeoutSeconds and SelfUpdateTimeoutMinutes bound the
+	// other outbound operations (see Timeouts). Zero means the matching
+	// Default*Timeout.
+	GitOperationTimeoutMinutes  int `json:"gitOperationTimeoutMinutes"`
+	DockerRequestTimeoutSeconds int `json:"dockerRequestTimeoutSeconds"`
+	ContainerStopTimeoutSeconds int `json:"containerStopTimeoutSeconds"`
+	SelfUpdateTimeoutMinutes    int `json:"selfUpdateTimeoutMinutes"`
+
+	// DefaultUser is the user ("PUID:PGID", e.g. 99:100 for Unraid's
+	// nobody:users) new apps' containers run as unless they set their own.
+	DefaultUser string `json:"defaultUser"`
+
+	// LogMaxSize and LogMaxFiles cap container log files (Docker's max-size
+	// and max-file) for apps that don't set their own. Empty and 0 leave
+	// the daemon's default. Containers pick up a change when recreated.
+	LogMaxSize  string `json:"logMaxSize"`
+	LogMaxFiles int    `json:"logMaxFiles"`
+
+	// HTTPProxy, HTTPSProxy and NoProxy are passed to git, to builds as
+	// build args, and to containers of apps with UseProxy. Proxy URLs may
+	// carry credentials, so the API only ever returns them masked.
+	HTTPProxy  string `json:"httpProxy"`
+	HTTPSProxy string `json:"httpsProxy"`
+	NoProxy    string `json:"noProxy"`
+
+	// PromotionWindowMinutes is how long an app may stay starting before
+	// the state watchdog checks it against Docker and settles it on running
+	// or error. Zero means DefaultPromotionWindowMinutes.
+	PromotionWindowMinutes int `json:"promotionWindowMinutes"`
+	// MetricsRetentionHours is how long per-app CPU and memory samples are
+	// kept. Zero means DefaultMetricsRetentionHours.
+	MetricsRetentionHours int `json:"metricsRetentionHours"`
+
+	// BuildCooldownSeconds is the least time between the starts of two
+	// builds of the same app; a request within it waits in the build queue
+	// while other apps' builds go ahead. Zero means no cooldown.
+	BuildCooldownSeconds int `json:"buildCooldownSeconds"`
+
+	// ExitFlapSeconds is how soon a container that exited must be running
+	// again for the exit to be recorded without an alert. Zero means
+	// DefaultExitFlapSeconds.
+	ExitFlapSeconds int `json:"exitFlapSeconds"`
+
+	// BindMountPrefixes are the host paths apps may bind mount, checked
+	// when a container is created. Unset means DefaultBindMountPrefixes;
+	// an empty list allows no bind mounts.
+	BindMountPrefixes []string `json:"bindMountPrefixes"`
+	// BindMountOwner ("uid:gid") owns host directories created for bind
+	// mounts with the create option that don't name their own. Empty means
+	// DefaultBindMountOwner.
+	BindMountOwner string `json:"bindMountOwner"`
+
+	// BindAddress is the host IP apps publish their ports on unless they
+	// set their own. Empty means every interface.
+	BindAddress string `json:"bindAddress"`
+	// PublishIPv6 has apps publishing on every interface publish on [::]
+	// too, unless they say otherwise. It has no effect on hosts without
+	// IPv6.
+	PublishIPv6 bool `json:"publishIPv6"`
+
+	// DisabledFeatures are API feature areas (see Features) left out of
+	// the router, on top of those the -disable flag names. Routes are set
+	// up at startup, so a change needs a restart.
+	DisabledFeatures []string `json:"disabledFeatures"`
+
+	// SharedNetwork is the bridge network apps join under their slug so
+	// they can reach each other. Empty means DefaultSharedNetwork,
+	// SharedNetworkOff none. Containers pick up a change when recreated.
+	SharedNetwork string `json:"sharedNetwork"`
+
+	// AppdataDir is the host directory under which apps get a directory
+	// of their own (<appdataDir>/<slug>) for the bare container paths their
+	// manifest declares as volumes. Empty means DefaultAppdataDir.
+	AppdataDir string `json:"appdataDir"`
+
+	// GlobalEnv is set in every app's container, beneath the app's own
+	// variables. Timezone is passed as TZ beneath GlobalEnv: empty means
+	// the controller's own (DetectTimezone), TimezoneOff none. Containers
+	// pick up a change when recreated.
+	GlobalEnv map[string]string `json:"globalEnv"`
+	Timezone  string            `json:"timezone"`
+}
+
+// liveSettings are the settings (by JSON name) that take effect without a
+// restart, either because they are read on every use or because the
+// services caching them subscribe to changes. Anything else that changes is
+// reported as needing a restart.
+var liveSettings = map[string]bool{
+	"offlineBuilds":               true,
+	"portStrategy":                true,
+	"maxLogStreams":               true,
+	"maxLogStreamsPerApp":         true,
+	"maxBuildLogMB":               true,
+	"buildHistoryLimit":           true,
+	"previousImageDays":           true,
+	"imageTagsKeep":               true,
+	"updateCheckHours":            true,
+	"externalBaseUrl":             true,
+	"containerPrefix":             true,
+	"confirmActions":              true,
+	"portRangeStart":              true,
+	"portRangeEnd":                true,
+	"buildTimeoutMinutes":         true,
+	"gitOperationTimeoutMinutes":  true,
+	"dockerRequestTimeoutSeconds": true,
+	"containerStopTimeoutSeconds": true,
+	"selfUpdateTimeoutMinutes":    true,
+	"defaultUser":                 true,
+	"logMaxSize":                  true,
+	"logMaxFiles":                 true,
+	"httpProxy":                   true,
+	"httpsProxy":                  true,
+	"noProxy":                     true,
+	"promotionWindowMinutes":      true,
+	"metricsRetentionHours":       true,
+	"buildCooldownSeconds":        true,
+	"exitFlapSeconds":             true,
+	"bindMountPrefixes":           true,
+	"bindMountOwner":              true,
+	"bindAddress":                 true,
+	"publishIPv6":                 true,
+	"sharedNetwork":               true,
+	"appdataDir":                  true,
+	"globalEnv":                   true,
+	"timezone":                    true,
+}
+
+// SettingsChanges lists the settings an update changed, split by whether
+// they are already in effect.
+type SettingsChanges struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restartRequired"`
+}
+
+type SettingsService struct {
+	path     string
+	mu       sync.RWMutex
+	settings Settings
+
+	// subscribers are called, in order, after every Update.
+	subsMu      sync.Mutex
+	subscribers []func(Settings)
+}
+
+func NewSettingsService(dataDir string) (*SettingsService, error) {
+	s := &SettingsService{
+		path: filepath.Join(dataDir, "settings.json"),
+	}
+
+	data, err := readFileRecovering(s.path, validSettings)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.settings); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SettingsService) Get() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings
+}
+
+// Subscribe registers fn to be called with the settings whenever they are
+// updated, and once right away with the current ones. It's for services that
+// cache something derived from the settings.
+func (s *SettingsService) Subscribe(fn func(Settings)) {
+	s.subsMu.Lock()
+	s.subscribers = append(s.subscribers, fn)
+	s.subsMu.Unlock()
+	fn(s.Get())
+}
+
+// Update saves settings and notifies subscribers, returning which settings
+// changed.
+func (s *SettingsService) Update(settings Settings) (*SettingsChanges, error) {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if err := writeFileAtomic(s.path, data, 0600, validSettings); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	previous := s.settings
+	s.settings = settings
+	s.mu.Unlock()
+
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for _, fn := range s.subscribers {
+		fn(settings)
+	}
+	return diffSettings(previous, settings), nil
+}
+
+// diffSettings compares settings field by field through their JSON form.
+func diffSettings(previous, current Settings) *SettingsChanges {
+	var before, after map[string]json.RawMessage
+	data, _ := json.Marshal(previous)
+	json.Unmarshal(data, &before)
+	data, _ = json.Marshal(current)
+	json.Unmarshal(data, &after)
+
+	changes := &SettingsChanges{Applied: []string{}, RestartRequired: []string{}}
+	for name, value := range after {
+		if bytes.Equal(value, before[name]) {
+			continue
+		}
+		if liveSettings[name] {
+			changes.Applied = append(changes.Applied, name)
+		} else {
+			changes.RestartRequired = append(changes.RestartRequired, name)
+		}
+	}
+	sort.Strings(changes.Applied)
+	sort.Strings(changes.RestartRequired)
+	return changes
+}
+
+func validSettings(data []byte) bool {
+	var settings Settings
+	return json.Unmarshal(data, &settings) == nil
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"nas-controller/internal/database"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/docker/dockertest"
+	"nas-controller/internal/models"
+)
+
+func newTestSettings(t *testing.T) (*SettingsService, *database.DB) {
+	t.Helper()
+	settings, err := NewSettingsService(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return settings, db
+}
+
+func TestSettingsUpdateReportsLiveChanges(t *testing.T) {
+	settings, _ := newTestSettings(t)
+	changes, err := settings.Update(Settings{BuildTimeoutMinutes: 5, PortRangeStart: 20000, DisabledFeatures: []string{"metrics"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changes.Applied, []string{"buildTimeoutMinutes", "portRangeStart"}) {
+		t.Errorf("applied = %v", changes.Applied)
+	}
+	if !slices.Equal(changes.RestartRequired, []string{"disabledFeatures"}) {
+		t.Errorf("restart required = %v", changes.RestartRequired)
+	}
+}
+
+func TestPortRangeChangeAppliesToNextAllocation(t *testing.T) {
+	settings, db := newTestSettings(t)
+	ports := NewPortAllocator(db, nil, settings)
+
+	first, err := ports.AllocatePort("first", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first < DefaultPortRangeStart || first > DefaultPortRangeEnd {
+		t.Fatalf("port %d outside the default range", first)
+	}
+
+	current := settings.Get()
+	current.PortRangeStart, current.PortRangeEnd = 24000, 24050
+	if _, err := settings.Update(current); err != nil {
+		t.Fatal(err)
+	}
+	if start, end := ports.Range(); start != 24000 || end != 24050 {
+		t.Errorf("range = %d-%d, want 24000-24050", start, end)
+	}
+	second, err := ports.AllocatePort("second", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second < 24000 || second > 24050 {
+		t.Errorf("port %d outside the new range", second)
+	}
+}
+
+// deadlineBuilder records how long each build was given.
+type deadlineBuilder struct {
+	*dockertest.Fake
+	timeouts []time.Duration
+}
+
+func (b *deadlineBuilder) BuildImage(ctx context.Context, contextPath, dockerfilePath, imageName string, buildArgs map[string]string, networkMode string, options docker.BuildOptions, logWriter io.Writer) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		b.timeouts = append(b.timeouts, time.Until(deadline))
+	}
+	return b.Fake.BuildImage(ctx, contextPath, dockerfilePath, imageName, buildArgs, networkMode, options, logWriter)
+}
+
+func TestBuildTimeoutChangeAppliesToNextBuild(t *testing.T) {
+	settings, db := newTestSettings(t)
+	dataDir := t.TempDir()
+	builder := &deadlineBuilder{Fake: dockertest.New()}
+	builds := NewBuildService(db, builder, settings, dataDir)
+
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "Dockerfile"), []byte("FROM alpine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app := &models.App{ID: "app1", Name: "demo", Slug: "demo", ImageName: "demo:latest", DockerfilePath: "Dockerfile", LastCommit: "abc12345"}
+	if err := db.CreateApp(app); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithForceBuild(context.Background())
+	if err := builds.BuildApp(ctx, app, source, nil); err != nil {
+		t.Fatalf("BuildApp: %v", err)
+	}
+	current := settings.Get()
+	current.BuildTimeoutMinutes = 2
+	if _, err := settings.Update(current); err != nil {
+		t.Fatal(err)
+	}
+	if err := builds.BuildApp(ctx, app, source, nil); err != nil {
+		t.Fatalf("BuildApp: %v", err)
+	}
+
+	if len(builder.timeouts) != 2 {
+		t.Fatalf("timeouts = %v, want two builds", builder.timeouts)
+	}
+	near := func(got, want time.Duration) bool { return got <= want && got > want-time.Minute }
+	if !near(builder.timeouts[0], DefaultBuildTimeout) {
+		t.Errorf("first build had %s, want the default %s", builder.timeouts[0], DefaultBuildTimeout)
+	}
+	if !near(builder.timeouts[1], 2*time.Minute) {
+		t.Errorf("second build had %s, want 2m", builder.timeouts[1])
+	}
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrSetupComplete is returned by CompleteSetup once the controller has a
+// password.
+var ErrSetupComplete = errors.New("setup already complete")
+
+// MinPasswordLength is the shortest password setup and password changes
+// accept.
+const MinPasswordLength = 8
+
+// NeedsSetup reports whether the controller has no password yet, so the
+// first-run wizard is still open.
+func (s *AuthService) NeedsSetup() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.loadPassword()
+	return os.IsNotExist(err)
+}
+
+// CompleteSetup runs apply (saving the rest of the wizard's choices) and
+// then stores the first password. password.txt is what marks setup done
+// and it's written atomically last, so a crash part way leaves the wizard
+// open to run again, never a controller without a password. Concurrent
+// calls are serialized; all but the first get ErrSetupComplete.
+func (s *AuthService) CompleteSetup(password string, apply func() error) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.loadPassword(); err == nil {
+		return ErrSetupComplete
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if apply != nil {
+		if err := apply(); err != nil {
+			return err
+		}
+	}
+	return s.storePassword(password)
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShareTail(t *testing.T) {
+	if got := shareTail("short\nlog\n"); got != "short\nlog\n" {
+		t.Errorf("shareTail changed a log under the cap: %q", got)
+	}
+
+	line := strings.Repeat("x", 99) + "\n"
+	content := "first line is cut\n" + strings.Repeat(line, maxShareSize/len(line)+1)
+	got := shareTail(content)
+	if len(got) > maxShareSize {
+		t.Errorf("len = %d, over the cap", len(got))
+	}
+	if !strings.HasPrefix(got, line) || !strings.HasSuffix(content, got) {
+		t.Errorf("tail doesn't start at a whole line: %q", got[:20])
+	}
+}
+
+func TestShareRedactsBeforeCut(t *testing.T) {
+	secret := strings.Repeat("s", 64)
+	env := map[string]string{"API_TOKEN": secret}
+	// The secret straddles where a byte cut of the raw log would fall
+	content := "token " + secret + "\n" + strings.Repeat("y", maxShareSize-40) + "\n"
+
+	got := shareTail(RedactSecrets(content, env))
+	if strings.Contains(got, "ssss") {
+		t.Error("part of the secret survived the cut")
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// DefaultSharedNetwork is the bridge network every app joins, under its
+// slug, so apps can reach each other by name.
+const DefaultSharedNetwork = "nas-controller-net"
+
+// SharedNetworkOff in Settings.SharedNetwork keeps apps off any shared
+// network.
+const SharedNetworkOff = "off"
+
+// ValidateSharedNetwork checks the sharedNetwork setting: a Docker network
+// name, or SharedNetworkOff. Empty means DefaultSharedNetwork.
+func ValidateSharedNetwork(name string) error {
+	if name == "" || name == SharedNetworkOff {
+		return nil
+	}
+	if !containerNamePattern.MatchString(name) {
+		return fmt.Errorf("shared network must start with a letter or digit and contain only letters, digits, '_', '.' and '-', or be %q", SharedNetworkOff)
+	}
+	return nil
+}
+
+// SharedNetworkName is the shared app network, or "" when it's off.
+func (s *SettingsService) SharedNetworkName() string {
+	switch name := s.Get().SharedNetwork; name {
+	case "":
+		return DefaultSharedNetwork
+	case SharedNetworkOff:
+		return ""
+	default:
+		return name
+	}
+}
+
+// sharedNetwork makes sure the shared app network exists and returns how
+// app's containers join it: with the slug as alias, so replicas share one
+// name. It returns the zero value for isolated and host-network apps, and
+// when the network can't be created, in which case the app starts without
+// it rather than not at all.
+func (m *AppManager) sharedNetwork(ctx context.Context, app *models.App) docker.SharedNetwork {
+	shared := m.sharedNetworkFor(app)
+	if shared.Name == "" {
+		return shared
+	}
+	if err := m.dockerClient.EnsureNetwork(ctx, shared.Name); err != nil {
+		logf(ctx, "[warn] App %s: starting without the shared network: %v", app.Name, err)
+		return docker.SharedNetwork{}
+	}
+	return shared
+}
+
+// sharedNetworkFor is sharedNetwork without making sure the network exists.
+func (m *AppManager) sharedNetworkFor(app *models.App) docker.SharedNetwork {
+	name := m.settings.SharedNetworkName()
+	if name == "" || app.NetworkIsolated || app.NetworkMode == models.NetworkModeHost {
+		return docker.SharedNetwork{}
+	}
+	return docker.SharedNetwork{Name: name, Aliases: []string{app.Slug}}
+}
+
+// releaseSharedNetwork removes the shared app network once no container is
+// attached to it. Networks the controller didn't create are left alone.
+func (m *AppManager) releaseSharedNetwork(ctx context.Context) {
+	name := m.settings.SharedNetworkName()
+	if name == "" {
+		return
+	}
+	removed, err := m.dockerClient.RemoveNetworkIfUnused(ctx, name)
+	if err != nil {
+		logf(ctx, "[warn] Failed to remove unused network %s: %v", name, err)
+	} else if removed {
+		logf(ctx, "Removed network %s, no apps are attached to it", name)
+	}
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// Plan issue severities. An error would stop the start; a warning is
+// something the start works around.
+const (
+	PlanError   = "error"
+	PlanWarning = "warning"
+)
+
+// PlanIssue is one thing PlanStart found wrong with starting the app.
+// Check names what was checked: image, containerName, port, network,
+// ipAddress or volumes.
+type PlanIssue struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// planIssueFunc adds an issue to a plan.
+type planIssueFunc func(check, severity, format string, args ...interface{})
+
+// StartPlan is what starting the app would do, with secrets masked.
+type StartPlan struct {
+	Spec docker.ContainerSpec `json:"spec"`
+	// Services are the specs of a compose app's other services, by name.
+	Services map[string]docker.ContainerSpec `json:"services,omitempty"`
+	Replicas int                             `json:"replicas"`
+	Issues   []PlanIssue                     `json:"issues"`
+	// Ready is set when no issue is an error.
+	Ready bool `json:"ready"`
+	// Running is set when the app has a container, which Changes compares
+	// with Spec: what recreating it would change.
+	Running         bool                `json:"running"`
+	RestartRequired bool                `json:"restartRequired"`
+	Changes         []models.SpecChange `json:"changes"`
+}
+
+// containerSpec is the spec of the app's primary container. volumes are
+// the app's, already prepared, and shared its place on the shared network.
+func (m *AppManager) containerSpec(app *models.App, volumes []string, shared docker.SharedNetwork) docker.ContainerSpec {
+	return docker.ContainerSpec{
+		Name:          app.ContainerName,
+		Image:         runImage(app),
+		Platform:      app.Platform,
+		InternalPort:  app.InternalPort,
+		ExternalPort:  app.ExternalPort,
+		BindAddress:   m.bindAddress(app),
+		PublishIPv6:   m.publishIPv6(app),
+		Env:           m.containerEnv(app),
+		RestartPolicy: app.RestartPolicy,
+		MaxRetries:    app.MaxRetries,
+		Volumes:       volumes,
+		NetworkMode:   app.NetworkMode,
+		Network:       app.Network,
+		IPAddress:     app.IPAddress,
+		Networks:      []docker.SharedNetwork{shared},
+		GPU:           gpuConfig(app),
+		Devices:       app.Devices,
+		Security:      securityConfig(app),
+		Healthcheck:   healthcheckConfig(app),
+		Labels:        m.containerLabels(app, 1),
+		User:          app.User,
+		Entrypoint:    app.Entrypoint,
+		Cmd:           app.Command,
+		ExtraHosts:    app.ExtraHosts,
+		DNS:           app.DNS,
+		Sysctls:       app.Sysctls,
+		LogConfig:     m.logConfig(app),
+		Hostname:      app.Hostname,
+		Resources:     resourceConfig(app),
+	}
+}
+
+// PlanStart builds the container spec StartApp would create the app's
+// container from, and checks what StartApp checks, without creating,
+// removing or changing anything. The container name is the one the start
+// would migrate to, and missing bind mount directories are reported rather
+// than created.
+func (m *AppManager) PlanStart(ctx context.Context, appID string) (*StartPlan, error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+	planned := *app
+	planned.ContainerName = m.canonicalContainerName(app)
+	if planned.NetworkMode == models.NetworkModeHost {
+		planned.ExternalPort = planned.InternalPort
+	}
+
+	plan := &StartPlan{Replicas: max(app.Replicas, 1), RestartRequired: app.RestartRequired}
+	issue := func(check, severity, format string, args ...interface{}) {
+		plan.Issues = append(plan.Issues, PlanIssue{Check: check, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if err := m.CheckContainerName(ctx, &planned); err != nil {
+		issue("containerName", PlanError, "%v", err)
+	}
+	if _, err := m.resolvedEnv(&planned); err != nil {
+		issue("env", PlanError, "%v", err)
+	}
+	if planned.NetworkMode != models.NetworkModeHost {
+		m.planPort(ctx, &planned, issue)
+	}
+	if planned.Network != "" && planned.NetworkMode != models.NetworkModeHost {
+		if exists, err := m.dockerClient.NetworkExists(ctx, planned.Network); err == nil && !exists {
+			issue("network", PlanError, "docker network %q does not exist; create it or pick another network", planned.Network)
+		} else if planned.IPAddress != "" {
+			if err := m.dockerClient.CheckStaticIP(ctx, planned.Network, planned.IPAddress, planned.ContainerName); err != nil {
+				issue("ipAddress", PlanError, "%v", err)
+			}
+		}
+	}
+
+	volumes := m.planVolumes(planned.Volumes, "", issue)
+	shared := m.sharedNetworkFor(&planned)
+	if planned.ComposeFile == "" {
+		plan.Spec = m.containerSpec(&planned, volumes, shared)
+	} else if err := m.planCompose(&planned, volumes, shared, plan, issue); err != nil {
+		issue("compose", PlanError, "%v", err)
+	}
+
+	if plan.Spec.Image != "" {
+		if _, err := m.dockerClient.GetImageSize(ctx, plan.Spec.Image); err != nil {
+			issue("image", PlanWarning, "image %s is not available (%v); starting will rebuild it first", plan.Spec.Image, err)
+		}
+	}
+
+	if running, _ := m.dockerClient.GetAppContainer(ctx, app.ID, 1); running != nil {
+		plan.Running = true
+		plan.Changes = containerChanges(running.Image, running.Labels, publishedPort(running.Ports, planned.InternalPort), plan.Spec)
+	}
+	if plan.Changes == nil {
+		plan.Changes = []models.SpecChange{}
+	}
+	if plan.Issues == nil {
+		plan.Issues = []PlanIssue{}
+	}
+
+	plan.Ready = true
+	for _, i := range plan.Issues {
+		if i.Severity == PlanError {
+			plan.Ready = false
+		}
+	}
+	plan.Spec.Env = maskEnv(plan.Spec.Env)
+	for name, spec := range plan.Services {
+		spec.Env = maskEnv(spec.Env)
+		plan.Services[name] = spec
+	}
+	return plan, nil
+}
+
+// planPort reports what reclaimPort would find: containers it would remove
+// from the app's port, and whether the app would move to another one.
+func (m *AppManager) planPort(ctx context.Context, app *models.App, issue planIssueFunc) {
+	ownOnly := true
+	holders, _ := m.dockerClient.GetContainersOnPort(ctx, app.ExternalPort)
+	for _, c := range holders {
+		if c.Labels[docker.AppIDLabel] == app.ID {
+			continue
+		}
+		ownOnly = false
+		issue("port", PlanWarning, "container %s holds port %d and will be removed", containerDisplayName(c.Names, c.ID), app.ExternalPort)
+	}
+	if len(holders) > 0 && ownOnly {
+		return
+	}
+	if !m.portAllocator.IsPortAvailableForApp(app.ExternalPort, app.ID, m.bindAddress(app)) {
+		issue("port", PlanWarning, "port %d is in use; the app will be moved to the next free port", app.ExternalPort)
+	}
+}
+
+// planVolumes is prepareVolumes as a dry run: problems and directories it
+// would create are reported instead. service names the compose service the
+// volumes belong to, if any.
+func (m *AppManager) planVolumes(appVolumes []string, service string, issue planIssueFunc) []string {
+	prefix := ""
+	if service != "" {
+		prefix = "service " + service + ": "
+	}
+	volumes := make([]string, 0, len(appVolumes))
+	for _, volume := range appVolumes {
+		v, missing, err := m.checkVolume(volume)
+		if err != nil {
+			issue("volumes", PlanError, "%s%v", prefix, err)
+		}
+		if v == nil {
+			continue
+		}
+		volumes = append(volumes, v.docker())
+		if missing {
+			issue("volumes", PlanWarning, "%svolume %q: host path %s does not exist and will be created", prefix, volume, bindSource(v))
+		}
+	}
+	return volumes
+}
+
+// planCompose fills in the specs of a compose app's services.
+func (m *AppManager) planCompose(app *models.App, volumes []string, shared docker.SharedNetwork, plan *StartPlan, issue planIssueFunc) error {
+	project, err := m.loadCompose(app)
+	if err != nil {
+		return err
+	}
+	if project.Services[app.ComposeService] == nil {
+		return fmt.Errorf("compose file no longer defines service %s", app.ComposeService)
+	}
+	appdataDir := m.settings.Get().AppdataDir
+	if appdataDir == "" {
+		appdataDir = DefaultAppdataDir
+	}
+
+	plan.Services = map[string]docker.ContainerSpec{}
+	for name, svc := range project.Services {
+		serviceVolumes, err := composeVolumes(app, appdataDir, svc.Volumes)
+		if err != nil {
+			issue("volumes", PlanError, "service %s: %v", name, err)
+		} else {
+			serviceVolumes = m.planVolumes(serviceVolumes, name, issue)
+		}
+		spec := m.composeServiceSpec(app, name, svc, serviceVolumes, volumes, shared)
+		if name == app.ComposeService {
+			plan.Spec = spec
+		} else {
+			plan.Services[name] = spec
+		}
+	}
+	return nil
+}
+
+// containerChanges compares a running container's image, labels and
+// published port with spec.
+func containerChanges(image string, labels map[string]string, port int, spec docker.ContainerSpec) []models.SpecChange {
+	var changes []models.SpecChange
+	if image != spec.Image {
+		changes = append(changes, models.SpecChange{Field: "image", From: image, To: spec.Image})
+	}
+	if spec.InternalPort != 0 && spec.NetworkMode != models.NetworkModeHost && port != spec.ExternalPort {
+		changes = append(changes, models.SpecChange{Field: "externalPort", From: port, To: spec.ExternalPort})
+	}
+
+	keys := map[string]bool{}
+	for k := range labels {
+		keys[k] = true
+	}
+	for k := range spec.Labels {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		from, hadOld := labels[k]
+		to, hasNew := spec.Labels[k]
+		if from == to && hadOld == hasNew {
+			continue
+		}
+		change := models.SpecChange{Field: "labels." + k}
+		if hadOld {
+			change.From = from
+		}
+		if hasNew {
+			change.To = to
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// publishedPort is the host port internalPort is published on, or 0.
+func publishedPort(ports []types.Port, internalPort int) int {
+	for _, p := range ports {
+		if int(p.PrivatePort) == internalPort && p.PublicPort != 0 {
+			return int(p.PublicPort)
+		}
+	}
+	return 0
+}
+
+// containerDisplayName is the container's name, or its short ID if it has
+// none.
+func containerDisplayName(names []string, id string) string {
+	if len(names) > 0 {
+		return strings.TrimPrefix(names[0], "/")
+	}
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+const (
+	// DefaultPromotionWindowMinutes is how long an app may stay starting
+	// when Settings.PromotionWindowMinutes is unset.
+	DefaultPromotionWindowMinutes = 5
+	// stateWatchdogInterval is how often transient statuses are checked.
+	// A building app or a flow with nothing behind it is repaired on the
+	// second sighting, so a status written just before its worker starts
+	// is never mistaken for a dead one.
+	stateWatchdogInterval = time.Minute
+)
+
+// stateSighting is when the watchdog first saw an app in its current
+// status.
+type stateSighting struct {
+	status    models.AppStatus
+	subStatus models.AppStatus
+	since     time.Time
+}
+
+// StateRepair describes a status the watchdog corrected.
+type StateRepair struct {
+	From          models.AppStatus `json:"from"`
+	FromSubStatus models.AppStatus `json:"fromSubStatus,omitempty"`
+	To            models.AppStatus `json:"to"`
+	Reason        string           `json:"reason"`
+}
+
+// RunStateWatchdog checks apps in transient statuses against what is
+// actually happening every stateWatchdogInterval, until ctx is done.
+// ReconcileStates does the same for every app at startup; this catches
+// statuses left behind while the controller keeps running, such as by a
+// panicked goroutine or a Docker call that never returned.
+func (m *AppManager) RunStateWatchdog(ctx context.Context) {
+	ticker := time.NewTicker(stateWatchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.checkStates(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *AppManager) checkStates(ctx context.Context) {
+	apps, err := m.db.GetAllApps()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool, len(apps))
+	for _, app := range apps {
+		seen[app.ID] = true
+		age := m.sight(app, now)
+		if _, err := m.repairState(ctx, app, age, false); err != nil {
+			log.Printf("State watchdog: %s: %v", app.Slug, err)
+		}
+	}
+
+	m.sightingsMu.Lock()
+	for appID := range m.sightings {
+		if !seen[appID] {
+			delete(m.sightings, appID)
+		}
+	}
+	m.sightingsMu.Unlock()
+}
+
+// sight records the app's status and returns how long it has been in it.
+// The clock starts at the first sighting, so after a controller restart an
+// app gets a full window again.
+func (m *AppManager) sight(app *models.App, now time.Time) time.Duration {
+	m.sightingsMu.Lock()
+	defer m.sightingsMu.Unlock()
+	s, ok := m.sightings[app.ID]
+	if !ok || s.status != app.Status || s.subStatus != app.SubStatus {
+		s = stateSighting{status: app.Status, subStatus: app.SubStatus, since: now}
+		m.sightings[app.ID] = s
+	}
+	return now.Sub(s.since)
+}
+
+// RepairState checks the app's status against reality straight away,
+// without waiting out the windows the periodic check allows. It returns nil
+// if the status was not stuck.
+func (m *AppManager) RepairState(ctx context.Context, appID string) (*StateRepair, error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+	return m.repairState(ctx, app, 0, true)
+}
+
+// repairState settles app on the status reality says it should have, if it
+// has been in a transient one for too long with nothing behind it:
+//
+//	building, with no build worker or lease          -> build-failed (interrupted)
+//	starting, past the promotion window              -> running, or error if the container isn't up
+//	updating/deploying, with no flow in this process -> as above for a building step, else running or stopped
+//
+// age is how long the app has been in its status; force ignores it.
+func (m *AppManager) repairState(ctx context.Context, app *models.App, age time.Duration, force bool) (*StateRepair, error) {
+	repair := &StateRepair{From: app.Status, FromSubStatus: app.SubStatus}
+	step := app.Status
+	if isCompositeStatus(app.Status) {
+		if m.inFlow(app.ID) || (!force && age < stateWatchdogInterval) {
+			return nil, nil
+		}
+		step = app.SubStatus
+	}
+
+	switch {
+	case step == models.StatusBuilding:
+		if building, _, ok := m.buildService.CurrentBuild(); (ok && building == app.ID) || m.buildService.Queued(app.ID) || m.db.HasBuildLease(app.ID) {
+			return nil, nil
+		}
+		if !force && age < stateWatchdogInterval {
+			return nil, nil
+		}
+		repair.To = models.StatusBuildFailed
+		repair.Reason = "no build is in progress"
+
+	case step == models.StatusStarting || isCompositeStatus(app.Status):
+		if step == models.StatusStarting && !force && age < m.promotionWindow() {
+			return nil, nil
+		}
+		containerID, state := m.findContainer(ctx, app, 1)
+		running := state == "running" || (app.Replicas > 1 && m.anyReplicaRunning(ctx, app))
+		if containerID != "" {
+			app.ContainerID = containerID
+		}
+		switch {
+		case running:
+			repair.To = models.StatusRunning
+			repair.Reason = "the container is running"
+		case step == models.StatusStarting:
+			repair.To = models.StatusError
+			repair.Reason = "the container did not start"
+		default:
+			repair.To = models.StatusStopped
+			repair.Reason = "the container is not running"
+		}
+		if isCompositeStatus(app.Status) {
+			repair.Reason = "no " + string(app.Status) + " is in progress and " + repair.Reason
+		}
+
+	default:
+		return nil, nil
+	}
+
+	// Don't clobber a change made since app was read
+	current, err := m.db.GetApp(app.ID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status != repair.From || current.SubStatus != repair.FromSubStatus {
+		return nil, nil
+	}
+
+	app.Status, app.SubStatus = repair.To, ""
+	switch repair.To {
+	case models.StatusBuildFailed:
+		app.LastBuildSuccess = false
+		app.LastBuildFailure = BuildFailureInterrupted
+	case models.StatusError:
+		app.LastError = "start did not finish: " + repair.Reason
+	}
+	if err := m.db.UpdateApp(app); err != nil {
+		return nil, err
+	}
+
+	from := string(repair.From)
+	if repair.FromSubStatus != "" {
+		from += " (" + string(repair.FromSubStatus) + ")"
+	}
+	detail := fmt.Sprintf("%s -> %s: %s", from, repair.To, repair.Reason)
+	m.db.CreateAppEvent(&models.AppEvent{
+		AppID:     app.ID,
+		Replica:   1,
+		Reason:    models.EventReasonStateRepaired,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}, appEventLimit)
+	log.Printf("[repair] App %s: %s", app.Slug, detail)
+	return repair, nil
+}
+
+func (m *AppManager) promotionWindow() time.Duration {
+	minutes := m.settings.Get().PromotionWindowMinutes
+	if minutes <= 0 {
+		minutes = DefaultPromotionWindowMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Defaults used when the settings leave the stream caps at zero.
+const (
+	DefaultMaxLogStreams       = 20
+	DefaultMaxLogStreamsPerApp = 5
+)
+
+// StreamLimiter counts open container log streams. Each one holds a docker
+// follow connection, so leaked browser tabs translate directly into daemon
+// load.
+type StreamLimiter struct {
+	settings *SettingsService
+	mu       sync.Mutex
+	total    int
+	perApp   map[string]int
+}
+
+func NewStreamLimiter(settings *SettingsService) *StreamLimiter {
+	return &StreamLimiter{
+		settings: settings,
+		perApp:   make(map[string]int),
+	}
+}
+
+// Acquire claims a stream slot for appID. The returned release func must be
+// called exactly once when the stream ends; calling it again is a no-op.
+func (l *StreamLimiter) Acquire(appID string) (func(), error) {
+	maxTotal, maxPerApp := l.limits()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.total >= maxTotal {
+		return nil, fmt.Errorf("too many open log streams (limit %d)", maxTotal)
+	}
+	if l.perApp[appID] >= maxPerApp {
+		return nil, fmt.Errorf("too many open log streams for this app (limit %d)", maxPerApp)
+	}
+
+	l.total++
+	l.perApp[appID]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.total--
+			if l.perApp[appID]--; l.perApp[appID] <= 0 {
+				delete(l.perApp, appID)
+			}
+		})
+	}, nil
+}
+
+// Counts returns the number of open streams overall and per app.
+func (l *StreamLimiter) Counts() (int, map[string]int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	perApp := make(map[string]int, len(l.perApp))
+	for id, n := range l.perApp {
+		perApp[id] = n
+	}
+	return l.total, perApp
+}
+
+func (l *StreamLimiter) limits() (int, int) {
+	settings := l.settings.Get()
+	maxTotal, maxPerApp := settings.MaxLogStreams, settings.MaxLogStreamsPerApp
+	if maxTotal <= 0 {
+		maxTotal = DefaultMaxLogStreams
+	}
+	if maxPerApp <= 0 {
+		maxPerApp = DefaultMaxLogStreamsPerApp
+	}
+	return maxTotal, maxPerApp
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"nas-controller/internal/models"
+)
+
+// sysctlNames and sysctlPrefixes are the sysctls Docker lets a container
+// set: the ones belonging to its IPC and network namespaces. Anything else
+// would change the host and is refused at container creation.
+var (
+	sysctlNames = map[string]bool{
+		"kernel.msgmax": true, "kernel.msgmnb": true, "kernel.msgmni": true,
+		"kernel.sem": true, "kernel.shmall": true, "kernel.shmmax": true,
+		"kernel.shmmni": true, "kernel.shm_rmid_forced": true,
+	}
+	sysctlPrefixes = []string{"fs.mqueue.", "net."}
+)
+
+// ValidateSysctls checks an app's sysctls are ones Docker allows, naming
+// every key that isn't. net.* sysctls belong to the network namespace, so
+// they can't be set on a host-network app.
+func ValidateSysctls(sysctls map[string]string, networkMode string) error {
+	var invalid, hostNet []string
+	for key, value := range sysctls {
+		if !sysctlAllowed(key) {
+			invalid = append(invalid, key)
+			continue
+		}
+		if networkMode == models.NetworkModeHost && strings.HasPrefix(key, "net.") {
+			hostNet = append(hostNet, key)
+			continue
+		}
+		if strings.TrimSpace(value) == "" || strings.ContainsAny(value, "\n\x00") {
+			return fmt.Errorf("sysctl %s: invalid value %q", key, value)
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return fmt.Errorf("sysctls not allowed in a container: %s (only net.*, fs.mqueue.* and kernel.msg*/sem/shm* can be set)",
+			strings.Join(invalid, ", "))
+	}
+	if len(hostNet) > 0 {
+		sort.Strings(hostNet)
+		return fmt.Errorf("sysctls %s cannot be set with host networking", strings.Join(hostNet, ", "))
+	}
+	return nil
+}
+
+func sysctlAllowed(key string) bool {
+	if sysctlNames[key] {
+		return true
+	}
+	for _, prefix := range sysctlPrefixes {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
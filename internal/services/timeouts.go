@@ -0,0 +1,72 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"nas-controller/internal/docker"
+)
+
+// Defaults for the operation timeouts, used when the matching setting is 0.
+const (
+	DefaultBuildTimeout         = 30 * time.Minute
+	DefaultGitOperationTimeout  = 10 * time.Minute
+	DefaultDockerRequestTimeout = docker.DefaultRequestTimeout
+	DefaultContainerStopTimeout = docker.DefaultStopTimeout
+	DefaultSelfUpdateTimeout    = 15 * time.Minute
+)
+
+// Timeouts are the effective bounds on the controller's outbound
+// operations, from the settings with defaults filled in.
+type Timeouts struct {
+	// Build bounds one image build.
+	Build time.Duration
+	// GitOperation bounds one git clone or fetch, for apps and for the
+	// controller's own self-update check.
+	GitOperation time.Duration
+	// DockerRequest bounds one Docker API request. Streams (builds, pulls,
+	// logs, events) and prunes are exempt; see docker.Client.SetTimeouts.
+	DockerRequest time.Duration
+	// ContainerStop is how long a container gets to exit after SIGTERM
+	// before it is killed.
+	ContainerStop time.Duration
+	// SelfUpdate bounds a whole controller self-update.
+	SelfUpdate time.Duration
+}
+
+// MarshalJSON writes each timeout as a duration string such as "30m0s".
+func (t Timeouts) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"buildTimeout":         t.Build.String(),
+		"gitOperationTimeout":  t.GitOperation.String(),
+		"dockerRequestTimeout": t.DockerRequest.String(),
+		"containerStopTimeout": t.ContainerStop.String(),
+		"selfUpdateTimeout":    t.SelfUpdate.String(),
+	})
+}
+
+// Timeouts returns the effective operation timeouts.
+func (s Settings) Timeouts() Timeouts {
+	orDefault := func(value int, unit, fallback time.Duration) time.Duration {
+		if value <= 0 {
+			return fallback
+		}
+		return time.Duration(value) * unit
+	}
+	return Timeouts{
+		Build:         orDefault(s.BuildTimeoutMinutes, time.Minute, DefaultBuildTimeout),
+		GitOperation:  orDefault(s.GitOperationTimeoutMinutes, time.Minute, DefaultGitOperationTimeout),
+		DockerRequest: orDefault(s.DockerRequestTimeoutSeconds, time.Second, DefaultDockerRequestTimeout),
+		ContainerStop: orDefault(s.ContainerStopTimeoutSeconds, time.Second, DefaultContainerStopTimeout),
+		SelfUpdate:    orDefault(s.SelfUpdateTimeoutMinutes, time.Minute, DefaultSelfUpdateTimeout),
+	}
+}
+
+// ApplyDockerTimeouts keeps the Docker client's request and stop timeouts
+// in step with the settings.
+func ApplyDockerTimeouts(settings *SettingsService, dockerClient *docker.Client) {
+	settings.Subscribe(func(s Settings) {
+		timeouts := s.Timeouts()
+		dockerClient.SetTimeouts(timeouts.DockerRequest, timeouts.ContainerStop)
+	})
+}
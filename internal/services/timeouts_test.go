@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSettingsTimeouts(t *testing.T) {
+	defaults := Timeouts{
+		Build:         DefaultBuildTimeout,
+		GitOperation:  DefaultGitOperationTimeout,
+		DockerRequest: DefaultDockerRequestTimeout,
+		ContainerStop: DefaultContainerStopTimeout,
+		SelfUpdate:    DefaultSelfUpdateTimeout,
+	}
+	tests := []struct {
+		name     string
+		settings Settings
+		want     Timeouts
+	}{
+		{name: "unset", want: defaults},
+		{name: "negative", settings: Settings{BuildTimeoutMinutes: -1, DockerRequestTimeoutSeconds: -5}, want: defaults},
+		{
+			name: "set",
+			settings: Settings{
+				BuildTimeoutMinutes:         45,
+				GitOperationTimeoutMinutes:  3,
+				DockerRequestTimeoutSeconds: 90,
+				ContainerStopTimeoutSeconds: 10,
+				SelfUpdateTimeoutMinutes:    20,
+			},
+			want: Timeouts{
+				Build:         45 * time.Minute,
+				GitOperation:  3 * time.Minute,
+				DockerRequest: 90 * time.Second,
+				ContainerStop: 10 * time.Second,
+				SelfUpdate:    20 * time.Minute,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.settings.Timeouts(); got != tt.want {
+				t.Errorf("Timeouts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGitTimeoutWithSlowRemote clones from a remote that accepts the
+// connection and never answers.
+func TestGitTimeoutWithSlowRemote(t *testing.T) {
+	dropped := make(chan struct{}, 1)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		dropped <- struct{}{}
+	}))
+	defer remote.Close()
+
+	settings, _ := newTestSettings(t)
+	git := NewGitService(t.TempDir(), settings)
+	dest := filepath.Join(t.TempDir(), "clone")
+
+	start := time.Now()
+	_, err := git.run(context.Background(), "clone", GitPriorityInteractive, 200*time.Millisecond,
+		"clone", remote.URL+"/acme/demo.git", dest)
+	elapsed := time.Since(start)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("clone gave up after %s", elapsed)
+	}
+
+	// git's helper went with it, rather than holding the connection open
+	select {
+	case <-dropped:
+	case <-time.After(5 * time.Second):
+		t.Error("remote still connected after the timeout")
+	}
+
+	// The slot is free again for the next command
+	if _, err := git.run(context.Background(), "version", GitPriorityInteractive, time.Second, "version"); err != nil {
+		t.Errorf("git version after the timeout: %v", err)
+	}
+}
+
+// slowGitHub sends git's requests for github.com/acme repos to remote.
+func slowGitHub(t *testing.T, remote string) {
+	t.Helper()
+	gitConfig := filepath.Join(t.TempDir(), "gitconfig")
+	config := fmt.Sprintf("[url \"%s/acme/\"]\n\tinsteadOf = https://github.com/acme/\n", remote)
+	if err := os.WriteFile(gitConfig, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", gitConfig)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+}
+
+// TestGitTimeoutOnCancelledCaller checks the caller's context still wins
+// over a longer operation timeout.
+func TestGitTimeoutOnCancelledCaller(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer remote.Close()
+	slowGitHub(t, remote.URL)
+
+	settings, _ := newTestSettings(t)
+	git := NewGitService(t.TempDir(), settings)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := git.CloneRepo(ctx, "https://github.com/acme/demo", "main")
+	if err == nil {
+		t.Fatal("clone from a silent remote succeeded")
+	}
+	if !strings.Contains(err.Error(), "git clone failed") {
+		t.Errorf("err = %v, want the clone to have run", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("clone gave up after %s, want the caller's 200ms", elapsed)
+	}
+}
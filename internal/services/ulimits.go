@@ -0,0 +1,54 @@
+package services
+
+import (
+	"fmt"
+
+	"nas-controller/internal/docker"
+	"nas-controller/internal/models"
+)
+
+// ulimitNames are the ulimits Docker accepts, as --ulimit names them.
+var ulimitNames = map[string]bool{
+	"as": true, "core": true, "cpu": true, "data": true, "fsize": true,
+	"locks": true, "memlock": true, "msgqueue": true, "nice": true,
+	"nofile": true, "nproc": true, "rss": true, "rtprio": true,
+	"rttime": true, "sigpending": true, "stack": true,
+}
+
+// ValidateUlimits checks an app's ulimits: known names, each once, and a
+// soft limit no higher than the hard one. -1 is unlimited, except for
+// nofile, which the kernel caps.
+func ValidateUlimits(ulimits []models.Ulimit) error {
+	seen := make(map[string]bool, len(ulimits))
+	for _, u := range ulimits {
+		if !ulimitNames[u.Name] {
+			return fmt.Errorf("ulimit %q: unknown name", u.Name)
+		}
+		if seen[u.Name] {
+			return fmt.Errorf("ulimit %q is set more than once", u.Name)
+		}
+		seen[u.Name] = true
+
+		if u.Soft < -1 || u.Hard < -1 {
+			return fmt.Errorf("ulimit %s: limits must be -1 (unlimited) or more", u.Name)
+		}
+		if u.Name == "nofile" && (u.Soft == -1 || u.Hard == -1) {
+			return fmt.Errorf("ulimit nofile cannot be unlimited; use a number such as 65536")
+		}
+		if u.Hard != -1 && (u.Soft == -1 || u.Soft > u.Hard) {
+			return fmt.Errorf("ulimit %s: soft limit %d exceeds hard limit %d", u.Name, u.Soft, u.Hard)
+		}
+	}
+	return nil
+}
+
+func dockerUlimits(ulimits []models.Ulimit) []docker.Ulimit {
+	if len(ulimits) == 0 {
+		return nil
+	}
+	out := make([]docker.Ulimit, 0, len(ulimits))
+	for _, u := range ulimits {
+		out = append(out, docker.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+const (
+	// DefaultUpdateCheckHours is Settings.UpdateCheckHours when unset.
+	DefaultUpdateCheckHours = 6
+	// updateCheckTick is how often the poller looks for apps whose last
+	// check is older than the interval.
+	updateCheckTick = 15 * time.Minute
+	// updateCheckSpacing is the gap between the fetches a sweep starts, so
+	// apps on the same host aren't all fetched at once.
+	updateCheckSpacing = 2 * time.Second
+)
+
+// UpdateSweepApp is one app's result in an update sweep.
+type UpdateSweepApp struct {
+	AppID           string `json:"appId"`
+	Name            string `json:"name"`
+	UpdateAvailable bool   `json:"updateAvailable"`
+	LocalCommit     string `json:"localCommit,omitempty"`
+	RemoteCommit    string `json:"remoteCommit,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// UpdateSweep is the result of checking the apps' remotes for new commits.
+// Skipped counts the apps with no remote to check: uploads and local
+// paths.
+type UpdateSweep struct {
+	Checked   int              `json:"checked"`
+	Available int              `json:"available"`
+	Failed    int              `json:"failed"`
+	Skipped   int              `json:"skipped"`
+	Duration  string           `json:"duration"`
+	Apps      []UpdateSweepApp `json:"apps"`
+}
+
+// RunUpdateChecks checks each git app's remote for new commits every
+// UpdateCheckHours until ctx is done, so the app list can show available
+// updates without fetching.
+func (m *AppManager) RunUpdateChecks(ctx context.Context) {
+	for {
+		sweep := m.SweepUpdates(ctx, false)
+		if sweep.Checked > 0 {
+			log.Printf("Update check: checked %d apps, %d with updates, %d failed", sweep.Checked, sweep.Available, sweep.Failed)
+		}
+		select {
+		case <-time.After(updateCheckTick):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SweepUpdates checks the git apps whose last check is older than the
+// interval, or all of them with force. Fetches are started
+// updateCheckSpacing apart and run at background priority in the git
+// pool. Only one sweep runs at a time; a second waits for it.
+func (m *AppManager) SweepUpdates(ctx context.Context, force bool) *UpdateSweep {
+	m.sweepMu.Lock()
+	defer m.sweepMu.Unlock()
+
+	start := time.Now()
+	sweep := &UpdateSweep{Apps: []UpdateSweepApp{}}
+	apps, err := m.db.GetAllApps()
+	if err != nil {
+		log.Printf("Update check: failed to list apps: %v", err)
+		return sweep
+	}
+
+	cutoff := start.Add(-m.updateCheckInterval())
+	var due []*models.App
+	for _, app := range apps {
+		if app.SourceType == models.SourceTypeUpload || IsLocalPath(app.RepoURL) {
+			sweep.Skipped++
+			continue
+		}
+		if !force && app.LastChecked != nil && app.LastChecked.After(cutoff) {
+			continue
+		}
+		due = append(due, app)
+	}
+
+	results := make([]UpdateSweepApp, len(due))
+	var wg sync.WaitGroup
+	started := 0
+	for i, app := range due {
+		if i > 0 {
+			select {
+			case <-time.After(updateCheckSpacing):
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		started++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = UpdateSweepApp{AppID: app.ID, Name: app.Name}
+			result, err := m.CheckAppUpdate(ctx, app.ID)
+			if err != nil {
+				results[i].Error = sanitizeRemoteError(err)
+				return
+			}
+			results[i].UpdateAvailable = result.HasUpdate
+			results[i].LocalCommit = result.LocalCommit
+			results[i].RemoteCommit = result.RemoteCommit
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range results[:started] {
+		sweep.Checked++
+		if result.Error != "" {
+			sweep.Failed++
+		} else if result.UpdateAvailable {
+			sweep.Available++
+		}
+		sweep.Apps = append(sweep.Apps, result)
+	}
+	sweep.Duration = time.Since(start).Round(time.Millisecond).String()
+	return sweep
+}
+
+func (m *AppManager) updateCheckInterval() time.Duration {
+	hours := m.settings.Get().UpdateCheckHours
+	if hours == 0 {
+		hours = DefaultUpdateCheckHours
+	}
+	return time.Duration(hours) * time.Hour
+}
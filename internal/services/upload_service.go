@@ -0,0 +1,160 @@
+package services
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// MaxUploadBytes caps an uploaded build context as sent (gzipped).
+const MaxUploadBytes = 256 << 20
+
+// maxUploadExtractedBytes caps the same context unpacked, so a small
+// archive can't fill the disk.
+const maxUploadExtractedBytes = 2 << 30
+
+// stagingPrefix marks directories an upload is unpacked into before it
+// replaces the app's source. Slugs can't start with a dot, so these never
+// collide with an app.
+const stagingPrefix = ".staging-"
+
+// UploadService stores build contexts uploaded as tarballs, one directory
+// per app under repos/uploads/.
+type UploadService struct {
+	uploadsDir string
+	mu         sync.Mutex
+}
+
+func NewUploadService(dataDir string) *UploadService {
+	uploadsDir := filepath.Join(dataDir, "repos", "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+
+	// Uploads interrupted by a restart
+	if stale, err := filepath.Glob(filepath.Join(uploadsDir, stagingPrefix+"*")); err == nil {
+		for _, dir := range stale {
+			os.RemoveAll(dir)
+		}
+	}
+
+	return &UploadService{uploadsDir: uploadsDir}
+}
+
+// Path returns where slug's uploaded source lives.
+func (s *UploadService) Path(slug string) string {
+	return filepath.Join(s.uploadsDir, slug)
+}
+
+// Receive unpacks a gzipped tarball from r into a staging directory and
+// returns its path. The caller hands it to Commit or Discard.
+func (s *UploadService) Receive(r io.Reader) (string, error) {
+	staged, err := os.MkdirTemp(s.uploadsDir, stagingPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	if err := extractTarGz(r, staged); err != nil {
+		os.RemoveAll(staged)
+		return "", err
+	}
+	return staged, nil
+}
+
+// Commit replaces slug's source with the staged upload. The swap is two
+// renames, so a build never sees a half-written context.
+func (s *UploadService) Commit(staged, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	final := s.Path(slug)
+	previous := staged + ".previous"
+	hadPrevious := false
+	if _, err := os.Stat(final); err == nil {
+		if err := os.Rename(final, previous); err != nil {
+			return fmt.Errorf("failed to replace upload: %v", err)
+		}
+		hadPrevious = true
+	}
+	if err := os.Rename(staged, final); err != nil {
+		if hadPrevious {
+			os.Rename(previous, final)
+		}
+		return fmt.Errorf("failed to replace upload: %v", err)
+	}
+	os.RemoveAll(previous)
+	return nil
+}
+
+// Discard drops a staged upload that won't be committed.
+func (s *UploadService) Discard(staged string) {
+	os.RemoveAll(staged)
+}
+
+func (s *UploadService) Remove(slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.RemoveAll(s.Path(slug))
+}
+
+// extractTarGz unpacks directories and regular files into dest. Links are
+// refused rather than skipped: the controller reads files from the context
+// (manifest, icon) and must not be pointed outside it.
+func extractTarGz(r io.Reader, dest string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("upload is not a gzipped tarball: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tarball: %w", err)
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if name == "." {
+			continue
+		}
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(os.PathSeparator)) {
+			return fmt.Errorf("tarball entry %q is outside the build context", hdr.Name)
+		}
+		target := filepath.Join(dest, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			total += hdr.Size
+			if total > maxUploadExtractedBytes {
+				return fmt.Errorf("build context exceeds %d MB unpacked", maxUploadExtractedBytes>>20)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm()|0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, io.LimitReader(tr, hdr.Size))
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("failed to unpack %s: %w", hdr.Name, err)
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return fmt.Errorf("tarball entry %q is a link; links aren't supported in uploaded build contexts", hdr.Name)
+		default:
+			// Devices, FIFOs and the like have no place in a build context
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+}
+
+func tarGz(t testing.TB, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.body))}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+			hdr.Linkname = "/etc/passwd"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Skip(err)
+		}
+		if e.typeflag == tar.TypeReg {
+			tw.Write([]byte(e.body))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestExtractTarGz(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		wantErr string
+		want    []string
+	}{
+		{
+			name: "files and directories",
+			entries: []tarEntry{
+				{name: "src/", typeflag: tar.TypeDir},
+				{name: "Dockerfile", typeflag: tar.TypeReg, body: "FROM alpine\n"},
+				{name: "./src/main.go", typeflag: tar.TypeReg, body: "package main\n"},
+			},
+			want: []string{"Dockerfile", "src", "src/main.go"},
+		},
+		{
+			name:    "parent directory",
+			entries: []tarEntry{{name: "../escape", typeflag: tar.TypeReg, body: "x"}},
+			wantErr: "outside the build context",
+		},
+		{
+			name:    "absolute path",
+			entries: []tarEntry{{name: "/etc/cron.d/x", typeflag: tar.TypeReg, body: "x"}},
+			wantErr: "outside the build context",
+		},
+		{
+			// Refused on the way, though it would end up back inside
+			name:    "climbing back in",
+			entries: []tarEntry{{name: "src/../../ctx/x", typeflag: tar.TypeReg, body: "x"}},
+			wantErr: "outside the build context",
+		},
+		{
+			name:    "symlink",
+			entries: []tarEntry{{name: "link", typeflag: tar.TypeSymlink}},
+			wantErr: "is a link",
+		},
+		{
+			name:    "hard link",
+			entries: []tarEntry{{name: "link", typeflag: tar.TypeLink}},
+			wantErr: "is a link",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "ctx")
+			err := extractTarGz(bytes.NewReader(tarGz(t, tt.entries...)), dest)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+				if rel, _ := filepath.Rel(dest, path); rel != "." {
+					got = append(got, filepath.ToSlash(rel))
+				}
+				return nil
+			})
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("extracted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// FuzzExtractTarGzStaysInContext checks that no entry name writes outside
+// the directory an upload is unpacked into.
+func FuzzExtractTarGzStaysInContext(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"Dockerfile", "src/main.go"},
+		{"../x", "a/../../x"},
+		{"/abs", "./../x"},
+		{"a/", "a/../../ctx-sibling/x"},
+		{"..\\x", "a\\..\\..\\x"},
+		{"", "."},
+	} {
+		f.Add(seed[0], seed[1], true)
+	}
+	f.Fuzz(func(t *testing.T, first, second string, firstIsDir bool) {
+		typeflag := byte(tar.TypeReg)
+		if firstIsDir {
+			typeflag = tar.TypeDir
+		}
+		archive := tarGz(t, tarEntry{name: first, typeflag: typeflag}, tarEntry{name: second, typeflag: tar.TypeReg, body: "x"})
+
+		parent := t.TempDir()
+		dest := filepath.Join(parent, "ctx")
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			t.Fatal(err)
+		}
+		extractTarGz(bytes.NewReader(archive), dest)
+
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "ctx" {
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			t.Fatalf("entries %q and %q wrote %v next to the context", first, second, names)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nas-controller/internal/models"
+)
+
+// Webhook providers, by the header that authenticates their deliveries.
+const (
+	// WebhookGitHub signs the body with the secret in X-Hub-Signature-256.
+	WebhookGitHub = "github"
+	// WebhookGitLab sends the secret itself in X-Gitlab-Token.
+	WebhookGitLab = "gitlab"
+)
+
+// ErrWebhookUnauthorized is returned for a delivery to an app without a
+// webhook, or whose signature or token doesn't match; the cases are not
+// told apart.
+var ErrWebhookUnauthorized = errors.New("invalid webhook signature")
+
+// WebhookInfo is whether the app has a webhook. The secret is only shown
+// when it is generated.
+type WebhookInfo struct {
+	Enabled   bool       `json:"enabled"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+// WebhookPush is what a push delivery says was pushed.
+type WebhookPush struct {
+	// Branch is the branch pushed to, "" for a tag.
+	Branch string
+	// Commit is the branch's new head, "" when the branch was deleted.
+	Commit string
+}
+
+// Webhook says whether the app has a webhook.
+func (m *AppManager) Webhook(appID string) (*WebhookInfo, error) {
+	if _, err := m.db.GetApp(appID); err != nil {
+		return nil, fmt.Errorf("app not found: %v", err)
+	}
+	secret, createdAt, err := m.db.GetWebhook(appID)
+	if err != nil || secret == "" {
+		return &WebhookInfo{}, err
+	}
+	return &WebhookInfo{Enabled: true, CreatedAt: &createdAt}, nil
+}
+
+// EnableWebhook gives the app a webhook under a new secret, replacing the
+// previous one if there was one, and returns it. Only git apps have a
+// branch to be pushed to.
+func (m *AppManager) EnableWebhook(appID string) (string, error) {
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return "", fmt.Errorf("app not found: %v", err)
+	}
+	if app.SourceType == models.SourceTypeUpload || IsLocalPath(app.RepoURL) {
+		return "", fmt.Errorf("only apps cloned from a git remote can have a webhook")
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %v", err)
+	}
+	secret := hex.EncodeToString(raw)
+	if err := m.db.SetWebhookSecret(appID, secret); err != nil {
+		return "", fmt.Errorf("failed to save webhook secret: %v", err)
+	}
+	return secret, nil
+}
+
+// DisableWebhook removes the app's webhook; deliveries are refused from
+// then on.
+func (m *AppManager) DisableWebhook(appID string) error {
+	return m.db.DeleteWebhook(appID)
+}
+
+// VerifyWebhook checks a delivery's credential against the app's webhook
+// secret: for GitHub the X-Hub-Signature-256 header (sha256=<hex HMAC of
+// body>), for GitLab the X-Gitlab-Token header. A verified delivery is
+// recorded as the app's latest webhook contact; refused ones aren't, as
+// anyone can send them.
+func (m *AppManager) VerifyWebhook(appID string, provider string, credential string, body []byte) (*models.App, error) {
+	secret, _, err := m.db.GetWebhook(appID)
+	if err != nil || secret == "" {
+		return nil, ErrWebhookUnauthorized
+	}
+	var expected string
+	switch provider {
+	case WebhookGitHub:
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	case WebhookGitLab:
+		expected = secret
+	default:
+		return nil, ErrWebhookUnauthorized
+	}
+	if subtle.ConstantTimeCompare([]byte(credential), []byte(expected)) != 1 {
+		return nil, ErrWebhookUnauthorized
+	}
+	app, err := m.db.GetApp(appID)
+	if err != nil {
+		return nil, ErrWebhookUnauthorized
+	}
+	m.recordContact(app.ID, models.ContactWebhook, nil)
+	return app, nil
+}
+
+// ParseWebhookPush reads the branch and new head from a push delivery's
+// body. GitHub and GitLab both send them as ref and after.
+func ParseWebhookPush(body []byte) (*WebhookPush, error) {
+	var payload struct {
+		Ref   string `json:"ref"`
+		After string `json:"after"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %v", err)
+	}
+	push := &WebhookPush{Commit: payload.After}
+	if branch, ok := strings.CutPrefix(payload.Ref, "refs/heads/"); ok {
+		push.Branch = branch
+	}
+	// A deleted branch's new head is all zeros
+	if strings.Trim(push.Commit, "0") == "" {
+		push.Commit = ""
+	}
+	return push, nil
+}